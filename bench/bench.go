@@ -0,0 +1,409 @@
+// Package bench simulates many CalDAV clients syncing one calendar at once,
+// an initial sync followed by steady-state polling, and reports request
+// latency percentiles per operation. It is the library behind
+// cmd/caldora-bench.
+package bench
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/httpclient"
+)
+
+const (
+	nsDAV      = "DAV:"
+	nsCalDAV   = "urn:ietf:params:xml:ns:caldav"
+	nsCalendar = "http://calendarserver.org/ns/"
+)
+
+// Operations measured by a run, in the order a client performs them.
+const (
+	// OpCollection is the Depth: 0 PROPFIND of the calendar's getctag and
+	// sync-token that starts the initial sync
+	OpCollection = "collection"
+	// OpList is the Depth: 1 PROPFIND listing the calendar's objects with
+	// their ETags
+	OpList = "list"
+	// OpMultiget is a calendar-multiget REPORT fetching a batch of objects
+	OpMultiget = "multiget"
+	// OpPoll is the Depth: 0 PROPFIND of getctag a client repeats to notice
+	// changes
+	OpPoll = "poll"
+)
+
+var operations = []string{OpCollection, OpList, OpMultiget, OpPoll}
+
+// Options configures a benchmark run.
+type Options struct {
+	// URL is the calendar collection every client syncs
+	URL string
+	// Username and Password are sent with Basic authentication when set
+	Username string
+	Password string
+	// Clients is the number of simulated clients running concurrently; zero
+	// means 10
+	Clients int
+	// Polls is how many times each client polls after its initial sync
+	Polls int
+	// Interval is the pause between a client's polls; zero polls back to back
+	Interval time.Duration
+	// BatchSize caps the hrefs per calendar-multiget; zero means 50
+	BatchSize int
+	// Client is the HTTP client to use; nil uses a client with a 30 second timeout
+	Client *http.Client
+	// Logger receives progress at debug level; nil disables logging
+	Logger *slog.Logger
+}
+
+// Stats summarizes the requests of one operation.
+type Stats struct {
+	Operation string
+	// Count is the number of requests sent, Errors those that failed or got
+	// an unexpected status
+	Count  int
+	Errors int
+	// Latency percentiles over all requests, including failed ones
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Clients int
+	// Objects is the number of objects the first client found in the calendar
+	Objects int
+	// Elapsed is the wall time of the whole run
+	Elapsed time.Duration
+	// Operations has one entry per operation that was performed, in the
+	// order clients perform them
+	Operations []Stats
+}
+
+// Failed reports whether any request of the run failed.
+func (r *Report) Failed() bool {
+	for _, s := range r.Operations {
+		if s.Errors > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Run simulates opts.Clients clients against opts.URL and waits for all of
+// them to finish or ctx to end. Failed requests are counted in the report; an
+// error is only returned for unusable options.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	target, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar URL: %w", err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, errors.New("invalid calendar URL: scheme must be http or https")
+	}
+	if opts.Clients < 0 || opts.Polls < 0 || opts.BatchSize < 0 {
+		return nil, errors.New("clients, polls and batch size must not be negative")
+	}
+	clients := opts.Clients
+	if clients == 0 {
+		clients = 10
+	}
+	batchSize := opts.BatchSize
+	if batchSize == 0 {
+		batchSize = 50
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if opts.Username != "" {
+		client = &http.Client{
+			Timeout:       client.Timeout,
+			CheckRedirect: client.CheckRedirect,
+			Jar:           client.Jar,
+			Transport:     httpclient.NewBasicAuthTransport(opts.Username, opts.Password, client.Transport, logger),
+		}
+	}
+
+	rec := &recorder{latencies: make(map[string][]time.Duration), errors: make(map[string]int)}
+	objects := make([]int, clients)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := &session{
+				ctx:       ctx,
+				client:    client,
+				target:    target,
+				batchSize: batchSize,
+				rec:       rec,
+				logger:    logger.With("client", i),
+			}
+			objects[i] = s.run(opts.Polls, opts.Interval)
+		}()
+	}
+	wg.Wait()
+
+	report := &Report{Clients: clients, Objects: objects[0], Elapsed: time.Since(start)}
+	for _, op := range operations {
+		if samples := rec.latencies[op]; len(samples) > 0 {
+			report.Operations = append(report.Operations, summarize(op, samples, rec.errors[op]))
+		}
+	}
+	return report, nil
+}
+
+// Format renders a report as an aligned plain-text table, one line per
+// operation.
+func Format(r *Report) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d clients, %d objects, %s elapsed\n", r.Clients, r.Objects, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&sb, "%-10s  %7s  %6s  %9s  %9s  %9s  %9s\n", "operation", "count", "errors", "p50", "p90", "p99", "max")
+	for _, s := range r.Operations {
+		fmt.Fprintf(&sb, "%-10s  %7d  %6d  %9s  %9s  %9s  %9s\n", s.Operation, s.Count, s.Errors,
+			formatLatency(s.P50), formatLatency(s.P90), formatLatency(s.P99), formatLatency(s.Max))
+	}
+	return sb.String()
+}
+
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+}
+
+// summarize computes the statistics of one operation's samples using the
+// nearest-rank method.
+func summarize(op string, samples []time.Duration, errs int) Stats {
+	slices.Sort(samples)
+	rank := func(p float64) time.Duration {
+		i := int(math.Ceil(p/100*float64(len(samples)))) - 1
+		return samples[max(i, 0)]
+	}
+	return Stats{
+		Operation: op,
+		Count:     len(samples),
+		Errors:    errs,
+		P50:       rank(50),
+		P90:       rank(90),
+		P99:       rank(99),
+		Max:       samples[len(samples)-1],
+	}
+}
+
+// recorder collects latencies from all clients.
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func (r *recorder) record(op string, d time.Duration, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[op] = append(r.latencies[op], d)
+	if failed {
+		r.errors[op]++
+	}
+}
+
+// session is one simulated client.
+type session struct {
+	ctx       context.Context
+	client    *http.Client
+	target    *url.URL
+	batchSize int
+	rec       *recorder
+	logger    *slog.Logger
+
+	ctag  string
+	etags map[string]string // href to ETag of the objects the client holds
+}
+
+// run performs the initial sync and then polls, fetching what changed
+// whenever the CTag moves. It returns the number of objects synced.
+func (s *session) run(polls int, interval time.Duration) int {
+	resp, err := s.multistatus(OpCollection, "PROPFIND", "0",
+		propfindBody(`<D:sync-token/><CS:getctag/>`))
+	if err != nil {
+		s.logger.Warn("initial sync failed", "error", err)
+		return 0
+	}
+	if len(resp) > 0 {
+		s.ctag = resp[0].text(nsCalendar, "getctag")
+	}
+	s.etags = make(map[string]string)
+	if err := s.fetchChanges(); err != nil {
+		s.logger.Warn("initial sync failed", "error", err)
+		return 0
+	}
+	s.logger.Debug("initial sync finished", "objects", len(s.etags), "ctag", s.ctag)
+
+	for i := range polls {
+		if i > 0 && interval > 0 {
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+		if s.ctx.Err() != nil {
+			break
+		}
+		resp, err := s.multistatus(OpPoll, "PROPFIND", "0", propfindBody(`<CS:getctag/>`))
+		if err != nil {
+			s.logger.Warn("poll failed", "error", err)
+			continue
+		}
+		if len(resp) == 0 {
+			continue
+		}
+		// Without a CTag every poll has to list the calendar
+		ctag := resp[0].text(nsCalendar, "getctag")
+		if ctag != "" && ctag == s.ctag {
+			continue
+		}
+		s.ctag = ctag
+		if err := s.fetchChanges(); err != nil {
+			s.logger.Warn("fetching changes failed", "error", err)
+		}
+	}
+	return len(s.etags)
+}
+
+// fetchChanges lists the calendar and multigets the objects whose ETag
+// differs from the one held.
+func (s *session) fetchChanges() error {
+	resp, err := s.multistatus(OpList, "PROPFIND", "1", propfindBody(`<D:getetag/>`))
+	if err != nil {
+		return err
+	}
+	current := make(map[string]string, len(resp))
+	var changed []string
+	for _, r := range resp {
+		etag := r.text(nsDAV, "getetag")
+		if etag == "" || strings.TrimSuffix(r.href, "/") == strings.TrimSuffix(s.target.EscapedPath(), "/") {
+			continue
+		}
+		current[r.href] = etag
+		if s.etags[r.href] != etag {
+			changed = append(changed, r.href)
+		}
+	}
+	for batch := range slices.Chunk(changed, s.batchSize) {
+		if _, err := s.multistatus(OpMultiget, "REPORT", "1", multigetBody(batch)); err != nil {
+			return err
+		}
+	}
+	s.etags = current
+	return nil
+}
+
+// davResponse is one DAV:response of a multistatus, with the properties
+// reported with a 2xx status.
+type davResponse struct {
+	href  string
+	props map[string]string // text keyed by namespace + " " + local name
+}
+
+func (r davResponse) text(space, name string) string {
+	return r.props[space+" "+name]
+}
+
+// multistatus sends a request to the calendar, records its latency under op
+// and parses the 207 response.
+func (s *session) multistatus(op, method, depth, body string) ([]davResponse, error) {
+	req, err := http.NewRequestWithContext(s.ctx, method, s.target.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", depth)
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil && resp.StatusCode != http.StatusMultiStatus {
+		err = fmt.Errorf("%s returned %s", method, resp.Status)
+	}
+	s.rec.record(op, time.Since(start), err != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("invalid multistatus: %w", err)
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "multistatus" || root.NamespaceURI() != nsDAV {
+		return nil, errors.New("response is not a DAV:multistatus")
+	}
+	var responses []davResponse
+	for _, r := range root.ChildElements() {
+		if r.Tag != "response" || r.NamespaceURI() != nsDAV {
+			continue
+		}
+		dr := davResponse{props: make(map[string]string)}
+		if href := r.FindElement("./href"); href != nil {
+			dr.href = strings.TrimSpace(href.Text())
+		}
+		for _, ps := range r.ChildElements() {
+			if ps.Tag != "propstat" {
+				continue
+			}
+			status := ps.FindElement("./status")
+			if status == nil || !strings.Contains(status.Text(), " 2") {
+				continue
+			}
+			if prop := ps.FindElement("./prop"); prop != nil {
+				for _, p := range prop.ChildElements() {
+					dr.props[p.NamespaceURI()+" "+p.Tag] = strings.TrimSpace(p.Text())
+				}
+			}
+		}
+		responses = append(responses, dr)
+	}
+	return responses, nil
+}
+
+func propfindBody(props string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>` +
+		`<D:propfind xmlns:D="DAV:" xmlns:CS="` + nsCalendar + `"><D:prop>` + props + `</D:prop></D:propfind>`
+}
+
+func multigetBody(hrefs []string) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	sb.WriteString(`<C:calendar-multiget xmlns:D="DAV:" xmlns:C="` + nsCalDAV + `">`)
+	sb.WriteString(`<D:prop><D:getetag/><C:calendar-data/></D:prop>`)
+	for _, href := range hrefs {
+		sb.WriteString(`<D:href>`)
+		_ = xml.EscapeText(&sb, []byte(href))
+		sb.WriteString(`</D:href>`)
+	}
+	sb.WriteString(`</C:calendar-multiget>`)
+	return sb.String()
+}
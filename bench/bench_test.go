@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAgainstCaldavHandler(t *testing.T) {
+	var objects []storage.CalendarObject
+	var paths []string
+	mockStorage := new(storage.MockStorage)
+	for _, id := range []string{"a", "b", "c"} {
+		start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+		object := storage.NewMockEvent("/alice/cal/work/"+id+".ics", id+"@example.com", "Event "+id, start, start.Add(time.Hour))
+		object.ETag = `"` + id + `1"`
+		objects = append(objects, object)
+		paths = append(paths, object.Path)
+		mockStorage.On("GetObject", "alice", "work", id+".ics").Return(&objects[len(objects)-1], nil).Maybe()
+	}
+	calendar := &storage.Calendar{
+		Path:                "/alice/cal/work",
+		CTag:                `"7"`,
+		SupportedComponents: []string{ical.CompEvent},
+		CalendarData:        ical.NewCalendar(),
+	}
+	mockStorage.On("AuthUser", "alice", "secret").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(calendar, nil).Maybe()
+	mockStorage.On("GetObjectPathsInCollection", "work").Return(paths, nil).Maybe()
+	mockStorage.On("GetObjectsInCollection", "work").Return(objects, nil).Maybe()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := server.NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, logger)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	report, err := Run(context.Background(), Options{
+		URL:       srv.URL + "/caldav/alice/cal/work/",
+		Username:  "alice",
+		Password:  "secret",
+		Clients:   4,
+		Polls:     3,
+		BatchSize: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Clients)
+	assert.Equal(t, 3, report.Objects)
+	assert.False(t, report.Failed())
+
+	counts := make(map[string]int)
+	for _, s := range report.Operations {
+		counts[s.Operation] = s.Count
+		assert.LessOrEqual(t, s.P50, s.P90, s.Operation)
+		assert.LessOrEqual(t, s.P90, s.P99, s.Operation)
+		assert.LessOrEqual(t, s.P99, s.Max, s.Operation)
+	}
+	// The CTag never moves, so polls don't list the calendar again, and three
+	// objects take two multigets of at most two
+	assert.Equal(t, map[string]int{OpCollection: 4, OpList: 4, OpMultiget: 8, OpPoll: 12}, counts)
+
+	out := Format(report)
+	assert.Contains(t, out, "4 clients, 3 objects")
+	assert.Contains(t, out, "multiget")
+}
+
+func TestRunCountsFailures(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "wrong").Return("", storage.ErrNotFound)
+
+	handler := server.NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	report, err := Run(context.Background(), Options{
+		URL:      srv.URL + "/caldav/alice/cal/work/",
+		Username: "alice",
+		Password: "wrong",
+		Clients:  2,
+		Polls:    5,
+	})
+	require.NoError(t, err)
+	assert.True(t, report.Failed())
+	require.Len(t, report.Operations, 1)
+	assert.Equal(t, OpCollection, report.Operations[0].Operation)
+	assert.Equal(t, 2, report.Operations[0].Errors)
+}
+
+func TestRunRejectsBadOptions(t *testing.T) {
+	_, err := Run(context.Background(), Options{URL: "ftp://example.com/cal/"})
+	assert.Error(t, err)
+	_, err = Run(context.Background(), Options{URL: "http://example.com/cal/", Clients: -1})
+	assert.Error(t, err)
+}
+
+func TestSummarize(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	s := summarize(OpPoll, samples, 1)
+	assert.Equal(t, Stats{
+		Operation: OpPoll,
+		Count:     100,
+		Errors:    1,
+		P50:       50 * time.Millisecond,
+		P90:       90 * time.Millisecond,
+		P99:       99 * time.Millisecond,
+		Max:       100 * time.Millisecond,
+	}, s)
+
+	one := summarize(OpList, []time.Duration{time.Second}, 0)
+	assert.Equal(t, time.Second, one.P50)
+	assert.Equal(t, time.Second, one.P99)
+}
+
+func TestMultigetBodyEscapesHrefs(t *testing.T) {
+	body := multigetBody([]string{"/cal/a&b.ics"})
+	assert.Contains(t, body, "<D:href>/cal/a&amp;b.ics</D:href>")
+}
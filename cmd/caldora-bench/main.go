@@ -0,0 +1,72 @@
+// Command caldora-bench simulates many CalDAV clients syncing a calendar and
+// reports request latency percentiles per operation.
+//
+// Usage:
+//
+//	caldora-bench -url https://cal.example.com/caldav/alice/cal/work/ -user alice [-clients 50] [-polls 20] [-interval 1s] [-v]
+//
+// Each client does an initial sync (PROPFIND, then calendar-multiget of every
+// object) and then polls the calendar's CTag. The password is read from the
+// CALDORA_PASSWORD environment variable so it doesn't end up in shell
+// history. The exit status is 1 if any request failed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/cyp0633/libcaldora/bench"
+)
+
+func main() {
+	calendarURL := flag.String("url", "", "calendar collection URL to sync (required)")
+	user := flag.String("user", "", "username for Basic authentication")
+	clients := flag.Int("clients", 10, "number of concurrent clients")
+	polls := flag.Int("polls", 10, "polls per client after the initial sync")
+	interval := flag.Duration("interval", time.Second, "pause between a client's polls")
+	batch := flag.Int("batch", 50, "hrefs per calendar-multiget")
+	timeout := flag.Duration("timeout", 10*time.Minute, "overall time limit")
+	verbose := flag.Bool("v", false, "log requests at debug level")
+	flag.Parse()
+
+	if *calendarURL == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, *timeout)
+	defer cancelTimeout()
+
+	report, err := bench.Run(ctx, bench.Options{
+		URL:       *calendarURL,
+		Username:  *user,
+		Password:  os.Getenv("CALDORA_PASSWORD"),
+		Clients:   *clients,
+		Polls:     *polls,
+		Interval:  *interval,
+		BatchSize: *batch,
+		Logger:    logger,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "caldora-bench:", err)
+		os.Exit(2)
+	}
+
+	fmt.Print(bench.Format(report))
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,63 @@
+// Command caldora-check runs CalDAV conformance checks against a server.
+//
+// Usage:
+//
+//	caldora-check -url https://cal.example.com/caldav/ -user alice [-write] [-v]
+//
+// The password is read from the CALDORA_PASSWORD environment variable so it
+// doesn't end up in shell history. The exit status is 1 if any check fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/cyp0633/libcaldora/conformance"
+)
+
+func main() {
+	serverURL := flag.String("url", "", "server URL to start discovery from (required)")
+	user := flag.String("user", "", "username for Basic authentication")
+	write := flag.Bool("write", false, "create and delete a scratch event in the first calendar")
+	timeout := flag.Duration("timeout", 2*time.Minute, "overall time limit")
+	verbose := flag.Bool("v", false, "log requests at debug level")
+	flag.Parse()
+
+	if *serverURL == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, *timeout)
+	defer cancelTimeout()
+
+	results, err := conformance.Run(ctx, conformance.Options{
+		URL:      *serverURL,
+		Username: *user,
+		Password: os.Getenv("CALDORA_PASSWORD"),
+		Write:    *write,
+		Logger:   logger,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "caldora-check:", err)
+		os.Exit(2)
+	}
+
+	fmt.Print(conformance.Format(results))
+	if conformance.Failed(results) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,583 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/google/uuid"
+)
+
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+)
+
+// checker carries the state discovered by earlier checks into later ones.
+type checker struct {
+	ctx    context.Context
+	client *http.Client
+	base   *url.URL
+	write  bool
+	logger *slog.Logger
+
+	principal  string // absolute URL of the current user's principal
+	homeSet    string // absolute URL of the calendar home set
+	calendar   string // absolute URL of the first calendar collection
+	objectURL  string // absolute URL of the scratch event created by write checks
+	objectETag string
+	objectUID  string
+}
+
+type check struct {
+	feature   string
+	reference string
+	run       func(c *checker) (Status, string)
+}
+
+// checks run in order; later ones rely on URLs discovered by earlier ones.
+var checks = []check{
+	{"OPTIONS advertises calendar-access", "RFC 4791 §5.1", checkOptions},
+	{"current-user-principal", "RFC 5397 §3", checkCurrentUserPrincipal},
+	{"calendar-home-set", "RFC 4791 §6.2.1", checkCalendarHomeSet},
+	{"calendar collections in home set", "RFC 4791 §4.2", checkCalendarCollections},
+	{"supported-calendar-component-set", "RFC 4791 §5.2.3", checkSupportedComponents},
+	{"calendar-query REPORT", "RFC 4791 §7.8", checkCalendarQuery},
+	{"sync-token property", "RFC 6578 §4", checkSyncTokenProperty},
+	{"sync-collection REPORT", "RFC 6578 §3.2", checkSyncCollection},
+	{"calendar-user-address-set", "RFC 6638 §2.4.1", checkCalendarUserAddressSet},
+	{"schedule-inbox-URL and schedule-outbox-URL", "RFC 6638 §2.2", checkScheduleCollections},
+	{"PUT creates an object with an ETag", "RFC 4791 §5.3.2", checkPutCreate},
+	{"If-None-Match: * prevents overwrite", "RFC 4791 §5.3.2", checkPutNoOverwrite},
+	{"GET returns the stored object", "RFC 4791 §5.3.4", checkGet},
+	{"calendar-query time-range finds the object", "RFC 4791 §9.9", checkTimeRangeQuery},
+	{"calendar-multiget REPORT", "RFC 4791 §7.9", checkMultiget},
+	{"DELETE with stale If-Match is refused", "RFC 4918 §10.4", checkDeleteStale},
+	{"DELETE removes the object", "RFC 4918 §9.6", checkDelete},
+}
+
+// do sends a request to target, which may be relative to the server URL.
+func (c *checker) do(method, target string, header map[string]string, body string) (*http.Response, []byte, error) {
+	u, err := c.base.Parse(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, method, u.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != "" && header["Content-Type"] == "" {
+		req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+// davResponse is one DAV:response of a multistatus, with the properties
+// reported with a 2xx status.
+type davResponse struct {
+	href   string
+	status string
+	props  map[string]*etree.Element // keyed by namespace + " " + local name
+}
+
+func (r davResponse) prop(space, name string) *etree.Element {
+	return r.props[space+" "+name]
+}
+
+// multistatus sends a PROPFIND or REPORT and parses the 207 response.
+func (c *checker) multistatus(method, target, depth, body string) ([]davResponse, error) {
+	resp, data, err := c.do(method, target, map[string]string{"Depth": depth}, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("%s returned %s", method, resp.Status)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("invalid multistatus: %w", err)
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "multistatus" || root.NamespaceURI() != nsDAV {
+		return nil, fmt.Errorf("response is not a DAV:multistatus")
+	}
+
+	var responses []davResponse
+	for _, r := range root.ChildElements() {
+		if r.Tag != "response" || r.NamespaceURI() != nsDAV {
+			continue
+		}
+		dr := davResponse{props: make(map[string]*etree.Element)}
+		if href := r.FindElement("./href"); href != nil {
+			dr.href = strings.TrimSpace(href.Text())
+		}
+		if status := r.FindElement("./status"); status != nil {
+			dr.status = status.Text()
+		}
+		for _, ps := range r.ChildElements() {
+			if ps.Tag != "propstat" {
+				continue
+			}
+			status := ps.FindElement("./status")
+			if status == nil || !strings.Contains(status.Text(), " 2") {
+				continue
+			}
+			if prop := ps.FindElement("./prop"); prop != nil {
+				for _, p := range prop.ChildElements() {
+					dr.props[p.NamespaceURI()+" "+p.Tag] = p
+				}
+			}
+		}
+		responses = append(responses, dr)
+	}
+	return responses, nil
+}
+
+// propfind requests the given properties ("prefix:name" with D or C) on
+// target with Depth 0 and returns the single response.
+func (c *checker) propfind(target string, props ...string) (davResponse, error) {
+	responses, err := c.multistatus("PROPFIND", target, "0", propfindBody(props...))
+	if err != nil {
+		return davResponse{}, err
+	}
+	if len(responses) == 0 {
+		return davResponse{}, fmt.Errorf("empty multistatus")
+	}
+	return responses[0], nil
+}
+
+func propfindBody(props ...string) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?>` +
+		`<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop>`)
+	for _, p := range props {
+		sb.WriteString("<" + p + "/>")
+	}
+	sb.WriteString("</D:prop></D:propfind>")
+	return sb.String()
+}
+
+// hrefIn returns the first DAV:href inside el, resolved against the server URL.
+func (c *checker) hrefIn(el *etree.Element) string {
+	if el == nil {
+		return ""
+	}
+	href := el.FindElement(".//href")
+	if href == nil {
+		return ""
+	}
+	u, err := c.base.Parse(strings.TrimSpace(href.Text()))
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+func checkOptions(c *checker) (Status, string) {
+	resp, _, err := c.do(http.MethodOptions, c.base.String(), nil, "")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	for _, value := range resp.Header.Values("DAV") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.TrimSpace(token) == "calendar-access" {
+				return Pass, ""
+			}
+		}
+	}
+	return Fail, fmt.Sprintf("DAV header is %q", strings.Join(resp.Header.Values("DAV"), ", "))
+}
+
+func checkCurrentUserPrincipal(c *checker) (Status, string) {
+	r, err := c.propfind(c.base.String(), "D:current-user-principal")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	c.principal = c.hrefIn(r.prop(nsDAV, "current-user-principal"))
+	if c.principal == "" {
+		return Fail, "property missing"
+	}
+	return Pass, c.principal
+}
+
+func checkCalendarHomeSet(c *checker) (Status, string) {
+	if c.principal == "" {
+		return Skip, "no principal"
+	}
+	r, err := c.propfind(c.principal, "C:calendar-home-set")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	c.homeSet = c.hrefIn(r.prop(nsCalDAV, "calendar-home-set"))
+	if c.homeSet == "" {
+		return Fail, "property missing"
+	}
+	return Pass, c.homeSet
+}
+
+func checkCalendarCollections(c *checker) (Status, string) {
+	if c.homeSet == "" {
+		return Skip, "no calendar home set"
+	}
+	responses, err := c.multistatus("PROPFIND", c.homeSet, "1", propfindBody("D:resourcetype"))
+	if err != nil {
+		return Fail, err.Error()
+	}
+	count := 0
+	for _, r := range responses {
+		rt := r.prop(nsDAV, "resourcetype")
+		if rt == nil {
+			continue
+		}
+		for _, kind := range rt.ChildElements() {
+			if kind.Tag == "calendar" && kind.NamespaceURI() == nsCalDAV {
+				count++
+				if c.calendar == "" {
+					if u, err := c.base.Parse(r.href); err == nil {
+						c.calendar = u.String()
+					}
+				}
+			}
+		}
+	}
+	if count == 0 {
+		return Fail, "no collection with resourcetype C:calendar"
+	}
+	return Pass, fmt.Sprintf("%d calendar(s), using %s", count, c.calendar)
+}
+
+func checkSupportedComponents(c *checker) (Status, string) {
+	if c.calendar == "" {
+		return Skip, "no calendar"
+	}
+	r, err := c.propfind(c.calendar, "C:supported-calendar-component-set")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	set := r.prop(nsCalDAV, "supported-calendar-component-set")
+	if set == nil {
+		return Fail, "property missing"
+	}
+	var names []string
+	for _, comp := range set.ChildElements() {
+		names = append(names, comp.SelectAttrValue("name", ""))
+	}
+	if len(names) == 0 {
+		return Fail, "empty component set"
+	}
+	return Pass, strings.Join(names, ", ")
+}
+
+func calendarQueryBody(start, end time.Time) string {
+	timeRange := ""
+	if !start.IsZero() {
+		timeRange = fmt.Sprintf(`<C:time-range start="%s" end="%s"/>`,
+			start.UTC().Format("20060102T150405Z"), end.UTC().Format("20060102T150405Z"))
+	}
+	return `<?xml version="1.0" encoding="utf-8"?>` +
+		`<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` +
+		`<D:prop><D:getetag/></D:prop>` +
+		`<C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">` + timeRange +
+		`</C:comp-filter></C:comp-filter></C:filter></C:calendar-query>`
+}
+
+func checkCalendarQuery(c *checker) (Status, string) {
+	if c.calendar == "" {
+		return Skip, "no calendar"
+	}
+	responses, err := c.multistatus("REPORT", c.calendar, "1", calendarQueryBody(time.Time{}, time.Time{}))
+	if err != nil {
+		return Fail, err.Error()
+	}
+	return Pass, fmt.Sprintf("%d event(s)", len(responses))
+}
+
+func checkSyncTokenProperty(c *checker) (Status, string) {
+	if c.calendar == "" {
+		return Skip, "no calendar"
+	}
+	r, err := c.propfind(c.calendar, "D:sync-token")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	token := r.prop(nsDAV, "sync-token")
+	if token == nil || strings.TrimSpace(token.Text()) == "" {
+		return Fail, "property missing"
+	}
+	return Pass, ""
+}
+
+func checkSyncCollection(c *checker) (Status, string) {
+	if c.calendar == "" {
+		return Skip, "no calendar"
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<D:sync-collection xmlns:D="DAV:"><D:sync-token/><D:sync-level>1</D:sync-level>` +
+		`<D:prop><D:getetag/></D:prop></D:sync-collection>`
+	resp, data, err := c.do("REPORT", c.calendar, nil, body)
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return Fail, "REPORT returned " + resp.Status
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil || doc.Root() == nil {
+		return Fail, "invalid multistatus"
+	}
+	if token := doc.Root().FindElement("./sync-token"); token == nil || strings.TrimSpace(token.Text()) == "" {
+		return Fail, "multistatus lacks a DAV:sync-token"
+	}
+	return Pass, ""
+}
+
+func checkCalendarUserAddressSet(c *checker) (Status, string) {
+	if c.principal == "" {
+		return Skip, "no principal"
+	}
+	r, err := c.propfind(c.principal, "C:calendar-user-address-set")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	set := r.prop(nsCalDAV, "calendar-user-address-set")
+	if set == nil || set.FindElement(".//href") == nil {
+		return Fail, "property missing"
+	}
+	return Pass, ""
+}
+
+func checkScheduleCollections(c *checker) (Status, string) {
+	if c.principal == "" {
+		return Skip, "no principal"
+	}
+	r, err := c.propfind(c.principal, "C:schedule-inbox-URL", "C:schedule-outbox-URL")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	var missing []string
+	for _, name := range []string{"schedule-inbox-URL", "schedule-outbox-URL"} {
+		if c.hrefIn(r.prop(nsCalDAV, name)) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return Fail, "missing " + strings.Join(missing, " and ")
+	}
+	return Pass, ""
+}
+
+// scratchEvent is the event created by write checks, one hour starting at start.
+func scratchEvent(uid string, start time.Time) string {
+	format := func(t time.Time) string { return t.UTC().Format("20060102T150405Z") }
+	return strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//libcaldora//caldora-check//EN",
+		"BEGIN:VEVENT",
+		"UID:" + uid,
+		"DTSTAMP:" + format(time.Now()),
+		"DTSTART:" + format(start),
+		"DTEND:" + format(start.Add(time.Hour)),
+		"SUMMARY:caldora-check scratch event",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+}
+
+// scratchStart is when the scratch event starts; far enough in the past to
+// never collide with real data in a time-range query.
+var scratchStart = time.Date(2001, 2, 3, 4, 0, 0, 0, time.UTC)
+
+func (c *checker) writeSkip() (Status, string, bool) {
+	if !c.write {
+		return Skip, "write checks disabled", true
+	}
+	if c.calendar == "" {
+		return Skip, "no calendar", true
+	}
+	return Pass, "", false
+}
+
+func (c *checker) needObject() (Status, string, bool) {
+	if status, detail, skip := c.writeSkip(); skip {
+		return status, detail, true
+	}
+	if c.objectURL == "" {
+		return Skip, "scratch event was not created", true
+	}
+	return Pass, "", false
+}
+
+func checkPutCreate(c *checker) (Status, string) {
+	if status, detail, skip := c.writeSkip(); skip {
+		return status, detail
+	}
+	uid := "caldora-check-" + uuid.NewString()
+	target := strings.TrimSuffix(c.calendar, "/") + "/" + uid + ".ics"
+	resp, _, err := c.do(http.MethodPut, target, map[string]string{
+		"Content-Type":  "text/calendar; charset=utf-8",
+		"If-None-Match": "*",
+	}, scratchEvent(uid, scratchStart))
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return Fail, "PUT returned " + resp.Status
+	}
+	c.objectURL, c.objectUID = target, uid
+	c.objectETag = resp.Header.Get("ETag")
+	if c.objectETag == "" {
+		return Fail, "no ETag in the response"
+	}
+	return Pass, ""
+}
+
+func checkPutNoOverwrite(c *checker) (Status, string) {
+	if status, detail, skip := c.needObject(); skip {
+		return status, detail
+	}
+	resp, _, err := c.do(http.MethodPut, c.objectURL, map[string]string{
+		"Content-Type":  "text/calendar; charset=utf-8",
+		"If-None-Match": "*",
+	}, scratchEvent(c.objectUID, scratchStart))
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		return Fail, fmt.Sprintf("PUT returned %s, want 412", resp.Status)
+	}
+	return Pass, ""
+}
+
+func checkGet(c *checker) (Status, string) {
+	if status, detail, skip := c.needObject(); skip {
+		return status, detail
+	}
+	resp, data, err := c.do(http.MethodGet, c.objectURL, nil, "")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Fail, "GET returned " + resp.Status
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		return Fail, fmt.Sprintf("Content-Type is %q", ct)
+	}
+	if !strings.Contains(string(data), "UID:"+c.objectUID) {
+		return Fail, "body does not contain the event UID"
+	}
+	return Pass, ""
+}
+
+// reportHrefs returns the hrefs of a REPORT response, resolved against the server URL.
+func (c *checker) reportHrefs(target, body string) ([]davResponse, []string, error) {
+	responses, err := c.multistatus("REPORT", target, "1", body)
+	if err != nil {
+		return nil, nil, err
+	}
+	hrefs := make([]string, 0, len(responses))
+	for _, r := range responses {
+		if u, err := c.base.Parse(r.href); err == nil {
+			hrefs = append(hrefs, u.String())
+		}
+	}
+	return responses, hrefs, nil
+}
+
+func checkTimeRangeQuery(c *checker) (Status, string) {
+	if status, detail, skip := c.needObject(); skip {
+		return status, detail
+	}
+	_, hrefs, err := c.reportHrefs(c.calendar, calendarQueryBody(scratchStart.Add(-time.Hour), scratchStart.Add(2*time.Hour)))
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if !slices.Contains(hrefs, c.objectURL) {
+		return Fail, "scratch event not in the result"
+	}
+	_, hrefs, err = c.reportHrefs(c.calendar, calendarQueryBody(scratchStart.Add(24*time.Hour), scratchStart.Add(48*time.Hour)))
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if slices.Contains(hrefs, c.objectURL) {
+		return Fail, "scratch event matched a range it does not overlap"
+	}
+	return Pass, ""
+}
+
+func checkMultiget(c *checker) (Status, string) {
+	if status, detail, skip := c.needObject(); skip {
+		return status, detail
+	}
+	u, err := url.Parse(c.objectURL)
+	if err != nil {
+		return Fail, err.Error()
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` +
+		`<D:prop><D:getetag/><C:calendar-data/></D:prop>` +
+		`<D:href>` + u.EscapedPath() + `</D:href></C:calendar-multiget>`
+	responses, _, err := c.reportHrefs(c.calendar, body)
+	if err != nil {
+		return Fail, err.Error()
+	}
+	for _, r := range responses {
+		if data := r.prop(nsCalDAV, "calendar-data"); data != nil && strings.Contains(data.Text(), "UID:"+c.objectUID) {
+			return Pass, ""
+		}
+	}
+	return Fail, "no calendar-data for the scratch event"
+}
+
+func checkDeleteStale(c *checker) (Status, string) {
+	if status, detail, skip := c.needObject(); skip {
+		return status, detail
+	}
+	resp, _, err := c.do(http.MethodDelete, c.objectURL, map[string]string{"If-Match": `"caldora-check-stale"`}, "")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		return Fail, fmt.Sprintf("DELETE returned %s, want 412", resp.Status)
+	}
+	return Pass, ""
+}
+
+func checkDelete(c *checker) (Status, string) {
+	if status, detail, skip := c.needObject(); skip {
+		return status, detail
+	}
+	resp, _, err := c.do(http.MethodDelete, c.objectURL, nil, "")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return Fail, "DELETE returned " + resp.Status
+	}
+	resp, _, err = c.do(http.MethodGet, c.objectURL, nil, "")
+	if err != nil {
+		return Fail, err.Error()
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return Fail, fmt.Sprintf("GET after DELETE returned %s, want 404", resp.Status)
+	}
+	c.objectURL = ""
+	return Pass, ""
+}
@@ -0,0 +1,149 @@
+// Package conformance runs RFC 4791 (CalDAV), RFC 6578 (sync) and RFC 6638
+// (scheduling) checks against a running CalDAV server and reports the outcome
+// per feature. It is the library behind cmd/caldora-check.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cyp0633/libcaldora/internal/httpclient"
+)
+
+// Status is the outcome of a single check.
+type Status int
+
+const (
+	// Pass means the server behaved as the RFC requires.
+	Pass Status = iota
+	// Fail means the server violated the RFC or did not support the feature.
+	Fail
+	// Skip means the check could not run, e.g. because an earlier check it
+	// depends on failed or write checks were not enabled.
+	Skip
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Fail:
+		return "FAIL"
+	default:
+		return "SKIP"
+	}
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	// Feature is a short human-readable name of what was checked
+	Feature string
+	// Reference is the RFC section defining the feature, e.g. "RFC 4791 §5.1"
+	Reference string
+	Status    Status
+	// Detail explains a failure or skip; empty on success unless informative
+	Detail string
+}
+
+// Options configures a conformance run.
+type Options struct {
+	// URL is where discovery starts: the server root, a context path or a principal URL
+	URL string
+	// Username and Password are sent with Basic authentication when set
+	Username string
+	Password string
+	// Write enables checks that create, modify and delete a scratch event in
+	// the first calendar found. Leave it off against production data.
+	Write bool
+	// Client is the HTTP client to use; nil uses a client with a 30 second timeout
+	Client *http.Client
+	// Logger receives progress at debug level; nil disables logging
+	Logger *slog.Logger
+}
+
+// Run executes every check in order and returns their results. Checks never
+// abort the run; an error is only returned for unusable options.
+func Run(ctx context.Context, opts Options) ([]Result, error) {
+	base, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, errors.New("invalid server URL: scheme must be http or https")
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if opts.Username != "" {
+		client = &http.Client{
+			Timeout:       client.Timeout,
+			CheckRedirect: client.CheckRedirect,
+			Jar:           client.Jar,
+			Transport:     httpclient.NewBasicAuthTransport(opts.Username, opts.Password, client.Transport, logger),
+		}
+	}
+
+	c := &checker{
+		ctx:    ctx,
+		client: client,
+		base:   base,
+		write:  opts.Write,
+		logger: logger,
+	}
+	results := make([]Result, 0, len(checks))
+	for _, chk := range checks {
+		if err := ctx.Err(); err != nil {
+			results = append(results, Result{Feature: chk.feature, Reference: chk.reference, Status: Skip, Detail: err.Error()})
+			continue
+		}
+		status, detail := chk.run(c)
+		logger.Debug("conformance check finished",
+			"feature", chk.feature,
+			"status", status.String(),
+			"detail", detail)
+		results = append(results, Result{
+			Feature:   chk.feature,
+			Reference: chk.reference,
+			Status:    status,
+			Detail:    detail,
+		})
+	}
+	return results, nil
+}
+
+// Failed reports whether any result failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Format renders results as an aligned plain-text report, one line per check.
+func Format(results []Result) string {
+	width := 0
+	for _, r := range results {
+		width = max(width, len(r.Feature))
+	}
+	var sb strings.Builder
+	for _, r := range results {
+		line := fmt.Sprintf("%-4s  %-*s  %-15s  %s", r.Status, width, r.Feature, r.Reference, r.Detail)
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,93 @@
+package conformance
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func resultsByFeature(results []Result) map[string]Result {
+	m := make(map[string]Result, len(results))
+	for _, r := range results {
+		m[r.Feature] = r
+	}
+	return m
+}
+
+func TestRunAgainstCaldavHandler(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "secret").Return("alice", nil)
+	mockStorage.On("GetUser", "alice").Return(&storage.User{DisplayName: "Alice"}, nil).Maybe()
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{{
+		Path:                "/alice/cal/work",
+		SupportedComponents: []string{"VEVENT"},
+	}}, nil).Maybe()
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{
+		Path:                "/alice/cal/work",
+		SupportedComponents: []string{"VEVENT"},
+	}, nil).Maybe()
+	mockStorage.On("GetObjectPathsInCollection", "work").Return([]string{}, nil).Maybe()
+	mockStorage.On("GetObjectByFilter", "alice", "work", mock.Anything).Return([]storage.CalendarObject{}, nil).Maybe()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := server.NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, logger)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	results, err := Run(context.Background(), Options{
+		URL:      srv.URL + "/caldav/alice",
+		Username: "alice",
+		Password: "secret",
+	})
+	require.NoError(t, err)
+	require.Len(t, results, len(checks))
+	byFeature := resultsByFeature(results)
+
+	for _, feature := range []string{
+		"OPTIONS advertises calendar-access",
+		"current-user-principal",
+		"calendar-home-set",
+		"calendar collections in home set",
+		"supported-calendar-component-set",
+		"calendar-query REPORT",
+	} {
+		assert.Equal(t, Pass, byFeature[feature].Status, feature)
+	}
+	assert.Equal(t, Skip, byFeature["PUT creates an object with an ETag"].Status)
+	assert.Equal(t, "write checks disabled", byFeature["PUT creates an object with an ETag"].Detail)
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("DAV", "1, 2")
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	results, err := Run(context.Background(), Options{URL: srv.URL, Write: true})
+	require.NoError(t, err)
+	byFeature := resultsByFeature(results)
+
+	assert.Equal(t, Fail, byFeature["OPTIONS advertises calendar-access"].Status)
+	assert.Equal(t, Fail, byFeature["current-user-principal"].Status)
+	assert.Equal(t, "PROPFIND returned 403 Forbidden", byFeature["current-user-principal"].Detail)
+	// Everything after discovery depends on it
+	assert.Equal(t, Skip, byFeature["calendar-home-set"].Status)
+	assert.Equal(t, Skip, byFeature["PUT creates an object with an ETag"].Status)
+	assert.True(t, Failed(results))
+	assert.Contains(t, Format(results), "FAIL  current-user-principal")
+}
+
+func TestRunRejectsInvalidURL(t *testing.T) {
+	_, err := Run(context.Background(), Options{URL: "ftp://example.com"})
+	assert.Error(t, err)
+}
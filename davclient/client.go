@@ -35,6 +35,17 @@ type DAVClient interface {
 	DeleteCalendarObject(objectURL string, etag string) error
 }
 
+// ObjectWriter is implemented by clients that can write whole calendar
+// objects, with all their components. The client returned by NewDAVClient
+// implements it; assert for it on other DAVClient implementations.
+type ObjectWriter interface {
+	PutCalendarObject(objectURL string, cal *ical.Calendar, etag string) (newEtag string, err error)
+}
+
+// StatusError is wrapped by the errors of writes the server answered with an
+// unexpected HTTP status. Use errors.As to inspect StatusCode.
+type StatusError = httpclient.StatusError
+
 type davClient struct {
 	httpClient  httpclient.HttpClientWrapper
 	calendarURL string
@@ -3,8 +3,9 @@ package davclient
 import "github.com/cyp0633/libcaldora/internal/httpclient"
 
 type mockPutResponse struct {
-	etag string
-	err  error
+	etag    string
+	err     error
+	created bool // set by DoCreatePUT
 }
 
 // PropfindFunc is a function type for mocking PROPFIND
@@ -37,6 +38,13 @@ func (m *mockHTTPClient) DoPUT(url string, etag string, data []byte) (string, er
 	return "new-etag", nil
 }
 
+func (m *mockHTTPClient) DoCreatePUT(url string, data []byte) (string, error) {
+	if m.putResponse != nil {
+		m.putResponse.created = true
+	}
+	return m.DoPUT(url, "", data)
+}
+
 func (m *mockHTTPClient) DoDELETE(url string, etag string) error {
 	return m.deleteResponse
 }
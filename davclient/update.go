@@ -139,6 +139,25 @@ func (c *davClient) UpdateCalendarObject(objectURL string, event *ical.Event) (e
 	return etag, nil
 }
 
+// PutCalendarObject writes a whole calendar object, with all its components, to the specified URL.
+// A non-empty etag is sent as If-Match; an empty one sends If-None-Match: *, so the object is only
+// created if the URL is free. The returned etag is empty if the server didn't send one
+func (c *davClient) PutCalendarObject(objectURL string, cal *ical.Calendar, etag string) (newEtag string, err error) {
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return "", fmt.Errorf("failed to encode calendar object: %w", err)
+	}
+	if etag == "" {
+		newEtag, err = c.httpClient.DoCreatePUT(objectURL, buf.Bytes())
+	} else {
+		newEtag, err = c.httpClient.DoPUT(objectURL, etag, buf.Bytes())
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to put calendar object: %w", err)
+	}
+	return newEtag, nil
+}
+
 // DeleteCalendarObject deletes a calendar object at the specified URL with optimistic locking using etag
 func (c *davClient) DeleteCalendarObject(objectURL string, etag string) error {
 	if err := c.httpClient.DoDELETE(objectURL, etag); err != nil {
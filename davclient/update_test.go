@@ -218,3 +218,76 @@ func TestUpdateCalendarObject(t *testing.T) {
 		})
 	}
 }
+
+func TestPutCalendarObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		putResp     *mockPutResponse
+		wantEtag    string
+		wantErr     bool
+		expectedErr string
+	}{
+		{
+			name:     "successful put",
+			putResp:  &mockPutResponse{etag: "new-etag"},
+			wantEtag: "new-etag",
+		},
+		{
+			name:     "no etag in response",
+			putResp:  &mockPutResponse{etag: ""},
+			wantEtag: "",
+		},
+		{
+			name:        "put error",
+			putResp:     &mockPutResponse{err: fmt.Errorf("precondition failed")},
+			wantErr:     true,
+			expectedErr: "failed to put calendar object: precondition failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &davClient{
+				httpClient: &mockHTTPClient{putResponse: tt.putResp},
+			}
+
+			cal := ical.NewCalendar()
+			cal.Props.SetText("PRODID", "-//test//EN")
+			cal.Props.SetText("VERSION", "2.0")
+			cal.Children = append(cal.Children, createTestEvent().Component)
+
+			gotEtag, err := client.PutCalendarObject("/calendar/event.ics", cal, "old-etag")
+			if tt.putResp.created {
+				t.Errorf("PutCalendarObject() sent If-None-Match with an etag")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PutCalendarObject() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr && err != nil && err.Error() != tt.expectedErr {
+				t.Errorf("PutCalendarObject() error = %v, want %v", err, tt.expectedErr)
+			}
+
+			if gotEtag != tt.wantEtag {
+				t.Errorf("PutCalendarObject() etag = %v, want %v", gotEtag, tt.wantEtag)
+			}
+		})
+	}
+}
+
+func TestPutCalendarObjectCreates(t *testing.T) {
+	putResp := &mockPutResponse{etag: "new-etag"}
+	client := &davClient{httpClient: &mockHTTPClient{putResponse: putResp}}
+
+	cal := ical.NewCalendar()
+	cal.Props.SetText("PRODID", "-//test//EN")
+	cal.Props.SetText("VERSION", "2.0")
+	cal.Children = append(cal.Children, createTestEvent().Component)
+	if _, err := client.PutCalendarObject("/calendar/event.ics", cal, ""); err != nil {
+		t.Fatalf("PutCalendarObject() error = %v", err)
+	}
+	if !putResp.created {
+		t.Error("PutCalendarObject() without an etag should only create")
+	}
+}
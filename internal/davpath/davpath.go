@@ -0,0 +1,72 @@
+// Package davpath canonicalizes the URL paths clients send as request URIs
+// and hrefs, so that spellings of the same path compare equal.
+package davpath
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Clean returns p in canonical form: the path of an absolute URL, starting
+// with a slash and without a trailing one, with duplicate slashes collapsed
+// and "." and ".." segments resolved. Escapes are kept as they are, so an
+// escaped slash or dot stays part of its segment. The service root is "/".
+func Clean(p string) string {
+	if strings.Contains(p, "://") {
+		if u, err := url.Parse(p); err == nil {
+			p = u.EscapedPath()
+		}
+	}
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// Segments returns the segments of p after Clean, percent-decoded: IDs may
+// hold spaces, "+" or UTF-8 escaped in hrefs. Segments that don't decode are
+// returned as they are. The service root has none.
+func Segments(p string) []string {
+	p = Clean(p)
+	if p == "/" {
+		return nil
+	}
+	segments := strings.Split(p[1:], "/")
+	for i, segment := range segments {
+		if decoded, err := url.PathUnescape(segment); err == nil {
+			segments[i] = decoded
+		}
+	}
+	return segments
+}
+
+// TrimPrefix returns p below prefix after cleaning both, and whether p lies
+// under prefix at all. Only whole segments match, so "/caldav" is under
+// "/caldav/" but "/caldavx" isn't.
+func TrimPrefix(p, prefix string) (string, bool) {
+	p, prefix = Clean(p), Clean(prefix)
+	switch {
+	case prefix == "/":
+		return p, true
+	case p == prefix:
+		return "/", true
+	case strings.HasPrefix(p, prefix+"/"):
+		return p[len(prefix):], true
+	}
+	return p, false
+}
+
+// Same reports whether a and b are spellings of the same path.
+func Same(a, b string) bool {
+	return slices.Equal(Segments(a), Segments(b))
+}
@@ -0,0 +1,72 @@
+package davpath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClean(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                                    "/",
+		"/":                                   "/",
+		"//":                                  "/",
+		"alice":                               "/alice",
+		"/caldav/alice/":                      "/caldav/alice",
+		"/caldav//alice///cal/":               "/caldav/alice/cal",
+		"/caldav/alice/cal/work/./a.ics":      "/caldav/alice/cal/work/a.ics",
+		"/caldav/alice/cal/home/../work/":     "/caldav/alice/cal/work",
+		"/../../caldav":                       "/caldav",
+		"/caldav/alice/cal/work/a%2Fb.ics":    "/caldav/alice/cal/work/a%2Fb.ics",
+		"/caldav/alice/cal/work/%2E%2E":       "/caldav/alice/cal/work/%2E%2E",
+		"/caldav/alice/cal/work/a%20b.ics":    "/caldav/alice/cal/work/a%20b.ics",
+		"https://dav.example.com/caldav/a/":   "/caldav/a",
+		"https://dav.example.com":             "/",
+		"https://dav.example.com/caldav/a%2B": "/caldav/a%2B",
+	} {
+		assert.Equal(t, want, Clean(in), in)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	assert.Nil(t, Segments("/"))
+	assert.Nil(t, Segments("//./"))
+	assert.Equal(t, []string{"caldav", "alice", "cal", "work", "a b+c.ics"}, Segments("/caldav/alice//cal/work/a%20b%2Bc.ics/"))
+	assert.Equal(t, []string{"work", "a/b.ics"}, Segments("work/a%2Fb.ics"))
+	assert.Equal(t, []string{"work", ".."}, Segments("/work/%2E%2E"))
+	// Escapes that don't decode are kept
+	assert.Equal(t, []string{"100%.ics"}, Segments("/100%.ics"))
+	assert.Equal(t, []string{"événement.ics"}, Segments("/%C3%A9v%C3%A9nement.ics"))
+}
+
+func TestTrimPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		path, prefix, want string
+		ok                 bool
+	}{
+		{"/caldav/alice/cal", "/caldav/", "/alice/cal", true},
+		{"/caldav/alice/cal/", "/caldav", "/alice/cal", true},
+		{"//caldav//alice", "/caldav/", "/alice", true},
+		{"/caldav", "/caldav/", "/", true},
+		{"/caldav/", "/caldav/", "/", true},
+		{"/caldavx/alice", "/caldav/", "/caldavx/alice", false},
+		{"/alice/cal", "/caldav/", "/alice/cal", false},
+		{"/alice/cal", "/", "/alice/cal", true},
+		{"/alice/cal", "", "/alice/cal", true},
+		{"https://dav.example.com/dav/alice", "/dav/", "/alice", true},
+	} {
+		got, ok := TrimPrefix(tt.path, tt.prefix)
+		assert.Equal(t, tt.want, got, "%s under %s", tt.path, tt.prefix)
+		assert.Equal(t, tt.ok, ok, "%s under %s", tt.path, tt.prefix)
+	}
+}
+
+func TestSame(t *testing.T) {
+	assert.True(t, Same("/caldav/alice/cal/work/", "/caldav/alice/cal/work"))
+	assert.True(t, Same("/caldav/alice/cal/work/a%20b.ics", "/caldav//alice/cal/work/a b.ics"))
+	assert.True(t, Same("/caldav/alice/cal/work/a%2Bb.ics", "/caldav/alice/cal/work/a+b.ics"))
+	assert.True(t, Same("http://dav.example.com/caldav/alice/", "/caldav/alice"))
+	assert.True(t, Same("/caldav/alice/cal/home/../work", "/caldav/alice/cal/work"))
+	assert.False(t, Same("/caldav/alice/cal/Work", "/caldav/alice/cal/work"))
+	assert.False(t, Same("/caldav/alice/cal/work/a%2Fb.ics", "/caldav/alice/cal/work/a/b.ics"))
+}
@@ -12,9 +12,21 @@ type HttpClientWrapper interface {
 	DoPROPFIND(url string, depth int, props ...string) (*PropfindResponse, error)
 	DoREPORT(url string, depth int, query interface{}) (*ReportResponse, error)
 	DoPUT(url string, etag string, data []byte) (newEtag string, err error)
+	DoCreatePUT(url string, data []byte) (newEtag string, err error)
 	DoDELETE(url string, etag string) error
 }
 
+// StatusError is returned for responses with an unexpected HTTP status, so
+// callers can tell a refused request from a failing server.
+type StatusError struct {
+	Method     string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s request failed with status %d", e.Method, e.StatusCode)
+}
+
 type httpClientWrapper struct {
 	client  *http.Client
 	baseURL url.URL
@@ -41,7 +41,7 @@ func (c *httpClientWrapper) DoDELETE(urlStr string, etag string) error {
 		c.logger.Debug("unexpected status code",
 			"status_code", resp.StatusCode,
 			"status", resp.Status)
-		return fmt.Errorf("DELETE request failed with status %d", resp.StatusCode)
+		return &StatusError{Method: http.MethodDelete, StatusCode: resp.StatusCode}
 	}
 
 	c.logger.Debug("DELETE request complete", "status", resp.Status)
@@ -6,10 +6,28 @@ import (
 	"net/http"
 )
 
+// DoPUT sends a PUT request, with an If-Match header when etag is non-empty
 func (c *httpClientWrapper) DoPUT(urlStr string, etag string, data []byte) (newEtag string, err error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	return c.put(urlStr, header, data)
+}
+
+// DoCreatePUT sends a PUT request with If-None-Match: *, so it fails with 412
+// instead of overwriting an existing resource
+func (c *httpClientWrapper) DoCreatePUT(urlStr string, data []byte) (newEtag string, err error) {
+	header := http.Header{}
+	header.Set("If-None-Match", "*")
+	return c.put(urlStr, header, data)
+}
+
+func (c *httpClientWrapper) put(urlStr string, header http.Header, data []byte) (newEtag string, err error) {
 	c.logger.Debug("starting PUT request",
 		"url", urlStr,
-		"etag", etag,
+		"if_match", header.Get("If-Match"),
+		"if_none_match", header.Get("If-None-Match"),
 		"data_length", len(data))
 
 	resolvedURL, err := c.resolveURL(urlStr)
@@ -25,8 +43,8 @@ func (c *httpClientWrapper) DoPUT(urlStr string, etag string, data []byte) (newE
 		return "", err
 	}
 
-	if etag != "" {
-		req.Header.Set("If-Match", etag)
+	for name, values := range header {
+		req.Header[name] = values
 	}
 	req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
 
@@ -43,7 +61,7 @@ func (c *httpClientWrapper) DoPUT(urlStr string, etag string, data []byte) (newE
 		c.logger.Debug("unexpected status code",
 			"status_code", resp.StatusCode,
 			"status", resp.Status)
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", &StatusError{Method: http.MethodPut, StatusCode: resp.StatusCode}
 	}
 
 	newEtag = resp.Header.Get("ETag")
@@ -0,0 +1,64 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newTestClient(server *httptest.Server) *httpClientWrapper {
+	baseURL, _ := url.Parse(server.URL)
+	return &httpClientWrapper{
+		client:  server.Client(),
+		baseURL: *baseURL,
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestDoPUTConditions(t *testing.T) {
+	var ifMatch, ifNoneMatch string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifMatch, ifNoneMatch = r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", `"2"`)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	client := newTestClient(server)
+
+	etag, err := client.DoPUT("/calendar/event.ics", `"1"`, []byte("BEGIN:VCALENDAR"))
+	if err != nil || etag != `"2"` {
+		t.Fatalf("DoPUT() = %q, %v", etag, err)
+	}
+	if ifMatch != `"1"` || ifNoneMatch != "" {
+		t.Errorf("DoPUT() sent If-Match %q, If-None-Match %q", ifMatch, ifNoneMatch)
+	}
+
+	if _, err := client.DoCreatePUT("/calendar/event.ics", []byte("BEGIN:VCALENDAR")); err != nil {
+		t.Fatalf("DoCreatePUT() error = %v", err)
+	}
+	if ifMatch != "" || ifNoneMatch != "*" {
+		t.Errorf("DoCreatePUT() sent If-Match %q, If-None-Match %q", ifMatch, ifNoneMatch)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}))
+	defer server.Close()
+	client := newTestClient(server)
+
+	var statusErr *StatusError
+	_, err := client.DoPUT("/calendar/event.ics", `"1"`, nil)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusPreconditionFailed || statusErr.Method != http.MethodPut {
+		t.Errorf("DoPUT() error = %v, want a 412 StatusError", err)
+	}
+	err = client.DoDELETE("/calendar/event.ics", `"1"`)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusPreconditionFailed || statusErr.Method != http.MethodDelete {
+		t.Errorf("DoDELETE() error = %v, want a 412 StatusError", err)
+	}
+}
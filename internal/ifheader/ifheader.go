@@ -0,0 +1,211 @@
+// Package ifheader parses the WebDAV If request header (RFC 4918 section 10.4).
+package ifheader
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/internal/davpath"
+)
+
+// NoLock is the state token no resource ever has. Clients send
+// "(Not <DAV:no-lock>)" to make a list that is always true.
+const NoLock = "DAV:no-lock"
+
+// Condition is one state token or entity tag inside a list.
+type Condition struct {
+	Not   bool
+	Token string // state token (e.g. a lock token), without angle brackets
+	ETag  string // entity tag including quotes and W/ prefix; set when Token is empty
+}
+
+// List is a parenthesized list of conditions, all of which must hold.
+type List struct {
+	// Resource is the path of the tagged resource, or empty for untagged
+	// lists, which apply to the request URI
+	Resource   string
+	Conditions []Condition
+}
+
+// Header is a parsed If header.
+type Header struct {
+	Lists []List
+}
+
+var errSyntax = errors.New("malformed If header")
+
+// Parse parses an If header value. An empty value yields an empty Header.
+func Parse(value string) (Header, error) {
+	var h Header
+	p := parser{s: value}
+	tagged, resource := false, ""
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+		switch p.peek() {
+		case '<':
+			// A resource tag must be followed by at least one list
+			tag, err := p.codedURL()
+			if err != nil {
+				return Header{}, err
+			}
+			if len(h.Lists) > 0 && !tagged {
+				return Header{}, errSyntax
+			}
+			u, err := url.Parse(tag)
+			if err != nil || u.Path == "" {
+				return Header{}, errSyntax
+			}
+			tagged, resource = true, u.Path
+			p.skipSpace()
+			if p.done() || p.peek() != '(' {
+				return Header{}, errSyntax
+			}
+		case '(':
+			list, err := p.list()
+			if err != nil {
+				return Header{}, err
+			}
+			list.Resource = resource
+			h.Lists = append(h.Lists, list)
+		default:
+			return Header{}, errSyntax
+		}
+	}
+	return h, nil
+}
+
+// Tokens returns every state token the header mentions, negated or not. RFC
+// 4918 counts all of them as submitted for lock checks.
+func (h Header) Tokens() []string {
+	var tokens []string
+	for _, list := range h.Lists {
+		for _, c := range list.Conditions {
+			if c.Token != "" {
+				tokens = append(tokens, c.Token)
+			}
+		}
+	}
+	return tokens
+}
+
+// Evaluate reports whether the header holds for a request to requestPath.
+// match reports whether a condition's token or entity tag currently applies
+// to the resource at path; Evaluate handles Not. The header holds when any
+// list holds. When the lists are tagged and none of the tags names
+// requestPath, the header doesn't apply to this request and holds as well.
+// An empty header always holds.
+func (h Header) Evaluate(requestPath string, match func(path string, c Condition) bool) bool {
+	if len(h.Lists) == 0 {
+		return true
+	}
+	applies := false
+	for _, list := range h.Lists {
+		if list.Resource == "" || davpath.Same(list.Resource, requestPath) {
+			applies = true
+		}
+	}
+	if !applies {
+		return true
+	}
+
+	for _, list := range h.Lists {
+		path := list.Resource
+		if path == "" {
+			path = requestPath
+		}
+		holds := true
+		for _, c := range list.Conditions {
+			matched := c.Token != NoLock && match(path, c)
+			if matched == c.Not {
+				holds = false
+				break
+			}
+		}
+		if holds {
+			return true
+		}
+	}
+	return false
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.s) }
+func (p *parser) peek() byte { return p.s[p.pos] }
+
+func (p *parser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// codedURL reads "<...>" and returns what's inside.
+func (p *parser) codedURL() (string, error) {
+	end := strings.IndexByte(p.s[p.pos:], '>')
+	if end < 0 {
+		return "", errSyntax
+	}
+	value := p.s[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	if value == "" {
+		return "", errSyntax
+	}
+	return value, nil
+}
+
+// list reads "(" 1*Condition ")".
+func (p *parser) list() (List, error) {
+	var list List
+	p.pos++ // (
+	for {
+		p.skipSpace()
+		if p.done() {
+			return List{}, errSyntax
+		}
+		if p.peek() == ')' {
+			p.pos++
+			if len(list.Conditions) == 0 {
+				return List{}, errSyntax
+			}
+			return list, nil
+		}
+
+		var c Condition
+		if strings.HasPrefix(p.s[p.pos:], "Not") {
+			c.Not = true
+			p.pos += len("Not")
+			p.skipSpace()
+			if p.done() {
+				return List{}, errSyntax
+			}
+		}
+		switch p.peek() {
+		case '<':
+			token, err := p.codedURL()
+			if err != nil {
+				return List{}, err
+			}
+			c.Token = token
+		case '[':
+			end := strings.IndexByte(p.s[p.pos:], ']')
+			if end < 0 {
+				return List{}, errSyntax
+			}
+			c.ETag = strings.TrimSpace(p.s[p.pos+1 : p.pos+end])
+			p.pos += end + 1
+			if c.ETag == "" {
+				return List{}, errSyntax
+			}
+		default:
+			return List{}, errSyntax
+		}
+		list.Conditions = append(list.Conditions, c)
+	}
+}
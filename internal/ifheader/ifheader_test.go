@@ -0,0 +1,85 @@
+package ifheader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	h, err := Parse(`(<urn:uuid:1> ["etag1"]) (Not <DAV:no-lock> [W/"2"])`)
+	require.NoError(t, err)
+	assert.Equal(t, []List{
+		{Conditions: []Condition{{Token: "urn:uuid:1"}, {ETag: `"etag1"`}}},
+		{Conditions: []Condition{{Not: true, Token: NoLock}, {ETag: `W/"2"`}}},
+	}, h.Lists)
+	assert.Equal(t, []string{"urn:uuid:1", NoLock}, h.Tokens())
+}
+
+func TestParseTagged(t *testing.T) {
+	h, err := Parse(`<http://example.com/caldav/alice/cal/work/a.ics> (<urn:uuid:1>) (["x"]) </caldav/alice/cal/work/b.ics> (Not ["y"])`)
+	require.NoError(t, err)
+	assert.Equal(t, []List{
+		{Resource: "/caldav/alice/cal/work/a.ics", Conditions: []Condition{{Token: "urn:uuid:1"}}},
+		{Resource: "/caldav/alice/cal/work/a.ics", Conditions: []Condition{{ETag: `"x"`}}},
+		{Resource: "/caldav/alice/cal/work/b.ics", Conditions: []Condition{{Not: true, ETag: `"y"`}}},
+	}, h.Lists)
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, value := range map[string]string{
+		"empty list":       `()`,
+		"unclosed list":    `(<urn:uuid:1>`,
+		"unclosed token":   `(<urn:uuid:1)`,
+		"unclosed etag":    `(["x")`,
+		"tag without list": `<http://example.com/a.ics>`,
+		"mixed":            `(<urn:uuid:1>) <http://example.com/a.ics> (<urn:uuid:2>)`,
+		"bare word":        `(foo)`,
+		"dangling not":     `(Not`,
+		"garbage":          `urn:uuid:1`,
+		"empty coded url":  `(<>)`,
+		"empty entity tag": `([])`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(value)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	// Resource a.ics has ETag "1" and lock urn:uuid:1; b.ics has ETag "2"
+	state := map[string]Condition{
+		"/a.ics": {Token: "urn:uuid:1", ETag: `"1"`},
+		"/b.ics": {ETag: `"2"`},
+	}
+	match := func(path string, c Condition) bool {
+		s := state[path]
+		if c.Token != "" {
+			return c.Token == s.Token
+		}
+		return c.ETag == s.ETag
+	}
+
+	for value, want := range map[string]bool{
+		``:                                   true,
+		`(<urn:uuid:1>)`:                     true,
+		`(<urn:uuid:2>)`:                     false,
+		`(<urn:uuid:1> ["2"])`:               false,
+		`(<urn:uuid:2>) (["1"])`:             true,
+		`(Not <urn:uuid:1>)`:                 false,
+		`(<DAV:no-lock>)`:                    false,
+		`(Not <DAV:no-lock>)`:                true,
+		`(<urn:uuid:2>) (Not <DAV:no-lock>)`: true,
+		`</a.ics> (["1"])`:                   true,
+		`</a.ics> (["2"])`:                   false,
+		`</a.ics> (["2"]) </b.ics> (["2"])`:  true,
+		// Tags naming other resources only don't apply to this request
+		`</b.ics> (["1"])`: true,
+	} {
+		h, err := Parse(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, h.Evaluate("/a.ics", match), value)
+	}
+}
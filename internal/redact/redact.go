@@ -0,0 +1,60 @@
+// Package redact strips personal data from iCalendar content so it can be
+// logged or stored as a test fixture.
+package redact
+
+import "strings"
+
+// Props are the iCalendar properties whose values ICS redacts. Names are upper case.
+var Props = map[string]bool{
+	"SUMMARY":     true,
+	"DESCRIPTION": true,
+	"LOCATION":    true,
+	"COMMENT":     true,
+	"ATTENDEE":    true,
+	"ORGANIZER":   true,
+}
+
+// ICS replaces the values of Props in iCalendar content, which may stand alone
+// or sit inside calendar-data elements of an XML body. The property name is
+// kept so the structure stays readable and the content still parses;
+// parameters (e.g. an ATTENDEE's CN) and folded continuation lines are dropped
+// with the value.
+func ICS(body string) string {
+	lines := strings.Split(body, "\n")
+	out := lines[:0]
+	redacting := false
+	for _, line := range lines {
+		if redacting && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			continue
+		}
+		redacting = false
+		// A content line may directly follow an XML tag, e.g. <C:calendar-data>SUMMARY:...
+		prefix, content := "", line
+		if _, ok := propName(line); !ok {
+			if i := strings.LastIndex(line, ">"); i >= 0 {
+				prefix, content = line[:i+1], line[i+1:]
+			}
+		}
+		if name, ok := propName(content); ok {
+			cr := ""
+			if strings.HasSuffix(line, "\r") {
+				cr = "\r"
+			}
+			line = prefix + name + ":[REDACTED]" + cr
+			redacting = true
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// propName returns the property name of a content line if it is one of
+// Props. Names are matched case-insensitively, as RFC 5545 requires.
+func propName(line string) (string, bool) {
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return "", false
+	}
+	name := line[:end]
+	return name, Props[strings.ToUpper(name)]
+}
@@ -0,0 +1,41 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestICS(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "plain properties",
+			body:     "BEGIN:VEVENT\r\nUID:abc\r\nSUMMARY:Doctor\r\nDESCRIPTION:Checkup\r\nEND:VEVENT\r\n",
+			expected: "BEGIN:VEVENT\r\nUID:abc\r\nSUMMARY:[REDACTED]\r\nDESCRIPTION:[REDACTED]\r\nEND:VEVENT\r\n",
+		},
+		{
+			name:     "parameters and folded lines",
+			body:     "ATTENDEE;CN=Jane Doe:mailto:jane@example.com\r\n with more\r\nlocation:Home\r\nDTSTART:20250101T000000Z\r\n",
+			expected: "ATTENDEE:[REDACTED]\r\nlocation:[REDACTED]\r\nDTSTART:20250101T000000Z\r\n",
+		},
+		{
+			name:     "calendar data inside xml",
+			body:     "<C:calendar-data>BEGIN:VCALENDAR\nSUMMARY:Secret\nEND:VCALENDAR\n</C:calendar-data>",
+			expected: "<C:calendar-data>BEGIN:VCALENDAR\nSUMMARY:[REDACTED]\nEND:VCALENDAR\n</C:calendar-data>",
+		},
+		{
+			name:     "xml without calendar data",
+			body:     "<D:propfind xmlns:D=\"DAV:\">\n  <D:prop><D:displayname/></D:prop>\n</D:propfind>",
+			expected: "<D:propfind xmlns:D=\"DAV:\">\n  <D:prop><D:displayname/></D:prop>\n</D:propfind>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ICS(tt.body))
+		})
+	}
+}
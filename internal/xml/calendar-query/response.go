@@ -8,6 +8,7 @@ import (
 	"github.com/cyp0633/libcaldora/internal/xml/propfind"
 	"github.com/cyp0633/libcaldora/internal/xml/props"
 	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
 	"github.com/samber/mo"
 )
 
@@ -71,3 +72,51 @@ func ParseRequest(xmlStr string) (propfind.ResponseMap, *storage.Filter, error)
 
 	return propsMap, filter, nil
 }
+
+// ParseTimezoneID returns the time zone a calendar-query asks floating times
+// to be read in: the text of <C:timezone-id> (RFC 7809), or the TZID of the
+// VTIMEZONE in <C:timezone> (RFC 4791 section 9.8). It returns an empty
+// string when the query names none.
+func ParseTimezoneID(xmlStr string) string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil || doc.Root() == nil {
+		return ""
+	}
+	root := doc.Root()
+	for _, child := range root.ChildElements() {
+		switch child.Tag {
+		case "timezone-id":
+			return strings.TrimSpace(child.Text())
+		case "timezone":
+			cal, err := ical.NewDecoder(strings.NewReader(child.Text())).Decode()
+			if err != nil {
+				return ""
+			}
+			for _, comp := range cal.Children {
+				if comp.Name == ical.CompTimezone {
+					if tzid := comp.Props.Get(ical.PropTimezoneID); tzid != nil {
+						return tzid.Value
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// ParseCalendarDataType returns the content-type and version attributes of the
+// <C:calendar-data> element a report asks for in its <D:prop> (RFC 4791
+// section 9.6). Both are empty when the attributes, or the element, are
+// missing. It works for any report with a top-level prop, such as
+// calendar-query and calendar-multiget.
+func ParseCalendarDataType(xmlStr string) (contentType, version string) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil || doc.Root() == nil {
+		return "", ""
+	}
+	data := doc.Root().FindElement("prop/calendar-data")
+	if data == nil {
+		return "", ""
+	}
+	return data.SelectAttrValue("content-type", ""), data.SelectAttrValue("version", "")
+}
@@ -272,3 +272,46 @@ func TestParseRequest_ExtraNamespaces(t *testing.T) {
 	assert.NotNil(t, filter)
 	assert.Equal(t, "VCALENDAR", filter.Component)
 }
+
+func TestParseTimezoneID(t *testing.T) {
+	withTimezone := `<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:filter><C:comp-filter name="VCALENDAR"/></C:filter>
+  <C:timezone>BEGIN:VCALENDAR
+PRODID:-//Example//EN
+VERSION:2.0
+BEGIN:VTIMEZONE
+TZID:America/New_York
+BEGIN:STANDARD
+DTSTART:19671029T020000
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+END:STANDARD
+END:VTIMEZONE
+END:VCALENDAR
+</C:timezone>
+</C:calendar-query>`
+	assert.Equal(t, "America/New_York", ParseTimezoneID(withTimezone))
+
+	withID := `<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:timezone-id> Europe/Berlin </C:timezone-id>
+</C:calendar-query>`
+	assert.Equal(t, "Europe/Berlin", ParseTimezoneID(withID))
+
+	assert.Equal(t, "", ParseTimezoneID(`<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav"/>`))
+	assert.Equal(t, "", ParseTimezoneID(`<C:calendar-query`))
+}
+
+func TestParseCalendarDataType(t *testing.T) {
+	contentType, version := ParseCalendarDataType(`<?xml version="1.0"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data content-type="text/calendar" version="2.0"/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"/></C:filter>
+</C:calendar-query>`)
+	assert.Equal(t, "text/calendar", contentType)
+	assert.Equal(t, "2.0", version)
+
+	contentType, version = ParseCalendarDataType(`<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><C:calendar-data/></D:prop></C:calendar-query>`)
+	assert.Empty(t, contentType)
+	assert.Empty(t, version)
+}
@@ -0,0 +1,41 @@
+package freebusyquery
+
+import (
+	"errors"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// ParseRequest parses a free-busy-query REPORT body (RFC 4791 section 7.10)
+// of the form
+//
+//	<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+//	  <C:time-range start="20060104T140000Z" end="20060105T220000Z"/>
+//	</C:free-busy-query>
+//
+// and returns the range asked for. Both bounds are required.
+func ParseRequest(xmlStr string) (start, end time.Time, err error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil {
+		return start, end, err
+	}
+	root := doc.FindElement("//free-busy-query")
+	if root == nil {
+		return start, end, errors.New("invalid free-busy-query request: missing free-busy-query element")
+	}
+	timeRange := root.FindElement("time-range")
+	if timeRange == nil {
+		return start, end, errors.New("invalid free-busy-query request: missing time-range")
+	}
+	if start, err = time.Parse("20060102T150405Z", timeRange.SelectAttrValue("start", "")); err != nil {
+		return start, end, errors.New("invalid free-busy-query request: time-range needs a UTC start")
+	}
+	if end, err = time.Parse("20060102T150405Z", timeRange.SelectAttrValue("end", "")); err != nil {
+		return start, end, errors.New("invalid free-busy-query request: time-range needs a UTC end")
+	}
+	if !end.After(start) {
+		return start, end, errors.New("invalid free-busy-query request: time-range ends before it starts")
+	}
+	return start, end, nil
+}
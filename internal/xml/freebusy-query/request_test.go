@@ -0,0 +1,33 @@
+package freebusyquery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequest(t *testing.T) {
+	start, end, err := ParseRequest(`<?xml version="1.0" encoding="utf-8"?>
+<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:time-range start="20060104T140000Z" end="20060105T220000Z"/>
+</C:free-busy-query>`)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2006, 1, 4, 14, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2006, 1, 5, 22, 0, 0, 0, time.UTC), end)
+}
+
+func TestParseRequestInvalid(t *testing.T) {
+	for name, body := range map[string]string{
+		"not xml":       `<C:free-busy-query`,
+		"other report":  `<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav"/>`,
+		"no time-range": `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav"/>`,
+		"no end":        `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav"><C:time-range start="20060104T140000Z"/></C:free-busy-query>`,
+		"local start":   `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav"><C:time-range start="20060104T140000" end="20060105T220000Z"/></C:free-busy-query>`,
+		"reversed":      `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav"><C:time-range start="20060105T220000Z" end="20060104T140000Z"/></C:free-busy-query>`,
+	} {
+		_, _, err := ParseRequest(body)
+		assert.Error(t, err, name)
+	}
+}
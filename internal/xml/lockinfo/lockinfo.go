@@ -0,0 +1,130 @@
+package lockinfo
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+)
+
+// Request is a parsed LOCK request body.
+type Request struct {
+	// Shared is set for a shared lock, otherwise the lock is exclusive
+	Shared bool
+	// Owner is the client's DAV:owner element, echoed back in lockdiscovery
+	Owner *etree.Element
+}
+
+// ParseRequest parses a LOCK request body (RFC 4918 section 9.10) of the form
+//
+//	<D:lockinfo xmlns:D="DAV:">
+//	  <D:lockscope><D:exclusive/></D:lockscope>
+//	  <D:locktype><D:write/></D:locktype>
+//	  <D:owner><D:href>mailto:alice@example.com</D:href></D:owner>
+//	</D:lockinfo>
+//
+// Only write locks exist in WebDAV, so a missing locktype is accepted.
+func ParseRequest(xmlStr string) (Request, error) {
+	var req Request
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil {
+		return req, err
+	}
+	root := doc.FindElement("//lockinfo")
+	if root == nil {
+		return req, errors.New("invalid lock request: missing lockinfo element")
+	}
+
+	scope := root.FindElement("lockscope")
+	if scope == nil {
+		return req, errors.New("invalid lock request: missing lockscope")
+	}
+	switch {
+	case scope.FindElement("exclusive") != nil:
+	case scope.FindElement("shared") != nil:
+		req.Shared = true
+	default:
+		return req, errors.New("invalid lock request: unknown lockscope")
+	}
+	if lockType := root.FindElement("locktype"); lockType != nil && lockType.FindElement("write") == nil {
+		return req, errors.New("invalid lock request: only write locks are supported")
+	}
+	if owner := root.FindElement("owner"); owner != nil {
+		req.Owner = detach(owner)
+	}
+	return req, nil
+}
+
+// detach copies elem so it can be placed in another document: DAV: elements
+// get the "d" prefix, and other namespaces are declared on the element using them.
+func detach(elem *etree.Element) *etree.Element {
+	out := etree.NewElement(elem.Tag)
+	switch ns := elem.NamespaceURI(); ns {
+	case "":
+	case props.NamespaceMap["d"]:
+		out.Space = "d"
+	default:
+		out.Space = elem.Space
+		if out.Space == "" {
+			out.CreateAttr("xmlns", ns)
+		} else {
+			out.CreateAttr("xmlns:"+out.Space, ns)
+		}
+	}
+	for _, attr := range elem.Attr {
+		if attr.Space != "xmlns" && attr.Key != "xmlns" {
+			out.CreateAttr(attr.FullKey(), attr.Value)
+		}
+	}
+	for _, child := range elem.Child {
+		switch c := child.(type) {
+		case *etree.Element:
+			out.AddChild(detach(c))
+		case *etree.CharData:
+			out.CreateText(c.Data)
+		}
+	}
+	return out
+}
+
+// ActiveLock describes a granted lock for lockdiscovery.
+type ActiveLock struct {
+	Token    string
+	Root     string // href of the locked resource
+	Shared   bool
+	Infinity bool // Depth: infinity, otherwise Depth: 0
+	Owner    *etree.Element
+	Timeout  time.Duration
+}
+
+// EncodeResponse builds the body answering a successful LOCK:
+// a DAV:prop holding DAV:lockdiscovery with the granted lock.
+func EncodeResponse(lock ActiveLock) *etree.Document {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="utf-8"`)
+	prop := doc.CreateElement("d:prop")
+	prop.CreateAttr("xmlns:d", props.NamespaceMap["d"])
+
+	active := prop.CreateElement("d:lockdiscovery").CreateElement("d:activelock")
+	active.CreateElement("d:locktype").CreateElement("d:write")
+	if lock.Shared {
+		active.CreateElement("d:lockscope").CreateElement("d:shared")
+	} else {
+		active.CreateElement("d:lockscope").CreateElement("d:exclusive")
+	}
+	if lock.Infinity {
+		active.CreateElement("d:depth").SetText("infinity")
+	} else {
+		active.CreateElement("d:depth").SetText("0")
+	}
+	if lock.Owner != nil {
+		active.AddChild(lock.Owner.Copy())
+	}
+	active.CreateElement("d:timeout").SetText("Second-" + strconv.Itoa(int(lock.Timeout.Seconds())))
+	active.CreateElement("d:locktoken").CreateElement("d:href").SetText(lock.Token)
+	active.CreateElement("d:lockroot").CreateElement("d:href").SetText(lock.Root)
+	return doc
+}
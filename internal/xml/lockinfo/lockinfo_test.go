@@ -0,0 +1,57 @@
+package lockinfo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequest(t *testing.T) {
+	req, err := ParseRequest(`<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope><D:shared/></D:lockscope>
+  <D:locktype><D:write/></D:locktype>
+  <D:owner><D:href>mailto:alice@example.com</D:href></D:owner>
+</D:lockinfo>`)
+	require.NoError(t, err)
+	assert.True(t, req.Shared)
+	require.NotNil(t, req.Owner)
+	assert.Equal(t, "d", req.Owner.Space)
+	assert.Equal(t, "mailto:alice@example.com", req.Owner.FindElement("href").Text())
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	for name, body := range map[string]string{
+		"malformed":     `<D:lockinfo`,
+		"wrong root":    `<D:propfind xmlns:D="DAV:"/>`,
+		"missing scope": `<D:lockinfo xmlns:D="DAV:"><D:locktype><D:write/></D:locktype></D:lockinfo>`,
+		"unknown type":  `<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:read/></D:locktype></D:lockinfo>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseRequest(body)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	req, err := ParseRequest(`<lockinfo xmlns="DAV:"><lockscope><exclusive/></lockscope>` +
+		`<owner><x:name xmlns:x="urn:example">Alice</x:name></owner></lockinfo>`)
+	require.NoError(t, err)
+
+	out, err := EncodeResponse(ActiveLock{
+		Token:   "urn:uuid:1",
+		Root:    "/caldav/alice/cal/work/a.ics",
+		Owner:   req.Owner,
+		Timeout: 10 * time.Minute,
+	}).WriteToString()
+	require.NoError(t, err)
+	assert.Contains(t, out, `<d:lockscope><d:exclusive/></d:lockscope>`)
+	assert.Contains(t, out, `<d:depth>0</d:depth>`)
+	assert.Contains(t, out, `<d:owner><x:name xmlns:x="urn:example">Alice</x:name></d:owner>`)
+	assert.Contains(t, out, `<d:timeout>Second-600</d:timeout>`)
+	assert.Contains(t, out, `<d:locktoken><d:href>urn:uuid:1</d:href></d:locktoken>`)
+	assert.Contains(t, out, `<d:lockroot><d:href>/caldav/alice/cal/work/a.ics</d:href></d:lockroot>`)
+}
@@ -0,0 +1,62 @@
+package multiput
+
+import (
+	"errors"
+
+	"github.com/beevik/etree"
+)
+
+// Item is a single <cs:resource> entry of a CalendarServer bulk (multiput) request.
+//
+// An item without Href creates a new resource, an item with Href and CalendarData
+// updates it, and an item with Href and Delete set removes it.
+type Item struct {
+	Href         string // target resource, empty for creates
+	IfMatch      string // optional ETag precondition from <cs:if-match>
+	CalendarData string // iCalendar payload from <d:set><d:prop><cal:calendar-data>
+	Delete       bool   // <cs:delete/> present
+}
+
+// ParseRequest parses a <cs:multiput> request body into its items.
+func ParseRequest(xmlStr string) ([]Item, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil {
+		return nil, err
+	}
+
+	root := doc.FindElement("//multiput")
+	if root == nil {
+		return nil, errors.New("invalid bulk request: missing multiput element")
+	}
+
+	var items []Item
+	for _, res := range root.SelectElements("resource") {
+		item := Item{}
+		if href := res.SelectElement("href"); href != nil {
+			item.Href = href.Text()
+		}
+		if ifMatch := res.SelectElement("if-match"); ifMatch != nil {
+			if etag := ifMatch.FindElement("getetag"); etag != nil {
+				item.IfMatch = etag.Text()
+			} else {
+				item.IfMatch = ifMatch.Text()
+			}
+		}
+		if res.SelectElement("delete") != nil {
+			item.Delete = true
+		}
+		if data := res.FindElement("set/prop/calendar-data"); data != nil {
+			item.CalendarData = data.Text()
+		}
+
+		if item.Delete && item.Href == "" {
+			return nil, errors.New("invalid bulk request: delete requires href")
+		}
+		if !item.Delete && item.CalendarData == "" {
+			return nil, errors.New("invalid bulk request: resource without calendar-data")
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,59 @@
+package multiput
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequest(t *testing.T) {
+	xmlInput := `<?xml version="1.0" encoding="utf-8"?>
+<CS:multiput xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <CS:resource>
+    <D:set><D:prop><C:calendar-data>BEGIN:VCALENDAR
+END:VCALENDAR</C:calendar-data></D:prop></D:set>
+  </CS:resource>
+  <CS:resource>
+    <D:href>/alice/cal/work/a.ics</D:href>
+    <CS:if-match><D:getetag>"etag-a"</D:getetag></CS:if-match>
+    <D:set><D:prop><C:calendar-data>BEGIN:VCALENDAR
+END:VCALENDAR</C:calendar-data></D:prop></D:set>
+  </CS:resource>
+  <CS:resource>
+    <D:href>/alice/cal/work/b.ics</D:href>
+    <CS:delete/>
+  </CS:resource>
+</CS:multiput>`
+
+	items, err := ParseRequest(xmlInput)
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+
+	assert.Equal(t, "", items[0].Href)
+	assert.Contains(t, items[0].CalendarData, "BEGIN:VCALENDAR")
+	assert.False(t, items[0].Delete)
+
+	assert.Equal(t, "/alice/cal/work/a.ics", items[1].Href)
+	assert.Equal(t, `"etag-a"`, items[1].IfMatch)
+
+	assert.Equal(t, "/alice/cal/work/b.ics", items[2].Href)
+	assert.True(t, items[2].Delete)
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		xmlInput string
+	}{
+		{"malformed xml", `<multiput`},
+		{"missing root", `<propfind xmlns="DAV:"/>`},
+		{"delete without href", `<CS:multiput xmlns:CS="http://calendarserver.org/ns/"><CS:resource><CS:delete/></CS:resource></CS:multiput>`},
+		{"missing calendar-data", `<CS:multiput xmlns:CS="http://calendarserver.org/ns/" xmlns:D="DAV:"><CS:resource><D:href>/a.ics</D:href></CS:resource></CS:multiput>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRequest(tt.xmlInput)
+			assert.Error(t, err)
+		})
+	}
+}
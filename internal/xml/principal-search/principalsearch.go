@@ -0,0 +1,139 @@
+// Package principalsearch parses DAV:principal-property-search REPORTs and
+// encodes DAV:principal-search-property-set responses (RFC 3744 section 9).
+package principalsearch
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/samber/mo"
+)
+
+// Match types, from the match-type attribute CalendarServer clients send.
+const (
+	MatchContains   = "contains"
+	MatchStartsWith = "starts-with"
+	MatchEquals     = "equals"
+)
+
+// PropertySearch is one DAV:property-search: any of Props must match Match.
+type PropertySearch struct {
+	Props     []string // property local names, e.g. "displayname"
+	Match     string
+	MatchType string // MatchContains, MatchStartsWith or MatchEquals
+}
+
+// Query holds the criteria of a principal-property-search REPORT.
+type Query struct {
+	// AllOf requires every search to match instead of any of them
+	AllOf    bool
+	Searches []PropertySearch
+}
+
+// ParseRequest parses a principal-property-search REPORT body of the form
+//
+//	<D:principal-property-search xmlns:D="DAV:" test="anyof">
+//	  <D:property-search>
+//	    <D:prop><D:displayname/></D:prop>
+//	    <D:match>doe</D:match>
+//	  </D:property-search>
+//	  <D:prop><D:displayname/></D:prop>
+//	</D:principal-property-search>
+//
+// and returns the requested properties and the query.
+func ParseRequest(xmlStr string) (propfind.ResponseMap, Query, error) {
+	propsMap := make(propfind.ResponseMap)
+	var query Query
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil {
+		return propsMap, query, err
+	}
+	root := doc.FindElement("//principal-property-search")
+	if root == nil {
+		return propsMap, query, errors.New("invalid principal-property-search request: missing root element")
+	}
+	query.AllOf = root.SelectAttrValue("test", "anyof") == "allof"
+
+	for _, elem := range root.FindElements("property-search") {
+		search := PropertySearch{MatchType: MatchContains}
+		if propElem := elem.FindElement("prop"); propElem != nil {
+			for _, p := range propElem.ChildElements() {
+				search.Props = append(search.Props, strings.ToLower(p.Tag))
+			}
+		}
+		matchElem := elem.FindElement("match")
+		if len(search.Props) == 0 || matchElem == nil {
+			return propsMap, query, errors.New("invalid principal-property-search request: incomplete property-search")
+		}
+		search.Match = matchElem.Text()
+		switch t := matchElem.SelectAttrValue("match-type", MatchContains); t {
+		case MatchContains, MatchStartsWith, MatchEquals:
+			search.MatchType = t
+		default:
+			return propsMap, query, errors.New("invalid principal-property-search request: unknown match-type " + t)
+		}
+		query.Searches = append(query.Searches, search)
+	}
+	if len(query.Searches) == 0 {
+		return propsMap, query, errors.New("invalid principal-property-search request: no property-search")
+	}
+
+	if propElem := root.FindElement("prop"); propElem != nil {
+		for _, elem := range propElem.ChildElements() {
+			localName := strings.ToLower(elem.Tag)
+			if structPtr, exists := props.PropNameToStruct[localName]; exists {
+				propsMap[localName] = mo.Ok(structPtr)
+			}
+		}
+	}
+
+	return propsMap, query, nil
+}
+
+// Matches reports whether value satisfies the search's match, compared
+// case-insensitively.
+func (s PropertySearch) Matches(value string) bool {
+	value, match := strings.ToLower(value), strings.ToLower(s.Match)
+	switch s.MatchType {
+	case MatchStartsWith:
+		return strings.HasPrefix(value, match)
+	case MatchEquals:
+		return value == match
+	default:
+		return strings.Contains(value, match)
+	}
+}
+
+// SearchProperty is a property clients may search on, with a description
+// for display.
+type SearchProperty struct {
+	Name        string
+	Description string
+}
+
+// EncodePropertySet encodes the principal-search-property-set response
+// listing the searchable properties.
+func EncodePropertySet(searchable []SearchProperty) *etree.Document {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="utf-8"`)
+	root := doc.CreateElement("d:principal-search-property-set")
+	for prefix, uri := range props.NamespaceMap {
+		root.CreateAttr("xmlns:"+prefix, uri)
+	}
+	for _, p := range searchable {
+		elem := root.CreateElement("d:principal-search-property")
+		prefix, ok := props.PropPrefixMap[p.Name]
+		if !ok {
+			prefix = "d"
+		}
+		elem.CreateElement("d:prop").CreateElement(prefix + ":" + p.Name)
+		desc := elem.CreateElement("d:description")
+		desc.CreateAttr("xml:lang", "en")
+		desc.SetText(p.Description)
+	}
+	return doc
+}
@@ -0,0 +1,63 @@
+package principalsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequest(t *testing.T) {
+	req, query, err := ParseRequest(`<?xml version="1.0" encoding="utf-8"?>
+<D:principal-property-search xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" test="allof">
+  <D:property-search>
+    <D:prop><D:displayname/><C:calendar-user-address-set/></D:prop>
+    <D:match>Doe</D:match>
+  </D:property-search>
+  <D:property-search>
+    <D:prop><D:displayname/></D:prop>
+    <D:match match-type="starts-with">J</D:match>
+  </D:property-search>
+  <D:prop><D:displayname/><C:calendar-home-set/></D:prop>
+</D:principal-property-search>`)
+	require.NoError(t, err)
+
+	assert.Len(t, req, 2)
+	assert.Contains(t, req, "displayname")
+	assert.Contains(t, req, "calendar-home-set")
+	assert.Equal(t, Query{AllOf: true, Searches: []PropertySearch{
+		{Props: []string{"displayname", "calendar-user-address-set"}, Match: "Doe", MatchType: MatchContains},
+		{Props: []string{"displayname"}, Match: "J", MatchType: MatchStartsWith},
+	}}, query)
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	for name, body := range map[string]string{
+		"malformed":       `<D:principal-property-search`,
+		"wrong root":      `<D:propfind xmlns:D="DAV:"/>`,
+		"no search":       `<D:principal-property-search xmlns:D="DAV:"><D:prop><D:displayname/></D:prop></D:principal-property-search>`,
+		"no match":        `<D:principal-property-search xmlns:D="DAV:"><D:property-search><D:prop><D:displayname/></D:prop></D:property-search></D:principal-property-search>`,
+		"bad match type":  `<D:principal-property-search xmlns:D="DAV:"><D:property-search><D:prop><D:displayname/></D:prop><D:match match-type="regex">x</D:match></D:property-search></D:principal-property-search>`,
+		"no search props": `<D:principal-property-search xmlns:D="DAV:"><D:property-search><D:prop/><D:match>x</D:match></D:property-search></D:principal-property-search>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ParseRequest(body)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	assert.True(t, PropertySearch{Match: "doe", MatchType: MatchContains}.Matches("Jane Doe"))
+	assert.False(t, PropertySearch{Match: "doe", MatchType: MatchStartsWith}.Matches("Jane Doe"))
+	assert.True(t, PropertySearch{Match: "jane", MatchType: MatchStartsWith}.Matches("Jane Doe"))
+	assert.True(t, PropertySearch{Match: "JANE DOE", MatchType: MatchEquals}.Matches("Jane Doe"))
+	assert.False(t, PropertySearch{Match: "Jane", MatchType: MatchEquals}.Matches("Jane Doe"))
+}
+
+func TestEncodePropertySet(t *testing.T) {
+	doc := EncodePropertySet([]SearchProperty{{Name: "displayname", Description: "Display name"}})
+	out, err := doc.WriteToString()
+	require.NoError(t, err)
+	assert.Contains(t, out, `<d:principal-search-property><d:prop><d:displayname/></d:prop><d:description xml:lang="en">Display name</d:description></d:principal-search-property>`)
+}
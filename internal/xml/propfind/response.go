@@ -1,6 +1,8 @@
 package propfind
 
 import (
+	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/beevik/etree"
@@ -67,8 +69,10 @@ func ParseRequest(xmlStr string) (ResponseMap, RequestType) {
 		if structPtr, exists := props.PropNameToStruct[localName]; exists {
 			// Add the property to the response map
 			propsMap[localName] = mo.Ok(structPtr)
+		} else {
+			// Unknown properties may be dead properties stored by clients
+			propsMap[DeadPropKey(elem.NamespaceURI(), elem.Tag)] = mo.Err[props.Property](ErrNotFound)
 		}
-		// Skip unknown properties
 	}
 
 	return propsMap, requestType
@@ -123,14 +127,19 @@ func EncodeResponse(propsMap ResponseMap, href string) *etree.Document {
 			}
 
 			// Create an empty element for the property
-			// Use PropPrefixMap to determine the correct namespace prefix
-			prefix, exists := props.PropPrefixMap[propName]
-			if !exists {
-				prefix = "d" // Default to WebDAV namespace if not found
+			if namespace, name, ok := ParseDeadPropKey(propName); ok {
+				propElem = etree.NewElement(name)
+				propElem.CreateAttr("xmlns", namespace)
+			} else {
+				// Use PropPrefixMap to determine the correct namespace prefix
+				prefix, exists := props.PropPrefixMap[propName]
+				if !exists {
+					prefix = "d" // Default to WebDAV namespace if not found
+				}
+
+				propElem = etree.NewElement(propName)
+				propElem.Space = prefix
 			}
-
-			propElem = etree.NewElement(propName)
-			propElem.Space = prefix
 		}
 
 		// Create propstat for this status code if it doesn't exist yet
@@ -151,6 +160,25 @@ func EncodeResponse(propsMap ResponseMap, href string) *etree.Document {
 	return doc
 }
 
+// EncodeStatusResponse creates a multistatus document holding a single response
+// that carries only an href and a status (no propstat), as used for per-resource
+// failures in REPORT and bulk responses.
+func EncodeStatusResponse(href string, statusCode int) *etree.Document {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="utf-8"`)
+
+	multistatus := doc.CreateElement("d:multistatus")
+	for prefix, uri := range props.NamespaceMap {
+		multistatus.CreateAttr("xmlns:"+prefix, uri)
+	}
+
+	response := multistatus.CreateElement("d:response")
+	response.CreateElement("d:href").SetText(href)
+	response.CreateElement("d:status").SetText(fmt.Sprintf("HTTP/1.1 %d %s", statusCode, http.StatusText(statusCode)))
+
+	return doc
+}
+
 // MergeResponses is used for merging responses for individual calendar resources into
 // one response to a PROPFIND request (often with depth>0).
 func MergeResponses(docs []*etree.Document) (*etree.Document, error) {
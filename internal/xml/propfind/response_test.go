@@ -1,7 +1,9 @@
 package propfind
 
 import (
+	"net/http"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/cyp0633/libcaldora/internal/xml/props"
 	"github.com/samber/mo"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseRequest(t *testing.T) {
@@ -16,6 +19,7 @@ func TestParseRequest(t *testing.T) {
 		name     string
 		xmlInput string
 		want     map[string]reflect.Type // Expected property names and their types
+		dead     []string                // Expected keys of unknown properties
 	}{
 		{
 			name: "Basic WebDAV properties",
@@ -111,6 +115,18 @@ func TestParseRequest(t *testing.T) {
 				"displayname": reflect.TypeOf(new(props.DisplayName)),
 				"getetag":     reflect.TypeOf(new(props.GetEtag)),
 			},
+			dead: []string{"{DAV:}nonexistent-property"},
+		},
+		{
+			name: "Custom namespace keeps case",
+			xmlInput: `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:x="urn:example">
+  <d:prop>
+    <x:SnoozeSettings/>
+  </d:prop>
+</d:propfind>`,
+			want: map[string]reflect.Type{},
+			dead: []string{"{urn:example}SnoozeSettings"},
 		},
 	}
 
@@ -119,8 +135,8 @@ func TestParseRequest(t *testing.T) {
 			got, typ := ParseRequest(tt.xmlInput)
 
 			// Check if the number of properties matches
-			assert.Equal(t, len(tt.want), len(got),
-				"Result should have %d properties, got %d", len(tt.want), len(got))
+			assert.Equal(t, len(tt.want)+len(tt.dead), len(got),
+				"Result should have %d properties, got %d", len(tt.want)+len(tt.dead), len(got))
 			assert.Equal(t, RequestTypeProp, typ, "Request type should be RequestTypeProp")
 
 			// Check each expected property type
@@ -140,9 +156,18 @@ func TestParseRequest(t *testing.T) {
 				}
 			}
 
+			// Unknown properties start out as not found
+			for _, key := range tt.dead {
+				result, exists := got[key]
+				if assert.True(t, exists, "Property %s should exist in result", key) {
+					assert.Equal(t, ErrNotFound, result.Error())
+				}
+			}
+
 			// Check if there are no unexpected properties
 			for propName := range got {
 				_, exists := tt.want[propName]
+				exists = exists || slices.Contains(tt.dead, propName)
 				assert.True(t, exists, "Unexpected property in result: %s", propName)
 			}
 		})
@@ -534,6 +559,31 @@ func parseXML(t *testing.T, xmlStr string) *etree.Document {
 
 // --- Test Suite ---
 
+func TestEncodeResponseDeadProperties(t *testing.T) {
+	stored := etree.NewDocument()
+	require.NoError(t, stored.ReadFromString(`<x:snooze xmlns:x="urn:example">15</x:snooze>`))
+
+	doc := EncodeResponse(ResponseMap{
+		DeadPropKey("urn:example", "snooze"):  mo.Ok[props.Property](&props.RawProperty{Element: stored.Root()}),
+		DeadPropKey("urn:example", "Missing"): mo.Err[props.Property](ErrNotFound),
+	}, "/calendars/user1/calendar1/")
+	out, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	assert.Contains(t, out, `<d:prop><x:snooze xmlns:x="urn:example">15</x:snooze></d:prop><d:status>HTTP/1.1 200 OK</d:status>`)
+	assert.Contains(t, out, `<d:prop><Missing xmlns="urn:example"/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status>`)
+}
+
+func TestParseDeadPropKey(t *testing.T) {
+	namespace, name, ok := ParseDeadPropKey(DeadPropKey("urn:example", "Snooze"))
+	assert.True(t, ok)
+	assert.Equal(t, "urn:example", namespace)
+	assert.Equal(t, "Snooze", name)
+
+	_, _, ok = ParseDeadPropKey("displayname")
+	assert.False(t, ok)
+}
+
 func TestMergeResponses(t *testing.T) {
 	// Setup: Ensure props.NamespaceMap is defined (copy from main code or define here)
 	// Assuming props.NamespaceMap is accessible or redefined for test scope
@@ -739,3 +789,17 @@ func TestMergeResponses(t *testing.T) {
 
 	})
 }
+
+func TestEncodeStatusResponse(t *testing.T) {
+	doc := EncodeStatusResponse("/alice/cal/work/missing.ics", http.StatusNotFound)
+
+	merged, err := MergeResponses([]*etree.Document{doc})
+	assert.NoError(t, err)
+
+	response := merged.Root().FindElement("./d:response")
+	if assert.NotNil(t, response) {
+		assert.Equal(t, "/alice/cal/work/missing.ics", response.FindElement("./d:href").Text())
+		assert.Equal(t, "HTTP/1.1 404 Not Found", response.FindElement("./d:status").Text())
+		assert.Nil(t, response.FindElement("./d:propstat"))
+	}
+}
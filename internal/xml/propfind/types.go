@@ -2,6 +2,7 @@ package propfind
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/cyp0633/libcaldora/internal/xml/props"
 	"github.com/samber/mo"
@@ -23,3 +24,21 @@ var (
 	ErrInternal   = errors.New("HTTP 500: Internal server error")
 	ErrBadRequest = errors.New("HTTP 400: Bad request")
 )
+
+// DeadPropKey returns the ResponseMap key of a property the props package has
+// no type for, in Clark notation: "{namespace}name". Unlike known properties,
+// these keep the case of their name and are told apart by namespace.
+func DeadPropKey(namespace, name string) string {
+	return "{" + namespace + "}" + name
+}
+
+// ParseDeadPropKey splits a key made by DeadPropKey. ok is false for keys of
+// known properties.
+func ParseDeadPropKey(key string) (namespace, name string, ok bool) {
+	rest, ok := strings.CutPrefix(key, "{")
+	if !ok {
+		return "", "", false
+	}
+	namespace, name, ok = strings.Cut(rest, "}")
+	return namespace, name, ok
+}
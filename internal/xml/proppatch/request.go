@@ -0,0 +1,70 @@
+package proppatch
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+)
+
+// Operation is one property instruction of a PROPPATCH body. Operations are
+// returned in document order, which is the order they must be applied in.
+type Operation struct {
+	// Name is the lowercased local name of the property
+	Name string
+	// Namespace is the namespace URI of the property element
+	Namespace string
+	// Remove is true for <remove>, false for <set>
+	Remove bool
+	// Property is the decoded value for known properties in a <set>, nil otherwise
+	Property props.Property
+	// Element is the raw property element, for properties without a Property type
+	Element *etree.Element
+}
+
+// ParseRequest parses a PROPPATCH XML request into its set and remove operations.
+func ParseRequest(xmlStr string) ([]Operation, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil {
+		return nil, err
+	}
+
+	update := doc.FindElement("//propertyupdate")
+	if update == nil {
+		return nil, errors.New("invalid PROPPATCH request: missing propertyupdate element")
+	}
+
+	var ops []Operation
+	for _, instruction := range update.ChildElements() {
+		remove := false
+		switch instruction.Tag {
+		case "set":
+		case "remove":
+			remove = true
+		default:
+			continue
+		}
+
+		prop := instruction.FindElement("prop")
+		if prop == nil {
+			continue
+		}
+		for _, e := range prop.ChildElements() {
+			op := Operation{Name: strings.ToLower(e.Tag), Namespace: e.NamespaceURI(), Remove: remove, Element: e}
+			if proto, ok := props.PropNameToStruct[op.Name]; ok && !remove {
+				inst := reflect.New(reflect.TypeOf(proto).Elem()).Interface().(props.Property)
+				if err := inst.Decode(e); err == nil {
+					op.Property = inst
+				}
+			}
+			ops = append(ops, op)
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil, errors.New("invalid PROPPATCH request: no properties to update")
+	}
+	return ops, nil
+}
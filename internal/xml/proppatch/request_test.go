@@ -0,0 +1,52 @@
+package proppatch
+
+import (
+	"testing"
+
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequest(t *testing.T) {
+	ops, err := ParseRequest(`<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/" xmlns:X="urn:example">
+  <D:set>
+    <D:prop>
+      <CS:calendar-availability>BEGIN:VCALENDAR</CS:calendar-availability>
+      <X:custom>value</X:custom>
+    </D:prop>
+  </D:set>
+  <D:remove>
+    <D:prop><D:displayname/></D:prop>
+  </D:remove>
+</D:propertyupdate>`)
+	require.NoError(t, err)
+	require.Len(t, ops, 3)
+
+	assert.Equal(t, "calendar-availability", ops[0].Name)
+	assert.False(t, ops[0].Remove)
+	assert.Equal(t, &props.CalendarAvailability{Value: "BEGIN:VCALENDAR"}, ops[0].Property)
+
+	assert.Equal(t, "custom", ops[1].Name)
+	assert.Equal(t, "urn:example", ops[1].Namespace)
+	assert.Nil(t, ops[1].Property)
+	assert.Equal(t, "value", ops[1].Element.Text())
+
+	assert.Equal(t, "displayname", ops[2].Name)
+	assert.True(t, ops[2].Remove)
+	assert.Nil(t, ops[2].Property)
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	for name, body := range map[string]string{
+		"malformed":    `<D:propertyupdate`,
+		"missing root": `<D:propfind xmlns:D="DAV:"/>`,
+		"empty":        `<D:propertyupdate xmlns:D="DAV:"><D:set><D:prop/></D:set></D:propertyupdate>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseRequest(body)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package proppatch
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+)
+
+// PropStatus is the outcome of one operation in a PROPPATCH response.
+type PropStatus struct {
+	Name string
+	// Namespace is only used for properties unknown to the props package
+	Namespace  string
+	StatusCode int
+}
+
+// EncodeResponse builds the 207 multistatus body for a PROPPATCH on href.
+// Properties are reported as empty elements grouped by status, as RFC 4918
+// requires, in the order of the given statuses.
+func EncodeResponse(href string, statuses []PropStatus) *etree.Document {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="utf-8"`)
+
+	multistatus := doc.CreateElement("d:multistatus")
+	for prefix, uri := range props.NamespaceMap {
+		multistatus.CreateAttr("xmlns:"+prefix, uri)
+	}
+
+	response := multistatus.CreateElement("d:response")
+	response.CreateElement("d:href").SetText(href)
+
+	propByStatus := make(map[int]*etree.Element)
+	for _, st := range statuses {
+		prop, exists := propByStatus[st.StatusCode]
+		if !exists {
+			propstat := response.CreateElement("d:propstat")
+			prop = propstat.CreateElement("d:prop")
+			propstat.CreateElement("d:status").SetText(
+				fmt.Sprintf("HTTP/1.1 %d %s", st.StatusCode, http.StatusText(st.StatusCode)))
+			propByStatus[st.StatusCode] = prop
+		}
+
+		elem := etree.NewElement(st.Name)
+		if prefix, exists := props.PropPrefixMap[st.Name]; exists {
+			elem.Space = prefix
+		} else if st.Namespace != "" {
+			elem.CreateAttr("xmlns", st.Namespace)
+		} else {
+			elem.Space = "d"
+		}
+		prop.AddChild(elem)
+	}
+
+	return doc
+}
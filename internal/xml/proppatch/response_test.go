@@ -0,0 +1,22 @@
+package proppatch
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeResponse(t *testing.T) {
+	doc := EncodeResponse("/caldav/alice/", []PropStatus{
+		{Name: "calendar-availability", StatusCode: http.StatusForbidden},
+		{Name: "custom", Namespace: "urn:example", StatusCode: http.StatusFailedDependency},
+	})
+	out, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	assert.Contains(t, out, "<d:href>/caldav/alice/</d:href>")
+	assert.Contains(t, out, "<d:prop><cs:calendar-availability/></d:prop><d:status>HTTP/1.1 403 Forbidden</d:status>")
+	assert.Contains(t, out, `<d:prop><custom xmlns="urn:example"/></d:prop><d:status>HTTP/1.1 424 Failed Dependency</d:status>`)
+}
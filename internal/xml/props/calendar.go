@@ -83,6 +83,8 @@ func (p *SupportedCalendarComponentSet) Decode(elem *etree.Element) error {
 	return nil
 }
 
+// SupportedCalendarData names the media type and version of calendar data a
+// collection accepts, as a calendar-data element (RFC 4791 section 5.2.4).
 type SupportedCalendarData struct {
 	ContentType string
 	Version     string
@@ -90,18 +92,22 @@ type SupportedCalendarData struct {
 
 func (p SupportedCalendarData) Encode() *etree.Element {
 	elem := createElement("supported-calendar-data")
-	elem.SetText(p.ContentType)
+	data := createElement("calendar-data")
+	data.CreateAttr("content-type", p.ContentType)
 	if p.Version != "" {
-		elem.CreateAttr("version", p.Version)
+		data.CreateAttr("version", p.Version)
 	}
+	elem.AddChild(data)
 	return elem
 }
 
 func (p *SupportedCalendarData) Decode(elem *etree.Element) error {
-	p.ContentType = elem.Text()
-	if versionAttr := elem.SelectAttr("version"); versionAttr != nil {
-		p.Version = versionAttr.Value
+	data := elem.FindElement("calendar-data")
+	if data == nil {
+		return nil
 	}
+	p.ContentType = data.SelectAttrValue("content-type", "")
+	p.Version = data.SelectAttrValue("version", "")
 	return nil
 }
 
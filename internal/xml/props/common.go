@@ -15,6 +15,7 @@ var NamespaceMap = map[string]string{
 	"cs":   "http://calendarserver.org/ns/",
 	"g":    "http://schemas.google.com/gCal/2005",
 	"ical": "http://apple.com/ns/ical/",
+	"lc":   "https://github.com/cyp0633/libcaldora/ns/",
 }
 
 // Prefix map for each property and child element
@@ -25,24 +26,33 @@ var PropPrefixMap = map[string]string{
 	"getetag":                    "d",
 	"getlastmodified":            "d",
 	"getcontenttype":             "d",
+	"getcontentlength":           "d",
 	"owner":                      "d",
 	"current-user-principal":     "d",
 	"principal-url":              "d",
+	"principal-collection-set":   "d",
 	"supported-report-set":       "d",
 	"acl":                        "d",
 	"current-user-privilege-set": "d",
+	"supported-privilege-set":    "d",
 	"quota-available-bytes":      "d",
 	"quota-used-bytes":           "d",
+	"sync-token":                 "d",
+	"group-member-set":           "d",
+	"group-membership":           "d",
 	// Additional child elements for WebDAV
-	"collection":       "d",
-	"principal":        "d",
-	"href":             "d",
-	"grant":            "d",
-	"privilege":        "d",
-	"supported-report": "d",
-	"search":           "d",
-	"report":           "d",
-	"ace":              "d",
+	"collection":          "d",
+	"principal":           "d",
+	"href":                "d",
+	"grant":               "d",
+	"privilege":           "d",
+	"supported-report":    "d",
+	"search":              "d",
+	"report":              "d",
+	"ace":                 "d",
+	"supported-privilege": "d",
+	"abstract":            "d",
+	"description":         "d",
 
 	// CalDAV properties (cal: prefix)
 	"calendar-description":             "cal",
@@ -75,9 +85,16 @@ var PropPrefixMap = map[string]string{
 	"shared-url":               "cs",
 	"invite":                   "cs",
 	"notification-url":         "cs",
+	"notificationtype":         "cs",
 	"auto-schedule":            "cs",
 	"calendar-proxy-read-for":  "cs",
 	"calendar-proxy-write-for": "cs",
+	"bulk-requests":            "cs",
+	"simple":                   "cs",
+	"crud":                     "cs",
+	"max-resources":            "cs",
+	"max-bytes":                "cs",
+	"calendar-availability":    "cs",
 	"calendar-color":           "ical",
 
 	// Google CalDAV Extensions (g: prefix)
@@ -85,6 +102,16 @@ var PropPrefixMap = map[string]string{
 	"timezone": "g",
 	"hidden":   "g",
 	"selected": "g",
+
+	// libcaldora extensions (lc: prefix)
+	"max-calendars":       "lc",
+	"calendars-remaining": "lc",
+	"max-objects":         "lc",
+	"objects-remaining":   "lc",
+	"archived":            "lc",
+	"week-start":          "lc",
+	"working-hours":       "lc",
+	"hours":               "lc",
 }
 
 // Reuse the property mapping from propfind
@@ -95,14 +122,20 @@ var PropNameToStruct = map[string]Property{
 	"getetag":                    new(GetEtag),
 	"getlastmodified":            new(GetLastModified),
 	"getcontenttype":             new(GetContentType),
+	"getcontentlength":           new(GetContentLength),
 	"owner":                      new(Owner),
 	"current-user-principal":     new(CurrentUserPrincipal),
 	"principal-url":              new(PrincipalURL),
+	"principal-collection-set":   new(PrincipalCollectionSet),
 	"supported-report-set":       new(SupportedReportSet),
 	"acl":                        new(ACL),
 	"current-user-privilege-set": new(CurrentUserPrivilegeSet),
+	"supported-privilege-set":    new(SupportedPrivilegeSet),
 	"quota-available-bytes":      new(QuotaAvailableBytes),
 	"quota-used-bytes":           new(QuotaUsedBytes),
+	"sync-token":                 new(SyncToken),
+	"group-member-set":           new(GroupMemberSet),
+	"group-membership":           new(GroupMembership),
 
 	// CalDAV properties
 	"calendar-description":             new(CalendarDescription),
@@ -128,9 +161,12 @@ var PropNameToStruct = map[string]Property{
 	"shared-url":               new(SharedURL),
 	"invite":                   new(Invite),
 	"notification-url":         new(NotificationURL),
+	"notificationtype":         new(NotificationType),
 	"auto-schedule":            new(AutoSchedule),
 	"calendar-proxy-read-for":  new(CalendarProxyReadFor),
 	"calendar-proxy-write-for": new(CalendarProxyWriteFor),
+	"bulk-requests":            new(BulkRequests),
+	"calendar-availability":    new(CalendarAvailability),
 	"calendar-color":           new(CalendarColor),
 
 	// Google CalDAV Extensions
@@ -138,6 +174,15 @@ var PropNameToStruct = map[string]Property{
 	"timezone": new(Timezone),
 	"hidden":   new(Hidden),
 	"selected": new(Selected),
+
+	// libcaldora extensions
+	"max-calendars":       new(MaxCalendars),
+	"calendars-remaining": new(CalendarsRemaining),
+	"max-objects":         new(MaxObjects),
+	"objects-remaining":   new(ObjectsRemaining),
+	"archived":            new(Archived),
+	"week-start":          new(WeekStart),
+	"working-hours":       new(WorkingHours),
 }
 
 // createElement creates an element with the namespace prefix taken from the propPrefixMap.
@@ -158,3 +203,18 @@ func createElementWithPrefix(name, prefix string) *etree.Element {
 	elem.Space = prefix
 	return elem
 }
+
+// RawProperty carries a property element verbatim, for properties the props
+// package has no type for, such as dead properties.
+type RawProperty struct {
+	Element *etree.Element
+}
+
+func (p RawProperty) Encode() *etree.Element {
+	return p.Element.Copy()
+}
+
+func (p *RawProperty) Decode(elem *etree.Element) error {
+	p.Element = elem.Copy()
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/beevik/etree"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Helper function to create an XML element for testing
@@ -95,8 +96,9 @@ func TestCalendarPropsDecodeFunctions(t *testing.T) {
 			element: func() *etree.Element {
 				elem := etree.NewElement("supported-calendar-data")
 				elem.Space = "cal"
-				elem.SetText("text/calendar")
-				elem.CreateAttr("version", "2.0")
+				data := elem.CreateElement("cal:calendar-data")
+				data.CreateAttr("content-type", "text/calendar")
+				data.CreateAttr("version", "2.0")
 				return elem
 			}(),
 			property: &SupportedCalendarData{},
@@ -396,6 +398,12 @@ func TestWebDAVPropsDecodeFunctions(t *testing.T) {
 			property: &GetContentType{},
 			expected: "text/calendar; charset=utf-8",
 		},
+		{
+			name:     "GetContentLength",
+			element:  createTestElement("d", "getcontentlength", "2048", nil),
+			property: &GetContentLength{},
+			expected: int64(2048),
+		},
 
 		// Time properties
 		{
@@ -426,7 +434,7 @@ func TestWebDAVPropsDecodeFunctions(t *testing.T) {
 		},
 		{
 			name:     "PrincipalURL",
-			element:  createElementWithHrefChild("d", "principal-url", "/principals/users/alice/"),
+			element:  createElementWithHrefChild("d", "principal-URL", "/principals/users/alice/"),
 			property: &PrincipalURL{},
 			expected: "/principals/users/alice/",
 		},
@@ -657,6 +665,8 @@ func TestWebDAVPropsDecodeFunctions(t *testing.T) {
 				assert.Equal(t, tt.expected.(int64), prop.Value)
 			case *QuotaUsedBytes:
 				assert.Equal(t, tt.expected.(int64), prop.Value)
+			case *GetContentLength:
+				assert.Equal(t, tt.expected.(int64), prop.Value)
 			case *Resourcetype:
 				if rt, ok := tt.expected.(ResourceType); ok {
 					assert.Equal(t, rt, prop.Type)
@@ -711,6 +721,11 @@ func TestWebDAVPropsDecodeErrors(t *testing.T) {
 			element:  createTestElement("d", "quota-used-bytes", "not-a-number", nil),
 			property: &QuotaUsedBytes{},
 		},
+		{
+			name:     "GetContentLength_InvalidNumber",
+			element:  createTestElement("d", "getcontentlength", "not-a-number", nil),
+			property: &GetContentLength{},
+		},
 	}
 
 	for _, tt := range tests {
@@ -737,9 +752,14 @@ func TestWebDAVDecodeEncodeCycle(t *testing.T) {
 		&Owner{Value: "/principals/users/alice/"},
 		&CurrentUserPrincipal{Value: "/principals/users/alice/"},
 		&PrincipalURL{Value: "/principals/users/alice/"},
+		&PrincipalCollectionSet{Hrefs: []string{"/principals/"}},
 		&SupportedReportSet{Reports: []ReportType{ReportTypePropfind, ReportTypeCalendarQuery}},
 		&ACL{Aces: []ACE{{Principal: "/principals/users/alice/", Grant: []string{"read", "write"}}}},
 		&CurrentUserPrivilegeSet{Privileges: []string{"read", "write"}},
+		&SupportedPrivilegeSet{Privileges: []SupportedPrivilege{{Name: "all", Description: "Any operation", Children: []SupportedPrivilege{
+			{Name: "read"},
+			{Name: "write", Abstract: true, Children: []SupportedPrivilege{{Name: "bind"}}},
+		}}}},
 		&QuotaAvailableBytes{Value: 1073741824},
 		&QuotaUsedBytes{Value: 536870912},
 	}
@@ -768,12 +788,16 @@ func TestWebDAVDecodeEncodeCycle(t *testing.T) {
 				decoded = &CurrentUserPrincipal{}
 			case *PrincipalURL:
 				decoded = &PrincipalURL{}
+			case *PrincipalCollectionSet:
+				decoded = &PrincipalCollectionSet{}
 			case *SupportedReportSet:
 				decoded = &SupportedReportSet{}
 			case *ACL:
 				decoded = &ACL{}
 			case *CurrentUserPrivilegeSet:
 				decoded = &CurrentUserPrivilegeSet{}
+			case *SupportedPrivilegeSet:
+				decoded = &SupportedPrivilegeSet{}
 			case *QuotaAvailableBytes:
 				decoded = &QuotaAvailableBytes{}
 			case *QuotaUsedBytes:
@@ -1003,11 +1027,18 @@ func TestExtensionDecodeEncodeCycle(t *testing.T) {
 		&AutoSchedule{Value: true},
 		&CalendarProxyReadFor{Hrefs: []string{"/principals/users/manager/", "/principals/users/admin/"}},
 		&CalendarProxyWriteFor{Hrefs: []string{"/principals/users/assistant/"}},
+		&BulkRequests{MaxResources: 100, MaxBytes: 1048576},
+		&CalendarAvailability{Value: "BEGIN:VCALENDAR\r\nBEGIN:VAVAILABILITY\r\nEND:VAVAILABILITY\r\nEND:VCALENDAR"},
 		&CalendarColor{Value: "#FF5733"},
 		&Color{Value: "#33FF57"},
 		&Timezone{Value: "Europe/London"},
 		&Hidden{Value: true},
 		&Selected{Value: false},
+		&WeekStart{Value: "MO"},
+		&WorkingHours{Hours: []WorkingHoursPeriod{
+			{Days: []string{"MO", "TU", "WE", "TH", "FR"}, Start: "09:00", End: "17:00"},
+			{Days: []string{"SA"}, Start: "10:00", End: "12:30"},
+		}},
 	}
 
 	for _, original := range originalProperties {
@@ -1034,6 +1065,10 @@ func TestExtensionDecodeEncodeCycle(t *testing.T) {
 				decoded = &CalendarProxyReadFor{}
 			case *CalendarProxyWriteFor:
 				decoded = &CalendarProxyWriteFor{}
+			case *BulkRequests:
+				decoded = &BulkRequests{}
+			case *CalendarAvailability:
+				decoded = &CalendarAvailability{}
 			case *CalendarColor:
 				decoded = &CalendarColor{}
 			case *Color:
@@ -1044,6 +1079,10 @@ func TestExtensionDecodeEncodeCycle(t *testing.T) {
 				decoded = &Hidden{}
 			case *Selected:
 				decoded = &Selected{}
+			case *WeekStart:
+				decoded = &WeekStart{}
+			case *WorkingHours:
+				decoded = &WorkingHours{}
 			default:
 				t.Fatalf("Unexpected property type: %T", original)
 				return
@@ -1069,3 +1108,17 @@ func TestExtensionDecodeEncodeCycle(t *testing.T) {
 		})
 	}
 }
+
+func TestDisplayNameLang(t *testing.T) {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<d:prop xmlns:d="DAV:" xml:lang="de"><d:displayname>Arbeit</d:displayname></d:prop>`))
+	var p DisplayName
+	require.NoError(t, p.Decode(doc.Root().SelectElement("displayname")))
+	assert.Equal(t, DisplayName{Value: "Arbeit", Lang: "de"}, p)
+
+	out := etree.NewDocument()
+	out.SetRoot(p.Encode())
+	s, err := out.WriteToString()
+	require.NoError(t, err)
+	assert.Equal(t, `<d:displayname xml:lang="de">Arbeit</d:displayname>`, s)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/beevik/etree"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Helper function to convert element to string
@@ -135,12 +136,19 @@ func TestEncodeFunctions(t *testing.T) {
 			expectedTag:     "getcontenttype",
 			expectedContent: "text/calendar",
 		},
+		{
+			name:            "getContentLength",
+			property:        &GetContentLength{Value: 1024},
+			expectedPrefix:  "d",
+			expectedTag:     "getcontentlength",
+			expectedContent: "1024",
+		},
 		{
 			name:            "owner",
-			property:        &Owner{Value: "mailto:alice@example.com"},
+			property:        &Owner{Value: "/principals/users/alice/"},
 			expectedPrefix:  "d",
 			expectedTag:     "owner",
-			expectedContent: "mailto:alice@example.com",
+			expectedContent: "/principals/users/alice/",
 			hasHrefChild:    true,
 		},
 		{
@@ -155,7 +163,7 @@ func TestEncodeFunctions(t *testing.T) {
 			name:            "principalURL",
 			property:        &PrincipalURL{Value: "/principals/users/alice/"},
 			expectedPrefix:  "d",
-			expectedTag:     "principal-url",
+			expectedTag:     "principal-URL",
 			expectedContent: "/principals/users/alice/",
 			hasHrefChild:    true,
 		},
@@ -215,6 +223,62 @@ func TestEncodeFunctions(t *testing.T) {
 			expectedTag:     "quota-used-bytes",
 			expectedContent: "214748364",
 		},
+		{
+			name:            "syncToken",
+			property:        &SyncToken{Value: "urn:libcaldora:sync:AAAA"},
+			expectedPrefix:  "d",
+			expectedTag:     "sync-token",
+			expectedContent: "urn:libcaldora:sync:AAAA",
+		},
+		{
+			name:            "groupMemberSet",
+			property:        &GroupMemberSet{Hrefs: []string{"/caldav/u/alice/"}},
+			expectedPrefix:  "d",
+			expectedTag:     "group-member-set",
+			expectedContent: "<d:href>/caldav/u/alice/</d:href>",
+		},
+		{
+			name:            "groupMembership",
+			property:        &GroupMembership{Hrefs: []string{"/caldav/u/team/"}},
+			expectedPrefix:  "d",
+			expectedTag:     "group-membership",
+			expectedContent: "<d:href>/caldav/u/team/</d:href>",
+		},
+		{
+			name:            "maxCalendars",
+			property:        &MaxCalendars{Value: 20},
+			expectedPrefix:  "lc",
+			expectedTag:     "max-calendars",
+			expectedContent: "20",
+		},
+		{
+			name:            "objectsRemaining",
+			property:        &ObjectsRemaining{Value: 0},
+			expectedPrefix:  "lc",
+			expectedTag:     "objects-remaining",
+			expectedContent: "0",
+		},
+		{
+			name:            "archived",
+			property:        &Archived{Value: true},
+			expectedPrefix:  "lc",
+			expectedTag:     "archived",
+			expectedContent: "true",
+		},
+		{
+			name:            "weekStart",
+			property:        &WeekStart{Value: "SU"},
+			expectedPrefix:  "lc",
+			expectedTag:     "week-start",
+			expectedContent: "SU",
+		},
+		{
+			name:            "workingHours",
+			property:        &WorkingHours{Hours: []WorkingHoursPeriod{{Days: []string{"MO", "TU"}, Start: "09:00", End: "17:00"}}},
+			expectedPrefix:  "lc",
+			expectedTag:     "working-hours",
+			expectedContent: `<lc:hours days="MO,TU" start="09:00" end="17:00"/>`,
+		},
 
 		// Test cases for calendar-data property
 		{
@@ -271,7 +335,7 @@ func TestEncodeFunctions(t *testing.T) {
 			},
 			expectedPrefix:  "cal",
 			expectedTag:     "supported-calendar-data",
-			expectedContent: "text/calendar",
+			expectedContent: `<cal:calendar-data content-type="text/calendar" version="2.0"/>`,
 		},
 		{
 			name:            "maxResourceSize",
@@ -416,6 +480,20 @@ func TestEncodeFunctions(t *testing.T) {
 			expectedTag:     "calendar-proxy-write-for",
 			expectedContent: "<d:href>mailto:assistant@example.com</d:href>",
 		},
+		{
+			name:            "bulkRequests",
+			property:        &BulkRequests{MaxResources: 100, MaxBytes: 2048},
+			expectedPrefix:  "cs",
+			expectedTag:     "bulk-requests",
+			expectedContent: "<cs:simple><cs:max-resources>100</cs:max-resources><cs:max-bytes>2048</cs:max-bytes></cs:simple>",
+		},
+		{
+			name:            "calendarAvailability",
+			property:        &CalendarAvailability{Value: "BEGIN:VCALENDAR"},
+			expectedPrefix:  "cs",
+			expectedTag:     "calendar-availability",
+			expectedContent: "BEGIN:VCALENDAR",
+		},
 		{
 			name:            "calendarColor",
 			property:        &CalendarColor{Value: "#FF5733"},
@@ -485,7 +563,9 @@ func TestEncodeFunctions(t *testing.T) {
 
 			// For specific attribute checks (only for supported-calendar-data that has a version attribute)
 			if tt.name == "supportedCalendarData" {
-				assert.Equal(t, "2.0", elem.SelectAttrValue("version", ""), "Element should have version attribute set to 2.0")
+				data := elem.FindElement("./cal:calendar-data")
+				assert.NotNil(t, data, "Element should have calendar-data child element")
+				assert.Equal(t, "2.0", data.SelectAttrValue("version", ""), "calendar-data should have version attribute set to 2.0")
 			}
 		})
 	}
@@ -537,6 +617,7 @@ func TestSupportedReportSetNamespaces(t *testing.T) {
 			ReportTypeScheduleQuery,
 			ReportTypeScheduleMultiget,
 			ReportTypeSearch,
+			ReportTypeSyncCollection,
 		},
 	}
 
@@ -549,7 +630,7 @@ func TestSupportedReportSetNamespaces(t *testing.T) {
 
 	// Find all the supported-report elements
 	supportedReports := elem.ChildElements()
-	assert.Equal(t, 7, len(supportedReports), "Should have 7 supported-report elements")
+	assert.Equal(t, 8, len(supportedReports), "Should have 8 supported-report elements")
 
 	// Expected namespace prefixes for each report type
 	expectedPrefixes := map[ReportType]string{
@@ -560,6 +641,7 @@ func TestSupportedReportSetNamespaces(t *testing.T) {
 		ReportTypeScheduleQuery:    "cal",
 		ReportTypeScheduleMultiget: "cal",
 		ReportTypeSearch:           "d",
+		ReportTypeSyncCollection:   "d",
 	}
 
 	// Expected tag names for each report type
@@ -571,6 +653,7 @@ func TestSupportedReportSetNamespaces(t *testing.T) {
 		ReportTypeScheduleQuery:    "schedule-query",
 		ReportTypeScheduleMultiget: "schedule-multiget",
 		ReportTypeSearch:           "search",
+		ReportTypeSyncCollection:   "sync-collection",
 	}
 
 	// Verify each report type has the correct structure and namespace
@@ -607,3 +690,59 @@ func TestSupportedReportSetNamespaces(t *testing.T) {
 		assert.Equal(t, 1, reportCount[tag], "Should have exactly one %s report type", tag)
 	}
 }
+
+func TestSupportedPrivilegeSetEncode(t *testing.T) {
+	set := SupportedPrivilegeSet{Privileges: []SupportedPrivilege{{
+		Name:        "all",
+		Description: "Any operation",
+		Children:    []SupportedPrivilege{{Name: "read"}, {Name: "write", Abstract: true}},
+	}}}
+	elem := set.Encode()
+	assert.Equal(t, "d", elem.Space)
+
+	all := elem.SelectElement("supported-privilege")
+	require.NotNil(t, all)
+	assert.NotNil(t, all.FindElement("privilege/all"))
+	assert.Nil(t, all.SelectElement("abstract"))
+	desc := all.SelectElement("description")
+	require.NotNil(t, desc)
+	assert.Equal(t, "Any operation", desc.Text())
+	assert.Equal(t, "en", desc.SelectAttrValue("xml:lang", ""))
+
+	children := all.SelectElements("supported-privilege")
+	require.Len(t, children, 2)
+	assert.NotNil(t, children[0].FindElement("privilege/read"))
+	assert.NotNil(t, children[1].SelectElement("abstract"))
+}
+
+func TestNotificationProps(t *testing.T) {
+	elem := NotificationType{Value: "invite-notification"}.Encode()
+	assert.Equal(t, "cs", elem.Space)
+	assert.Equal(t, "notificationtype", elem.Tag)
+	require.Len(t, elem.ChildElements(), 1)
+	assert.Equal(t, "cs", elem.ChildElements()[0].Space)
+	assert.Equal(t, "invite-notification", elem.ChildElements()[0].Tag)
+	var decoded NotificationType
+	require.NoError(t, decoded.Decode(elem))
+	assert.Equal(t, "invite-notification", decoded.Value)
+
+	elem = Resourcetype{Type: ResourceCollection, ObjectType: "notification"}.Encode()
+	require.NotNil(t, elem.FindElement("collection"))
+	require.NotNil(t, elem.FindElement("notification"))
+	assert.Nil(t, elem.FindElement("calendar"))
+	var rt Resourcetype
+	require.NoError(t, rt.Decode(elem))
+	assert.Equal(t, Resourcetype{Type: ResourceCollection, ObjectType: "notification"}, rt)
+}
+
+func TestScheduleInboxResourcetype(t *testing.T) {
+	elem := Resourcetype{Type: ResourceCollection, ObjectType: "schedule-inbox"}.Encode()
+	require.NotNil(t, elem.FindElement("collection"))
+	inbox := elem.FindElement("schedule-inbox")
+	require.NotNil(t, inbox)
+	assert.Equal(t, "cal", inbox.Space)
+	assert.Nil(t, elem.FindElement("calendar"))
+	var rt Resourcetype
+	require.NoError(t, rt.Decode(elem))
+	assert.Equal(t, Resourcetype{Type: ResourceCollection, ObjectType: "schedule-inbox"}, rt)
+}
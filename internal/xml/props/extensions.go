@@ -1,6 +1,10 @@
 package props
 
-import "github.com/beevik/etree"
+import (
+	"strconv"
+
+	"github.com/beevik/etree"
+)
 
 // Apple CalendarServer Extensions
 
@@ -94,6 +98,25 @@ func (p *NotificationURL) Decode(elem *etree.Element) error {
 	return nil
 }
 
+// NotificationType is cs:notificationtype of a notification resource. Value
+// names the element the notification carries, e.g. "invite-notification".
+type NotificationType struct {
+	Value string
+}
+
+func (p NotificationType) Encode() *etree.Element {
+	elem := createElement("notificationtype")
+	elem.AddChild(createElementWithPrefix(p.Value, "cs"))
+	return elem
+}
+
+func (p *NotificationType) Decode(elem *etree.Element) error {
+	if children := elem.ChildElements(); len(children) > 0 {
+		p.Value = children[0].Tag
+	}
+	return nil
+}
+
 type AutoSchedule struct {
 	Value bool
 }
@@ -179,6 +202,62 @@ func (p *CalendarColor) Decode(elem *etree.Element) error {
 	return nil
 }
 
+// BulkRequests advertises CalendarServer bulk POST support (cs:bulk-requests).
+// The same limits are reported for both the "simple" and "crud" actions.
+type BulkRequests struct {
+	MaxResources int
+	MaxBytes     int
+}
+
+func (p BulkRequests) Encode() *etree.Element {
+	elem := createElement("bulk-requests")
+	for _, action := range []string{"simple", "crud"} {
+		actionElem := createElement(action)
+		elem.AddChild(actionElem)
+		maxResources := createElement("max-resources")
+		maxResources.SetText(strconv.Itoa(p.MaxResources))
+		actionElem.AddChild(maxResources)
+		maxBytes := createElement("max-bytes")
+		maxBytes.SetText(strconv.Itoa(p.MaxBytes))
+		actionElem.AddChild(maxBytes)
+	}
+	return elem
+}
+
+func (p *BulkRequests) Decode(elem *etree.Element) error {
+	action := elem.FindElement("crud")
+	if action == nil {
+		action = elem.FindElement("simple")
+	}
+	if action == nil {
+		return nil
+	}
+	if e := action.FindElement("max-resources"); e != nil {
+		p.MaxResources, _ = strconv.Atoi(e.Text())
+	}
+	if e := action.FindElement("max-bytes"); e != nil {
+		p.MaxBytes, _ = strconv.Atoi(e.Text())
+	}
+	return nil
+}
+
+// CalendarAvailability holds a user's published availability (cs:calendar-availability),
+// an iCalendar stream containing VAVAILABILITY components (RFC 7953).
+type CalendarAvailability struct {
+	Value string
+}
+
+func (p CalendarAvailability) Encode() *etree.Element {
+	elem := createElement("calendar-availability")
+	elem.SetText(p.Value)
+	return elem
+}
+
+func (p *CalendarAvailability) Decode(elem *etree.Element) error {
+	p.Value = elem.Text()
+	return nil
+}
+
 // Google CalDAV Extensions
 
 type Color struct {
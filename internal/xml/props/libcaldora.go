@@ -0,0 +1,144 @@
+package props
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// libcaldora extensions, reporting the limits configured on the server
+
+type MaxCalendars struct {
+	Value int
+}
+
+func (p MaxCalendars) Encode() *etree.Element {
+	return encodeInt("max-calendars", p.Value)
+}
+
+func (p *MaxCalendars) Decode(elem *etree.Element) error {
+	return decodeInt(elem, &p.Value)
+}
+
+type CalendarsRemaining struct {
+	Value int
+}
+
+func (p CalendarsRemaining) Encode() *etree.Element {
+	return encodeInt("calendars-remaining", p.Value)
+}
+
+func (p *CalendarsRemaining) Decode(elem *etree.Element) error {
+	return decodeInt(elem, &p.Value)
+}
+
+type MaxObjects struct {
+	Value int
+}
+
+func (p MaxObjects) Encode() *etree.Element {
+	return encodeInt("max-objects", p.Value)
+}
+
+func (p *MaxObjects) Decode(elem *etree.Element) error {
+	return decodeInt(elem, &p.Value)
+}
+
+type ObjectsRemaining struct {
+	Value int
+}
+
+func (p ObjectsRemaining) Encode() *etree.Element {
+	return encodeInt("objects-remaining", p.Value)
+}
+
+func (p *ObjectsRemaining) Decode(elem *etree.Element) error {
+	return decodeInt(elem, &p.Value)
+}
+
+type Archived struct {
+	Value bool
+}
+
+func (p Archived) Encode() *etree.Element {
+	elem := createElement("archived")
+	elem.SetText(strconv.FormatBool(p.Value))
+	return elem
+}
+
+func (p *Archived) Decode(elem *etree.Element) error {
+	text := elem.Text()
+	p.Value = text == "true" || text == "1"
+	return nil
+}
+
+// WeekStart is the first day of the user's week, as an iCalendar weekday
+// such as "MO" or "SU"
+type WeekStart struct {
+	Value string
+}
+
+func (p WeekStart) Encode() *etree.Element {
+	elem := createElement("week-start")
+	elem.SetText(p.Value)
+	return elem
+}
+
+func (p *WeekStart) Decode(elem *etree.Element) error {
+	p.Value = elem.Text()
+	return nil
+}
+
+// WorkingHours lists the user's working hours, encoded as
+// <lc:hours days="MO,TU" start="09:00" end="17:00"/> children
+type WorkingHours struct {
+	Hours []WorkingHoursPeriod
+}
+
+type WorkingHoursPeriod struct {
+	Days       []string
+	Start, End string
+}
+
+func (p WorkingHours) Encode() *etree.Element {
+	elem := createElement("working-hours")
+	for _, period := range p.Hours {
+		child := createElement("hours")
+		child.CreateAttr("days", strings.Join(period.Days, ","))
+		child.CreateAttr("start", period.Start)
+		child.CreateAttr("end", period.End)
+		elem.AddChild(child)
+	}
+	return elem
+}
+
+func (p *WorkingHours) Decode(elem *etree.Element) error {
+	p.Hours = nil
+	for _, child := range elem.SelectElements("hours") {
+		period := WorkingHoursPeriod{
+			Start: child.SelectAttrValue("start", ""),
+			End:   child.SelectAttrValue("end", ""),
+		}
+		if days := child.SelectAttrValue("days", ""); days != "" {
+			period.Days = strings.Split(days, ",")
+		}
+		p.Hours = append(p.Hours, period)
+	}
+	return nil
+}
+
+func encodeInt(name string, value int) *etree.Element {
+	elem := createElement(name)
+	elem.SetText(strconv.Itoa(value))
+	return elem
+}
+
+func decodeInt(elem *etree.Element, value *int) error {
+	val, err := strconv.Atoi(elem.Text())
+	if err != nil {
+		return err
+	}
+	*value = val
+	return nil
+}
@@ -10,19 +10,36 @@ import (
 
 type DisplayName struct {
 	Value string
+	// Lang is the BCP 47 language tag of Value, sent as xml:lang
+	Lang string
 }
 
 func (p DisplayName) Encode() *etree.Element {
 	elem := createElement("displayname")
 	elem.SetText(p.Value)
+	if p.Lang != "" {
+		elem.CreateAttr("xml:lang", p.Lang)
+	}
 	return elem
 }
 
 func (p *DisplayName) Decode(elem *etree.Element) error {
 	p.Value = elem.Text()
+	p.Lang = xmlLang(elem)
 	return nil
 }
 
+// xmlLang returns the xml:lang in scope for elem, which it may inherit from
+// an ancestor.
+func xmlLang(elem *etree.Element) string {
+	for e := elem; e != nil; e = e.Parent() {
+		if attr := e.SelectAttr("xml:lang"); attr != nil {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
 type Resourcetype struct {
 	// Primary resource type from storage package
 	Type ResourceType
@@ -62,6 +79,16 @@ func (p Resourcetype) Encode() *etree.Element {
 		collElem := createElement("collection")
 		elem.AddChild(collElem)
 
+		// Notification collection: <d:resourcetype><d:collection/><cs:notification/></d:resourcetype>
+		if p.ObjectType == "notification" {
+			elem.AddChild(createElementWithPrefix("notification", "cs"))
+			break
+		}
+		// Scheduling inbox: <d:resourcetype><d:collection/><cal:schedule-inbox/></d:resourcetype>
+		if p.ObjectType == "schedule-inbox" {
+			elem.AddChild(createElementWithPrefix("schedule-inbox", "cal"))
+			break
+		}
 		calElem := createElement("calendar")
 		elem.AddChild(calElem)
 
@@ -113,6 +140,16 @@ func (p *Resourcetype) Decode(elem *etree.Element) error {
 			p.Type = ResourceCollection
 			return nil
 		}
+		if elem.FindElement("notification") != nil {
+			p.Type = ResourceCollection
+			p.ObjectType = "notification"
+			return nil
+		}
+		if elem.FindElement("schedule-inbox") != nil {
+			p.Type = ResourceCollection
+			p.ObjectType = "schedule-inbox"
+			return nil
+		}
 	}
 
 	// Handle object types (VEVENT, VTODO, etc.)
@@ -181,6 +218,26 @@ func (p *GetContentType) Decode(elem *etree.Element) error {
 	return nil
 }
 
+// GetContentLength is the size in bytes of the body a GET of the resource returns.
+type GetContentLength struct {
+	Value int64
+}
+
+func (p GetContentLength) Encode() *etree.Element {
+	elem := createElement("getcontentlength")
+	elem.SetText(strconv.FormatInt(p.Value, 10))
+	return elem
+}
+
+func (p *GetContentLength) Decode(elem *etree.Element) error {
+	val, err := strconv.ParseInt(elem.Text(), 10, 64)
+	if err != nil {
+		return err
+	}
+	p.Value = val
+	return nil
+}
+
 type Owner struct {
 	Value string
 }
@@ -203,10 +260,17 @@ func (p *Owner) Decode(elem *etree.Element) error {
 
 type CurrentUserPrincipal struct {
 	Value string
+	// Unauthenticated encodes DAV:unauthenticated instead of an href, for
+	// requests without credentials (RFC 5397 section 3)
+	Unauthenticated bool
 }
 
 func (p CurrentUserPrincipal) Encode() *etree.Element {
 	elem := createElement("current-user-principal")
+	if p.Unauthenticated {
+		elem.AddChild(createElement("unauthenticated"))
+		return elem
+	}
 	href := createElement("href")
 	href.SetText(p.Value)
 	elem.AddChild(href)
@@ -218,15 +282,18 @@ func (p *CurrentUserPrincipal) Decode(elem *etree.Element) error {
 	if href != nil {
 		p.Value = href.Text()
 	}
+	p.Unauthenticated = elem.FindElement("unauthenticated") != nil
 	return nil
 }
 
+// PrincipalURL is DAV:principal-URL, the one property name RFC 3744 spells in
+// mixed case. Requests are matched case-insensitively.
 type PrincipalURL struct {
 	Value string
 }
 
 func (p PrincipalURL) Encode() *etree.Element {
-	elem := createElement("principal-url")
+	elem := createElement("principal-URL")
 	hrefElem := createElement("href")
 	elem.AddChild(hrefElem)
 	hrefElem.SetText(p.Value)
@@ -241,6 +308,30 @@ func (p *PrincipalURL) Decode(elem *etree.Element) error {
 	return nil
 }
 
+// PrincipalCollectionSet lists the collections holding principals (RFC 3744
+// section 5.8), where clients run principal-property-search.
+type PrincipalCollectionSet struct {
+	Hrefs []string
+}
+
+func (p PrincipalCollectionSet) Encode() *etree.Element {
+	elem := createElement("principal-collection-set")
+	for _, href := range p.Hrefs {
+		hrefElem := createElement("href")
+		hrefElem.SetText(href)
+		elem.AddChild(hrefElem)
+	}
+	return elem
+}
+
+func (p *PrincipalCollectionSet) Decode(elem *etree.Element) error {
+	p.Hrefs = nil
+	for _, hrefElem := range elem.SelectElements("href") {
+		p.Hrefs = append(p.Hrefs, hrefElem.Text())
+	}
+	return nil
+}
+
 type SupportedReportSet struct {
 	Reports []ReportType
 }
@@ -255,6 +346,7 @@ const (
 	ReportTypeScheduleQuery
 	ReportTypeScheduleMultiget
 	ReportTypeSearch
+	ReportTypeSyncCollection
 )
 
 func (p SupportedReportSet) Encode() *etree.Element {
@@ -286,6 +378,8 @@ func (p SupportedReportSet) Encode() *etree.Element {
 			reportTypeElem = createElement("schedule-multiget")
 		case ReportTypeSearch:
 			reportTypeElem = createElement("search")
+		case ReportTypeSyncCollection:
+			reportTypeElem = createElement("sync-collection")
 		}
 
 		if reportTypeElem != nil {
@@ -329,6 +423,9 @@ func (p *SupportedReportSet) Decode(elem *etree.Element) error {
 		if reportElem.FindElement("search") != nil {
 			p.Reports = append(p.Reports, ReportTypeSearch)
 		}
+		if reportElem.FindElement("sync-collection") != nil {
+			p.Reports = append(p.Reports, ReportTypeSyncCollection)
+		}
 	}
 
 	return nil
@@ -468,6 +565,77 @@ func (p *CurrentUserPrivilegeSet) Decode(elem *etree.Element) error {
 	return nil
 }
 
+// SupportedPrivilege is one node of the privilege tree in
+// DAV:supported-privilege-set (RFC 3744 section 5.3). Children are the
+// privileges it aggregates.
+type SupportedPrivilege struct {
+	Name        string
+	Abstract    bool
+	Description string
+	Children    []SupportedPrivilege
+}
+
+func (p SupportedPrivilege) encode() *etree.Element {
+	elem := createElement("supported-privilege")
+	privElem := createElement("privilege")
+	privElem.AddChild(createElement(p.Name))
+	elem.AddChild(privElem)
+	if p.Abstract {
+		elem.AddChild(createElement("abstract"))
+	}
+	if p.Description != "" {
+		desc := createElement("description")
+		desc.CreateAttr("xml:lang", "en")
+		desc.SetText(p.Description)
+		elem.AddChild(desc)
+	}
+	for _, child := range p.Children {
+		elem.AddChild(child.encode())
+	}
+	return elem
+}
+
+func (p *SupportedPrivilege) decode(elem *etree.Element) {
+	if privElem := elem.FindElement("privilege"); privElem != nil {
+		if children := privElem.ChildElements(); len(children) > 0 {
+			p.Name = children[0].Tag
+		}
+	}
+	p.Abstract = elem.FindElement("abstract") != nil
+	if desc := elem.FindElement("description"); desc != nil {
+		p.Description = desc.Text()
+	}
+	for _, childElem := range elem.SelectElements("supported-privilege") {
+		var child SupportedPrivilege
+		child.decode(childElem)
+		p.Children = append(p.Children, child)
+	}
+}
+
+// SupportedPrivilegeSet describes the privileges a resource supports, so ACL
+// editors know which ones they can offer.
+type SupportedPrivilegeSet struct {
+	Privileges []SupportedPrivilege
+}
+
+func (p SupportedPrivilegeSet) Encode() *etree.Element {
+	elem := createElement("supported-privilege-set")
+	for _, priv := range p.Privileges {
+		elem.AddChild(priv.encode())
+	}
+	return elem
+}
+
+func (p *SupportedPrivilegeSet) Decode(elem *etree.Element) error {
+	p.Privileges = nil
+	for _, privElem := range elem.SelectElements("supported-privilege") {
+		var priv SupportedPrivilege
+		priv.decode(privElem)
+		p.Privileges = append(p.Privileges, priv)
+	}
+	return nil
+}
+
 type QuotaAvailableBytes struct {
 	Value int64
 }
@@ -505,3 +673,65 @@ func (p *QuotaUsedBytes) Decode(elem *etree.Element) error {
 	p.Value = val
 	return nil
 }
+
+type SyncToken struct {
+	Value string
+}
+
+func (p SyncToken) Encode() *etree.Element {
+	elem := createElement("sync-token")
+	elem.SetText(p.Value)
+	return elem
+}
+
+func (p *SyncToken) Decode(elem *etree.Element) error {
+	p.Value = elem.Text()
+	return nil
+}
+
+// GroupMemberSet lists the principal URLs of a group's members (RFC 3744 section 4.3).
+type GroupMemberSet struct {
+	Hrefs []string
+}
+
+func (p GroupMemberSet) Encode() *etree.Element {
+	return encodeHrefs("group-member-set", p.Hrefs)
+}
+
+func (p *GroupMemberSet) Decode(elem *etree.Element) error {
+	p.Hrefs = decodeHrefs(elem)
+	return nil
+}
+
+// GroupMembership lists the principal URLs of the groups a principal is a
+// direct member of (RFC 3744 section 4.4).
+type GroupMembership struct {
+	Hrefs []string
+}
+
+func (p GroupMembership) Encode() *etree.Element {
+	return encodeHrefs("group-membership", p.Hrefs)
+}
+
+func (p *GroupMembership) Decode(elem *etree.Element) error {
+	p.Hrefs = decodeHrefs(elem)
+	return nil
+}
+
+func encodeHrefs(name string, hrefs []string) *etree.Element {
+	elem := createElement(name)
+	for _, href := range hrefs {
+		hrefElem := createElement("href")
+		hrefElem.SetText(href)
+		elem.AddChild(hrefElem)
+	}
+	return elem
+}
+
+func decodeHrefs(elem *etree.Element) []string {
+	hrefs := []string{}
+	for _, href := range elem.FindElements("href") {
+		hrefs = append(hrefs, href.Text())
+	}
+	return hrefs
+}
@@ -0,0 +1,78 @@
+package search
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/samber/mo"
+)
+
+// Query holds the search parameters of a DAV:search REPORT.
+type Query struct {
+	// Text is the free-text query
+	Text string
+	// Components restricts results to these component names (e.g. "VEVENT"); empty means any
+	Components []string
+	// Limit caps the number of results; 0 means no limit
+	Limit int
+}
+
+// ParseRequest parses a search REPORT body of the form
+//
+//	<D:search xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+//	  <D:prop><D:getetag/></D:prop>
+//	  <D:text>standup</D:text>
+//	  <C:comp name="VEVENT"/>
+//	  <D:limit><D:nresults>50</D:nresults></D:limit>
+//	</D:search>
+//
+// and returns the requested properties and the query.
+func ParseRequest(xmlStr string) (propfind.ResponseMap, Query, error) {
+	propsMap := make(propfind.ResponseMap)
+	var query Query
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil {
+		return propsMap, query, err
+	}
+	root := doc.FindElement("//search")
+	if root == nil {
+		return propsMap, query, errors.New("invalid search request: missing search element")
+	}
+
+	if propElem := root.FindElement("prop"); propElem != nil {
+		for _, elem := range propElem.ChildElements() {
+			localName := strings.ToLower(elem.Tag)
+			if structPtr, exists := props.PropNameToStruct[localName]; exists {
+				propsMap[localName] = mo.Ok(structPtr)
+			}
+		}
+	}
+
+	if textElem := root.FindElement("text"); textElem != nil {
+		query.Text = strings.TrimSpace(textElem.Text())
+	}
+	if query.Text == "" {
+		return propsMap, query, errors.New("invalid search request: empty text")
+	}
+
+	for _, comp := range root.FindElements("comp") {
+		if name := comp.SelectAttrValue("name", ""); name != "" {
+			query.Components = append(query.Components, strings.ToUpper(name))
+		}
+	}
+
+	if nresults := root.FindElement("limit/nresults"); nresults != nil {
+		limit, err := strconv.Atoi(strings.TrimSpace(nresults.Text()))
+		if err != nil || limit < 0 {
+			return propsMap, query, errors.New("invalid search request: bad nresults")
+		}
+		query.Limit = limit
+	}
+
+	return propsMap, query, nil
+}
@@ -0,0 +1,42 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequest(t *testing.T) {
+	req, query, err := ParseRequest(`<?xml version="1.0" encoding="utf-8"?>
+<D:search xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+    <C:calendar-data/>
+  </D:prop>
+  <D:text> weekly standup </D:text>
+  <C:comp name="vevent"/>
+  <C:comp name="VTODO"/>
+  <D:limit><D:nresults>25</D:nresults></D:limit>
+</D:search>`)
+	require.NoError(t, err)
+
+	assert.Contains(t, req, "getetag")
+	assert.Contains(t, req, "calendar-data")
+	assert.Equal(t, Query{Text: "weekly standup", Components: []string{"VEVENT", "VTODO"}, Limit: 25}, query)
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	for name, body := range map[string]string{
+		"malformed":      `<D:search`,
+		"wrong root":     `<D:propfind xmlns:D="DAV:"/>`,
+		"empty text":     `<D:search xmlns:D="DAV:"><D:text> </D:text></D:search>`,
+		"bad nresults":   `<D:search xmlns:D="DAV:"><D:text>x</D:text><D:limit><D:nresults>many</D:nresults></D:limit></D:search>`,
+		"negative limit": `<D:search xmlns:D="DAV:"><D:text>x</D:text><D:limit><D:nresults>-1</D:nresults></D:limit></D:search>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ParseRequest(body)
+			assert.Error(t, err)
+		})
+	}
+}
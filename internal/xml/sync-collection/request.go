@@ -0,0 +1,67 @@
+package synccollection
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/samber/mo"
+)
+
+// Query holds the parameters of a sync-collection REPORT.
+type Query struct {
+	// Token is the sync token sent by the client; empty for an initial sync
+	Token string
+	// Level is the sync-level, "1" or "infinite"
+	Level string
+}
+
+// ParseRequest parses a sync-collection REPORT body (RFC 6578) of the form
+//
+//	<D:sync-collection xmlns:D="DAV:">
+//	  <D:sync-token>urn:libcaldora:sync:...</D:sync-token>
+//	  <D:sync-level>1</D:sync-level>
+//	  <D:prop><D:getetag/></D:prop>
+//	</D:sync-collection>
+//
+// and returns the requested properties and the query.
+func ParseRequest(xmlStr string) (propfind.ResponseMap, Query, error) {
+	propsMap := make(propfind.ResponseMap)
+	var query Query
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlStr); err != nil {
+		return propsMap, query, err
+	}
+	root := doc.FindElement("//sync-collection")
+	if root == nil {
+		return propsMap, query, errors.New("invalid sync-collection request: missing sync-collection element")
+	}
+
+	if propElem := root.FindElement("prop"); propElem != nil {
+		for _, elem := range propElem.ChildElements() {
+			localName := strings.ToLower(elem.Tag)
+			if structPtr, exists := props.PropNameToStruct[localName]; exists {
+				propsMap[localName] = mo.Ok(structPtr)
+			}
+		}
+	}
+
+	tokenElem := root.FindElement("sync-token")
+	if tokenElem == nil {
+		return propsMap, query, errors.New("invalid sync-collection request: missing sync-token")
+	}
+	query.Token = strings.TrimSpace(tokenElem.Text())
+
+	query.Level = "1"
+	if levelElem := root.FindElement("sync-level"); levelElem != nil {
+		query.Level = strings.TrimSpace(levelElem.Text())
+	}
+	if query.Level != "1" && query.Level != "infinite" {
+		return propsMap, query, errors.New("invalid sync-collection request: bad sync-level")
+	}
+
+	return propsMap, query, nil
+}
@@ -0,0 +1,43 @@
+package synccollection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequest(t *testing.T) {
+	req, query, err := ParseRequest(`<?xml version="1.0" encoding="utf-8"?>
+<D:sync-collection xmlns:D="DAV:">
+  <D:sync-token> urn:libcaldora:sync:AAAA </D:sync-token>
+  <D:sync-level>1</D:sync-level>
+  <D:prop>
+    <D:getetag/>
+  </D:prop>
+</D:sync-collection>`)
+	require.NoError(t, err)
+
+	assert.Contains(t, req, "getetag")
+	assert.Equal(t, Query{Token: "urn:libcaldora:sync:AAAA", Level: "1"}, query)
+}
+
+func TestParseRequestInitialSync(t *testing.T) {
+	_, query, err := ParseRequest(`<D:sync-collection xmlns:D="DAV:"><D:sync-token/><D:prop/></D:sync-collection>`)
+	require.NoError(t, err)
+	assert.Equal(t, Query{Level: "1"}, query)
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	for name, body := range map[string]string{
+		"malformed":     `<D:sync-collection`,
+		"wrong root":    `<D:propfind xmlns:D="DAV:"/>`,
+		"missing token": `<D:sync-collection xmlns:D="DAV:"><D:sync-level>1</D:sync-level></D:sync-collection>`,
+		"bad level":     `<D:sync-collection xmlns:D="DAV:"><D:sync-token/><D:sync-level>2</D:sync-level></D:sync-collection>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ParseRequest(body)
+			assert.Error(t, err)
+		})
+	}
+}
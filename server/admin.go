@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// AdminHandler exposes maintenance operations over HTTP. It is not mounted by
+// CaldavHandler; embedders opt in by registering it on their own mux, e.g.
+//
+//	admin := &server.AdminHandler{Handler: caldavHandler, Authorize: isOperator}
+//	http.Handle("/admin/", http.StripPrefix("/admin", admin))
+//
+// Supported endpoints (the target user is passed as the "user" query parameter):
+//
+//	GET  /export      returns the user's snapshot as JSON
+//	POST /import      imports a snapshot from the request body
+//	GET  /duplicates  lists UIDs stored more than once, as JSON
+//	POST /duplicates  resolves them with ?strategy=keep-newest, keep-oldest or merge
+type AdminHandler struct {
+	Handler *CaldavHandler
+	// Authorize decides whether the request may use the admin API.
+	// A nil Authorize rejects every request.
+	Authorize func(r *http.Request) bool
+}
+
+// ServeHTTP routes admin requests.
+func (a *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := a.Handler.Logger
+	if a.Authorize == nil || !a.Authorize(r) {
+		logger.Warn("unauthorized admin request",
+			"method", r.Method,
+			"path", r.URL.Path)
+		a.Handler.writeError(w, r, http.StatusForbidden, CodeForbidden)
+		return
+	}
+
+	userID := r.URL.Query().Get("user")
+	if userID == "" {
+		a.Handler.writeError(w, r, http.StatusBadRequest, CodeMissingUser)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/export") && r.Method == http.MethodGet:
+		a.handleExport(w, r, userID)
+	case strings.HasSuffix(r.URL.Path, "/import") && r.Method == http.MethodPost:
+		a.handleImport(w, r, userID)
+	case strings.HasSuffix(r.URL.Path, "/duplicates") && r.Method == http.MethodGet:
+		a.handleFindDuplicates(w, r, userID)
+	case strings.HasSuffix(r.URL.Path, "/duplicates") && r.Method == http.MethodPost:
+		a.handleResolveDuplicates(w, r, userID)
+	default:
+		a.Handler.writeError(w, r, http.StatusNotFound, CodeNotFound)
+	}
+}
+
+func (a *AdminHandler) handleExport(w http.ResponseWriter, r *http.Request, userID string) {
+	snap, err := a.Handler.ExportUser(userID)
+	if err != nil {
+		a.Handler.Logger.Error("failed to export user",
+			"user_id", userID,
+			"error", err)
+		a.Handler.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := storage.WriteSnapshot(w, snap); err != nil {
+		a.Handler.Logger.Error("failed to write snapshot",
+			"error", err)
+	}
+}
+
+func (a *AdminHandler) handleImport(w http.ResponseWriter, r *http.Request, userID string) {
+	snap, err := storage.ReadSnapshot(r.Body)
+	if err != nil {
+		a.Handler.Logger.Warn("invalid snapshot in import request",
+			"error", err)
+		a.Handler.writeError(w, r, http.StatusBadRequest, CodeInvalidSnapshot)
+		return
+	}
+	opts := ImportOptions{
+		KeepETags:             r.URL.Query().Get("keep_etags") == "true",
+		SkipExistingCalendars: r.URL.Query().Get("skip_existing") == "true",
+	}
+	if err := a.Handler.ImportUser(userID, snap, opts); err != nil {
+		a.Handler.Logger.Error("failed to import user",
+			"user_id", userID,
+			"error", err)
+		a.Handler.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminHandler) handleFindDuplicates(w http.ResponseWriter, r *http.Request, userID string) {
+	dups, err := a.Handler.FindDuplicates(userID)
+	if err != nil {
+		a.Handler.Logger.Error("failed to find duplicates",
+			"user_id", userID,
+			"error", err)
+		a.Handler.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+	if dups == nil {
+		dups = []Duplicate{}
+	}
+	a.writeJSON(w, dups)
+}
+
+func (a *AdminHandler) handleResolveDuplicates(w http.ResponseWriter, r *http.Request, userID string) {
+	strategy := DuplicateStrategy(r.URL.Query().Get("strategy"))
+	switch strategy {
+	case DuplicateKeepNewest, DuplicateKeepOldest, DuplicateMerge:
+	default:
+		a.Handler.writeError(w, r, http.StatusBadRequest, CodeInvalidStrategy)
+		return
+	}
+	results, err := a.Handler.ResolveDuplicates(userID, strategy)
+	if err != nil {
+		a.Handler.Logger.Error("failed to resolve duplicates",
+			"user_id", userID,
+			"strategy", strategy,
+			"error", err)
+		a.Handler.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+	a.writeJSON(w, results)
+}
+
+func (a *AdminHandler) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		a.Handler.Logger.Error("failed to write admin response",
+			"error", err)
+	}
+}
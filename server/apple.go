@@ -0,0 +1,86 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// Apple's extensions for locations and travel time. iOS and macOS show the
+// structured location on a map and, with travel advisories enabled, alert
+// when it's time to leave. The server stores them like any other property;
+// these names let applications read them.
+const (
+	PropAppleStructuredLocation = "X-APPLE-STRUCTURED-LOCATION"
+	PropAppleTravelAdvisory     = "X-APPLE-TRAVEL-ADVISORY-BEHAVIOR"
+	PropAppleTravelDuration     = "X-APPLE-TRAVEL-DURATION"
+)
+
+// StructuredLocation is the place an X-APPLE-STRUCTURED-LOCATION points at.
+type StructuredLocation struct {
+	Title   string // X-TITLE, usually the first line of LOCATION
+	Address string // X-ADDRESS, lines separated by "\n"
+	// Latitude and Longitude come from the geo: URI of the value
+	Latitude, Longitude float64
+	Radius              float64 // X-APPLE-RADIUS in meters, 0 when unset
+}
+
+// ParseStructuredLocation reads the X-APPLE-STRUCTURED-LOCATION of comp. It
+// reports false when comp has none, or one without a geo: URI.
+func ParseStructuredLocation(comp *ical.Component) (*StructuredLocation, bool) {
+	prop := comp.Props.Get(PropAppleStructuredLocation)
+	if prop == nil {
+		return nil, false
+	}
+	coords, ok := strings.CutPrefix(strings.ToLower(prop.Value), "geo:")
+	if !ok {
+		return nil, false
+	}
+	coords, _, _ = strings.Cut(coords, ";")
+	parts := strings.Split(coords, ",")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	lat, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil, false
+	}
+	lon, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return nil, false
+	}
+	loc := &StructuredLocation{
+		Title:     appleParam(prop, "X-TITLE"),
+		Address:   appleParam(prop, "X-ADDRESS"),
+		Latitude:  lat,
+		Longitude: lon,
+	}
+	if radius, err := strconv.ParseFloat(prop.Params.Get("X-APPLE-RADIUS"), 64); err == nil {
+		loc.Radius = radius
+	}
+	return loc, true
+}
+
+// appleParam returns a parameter with the "\n" escapes Apple writes in them
+// turned into newlines.
+func appleParam(prop *ical.Prop, name string) string {
+	value := prop.Params.Get(name)
+	value = strings.ReplaceAll(value, `\\n`, "\n")
+	return strings.ReplaceAll(value, `\n`, "\n")
+}
+
+// travelTime reads the travel time Apple clients block before comp, and
+// whether they alert when it's time to leave.
+func travelTime(comp *ical.Component) (duration time.Duration, advisory string) {
+	if prop := comp.Props.Get(PropAppleTravelDuration); prop != nil {
+		if d, err := prop.Duration(); err == nil && d > 0 {
+			duration = d
+		}
+	}
+	if prop := comp.Props.Get(PropAppleTravelAdvisory); prop != nil {
+		advisory = strings.ToUpper(prop.Value)
+	}
+	return duration, advisory
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStructuredLocation(t *testing.T) {
+	_, comps := readXPropSample(t, "apple")
+	loc, ok := ParseStructuredLocation(comps[0])
+	require.True(t, ok)
+	assert.Equal(t, &StructuredLocation{
+		Title:     "Markthalle Neun",
+		Address:   "Eisenbahnstraße 42/43\n10997 Berlin\nGermany",
+		Latitude:  52.502106,
+		Longitude: 13.431985,
+		Radius:    70.58,
+	}, loc)
+
+	event := ical.NewEvent()
+	_, ok = ParseStructuredLocation(event.Component)
+	assert.False(t, ok)
+	event.Props.SetText(PropAppleStructuredLocation, "https://maps.example.com/")
+	_, ok = ParseStructuredLocation(event.Component)
+	assert.False(t, ok, "only geo: URIs locate a place")
+}
+
+func TestInstancesSurfaceAppleLocation(t *testing.T) {
+	_, comps := readXPropSample(t, "apple")
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	from := time.Date(2024, 3, 15, 0, 0, 0, 0, berlin)
+
+	instances, err := objectInstances(recurrence.NewEngineWithoutCache(), &storage.CalendarObject{Component: comps}, from, from.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.NotNil(t, instances[0].Location)
+	assert.Equal(t, "Markthalle Neun", instances[0].Location.Title)
+	assert.Equal(t, 20*time.Minute, instances[0].TravelTime)
+	assert.Equal(t, "AUTOMATIC", instances[0].TravelAdvisory)
+
+	_, comps = readXPropSample(t, "google")
+	instances, err = objectInstances(recurrence.NewEngineWithoutCache(), &storage.CalendarObject{Component: comps}, time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 9, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Nil(t, instances[0].Location)
+	assert.Zero(t, instances[0].TravelTime)
+}
+
+func TestAppleLocationSurvivesWrites(t *testing.T) {
+	h := newXPropHandler(t)
+	h.StrictObjects = true
+	h.SkipUnchangedPuts = true
+	h.NormalizePolicy = NormalizeScheduling
+	data, _ := readXPropSample(t, "apple")
+	put := func(data string) string {
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/lunch.ics", data, map[string]string{"Content-Type": "text/calendar"})
+		require.Less(t, w.Code, 300, w.Body.String())
+		return w.Header().Get("ETag")
+	}
+	put(data)
+
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/lunch.ics", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	stored := decodeComponents(t, w.Body.String())[0]
+	for _, name := range []string{PropAppleStructuredLocation, PropAppleTravelAdvisory, PropAppleTravelDuration} {
+		assert.NotNil(t, stored.Props.Get(name), "normalization keeps %s", name)
+	}
+
+	h.NormalizePolicy = NormalizeNone
+	first := put(data)
+	assert.Equal(t, first, put(data), "unchanged")
+	// Turning travel advisories off is a change of its own
+	second := put(strings.Replace(data, "X-APPLE-TRAVEL-ADVISORY-BEHAVIOR:AUTOMATIC", "X-APPLE-TRAVEL-ADVISORY-BEHAVIOR:DISABLED", 1))
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// checkNotArchived reports whether the calendar holding res accepts writes,
+// answering the request itself with 403 and an lc:calendar-not-archived
+// precondition when the calendar is archived. A calendar that doesn't exist
+// is left for the write itself to report.
+func (h *CaldavHandler) checkNotArchived(w http.ResponseWriter, r *http.Request, res Resource) bool {
+	cal, err := h.Storage.GetCalendar(res.UserID, res.CalendarID)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && cal == nil) {
+		return true
+	} else if err != nil {
+		h.Logger.Error("failed to get calendar for archive check",
+			"calendar_id", res.CalendarID,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return false
+	}
+	if cal.Archived {
+		h.Logger.Warn("write to archived calendar rejected",
+			"user_id", res.UserID,
+			"calendar_id", res.CalendarID)
+		h.writePrecondition(w, r, http.StatusForbidden, "lc:calendar-not-archived", "calendar is archived and read-only")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestArchivedCalendar(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	data := ical.NewCalendar()
+	data.Props.SetText(ical.PropProductID, "-//test//EN")
+	data.Props.SetText(ical.PropVersion, "2.0")
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work", Archived: true, CalendarData: data}, nil)
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "1")
+	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC))
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/a.ics", ETag: "etag", Component: []*ical.Component{event.Component}}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250101T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	header := map[string]string{"Content-Type": "text/calendar"}
+	color := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:A="http://apple.com/ns/ical/"><D:set><D:prop><A:calendar-color>#FF0000</A:calendar-color></D:prop></D:set></D:propertyupdate>`
+	dead := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:example"><D:set><D:prop><X:note>hi</X:note></D:prop></D:set></D:propertyupdate>`
+	for _, tc := range []struct{ method, path, body string }{
+		{http.MethodPut, "/caldav/alice/cal/work/a.ics", ics},
+		{http.MethodDelete, "/caldav/alice/cal/work/a.ics", ""},
+		{http.MethodPost, "/caldav/alice/cal/work?action=simple", ics},
+		{"PROPPATCH", "/caldav/alice/cal/work", color},
+		{"PROPPATCH", "/caldav/alice/cal/work/a.ics", dead},
+	} {
+		w := serveAlice(h, tc.method, tc.path, tc.body, header)
+		assert.Equal(t, http.StatusForbidden, w.Code, tc.method)
+		assert.Contains(t, w.Body.String(), "<lc:calendar-not-archived/>", tc.method)
+	}
+	mockStorage.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+	mockStorage.AssertNotCalled(t, "DeleteObject", mock.Anything, mock.Anything, mock.Anything)
+
+	// Still readable, and advertised as archived and read-only
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/a.ics", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:L="https://github.com/cyp0633/libcaldora/ns/"><D:prop><L:archived/><D:current-user-privilege-set/></D:prop></D:propfind>`
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<lc:archived>true</lc:archived>")
+	assert.NotContains(t, w.Body.String(), "<d:write/>")
+}
@@ -2,23 +2,134 @@ package server
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+
+	"github.com/cyp0633/libcaldora/internal/davpath"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// no credentials it understands, so the next authenticator gets a turn.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator identifies the principal of a request by other means than
+// Basic authentication, e.g. client certificates. Authenticators in
+// CaldavHandler.Authenticators are tried in order before Basic
+// authentication.
+type Authenticator interface {
+	// Authenticate returns the user ID the request acts as. It returns
+	// ErrNoCredentials when the request carries no credentials for this
+	// authenticator; any other error rejects the request with 401.
+	Authenticate(r *http.Request) (string, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(r *http.Request) (string, error)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// AnonymousMode selects how the handler treats requests without credentials.
+type AnonymousMode int
+
+const (
+	// AnonymousDeny answers every request without credentials with 401 and a
+	// WWW-Authenticate challenge. This is the default.
+	AnonymousDeny AnonymousMode = iota
+	// AnonymousUnauthenticated lets PROPFIND and OPTIONS on the service root
+	// through, reporting DAV:unauthenticated as current-user-principal, so
+	// clients can discover that they need to log in. Everything else gets 401.
+	AnonymousUnauthenticated
+	// AnonymousPrincipal serves requests without credentials as
+	// CaldavHandler.AnonymousUser, for public deployments. Only reading
+	// methods are allowed; writes get 401 so clients prompt for a login.
+	AnonymousPrincipal
 )
 
-// checkAuth enforces Basic Authentication. Returns the user ID and true if successful.
+// safeMethods don't change state. AnonymousPrincipal only lets them through,
+// and session cookies may authenticate them from any origin.
+var safeMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true, "PROPFIND": true, "REPORT": true,
+}
+
+// checkAnonymous decides on a request without credentials according to
+// h.Anonymous. It returns the user ID to serve the request as, empty for
+// unauthenticated requests, and false after answering the request itself.
+func (h *CaldavHandler) checkAnonymous(w http.ResponseWriter, r *http.Request) (string, bool) {
+	switch h.Anonymous {
+	case AnonymousUnauthenticated:
+		if (r.Method == "PROPFIND" || r.Method == http.MethodOptions) && h.isServiceRoot(r.URL.Path) {
+			h.Logger.Info("serving unauthenticated request",
+				"method", r.Method)
+			return "", true
+		}
+	case AnonymousPrincipal:
+		if h.AnonymousUser != "" && safeMethods[r.Method] {
+			h.Logger.Info("serving anonymous request",
+				"method", r.Method,
+				"user_id", h.AnonymousUser)
+			return h.AnonymousUser, true
+		}
+	}
+	h.Logger.Info("authentication required - no auth header")
+	h.requireAuth(w, r)
+	return "", false
+}
+
+func (h *CaldavHandler) isServiceRoot(path string) bool {
+	res, err := h.URLConverter.ParsePath(path)
+	return err == nil && res.ResourceType == storage.ResourceServiceRoot
+}
+
+// authenticate runs h.Authenticators, then falls back to checkAuth. It
+// returns the user ID, whether the request was let through without
+// credentials, and false after answering the request itself.
+func (h *CaldavHandler) authenticate(w http.ResponseWriter, r *http.Request) (userID string, anonymous, ok bool) {
+	for _, a := range h.Authenticators {
+		userID, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if errors.Is(err, ErrCrossSiteRequest) {
+			h.Logger.Warn("cross-site request rejected",
+				"method", r.Method,
+				"origin", r.Header.Get("Origin"))
+			h.writeError(w, r, http.StatusForbidden, CodeCrossSiteRequest)
+			return "", false, false
+		}
+		if err != nil || userID == "" {
+			h.Logger.Warn("authentication failed",
+				"authenticator", fmt.Sprintf("%T", a),
+				"error", err)
+			h.requireAuth(w, r)
+			return "", false, false
+		}
+		h.Logger.Info("authentication successful",
+			"authenticator", fmt.Sprintf("%T", a),
+			"userID", userID)
+		return userID, false, true
+	}
+	userID, ok = h.checkAuth(w, r)
+	return userID, ok && r.Header.Get("Authorization") == "", ok
+}
+
+// checkAuth enforces Basic Authentication. Returns the user ID and true if
+// successful. Requests without credentials are handled by checkAnonymous.
 func (h *CaldavHandler) checkAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		h.Logger.Info("authentication required - no auth header")
-		h.requireAuth(w)
-		return "", false
+		return h.checkAnonymous(w, r)
 	}
 
 	if !strings.HasPrefix(authHeader, "Basic ") {
 		h.Logger.Error("invalid authorization header format")
-		http.Error(w, "Bad Request: Invalid Authorization header format", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidAuthorization)
 		return "", false
 	}
 
@@ -27,7 +138,7 @@ func (h *CaldavHandler) checkAuth(w http.ResponseWriter, r *http.Request) (strin
 	if err != nil {
 		h.Logger.Error("failed to decode base64 credentials",
 			"error", err)
-		http.Error(w, "Bad Request: Invalid base64 encoding", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidAuthorization)
 		return "", false
 	}
 
@@ -35,7 +146,7 @@ func (h *CaldavHandler) checkAuth(w http.ResponseWriter, r *http.Request) (strin
 	parts := strings.SplitN(credentials, ":", 2)
 	if len(parts) != 2 {
 		h.Logger.Error("invalid format for decoded credentials")
-		http.Error(w, "Bad Request: Invalid credentials format", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidAuthorization)
 		return "", false
 	}
 
@@ -48,14 +159,14 @@ func (h *CaldavHandler) checkAuth(w http.ResponseWriter, r *http.Request) (strin
 		h.Logger.Warn("authentication failed",
 			"username", username,
 			"error", err)
-		h.requireAuth(w)
+		h.requireAuth(w, r)
 		return "", false
 	}
 
 	if userID == "" {
 		h.Logger.Warn("authentication failed - invalid credentials",
 			"username", username)
-		h.requireAuth(w)
+		h.requireAuth(w, r)
 		return "", false
 	}
 
@@ -65,8 +176,69 @@ func (h *CaldavHandler) checkAuth(w http.ResponseWriter, r *http.Request) (strin
 	return userID, true
 }
 
+// AuthPolicy is how a request is authenticated. CaldavHandler.Realm,
+// Anonymous, AnonymousUser and Authenticators make up the default policy.
+type AuthPolicy struct {
+	// Realm is named in the Basic authentication challenge
+	Realm string
+	// Anonymous selects how requests without credentials are treated
+	Anonymous AnonymousMode
+	// AnonymousUser is the user AnonymousPrincipal serves requests as
+	AnonymousUser string
+	// Authenticators are tried in order before Basic authentication
+	Authenticators []Authenticator
+}
+
+// AuthPolicyFunc picks the AuthPolicy of a request, e.g. by URL subtree to
+// serve public calendars anonymously next to private ones. It receives the
+// handler's default policy.
+type AuthPolicyFunc func(r *http.Request, defaults AuthPolicy) AuthPolicy
+
+// AuthPolicyByPrefix returns an AuthPolicyFunc applying the policy of the
+// longest path prefix that matches the request, or the default policy when
+// none does. Paths are compared by whole decoded segments, the way
+// DefaultURLConverter.ParsePath sees them, so escapes and duplicate slashes
+// can't route a request to a resource under another policy.
+func AuthPolicyByPrefix(policies map[string]AuthPolicy) AuthPolicyFunc {
+	prefixes := make(map[string][]string, len(policies))
+	for prefix := range policies {
+		prefixes[prefix] = davpath.Segments(prefix)
+	}
+	return func(r *http.Request, defaults AuthPolicy) AuthPolicy {
+		segments := davpath.Segments(r.URL.EscapedPath())
+		policy, longest := defaults, -1
+		for prefix, p := range policies {
+			want := prefixes[prefix]
+			if len(want) > longest && len(want) <= len(segments) && slices.Equal(segments[:len(want)], want) {
+				policy, longest = p, len(want)
+			}
+		}
+		return policy
+	}
+}
+
+// withAuthPolicy returns h, or a copy of it carrying the policy that
+// h.AuthPolicy picks for r.
+func (h *CaldavHandler) withAuthPolicy(r *http.Request) *CaldavHandler {
+	if h.AuthPolicy == nil {
+		return h
+	}
+	policy := h.AuthPolicy(r, AuthPolicy{
+		Realm:          h.Realm,
+		Anonymous:      h.Anonymous,
+		AnonymousUser:  h.AnonymousUser,
+		Authenticators: h.Authenticators,
+	})
+	bound := *h
+	bound.Realm = policy.Realm
+	bound.Anonymous = policy.Anonymous
+	bound.AnonymousUser = policy.AnonymousUser
+	bound.Authenticators = policy.Authenticators
+	return &bound
+}
+
 // requireAuth sends a 401 Unauthorized response asking for Basic Auth.
-func (h *CaldavHandler) requireAuth(w http.ResponseWriter) {
-	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, h.Realm))
-	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+func (h *CaldavHandler) requireAuth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, h.Realm))
+	h.writeError(w, r, http.StatusUnauthorized, CodeUnauthorized)
 }
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const currentUserPrincipalBody = `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:current-user-principal/></d:prop></d:propfind>`
+
+func serveAnonymous(h *CaldavHandler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAnonymousDeny(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	w := serveAnonymous(h, "PROPFIND", "/caldav/", currentUserPrincipalBody)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="test", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestAnonymousUnauthenticated(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Anonymous = AnonymousUnauthenticated
+
+	w := serveAnonymous(h, "PROPFIND", "/caldav/", currentUserPrincipalBody)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:current-user-principal><d:unauthenticated/></d:current-user-principal>")
+
+	// Anything past the service root still needs a login
+	w = serveAnonymous(h, "PROPFIND", "/caldav/alice/", currentUserPrincipalBody)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+
+	// Credentials still work as usual
+	w = serveAlice(h, "PROPFIND", "/caldav/", currentUserPrincipalBody, nil)
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice</d:href>")
+}
+
+func TestAnonymousPrincipal(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Anonymous = AnonymousPrincipal
+	h.AnonymousUser = "public"
+	mockStorage.On("GetObject", "public", "events", "a.ics").Return(nil, storage.ErrNotFound)
+
+	w := serveAnonymous(h, "PROPFIND", "/caldav/", currentUserPrincipalBody)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/public</d:href>")
+
+	serveAnonymous(h, "GET", "/caldav/public/cal/events/a.ics", "")
+	mockStorage.AssertCalled(t, "GetObject", "public", "events", "a.ics")
+
+	w = serveAnonymous(h, "PUT", "/caldav/public/cal/events/a.ics", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockStorage.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+
+	// Other users stay private
+	w = serveAnonymous(h, "GET", "/caldav/alice/cal/work/a.ics", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAuthPolicyByPrefix(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.AuthPolicy = AuthPolicyByPrefix(map[string]AuthPolicy{
+		"/caldav/public/":         {Realm: "public", Anonymous: AnonymousPrincipal, AnonymousUser: "public"},
+		"/caldav/public/cal/team": {Realm: "team"},
+	})
+	mockStorage.On("GetObject", "public", mock.Anything, "a.ics").Return(nil, storage.ErrNotFound)
+
+	// Public calendars are readable without a login
+	serveAnonymous(h, "GET", "/caldav/public/cal/events/a.ics", "")
+	mockStorage.AssertCalled(t, "GetObject", "public", "events", "a.ics")
+
+	// The longest prefix wins
+	w := serveAnonymous(h, "GET", "/caldav/public/cal/team/a.ics", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="team", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+
+	// Everything else keeps the handler's settings
+	w = serveAnonymous(h, "GET", "/caldav/alice/cal/work/a.ics", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="test", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, AnonymousDeny, h.Anonymous, "policies don't change the handler")
+
+	// Prefixes match the path the request is routed by
+	serveAnonymous(h, "GET", "/caldav/p%75blic//cal/events/a.ics", "")
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 2)
+	serveAnonymous(h, "GET", "/caldav/public/cal/team%2Fx/a.ics", "")
+	mockStorage.AssertCalled(t, "GetObject", "public", "team/x", "a.ics")
+	serveAnonymous(h, "GET", "/caldav/public/cal/teamwork/a.ics", "")
+	mockStorage.AssertCalled(t, "GetObject", "public", "teamwork", "a.ics")
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/internal/redact"
+)
+
+// maxLoggedBody caps how much of each request and response body is logged.
+const maxLoggedBody = 64 << 10
+
+// bodyRecorder tees the response body into a bounded buffer for logging.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (rec *bodyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *bodyRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if room := maxLoggedBody - rec.buf.Len(); room > 0 {
+		rec.buf.Write(p[:min(len(p), room)])
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// logBodies logs the request body and wraps w so the response body is logged
+// by the returned finish function. Only XML and iCalendar bodies are logged,
+// after passing through redact.ICS. Nothing happens unless debug logging is
+// enabled on h.Logger.
+func (h *CaldavHandler) logBodies(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if !h.Logger.Enabled(r.Context(), slog.LevelDebug) {
+		return w, func() {}
+	}
+
+	if r.Body != nil && loggableContentType(r.Header.Get("Content-Type")) {
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		// Hand the handler whatever was read, plus the error if reading failed
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		h.Logger.Debug("request body",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"body", redact.ICS(truncateBody(body)))
+	}
+
+	rec := &bodyRecorder{ResponseWriter: w}
+	return rec, func() {
+		if rec.buf.Len() == 0 || !loggableContentType(rec.Header().Get("Content-Type")) {
+			return
+		}
+		h.Logger.Debug("response body",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"body", redact.ICS(truncateBody(rec.buf.Bytes())))
+	}
+}
+
+// errReader returns err once the preceding readers are drained, or io.EOF if err is nil.
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	return 0, io.EOF
+}
+
+func loggableContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "xml") || strings.Contains(contentType, "text/calendar")
+}
+
+func truncateBody(body []byte) string {
+	if len(body) > maxLoggedBody {
+		return string(body[:maxLoggedBody]) + "...(truncated)"
+	}
+	return string(body)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogBodies(t *testing.T) {
+	const event = "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:abc\r\nSUMMARY:Secret meeting\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+	run := func(t *testing.T, enabled bool, level slog.Level) string {
+		var logs bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: level}))
+		h := NewCaldavHandler("/caldav/", "test", new(storage.MockStorage), 1, nil, logger)
+		h.LogBodies = enabled
+
+		var received string
+		r := httptest.NewRequest(http.MethodPut, "/caldav/u/cal/c/e.ics", strings.NewReader(event))
+		r.Header.Set("Content-Type", "text/calendar")
+		w, finish := h.logBodies(httptest.NewRecorder(), r)
+		if enabled {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			received = string(body)
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusMultiStatus)
+			_, err = w.Write([]byte("<C:calendar-data>SUMMARY:Other secret\n</C:calendar-data>"))
+			require.NoError(t, err)
+			finish()
+			// The handler still sees the original body
+			assert.Equal(t, event, received)
+		}
+		return logs.String()
+	}
+
+	t.Run("debug enabled", func(t *testing.T) {
+		out := run(t, true, slog.LevelDebug)
+		assert.Contains(t, out, "request body")
+		assert.Contains(t, out, "response body")
+		assert.Contains(t, out, "status=207")
+		assert.Contains(t, out, "SUMMARY:[REDACTED]")
+		assert.NotContains(t, out, "Secret meeting")
+		assert.NotContains(t, out, "Other secret")
+	})
+
+	t.Run("debug level disabled", func(t *testing.T) {
+		assert.Empty(t, run(t, true, slog.LevelInfo))
+	})
+}
+
+func TestServeHTTPLogBodiesOff(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	mockStorage := new(storage.MockStorage)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, logger)
+
+	r := httptest.NewRequest("OPTIONS", "/caldav/", strings.NewReader("<secret/>"))
+	r.Header.Set("Content-Type", "application/xml")
+	r.SetBasicAuth("alice", "password")
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil).Maybe()
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.NotContains(t, logs.String(), "request body")
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/multiput"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/samber/mo"
+)
+
+// Limits advertised through cs:bulk-requests and enforced on bulk POSTs.
+const (
+	bulkMaxResources = 100
+	bulkMaxBytes     = 10485760
+)
+
+// handlePost dispatches POST requests. Only the CalendarServer bulk change
+// extension is supported: POST on a calendar collection with ?action=simple
+// (a text/calendar body holding many new events) or ?action=crud (a cs:multiput body).
+func (h *CaldavHandler) handlePost(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	h.Logger.Info("post request received",
+		"resource_type", ctx.Resource.ResourceType,
+		"user_id", ctx.Resource.UserID,
+		"calendar_id", ctx.Resource.CalendarID,
+		"action", r.URL.Query().Get("action"))
+
+	if !h.features().EnableBulk {
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+		return
+	}
+	if ctx.Resource.ResourceType != storage.ResourceCollection {
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+		return
+	}
+
+	action := r.URL.Query().Get("action")
+	if action != "simple" && action != "crud" {
+		h.Logger.Warn("unsupported post action",
+			"action", action)
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedAction)
+		return
+	}
+	if !h.checkNotArchived(w, r, ctx.Resource) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, bulkMaxBytes+1))
+	if err != nil {
+		h.Logger.Error("failed to read bulk request body",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
+		return
+	}
+	if len(body) > bulkMaxBytes {
+		h.Logger.Warn("bulk request body too large",
+			"limit", bulkMaxBytes)
+		h.writeError(w, r, http.StatusRequestEntityTooLarge, CodeRequestTooLarge)
+		return
+	}
+	unlock, ok := h.lockCollection(w, r, ctx.Resource)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var docs []*etree.Document
+	switch action {
+	case "simple":
+		docs, err = h.bulkSimple(r, ctx, string(body))
+	case "crud":
+		docs, err = h.bulkCrud(r, ctx, string(body))
+	}
+	if errors.Is(err, errBulkTooManyResources) {
+		h.writeError(w, r, http.StatusRequestEntityTooLarge, CodeTooManyResources)
+		return
+	} else if err != nil {
+		h.Logger.Warn("invalid bulk request",
+			"action", action,
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
+		return
+	}
+
+	h.writeMultistatus(w, r, docs)
+}
+
+var errBulkTooManyResources = errors.New("bulk request exceeds max-resources")
+
+// decodeErrorStatus is the status of a bulk item whose calendar data
+// decodeCalendarComponents refused.
+func decodeErrorStatus(err error) int {
+	if errors.Is(err, errUnsupportedVersion) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// bulkSimple creates one object per distinct UID found in the calendar body.
+func (h *CaldavHandler) bulkSimple(r *http.Request, ctx *RequestContext, body string) ([]*etree.Document, error) {
+	components, err := decodeCalendarComponents(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group components by UID; VTIMEZONEs are attached to every group.
+	var timezones []*ical.Component
+	groups := map[string][]*ical.Component{}
+	var order []string
+	for _, comp := range components {
+		if comp.Name == ical.CompTimezone {
+			timezones = append(timezones, comp)
+			continue
+		}
+		uid, _ := comp.Props.Text(ical.PropUID)
+		if _, ok := groups[uid]; !ok {
+			order = append(order, uid)
+		}
+		groups[uid] = append(groups[uid], comp)
+	}
+	if len(order) > bulkMaxResources {
+		return nil, errBulkTooManyResources
+	}
+
+	var docs []*etree.Document
+	for _, uid := range order {
+		comps := append(append([]*ical.Component{}, timezones...), groups[uid]...)
+		docs = append(docs, h.bulkCreate(r, ctx, comps))
+	}
+	return docs, nil
+}
+
+// bulkCrud applies every item of a cs:multiput body and reports per-item status.
+func (h *CaldavHandler) bulkCrud(r *http.Request, ctx *RequestContext, body string) ([]*etree.Document, error) {
+	items, err := multiput.ParseRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > bulkMaxResources {
+		return nil, errBulkTooManyResources
+	}
+
+	var docs []*etree.Document
+	for _, item := range items {
+		if item.Href == "" {
+			comps, err := decodeCalendarComponents(item.CalendarData)
+			if err != nil {
+				docs = append(docs, propfind.EncodeStatusResponse("", decodeErrorStatus(err)))
+				continue
+			}
+			docs = append(docs, h.bulkCreate(r, ctx, comps))
+			continue
+		}
+		docs = append(docs, h.bulkModify(r, ctx, item))
+	}
+	return docs, nil
+}
+
+// bulkCreate stores components as a new object with a generated resource name.
+func (h *CaldavHandler) bulkCreate(r *http.Request, ctx *RequestContext, comps []*ical.Component) *etree.Document {
+	objectID, err := h.newObjectID(ctx.Resource.UserID, ctx.Resource.CalendarID, comps)
+	if err != nil {
+		h.Logger.Error("failed to name object for bulk create",
+			"error", err)
+		return propfind.EncodeStatusResponse("", http.StatusInternalServerError)
+	}
+	res := Resource{
+		UserID:       ctx.Resource.UserID,
+		CalendarID:   ctx.Resource.CalendarID,
+		ObjectID:     objectID,
+		ResourceType: storage.ResourceObject,
+	}
+	href, err := h.URLConverter.EncodePath(res)
+	if err != nil {
+		h.Logger.Error("failed to encode path for bulk create",
+			"error", err)
+		return propfind.EncodeStatusResponse("", http.StatusInternalServerError)
+	}
+	return h.bulkWrite(r, href, objectWrite{res: res, comps: comps})
+}
+
+// bulkModify updates or deletes the object referenced by item.Href.
+func (h *CaldavHandler) bulkModify(r *http.Request, ctx *RequestContext, item multiput.Item) *etree.Document {
+	res, err := h.URLConverter.ParsePath(item.Href)
+	if err != nil || res.ResourceType != storage.ResourceObject {
+		return propfind.EncodeStatusResponse(item.Href, http.StatusBadRequest)
+	}
+	if res.UserID != ctx.Resource.UserID || res.CalendarID != ctx.Resource.CalendarID {
+		// Bulk requests may only touch members of the target collection
+		return propfind.EncodeStatusResponse(item.Href, http.StatusForbidden)
+	}
+
+	write := objectWrite{res: res, delete: item.Delete, ifMatch: item.IfMatch}
+	if !item.Delete {
+		if write.comps, err = decodeCalendarComponents(item.CalendarData); err != nil {
+			return propfind.EncodeStatusResponse(item.Href, decodeErrorStatus(err))
+		}
+	}
+	return h.bulkWrite(r, item.Href, write)
+}
+
+// bulkWrite applies the write of the bulk item at href the way a PUT or
+// DELETE of the object would, lock tokens and UID conflicts included, and
+// returns the item's response: its ETag, or the status the write failed
+// with.
+func (h *CaldavHandler) bulkWrite(r *http.Request, href string, write objectWrite) *etree.Document {
+	rec := &itemResponse{header: make(http.Header)}
+	ctx, hasCtx := RequestContextFrom(r.Context())
+	var transient bool
+	if hasCtx {
+		transient = ctx.transient
+	}
+	h.writeObject(rec, r, write)
+	if hasCtx {
+		// A transient error fails the item only: retrying the request
+		// would apply the items before it again
+		ctx.transient = transient
+	}
+
+	switch {
+	case rec.status >= http.StatusMultipleChoices:
+		return propfind.EncodeStatusResponse(href, rec.status)
+	case write.delete:
+		return propfind.EncodeStatusResponse(href, http.StatusOK)
+	}
+	if location := rec.header.Get("Location"); location != "" {
+		// The write landed on the object already holding the UID
+		href = location
+	}
+	return bulkSuccess(href, rec.header.Get("ETag"))
+}
+
+// itemResponse records how writeObject answered one bulk item.
+type itemResponse struct {
+	header http.Header
+	status int
+}
+
+func (rec *itemResponse) Header() http.Header {
+	return rec.header
+}
+
+func (rec *itemResponse) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *itemResponse) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func bulkSuccess(href, etag string) *etree.Document {
+	return propfind.EncodeResponse(propfind.ResponseMap{
+		"getetag": mo.Ok[props.Property](&props.GetEtag{Value: etag}),
+	}, href)
+}
+
+// writeMultistatus merges per-resource documents and writes a 207 response.
+func (h *CaldavHandler) writeMultistatus(w http.ResponseWriter, r *http.Request, docs []*etree.Document) {
+	mergedDoc, err := propfind.MergeResponses(docs)
+	if err != nil {
+		h.Logger.Error("failed to merge responses",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+
+	xmlOutput, err := h.writeXML(mergedDoc)
+	if err != nil {
+		h.Logger.Error("failed to serialize XML response",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+	h.recordResponseSize(r, mergedDoc, xmlOutput)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xmlOutput))
+}
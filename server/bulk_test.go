@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const bulkTestEvent = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:%s
+DTSTAMP:20250101T000000Z
+DTSTART:20250101T090000Z
+SUMMARY:Bulk
+END:VEVENT
+END:VCALENDAR`
+
+func bulkCollectionContext() *RequestContext {
+	return &RequestContext{
+		Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection},
+		AuthUser: "alice",
+	}
+}
+
+func TestBulkSimpleCreatesOnePerUID(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+
+	body := `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VEVENT
+UID:one
+DTSTAMP:20250101T000000Z
+DTSTART:20250101T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:two
+DTSTAMP:20250101T000000Z
+DTSTART:20250102T090000Z
+END:VEVENT
+END:VCALENDAR`
+
+	mockStorage.On("GetObject", "alice", "work", mock.Anything).Return(nil, storage.ErrNotFound)
+	mockStorage.On("UpdateObject", "alice", "work", mock.MatchedBy(func(o *storage.CalendarObject) bool {
+		return strings.HasPrefix(o.Path, "/caldav/alice/cal/work/") && len(o.Component) == 1
+	})).Return("new-etag", nil).Twice()
+
+	req := httptest.NewRequest("POST", "/caldav/alice/cal/work?action=simple", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.handlePost(w, req, bulkCollectionContext())
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "<d:getetag>new-etag</d:getetag>"))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestBulkCrudPerItemStatus(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+
+	existing := &storage.CalendarObject{
+		Path:      "/caldav/alice/cal/work/a.ics",
+		ETag:      "etag-a",
+		Component: []*ical.Component{ical.NewComponent(ical.CompEvent)},
+	}
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(existing, nil)
+	mockStorage.On("GetObject", "alice", "work", "b.ics").Return(existing, nil)
+	mockStorage.On("UpdateObject", "alice", "work", mock.MatchedBy(func(o *storage.CalendarObject) bool {
+		return o.Path == "/caldav/alice/cal/work/a.ics"
+	})).Return("etag-a2", nil).Once()
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<CS:multiput xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <CS:resource>
+    <D:href>/caldav/alice/cal/work/a.ics</D:href>
+    <CS:if-match><D:getetag>etag-a</D:getetag></CS:if-match>
+    <D:set><D:prop><C:calendar-data>` + strings.ReplaceAll(bulkTestEvent, "%s", "a") + `</C:calendar-data></D:prop></D:set>
+  </CS:resource>
+  <CS:resource>
+    <D:href>/caldav/alice/cal/work/b.ics</D:href>
+    <CS:if-match><D:getetag>stale</D:getetag></CS:if-match>
+    <CS:delete/>
+  </CS:resource>
+  <CS:resource>
+    <D:href>/caldav/alice/cal/other/c.ics</D:href>
+    <CS:delete/>
+  </CS:resource>
+</CS:multiput>`
+
+	req := httptest.NewRequest("POST", "/caldav/alice/cal/work?action=crud", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.handlePost(w, req, bulkCollectionContext())
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	out := w.Body.String()
+	assert.Contains(t, out, "<d:getetag>etag-a2</d:getetag>")
+	assert.Contains(t, out, "HTTP/1.1 412 Precondition Failed")
+	assert.Contains(t, out, "HTTP/1.1 403 Forbidden")
+	mockStorage.AssertNotCalled(t, "DeleteObject", mock.Anything, mock.Anything, mock.Anything)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestBulkPostRejectsInvalidRequests(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+
+	tests := []struct {
+		name           string
+		url            string
+		ctx            *RequestContext
+		body           string
+		expectedStatus int
+	}{
+		{"object target", "/caldav/alice/cal/work/a.ics?action=crud", &RequestContext{Resource: Resource{ResourceType: storage.ResourceObject}}, "", http.StatusMethodNotAllowed},
+		{"missing action", "/caldav/alice/cal/work", bulkCollectionContext(), "", http.StatusBadRequest},
+		{"malformed multiput", "/caldav/alice/cal/work?action=crud", bulkCollectionContext(), "<multiput", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.handlePost(w, req, tt.ctx)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestBulkCrudHonorsMemberLocks(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Locks = NewLockTable()
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/caldav/alice/cal/work/a.ics", ETag: "etag-a"}, nil)
+	mockStorage.On("GetObject", "alice", "work", "b.ics").Return(&storage.CalendarObject{Path: "/caldav/alice/cal/work/b.ics", ETag: "etag-b"}, nil)
+	mockStorage.On("DeleteObject", "alice", "work", "b.ics").Return(nil).Once()
+
+	w := serveAlice(h, "LOCK", "/caldav/alice/cal/work/a.ics", exclusiveLockBody, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<CS:multiput xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/">
+  <CS:resource><D:href>/caldav/alice/cal/work/a.ics</D:href><CS:delete/></CS:resource>
+  <CS:resource><D:href>/caldav/alice/cal/work/b.ics</D:href><CS:delete/></CS:resource>
+</CS:multiput>`
+	w = serveAlice(h, http.MethodPost, "/caldav/alice/cal/work?action=crud", body, nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	out := w.Body.String()
+	assert.Contains(t, out, "<d:href>/caldav/alice/cal/work/a.ics</d:href><d:status>HTTP/1.1 423 Locked</d:status>")
+	assert.Contains(t, out, "<d:href>/caldav/alice/cal/work/b.ics</d:href><d:status>HTTP/1.1 200 OK</d:status>")
+	mockStorage.AssertNotCalled(t, "DeleteObject", "alice", "work", "a.ics")
+	mockStorage.AssertExpectations(t)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/cyp0633/libcaldora/internal/xml/proppatch"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// calendarPreferences returns what principal set for a calendar. Without
+// storage.CalendarPreferenceStorage, or without a known principal, there are
+// none.
+func (h *CaldavHandler) calendarPreferences(principal, userID, calendarID string) (storage.CalendarPreferences, error) {
+	prefs, ok := storage.As[storage.CalendarPreferenceStorage](h.Storage)
+	if !ok || principal == "" {
+		return storage.CalendarPreferences{}, nil
+	}
+	return prefs.GetCalendarPreferences(principal, userID, calendarID)
+}
+
+// patchPreference changes one of the principal's preferences for the
+// calendar. It needs storage.CalendarPreferenceStorage.
+func patchPreference(h *CaldavHandler, ctx *RequestContext, change func(*storage.CalendarPreferences)) (func() error, int) {
+	prefs, ok := storage.As[storage.CalendarPreferenceStorage](h.Storage)
+	if !ok || ctx.AuthUser == "" {
+		return nil, http.StatusForbidden
+	}
+	res := ctx.Resource
+	return func() error {
+		current, err := prefs.GetCalendarPreferences(ctx.AuthUser, res.UserID, res.CalendarID)
+		if err != nil {
+			return err
+		}
+		change(&current)
+		return prefs.SetCalendarPreferences(ctx.AuthUser, res.UserID, res.CalendarID, current)
+	}, http.StatusOK
+}
+
+// patchCalendar changes the calendar itself, for all who see it. It needs
+// storage.MutableCalendarStorage.
+func patchCalendar(h *CaldavHandler, ctx *RequestContext, change func(*storage.Calendar)) (func() error, int) {
+	mutable, ok := storage.As[storage.MutableCalendarStorage](h.Storage)
+	if !ok {
+		return nil, http.StatusForbidden
+	}
+	res := ctx.Resource
+	return func() error {
+		cal, err := h.Storage.GetCalendar(res.UserID, res.CalendarID)
+		if err != nil {
+			return err
+		}
+		if cal.CalendarData == nil {
+			cal.CalendarData = ical.NewCalendar()
+		}
+		change(cal)
+		return mutable.UpdateCalendar(res.UserID, res.CalendarID, cal)
+	}, http.StatusOK
+}
+
+// patchSelected sets or removes g:selected. Removing it selects the calendar
+// again.
+func patchSelected(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	var selected *bool
+	if !op.Remove {
+		prop, ok := op.Property.(*props.Selected)
+		if !ok {
+			return nil, http.StatusForbidden
+		}
+		selected = &prop.Value
+	}
+	return patchPreference(h, ctx, func(p *storage.CalendarPreferences) { p.Selected = selected })
+}
+
+// patchColor sets or removes g:color. With preference storage it only
+// changes the color for the authenticated principal; otherwise it changes
+// the calendar's COLOR, which needs storage.MutableCalendarStorage.
+func patchColor(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	if _, ok := storage.As[storage.CalendarPreferenceStorage](h.Storage); ok {
+		return patchColorPreference(h, ctx, op)
+	}
+	color, ok := opColor(op)
+	if !ok {
+		return nil, http.StatusForbidden
+	}
+	return patchCalendar(h, ctx, func(cal *storage.Calendar) {
+		if color == nil {
+			cal.CalendarData.Props.Del(ical.PropColor)
+		} else {
+			cal.CalendarData.Props.SetText(ical.PropColor, *color)
+		}
+	})
+}
+
+// patchDisplayName renames a calendar. With preference storage it only
+// renames it for the authenticated principal, like patchColor; otherwise it
+// changes the calendar's NAME.
+func patchDisplayName(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	if _, ok := storage.As[storage.CalendarPreferenceStorage](h.Storage); ok {
+		return patchDisplayNamePreference(h, ctx, op)
+	}
+	var name, lang string
+	if !op.Remove {
+		prop, ok := op.Property.(*props.DisplayName)
+		if !ok || prop.Value == "" {
+			return nil, http.StatusForbidden
+		}
+		name, lang = prop.Value, prop.Lang
+	}
+	return patchCalendar(h, ctx, func(cal *storage.Calendar) {
+		if op.Remove {
+			cal.CalendarData.Props.Del(ical.PropName)
+			return
+		}
+		cal.CalendarData.Props.SetText(ical.PropName, name)
+		if lang != "" {
+			cal.CalendarData.Props.Get(ical.PropName).Params.Set(ical.ParamLanguage, lang)
+		}
+	})
+}
+
+// opColor returns the color a g:color or ical:calendar-color operation sets,
+// nil for a removal.
+func opColor(op proppatch.Operation) (*string, bool) {
+	if op.Remove {
+		return nil, true
+	}
+	var color string
+	switch prop := op.Property.(type) {
+	case *props.Color:
+		color = prop.Value
+	case *props.CalendarColor:
+		color = prop.Value
+	}
+	return &color, color != ""
+}
+
+// shareePatchers apply when the authenticated principal doesn't own the
+// calendar. They only change the principal's own view of it, so read access
+// is enough.
+var shareePatchers = map[string]propPatcher{
+	"displayname":    patchDisplayNamePreference,
+	"calendar-color": patchColorPreference,
+	"color":          patchColorPreference,
+	"hidden":         patchHiddenPreference,
+	"selected":       patchSelected,
+}
+
+// patchColorPreference sets or clears the principal's color for a calendar.
+func patchColorPreference(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	color, ok := opColor(op)
+	if !ok {
+		return nil, http.StatusForbidden
+	}
+	return patchPreference(h, ctx, func(p *storage.CalendarPreferences) { p.Color = color })
+}
+
+// patchHiddenPreference hides or shows a calendar for the principal.
+// Removing the property shows it.
+func patchHiddenPreference(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	hidden := false
+	if !op.Remove {
+		prop, ok := op.Property.(*props.Hidden)
+		if !ok {
+			return nil, http.StatusForbidden
+		}
+		hidden = prop.Value
+	}
+	return patchPreference(h, ctx, func(p *storage.CalendarPreferences) { p.Hidden = &hidden })
+}
+
+// patchDisplayNamePreference renames a calendar for the principal, e.g.
+// "Bob's calendar" to "Team". Removing the property restores the owner's
+// name.
+func patchDisplayNamePreference(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	var name *string
+	if !op.Remove {
+		prop, ok := op.Property.(*props.DisplayName)
+		if !ok || prop.Value == "" {
+			return nil, http.StatusForbidden
+		}
+		name = &prop.Value
+	}
+	return patchPreference(h, ctx, func(p *storage.CalendarPreferences) { p.DisplayName = name })
+}
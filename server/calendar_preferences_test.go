@@ -0,0 +1,216 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// preferenceStorage keeps calendar preferences in memory on top of
+// MockStorage, keyed by "principal/userID/calendarID".
+type preferenceStorage struct {
+	*storage.MockStorage
+	prefs map[string]storage.CalendarPreferences
+}
+
+func (s *preferenceStorage) GetCalendarPreferences(principal, userID, calendarID string) (storage.CalendarPreferences, error) {
+	return s.prefs[principal+"/"+userID+"/"+calendarID], nil
+}
+
+func (s *preferenceStorage) SetCalendarPreferences(principal, userID, calendarID string, prefs storage.CalendarPreferences) error {
+	if prefs.IsZero() {
+		delete(s.prefs, principal+"/"+userID+"/"+calendarID)
+	} else {
+		s.prefs[principal+"/"+userID+"/"+calendarID] = prefs
+	}
+	return nil
+}
+
+func newPreferenceHandler() (*CaldavHandler, *preferenceStorage) {
+	data := ical.NewCalendar()
+	data.Props.SetText(ical.PropColor, "#0000ff")
+	store := &preferenceStorage{MockStorage: &storage.MockStorage{}, prefs: map[string]storage.CalendarPreferences{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work/", CalendarData: data}, nil)
+	store.On("GetCalendar", "alice", "birthdays").Return(&storage.Calendar{Path: "/alice/cal/birthdays/", CalendarData: ical.NewCalendar()}, nil)
+	store.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work/"}, {Path: "/alice/cal/birthdays/"}}, nil)
+	return NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil))), store
+}
+
+const googlePrefsPropfind = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:G="http://schemas.google.com/gCal/2005">
+<D:prop><G:color/><G:hidden/><G:selected/></D:prop></D:propfind>`
+
+func googlePrefsPatch(inner string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:G="http://schemas.google.com/gCal/2005">` + inner + `</D:propertyupdate>`
+}
+
+func TestCalendarPreferences(t *testing.T) {
+	h, store := newPreferenceHandler()
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", googlePrefsPropfind, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<g:color>#0000ff</g:color>")
+	assert.Contains(t, w.Body.String(), "<g:hidden>false</g:hidden>")
+	assert.Contains(t, w.Body.String(), "<g:selected>true</g:selected>")
+
+	body := googlePrefsPatch(`<D:set><D:prop><G:color>#ff0000</G:color><G:hidden>true</G:hidden><G:selected>false</G:selected></D:prop></D:set>`)
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work/", body, nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "403")
+	require.Contains(t, store.prefs, "alice/alice/work")
+
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", googlePrefsPropfind, map[string]string{"Depth": "0"})
+	assert.Contains(t, w.Body.String(), "<g:color>#ff0000</g:color>")
+	assert.Contains(t, w.Body.String(), "<g:hidden>true</g:hidden>")
+	assert.Contains(t, w.Body.String(), "<g:selected>false</g:selected>")
+
+	// Other principals keep the calendar's own values
+	env := newPropEnv(h, Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, nil)
+	env.principal = "bob"
+	assert.Equal(t, &props.Color{Value: "#0000ff"}, collectionResolvers["color"](env).MustGet())
+	assert.Equal(t, &props.Selected{Value: true}, collectionResolvers["selected"](env).MustGet())
+
+	// Removing a preference falls back to the calendar again
+	body = googlePrefsPatch(`<D:remove><D:prop><G:color/><G:selected/></D:prop></D:remove>`)
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work/", body, nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", googlePrefsPropfind, map[string]string{"Depth": "0"})
+	assert.Contains(t, w.Body.String(), "<g:color>#0000ff</g:color>")
+	assert.Contains(t, w.Body.String(), "<g:hidden>true</g:hidden>")
+	assert.Contains(t, w.Body.String(), "<g:selected>true</g:selected>")
+}
+
+func TestHideHiddenCalendarsPreference(t *testing.T) {
+	h, store := newPreferenceHandler()
+	h.HideHiddenCalendars = true
+	hidden := true
+	store.prefs["alice/alice/birthdays"] = storage.CalendarPreferences{Hidden: &hidden}
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/", body, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "/caldav/alice/cal/work")
+	assert.NotContains(t, w.Body.String(), "birthdays")
+}
+
+func TestProppatchColorWithoutPreferences(t *testing.T) {
+	store := &mutableCalendarStorage{MockStorage: &storage.MockStorage{}}
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work"}, nil)
+	store.On("UpdateCalendar", "alice", "work", mock.MatchedBy(func(cal *storage.Calendar) bool {
+		color, _ := cal.CalendarData.Props.Text(ical.PropColor)
+		return color == "#ff0000"
+	})).Return(nil).Once()
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, AuthUser: "alice"}
+
+	body := googlePrefsPatch(`<D:set><D:prop><G:color>#ff0000</G:color></D:prop></D:set>`)
+	w := httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work", strings.NewReader(body)), ctx)
+	assert.Contains(t, w.Body.String(), "<g:color/></d:prop><d:status>HTTP/1.1 200 OK</d:status>")
+	store.AssertExpectations(t)
+
+	// g:selected only exists as a preference
+	body = googlePrefsPatch(`<D:set><D:prop><G:selected>false</G:selected></D:prop></D:set>`)
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work", strings.NewReader(body)), ctx)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+}
+
+func TestProppatchOwnerNameAndColor(t *testing.T) {
+	store := &mutableCalendarStorage{MockStorage: &storage.MockStorage{}}
+	cal := &storage.Calendar{Path: "/alice/cal/work"}
+	store.On("GetCalendar", "alice", "work").Return(cal, nil)
+	store.On("UpdateCalendar", "alice", "work", cal).Return(nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, AuthUser: "alice"}
+
+	// Owners may set what sharees may set for themselves
+	body := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:I="http://apple.com/ns/ical/">
+<D:set><D:prop><D:displayname xml:lang="en">Team</D:displayname><I:calendar-color>#00ff00</I:calendar-color></D:prop></D:set></D:propertyupdate>`
+	w := httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work", strings.NewReader(body)), ctx)
+	assert.NotContains(t, w.Body.String(), "403")
+	name := cal.CalendarData.Props.Get(ical.PropName)
+	require.NotNil(t, name)
+	assert.Equal(t, "Team", name.Value)
+	assert.Equal(t, "en", name.Params.Get(ical.ParamLanguage))
+	color, _ := cal.CalendarData.Props.Text(ical.PropColor)
+	assert.Equal(t, "#00ff00", color)
+
+	body = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:"><D:remove><D:prop><D:displayname/></D:prop></D:remove></D:propertyupdate>`
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work", strings.NewReader(body)), ctx)
+	assert.NotContains(t, w.Body.String(), "403")
+	assert.Nil(t, cal.CalendarData.Props.Get(ical.PropName))
+}
+
+// shareeStorage gives dave read access to alice's home.
+type shareeStorage struct {
+	*preferenceStorage
+}
+
+func (s *shareeStorage) GetDelegatedPrivileges(ownerID, delegateID string) (storage.Privilege, error) {
+	if ownerID == "alice" && delegateID == "dave" {
+		return storage.PrivilegeRead, nil
+	}
+	return 0, nil
+}
+
+func TestShareeCalendarPreferences(t *testing.T) {
+	data := ical.NewCalendar()
+	data.Props.SetText(ical.PropName, "Alice's calendar")
+	data.Props.SetText(ical.PropColor, "#0000ff")
+	store := &shareeStorage{&preferenceStorage{MockStorage: &storage.MockStorage{}, prefs: map[string]storage.CalendarPreferences{}}}
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work/", CalendarData: data}, nil)
+	h := NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.Authenticators = []Authenticator{AuthenticatorFunc(func(r *http.Request) (string, error) {
+		return r.Header.Get("X-User"), nil
+	})}
+	send := func(user, method, body string) string {
+		r := httptest.NewRequest(method, "/caldav/alice/cal/work/", strings.NewReader(body))
+		r.Header.Set("X-User", user)
+		r.Header.Set("Depth", "0")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+	propfind := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:I="http://apple.com/ns/ical/">
+<D:prop><D:displayname/><I:calendar-color/></D:prop></D:propfind>`
+
+	// Read access is enough to rename and recolor the calendar for oneself
+	body := send("dave", "PROPPATCH", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:I="http://apple.com/ns/ical/">
+<D:set><D:prop><D:displayname>Team</D:displayname><I:calendar-color>#00ff00</I:calendar-color></D:prop></D:set></D:propertyupdate>`)
+	assert.NotContains(t, body, "403")
+	body = send("dave", "PROPFIND", propfind)
+	assert.Contains(t, body, "<d:displayname>Team</d:displayname>")
+	assert.Contains(t, body, "<ical:calendar-color>#00ff00</ical:calendar-color>")
+
+	// The owner still sees the calendar as it is
+	body = send("alice", "PROPFIND", propfind)
+	assert.Contains(t, body, "<d:displayname>Alice&apos;s calendar</d:displayname>")
+	assert.Contains(t, body, "<ical:calendar-color>#0000ff</ical:calendar-color>")
+
+	// Anything else still needs write access
+	body = send("dave", "PROPPATCH", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:example">
+<D:set><D:prop><D:displayname>Other</D:displayname><X:note>hi</X:note></D:prop></D:set></D:propertyupdate>`)
+	assert.Contains(t, body, "HTTP/1.1 403 Forbidden")
+	assert.Contains(t, body, "HTTP/1.1 424 Failed Dependency")
+	assert.Equal(t, "Team", *store.prefs["dave/alice/work"].DisplayName)
+
+	// Removing the name restores the owner's
+	send("dave", "PROPPATCH", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:"><D:remove><D:prop><D:displayname/></D:prop></D:remove></D:propertyupdate>`)
+	body = send("dave", "PROPFIND", propfind)
+	assert.Contains(t, body, "Alice&apos;s calendar")
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/samber/mo"
+)
+
+// The only calendar data format the server stores and returns.
+const (
+	calendarDataType = "text/calendar"
+	icalVersion      = "2.0"
+)
+
+// supportsCalendarData reports whether calendar data of the given media type
+// and iCalendar version can be served. Empty values mean the defaults RFC 4791
+// section 9.6 gives them, text/calendar and 2.0.
+func supportsCalendarData(contentType, version string) bool {
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != calendarDataType {
+			return false
+		}
+	}
+	return version == "" || strings.TrimSpace(version) == icalVersion
+}
+
+// resolveSupportedCalendarData advertises the one supported format, for
+// calendar collections and the resources that create them.
+func resolveSupportedCalendarData(_ *propEnv) mo.Result[props.Property] {
+	return mo.Ok[props.Property](&props.SupportedCalendarData{ContentType: calendarDataType, Version: icalVersion})
+}
+
+// objectContentType returns the media type GET serves obj with and
+// DAV:getcontenttype reports for it: text/calendar in UTF-8, with the
+// component parameter of RFC 5545 section 8.1 naming the type of the
+// object's component unless OmitContentTypeComponent is set.
+func (h *CaldavHandler) objectContentType(obj *storage.CalendarObject) string {
+	contentType := calendarDataType + "; charset=utf-8"
+	if h.OmitContentTypeComponent || obj == nil {
+		return contentType
+	}
+	master := obj.Master()
+	if master != nil && master.Name == ical.CompCalendar {
+		// Objects stored wrapped in their VCALENDAR
+		master = (&storage.CalendarObject{Component: master.Children}).Master()
+	}
+	if master == nil {
+		return contentType
+	}
+	return contentType + "; component=" + master.Name
+}
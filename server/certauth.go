@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+)
+
+// CertIdentity selects which part of a client certificate names the principal.
+type CertIdentity int
+
+const (
+	// CertEmailSAN uses the first email address subject alternative name.
+	CertEmailSAN CertIdentity = iota
+	// CertDNSSAN uses the first DNS name subject alternative name.
+	CertDNSSAN
+	// CertURISAN uses the first URI subject alternative name.
+	CertURISAN
+	// CertCommonName uses the subject common name.
+	CertCommonName
+)
+
+var errNoCertIdentity = errors.New("client certificate has no usable identity")
+
+// CertAuthenticator authenticates requests by verified TLS client
+// certificates, for deployments where devices are provisioned with
+// certificates. The http.Server must verify them, with tls.Config.ClientAuth
+// set to tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert and
+// ClientCAs set to the issuing CA; unverified certificates are ignored.
+// Certificates can't be checked behind a TLS-terminating proxy.
+type CertAuthenticator struct {
+	// Identity selects the certificate field naming the principal,
+	// CertEmailSAN by default
+	Identity CertIdentity
+	// Lookup maps that identity to a user ID, e.g. by email address, and
+	// returns an error for unknown identities. Nil uses the identity as the
+	// user ID.
+	Lookup func(identity string, cert *x509.Certificate) (string, error)
+}
+
+// Authenticate implements Authenticator.
+func (a *CertAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", ErrNoCredentials
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	identity := a.identity(cert)
+	if identity == "" {
+		return "", errNoCertIdentity
+	}
+	if a.Lookup == nil {
+		return identity, nil
+	}
+	return a.Lookup(identity, cert)
+}
+
+func (a *CertAuthenticator) identity(cert *x509.Certificate) string {
+	switch a.Identity {
+	case CertEmailSAN:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case CertDNSSAN:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case CertURISAN:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case CertCommonName:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
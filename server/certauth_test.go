@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithCert(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest("PROPFIND", "/caldav/", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return r
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.com/device/1")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		EmailAddresses: []string{"alice@example.com"},
+		DNSNames:       []string{"phone.example.com"},
+		URIs:           []*url.URL{uri},
+	}
+
+	for identity, want := range map[CertIdentity]string{
+		CertEmailSAN:   "alice@example.com",
+		CertDNSSAN:     "phone.example.com",
+		CertURISAN:     "spiffe://example.com/device/1",
+		CertCommonName: "alice",
+	} {
+		a := &CertAuthenticator{Identity: identity}
+		userID, err := a.Authenticate(requestWithCert(cert))
+		require.NoError(t, err)
+		assert.Equal(t, want, userID)
+	}
+
+	_, err := (&CertAuthenticator{Identity: CertDNSSAN}).Authenticate(requestWithCert(&x509.Certificate{}))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoCredentials)
+
+	// Without a verified chain the request has no certificate credentials
+	r := httptest.NewRequest("PROPFIND", "/caldav/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, err = (&CertAuthenticator{}).Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestCertAuthenticatorLookup(t *testing.T) {
+	a := &CertAuthenticator{Lookup: func(identity string, _ *x509.Certificate) (string, error) {
+		if identity == "alice@example.com" {
+			return "alice", nil
+		}
+		return "", errors.New("unknown device")
+	}}
+
+	userID, err := a.Authenticate(requestWithCert(&x509.Certificate{EmailAddresses: []string{"alice@example.com"}}))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", userID)
+
+	_, err = a.Authenticate(requestWithCert(&x509.Certificate{EmailAddresses: []string{"mallory@example.com"}}))
+	assert.Error(t, err)
+}
+
+func TestHandlerWithCertAuthenticator(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Authenticators = []Authenticator{&CertAuthenticator{Identity: CertCommonName}}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, requestWithCert(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}))
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	// A certificate without the identity is rejected rather than falling back
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, requestWithCert(&x509.Certificate{}))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Basic authentication still works for clients without certificates
+	w = serveAlice(h, "PROPFIND", "/caldav/", "", nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// ObjectChange describes a write the handler made to storage, passed to
+// OnObjectChange after the Recurrence cache has been flushed for it.
+type ObjectChange struct {
+	UserID     string
+	CalendarID string
+	// ObjectID is empty when the whole calendar was deleted
+	ObjectID string
+	// ETag is the object's new ETag, empty for deletions and when the
+	// storage didn't return one
+	ETag    string
+	Deleted bool
+}
+
+// recurrenceScope is the prefix the Recurrence cache keys entries of a
+// calendar's objects with, followed by the object ID.
+func recurrenceScope(userID, calendarID string) string {
+	return userID + "/" + calendarID + "/"
+}
+
+// objectRecurrence returns h.Recurrence keyed to the stored object obj of a
+// calendar, as calendar-query uses it. Its object ID is the last segment of
+// its path, as DefaultURLConverter encodes it.
+func (h *CaldavHandler) objectRecurrence(userID, calendarID string, obj *storage.CalendarObject) *recurrence.Engine {
+	return h.Recurrence.ForObject(recurrenceScope(userID, calendarID)+storage.PathSegment(obj.Path), obj.ETag)
+}
+
+// objectChanged flushes the Recurrence cache entries of the changed object, or
+// of every object of the calendar when change.ObjectID is empty, and the
+// FreeBusyCache results depending on it, then passes change on to
+// OnObjectChange. Every write to objects goes through here.
+func (h *CaldavHandler) objectChanged(change ObjectChange) {
+	if h.Recurrence != nil {
+		scope := recurrenceScope(change.UserID, change.CalendarID)
+		if change.ObjectID == "" {
+			h.Recurrence.InvalidatePrefix(scope)
+		} else {
+			h.Recurrence.Invalidate(scope + change.ObjectID)
+		}
+	}
+	if h.FreeBusyCache != nil {
+		h.FreeBusyCache.Invalidate(change.UserID, change.CalendarID,
+			change.ObjectID == "" || h.freeBusyCalendarChanged(change.UserID, change.CalendarID))
+	}
+	if h.OnObjectChange != nil {
+		h.OnObjectChange(change)
+	}
+}
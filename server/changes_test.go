@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// changeStorage keeps the objects of one calendar in a map, so queries see
+// what PUT and DELETE wrote.
+type changeStorage struct {
+	*storage.MockStorage
+	objects map[string]storage.CalendarObject
+	version int
+}
+
+func (s *changeStorage) GetObject(userID, calendarID, objectID string) (*storage.CalendarObject, error) {
+	obj, ok := s.objects[objectID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &obj, nil
+}
+
+func (s *changeStorage) GetObjectsInCollection(calendarID string) ([]storage.CalendarObject, error) {
+	var objects []storage.CalendarObject
+	for _, obj := range s.objects {
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+func (s *changeStorage) UpdateObject(userID, calendarID string, obj *storage.CalendarObject) (string, error) {
+	s.version++
+	stored := *obj
+	stored.ETag = fmt.Sprint(s.version)
+	s.objects[storage.PathSegment(obj.Path)] = stored
+	return stored.ETag, nil
+}
+
+func (s *changeStorage) DeleteObject(userID, calendarID, objectID string) error {
+	delete(s.objects, objectID)
+	return nil
+}
+
+func (s *changeStorage) GetObjectByFilter(userID, calendarID string, filter *storage.Filter) ([]storage.CalendarObject, error) {
+	var found []storage.CalendarObject
+	for _, obj := range s.objects {
+		// Filters start at the VCALENDAR
+		cal := ical.NewComponent(ical.CompCalendar)
+		cal.Children = obj.Component
+		if filter.Validate(&storage.CalendarObject{Path: obj.Path, ETag: obj.ETag, Component: []*ical.Component{cal}}) {
+			found = append(found, obj)
+		}
+	}
+	return found, nil
+}
+
+func TestObjectChangeFlushesRecurrenceCache(t *testing.T) {
+	store := &changeStorage{MockStorage: &storage.MockStorage{}, objects: map[string]storage.CalendarObject{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: ical.NewCalendar()}, nil)
+	store.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/caldav/alice/cal/work/"}}, nil).Maybe()
+	store.On("GetObjectPathsInCollection", mock.Anything).Return([]string{}, nil).Maybe()
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.Recurrence = recurrence.NewEngine()
+	defer h.Recurrence.Close()
+	var changes []ObjectChange
+	h.OnObjectChange = func(change ObjectChange) { changes = append(changes, change) }
+
+	put := func(rrule string) {
+		body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:standup\r\n" +
+			"DTSTAMP:20250101T000000Z\r\nDTSTART:20250303T090000Z\r\nDTEND:20250303T093000Z\r\n" +
+			"SUMMARY:Standup\r\nRRULE:" + rrule + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/standup.ics", body,
+			map[string]string{"Content-Type": "text/calendar"})
+		require.Less(t, w.Code, 300, w.Body.String())
+	}
+	// query reports whether standup.ics has an instance on 2025-03-10
+	query := func() bool {
+		body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="20250310T000000Z" end="20250311T000000Z"/>
+  </C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+		w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+		return strings.Contains(w.Body.String(), "standup.ics")
+	}
+
+	put("FREQ=DAILY")
+	assert.True(t, query())
+	assert.Equal(t, 1, h.Recurrence.GetCacheStats().TotalEntries)
+
+	// The new version no longer occurs on Mondays, a stale entry would
+	// still say it does
+	put("FREQ=DAILY;BYDAY=TU,WE,TH,FR")
+	assert.Equal(t, 0, h.Recurrence.GetCacheStats().TotalEntries)
+	assert.False(t, query())
+	assert.Equal(t, 1, h.Recurrence.GetCacheStats().TotalEntries)
+
+	w := serveAlice(h, http.MethodDelete, "/caldav/alice/cal/work/standup.ics", "", nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Equal(t, 0, h.Recurrence.GetCacheStats().TotalEntries)
+	assert.False(t, query())
+
+	assert.Equal(t, []ObjectChange{
+		{UserID: "alice", CalendarID: "work", ObjectID: "standup.ics", ETag: "1"},
+		{UserID: "alice", CalendarID: "work", ObjectID: "standup.ics", ETag: "2"},
+		{UserID: "alice", CalendarID: "work", ObjectID: "standup.ics", Deleted: true},
+	}, changes)
+}
+
+func TestObjectChangedCalendar(t *testing.T) {
+	h := NewCaldavHandler("/caldav/", "Test Realm", &storage.MockStorage{}, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.Recurrence = recurrence.NewEngine()
+	defer h.Recurrence.Close()
+
+	start := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	for _, object := range []string{"alice/work/a.ics", "alice/work/b.ics", "alice/home/c.ics"} {
+		_, err := h.Recurrence.ForObject(object, "1").HasOccurrenceInRange(start, start.Add(time.Hour),
+			recurrence.RecurrenceInfo{RRULE: "FREQ=DAILY"}, start, start.AddDate(0, 0, 1))
+		require.NoError(t, err)
+	}
+	h.objectChanged(ObjectChange{UserID: "alice", CalendarID: "work", Deleted: true})
+	assert.Equal(t, 1, h.Recurrence.GetCacheStats().TotalEntries, "only the other calendar's entry is left")
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter bounds how many expensive requests each principal has
+// running at once: REPORTs and PROPFINDs with a Depth of 1 or more. A client
+// stuck in a resync loop then can't starve the storage for everyone else.
+// Extra requests wait up to Wait for a slot, and are answered with 503 and
+// Retry-After when none frees up. Set it as CaldavHandler.Concurrency; the
+// handler counts requests authorized by a share link per link, and other
+// anonymous requests per client address, see concurrencyKey.
+type ConcurrencyLimiter struct {
+	Max  int           // Expensive requests a principal may run at once, no limit when 0
+	Wait time.Duration // Optional: how long extra requests queue for a slot, none are queued when 0
+
+	mu         sync.Mutex
+	principals map[string]*principalSlots
+	stats      ConcurrencyStats
+}
+
+type principalSlots struct {
+	ch   chan struct{} // holds a token per running request
+	refs int           // running and waiting requests, to know when to drop the entry
+}
+
+// ConcurrencyStats counts the work of a ConcurrencyLimiter for metrics.
+type ConcurrencyStats struct {
+	Admitted uint64 // requests that got a slot
+	Queued   uint64 // requests that had to wait for a slot
+	Rejected uint64 // requests answered with 503
+	Running  int    // requests currently holding a slot
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter allowing max expensive
+// requests per principal, queueing extras for up to wait.
+func NewConcurrencyLimiter(max int, wait time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{Max: max, Wait: wait}
+}
+
+// Acquire takes a slot of principal, waiting up to Wait or until ctx is
+// done. It returns a function releasing the slot, or false if none was free.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, principal string) (func(), bool) {
+	if l.Max <= 0 {
+		return func() {}, true
+	}
+	slots := l.ref(principal)
+	select {
+	case slots.ch <- struct{}{}:
+	default:
+		if !l.queue(ctx, slots) {
+			l.unref(principal)
+			l.mu.Lock()
+			l.stats.Rejected++
+			l.mu.Unlock()
+			return nil, false
+		}
+	}
+
+	l.mu.Lock()
+	l.stats.Admitted++
+	l.stats.Running++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-slots.ch
+			l.unref(principal)
+			l.mu.Lock()
+			l.stats.Running--
+			l.mu.Unlock()
+		})
+	}, true
+}
+
+// queue waits up to Wait for a slot of slots.
+func (l *ConcurrencyLimiter) queue(ctx context.Context, slots *principalSlots) bool {
+	if l.Wait <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	l.stats.Queued++
+	l.mu.Unlock()
+	timer := time.NewTimer(l.Wait)
+	defer timer.Stop()
+	select {
+	case slots.ch <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// Stats returns limiter statistics.
+func (l *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *ConcurrencyLimiter) ref(principal string) *principalSlots {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.principals == nil {
+		l.principals = map[string]*principalSlots{}
+	}
+	slots, ok := l.principals[principal]
+	if !ok {
+		slots = &principalSlots{ch: make(chan struct{}, l.Max)}
+		l.principals[principal] = slots
+	}
+	slots.refs++
+	return slots
+}
+
+func (l *ConcurrencyLimiter) unref(principal string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots := l.principals[principal]
+	if slots.refs--; slots.refs == 0 {
+		delete(l.principals, principal)
+	}
+}
+
+// expensive reports whether a request counts against the
+// ConcurrencyLimiter.
+func expensive(r *http.Request, ctx *RequestContext) bool {
+	switch r.Method {
+	case "REPORT":
+		return true
+	case "PROPFIND":
+		return ctx.Depth > 0
+	}
+	return false
+}
+
+// concurrencyKey returns the principal whose slots an expensive request
+// takes. Requests authorized by a share link, or made anonymously, run as a
+// shared user, so they are counted per link and per client address instead;
+// the prefixes keep those keys apart from user IDs.
+func (h *CaldavHandler) concurrencyKey(r *http.Request, ctx *RequestContext) string {
+	switch {
+	case ctx.Share != nil:
+		return "share:" + ctx.Share.Token
+	case ctx.Anonymous:
+		return "anonymous:" + h.clientAddress(r)
+	}
+	return "user:" + ctx.AuthUser
+}
+
+// clientAddress returns the IP address r came from: the first hop of
+// X-Forwarded-For when TrustForwardedHeaders is set, the peer otherwise.
+func (h *CaldavHandler) clientAddress(r *http.Request) string {
+	if h.TrustForwardedHeaders {
+		if addr := firstForwarded(r.Header.Get("X-Forwarded-For")); addr != "" {
+			return addr
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitConcurrency takes a slot of the principal of an expensive request,
+// answering the request itself when none is free. The returned function
+// releases the slot; without a ConcurrencyLimiter it does nothing.
+func (h *CaldavHandler) limitConcurrency(w http.ResponseWriter, r *http.Request, ctx *RequestContext) (func(), bool) {
+	if h.Concurrency == nil || !expensive(r, ctx) {
+		return func() {}, true
+	}
+	key := h.concurrencyKey(r, ctx)
+	release, ok := h.Concurrency.Acquire(r.Context(), key)
+	if !ok {
+		h.Logger.Warn("too many concurrent requests",
+			"auth_user", ctx.AuthUser,
+			"principal", key,
+			"method", r.Method,
+			"limit", h.Concurrency.Max)
+		h.setRetryAfter(w)
+		h.writeError(w, r, http.StatusServiceUnavailable, CodeTooManyRequests)
+		return nil, false
+	}
+	return release, true
+}
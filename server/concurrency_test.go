@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 0)
+
+	release, ok := l.Acquire(context.Background(), "alice")
+	require.True(t, ok)
+	_, ok = l.Acquire(context.Background(), "alice")
+	assert.False(t, ok, "alice's slot is taken")
+	releaseBob, ok := l.Acquire(context.Background(), "bob")
+	assert.True(t, ok, "other principals have their own slots")
+
+	release()
+	release()
+	releaseBob()
+	release, ok = l.Acquire(context.Background(), "alice")
+	require.True(t, ok)
+	release()
+
+	assert.Equal(t, ConcurrencyStats{Admitted: 3, Rejected: 1}, l.Stats())
+	assert.Empty(t, l.principals, "idle principals are dropped")
+}
+
+func TestConcurrencyLimiterQueues(t *testing.T) {
+	l := NewConcurrencyLimiter(1, time.Second)
+	release, ok := l.Acquire(context.Background(), "alice")
+	require.True(t, ok)
+	time.AfterFunc(10*time.Millisecond, release)
+
+	second, ok := l.Acquire(context.Background(), "alice")
+	require.True(t, ok, "waits for the slot to free up")
+	defer second()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = l.Acquire(ctx, "alice")
+	assert.False(t, ok, "gives up with the request")
+	assert.Equal(t, uint64(2), l.Stats().Queued)
+}
+
+func TestConcurrencyLimitedRequests(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Concurrency = NewConcurrencyLimiter(1, 0)
+	started, release := make(chan struct{}), make(chan struct{})
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Block") != "" {
+				started <- struct{}{}
+				<-release
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- serveAlice(h, "PROPFIND", "/caldav/alice/cal/", "", map[string]string{"Depth": "1", "X-Block": "1"}).Code
+	}()
+	<-started
+
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/", syncBody(""), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, string(CodeTooManyRequests), w.Header().Get("Libcaldora-Error"))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	w = serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "cheap requests aren't limited")
+
+	close(release)
+	assert.Equal(t, http.StatusMultiStatus, <-done)
+	assert.Equal(t, 0, h.Concurrency.Stats().Running)
+}
+
+func TestConcurrencyKey(t *testing.T) {
+	h := &CaldavHandler{}
+	r := httptest.NewRequest("REPORT", "/caldav/alice/cal/work/", nil)
+	r.RemoteAddr = "192.0.2.1:51234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.1")
+
+	assert.Equal(t, "user:alice", h.concurrencyKey(r, &RequestContext{AuthUser: "alice"}))
+	assert.Equal(t, "share:secret", h.concurrencyKey(r, &RequestContext{AuthUser: "alice", Share: &storage.ShareLink{Token: "secret"}}),
+		"share links don't use up their owner's slots")
+	assert.Equal(t, "anonymous:192.0.2.1", h.concurrencyKey(r, &RequestContext{AuthUser: "guest", Anonymous: true}))
+	h.TrustForwardedHeaders = true
+	assert.Equal(t, "anonymous:198.51.100.7", h.concurrencyKey(r, &RequestContext{AuthUser: "guest", Anonymous: true}))
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/internal/ifheader"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// checkIfHeader evaluates the If header (RFC 4918 section 10.4) of a write
+// to an object, answering 400 when it is malformed and 412 when it doesn't
+// hold. object is the current object at the request URI, nil when there is
+// none. Lists tagged with other objects are checked against their current
+// state in storage.
+func (h *CaldavHandler) checkIfHeader(w http.ResponseWriter, r *http.Request, object *storage.CalendarObject) bool {
+	value := r.Header.Get("If")
+	if value == "" {
+		return true
+	}
+	header, err := ifheader.Parse(value)
+	if err != nil {
+		h.Logger.Warn("malformed If header",
+			"value", value)
+		h.writeError(w, r, http.StatusBadRequest, CodeMalformedIf)
+		return false
+	}
+
+	// Remember objects fetched for tagged lists, by canonical path so any
+	// spelling of the request URI finds its object
+	objects := map[string]*storage.CalendarObject{h.canonicalPath(r.URL.EscapedPath()): object}
+	match := func(path string, c ifheader.Condition) bool {
+		path = h.canonicalPath(path)
+		if c.Token != "" {
+			return h.Locks != nil && h.Locks.holds(path, c.Token, lockUser(r))
+		}
+		obj, ok := objects[path]
+		if !ok {
+			obj = h.objectAt(path)
+			objects[path] = obj
+		}
+		return h.etagMatches(c.ETag, obj)
+	}
+	if !header.Evaluate(r.URL.EscapedPath(), match) {
+		h.Logger.Warn("If header precondition failed",
+			"path", r.URL.Path,
+			"value", value)
+		h.writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// canonicalPath returns path as URLConverter encodes it, which is how lock
+// roots are recorded, or path itself when it can't be parsed.
+func (h *CaldavHandler) canonicalPath(path string) string {
+	res, err := h.URLConverter.ParsePath(path)
+	if err != nil {
+		return path
+	}
+	encoded, err := h.URLConverter.EncodePath(res)
+	if err != nil {
+		return path
+	}
+	return encoded
+}
+
+// objectAt loads the object at path, or returns nil when path doesn't name
+// an existing object.
+func (h *CaldavHandler) objectAt(path string) *storage.CalendarObject {
+	res, err := h.URLConverter.ParsePath(path)
+	if err != nil || res.ResourceType != storage.ResourceObject {
+		return nil
+	}
+	obj, err := h.Storage.GetObject(res.UserID, res.CalendarID, res.ObjectID)
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// collectionUnchanged reports whether the client already has the current
+// state of a calendar: its If-None-Match names the calendar's CTag, or its If
+// header fails against it, e.g. "(Not ["ctag"])". Depth: 1 PROPFINDs then
+// skip listing the calendar's objects.
+func (h *CaldavHandler) collectionUnchanged(r *http.Request, res Resource) bool {
+	ifNoneMatch, ifValue := r.Header.Get("If-None-Match"), r.Header.Get("If")
+	if ifNoneMatch == "" && ifValue == "" {
+		return false
+	}
+	ctag := h.calendarCTag(res.UserID, res.CalendarID)
+	if ctag == "" {
+		return false
+	}
+	matches := func(tag string) bool {
+		return opaqueTag(tag) != "" && opaqueTag(tag) == opaqueTag(ctag)
+	}
+
+	unchanged := false
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if matches(strings.TrimSpace(candidate)) {
+			unchanged = true
+		}
+	}
+	// Only If headers made of entity tags say something about the CTag;
+	// lock tokens are checked elsewhere
+	if header, err := ifheader.Parse(ifValue); err == nil && len(header.Lists) > 0 && len(header.Tokens()) == 0 {
+		unchanged = unchanged || !header.Evaluate(r.URL.EscapedPath(), func(path string, c ifheader.Condition) bool {
+			return h.canonicalPath(path) == h.canonicalPath(r.URL.EscapedPath()) && matches(c.ETag)
+		})
+	}
+	if unchanged {
+		h.Logger.Debug("calendar unchanged, skipping its objects",
+			"user_id", res.UserID,
+			"calendar_id", res.CalendarID,
+			"ctag", ctag)
+	}
+	return unchanged
+}
+
+// calendarCTag returns the CTag of a calendar, from storage.CTagStorage when
+// the backend implements it, falling back to syncCTag. It's empty when the
+// calendar has none or the lookup fails.
+func (h *CaldavHandler) calendarCTag(userID, calendarID string) string {
+	if hinted, ok := storage.As[storage.CTagStorage](h.Storage); ok {
+		ctag, err := hinted.GetCalendarCTag(userID, calendarID)
+		if err != nil {
+			return ""
+		}
+		if ctag != "" {
+			return ctag
+		}
+		return h.syncCTag(userID, calendarID)
+	}
+	cal, err := h.Storage.GetCalendar(userID, calendarID)
+	if err != nil || cal == nil {
+		return ""
+	}
+	if cal.CTag != "" {
+		return cal.CTag
+	}
+	return h.syncCTag(userID, calendarID)
+}
+
+// syncCTag derives a CTag from the sync token of a calendar, for backends
+// that keep a change log but leave Calendar.CTag empty. Tokens change with
+// every change of the calendar's members, like CTags do, and keeping one
+// counter for both means they can't disagree. It's empty when the storage
+// has no change log.
+func (h *CaldavHandler) syncCTag(userID, calendarID string) string {
+	syncer, ok := storage.As[storage.SyncStorage](h.Storage)
+	if !ok {
+		return ""
+	}
+	token, err := syncer.GetSyncToken(userID, calendarID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUnsupported) {
+			h.Logger.Error("failed to get sync token for ctag",
+				"user_id", userID,
+				"calendar_id", calendarID,
+				"error", err)
+		}
+		return ""
+	}
+	return `"` + token + `"`
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfHeaderOnDelete(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/a.ics", ETag: `"1"`}, nil)
+	mockStorage.On("GetObject", "alice", "work", "b.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/b.ics", ETag: `"2"`}, nil)
+	mockStorage.On("DeleteObject", "alice", "work", "a.ics").Return(nil)
+
+	for value, want := range map[string]int{
+		`(["2"])`:                                http.StatusPreconditionFailed,
+		`(<urn:uuid:1>)`:                         http.StatusPreconditionFailed,
+		`(Not ["1"])`:                            http.StatusPreconditionFailed,
+		`(["1"`:                                  http.StatusBadRequest,
+		`</caldav/alice/cal/work/a.ics> (["2"])`: http.StatusPreconditionFailed,
+		`</caldav/alice/cal/work/b.ics> (["1"])`: http.StatusNoContent, // doesn't apply to a.ics
+		`(["1"])`:                                http.StatusNoContent,
+		`(<urn:uuid:1>) (Not <DAV:no-lock> [W/"1"])`:                                                    http.StatusNoContent,
+		`</caldav/alice/cal/work/a.ics> (Not ["2"])`:                                                    http.StatusNoContent,
+		`<http://localhost/caldav/alice/cal/work/a.ics> (["1"]) </caldav/alice/cal/work/b.ics> (["2"])`: http.StatusNoContent,
+	} {
+		w := serveAlice(h, "DELETE", "/caldav/alice/cal/work/a.ics", "", map[string]string{"If": value})
+		assert.Equal(t, want, w.Code, value)
+	}
+}
+
+func TestIfHeaderWithLockToken(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Locks = NewLockTable()
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/a.ics", ETag: "etag"}, nil)
+	mockStorage.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrNotFound)
+	w := serveAlice(h, "LOCK", "/caldav/alice/cal/work/a.ics", exclusiveLockBody, nil)
+	token := w.Header().Get("Lock-Token")
+
+	// Submitting the token under Not satisfies the lock check but not the If header
+	w = serveAlice(h, "PUT", "/caldav/alice/cal/work/a.ics", "", map[string]string{"If": "(Not " + token + ")"})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serveAlice(h, "PUT", "/caldav/alice/cal/work/a.ics", "", map[string]string{"If": "(" + token + ")"})
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, "passes preconditions, fails on the missing body")
+}
+
+// ctagStorage answers CTag lookups without loading calendars.
+type ctagStorage struct {
+	*storage.MockStorage
+	ctag    string
+	lookups int
+}
+
+func (s *ctagStorage) GetCalendarCTag(userID, calendarID string) (string, error) {
+	s.lookups++
+	return s.ctag, nil
+}
+
+func TestPropfindUnchangedCollection(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	store := &ctagStorage{MockStorage: mockStorage, ctag: `"42"`}
+	h.Storage = store
+	for _, id := range []string{"a.ics", "b.ics"} {
+		event := storage.NewMockEvent("/alice/cal/work/"+id, id, "Event", time.Now(), time.Now().Add(time.Hour))
+		mockStorage.On("GetObject", "alice", "work", id).Return(&event, nil)
+	}
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+
+	for header, skipped := range map[string]bool{
+		"":                                false,
+		"If-None-Match: \"41\"":           false,
+		"If-None-Match: \"41\", W/\"42\"": true,
+		"If-None-Match: *":                false,
+		"If: ([\"42\"])":                  false,
+		"If: (Not [\"42\"])":              true,
+		"If: (Not [\"41\"])":              false,
+		"If: (<urn:uuid:1>)":              false, // lock tokens aren't about the CTag
+	} {
+		headers := map[string]string{"Depth": "1"}
+		if name, value, ok := strings.Cut(header, ": "); ok {
+			headers[name] = value
+		}
+		w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", body, headers)
+		assert.Equal(t, http.StatusMultiStatus, w.Code, header)
+		assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work", header)
+		assert.Equal(t, skipped, !strings.Contains(w.Body.String(), "a.ics"), header)
+	}
+	mockStorage.AssertNumberOfCalls(t, "GetObjectPathsInCollection", 6)
+
+	// Depth: 0 needs no lookup
+	lookups := store.lookups
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "0", "If-None-Match": `"42"`})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, lookups, store.lookups)
+}
+
+func TestPropfindGetCTag(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.ExpectedCalls = nil
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work", CTag: `"7"`}, nil)
+	mockStorage.On("GetObjectPathsInCollection", "work").Return([]string{}, nil)
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/"><D:prop><CS:getctag/></D:prop></D:propfind>`
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), `<cs:getctag>&quot;7&quot;</cs:getctag>`)
+
+	// Without CTagStorage the calendar is loaded to compare
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1", "If-None-Match": `"7"`})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	mockStorage.AssertNotCalled(t, "GetObjectPathsInCollection", "work")
+}
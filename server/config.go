@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// Settings are the handler options a Config can change while the server
+// runs. Each field overrides the CaldavHandler field of the same name; a
+// field added here also needs copying in CaldavHandler.Settings and
+// withConfig.
+type Settings struct {
+	Realm                    string
+	MaxDepth                 int
+	DefaultDepth             int
+	ETagMode                 ETagMode
+	LogBodies                bool
+	Limits                   Limits
+	HideHiddenCalendars      bool
+	NormalizePolicy          NormalizePolicy
+	SkipUnchangedPuts        bool
+	StrictObjects            bool
+	Anonymous                AnonymousMode
+	AnonymousUser            string
+	ObjectDisplayNameSummary bool
+	XMLFormat                XMLFormat
+	UIDConflict              UIDConflictMode
+	ForceCalendarDelete      bool
+	HideForbidden            bool
+	OmitContentTypeComponent bool
+	ErrorFormat              ErrorFormat
+	TrustForwardedHeaders    bool
+	HrefMode                 HrefMode
+	Features                 *Features
+	Retry                    RetryPolicy
+}
+
+// Validate reports settings the handler can't serve requests with.
+func (s Settings) Validate() error {
+	switch {
+	case s.MaxDepth < 0:
+		return errors.New("MaxDepth must not be negative")
+	case s.DefaultDepth != 0 && s.DefaultDepth != 1 && s.DefaultDepth != DepthInfinity:
+		return errors.New("DefaultDepth must be 0, 1 or DepthInfinity")
+	case s.Limits.MaxCalendars < 0 || s.Limits.MaxObjects < 0:
+		return errors.New("limits must not be negative")
+	case s.Anonymous == AnonymousPrincipal && s.AnonymousUser == "":
+		return errors.New("AnonymousPrincipal needs an AnonymousUser")
+	case s.Retry.Attempts < 0 || s.Retry.Backoff < 0:
+		return errors.New("retry policy must not be negative")
+	}
+	return nil
+}
+
+// Settings returns the current values of the fields Settings covers, e.g. to
+// seed NewConfig with the handler as configured at startup.
+func (h *CaldavHandler) Settings() Settings {
+	return Settings{
+		Realm:                    h.Realm,
+		MaxDepth:                 h.MaxDepth,
+		DefaultDepth:             h.DefaultDepth,
+		ETagMode:                 h.ETagMode,
+		LogBodies:                h.LogBodies,
+		Limits:                   h.Limits,
+		HideHiddenCalendars:      h.HideHiddenCalendars,
+		NormalizePolicy:          h.NormalizePolicy,
+		SkipUnchangedPuts:        h.SkipUnchangedPuts,
+		StrictObjects:            h.StrictObjects,
+		Anonymous:                h.Anonymous,
+		AnonymousUser:            h.AnonymousUser,
+		ObjectDisplayNameSummary: h.ObjectDisplayNameSummary,
+		XMLFormat:                h.XMLFormat,
+		UIDConflict:              h.UIDConflict,
+		ForceCalendarDelete:      h.ForceCalendarDelete,
+		HideForbidden:            h.HideForbidden,
+		OmitContentTypeComponent: h.OmitContentTypeComponent,
+		ErrorFormat:              h.ErrorFormat,
+		TrustForwardedHeaders:    h.TrustForwardedHeaders,
+		HrefMode:                 h.HrefMode,
+		Features:                 h.Features.clone(),
+		Retry:                    h.Retry,
+	}
+}
+
+// Config holds Settings that can be replaced at runtime, e.g. on SIGHUP,
+// without restarting the server and interrupting client syncs. Set it as
+// CaldavHandler.Config; each request is served with the settings current
+// when it arrived. Config is safe for concurrent use.
+type Config struct {
+	updating sync.Mutex // held through an Update, watchers included
+	mu       sync.RWMutex
+	settings Settings
+	watchers []watcher // in the order they were added
+	next     int
+}
+
+type watcher struct {
+	id int
+	fn func(old, new Settings)
+}
+
+// NewConfig returns a Config holding s. Features are copied, so changing
+// them afterwards takes an Update like any other setting.
+func NewConfig(s Settings) *Config {
+	s.Features = s.Features.clone()
+	return &Config{settings: s}
+}
+
+// Settings returns the current settings. Its Features are a copy of their
+// own.
+func (c *Config) Settings() Settings {
+	c.mu.RLock()
+	s := c.settings
+	c.mu.RUnlock()
+	s.Features = s.Features.clone()
+	return s
+}
+
+// Update applies fn to a copy of the current settings and, if the result is
+// valid, makes it current and notifies the watchers. Invalid settings are
+// returned as an error and leave the current ones in place. Concurrent
+// updates are applied one after the other, and each one reaches every
+// watcher before the next is applied, so watchers see them in order.
+func (c *Config) Update(fn func(s *Settings)) error {
+	c.updating.Lock()
+	defer c.updating.Unlock()
+
+	old := c.Settings()
+	updated := c.Settings()
+	fn(&updated)
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	updated.Features = updated.Features.clone()
+	c.mu.Lock()
+	c.settings = updated
+	watchers := slices.Clone(c.watchers)
+	c.mu.Unlock()
+
+	for _, w := range watchers {
+		prev := old
+		prev.Features = old.Features.clone()
+		w.fn(prev, c.Settings())
+	}
+	return nil
+}
+
+// Watch calls fn after every successful Update with the previous and the new
+// settings, until the returned function is called. Watchers are called in
+// the order they were added. fn runs on the updating goroutine and must not
+// call Update.
+func (c *Config) Watch(fn func(old, new Settings)) (stop func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.next
+	c.next++
+	c.watchers = append(c.watchers, watcher{id: id, fn: fn})
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.watchers = slices.DeleteFunc(c.watchers, func(w watcher) bool { return w.id == id })
+	}
+}
+
+// withConfig returns a copy of h with the current settings of h.Config
+// applied, or h itself without one.
+func (h *CaldavHandler) withConfig() *CaldavHandler {
+	if h.Config == nil {
+		return h
+	}
+	s := h.Config.Settings()
+	bound := *h
+	bound.Realm = s.Realm
+	bound.MaxDepth = s.MaxDepth
+	bound.DefaultDepth = s.DefaultDepth
+	bound.ETagMode = s.ETagMode
+	bound.LogBodies = s.LogBodies
+	bound.Limits = s.Limits
+	bound.HideHiddenCalendars = s.HideHiddenCalendars
+	bound.NormalizePolicy = s.NormalizePolicy
+	bound.SkipUnchangedPuts = s.SkipUnchangedPuts
+	bound.StrictObjects = s.StrictObjects
+	bound.Anonymous = s.Anonymous
+	bound.AnonymousUser = s.AnonymousUser
+	bound.ObjectDisplayNameSummary = s.ObjectDisplayNameSummary
+	bound.XMLFormat = s.XMLFormat
+	bound.UIDConflict = s.UIDConflict
+	bound.ForceCalendarDelete = s.ForceCalendarDelete
+	bound.HideForbidden = s.HideForbidden
+	bound.OmitContentTypeComponent = s.OmitContentTypeComponent
+	bound.ErrorFormat = s.ErrorFormat
+	bound.TrustForwardedHeaders = s.TrustForwardedHeaders
+	bound.HrefMode = s.HrefMode
+	bound.Features = s.Features
+	bound.Retry = s.Retry
+	return &bound
+}
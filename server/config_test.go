@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigAppliesPerRequest(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Config = NewConfig(h.Settings())
+	require.NoError(t, h.Config.Update(func(s *Settings) { s.Limits.MaxCalendars = 1 }))
+	body := `<?xml version="1.0"?><C:mkcalendar xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:D="DAV:"><D:set><D:prop><D:displayname>Home</D:displayname></D:prop></D:set></C:mkcalendar>`
+
+	w := serveAlice(h, "MKCALENDAR", "/caldav/alice/cal/home", body, nil)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	assert.Equal(t, 0, h.Limits.MaxCalendars, "the handler itself is left alone")
+
+	require.NoError(t, h.Config.Update(func(s *Settings) { s.Limits = Limits{} }))
+	mockStorage.On("CreateCalendar", "alice", mock.AnythingOfType("*storage.Calendar")).
+		Run(func(args mock.Arguments) {
+			cal := args.Get(1).(*storage.Calendar)
+			cal.ETag = "etag"
+			cal.Path = "/alice/cal/home"
+		}).Return(nil).Once()
+	w = serveAlice(h, "MKCALENDAR", "/caldav/alice/cal/home", body, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	require.NoError(t, h.Config.Update(func(s *Settings) { s.Realm = "reloaded" }))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/caldav/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `realm="reloaded"`)
+}
+
+func TestConfigUpdate(t *testing.T) {
+	c := NewConfig(Settings{MaxDepth: 1})
+
+	var changes [][2]Settings
+	stop := c.Watch(func(old, new Settings) { changes = append(changes, [2]Settings{old, new}) })
+
+	require.NoError(t, c.Update(func(s *Settings) { s.MaxDepth = 3 }))
+	assert.Equal(t, 3, c.Settings().MaxDepth)
+	require.Len(t, changes, 1)
+	assert.Equal(t, 1, changes[0][0].MaxDepth)
+	assert.Equal(t, 3, changes[0][1].MaxDepth)
+
+	err := c.Update(func(s *Settings) { s.Anonymous = AnonymousPrincipal })
+	assert.ErrorContains(t, err, "AnonymousUser")
+	assert.Equal(t, AnonymousDeny, c.Settings().Anonymous, "invalid settings aren't applied")
+	assert.Len(t, changes, 1, "nor announced")
+
+	stop()
+	require.NoError(t, c.Update(func(s *Settings) { s.MaxDepth = 2 }))
+	assert.Len(t, changes, 1, "stopped watchers aren't called")
+}
+
+func TestConfigConcurrentUpdates(t *testing.T) {
+	c := NewConfig(Settings{})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = c.Update(func(s *Settings) { s.Limits.MaxObjects++ })
+			_ = c.Settings()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 50, c.Settings().Limits.MaxObjects)
+}
+
+func TestConfigWatchersSeeUpdatesInOrder(t *testing.T) {
+	c := NewConfig(Settings{})
+	var mu sync.Mutex
+	var seen []int
+	var calls []string
+	c.Watch(func(old, new Settings) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, new.Limits.MaxObjects)
+		calls = append(calls, "first")
+	})
+	c.Watch(func(old, new Settings) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, "second")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = c.Update(func(s *Settings) { s.Limits.MaxObjects++ })
+		}()
+	}
+	wg.Wait()
+	require.Len(t, seen, 50)
+	for i, n := range seen {
+		assert.Equal(t, i+1, n)
+	}
+	assert.Equal(t, []string{"first", "second"}, calls[:2], "watchers are called in the order they were added")
+}
+
+func TestConfigCopiesFeatures(t *testing.T) {
+	features := &Features{EnableSync: true}
+	h := &CaldavHandler{Features: features}
+	h.Config = NewConfig(h.Settings())
+
+	features.EnableSync = false
+	assert.True(t, h.withConfig().features().EnableSync, "only Update changes the settings")
+	h.withConfig().Features.EnableBulk = true
+	assert.False(t, h.Config.Settings().Features.EnableBulk, "requests get a copy")
+
+	require.NoError(t, h.Config.Update(func(s *Settings) { s.Features.EnableSync = false }))
+	assert.False(t, h.withConfig().features().EnableSync)
+}
+
+// fillSettings sets every field of v, recursively, to a value other than
+// its zero value.
+func fillSettings(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillSettings(v.Field(i))
+		}
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillSettings(v.Elem())
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.String:
+		v.SetString("x")
+	default:
+		panic("fillSettings: unhandled kind " + v.Kind().String())
+	}
+}
+
+func TestConfigCopiesEverySetting(t *testing.T) {
+	var s Settings
+	fillSettings(reflect.ValueOf(&s).Elem())
+	h := &CaldavHandler{Config: NewConfig(s)}
+	assert.Equal(t, s, h.withConfig().Settings(), "every setting is copied to the handler and back")
+	assert.Equal(t, Settings{}, h.Settings(), "the handler itself is left alone")
+}
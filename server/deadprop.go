@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/proppatch"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/samber/mo"
+)
+
+// maxDeadPropertySize caps the serialized size of a single dead property.
+const maxDeadPropertySize = 64 << 10
+
+// patchDeadProperty stores or removes a property the server doesn't know on a
+// calendar or calendar object. It needs storage.DeadPropertyStorage.
+func patchDeadProperty(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	dead, ok := storage.As[storage.DeadPropertyStorage](h.Storage)
+	if !ok {
+		return nil, http.StatusForbidden
+	}
+	res := ctx.Resource
+	name := op.Element.Tag
+	if op.Remove {
+		return func() error {
+			return dead.RemoveDeadProperty(res.UserID, res.CalendarID, res.ObjectID, op.Namespace, name)
+		}, http.StatusOK
+	}
+
+	value, err := serializeDeadProperty(op.Element)
+	if err != nil {
+		h.Logger.Warn("failed to serialize dead property",
+			"property", name,
+			"error", err)
+		return nil, http.StatusBadRequest
+	}
+	if len(value) > maxDeadPropertySize {
+		h.Logger.Warn("dead property too large",
+			"property", name,
+			"size", len(value))
+		return nil, http.StatusInsufficientStorage
+	}
+	prop := storage.DeadProperty{Namespace: op.Namespace, Name: name, XML: value}
+	return func() error {
+		return dead.SetDeadProperty(res.UserID, res.CalendarID, res.ObjectID, prop)
+	}, http.StatusOK
+}
+
+// serializeDeadProperty writes elem as a standalone XML fragment. Namespace
+// declarations of its ancestors are copied onto it when the property or its
+// value uses them, so prefixes still resolve once it's out of the request.
+func serializeDeadProperty(elem *etree.Element) (string, error) {
+	used := map[string]bool{}
+	var collect func(e *etree.Element)
+	collect = func(e *etree.Element) {
+		used[e.Space] = true
+		for _, attr := range e.Attr {
+			if attr.Space != "" && attr.Space != "xmlns" {
+				used[attr.Space] = true
+			}
+		}
+		for _, child := range e.ChildElements() {
+			collect(child)
+		}
+	}
+	collect(elem)
+
+	out := elem.Copy()
+	for parent := elem.Parent(); parent != nil; parent = parent.Parent() {
+		for _, attr := range parent.Attr {
+			var prefix string
+			switch {
+			case attr.Space == "xmlns":
+				prefix = attr.Key
+			case attr.Space == "" && attr.Key == "xmlns":
+			default:
+				continue
+			}
+			if used[prefix] && out.SelectAttr(attr.FullKey()) == nil {
+				out.CreateAttr(attr.FullKey(), attr.Value)
+			}
+		}
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(out)
+	return doc.WriteToString()
+}
+
+// resolveDeadProperties fills in the requested dead properties of a calendar
+// or calendar object. Properties the storage doesn't have stay 404.
+func (h *CaldavHandler) resolveDeadProperties(req propfind.ResponseMap, res Resource) propfind.ResponseMap {
+	if res.ResourceType != storage.ResourceCollection && res.ResourceType != storage.ResourceObject {
+		return req
+	}
+	dead, ok := storage.As[storage.DeadPropertyStorage](h.Storage)
+	if !ok {
+		return req
+	}
+	requested := false
+	for key := range req {
+		if _, _, ok := propfind.ParseDeadPropKey(key); ok {
+			requested = true
+			break
+		}
+	}
+	if !requested {
+		return req
+	}
+
+	stored, err := dead.GetDeadProperties(res.UserID, res.CalendarID, res.ObjectID)
+	if err != nil {
+		h.Logger.Error("failed to get dead properties",
+			"resource", res,
+			"error", err)
+		return req
+	}
+	for _, prop := range stored {
+		key := propfind.DeadPropKey(prop.Namespace, prop.Name)
+		if _, ok := req[key]; !ok {
+			continue
+		}
+		doc := etree.NewDocument()
+		if err := doc.ReadFromString(prop.XML); err != nil || doc.Root() == nil {
+			h.Logger.Error("invalid stored dead property",
+				"resource", res,
+				"property", key,
+				"error", err)
+			continue
+		}
+		req[key] = mo.Ok[props.Property](&props.RawProperty{Element: doc.Root()})
+	}
+	return req
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadPropStorage keeps dead properties in memory on top of MockStorage.
+type deadPropStorage struct {
+	*storage.MockStorage
+	props map[string][]storage.DeadProperty
+}
+
+func (s *deadPropStorage) GetDeadProperties(userID, calendarID, objectID string) ([]storage.DeadProperty, error) {
+	return s.props[userID+"/"+calendarID+"/"+objectID], nil
+}
+
+func (s *deadPropStorage) SetDeadProperty(userID, calendarID, objectID string, prop storage.DeadProperty) error {
+	key := userID + "/" + calendarID + "/" + objectID
+	s.RemoveDeadProperty(userID, calendarID, objectID, prop.Namespace, prop.Name)
+	s.props[key] = append(s.props[key], prop)
+	return nil
+}
+
+func (s *deadPropStorage) RemoveDeadProperty(userID, calendarID, objectID, namespace, name string) error {
+	key := userID + "/" + calendarID + "/" + objectID
+	var kept []storage.DeadProperty
+	for _, prop := range s.props[key] {
+		if prop.Namespace != namespace || prop.Name != name {
+			kept = append(kept, prop)
+		}
+	}
+	s.props[key] = kept
+	return nil
+}
+
+func TestDeadProperties(t *testing.T) {
+	store := &deadPropStorage{MockStorage: &storage.MockStorage{}, props: map[string][]storage.DeadProperty{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work"}, nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:example"><D:set><D:prop>
+<X:SnoozeSettings><X:default>15</X:default></X:SnoozeSettings>
+</D:prop></D:set></D:propertyupdate>`
+	w := serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work", body, nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), `<SnoozeSettings xmlns="urn:example"/></d:prop><d:status>HTTP/1.1 200 OK</d:status>`)
+	require.Len(t, store.props["alice/work/"], 1)
+	assert.Equal(t, "SnoozeSettings", store.props["alice/work/"][0].Name)
+
+	propfind := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:Y="urn:example"><D:prop><Y:SnoozeSettings/><Y:other/></D:prop></D:propfind>`
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", propfind, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), `<X:SnoozeSettings xmlns:X="urn:example"><X:default>15</X:default></X:SnoozeSettings>`)
+	assert.Contains(t, w.Body.String(), `<other xmlns="urn:example"/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status>`)
+
+	body = `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:example"><D:remove><D:prop><X:SnoozeSettings/></D:prop></D:remove></D:propertyupdate>`
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work", body, nil)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 200 OK")
+	assert.Empty(t, store.props["alice/work/"])
+}
+
+func TestDeadPropertiesRejected(t *testing.T) {
+	store := &deadPropStorage{MockStorage: &storage.MockStorage{}, props: map[string][]storage.DeadProperty{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Oversized values are refused, and the rest of the request with them
+	big := `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:example"><D:set><D:prop>
+<X:small>1</X:small><X:big>` + strings.Repeat("a", maxDeadPropertySize) + `</X:big>
+</D:prop></D:set></D:propertyupdate>`
+	w := serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work", big, nil)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 507 Insufficient Storage")
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 424 Failed Dependency")
+	assert.Empty(t, store.props)
+
+	// Principals don't take dead properties
+	custom := `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:example"><D:set><D:prop><X:small>1</X:small></D:prop></D:set></D:propertyupdate>`
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/", custom, nil)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+
+	// Neither do backends without DeadPropertyStorage
+	h.Storage = store.MockStorage
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work", custom, nil)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+	assert.Empty(t, store.props)
+}
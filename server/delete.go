@@ -1,7 +1,6 @@
 package server
 
 import (
-	"errors"
 	"net/http"
 
 	"github.com/cyp0633/libcaldora/server/storage"
@@ -14,49 +13,94 @@ func (h *CaldavHandler) handleDelete(w http.ResponseWriter, r *http.Request, ctx
 		"calendar_id", ctx.Resource.CalendarID,
 		"object_id", ctx.Resource.ObjectID)
 
+	if ctx.Resource.ResourceType == storage.ResourceCollection {
+		if store, ok := storage.As[storage.DeletableCalendarStorage](h.Storage); ok {
+			h.handleDeleteCalendar(w, r, ctx, store)
+			return
+		}
+	}
+
 	// DELETE is only valid for ResourceObject
 	if ctx.Resource.ResourceType != storage.ResourceObject {
 		h.Logger.Warn("delete not allowed on resource type",
 			"resource_type", ctx.Resource.ResourceType)
-		http.Error(w, "Method Not Allowed on this resource type", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+		return
+	}
+	if !h.checkNotArchived(w, r, ctx.Resource) {
 		return
 	}
+	unlock, ok := h.lockCollection(w, r, ctx.Resource)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	h.writeObject(w, r, objectWrite{res: ctx.Resource, delete: true, ifMatch: r.Header.Get("If-Match")})
+}
 
-	// Get the object to check if it exists and to get its ETag
-	object, err := h.Storage.GetObject(ctx.Resource.UserID, ctx.Resource.CalendarID, ctx.Resource.ObjectID)
-	if errors.Is(err, storage.ErrNotFound) {
-		h.Logger.Warn("resource not found for deletion",
-			"object_id", ctx.Resource.ObjectID)
-		http.Error(w, "Not Found", http.StatusNotFound)
+// handleDeleteCalendar removes a calendar collection from store, the
+// storage.DeletableCalendarStorage As found on h.Storage. Calendars still
+// holding objects are only deleted with ForceCalendarDelete.
+func (h *CaldavHandler) handleDeleteCalendar(w http.ResponseWriter, r *http.Request, ctx *RequestContext, store storage.DeletableCalendarStorage) {
+	if !h.checkNotArchived(w, r, ctx.Resource) {
 		return
-	} else if err != nil {
-		h.Logger.Error("error retrieving object for deletion",
-			"error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	unlock, ok := h.lockCollection(w, r, ctx.Resource)
+	if !ok {
 		return
 	}
+	defer unlock()
 
-	// Check If-Match header for ETag validation
-	ifMatch := r.Header.Get("If-Match")
-	if ifMatch != "" && ifMatch != object.ETag {
-		h.Logger.Warn("etag mismatch",
-			"client_etag", ifMatch,
-			"server_etag", object.ETag)
-		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+	if !h.checkLockTokens(w, r, ctx.Resource, true) {
 		return
 	}
+	if h.Locks != nil {
+		path, err := h.URLConverter.EncodePath(ctx.Resource)
+		if err != nil {
+			h.Logger.Error("failed to encode path for lock check",
+				"resource", ctx.Resource,
+				"error", err)
+			h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+			return
+		}
+		if root := h.Locks.blockingMember(path, submittedLockTokens(r), lockUser(r)); root != "" {
+			h.Logger.Warn("delete of collection with locked member without lock token",
+				"path", path,
+				"lock_root", root)
+			h.writePrecondition(w, r, http.StatusLocked, "d:lock-token-submitted", "resource is locked by "+root)
+			return
+		}
+	}
 
-	// Delete the object
-	err = h.Storage.DeleteObject(ctx.Resource.UserID, ctx.Resource.CalendarID, ctx.Resource.ObjectID)
+	if !h.ForceCalendarDelete {
+		empty, err := h.calendarEmpty(ctx.Resource.UserID, ctx.Resource.CalendarID)
+		if err != nil {
+			h.writeStorageError(w, r, err, CodeInternal)
+			return
+		}
+		if !empty {
+			h.Logger.Warn("refusing to delete calendar with objects",
+				"user_id", ctx.Resource.UserID,
+				"calendar_id", ctx.Resource.CalendarID)
+			h.writePrecondition(w, r, http.StatusConflict, "lc:calendar-not-empty", "delete the calendar's objects first")
+			return
+		}
+	}
+
+	err := store.DeleteCalendar(ctx.Resource.UserID, ctx.Resource.CalendarID)
 	if err != nil {
-		h.Logger.Error("failed to delete object",
-			"error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		h.writeStorageError(w, r, err, CodeInternal)
 		return
 	}
+	h.objectChanged(ObjectChange{
+		UserID:     ctx.Resource.UserID,
+		CalendarID: ctx.Resource.CalendarID,
+		Deleted:    true,
+	})
 
-	// Return success with no content
-	h.Logger.Info("object deleted successfully",
-		"object_id", ctx.Resource.ObjectID)
+	h.Logger.Info("calendar deleted successfully",
+		"user_id", ctx.Resource.UserID,
+		"calendar_id", ctx.Resource.CalendarID)
 	w.WriteHeader(http.StatusNoContent)
 }
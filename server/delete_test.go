@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -130,6 +131,8 @@ func TestHandleDelete(t *testing.T) {
 
 			// Setup mocks
 			tt.setupMocks()
+			// The calendar is writable unless a case says otherwise
+			mockStorage.On("GetCalendar", userID, calendarID).Return(&storage.Calendar{}, nil).Maybe()
 
 			// Create request
 			req := httptest.NewRequest("DELETE", "/caldav/"+userID+"/cal/"+calendarID+"/"+objectID, nil)
@@ -165,3 +168,39 @@ func TestHandleDelete(t *testing.T) {
 		})
 	}
 }
+
+// calendarDeleteStorage records deleted calendars on top of MockStorage.
+type calendarDeleteStorage struct {
+	*storage.MockStorage
+	deleted []string
+}
+
+func (s *calendarDeleteStorage) DeleteCalendar(userID, calendarID string) error {
+	s.deleted = append(s.deleted, userID+"/"+calendarID)
+	return nil
+}
+
+func TestHandleDeleteCalendar(t *testing.T) {
+	store := &calendarDeleteStorage{MockStorage: new(storage.MockStorage)}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	store.On("GetCalendar", "alice", "empty").Return(&storage.Calendar{}, nil)
+	store.On("GetObjectPathsInCollection", "work").Return([]string{"/caldav/alice/cal/work/a.ics"}, nil)
+	store.On("GetObjectPathsInCollection", "empty").Return([]string{}, nil)
+	h := NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Calendars holding objects are kept unless deletion is forced
+	w := serveAlice(h, http.MethodDelete, "/caldav/alice/cal/work", "", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "calendar-not-empty")
+	assert.Empty(t, store.deleted)
+
+	w = serveAlice(h, http.MethodDelete, "/caldav/alice/cal/empty", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{"alice/empty"}, store.deleted)
+
+	h.ForceCalendarDelete = true
+	w = serveAlice(h, http.MethodDelete, "/caldav/alice/cal/work", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{"alice/empty", "alice/work"}, store.deleted)
+}
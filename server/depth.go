@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DepthInfinity is the Depth of requests asking for Depth: infinity. Handlers
+// treat any Depth above 3 alike.
+const DepthInfinity = 114514
+
+// allowedDepths lists the Depth values each method accepts. Methods not
+// listed ignore the header. DELETE always acts on the whole collection, so
+// clients may only state that (RFC 4918 section 9.6.1).
+var allowedDepths = map[string][]string{
+	"PROPFIND": {"0", "1", "infinity"},
+	"REPORT":   {"0", "1"},
+	"DELETE":   {"infinity"},
+	"LOCK":     {"0", "infinity"},
+}
+
+// requestDepth returns the Depth of r, or an error explaining why its Depth
+// header isn't valid for the method. PROPFIND requests without one get
+// DefaultDepth, others 0. The result is capped at MaxDepth.
+func (h *CaldavHandler) requestDepth(r *http.Request) (int, error) {
+	values := r.Header.Values("Depth")
+	if len(values) == 0 {
+		if r.Method == "PROPFIND" {
+			return min(h.DefaultDepth, h.MaxDepth), nil
+		}
+		return 0, nil
+	}
+	allowed, checked := allowedDepths[r.Method]
+	if !checked {
+		return 0, nil
+	}
+	if len(values) > 1 {
+		return 0, errors.New("only one Depth header is allowed")
+	}
+
+	value := strings.ToLower(strings.TrimSpace(values[0]))
+	for _, ok := range allowed {
+		if value != ok {
+			continue
+		}
+		if value == "infinity" {
+			return min(DepthInfinity, h.MaxDepth), nil
+		}
+		return min(int(value[0]-'0'), h.MaxDepth), nil
+	}
+	return 0, fmt.Errorf("Depth %q is not allowed for %s, use %s", values[0], r.Method, orList(allowed))
+}
+
+// orList joins values as "a, b or c".
+func orList(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
+
+// depthLabel formats a request Depth as the Depth header spells it.
+func depthLabel(depth int) string {
+	if depth > 3 {
+		return "infinity"
+	}
+	return strconv.Itoa(depth)
+}
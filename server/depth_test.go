@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/cyp0633/libcaldora/server/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDepth(t *testing.T) {
+	h := &CaldavHandler{MaxDepth: 3}
+	for _, tt := range []struct {
+		method string
+		depth  []string
+		want   int
+		err    string
+	}{
+		{method: "PROPFIND", want: 0},
+		{method: "PROPFIND", depth: []string{"1"}, want: 1},
+		{method: "PROPFIND", depth: []string{" Infinity "}, want: 3},
+		{method: "PROPFIND", depth: []string{"2"}, err: `Depth "2" is not allowed for PROPFIND, use 0, 1 or infinity`},
+		{method: "PROPFIND", depth: []string{"-1"}, err: `Depth "-1" is not allowed for PROPFIND, use 0, 1 or infinity`},
+		{method: "PROPFIND", depth: []string{"0", "1"}, err: "only one Depth header is allowed"},
+		{method: "REPORT", depth: []string{"1"}, want: 1},
+		{method: "REPORT", depth: []string{"infinity"}, err: `Depth "infinity" is not allowed for REPORT, use 0 or 1`},
+		{method: "DELETE", depth: []string{"infinity"}, want: 3},
+		{method: "DELETE", depth: []string{"0"}, err: `Depth "0" is not allowed for DELETE, use infinity`},
+		{method: "LOCK", depth: []string{"1"}, err: `Depth "1" is not allowed for LOCK, use 0 or infinity`},
+		{method: "PUT", depth: []string{"banana"}, want: 0},
+	} {
+		r := httptest.NewRequest(tt.method, "/caldav/alice/cal/work/", nil)
+		for _, value := range tt.depth {
+			r.Header.Add("Depth", value)
+		}
+		depth, err := h.requestDepth(r)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, "%s %v", tt.method, tt.depth)
+			continue
+		}
+		require.NoError(t, err, "%s %v", tt.method, tt.depth)
+		assert.Equal(t, tt.want, depth, "%s %v", tt.method, tt.depth)
+	}
+
+	// The default only applies to PROPFIND, still capped by MaxDepth
+	h.DefaultDepth = DepthInfinity
+	depth, err := h.requestDepth(httptest.NewRequest("PROPFIND", "/caldav/alice/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 3, depth)
+	depth, err = h.requestDepth(httptest.NewRequest("REPORT", "/caldav/alice/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 0, depth)
+}
+
+func TestInvalidDepthRejected(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", "", map[string]string{"Depth": "2"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, string(CodeInvalidDepth), w.Header().Get(errorCodeHeader))
+	assert.Contains(t, w.Body.String(), `Bad Request: invalid Depth: Depth "2" is not allowed for PROPFIND, use 0, 1 or infinity`)
+
+	w = serveAlice(h, http.MethodDelete, "/caldav/alice/cal/work/", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockStorage.AssertNotCalled(t, "DeleteCalendar", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,12 @@
+// Package server implements a CalDAV server on top of net/http.
+//
+// CaldavHandler is the single entry point: construct it with NewCaldavHandler,
+// passing a storage.Storage implementation for your backend, and mount it
+// under its prefix. Everything backend-specific goes through the storage
+// package; optional capabilities (pagination, search, availability, ...) are
+// separate interfaces in that package which the handler detects at runtime,
+// so a backend only implements what it supports.
+//
+// AdminHandler and the ExportUser/ImportUser methods add operator tooling on
+// top of the same handler.
+package server
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// DuplicateStrategy selects how ResolveDuplicates handles a UID stored more than once.
+type DuplicateStrategy string
+
+const (
+	// DuplicateKeepNewest keeps the copy with the highest SEQUENCE, then the
+	// latest LAST-MODIFIED, and deletes the others.
+	DuplicateKeepNewest DuplicateStrategy = "keep-newest"
+	// DuplicateKeepOldest keeps the copy that DuplicateKeepNewest would rank
+	// last, and deletes the others.
+	DuplicateKeepOldest DuplicateStrategy = "keep-oldest"
+	// DuplicateMerge keeps the newest copy, adds the overridden instances
+	// (RECURRENCE-ID) and time zones it lacks from the other copies, and
+	// deletes them.
+	DuplicateMerge DuplicateStrategy = "merge"
+)
+
+// Duplicate is a UID that a user has stored in more than one object, usually
+// after a migration was run twice.
+type Duplicate struct {
+	UID    string          `json:"uid"`
+	Copies []DuplicateCopy `json:"copies"`
+}
+
+// DuplicateCopy is one stored object holding a duplicated UID.
+type DuplicateCopy struct {
+	CalendarID   string    `json:"calendar_id"`
+	ObjectID     string    `json:"object_id"`
+	Path         string    `json:"path"`
+	ETag         string    `json:"etag,omitempty"`
+	Sequence     int       `json:"sequence"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+
+	object storage.CalendarObject
+}
+
+// DuplicateResolution reports what ResolveDuplicates did with one UID.
+type DuplicateResolution struct {
+	UID     string   `json:"uid"`
+	Kept    string   `json:"kept"`
+	Deleted []string `json:"deleted"`
+	// Merged counts the components copied into the kept object.
+	Merged int `json:"merged,omitempty"`
+}
+
+// FindDuplicates scans every calendar of userID for UIDs stored in more than
+// one object. Copies are ordered newest first.
+func (h *CaldavHandler) FindDuplicates(userID string) ([]Duplicate, error) {
+	calendars, err := h.Storage.GetUserCalendars(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calendars of %q: %w", userID, err)
+	}
+
+	copies := map[string][]DuplicateCopy{}
+	for _, cal := range calendars {
+		calRes, err := h.URLConverter.ParsePath(cal.Path)
+		if err != nil || calRes.ResourceType != storage.ResourceCollection {
+			h.Logger.Warn("skipping calendar with unparsable path during duplicate scan",
+				"path", cal.Path,
+				"error", err)
+			continue
+		}
+		err = h.forEachObject(userID, calRes.CalendarID, func(obj storage.CalendarObject) error {
+			objRes, err := h.URLConverter.ParsePath(obj.Path)
+			if err != nil || objRes.ResourceType != storage.ResourceObject {
+				h.Logger.Warn("skipping object with unparsable path during duplicate scan",
+					"path", obj.Path,
+					"error", err)
+				return nil
+			}
+			master := obj.Master()
+			if master == nil {
+				return nil
+			}
+			uid, _ := master.Props.Text(ical.PropUID)
+			if uid == "" {
+				return nil
+			}
+			copies[uid] = append(copies[uid], DuplicateCopy{
+				CalendarID:   calRes.CalendarID,
+				ObjectID:     objRes.ObjectID,
+				Path:         obj.Path,
+				ETag:         obj.ETag,
+				Sequence:     sequence(master),
+				LastModified: lastModified(obj, master),
+				object:       obj,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of calendar %q: %w", calRes.CalendarID, err)
+		}
+	}
+
+	var dups []Duplicate
+	for uid, list := range copies {
+		if len(list) < 2 {
+			continue
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].Sequence != list[j].Sequence {
+				return list[i].Sequence > list[j].Sequence
+			}
+			return list[i].LastModified.After(list[j].LastModified)
+		})
+		dups = append(dups, Duplicate{UID: uid, Copies: list})
+	}
+	sort.Slice(dups, func(i, j int) bool { return dups[i].UID < dups[j].UID })
+	return dups, nil
+}
+
+// ResolveDuplicates applies strategy to every duplicate found for userID and
+// reports what was kept and deleted. It stops at the first storage error;
+// UIDs handled before it stay resolved.
+func (h *CaldavHandler) ResolveDuplicates(userID string, strategy DuplicateStrategy) ([]DuplicateResolution, error) {
+	switch strategy {
+	case DuplicateKeepNewest, DuplicateKeepOldest, DuplicateMerge:
+	default:
+		return nil, fmt.Errorf("unknown duplicate strategy %q", strategy)
+	}
+
+	dups, err := h.FindDuplicates(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []DuplicateResolution{}
+	for _, dup := range dups {
+		keep, drop := dup.Copies[0], dup.Copies[1:]
+		if strategy == DuplicateKeepOldest {
+			last := len(dup.Copies) - 1
+			keep, drop = dup.Copies[last], dup.Copies[:last]
+		}
+		result := DuplicateResolution{UID: dup.UID, Kept: keep.Path, Deleted: []string{}}
+
+		if strategy == DuplicateMerge {
+			merged := mergeComponents(keep.object.Component, drop)
+			if added := len(merged) - len(keep.object.Component); added > 0 {
+				obj := keep.object
+				obj.Component = merged
+				etag, err := h.Storage.UpdateObject(userID, keep.CalendarID, &obj)
+				if err != nil {
+					return results, fmt.Errorf("failed to update %q: %w", keep.Path, err)
+				}
+				h.objectChanged(ObjectChange{UserID: userID, CalendarID: keep.CalendarID, ObjectID: keep.ObjectID, ETag: etag})
+				result.Merged = added
+			}
+		}
+
+		for _, c := range drop {
+			if err := h.Storage.DeleteObject(userID, c.CalendarID, c.ObjectID); err != nil {
+				return results, fmt.Errorf("failed to delete %q: %w", c.Path, err)
+			}
+			h.objectChanged(ObjectChange{UserID: userID, CalendarID: c.CalendarID, ObjectID: c.ObjectID, Deleted: true})
+			result.Deleted = append(result.Deleted, c.Path)
+		}
+		h.Logger.Info("resolved duplicate uid",
+			"user_id", userID,
+			"uid", dup.UID,
+			"strategy", strategy,
+			"kept", result.Kept,
+			"deleted", len(result.Deleted),
+			"merged", result.Merged)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func lastModified(obj storage.CalendarObject, master *ical.Component) time.Time {
+	if prop := master.Props.Get(ical.PropLastModified); prop != nil {
+		if t, err := prop.DateTime(time.UTC); err == nil {
+			return t
+		}
+	}
+	return obj.LastModified
+}
+
+// mergeComponents returns keep plus the components of the other copies whose
+// instance (or TZID, for time zones) keep doesn't have yet.
+func mergeComponents(keep []*ical.Component, others []DuplicateCopy) []*ical.Component {
+	merged := append([]*ical.Component{}, keep...)
+	seen := map[string]bool{}
+	for _, comp := range keep {
+		seen[mergeKey(comp)] = true
+	}
+	for _, other := range others {
+		for _, comp := range other.object.Component {
+			key := mergeKey(comp)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, comp)
+		}
+	}
+	return merged
+}
+
+func mergeKey(comp *ical.Component) string {
+	if comp.Name == ical.CompTimezone {
+		tzid, _ := comp.Props.Text(ical.PropTimezoneID)
+		return comp.Name + "/" + tzid
+	}
+	return instanceKey(comp)
+}
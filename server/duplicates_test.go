@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newDuplicatesTestHandler() (*CaldavHandler, *storage.MockStorage) {
+	start := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+
+	// An older import of the standup, with an overridden instance
+	old := storage.NewMockEvent("/caldav/alice/cal/work/a.ics", "standup", "Standup", start, start.Add(15*time.Minute))
+	old.LastModified = start
+	override := ical.NewComponent(ical.CompEvent)
+	override.Props.SetText(ical.PropUID, "standup")
+	override.Props.SetDateTime(ical.PropRecurrenceID, start.AddDate(0, 0, 1))
+	override.Props.SetDateTime(ical.PropDateTimeStart, start.AddDate(0, 0, 1).Add(time.Hour))
+	old.Component = append(old.Component, override)
+
+	// The newer copy the user kept editing
+	newer := storage.NewMockEvent("/caldav/alice/cal/home/c.ics", "standup", "Standup", start, start.Add(30*time.Minute))
+	newer.Component[0].Props.SetText(ical.PropSequence, "1")
+
+	other := storage.NewMockEvent("/caldav/alice/cal/work/b.ics", "review", "Review", start, start.Add(time.Hour))
+
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{
+		{Path: "/caldav/alice/cal/work"},
+		{Path: "/caldav/alice/cal/home"},
+	}, nil)
+	mockStorage.On("GetObjectsInCollection", "work").Return([]storage.CalendarObject{old, other}, nil)
+	mockStorage.On("GetObjectsInCollection", "home").Return([]storage.CalendarObject{newer}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return h, mockStorage
+}
+
+func TestFindDuplicates(t *testing.T) {
+	h, _ := newDuplicatesTestHandler()
+	dups, err := h.FindDuplicates("alice")
+	require.NoError(t, err)
+	require.Len(t, dups, 1)
+	assert.Equal(t, "standup", dups[0].UID)
+	require.Len(t, dups[0].Copies, 2)
+	assert.Equal(t, "home", dups[0].Copies[0].CalendarID)
+	assert.Equal(t, 1, dups[0].Copies[0].Sequence)
+	assert.Equal(t, "a.ics", dups[0].Copies[1].ObjectID)
+}
+
+func TestResolveDuplicates(t *testing.T) {
+	t.Run("merge", func(t *testing.T) {
+		h, mockStorage := newDuplicatesTestHandler()
+		mockStorage.On("UpdateObject", "alice", "home", mock.MatchedBy(func(obj *storage.CalendarObject) bool {
+			return obj.Path == "/caldav/alice/cal/home/c.ics" && len(obj.Component) == 2
+		})).Return("etag-merged", nil).Once()
+		mockStorage.On("DeleteObject", "alice", "work", "a.ics").Return(nil).Once()
+
+		results, err := h.ResolveDuplicates("alice", DuplicateMerge)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "/caldav/alice/cal/home/c.ics", results[0].Kept)
+		assert.Equal(t, []string{"/caldav/alice/cal/work/a.ics"}, results[0].Deleted)
+		assert.Equal(t, 1, results[0].Merged)
+		mockStorage.AssertExpectations(t)
+	})
+
+	t.Run("keep oldest", func(t *testing.T) {
+		h, mockStorage := newDuplicatesTestHandler()
+		mockStorage.On("DeleteObject", "alice", "home", "c.ics").Return(nil).Once()
+
+		results, err := h.ResolveDuplicates("alice", DuplicateKeepOldest)
+		require.NoError(t, err)
+		assert.Equal(t, "/caldav/alice/cal/work/a.ics", results[0].Kept)
+		mockStorage.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+		mockStorage.AssertExpectations(t)
+	})
+
+	t.Run("unknown strategy", func(t *testing.T) {
+		h, _ := newDuplicatesTestHandler()
+		_, err := h.ResolveDuplicates("alice", "newest")
+		assert.Error(t, err)
+	})
+}
+
+func TestAdminDuplicates(t *testing.T) {
+	h, mockStorage := newDuplicatesTestHandler()
+	admin := &AdminHandler{Handler: h, Authorize: func(*http.Request) bool { return true }}
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/duplicates?user=alice", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var dups []Duplicate
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &dups))
+	assert.Len(t, dups, 1)
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/duplicates?user=alice&strategy=newest", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockStorage.On("DeleteObject", "alice", "work", "a.ics").Return(nil).Once()
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/duplicates?user=alice&strategy=keep-newest", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"kept": "/caldav/alice/cal/home/c.ics"`)
+	mockStorage.AssertExpectations(t)
+}
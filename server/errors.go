@@ -0,0 +1,249 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// ErrorCode identifies why a request failed. Codes are stable across releases
+// and are sent with every error response, in the Libcaldora-Error header and,
+// with ErrorFormatXML, as an lc: element of the body, so embedders and
+// clients can key translations or handling on them.
+type ErrorCode string
+
+const (
+	CodeInternal             ErrorCode = "internal-error"
+	CodeStorage              ErrorCode = "storage-error"
+	CodeStorageUnavailable   ErrorCode = "storage-unavailable"
+	CodeConflict             ErrorCode = "conflict"
+	CodeInvalidInput         ErrorCode = "invalid-input"
+	CodeResponseFailed       ErrorCode = "response-failed"
+	CodeEncodeFailed         ErrorCode = "encode-failed"
+	CodeReadBody             ErrorCode = "read-body-failed"
+	CodeInvalidBody          ErrorCode = "invalid-body"
+	CodeNotFound             ErrorCode = "not-found"
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeUnauthorized         ErrorCode = "unauthorized"
+	CodeInvalidAuthorization ErrorCode = "invalid-authorization"
+	CodeCrossSiteRequest     ErrorCode = "cross-site-request"
+	CodeMethodNotAllowed     ErrorCode = "method-not-allowed"
+	CodePreconditionFailed   ErrorCode = "precondition-failed"
+	CodeMalformedIf          ErrorCode = "malformed-if-header"
+	CodeInvalidDepth         ErrorCode = "invalid-depth"
+	CodeMissingLockToken     ErrorCode = "missing-lock-token"
+	CodeInvalidLockRefresh   ErrorCode = "invalid-lock-refresh"
+	CodeCollectionBusy       ErrorCode = "collection-busy"
+	CodeUnsupportedReport    ErrorCode = "unsupported-report"
+	CodeUnsupportedResource  ErrorCode = "unsupported-resource-type"
+	CodeUnsupportedMediaType ErrorCode = "unsupported-media-type"
+	CodeUnsupportedAction    ErrorCode = "unsupported-post-action"
+	CodeInvalidCalendarData  ErrorCode = "invalid-calendar-data"
+	CodeEmptyCalendarData    ErrorCode = "empty-calendar-data"
+	CodeFilterMismatch       ErrorCode = "filter-mismatch"
+	CodeCalendarLocation     ErrorCode = "invalid-calendar-location"
+	CodeTooManyResources     ErrorCode = "too-many-resources"
+	CodeRequestTooLarge      ErrorCode = "request-too-large"
+	CodeSearchUnavailable    ErrorCode = "search-unavailable"
+	CodeShareNotFound        ErrorCode = "share-not-found"
+	CodeMissingUser          ErrorCode = "missing-user"
+	CodeInvalidStrategy      ErrorCode = "invalid-strategy"
+	CodeInvalidSnapshot      ErrorCode = "invalid-snapshot"
+	CodeShuttingDown         ErrorCode = "shutting-down"
+	CodeTooManyRequests      ErrorCode = "too-many-requests"
+)
+
+// DefaultErrorMessages holds the English message sent for each ErrorCode.
+// ErrorMessages can replace any of them per request.
+var DefaultErrorMessages = map[ErrorCode]string{
+	CodeInternal:             "Internal Server Error",
+	CodeStorage:              "Internal Server Error: storage failure",
+	CodeStorageUnavailable:   "Service Unavailable: storage is unavailable, try again later",
+	CodeConflict:             "Conflict with the stored resource",
+	CodeInvalidInput:         "Bad Request: rejected by storage",
+	CodeResponseFailed:       "Failed to generate response",
+	CodeEncodeFailed:         "Internal Server Error: failed to encode calendar",
+	CodeReadBody:             "Failed to read request body",
+	CodeInvalidBody:          "Bad Request: invalid request body",
+	CodeNotFound:             "Not Found",
+	CodeForbidden:            "Forbidden: Access denied to the requested resource",
+	CodeUnauthorized:         "Unauthorized",
+	CodeInvalidAuthorization: "Bad Request: invalid Authorization header",
+	CodeCrossSiteRequest:     "Forbidden: cross-site request",
+	CodeMethodNotAllowed:     "Method Not Allowed on this resource type",
+	CodePreconditionFailed:   "Precondition Failed",
+	CodeMalformedIf:          "Bad Request: malformed If header",
+	CodeInvalidDepth:         "Bad Request: invalid Depth",
+	CodeMissingLockToken:     "Bad Request: missing Lock-Token",
+	CodeInvalidLockRefresh:   "Bad Request: refresh needs exactly one lock token",
+	CodeCollectionBusy:       "Service Unavailable: calendar is busy",
+	CodeUnsupportedReport:    "Unsupported report type",
+	CodeUnsupportedResource:  "Unsupported resource type for this request",
+	CodeUnsupportedMediaType: "Unsupported Media Type",
+	CodeUnsupportedAction:    "Bad Request: unsupported POST action",
+	CodeInvalidCalendarData:  "Invalid iCalendar data",
+	CodeEmptyCalendarData:    "No valid components found in iCalendar data",
+	CodeFilterMismatch:       "Object does not match filter",
+	CodeCalendarLocation:     "Method Not Allowed: MKCALENDAR can only be used to create a calendar collection",
+	CodeTooManyResources:     "Request Entity Too Large: too many resources",
+	CodeRequestTooLarge:      "Request Entity Too Large",
+	CodeSearchUnavailable:    "Forbidden: search is not available",
+	CodeShareNotFound:        "Share link not found or expired",
+	CodeMissingUser:          "Bad Request: missing user parameter",
+	CodeInvalidStrategy:      "Bad Request: strategy must be keep-newest, keep-oldest or merge",
+	CodeInvalidSnapshot:      "Bad Request: invalid snapshot",
+	CodeShuttingDown:         "Service Unavailable: server is shutting down",
+	CodeTooManyRequests:      "Service Unavailable: too many concurrent requests, try again later",
+}
+
+// ErrorFormat selects the body of error responses.
+type ErrorFormat int
+
+const (
+	// ErrorFormatText sends the message as text/plain. This is the default.
+	ErrorFormatText ErrorFormat = iota
+	// ErrorFormatXML sends a WebDAV error body like precondition failures,
+	// holding an lc: element named after the code and an lc:message.
+	ErrorFormatXML
+)
+
+// errorCodeHeader carries the ErrorCode of every error response.
+const errorCodeHeader = "Libcaldora-Error"
+
+// errorMessage returns the message for code, translated by ErrorMessages when
+// set. fallback is used for codes outside DefaultErrorMessages.
+func (h *CaldavHandler) errorMessage(r *http.Request, code ErrorCode, fallback string) string {
+	message, ok := DefaultErrorMessages[code]
+	if !ok {
+		message = fallback
+	}
+	if h.ErrorMessages != nil && r != nil {
+		if translated := h.ErrorMessages(r, code, message); translated != "" {
+			return translated
+		}
+	}
+	return message
+}
+
+// writeError answers with status and the catalog message for code, in the
+// configured ErrorFormat.
+func (h *CaldavHandler) writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode) {
+	h.writeErrorDetail(w, r, status, code, "")
+}
+
+// writeErrorDetail is writeError with detail appended to the message.
+func (h *CaldavHandler) writeErrorDetail(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, detail string) {
+	w.Header().Set(errorCodeHeader, string(code))
+	message := h.errorMessage(r, code, http.StatusText(status))
+	if detail != "" {
+		message += ": " + detail
+	}
+	if h.ErrorFormat == ErrorFormatXML {
+		h.writeErrorBody(w, status, "lc:"+string(code), message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// storageErrorResponse is how requests failing with a kind of storage error
+// are answered: with a status and ErrorCode, or a precondition element and
+// its default message.
+type storageErrorResponse struct {
+	kind      error
+	status    int
+	code      ErrorCode
+	condition string
+	message   string
+}
+
+// storageErrors maps the storage error kinds to responses. Handlers answer
+// storage failures through it, see writeStorageError, rather than picking
+// statuses themselves.
+var storageErrors = []storageErrorResponse{
+	{kind: storage.ErrNotFound, status: http.StatusNotFound, code: CodeNotFound},
+	{kind: storage.ErrPermissionDenied, status: http.StatusForbidden, code: CodeForbidden},
+	{kind: storage.ErrUnauthorized, status: http.StatusUnauthorized, code: CodeUnauthorized},
+	{kind: storage.ErrInvalidInput, status: http.StatusBadRequest, code: CodeInvalidInput},
+	{kind: storage.ErrConflict, status: http.StatusConflict, code: CodeConflict},
+	{kind: storage.ErrPreconditionFailed, status: http.StatusPreconditionFailed, code: CodePreconditionFailed},
+	{kind: storage.ErrQuotaExceeded, status: http.StatusInsufficientStorage,
+		condition: "d:quota-not-exceeded", message: "storage quota exceeded"},
+	{kind: storage.ErrTooLarge, status: http.StatusRequestEntityTooLarge,
+		condition: "cal:max-resource-size", message: "resource is larger than the storage accepts"},
+	{kind: storage.ErrInvalidSyncToken, status: http.StatusForbidden,
+		condition: "d:valid-sync-token", message: "sync token is no longer valid, a full resync is required"},
+	{kind: storage.ErrStorageUnavailable, status: http.StatusServiceUnavailable, code: CodeStorageUnavailable},
+	{kind: storage.ErrTransient, status: http.StatusServiceUnavailable, code: CodeStorageUnavailable},
+}
+
+// lookupStorageError returns the response for the kind of err, if it has
+// one of the mapped kinds.
+func lookupStorageError(err error) (storageErrorResponse, bool) {
+	for _, resp := range storageErrors {
+		if errors.Is(err, resp.kind) {
+			return resp, true
+		}
+	}
+	return storageErrorResponse{}, false
+}
+
+// storageErrorStatus returns the status a storage error maps to, for
+// multistatus responses: 500 for errors of no known kind.
+func storageErrorStatus(err error) int {
+	if resp, ok := lookupStorageError(err); ok {
+		return resp.status
+	}
+	return http.StatusInternalServerError
+}
+
+// writeStorageError answers a request failing with a storage error, using
+// storageErrors and the Message of a storage.Error. Errors of no known kind
+// are logged and answered with 500 and fallback. 503 responses carry
+// Retry-After, and transient errors are flagged for serveRetrying.
+func (h *CaldavHandler) writeStorageError(w http.ResponseWriter, r *http.Request, err error, fallback ErrorCode) {
+	if storage.IsTransient(err) {
+		if ctx, ok := RequestContextFrom(r.Context()); ok {
+			ctx.transient = true
+		}
+	}
+	resp, ok := lookupStorageError(err)
+	if !ok {
+		h.Logger.Error("storage error",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, fallback)
+		return
+	}
+	if resp.status == http.StatusServiceUnavailable {
+		h.setRetryAfter(w)
+	}
+	if resp.status >= http.StatusInternalServerError {
+		h.Logger.Error("storage failure",
+			"path", r.URL.Path,
+			"error", err)
+	} else {
+		h.Logger.Info("storage refused request",
+			"path", r.URL.Path,
+			"error", err)
+	}
+
+	var detail string
+	var storageErr *storage.Error
+	if errors.As(err, &storageErr) {
+		detail = storageErr.Message
+	}
+	switch {
+	case resp.status == http.StatusUnauthorized:
+		h.requireAuth(w, r)
+	case resp.condition != "":
+		message := resp.message
+		if detail != "" {
+			message = detail
+		}
+		h.writePrecondition(w, r, resp.status, resp.condition, message)
+	default:
+		h.writeErrorDetail(w, r, resp.status, resp.code, detail)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWriteError(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+
+	w := serveAnonymous(h, "PROPFIND", "/caldav/", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "unauthorized", w.Header().Get("Libcaldora-Error"))
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Unauthorized\n", w.Body.String())
+
+	h.ErrorFormat = ErrorFormatXML
+	w = serveAnonymous(h, "PROPFIND", "/caldav/", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<lc:unauthorized/><lc:message>Unauthorized</lc:message>")
+}
+
+func TestErrorMessages(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.ErrorMessages = func(r *http.Request, code ErrorCode, message string) string {
+		if r.Header.Get("Accept-Language") != "de" {
+			return ""
+		}
+		switch code {
+		case CodeUnauthorized:
+			return "Nicht angemeldet"
+		case "d:need-privileges":
+			return "Keine Berechtigung: " + message
+		}
+		return ""
+	}
+
+	r := httptest.NewRequest("PROPFIND", "/caldav/", nil)
+	r.Header.Set("Accept-Language", "de")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, "Nicht angemeldet\n", w.Body.String())
+
+	// Untranslated codes keep the default message
+	w = serveAnonymous(h, "PROPFIND", "/caldav/", "")
+	assert.Equal(t, "Unauthorized\n", w.Body.String())
+
+	// Preconditions are translated by their name
+	w = httptest.NewRecorder()
+	h.writePrecondition(w, r, http.StatusForbidden, "d:need-privileges", "write privilege required")
+	assert.Equal(t, "d:need-privileges", w.Header().Get("Libcaldora-Error"))
+	assert.Contains(t, w.Body.String(), "<lc:message>Keine Berechtigung: write privilege required</lc:message>")
+}
+
+func TestStorageErrorMapping(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250101T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	for _, tc := range []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{storage.ErrPermissionDenied, http.StatusForbidden, "Forbidden"},
+		{storage.ErrConflict, http.StatusConflict, "Conflict"},
+		{storage.ErrPreconditionFailed, http.StatusPreconditionFailed, "Precondition Failed"},
+		{storage.ErrInvalidInput, http.StatusBadRequest, "rejected by storage"},
+		{storage.ErrStorageUnavailable, http.StatusServiceUnavailable, "try again later"},
+		{storage.ErrQuotaExceeded, http.StatusInsufficientStorage, "<d:quota-not-exceeded/>"},
+		{storage.NewError(storage.ErrQuotaExceeded, "100 MB per user"), http.StatusInsufficientStorage, "100 MB per user"},
+		{storage.Wrap(storage.ErrTooLarge, errors.New("row too big")), http.StatusRequestEntityTooLarge, "<cal:max-resource-size/>"},
+		{fmt.Errorf("saving: %w", storage.NewError(storage.ErrConflict, "UID in use")), http.StatusConflict, "Conflict with the stored resource: UID in use"},
+		{errors.New("disk failure"), http.StatusInternalServerError, "storage failure"},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			h, mockStorage := newTestHandler(&storage.Calendar{})
+			mockStorage.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrNotFound)
+			mockStorage.On("UpdateObject", "alice", "work", mock.Anything).Return("", tc.err)
+
+			w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", ics, map[string]string{"Content-Type": "text/calendar"})
+			assert.Equal(t, tc.status, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrUnauthorized)
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", ics, map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// ETagMode selects which entity tag the handler emits for calendar objects.
+type ETagMode int
+
+const (
+	// ETagStorage emits CalendarObject.ETag exactly as the backend returns it. This is the default.
+	ETagStorage ETagMode = iota
+	// ETagContentHash emits a strong tag computed from a SHA-256 hash of the
+	// object's iCalendar content, for backends without trustworthy ETags.
+	ETagContentHash
+	// ETagWeak emits W/"<revision>" built from CalendarObject.Revision, for
+	// backends that can't cheaply hash content. Objects without a revision fall
+	// back to the storage ETag.
+	ETagWeak
+)
+
+// objectETag returns the entity tag to emit for obj according to h.ETagMode.
+// An empty result means the object has no usable tag.
+func (h *CaldavHandler) objectETag(obj *storage.CalendarObject) string {
+	switch h.ETagMode {
+	case ETagContentHash:
+		if tag := contentHashETag(obj.Component); tag != "" {
+			return tag
+		}
+	case ETagWeak:
+		if obj.Revision > 0 {
+			return weakETag(obj.Revision)
+		}
+	}
+	return obj.ETag
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value names
+// obj. Any of the object's tags (storage, content hash or weak revision) is
+// accepted and comparison is weak, so clients keep working when ETagMode
+// changes or when they send a weak tag back in If-Match.
+func (h *CaldavHandler) etagMatches(header string, obj *storage.CalendarObject) bool {
+	if obj == nil {
+		return false
+	}
+	hashed := false
+	var hash string
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		tag := opaqueTag(candidate)
+		if tag == "" {
+			continue
+		}
+		if obj.ETag != "" && tag == opaqueTag(obj.ETag) {
+			return true
+		}
+		if obj.Revision > 0 && tag == opaqueTag(weakETag(obj.Revision)) {
+			return true
+		}
+		// Hashing is the expensive comparison; do it at most once
+		if !hashed {
+			hash, hashed = opaqueTag(contentHashETag(obj.Component)), true
+		}
+		if hash != "" && tag == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// opaqueTag strips the weak indicator and quotes from an entity tag.
+func opaqueTag(tag string) string {
+	return strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+}
+
+func weakETag(revision uint64) string {
+	return fmt.Sprintf(`W/"%d"`, revision)
+}
+
+// contentHashETag hashes the components' names, properties and parameters in
+// a canonical order. It does not go through the iCalendar encoder, which
+// rejects objects lacking required properties such as DTSTAMP.
+func contentHashETag(components []*ical.Component) string {
+	h := sha256.New()
+	n := 0
+	var write func(comp *ical.Component)
+	write = func(comp *ical.Component) {
+		fmt.Fprintf(h, "BEGIN:%s\n", comp.Name)
+		names := make([]string, 0, len(comp.Props))
+		for name := range comp.Props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, prop := range comp.Props[name] {
+				fmt.Fprintf(h, "%s", name)
+				params := make([]string, 0, len(prop.Params))
+				for param := range prop.Params {
+					params = append(params, param)
+				}
+				sort.Strings(params)
+				for _, param := range params {
+					fmt.Fprintf(h, ";%s=%q", param, prop.Params[param])
+				}
+				fmt.Fprintf(h, ":%s\n", prop.Value)
+			}
+		}
+		for _, child := range comp.Children {
+			if child != nil {
+				write(child)
+			}
+		}
+		fmt.Fprintf(h, "END:%s\n", comp.Name)
+	}
+	for _, comp := range components {
+		if comp != nil {
+			write(comp)
+			n++
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
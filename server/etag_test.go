@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectETagModes(t *testing.T) {
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	obj := storage.NewMockEvent("/caldav/alice/cal/work/e.ics", "uid-1", "Standup", start, start.Add(time.Hour))
+	obj.Revision = 7
+
+	h := NewCaldavHandler("/caldav/", "Test Realm", &storage.MockStorage{}, 1, nil, nil)
+	assert.Equal(t, obj.ETag, h.objectETag(&obj))
+
+	h.ETagMode = ETagWeak
+	assert.Equal(t, `W/"7"`, h.objectETag(&obj))
+	noRevision := obj
+	noRevision.Revision = 0
+	assert.Equal(t, obj.ETag, h.objectETag(&noRevision))
+
+	h.ETagMode = ETagContentHash
+	hash := h.objectETag(&obj)
+	assert.True(t, strings.HasPrefix(hash, `"`) && len(hash) == 66, hash)
+	assert.Equal(t, hash, h.objectETag(&obj), "hash must be stable")
+
+	// Every form of the tag satisfies a precondition, whatever the mode
+	for _, header := range []string{obj.ETag, `"` + obj.ETag + `"`, `W/"7"`, hash, `"other", ` + hash, "*"} {
+		assert.True(t, h.etagMatches(header, &obj), header)
+	}
+	assert.False(t, h.etagMatches(`W/"6"`, &obj))
+	assert.False(t, h.etagMatches(`"other"`, nil))
+}
+
+func TestGetEmitsWeakETag(t *testing.T) {
+	mockStorage := &storage.MockStorage{}
+	h := NewCaldavHandler("/caldav/", "Test Realm", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.ETagMode = ETagWeak
+
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	obj := storage.NewMockEvent("/caldav/alice/cal/work/e.ics", "uid-1", "Standup", start, start.Add(time.Hour))
+	obj.Revision = 3
+	obj.Component[0].Props.SetDateTime(ical.PropDateTimeStamp, start)
+	cal := storage.NewMockCalendar("/caldav/alice/cal/work", "Work", "Work Calendar")
+	cal.CalendarData.Props.SetText(ical.PropProductID, "-//Test//EN")
+	cal.CalendarData.Props.SetText(ical.PropVersion, "2.0")
+	mockStorage.On("GetObject", "alice", "work", "e.ics").Return(&obj, nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&cal, nil)
+
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ObjectID: "e.ics", ResourceType: storage.ResourceObject}}
+
+	w := httptest.NewRecorder()
+	h.handleGet(w, httptest.NewRequest("GET", "/caldav/alice/cal/work/e.ics", nil), ctx)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `W/"3"`, w.Header().Get("ETag"))
+
+	req := httptest.NewRequest("GET", "/caldav/alice/cal/work/e.ics", nil)
+	req.Header.Set("If-None-Match", `W/"3"`)
+	w = httptest.NewRecorder()
+	h.handleGet(w, req, ctx)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
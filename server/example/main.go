@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"log/slog"
@@ -33,6 +34,13 @@ func main() {
 
 	// Create the CalDAV handler with our storage
 	handler := server.NewCaldavHandler(caldavPrefix, serverRealm, memStorage, maxDepth, nil, logger)
+	handler.ObjectIDs = server.UIDSlugObjectID
+
+	// Forget deletions after the default retention; clients that haven't
+	// synced since then get a full resync
+	handler.Jobs = &server.JobRunner{Logger: logger}
+	handler.Jobs.Add(server.CompactTombstonesJob(memStorage, storage.DefaultTombstoneRetention, time.Hour, logger))
+	handler.Jobs.Start(context.Background())
 
 	// Register the handler with the HTTP server
 	http.Handle(caldavPrefix, handler)
@@ -176,7 +184,6 @@ func createCalendarForUser(ms *MemoryStorage, userID, calendarID, name, color st
 // createEvent is a helper function to create a calendar event
 func createEvent(userID, calendarID, summary, location string, start, end time.Time) storage.CalendarObject {
 	eventUID := uuid.New().String()
-	eventID := fmt.Sprintf("%s.ics", eventUID[:8])
 
 	event := ical.NewEvent()
 	event.Props.SetText(ical.PropUID, eventUID)
@@ -185,10 +192,11 @@ func createEvent(userID, calendarID, summary, location string, start, end time.T
 	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now())
 	event.Props.SetDateTime(ical.PropDateTimeStart, start)
 	event.Props.SetDateTime(ical.PropDateTimeEnd, end)
+	eventID := server.UIDSlugObjectID([]*ical.Component{event.Component})
 
 	return storage.CalendarObject{
 		Path:      fmt.Sprintf("/caldav/%s/cal/%s/%s", userID, calendarID, eventID),
-		ETag:      fmt.Sprintf("etag-%s-%d", eventUID[:8], time.Now().Unix()),
+		ETag:      fmt.Sprintf("etag-%s-%d", eventUID, time.Now().Unix()),
 		Component: []*ical.Component{event.Component},
 	}
 }
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/cyp0633/libcaldora/server/synctoken"
+	"github.com/emersion/go-ical"
 	"github.com/google/uuid"
 )
 
@@ -25,6 +31,21 @@ type MemoryStorage struct {
 	// Objects map: userID -> calendarID -> objectID -> CalendarObject
 	objects map[string]map[string]map[string]storage.CalendarObject
 
+	// Availability map: userID -> VCALENDAR with VAVAILABILITY components
+	availability map[string]*ical.Calendar
+
+	// Calendar preferences: "userID/calendarID" -> principal -> preferences
+	preferences map[string]map[string]storage.CalendarPreferences
+
+	// Notifications map: userID -> notifications, oldest first
+	notifications map[string][]storage.Notification
+
+	// Time-range indexes: userID -> calendarID -> index over that calendar's objects
+	indexes map[string]map[string]*timeIndex
+
+	// Change log with deletion tombstones, scoped by "userID/calendarID"
+	changes *storage.ChangeLog
+
 	// Logger
 	log *slog.Logger
 }
@@ -36,11 +57,20 @@ func NewMemoryStorage() *MemoryStorage {
 		Level: slog.LevelDebug,
 	}))
 
+	// Tokens don't need to survive a restart, since the data doesn't either
+	key := make([]byte, 32)
+	rand.Read(key)
+
 	return &MemoryStorage{
-		users:     make(map[string]storage.User),
-		calendars: make(map[string]map[string]storage.Calendar),
-		objects:   make(map[string]map[string]map[string]storage.CalendarObject),
-		log:       logger,
+		users:         make(map[string]storage.User),
+		calendars:     make(map[string]map[string]storage.Calendar),
+		objects:       make(map[string]map[string]map[string]storage.CalendarObject),
+		indexes:       make(map[string]map[string]*timeIndex),
+		availability:  make(map[string]*ical.Calendar),
+		preferences:   make(map[string]map[string]storage.CalendarPreferences),
+		changes:       storage.NewChangeLog(synctoken.NewCodec(key), nil),
+		notifications: make(map[string][]storage.Notification),
+		log:           logger,
 	}
 }
 
@@ -56,11 +86,13 @@ func (m *MemoryStorage) RegisterUser(userID string, displayName string) {
 		UserAddress:       fmt.Sprintf("mailto:%s@example.com", userID),
 		PreferredColor:    "#4285F4", // Default blue color
 		PreferredTimezone: "UTC",
+		WeekStart:         "MO",
 	}
 
 	// Initialize maps for the user
 	m.calendars[userID] = make(map[string]storage.Calendar)
 	m.objects[userID] = make(map[string]map[string]storage.CalendarObject)
+	m.indexes[userID] = make(map[string]*timeIndex)
 
 	m.log.Info("User registered successfully", "userID", userID)
 }
@@ -165,7 +197,7 @@ func (m *MemoryStorage) CreateCalendar(userID string, calendar *storage.Calendar
 		return storage.ErrInvalidInput
 	}
 
-	calendarID := pathParts[3] // Assuming path format: "/caldav/userID/cal/calendarID/"
+	calendarID := storage.PathSegment(calendar.Path) // Assuming path format: "/caldav/userID/cal/calendarID/"
 	m.log.Debug("Extracted calendarID from path", "calendarID", calendarID)
 
 	// Check if calendar already exists
@@ -194,11 +226,58 @@ func (m *MemoryStorage) CreateCalendar(userID string, calendar *storage.Calendar
 		m.objects[userID] = make(map[string]map[string]storage.CalendarObject)
 	}
 	m.objects[userID][calendarID] = make(map[string]storage.CalendarObject)
+	m.calendarIndex(userID, calendarID)
 
 	m.log.Info("Calendar created successfully", "userID", userID, "calendarID", calendarID, "path", calendar.Path)
 	return nil
 }
 
+// UpdateCalendar replaces the properties of an existing calendar
+func (m *MemoryStorage) UpdateCalendar(userID, calendarID string, calendar *storage.Calendar) error {
+	m.log.Debug("Updating calendar", "userID", userID, "calendarID", calendarID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, exists := m.calendars[userID][calendarID]
+	if !exists {
+		m.log.Warn("Calendar not found when updating", "userID", userID, "calendarID", calendarID)
+		return storage.ErrNotFound
+	}
+
+	calendar.Path = existing.Path
+	calendar.CTag = existing.CTag
+	calendar.ETag = fmt.Sprintf("etag-calendar-%s", uuid.New().String())
+	m.calendars[userID][calendarID] = *calendar
+
+	m.log.Info("Calendar updated", "userID", userID, "calendarID", calendarID)
+	return nil
+}
+
+// DeleteCalendar removes a calendar collection together with its objects
+func (m *MemoryStorage) DeleteCalendar(userID, calendarID string) error {
+	m.log.Debug("Deleting calendar", "userID", userID, "calendarID", calendarID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.calendars[userID][calendarID]; !exists {
+		m.log.Warn("Calendar not found when deleting", "userID", userID, "calendarID", calendarID)
+		return storage.ErrNotFound
+	}
+	// Tombstones keep old sync tokens honest if the calendar is created again
+	for _, obj := range m.objects[userID][calendarID] {
+		m.changes.Delete(changeScope(userID, calendarID), obj.Path)
+	}
+	delete(m.calendars[userID], calendarID)
+	delete(m.objects[userID], calendarID)
+	delete(m.indexes[userID], calendarID)
+	delete(m.preferences, changeScope(userID, calendarID))
+
+	m.log.Info("Calendar deleted", "userID", userID, "calendarID", calendarID)
+	return nil
+}
+
 // GetObjectsInCollection retrieves all calendar objects in a given calendar collection
 func (m *MemoryStorage) GetObjectsInCollection(calendarID string) ([]storage.CalendarObject, error) {
 	m.log.Debug("Getting objects in collection", "calendarID", calendarID)
@@ -297,6 +376,54 @@ func (m *MemoryStorage) GetObjectPathsInCollection(calendarID string) ([]string,
 	return []string{}, nil
 }
 
+// ListObjects returns one page of a calendar's objects ordered by object ID.
+// The cursor encodes the last object ID of the previous page, so objects added
+// or removed between pages don't shift the remaining ones.
+func (m *MemoryStorage) ListObjects(userID, calendarID string, opts storage.ListOptions) (*storage.ObjectPage, error) {
+	m.log.Debug("Listing objects",
+		"userID", userID, "calendarID", calendarID, "limit", opts.Limit, "cursor", opts.Cursor)
+
+	after := ""
+	if opts.Cursor != "" {
+		var err error
+		if after, err = storage.DecodeCursor(opts.Cursor); err != nil {
+			m.log.Warn("Invalid list cursor", "cursor", opts.Cursor)
+			return nil, err
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	calObjs, exists := m.objects[userID][calendarID]
+	if !exists {
+		m.log.Warn("Calendar not found when listing objects",
+			"userID", userID, "calendarID", calendarID)
+		return nil, storage.ErrNotFound
+	}
+
+	ids := make([]string, 0, len(calObjs))
+	for objID := range calObjs {
+		if objID > after {
+			ids = append(ids, objID)
+		}
+	}
+	sort.Strings(ids)
+
+	page := &storage.ObjectPage{}
+	if opts.Limit > 0 && len(ids) > opts.Limit {
+		ids = ids[:opts.Limit]
+		page.NextCursor = storage.EncodeCursor(ids[len(ids)-1])
+	}
+	for _, objID := range ids {
+		page.Objects = append(page.Objects, calObjs[objID])
+	}
+
+	m.log.Info("Listed objects",
+		"userID", userID, "calendarID", calendarID, "count", len(page.Objects))
+	return page, nil
+}
+
 // GetObject finds a calendar object by user id, calendar id and object id
 func (m *MemoryStorage) GetObject(userID, calendarID, objectID string) (*storage.CalendarObject, error) {
 	m.log.Debug("Getting object", "userID", userID, "calendarID", calendarID, "objectID", objectID)
@@ -332,6 +459,19 @@ func (m *MemoryStorage) GetObject(userID, calendarID, objectID string) (*storage
 	return &obj, nil
 }
 
+// GetObjectByUID finds the object of a calendar holding uid
+func (m *MemoryStorage) GetObjectByUID(userID, calendarID, uid string) (*storage.CalendarObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, obj := range m.objects[userID][calendarID] {
+		if obj.UID() == uid {
+			return &obj, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
 // GetObjectByFilter finds calendar objects by filter
 func (m *MemoryStorage) GetObjectByFilter(userID, calendarID string, filter *storage.Filter) ([]storage.CalendarObject, error) {
 	filterDesc := "nil"
@@ -359,10 +499,24 @@ func (m *MemoryStorage) GetObjectByFilter(userID, calendarID string, filter *sto
 		return nil, storage.ErrNotFound
 	}
 
+	// Narrow the scan through the time-range index when the filter requires a range
+	candidates := calObjs
+	if tr := requiredTimeRange(filter); tr != nil {
+		if idx, exists := m.indexes[userID][calendarID]; exists {
+			ids := idx.candidates(tr.Start, tr.End)
+			candidates = make(map[string]storage.CalendarObject, len(ids))
+			for _, id := range ids {
+				candidates[id] = calObjs[id]
+			}
+			m.log.Debug("Narrowed objects by time-range index",
+				"userID", userID, "calendarID", calendarID, "candidates", len(candidates))
+		}
+	}
+
 	// Convert map to slice
-	objects := make([]storage.CalendarObject, 0, len(calObjs))
+	objects := make([]storage.CalendarObject, 0, len(candidates))
 	matchCount := 0
-	for objID, obj := range calObjs {
+	for objID, obj := range candidates {
 		// Skip if object doesn't match the filter
 		if filter != nil && !filter.Validate(&obj) {
 			m.log.Debug("Object doesn't match filter",
@@ -421,13 +575,15 @@ func (m *MemoryStorage) UpdateObject(userID, calendarID string, object *storage.
 		return "", storage.ErrInvalidInput
 	}
 
-	objectID := pathParts[4] // Assuming path format: "/caldav/userID/cal/calendarID/objectID.ics"
+	objectID := storage.PathSegment(object.Path) // Assuming path format: "/caldav/userID/cal/calendarID/objectID.ics"
 	m.log.Debug("Extracted objectID from path", "objectID", objectID)
 
 	oldETag := ""
+	object.Revision = 1
 	// Check if object already exists
 	if existingObj, exists := m.objects[userID][calendarID][objectID]; exists {
 		oldETag = existingObj.ETag
+		object.Revision = existingObj.Revision + 1
 		m.log.Debug("Updating existing object",
 			"userID", userID, "calendarID", calendarID,
 			"objectID", objectID, "oldETag", oldETag)
@@ -447,11 +603,13 @@ func (m *MemoryStorage) UpdateObject(userID, calendarID string, object *storage.
 
 	// Store the object
 	m.objects[userID][calendarID][objectID] = *object
+	m.calendarIndex(userID, calendarID).put(objectID, object)
+	m.changes.Update(changeScope(userID, calendarID), object.Path, object.ETag)
 
-	// Update the calendar's CTag
+	// Update the calendar's CTag, from the change log like the sync token
 	oldCTag := userCals[calendarID].CTag
 	cal := userCals[calendarID]
-	cal.CTag = fmt.Sprintf("ctag-%s-%d", calendarID, time.Now().Unix())
+	cal.CTag = m.changes.CTag(changeScope(userID, calendarID))
 	m.calendars[userID][calendarID] = cal
 	m.log.Debug("Updated calendar CTag",
 		"userID", userID, "calendarID", calendarID,
@@ -498,12 +656,14 @@ func (m *MemoryStorage) DeleteObject(userID, calendarID, objectID string) error
 
 	// Delete the object
 	delete(m.objects[userID][calendarID], objectID)
+	m.calendarIndex(userID, calendarID).remove(objectID)
+	m.changes.Delete(changeScope(userID, calendarID), obj.Path)
 
 	// Update the calendar's CTag
 	userCals := m.calendars[userID]
 	oldCTag := userCals[calendarID].CTag
 	cal := userCals[calendarID]
-	cal.CTag = fmt.Sprintf("ctag-%s-%d", calendarID, time.Now().Unix())
+	cal.CTag = m.changes.CTag(changeScope(userID, calendarID))
 	m.calendars[userID][calendarID] = cal
 	m.log.Debug("Updated calendar CTag",
 		"userID", userID, "calendarID", calendarID,
@@ -528,7 +688,7 @@ func (m *MemoryStorage) AddEvent(userID, calendarID string, event storage.Calend
 		return
 	}
 
-	objectID := pathParts[4] // Assuming path format: "/caldav/userID/cal/calendarID/objectID.ics"
+	objectID := storage.PathSegment(event.Path) // Assuming path format: "/caldav/userID/cal/calendarID/objectID.ics"
 	m.log.Debug("Extracted objectID from path", "objectID", objectID)
 
 	// Check if user exists
@@ -562,12 +722,14 @@ func (m *MemoryStorage) AddEvent(userID, calendarID string, event storage.Calend
 
 	// Store the event
 	m.objects[userID][calendarID][objectID] = event
+	m.calendarIndex(userID, calendarID).put(objectID, &event)
+	m.changes.Update(changeScope(userID, calendarID), event.Path, event.ETag)
 
 	// Update the calendar's CTag
 	if userCals, exists := m.calendars[userID]; exists {
 		if cal, exists := userCals[calendarID]; exists {
 			oldCTag := cal.CTag
-			cal.CTag = fmt.Sprintf("ctag-%s-%d", calendarID, time.Now().Unix())
+			cal.CTag = m.changes.CTag(changeScope(userID, calendarID))
 			m.calendars[userID][calendarID] = cal
 			m.log.Debug("Updated calendar CTag", "userID", userID, "calendarID", calendarID,
 				"oldCTag", oldCTag, "newCTag", cal.CTag)
@@ -578,6 +740,216 @@ func (m *MemoryStorage) AddEvent(userID, calendarID string, event storage.Calend
 		"objectID", objectID, "etag", event.ETag)
 }
 
+func changeScope(userID, calendarID string) string {
+	return userID + "/" + calendarID
+}
+
+// GetSyncToken returns the current sync token of a calendar
+func (m *MemoryStorage) GetSyncToken(userID, calendarID string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, exists := m.calendars[userID][calendarID]; !exists {
+		return "", storage.ErrNotFound
+	}
+	return m.changes.Token(changeScope(userID, calendarID)), nil
+}
+
+// GetChanges lists the objects of a calendar changed since token
+func (m *MemoryStorage) GetChanges(userID, calendarID, token string) (*storage.ChangeSet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, exists := m.calendars[userID][calendarID]; !exists {
+		return nil, storage.ErrNotFound
+	}
+	changes, err := m.changes.Changes(changeScope(userID, calendarID), token)
+	if err != nil {
+		m.log.Info("Rejected sync token", "userID", userID, "calendarID", calendarID)
+		return nil, err
+	}
+	return changes, nil
+}
+
+// CompactTombstones drops tombstones of objects deleted before cutoff
+func (m *MemoryStorage) CompactTombstones(cutoff time.Time) (int, error) {
+	return m.changes.CompactTombstones(cutoff)
+}
+
+// SearchObjects does a case-insensitive substring match on SUMMARY, DESCRIPTION
+// and LOCATION. A real backend would use a full-text index instead.
+func (m *MemoryStorage) SearchObjects(userID, query string, opts storage.SearchOptions) ([]storage.CalendarObject, error) {
+	m.log.Debug("Searching objects", "userID", userID, "query", query)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userObjs, exists := m.objects[userID]
+	if !exists {
+		m.log.Warn("User not found when searching objects", "userID", userID)
+		return nil, storage.ErrNotFound
+	}
+
+	calendarIDs := opts.CalendarIDs
+	if len(calendarIDs) == 0 {
+		for calID := range userObjs {
+			calendarIDs = append(calendarIDs, calID)
+		}
+		sort.Strings(calendarIDs)
+	}
+
+	needle := strings.ToLower(query)
+	var results []storage.CalendarObject
+	for _, calID := range calendarIDs {
+		objIDs := make([]string, 0, len(userObjs[calID]))
+		for objID := range userObjs[calID] {
+			objIDs = append(objIDs, objID)
+		}
+		sort.Strings(objIDs)
+		for _, objID := range objIDs {
+			obj := userObjs[calID][objID]
+			if matchesSearch(&obj, needle, opts.Components) {
+				results = append(results, obj)
+				if opts.Limit > 0 && len(results) == opts.Limit {
+					return results, nil
+				}
+			}
+		}
+	}
+
+	m.log.Info("Search completed", "userID", userID, "results", len(results))
+	return results, nil
+}
+
+func matchesSearch(obj *storage.CalendarObject, needle string, components []string) bool {
+	for _, comp := range obj.Component {
+		if len(components) > 0 && !slices.Contains(components, comp.Name) {
+			continue
+		}
+		for _, name := range []string{ical.PropSummary, ical.PropDescription, ical.PropLocation} {
+			for _, prop := range comp.Props.Values(name) {
+				if strings.Contains(strings.ToLower(prop.Value), needle) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// GetUserAvailability returns the user's published availability
+func (m *MemoryStorage) GetUserAvailability(userID string) (*ical.Calendar, error) {
+	m.log.Debug("Getting user availability", "userID", userID)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cal, exists := m.availability[userID]
+	if !exists {
+		return nil, storage.ErrNotFound
+	}
+	return cal, nil
+}
+
+// SetUserAvailability replaces the user's published availability, or removes it if cal is nil
+func (m *MemoryStorage) SetUserAvailability(userID string, cal *ical.Calendar) error {
+	m.log.Debug("Setting user availability", "userID", userID, "remove", cal == nil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[userID]; !exists {
+		m.log.Warn("User not found when setting availability", "userID", userID)
+		return storage.ErrNotFound
+	}
+	if cal == nil {
+		delete(m.availability, userID)
+	} else {
+		m.availability[userID] = cal
+	}
+
+	m.log.Info("User availability updated", "userID", userID)
+	return nil
+}
+
+// GetCalendarPreferences returns what principal set for a calendar
+func (m *MemoryStorage) GetCalendarPreferences(principal, userID, calendarID string) (storage.CalendarPreferences, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.preferences[changeScope(userID, calendarID)][principal], nil
+}
+
+// SetCalendarPreferences replaces what principal set for a calendar
+func (m *MemoryStorage) SetCalendarPreferences(principal, userID, calendarID string, prefs storage.CalendarPreferences) error {
+	m.log.Debug("Setting calendar preferences", "principal", principal, "userID", userID, "calendarID", calendarID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.calendars[userID][calendarID]; !exists {
+		m.log.Warn("Calendar not found when setting preferences", "userID", userID, "calendarID", calendarID)
+		return storage.ErrNotFound
+	}
+	scope := changeScope(userID, calendarID)
+	if prefs.IsZero() {
+		delete(m.preferences[scope], principal)
+		return nil
+	}
+	if _, exists := m.preferences[scope]; !exists {
+		m.preferences[scope] = make(map[string]storage.CalendarPreferences)
+	}
+	m.preferences[scope][principal] = prefs
+	return nil
+}
+
+// AddNotification stores a notification for a user
+func (m *MemoryStorage) AddNotification(userID string, n storage.Notification) error {
+	m.log.Debug("Adding notification", "userID", userID, "id", n.ID, "type", n.Type)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.notifications[userID] = append(m.notifications[userID], n)
+	return nil
+}
+
+// GetNotifications returns a user's notifications, oldest first
+func (m *MemoryStorage) GetNotifications(userID string) ([]storage.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Clone(m.notifications[userID]), nil
+}
+
+// DeleteNotification removes a user's notification
+func (m *MemoryStorage) DeleteNotification(userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.notifications[userID], func(n storage.Notification) bool { return n.ID == id })
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	m.notifications[userID] = slices.Delete(m.notifications[userID], i, i+1)
+	m.log.Info("Notification deleted", "userID", userID, "id", id)
+	return nil
+}
+
+// calendarIndex returns the time-range index of a calendar, creating it if
+// needed. The caller must hold the write lock.
+func (m *MemoryStorage) calendarIndex(userID, calendarID string) *timeIndex {
+	if _, exists := m.indexes[userID]; !exists {
+		m.indexes[userID] = make(map[string]*timeIndex)
+	}
+	idx, exists := m.indexes[userID][calendarID]
+	if !exists {
+		idx = newTimeIndex()
+		m.indexes[userID][calendarID] = idx
+	}
+	return idx
+}
+
 // Helper function to split a path
 func pathSplit(path string) []string {
 	var parts []string
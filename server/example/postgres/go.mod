@@ -0,0 +1,24 @@
+module github.com/cyp0633/libcaldora/server/example/postgres
+
+go 1.23.5
+
+require (
+	github.com/cyp0633/libcaldora v0.0.0
+	github.com/emersion/go-ical v0.0.0-20240127095438-fc1c9d8fb2b6
+	github.com/google/uuid v1.6.0
+	github.com/lib/pq v1.10.9
+	github.com/stretchr/testify v1.10.0
+	golang.org/x/crypto v0.31.0
+)
+
+require (
+	github.com/beevik/etree v1.5.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/samber/mo v1.13.0 // indirect
+	github.com/stretchr/objx v0.5.2 // indirect
+	github.com/teambition/rrule-go v1.8.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/cyp0633/libcaldora => ../../..
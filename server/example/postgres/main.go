@@ -0,0 +1,344 @@
+// Command caldora-pg is a multi-user CalDAV server backed by PostgreSQL. It
+// shows a realistic libcaldora deployment: persistent storage with the
+// optional storage extensions, per-device app passwords hashed with bcrypt,
+// TLS, Prometheus metrics, the admin API and graceful shutdown.
+//
+// Usage:
+//
+//	caldora-pg [serve]                       run the server
+//	caldora-pg adduser <id> <name> [email]   create or update a user
+//	caldora-pg apppassword <id> <label>      issue an app password for a device
+//	caldora-pg reindex                       recompute the time-range index
+//
+// Configuration is read from the environment, see README.md.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server"
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/cyp0633/libcaldora/server/synctoken"
+	_ "github.com/lib/pq"
+)
+
+const caldavPrefix = "/caldav/"
+
+// config is read from the environment.
+type config struct {
+	databaseURL  string
+	listenAddr   string
+	tlsCert      string
+	tlsKey       string
+	internalAddr string
+	adminToken   string
+	adminUsers   []string
+	realm        string
+	logLevel     slog.Level
+	logBodies    bool
+	syncKey      []byte
+	retention    time.Duration
+	reindex      time.Duration
+	warmDays     int
+	freeBusyTTL  time.Duration
+	externalURL  string
+	forwarded    bool
+	objectIDs    server.ObjectIDGenerator
+	retries      int
+	concurrent   int
+	largeWarn    int
+}
+
+func loadConfig() (config, error) {
+	cfg := config{
+		databaseURL:  os.Getenv("DATABASE_URL"),
+		listenAddr:   envOr("LISTEN_ADDR", ":8443"),
+		tlsCert:      os.Getenv("TLS_CERT_FILE"),
+		tlsKey:       os.Getenv("TLS_KEY_FILE"),
+		internalAddr: envOr("INTERNAL_ADDR", "127.0.0.1:9090"),
+		adminToken:   os.Getenv("ADMIN_TOKEN"),
+		adminUsers:   strings.FieldsFunc(os.Getenv("ADMIN_USERS"), func(r rune) bool { return r == ',' || r == ' ' }),
+		realm:        envOr("REALM", "libcaldora"),
+		logBodies:    os.Getenv("LOG_BODIES") == "1",
+		syncKey:      []byte(os.Getenv("SYNC_TOKEN_KEY")),
+		externalURL:  os.Getenv("EXTERNAL_URL"),
+		forwarded:    os.Getenv("TRUST_FORWARDED_HEADERS") == "1",
+	}
+	if cfg.databaseURL == "" {
+		return cfg, errors.New("DATABASE_URL is required")
+	}
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return cfg, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if err := cfg.logLevel.UnmarshalText([]byte(envOr("LOG_LEVEL", "info"))); err != nil {
+		return cfg, fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	retention, err := time.ParseDuration(envOr("TOMBSTONE_RETENTION", storage.DefaultTombstoneRetention.String()))
+	if err != nil {
+		return cfg, fmt.Errorf("TOMBSTONE_RETENTION: %w", err)
+	}
+	cfg.retention = retention
+	if cfg.reindex, err = time.ParseDuration(envOr("REINDEX_INTERVAL", "720h")); err != nil {
+		return cfg, fmt.Errorf("REINDEX_INTERVAL: %w", err)
+	}
+	if cfg.warmDays, err = strconv.Atoi(envOr("WARM_DAYS", "0")); err != nil {
+		return cfg, fmt.Errorf("WARM_DAYS: %w", err)
+	}
+	if cfg.freeBusyTTL, err = time.ParseDuration(envOr("FREEBUSY_CACHE_TTL", "5m")); err != nil {
+		return cfg, fmt.Errorf("FREEBUSY_CACHE_TTL: %w", err)
+	}
+	if cfg.retries, err = strconv.Atoi(envOr("STORAGE_RETRIES", "2")); err != nil {
+		return cfg, fmt.Errorf("STORAGE_RETRIES: %w", err)
+	}
+	if cfg.concurrent, err = strconv.Atoi(envOr("MAX_CONCURRENT_REPORTS", "4")); err != nil {
+		return cfg, fmt.Errorf("MAX_CONCURRENT_REPORTS: %w", err)
+	}
+	if cfg.largeWarn, err = strconv.Atoi(envOr("LARGE_RESPONSE_WARN", "1000")); err != nil {
+		return cfg, fmt.Errorf("LARGE_RESPONSE_WARN: %w", err)
+	}
+	switch style := os.Getenv("OBJECT_ID_STYLE"); style {
+	case "":
+	case "uuid":
+		cfg.objectIDs = server.UUIDObjectID
+	case "uid":
+		cfg.objectIDs = server.UIDSlugObjectID
+	case "hash":
+		cfg.objectIDs = server.HashObjectID
+	default:
+		return cfg, fmt.Errorf("OBJECT_ID_STYLE: unknown style %q", style)
+	}
+	return cfg, nil
+}
+
+// addJobs registers the background work of the server: compacting
+// tombstones, moving the instance windows on and, with WARM_DAYS, warming
+// every user's recurrence cache, first right after starting.
+func addJobs(handler *server.CaldavHandler, cfg config, store *PostgresStorage, logger *slog.Logger) error {
+	jobs := []server.Job{server.CompactTombstonesJob(store, cfg.retention, time.Hour, logger.With("component", "storage"))}
+	if cfg.reindex > 0 {
+		jobs = append(jobs, server.Job{
+			Name:     "reindex",
+			Interval: cfg.reindex,
+			Jitter:   time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := store.Reindex(ctx)
+				logger.Info("reindexed objects", "objects", n)
+				return err
+			},
+		})
+	}
+	if handler.Warmer != nil {
+		jobs = append(jobs, handler.Warmer.Job(24*time.Hour, store.UserIDs))
+	}
+	for _, job := range jobs {
+		if err := handler.Jobs.Add(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "caldora-pg:", err)
+		os.Exit(2)
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.logLevel}))
+
+	db, err := sql.Open("postgres", cfg.databaseURL)
+	if err != nil {
+		logger.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(20)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	if len(cfg.syncKey) == 0 {
+		// Tokens from earlier runs become invalid and clients resync from scratch
+		logger.Warn("SYNC_TOKEN_KEY is unset; using a random key")
+		cfg.syncKey = make([]byte, 32)
+		rand.Read(cfg.syncKey)
+	}
+	store, err := NewPostgresStorage(db, synctoken.NewCodec(cfg.syncKey), 10*time.Second, logger.With("component", "storage"))
+	if err != nil {
+		logger.Error("failed to initialize storage", "error", err)
+		os.Exit(1)
+	}
+
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	switch args[0] {
+	case "serve":
+		err = serve(cfg, store, logger)
+	case "adduser":
+		if len(args) < 3 || len(args) > 4 {
+			err = errors.New("usage: caldora-pg adduser <id> <name> [email]")
+			break
+		}
+		email := ""
+		if len(args) == 4 {
+			email = args[3]
+		}
+		err = store.CreateUser(args[1], args[2], email)
+	case "apppassword":
+		if len(args) != 3 {
+			err = errors.New("usage: caldora-pg apppassword <id> <label>")
+			break
+		}
+		var password string
+		if password, err = store.AddAppPassword(args[1], args[2]); err == nil {
+			fmt.Printf("App password for %s (%s): %s\n", args[1], args[2], password)
+		}
+	case "reindex":
+		var n int
+		if n, err = store.Reindex(context.Background()); err == nil {
+			fmt.Printf("Reindexed %d objects\n", n)
+		}
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		logger.Error("command failed", "command", args[0], "error", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the public CalDAV listener and the internal listener (metrics,
+// health and admin API) until SIGINT or SIGTERM.
+func serve(cfg config, store *PostgresStorage, logger *slog.Logger) error {
+	handler := server.NewCaldavHandler(caldavPrefix, cfg.realm, store, 3, nil, logger.With("component", "caldav"))
+	handler.LogBodies = cfg.logBodies
+	if cfg.externalURL != "" {
+		handler.URLConverter = &server.DefaultURLConverter{Prefix: caldavPrefix, ExternalURL: cfg.externalURL}
+	}
+	handler.TrustForwardedHeaders = cfg.forwarded
+	handler.IsAdmin = func(userID string) bool { return slices.Contains(cfg.adminUsers, userID) }
+	handler.ObjectIDs = cfg.objectIDs
+	handler.Retry = server.RetryPolicy{Attempts: cfg.retries, Backoff: 50 * time.Millisecond}
+	if cfg.concurrent > 0 {
+		handler.Concurrency = server.NewConcurrencyLimiter(cfg.concurrent, 2*time.Second)
+	}
+
+	m := newMetrics()
+	if cfg.warmDays > 0 {
+		handler.Recurrence = recurrence.NewEngineWithConfig(recurrence.HighPerformanceConfig)
+		defer handler.Recurrence.Close()
+		handler.Warmer = &server.RecurrenceWarmer{Handler: handler, Days: cfg.warmDays, Budget: time.Minute}
+		m.warmer = handler.Warmer
+	}
+	handler.Jobs = &server.JobRunner{Logger: logger.With("component", "jobs"), MaxRunning: 1}
+	m.jobs = handler.Jobs
+	if err := addJobs(handler, cfg, store, logger); err != nil {
+		return err
+	}
+	if cfg.freeBusyTTL > 0 {
+		handler.FreeBusyCache = &server.FreeBusyCache{TTL: cfg.freeBusyTTL}
+		m.freeBusy = handler.FreeBusyCache
+	}
+	handler.ResponseSizes = server.NewResponseSizes(cfg.largeWarn, 0)
+	m.responses = handler.ResponseSizes
+	public := http.NewServeMux()
+	public.Handle(caldavPrefix, m.wrap(handler))
+	public.HandleFunc("/.well-known/caldav", handler.ServeWellKnown)
+
+	internal := http.NewServeMux()
+	internal.Handle("/metrics", m)
+	internal.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := store.db.PingContext(ctx); err != nil {
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	internal.Handle("/admin/", http.StripPrefix("/admin", &server.AdminHandler{
+		Handler:   handler,
+		Authorize: bearerToken(cfg.adminToken),
+	}))
+
+	publicServer := &http.Server{
+		Addr:              cfg.listenAddr,
+		Handler:           public,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	internalServer := &http.Server{
+		Addr:              cfg.internalAddr,
+		Handler:           internal,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		logger.Info("serving CalDAV", "addr", cfg.listenAddr, "tls", cfg.tlsCert != "")
+		var err error
+		if cfg.tlsCert != "" {
+			err = publicServer.ListenAndServeTLS(cfg.tlsCert, cfg.tlsKey)
+		} else {
+			logger.Warn("TLS is disabled; only run without it behind a TLS-terminating proxy")
+			err = publicServer.ListenAndServe()
+		}
+		errs <- err
+	}()
+	go func() {
+		logger.Info("serving metrics, health and admin API", "addr", cfg.internalAddr)
+		errs <- internalServer.ListenAndServe()
+	}()
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	handler.Jobs.Start(stop)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-stop.Done():
+		logger.Info("shutting down")
+	}
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelShutdown()
+	return errors.Join(publicServer.Shutdown(ctx), internalServer.Shutdown(ctx), handler.Shutdown(ctx))
+}
+
+// bearerToken authorizes admin requests carrying "Authorization: Bearer <token>".
+// An empty token disables the admin API.
+func bearerToken(token string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		if token == "" {
+			return false
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server"
+)
+
+// metrics counts requests by method and status and sums their latency. It
+// serves the Prometheus text exposition format without pulling in a client
+// library.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]*requestStats
+	inFlight int
+	// warmer, if set, has its counters appended
+	warmer *server.RecurrenceWarmer
+	// freeBusy, if set, has its counters appended
+	freeBusy *server.FreeBusyCache
+	// jobs, if set, has the counters of its background jobs appended
+	jobs *server.JobRunner
+	// responses, if set, has the multistatus sizes appended
+	responses *server.ResponseSizes
+}
+
+type requestKey struct {
+	method string
+	status int
+}
+
+type requestStats struct {
+	count   uint64
+	seconds float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[requestKey]*requestStats)}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// wrap instruments next.
+func (m *metrics) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inFlight--
+		key := requestKey{method: r.Method, status: rec.status}
+		stats, ok := m.requests[key]
+		if !ok {
+			stats = &requestStats{}
+			m.requests[key] = stats
+		}
+		stats.count++
+		stats.seconds += time.Since(start).Seconds()
+	})
+}
+
+// ServeHTTP writes the metrics in Prometheus text format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	snapshot := make(map[requestKey]requestStats, len(m.requests))
+	for key, stats := range m.requests {
+		keys = append(keys, key)
+		snapshot[key] = *stats
+	}
+	inFlight := m.inFlight
+	m.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP caldav_requests_total CalDAV requests by method and status.")
+	fmt.Fprintln(w, "# TYPE caldav_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "caldav_requests_total{method=%q,status=\"%d\"} %d\n", key.method, key.status, snapshot[key].count)
+	}
+	fmt.Fprintln(w, "# HELP caldav_request_duration_seconds_sum Total time spent serving CalDAV requests.")
+	fmt.Fprintln(w, "# TYPE caldav_request_duration_seconds_sum counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "caldav_request_duration_seconds_sum{method=%q,status=\"%d\"} %s\n",
+			key.method, key.status, strconv.FormatFloat(snapshot[key].seconds, 'f', -1, 64))
+	}
+	fmt.Fprintln(w, "# HELP caldav_requests_in_flight CalDAV requests currently being served.")
+	fmt.Fprintln(w, "# TYPE caldav_requests_in_flight gauge")
+	fmt.Fprintf(w, "caldav_requests_in_flight %d\n", inFlight)
+	if m.warmer != nil {
+		m.warmer.WriteMetrics(w)
+	}
+	if m.freeBusy != nil {
+		m.freeBusy.WriteMetrics(w)
+	}
+	if m.jobs != nil {
+		m.jobs.WriteMetrics(w)
+	}
+	if m.responses != nil {
+		m.responses.WriteMetrics(w)
+	}
+}
@@ -0,0 +1,116 @@
+-- Schema for the libcaldora PostgreSQL example. Every statement is idempotent;
+-- the server applies this file on startup.
+
+CREATE TABLE IF NOT EXISTS users (
+    id           text PRIMARY KEY,
+    display_name text NOT NULL DEFAULT '',
+    email        text NOT NULL DEFAULT '',
+    color        text NOT NULL DEFAULT '',
+    timezone     text NOT NULL DEFAULT '',
+    -- VCALENDAR holding the user's VAVAILABILITY components, if published
+    availability text
+);
+
+-- Per-device app passwords; users never log in with a primary password.
+CREATE TABLE IF NOT EXISTS app_passwords (
+    id           bigserial PRIMARY KEY,
+    user_id      text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    label        text NOT NULL,
+    hash         bytea NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    last_used_at timestamptz,
+    UNIQUE (user_id, label)
+);
+
+-- Every change to a calendar or object draws a new value from this sequence,
+-- which backs ETags, CTags and object revisions.
+CREATE SEQUENCE IF NOT EXISTS revisions;
+
+CREATE TABLE IF NOT EXISTS calendars (
+    user_id    text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    id         text NOT NULL,
+    read_only  boolean NOT NULL DEFAULT false,
+    components text[] NOT NULL DEFAULT '{VEVENT}',
+    -- VCALENDAR with the collection properties (NAME, COLOR, ...)
+    data       text NOT NULL,
+    revision   bigint NOT NULL DEFAULT nextval('revisions'),
+    ctag       bigint NOT NULL DEFAULT nextval('revisions'),
+    PRIMARY KEY (user_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS objects (
+    user_id     text NOT NULL,
+    calendar_id text NOT NULL,
+    id          text NOT NULL,
+    ics         text NOT NULL,
+    revision    bigint NOT NULL DEFAULT nextval('revisions'),
+    modified_at timestamptz NOT NULL DEFAULT now(),
+    -- Time span used to narrow time-range queries; NULL means unknown or unbounded
+    starts_at   timestamptz,
+    ends_at     timestamptz,
+    -- SUMMARY, DESCRIPTION and LOCATION, for full-text search
+    search_text text NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, calendar_id, id),
+    FOREIGN KEY (user_id, calendar_id) REFERENCES calendars (user_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS objects_span ON objects (user_id, calendar_id, starts_at, ends_at);
+CREATE INDEX IF NOT EXISTS objects_search ON objects USING gin (to_tsvector('simple', search_text));
+
+-- Deleted objects, reported as removals by sync-collection until compacted.
+CREATE TABLE IF NOT EXISTS tombstones (
+    user_id     text NOT NULL,
+    calendar_id text NOT NULL,
+    id          text NOT NULL,
+    revision    bigint NOT NULL DEFAULT nextval('revisions'),
+    deleted_at  timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, calendar_id, id),
+    FOREIGN KEY (user_id, calendar_id) REFERENCES calendars (user_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS tombstones_deleted_at ON tombstones (deleted_at);
+
+-- Newest revision whose tombstone was compacted; older sync tokens are rejected.
+ALTER TABLE calendars ADD COLUMN IF NOT EXISTS sync_horizon bigint NOT NULL DEFAULT 0;
+
+-- Instance window of storage.OccurrenceIndex: the instances of each object
+-- between window_start and window_end. NULL means the object has none kept.
+ALTER TABLE objects ADD COLUMN IF NOT EXISTS window_start timestamptz;
+ALTER TABLE objects ADD COLUMN IF NOT EXISTS window_end timestamptz;
+
+CREATE TABLE IF NOT EXISTS object_instances (
+    user_id     text NOT NULL,
+    calendar_id text NOT NULL,
+    object_id   text NOT NULL,
+    starts_at   timestamptz NOT NULL,
+    ends_at     timestamptz NOT NULL,
+    FOREIGN KEY (user_id, calendar_id, object_id) REFERENCES objects (user_id, calendar_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS object_instances_span ON object_instances (user_id, calendar_id, object_id, starts_at);
+
+-- g:color, g:hidden and g:selected each principal set for a calendar. NULL
+-- means unset.
+CREATE TABLE IF NOT EXISTS calendar_preferences (
+    principal   text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_id     text NOT NULL,
+    calendar_id text NOT NULL,
+    color       text,
+    hidden      boolean,
+    selected    boolean,
+    PRIMARY KEY (principal, user_id, calendar_id),
+    FOREIGN KEY (user_id, calendar_id) REFERENCES calendars (user_id, id) ON DELETE CASCADE
+);
+
+-- Sharees' own name for a calendar.
+ALTER TABLE calendar_preferences ADD COLUMN IF NOT EXISTS display_name text;
+
+-- Notifications waiting in each user's notification collection.
+CREATE TABLE IF NOT EXISTS notifications (
+    user_id    text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    id         text NOT NULL,
+    type       text NOT NULL,
+    created_at timestamptz NOT NULL,
+    xml        text NOT NULL,
+    PRIMARY KEY (user_id, id)
+);
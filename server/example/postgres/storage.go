@@ -0,0 +1,990 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/cyp0633/libcaldora/server/synctoken"
+	"github.com/emersion/go-ical"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed schema.sql
+var schema string
+
+// PostgresStorage keeps users, calendars and objects in PostgreSQL. Besides
+// storage.Storage it implements the optional PaginatedStorage,
+// SearchableStorage, AvailabilityStorage, CalendarPreferenceStorage,
+// SyncStorage and TombstoneStorage extensions.
+type PostgresStorage struct {
+	db      *sql.DB
+	tokens  *synctoken.Codec
+	log     *slog.Logger
+	timeout time.Duration
+}
+
+var (
+	_ storage.Storage                   = (*PostgresStorage)(nil)
+	_ storage.PaginatedStorage          = (*PostgresStorage)(nil)
+	_ storage.SearchableStorage         = (*PostgresStorage)(nil)
+	_ storage.AvailabilityStorage       = (*PostgresStorage)(nil)
+	_ storage.CalendarPreferenceStorage = (*PostgresStorage)(nil)
+	_ storage.NotificationStorage       = (*PostgresStorage)(nil)
+	_ storage.SyncStorage               = (*PostgresStorage)(nil)
+	_ storage.TombstoneStorage          = (*PostgresStorage)(nil)
+	_ storage.CTagStorage               = (*PostgresStorage)(nil)
+	_ storage.UserDirectoryStorage      = (*PostgresStorage)(nil)
+)
+
+// NewPostgresStorage wraps db and applies the schema. Sync tokens are signed
+// with tokens. Every query runs with the given timeout, since the storage
+// interface carries no context.
+func NewPostgresStorage(db *sql.DB, tokens *synctoken.Codec, timeout time.Duration, logger *slog.Logger) (*PostgresStorage, error) {
+	s := &PostgresStorage{db: db, tokens: tokens, log: logger, timeout: timeout}
+	ctx, cancel := s.ctx()
+	defer cancel()
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("apply schema: %w", err)
+	}
+	return s, nil
+}
+
+func (s *PostgresStorage) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+// mapError turns database errors into the storage error types the handler understands.
+func (s *PostgresStorage) mapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "23": // integrity constraint violation
+			return fmt.Errorf("%w: %s", storage.ErrConflict, pqErr.Message)
+		case "40": // serialization failure, deadlock
+			return storage.Transient(err)
+		case "08", "53", "57": // connection, resources, operator intervention
+			s.log.Error("database unavailable", "op", op, "error", err)
+			return storage.ErrStorageUnavailable
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return storage.Transient(err)
+	}
+	s.log.Error("database error", "op", op, "error", err)
+	return err
+}
+
+func calendarPath(userID, calendarID string) string {
+	return fmt.Sprintf("/%s/cal/%s/", url.PathEscape(userID), url.PathEscape(calendarID))
+}
+
+func objectPath(userID, calendarID, objectID string) string {
+	return calendarPath(userID, calendarID) + url.PathEscape(objectID)
+}
+
+func quoteRevision(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// --- Users and authentication ---
+
+// CreateUser adds a user, or updates the display name and address of an existing one.
+func (s *PostgresStorage) CreateUser(userID, displayName, email string) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (id, display_name, email) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET display_name = EXCLUDED.display_name, email = EXCLUDED.email`,
+		userID, displayName, email)
+	return s.mapError("create user", err)
+}
+
+// AddAppPassword generates a random app password for the user, stores its
+// bcrypt hash under label and returns the plain password. It is shown once.
+func (s *PostgresStorage) AddAppPassword(userID, label string) (string, error) {
+	password := strings.ReplaceAll(uuid.NewString(), "-", "")
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := s.ctx()
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO app_passwords (user_id, label, hash) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, label) DO UPDATE SET hash = EXCLUDED.hash, created_at = now(), last_used_at = NULL`,
+		userID, label, hash)
+	if err != nil {
+		return "", s.mapError("add app password", err)
+	}
+	return password, nil
+}
+
+// AuthUser checks password against the user's app passwords.
+func (s *PostgresStorage) AuthUser(username, password string) (string, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT id, hash FROM app_passwords WHERE user_id = $1`, username)
+	if err != nil {
+		return "", s.mapError("auth user", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var hash []byte
+		if err := rows.Scan(&id, &hash); err != nil {
+			return "", s.mapError("auth user", err)
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			// Best effort; a failure here must not fail the login
+			if _, err := s.db.ExecContext(ctx, `UPDATE app_passwords SET last_used_at = now() WHERE id = $1`, id); err != nil {
+				s.log.Warn("failed to record app password use", "user_id", username, "error", err)
+			}
+			return username, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", s.mapError("auth user", err)
+	}
+	s.log.Info("authentication failed", "user_id", username)
+	return "", storage.ErrPermissionDenied
+}
+
+// GetUser returns the user's profile.
+func (s *PostgresStorage) GetUser(userID string) (*storage.User, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	user := &storage.User{Path: "/" + url.PathEscape(userID)}
+	err := s.db.QueryRowContext(ctx, `SELECT display_name, email, color, timezone FROM users WHERE id = $1`, userID).
+		Scan(&user.DisplayName, &user.UserAddress, &user.PreferredColor, &user.PreferredTimezone)
+	if err != nil {
+		return nil, s.mapError("get user", err)
+	}
+	if user.UserAddress != "" && !strings.Contains(user.UserAddress, ":") {
+		user.UserAddress = "mailto:" + user.UserAddress
+	}
+	return user, nil
+}
+
+// UserIDs implements storage.UserDirectoryStorage, listing all users ordered
+// by ID.
+func (s *PostgresStorage) UserIDs() ([]string, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM users ORDER BY id`)
+	if err != nil {
+		return nil, s.mapError("list users", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, s.mapError("list users", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, s.mapError("list users", rows.Err())
+}
+
+// --- Calendars ---
+
+const calendarColumns = `user_id, id, read_only, components, data, revision, ctag`
+
+func scanCalendar(row interface{ Scan(...any) error }) (*storage.Calendar, error) {
+	var userID, calendarID, data string
+	var readOnly bool
+	var components []string
+	var revision, ctag int64
+	if err := row.Scan(&userID, &calendarID, &readOnly, pq.Array(&components), &data, &revision, &ctag); err != nil {
+		return nil, err
+	}
+	calData, err := ical.NewDecoder(strings.NewReader(data)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("decode calendar %s/%s: %w", userID, calendarID, err)
+	}
+	return &storage.Calendar{
+		Path:                calendarPath(userID, calendarID),
+		ReadOnly:            readOnly,
+		CTag:                quoteRevision(ctag),
+		ETag:                quoteRevision(revision),
+		CalendarData:        calData,
+		SupportedComponents: components,
+	}, nil
+}
+
+// GetUserCalendars lists the user's calendars ordered by ID.
+func (s *PostgresStorage) GetUserCalendars(userID string) ([]storage.Calendar, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+calendarColumns+` FROM calendars WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, s.mapError("get user calendars", err)
+	}
+	defer rows.Close()
+	calendars := []storage.Calendar{}
+	for rows.Next() {
+		cal, err := scanCalendar(rows)
+		if err != nil {
+			return nil, s.mapError("get user calendars", err)
+		}
+		calendars = append(calendars, *cal)
+	}
+	return calendars, s.mapError("get user calendars", rows.Err())
+}
+
+// GetCalendar returns one calendar.
+func (s *PostgresStorage) GetCalendar(userID, calendarID string) (*storage.Calendar, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	cal, err := scanCalendar(s.db.QueryRowContext(ctx,
+		`SELECT `+calendarColumns+` FROM calendars WHERE user_id = $1 AND id = $2`, userID, calendarID))
+	return cal, s.mapError("get calendar", err)
+}
+
+// GetCalendarCTag implements storage.CTagStorage, so polling clients that
+// are up to date don't cost more than this lookup.
+func (s *PostgresStorage) GetCalendarCTag(userID, calendarID string) (string, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var ctag int64
+	err := s.db.QueryRowContext(ctx, `SELECT ctag FROM calendars WHERE user_id = $1 AND id = $2`, userID, calendarID).Scan(&ctag)
+	if err != nil {
+		return "", s.mapError("get calendar ctag", err)
+	}
+	return quoteRevision(ctag), nil
+}
+
+// CreateCalendar stores a new calendar. The calendar ID is the last segment
+// of calendar.Path; without a path a random ID is picked.
+func (s *PostgresStorage) CreateCalendar(userID string, calendar *storage.Calendar) error {
+	calendarID := storage.PathSegment(calendar.Path)
+	if calendar.Path == "" || calendarID == "." || calendarID == "/" {
+		calendarID = uuid.NewString()
+	}
+
+	calData := calendar.CalendarData
+	if calData == nil {
+		calData = ical.NewCalendar()
+	}
+	if calData.Props.Get(ical.PropProductID) == nil {
+		calData.Props.SetText(ical.PropProductID, "-//libcaldora//PostgreSQL Example//EN")
+	}
+	if calData.Props.Get(ical.PropVersion) == nil {
+		calData.Props.SetText(ical.PropVersion, "2.0")
+	}
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(calData); err != nil {
+		return fmt.Errorf("%w: %v", storage.ErrInvalidInput, err)
+	}
+	components := calendar.SupportedComponents
+	if len(components) == 0 {
+		components = []string{ical.CompEvent}
+	}
+
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var revision, ctag int64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO calendars (user_id, id, read_only, components, data) VALUES ($1, $2, $3, $4, $5)
+		RETURNING revision, ctag`,
+		userID, calendarID, calendar.ReadOnly, pq.Array(components), buf.String()).Scan(&revision, &ctag)
+	if err != nil {
+		return s.mapError("create calendar", err)
+	}
+	calendar.Path = calendarPath(userID, calendarID)
+	calendar.ETag = quoteRevision(revision)
+	calendar.CTag = quoteRevision(ctag)
+	s.log.Info("calendar created", "user_id", userID, "calendar_id", calendarID)
+	return nil
+}
+
+// --- Objects ---
+
+const objectColumns = `user_id, calendar_id, id, ics, revision, modified_at`
+
+func scanObject(row interface{ Scan(...any) error }) (*storage.CalendarObject, error) {
+	var userID, calendarID, objectID, ics string
+	var revision int64
+	var modified time.Time
+	if err := row.Scan(&userID, &calendarID, &objectID, &ics, &revision, &modified); err != nil {
+		return nil, err
+	}
+	cal, err := ical.NewDecoder(strings.NewReader(ics)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("decode object %s: %w", objectPath(userID, calendarID, objectID), err)
+	}
+	return &storage.CalendarObject{
+		Path:         objectPath(userID, calendarID, objectID),
+		ETag:         quoteRevision(revision),
+		LastModified: modified,
+		Revision:     uint64(revision),
+		Component:    cal.Children,
+	}, nil
+}
+
+func (s *PostgresStorage) queryObjects(op, query string, args ...any) ([]storage.CalendarObject, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, s.mapError(op, err)
+	}
+	defer rows.Close()
+	objects := []storage.CalendarObject{}
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, s.mapError(op, err)
+		}
+		objects = append(objects, *obj)
+	}
+	return objects, s.mapError(op, rows.Err())
+}
+
+// GetObjectsInCollection is only a fallback: the handler uses ListObjects,
+// which knows the owner. Since calendar IDs are unique per user only, an ID
+// shared by several users is reported as a conflict.
+func (s *PostgresStorage) GetObjectsInCollection(calendarID string) ([]storage.CalendarObject, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var owners int
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM calendars WHERE id = $1`, calendarID).Scan(&owners); err != nil {
+		return nil, s.mapError("get objects in collection", err)
+	}
+	switch owners {
+	case 0:
+		return nil, storage.ErrNotFound
+	case 1:
+		return s.queryObjects("get objects in collection",
+			`SELECT `+objectColumns+` FROM objects WHERE calendar_id = $1 ORDER BY id`, calendarID)
+	default:
+		return nil, fmt.Errorf("%w: calendar ID %q is not unique", storage.ErrConflict, calendarID)
+	}
+}
+
+// GetObjectPathsInCollection has the same caveat as GetObjectsInCollection.
+func (s *PostgresStorage) GetObjectPathsInCollection(calendarID string) ([]string, error) {
+	objects, err := s.GetObjectsInCollection(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(objects))
+	for i, obj := range objects {
+		paths[i] = obj.Path
+	}
+	return paths, nil
+}
+
+// ListObjects pages through a calendar by object ID, so the cursor is the last
+// ID of the previous page and stays valid across concurrent changes.
+func (s *PostgresStorage) ListObjects(userID, calendarID string, opts storage.ListOptions) (*storage.ObjectPage, error) {
+	after := ""
+	if opts.Cursor != "" {
+		var err error
+		if after, err = storage.DecodeCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 500
+	}
+	if _, err := s.GetCalendar(userID, calendarID); err != nil {
+		return nil, err
+	}
+	// Fetch one extra row to know whether another page follows
+	objects, err := s.queryObjects("list objects", `
+		SELECT `+objectColumns+` FROM objects
+		WHERE user_id = $1 AND calendar_id = $2 AND id > $3
+		ORDER BY id LIMIT $4`, userID, calendarID, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &storage.ObjectPage{Objects: objects}
+	if len(objects) > limit {
+		page.Objects = objects[:limit]
+		page.NextCursor = storage.EncodeCursor(storage.PathSegment(page.Objects[limit-1].Path))
+	}
+	return page, nil
+}
+
+// GetObject returns one object.
+func (s *PostgresStorage) GetObject(userID, calendarID, objectID string) (*storage.CalendarObject, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	obj, err := scanObject(s.db.QueryRowContext(ctx, `
+		SELECT `+objectColumns+` FROM objects WHERE user_id = $1 AND calendar_id = $2 AND id = $3`,
+		userID, calendarID, objectID))
+	return obj, s.mapError("get object", err)
+}
+
+// GetObjectByFilter narrows candidates in SQL by the filter's time range, then
+// evaluates the full filter in Go.
+func (s *PostgresStorage) GetObjectByFilter(userID, calendarID string, filter *storage.Filter) ([]storage.CalendarObject, error) {
+	if _, err := s.GetCalendar(userID, calendarID); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + objectColumns + ` FROM objects o WHERE user_id = $1 AND calendar_id = $2`
+	args := []any{userID, calendarID}
+	if tr := filter.RequiredTimeRange(); tr != nil {
+		query, args = inTimeRange(query, args, tr.Start, tr.End)
+	}
+	candidates, err := s.queryObjects("get objects by filter", query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	objects := candidates[:0]
+	for i := range candidates {
+		if filter == nil || filter.Validate(&candidates[i]) {
+			objects = append(objects, candidates[i])
+		}
+	}
+	s.log.Debug("filtered objects",
+		"user_id", userID, "calendar_id", calendarID,
+		"candidates", len(candidates), "matches", len(objects))
+	return objects, nil
+}
+
+// GetObjectsInTimeRange implements storage.OccurrenceIndex: objects are
+// looked up by their span and, within their window, by their instances.
+func (s *PostgresStorage) GetObjectsInTimeRange(userID, calendarID string, start, end *time.Time) ([]storage.CalendarObject, error) {
+	if _, err := s.GetCalendar(userID, calendarID); err != nil {
+		return nil, err
+	}
+	query, args := inTimeRange(`SELECT `+objectColumns+` FROM objects o WHERE user_id = $1 AND calendar_id = $2`,
+		[]any{userID, calendarID}, start, end)
+	return s.queryObjects("get objects in time range", query+` ORDER BY id`, args...)
+}
+
+// inTimeRange adds the conditions of storage.ObjectOccurrences.MayOverlap to
+// a query on objects aliased o.
+func inTimeRange(query string, args []any, start, end *time.Time) (string, []any) {
+	if end != nil {
+		args = append(args, *end)
+		query += fmt.Sprintf(` AND (starts_at IS NULL OR starts_at <= $%d)`, len(args))
+	}
+	if start != nil {
+		args = append(args, *start)
+		query += fmt.Sprintf(` AND (ends_at IS NULL OR ends_at >= $%d)`, len(args))
+	}
+	if start != nil && end != nil {
+		query += fmt.Sprintf(` AND (window_end IS NULL OR window_start > $%[1]d OR window_end < $%[2]d OR EXISTS (
+			SELECT 1 FROM object_instances i
+			WHERE i.user_id = o.user_id AND i.calendar_id = o.calendar_id AND i.object_id = o.id
+			AND i.starts_at <= $%[2]d AND i.ends_at >= $%[1]d))`, len(args), len(args)-1)
+	}
+	return query, args
+}
+
+// UpdateObject creates or replaces an object and bumps the calendar's CTag in
+// the same transaction.
+func (s *PostgresStorage) UpdateObject(userID, calendarID string, object *storage.CalendarObject) (string, error) {
+	objectID := storage.PathSegment(object.Path)
+	if object.Path == "" || objectID == "/" || objectID == "." {
+		return "", storage.ErrInvalidInput
+	}
+	ics, err := storage.ICalCompToICS(object.Component, false)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", storage.ErrInvalidInput, err)
+	}
+	start, end, occ := objectSpan(object.Component, time.Now())
+
+	ctx, cancel := s.ctx()
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", s.mapError("update object", err)
+	}
+	defer tx.Rollback()
+
+	var readOnly bool
+	if err := tx.QueryRowContext(ctx, `SELECT read_only FROM calendars WHERE user_id = $1 AND id = $2 FOR UPDATE`,
+		userID, calendarID).Scan(&readOnly); err != nil {
+		return "", s.mapError("update object", err)
+	}
+	if readOnly {
+		return "", storage.ErrPermissionDenied
+	}
+
+	var revision int64
+	var modified time.Time
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO objects (user_id, calendar_id, id, ics, starts_at, ends_at, search_text)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, calendar_id, id) DO UPDATE SET
+			ics = EXCLUDED.ics, starts_at = EXCLUDED.starts_at, ends_at = EXCLUDED.ends_at,
+			search_text = EXCLUDED.search_text, revision = nextval('revisions'), modified_at = now()
+		RETURNING revision, modified_at`,
+		userID, calendarID, objectID, ics, start, end, searchText(object.Component)).Scan(&revision, &modified)
+	if err != nil {
+		return "", s.mapError("update object", err)
+	}
+	if err := writeInstances(ctx, tx, userID, calendarID, objectID, occ); err != nil {
+		return "", s.mapError("update object", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tombstones WHERE user_id = $1 AND calendar_id = $2 AND id = $3`,
+		userID, calendarID, objectID); err != nil {
+		return "", s.mapError("update object", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE calendars SET ctag = nextval('revisions') WHERE user_id = $1 AND id = $2`,
+		userID, calendarID); err != nil {
+		return "", s.mapError("update object", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", s.mapError("update object", err)
+	}
+
+	object.ETag = quoteRevision(revision)
+	object.Revision = uint64(revision)
+	object.LastModified = modified
+	return object.ETag, nil
+}
+
+// DeleteObject removes an object, leaves a tombstone for sync-collection and
+// bumps the calendar's CTag.
+func (s *PostgresStorage) DeleteObject(userID, calendarID, objectID string) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return s.mapError("delete object", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM objects WHERE user_id = $1 AND calendar_id = $2 AND id = $3`,
+		userID, calendarID, objectID)
+	if err != nil {
+		return s.mapError("delete object", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO tombstones (user_id, calendar_id, id) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, calendar_id, id) DO UPDATE SET revision = nextval('revisions'), deleted_at = now()`,
+		userID, calendarID, objectID); err != nil {
+		return s.mapError("delete object", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE calendars SET ctag = nextval('revisions') WHERE user_id = $1 AND id = $2`,
+		userID, calendarID); err != nil {
+		return s.mapError("delete object", err)
+	}
+	return s.mapError("delete object", tx.Commit())
+}
+
+// --- Sync ---
+
+// The CTag is drawn after every object change, so it doubles as the
+// calendar's position in the change log and as the sync token value.
+
+// GetSyncToken returns the calendar's CTag as a signed sync token.
+func (s *PostgresStorage) GetSyncToken(userID, calendarID string) (string, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var ctag int64
+	err := s.db.QueryRowContext(ctx, `SELECT ctag FROM calendars WHERE user_id = $1 AND id = $2`,
+		userID, calendarID).Scan(&ctag)
+	if err != nil {
+		return "", s.mapError("get sync token", err)
+	}
+	return s.tokens.Encode(synctoken.Token{Scope: calendarPath(userID, calendarID), Value: uint64(ctag)}), nil
+}
+
+// GetChanges lists objects and tombstones with a revision newer than token.
+func (s *PostgresStorage) GetChanges(userID, calendarID, token string) (*storage.ChangeSet, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, s.mapError("get changes", err)
+	}
+	defer tx.Rollback()
+
+	scope := calendarPath(userID, calendarID)
+	var ctag, horizon int64
+	if err := tx.QueryRowContext(ctx, `SELECT ctag, sync_horizon FROM calendars WHERE user_id = $1 AND id = $2`,
+		userID, calendarID).Scan(&ctag, &horizon); err != nil {
+		return nil, s.mapError("get changes", err)
+	}
+	var since int64
+	if token != "" {
+		t, err := s.tokens.Decode(token, scope)
+		if err != nil || t.Value > uint64(ctag) || t.Value < uint64(horizon) {
+			return nil, storage.ErrInvalidSyncToken
+		}
+		since = int64(t.Value)
+	}
+
+	// Deletions only matter to clients that have seen the objects
+	query := `SELECT id, revision, false FROM objects WHERE user_id = $1 AND calendar_id = $2 AND revision > $3`
+	if token != "" {
+		query += ` UNION ALL SELECT id, revision, true FROM tombstones WHERE user_id = $1 AND calendar_id = $2 AND revision > $3`
+	}
+	rows, err := tx.QueryContext(ctx, query+` ORDER BY 2`, userID, calendarID, since)
+	if err != nil {
+		return nil, s.mapError("get changes", err)
+	}
+	defer rows.Close()
+
+	set := &storage.ChangeSet{Token: s.tokens.Encode(synctoken.Token{Scope: scope, Value: uint64(ctag)})}
+	for rows.Next() {
+		var objectID string
+		var revision int64
+		var deleted bool
+		if err := rows.Scan(&objectID, &revision, &deleted); err != nil {
+			return nil, s.mapError("get changes", err)
+		}
+		change := storage.Change{Path: objectPath(userID, calendarID, objectID), Deleted: deleted}
+		if !deleted {
+			change.ETag = quoteRevision(revision)
+		}
+		set.Changes = append(set.Changes, change)
+	}
+	return set, s.mapError("get changes", rows.Err())
+}
+
+// CompactTombstones drops tombstones older than cutoff and raises each
+// affected calendar's sync horizon past them.
+func (s *PostgresStorage) CompactTombstones(cutoff time.Time) (int, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var dropped int
+	err := s.db.QueryRowContext(ctx, `
+		WITH dropped AS (
+			DELETE FROM tombstones WHERE deleted_at < $1 RETURNING user_id, calendar_id, revision
+		), horizons AS (
+			UPDATE calendars c SET sync_horizon = greatest(c.sync_horizon, d.revision)
+			FROM (SELECT user_id, calendar_id, max(revision) AS revision FROM dropped GROUP BY 1, 2) d
+			WHERE c.user_id = d.user_id AND c.id = d.calendar_id
+		)
+		SELECT count(*) FROM dropped`, cutoff).Scan(&dropped)
+	if err != nil {
+		return 0, s.mapError("compact tombstones", err)
+	}
+	return dropped, nil
+}
+
+// --- Search ---
+
+// SearchObjects runs a Postgres full-text query over SUMMARY, DESCRIPTION and LOCATION.
+func (s *PostgresStorage) SearchObjects(userID, query string, opts storage.SearchOptions) ([]storage.CalendarObject, error) {
+	sqlQuery := `SELECT ` + objectColumns + ` FROM objects
+		WHERE user_id = $1 AND to_tsvector('simple', search_text) @@ plainto_tsquery('simple', $2)`
+	args := []any{userID, query}
+	if len(opts.CalendarIDs) > 0 {
+		args = append(args, pq.Array(opts.CalendarIDs))
+		sqlQuery += fmt.Sprintf(` AND calendar_id = ANY($%d)`, len(args))
+	}
+	sqlQuery += ` ORDER BY ts_rank(to_tsvector('simple', search_text), plainto_tsquery('simple', $2)) DESC, id`
+	if opts.Limit > 0 && len(opts.Components) == 0 {
+		args = append(args, opts.Limit)
+		sqlQuery += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	objects, err := s.queryObjects("search objects", sqlQuery, args...)
+	if err != nil || len(opts.Components) == 0 {
+		return objects, err
+	}
+
+	// Component restrictions are checked on the parsed objects
+	matched := objects[:0]
+	for _, obj := range objects {
+		if hasComponent(obj.Component, opts.Components) {
+			matched = append(matched, obj)
+			if opts.Limit > 0 && len(matched) == opts.Limit {
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+func hasComponent(components []*ical.Component, names []string) bool {
+	for _, comp := range components {
+		for _, name := range names {
+			if comp != nil && strings.EqualFold(comp.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// --- Availability ---
+
+// GetUserAvailability returns the user's VAVAILABILITY calendar.
+func (s *PostgresStorage) GetUserAvailability(userID string) (*ical.Calendar, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var data sql.NullString
+	if err := s.db.QueryRowContext(ctx, `SELECT availability FROM users WHERE id = $1`, userID).Scan(&data); err != nil {
+		return nil, s.mapError("get availability", err)
+	}
+	if !data.Valid {
+		return nil, storage.ErrNotFound
+	}
+	cal, err := ical.NewDecoder(strings.NewReader(data.String)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("decode availability of %s: %w", userID, err)
+	}
+	return cal, nil
+}
+
+// SetUserAvailability replaces the user's availability; nil removes it.
+func (s *PostgresStorage) SetUserAvailability(userID string, cal *ical.Calendar) error {
+	var data sql.NullString
+	if cal != nil {
+		var buf bytes.Buffer
+		if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+			return fmt.Errorf("%w: %v", storage.ErrInvalidInput, err)
+		}
+		data = sql.NullString{String: buf.String(), Valid: true}
+	}
+	ctx, cancel := s.ctx()
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET availability = $2 WHERE id = $1`, userID, data)
+	if err != nil {
+		return s.mapError("set availability", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// --- Calendar preferences ---
+
+// GetCalendarPreferences returns what principal set for a calendar.
+func (s *PostgresStorage) GetCalendarPreferences(principal, userID, calendarID string) (storage.CalendarPreferences, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	var name, color sql.NullString
+	var hidden, selected sql.NullBool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT display_name, color, hidden, selected FROM calendar_preferences WHERE principal = $1 AND user_id = $2 AND calendar_id = $3`,
+		principal, userID, calendarID).Scan(&name, &color, &hidden, &selected)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.CalendarPreferences{}, nil
+	} else if err != nil {
+		return storage.CalendarPreferences{}, s.mapError("get calendar preferences", err)
+	}
+	var prefs storage.CalendarPreferences
+	if name.Valid {
+		prefs.DisplayName = &name.String
+	}
+	if color.Valid {
+		prefs.Color = &color.String
+	}
+	if hidden.Valid {
+		prefs.Hidden = &hidden.Bool
+	}
+	if selected.Valid {
+		prefs.Selected = &selected.Bool
+	}
+	return prefs, nil
+}
+
+// SetCalendarPreferences replaces what principal set for a calendar; the
+// zero value removes it.
+func (s *PostgresStorage) SetCalendarPreferences(principal, userID, calendarID string, prefs storage.CalendarPreferences) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	if prefs.IsZero() {
+		_, err := s.db.ExecContext(ctx,
+			`DELETE FROM calendar_preferences WHERE principal = $1 AND user_id = $2 AND calendar_id = $3`,
+			principal, userID, calendarID)
+		return s.mapError("remove calendar preferences", err)
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO calendar_preferences (principal, user_id, calendar_id, display_name, color, hidden, selected)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (principal, user_id, calendar_id)
+		DO UPDATE SET display_name = EXCLUDED.display_name, color = EXCLUDED.color,
+			hidden = EXCLUDED.hidden, selected = EXCLUDED.selected`,
+		principal, userID, calendarID, prefs.DisplayName, prefs.Color, prefs.Hidden, prefs.Selected)
+	return s.mapError("set calendar preferences", err)
+}
+
+// --- Notifications ---
+
+// AddNotification stores a notification for the user.
+func (s *PostgresStorage) AddNotification(userID string, n storage.Notification) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO notifications (user_id, id, type, created_at, xml) VALUES ($1, $2, $3, $4, $5)`,
+		userID, n.ID, n.Type, n.Created, n.XML)
+	return s.mapError("add notification", err)
+}
+
+// GetNotifications returns the user's notifications, oldest first.
+func (s *PostgresStorage) GetNotifications(userID string) ([]storage.Notification, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, type, created_at, xml FROM notifications WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, s.mapError("get notifications", err)
+	}
+	defer rows.Close()
+	var notes []storage.Notification
+	for rows.Next() {
+		var n storage.Notification
+		if err := rows.Scan(&n.ID, &n.Type, &n.Created, &n.XML); err != nil {
+			return nil, s.mapError("scan notification", err)
+		}
+		notes = append(notes, n)
+	}
+	return notes, s.mapError("get notifications", rows.Err())
+}
+
+// DeleteNotification removes one of the user's notifications.
+func (s *PostgresStorage) DeleteNotification(userID, id string) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM notifications WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return s.mapError("delete notification", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// --- Helpers ---
+
+// objectSpan returns the first start and last end of the object's instances
+// for the time-range columns, and the instances to store for the window
+// around now. Recurrences that never end have no end, and an object
+// storage.ComputeOccurrences can't index leaves both bounds open, so such
+// objects are always query candidates.
+func objectSpan(components []*ical.Component, now time.Time) (start, end *time.Time, occ storage.ObjectOccurrences) {
+	occ, ok := storage.ComputeOccurrences(&storage.CalendarObject{Component: components}, now)
+	if !ok {
+		return nil, nil, storage.ObjectOccurrences{}
+	}
+	if occ.Last.IsZero() {
+		return &occ.First, nil, occ
+	}
+	return &occ.First, &occ.Last, occ
+}
+
+// writeInstances replaces the instance window of an object.
+func writeInstances(ctx context.Context, tx *sql.Tx, userID, calendarID, objectID string, occ storage.ObjectOccurrences) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE objects SET window_start = $4, window_end = $5
+		WHERE user_id = $1 AND calendar_id = $2 AND id = $3`,
+		userID, calendarID, objectID, nullTime(occ.WindowStart), nullTime(occ.WindowEnd)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM object_instances WHERE user_id = $1 AND calendar_id = $2 AND object_id = $3`,
+		userID, calendarID, objectID); err != nil {
+		return err
+	}
+	if len(occ.Instances) == 0 {
+		return nil
+	}
+	starts := make([]time.Time, len(occ.Instances))
+	ends := make([]time.Time, len(occ.Instances))
+	for i, instance := range occ.Instances {
+		starts[i], ends[i] = instance.Start, instance.End
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO object_instances (user_id, calendar_id, object_id, starts_at, ends_at)
+		SELECT $1, $2, $3, s, e FROM unnest($4::timestamptz[], $5::timestamptz[]) AS t(s, e)`,
+		userID, calendarID, objectID, pq.Array(starts), pq.Array(ends))
+	return err
+}
+
+// Reindex recomputes the time-range columns and instance windows of every
+// object, for moving the windows on as time passes and for objects stored
+// before they existed.
+func (s *PostgresStorage) Reindex(ctx context.Context) (int, error) {
+	type key struct{ userID, calendarID, objectID string }
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, calendar_id, id FROM objects ORDER BY user_id, calendar_id, id`)
+	if err != nil {
+		return 0, s.mapError("reindex", err)
+	}
+	var keys []key
+	for rows.Next() {
+		var k key
+		if err := rows.Scan(&k.userID, &k.calendarID, &k.objectID); err != nil {
+			rows.Close()
+			return 0, s.mapError("reindex", err)
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, s.mapError("reindex", err)
+	}
+
+	now := time.Now()
+	for i, k := range keys {
+		obj, err := s.GetObject(k.userID, k.calendarID, k.objectID)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue // deleted meanwhile
+		} else if err != nil {
+			return i, err
+		}
+		start, end, occ := objectSpan(obj.Component, now)
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return i, s.mapError("reindex", err)
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE objects SET starts_at = $4, ends_at = $5
+			WHERE user_id = $1 AND calendar_id = $2 AND id = $3`, k.userID, k.calendarID, k.objectID, start, end)
+		if err == nil {
+			err = writeInstances(ctx, tx, k.userID, k.calendarID, k.objectID, occ)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return i, s.mapError("reindex", err)
+		}
+	}
+	return len(keys), nil
+}
+
+// nullTime maps the zero time to NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// searchText concatenates the searchable properties of all components.
+func searchText(components []*ical.Component) string {
+	var parts []string
+	for _, comp := range components {
+		if comp == nil {
+			continue
+		}
+		for _, name := range []string{ical.PropSummary, ical.PropDescription, ical.PropLocation} {
+			for _, prop := range comp.Props[name] {
+				parts = append(parts, prop.Value)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/conformance"
+	"github.com/cyp0633/libcaldora/server"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/cyp0633/libcaldora/server/synctoken"
+	"github.com/emersion/go-ical"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func spanTestEvent(start, end time.Time, rrule string) *ical.Component {
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "uid")
+	event.Props.SetDateTime(ical.PropDateTimeStart, start)
+	event.Props.SetDateTime(ical.PropDateTimeEnd, end)
+	if rrule != "" {
+		event.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: rrule})
+	}
+	return event
+}
+
+func TestObjectSpan(t *testing.T) {
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	gotStart, gotEnd, occ := objectSpan([]*ical.Component{spanTestEvent(start, end, "")}, start)
+	require.NotNil(t, gotStart)
+	require.NotNil(t, gotEnd)
+	assert.True(t, start.Equal(*gotStart))
+	assert.True(t, end.Equal(*gotEnd))
+	assert.Len(t, occ.Instances, 1)
+
+	// Endless recurrences are open-ended, and expanded within the window
+	gotStart, gotEnd, occ = objectSpan([]*ical.Component{spanTestEvent(start, end, "FREQ=DAILY")}, start)
+	require.NotNil(t, gotStart)
+	assert.Nil(t, gotEnd)
+	require.NotEmpty(t, occ.Instances)
+	assert.True(t, occ.Instances[len(occ.Instances)-1].Start.Before(occ.WindowEnd))
+
+	// Bounded ones end with their last instance
+	_, gotEnd, _ = objectSpan([]*ical.Component{spanTestEvent(start, end, "FREQ=DAILY;COUNT=3")}, start)
+	require.NotNil(t, gotEnd)
+	assert.True(t, end.AddDate(0, 0, 2).Equal(*gotEnd))
+
+	// Components without time are always candidates
+	gotStart, gotEnd, occ = objectSpan([]*ical.Component{ical.NewComponent(ical.CompToDo)}, start)
+	assert.Nil(t, gotStart)
+	assert.Nil(t, gotEnd)
+	assert.True(t, occ.WindowEnd.IsZero())
+}
+
+func TestBearerToken(t *testing.T) {
+	authorize := bearerToken("s3cret")
+	r := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+	assert.False(t, authorize(r))
+	r.Header.Set("Authorization", "Bearer s3cret")
+	assert.True(t, authorize(r))
+	r.Header.Set("Authorization", "Bearer wrong")
+	assert.False(t, authorize(r))
+
+	assert.False(t, bearerToken("")(r), "an empty token disables the admin API")
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	h := m.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/", nil))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, `caldav_requests_total{method="DELETE",status="204"} 1`)
+	assert.Contains(t, body, `caldav_requests_total{method="PROPFIND",status="200"} 2`)
+	assert.Contains(t, body, "caldav_requests_in_flight 0")
+}
+
+// TestPostgresConformance runs the conformance checks, including writes,
+// against the server backed by a real database. Set CALDORA_TEST_DATABASE_URL
+// to a scratch database to run it.
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv("CALDORA_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("CALDORA_TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := NewPostgresStorage(db, synctoken.NewCodec([]byte("test")), 10*time.Second, logger)
+	require.NoError(t, err)
+
+	userID := "test-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	require.NoError(t, store.CreateUser(userID, "Test User", userID+"@example.com"))
+	t.Cleanup(func() { db.Exec(`DELETE FROM users WHERE id = $1`, userID) })
+	password, err := store.AddAppPassword(userID, "test")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateCalendar(userID, &storage.Calendar{Path: calendarPath(userID, "default")}))
+
+	handler := server.NewCaldavHandler(caldavPrefix, "test", store, 3, nil, logger)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	results, err := conformance.Run(context.Background(), conformance.Options{
+		URL:      srv.URL + caldavPrefix + userID,
+		Username: userID,
+		Password: password,
+		Write:    true,
+	})
+	require.NoError(t, err)
+	for _, r := range results {
+		// Scheduling is not implemented by the handler yet
+		if strings.HasPrefix(r.Reference, "RFC 6638 §2.2") {
+			continue
+		}
+		assert.NotEqual(t, conformance.Fail, r.Status, "%s: %s", r.Feature, r.Detail)
+	}
+}
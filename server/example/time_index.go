@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// objectSpan is the time covered by an object, from the start of its first
+// occurrence to the end of its last one.
+type objectSpan struct {
+	objectID string
+	start    time.Time
+	end      time.Time
+}
+
+// timeIndex keeps the objects of one calendar ordered by the start of their
+// first occurrence, so a time-range query only visits entries that can overlap.
+// Objects whose recurrence never ends are kept aside and checked separately.
+type timeIndex struct {
+	// entries is sorted by start
+	entries []objectSpan
+
+	// maxSpan is the longest finite span ever indexed. It is not shrunk on
+	// removal, which only makes queries visit a few more entries.
+	maxSpan time.Duration
+
+	// unbounded holds objects with an open-ended recurrence, keyed by object ID
+	unbounded map[string]objectSpan
+
+	// spans remembers how each object was indexed so it can be removed
+	spans map[string]objectSpan
+}
+
+func newTimeIndex() *timeIndex {
+	return &timeIndex{
+		unbounded: make(map[string]objectSpan),
+		spans:     make(map[string]objectSpan),
+	}
+}
+
+// put indexes (or re-indexes) an object.
+func (idx *timeIndex) put(objectID string, object *storage.CalendarObject) {
+	idx.remove(objectID)
+
+	span, bounded, ok := computeObjectSpan(object)
+	if !ok {
+		return
+	}
+	span.objectID = objectID
+	idx.spans[objectID] = span
+
+	if !bounded {
+		idx.unbounded[objectID] = span
+		return
+	}
+
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].start.After(span.start)
+	})
+	idx.entries = append(idx.entries, objectSpan{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = span
+
+	if d := span.end.Sub(span.start); d > idx.maxSpan {
+		idx.maxSpan = d
+	}
+}
+
+// remove drops an object from the index. Removing an unknown object is a no-op.
+func (idx *timeIndex) remove(objectID string) {
+	span, exists := idx.spans[objectID]
+	if !exists {
+		return
+	}
+	delete(idx.spans, objectID)
+
+	if _, exists := idx.unbounded[objectID]; exists {
+		delete(idx.unbounded, objectID)
+		return
+	}
+
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return !idx.entries[i].start.Before(span.start)
+	})
+	for ; i < len(idx.entries) && idx.entries[i].start.Equal(span.start); i++ {
+		if idx.entries[i].objectID == objectID {
+			idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// candidates returns the IDs of objects that may overlap [start, end]. A nil
+// bound is open. The result is a superset of the matches; callers still run
+// the full filter on every candidate.
+func (idx *timeIndex) candidates(start, end *time.Time) []string {
+	// Only entries starting in [start-maxSpan, end] can overlap the range
+	lo := 0
+	if start != nil {
+		from := start.Add(-idx.maxSpan)
+		lo = sort.Search(len(idx.entries), func(i int) bool {
+			return !idx.entries[i].start.Before(from)
+		})
+	}
+	hi := len(idx.entries)
+	if end != nil {
+		hi = sort.Search(len(idx.entries), func(i int) bool {
+			return idx.entries[i].start.After(*end)
+		})
+	}
+
+	var ids []string
+	for i := lo; i < hi; i++ {
+		if start == nil || !idx.entries[i].end.Before(*start) {
+			ids = append(ids, idx.entries[i].objectID)
+		}
+	}
+	for id, span := range idx.unbounded {
+		if end == nil || !span.start.After(*end) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// computeObjectSpan returns the union of the spans of every component (and
+// nested component) with time information. bounded is false when some
+// recurrence never ends; ok is false when no component has any time at all.
+func computeObjectSpan(object *storage.CalendarObject) (span objectSpan, bounded, ok bool) {
+	bounded = true
+	var walk func(comps []*ical.Component)
+	walk = func(comps []*ical.Component) {
+		for _, comp := range comps {
+			if comp == nil {
+				continue
+			}
+			if start, end, compBounded, hasTime := componentSpan(comp); hasTime {
+				if !ok || start.Before(span.start) {
+					span.start = start
+				}
+				if !ok || end.After(span.end) {
+					span.end = end
+				}
+				ok = true
+				bounded = bounded && compBounded
+			}
+			walk(comp.Children)
+		}
+	}
+	walk(object.Component)
+	return span, bounded, ok
+}
+
+// componentSpan computes the first start and last end of a single component
+// with the recurrence engine, in the component's TZID like freebusy and
+// expand. Rules too long to walk are treated as open-ended.
+func componentSpan(comp *ical.Component) (start, end time.Time, bounded, hasTime bool) {
+	start, end, hasTime = recurrence.ExtractBasicTimeInfoFromComponent(comp)
+	if !hasTime {
+		return start, end, false, false
+	}
+	info := recurrence.ExtractRecurrenceInfoFromComponent(comp)
+	start, end, bounded = recurrence.NewEngineWithCache(nil).Span(start, end, info)
+	return start, end, bounded, true
+}
+
+// requiredTimeRange returns the time range every matching object must
+// overlap, or nil if the filter does not constrain time unconditionally. A
+// time-range only counts when it sits on a chain of single comp-filters,
+// since any sibling could otherwise match on its own.
+func requiredTimeRange(filter *storage.Filter) *storage.TimeRange {
+	for f := filter; f != nil && !f.IsNotDefined; {
+		if f.TimeRange != nil {
+			// Spans are indexed with floating times read as UTC
+			tr := f.TimeRange.IndexRange()
+			// Mirror the filter: an end before the start is ignored
+			if tr.Start != nil && tr.End != nil && tr.End.Before(*tr.Start) {
+				tr.End = nil
+			}
+			return &tr
+		}
+		if len(f.Children) != 1 {
+			return nil
+		}
+		f = &f.Children[0]
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func indexTestObject(start, end time.Time, rrule string) *storage.CalendarObject {
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "uid")
+	event.Props.SetDateTime(ical.PropDateTimeStart, start)
+	event.Props.SetDateTime(ical.PropDateTimeEnd, end)
+	if rrule != "" {
+		// SetText would escape the rule's semicolons
+		event.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: rrule})
+	}
+	return &storage.CalendarObject{Component: []*ical.Component{event}}
+}
+
+func TestTimeIndexCandidates(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 9, 0, 0, 0, time.UTC) }
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	idx := newTimeIndex()
+	idx.put("early", indexTestObject(day(1), day(1).Add(time.Hour), ""))
+	idx.put("long", indexTestObject(day(2), day(9), ""))
+	idx.put("late", indexTestObject(day(20), day(20).Add(time.Hour), ""))
+	idx.put("weekly", indexTestObject(day(3), day(3).Add(time.Hour), "FREQ=WEEKLY;COUNT=3"))
+	idx.put("forever", indexTestObject(day(5), day(5).Add(time.Hour), "FREQ=DAILY"))
+	idx.put("untimed", &storage.CalendarObject{Component: []*ical.Component{ical.NewComponent(ical.CompToDo)}})
+
+	tests := []struct {
+		name       string
+		start, end *time.Time
+		expected   []string
+	}{
+		// weekly's span covers the gap between occurrences; the filter drops it later
+		{"inside long event", ptr(day(8)), ptr(day(8).Add(time.Hour)), []string{"forever", "long", "weekly"}},
+		{"last weekly occurrence", ptr(day(17)), ptr(day(18)), []string{"forever", "weekly"}},
+		{"before everything", ptr(day(1).Add(-48 * time.Hour)), ptr(day(1).Add(-24 * time.Hour)), nil},
+		// Components without DTSTART or DUE have no span, and storage.Filter never matches them
+		{"open start", nil, ptr(day(1).Add(30 * time.Minute)), []string{"early"}},
+		{"open end", ptr(day(19)), nil, []string{"forever", "late"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := idx.candidates(tt.start, tt.end)
+			sort.Strings(got)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+
+	idx.remove("long")
+	idx.put("weekly", indexTestObject(day(25), day(25).Add(time.Hour), ""))
+	got := idx.candidates(ptr(day(8)), ptr(day(18)))
+	sort.Strings(got)
+	assert.Equal(t, []string{"forever"}, got)
+}
+
+func TestTimeIndexRecurrence(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	idx := newTimeIndex()
+	// The last instance is at 09:00 EDT, after the DST change, not 09:00 EST
+	start := time.Date(2025, 3, 8, 9, 0, 0, 0, ny)
+	idx.put("dst", indexTestObject(start, start.Add(time.Hour), "FREQ=DAILY;COUNT=3"))
+	idx.put("long", indexTestObject(start, start.Add(time.Minute), "FREQ=MINUTELY;COUNT=1000000"))
+
+	assert.Equal(t, []string{"long"}, idx.candidates(ptr(time.Date(2025, 3, 10, 14, 30, 0, 0, time.UTC)), nil))
+	assert.Contains(t, idx.unbounded, "long", "rules too long to walk are open-ended")
+}
+
+func TestRequiredTimeRange(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	tr := &storage.TimeRange{Start: &start, End: &end}
+
+	assert.Nil(t, requiredTimeRange(nil))
+	assert.Equal(t, tr, requiredTimeRange(&storage.Filter{
+		Component: "VCALENDAR",
+		Children:  []storage.Filter{{Component: "VEVENT", TimeRange: tr}},
+	}))
+	// Alternatives make the range optional
+	assert.Nil(t, requiredTimeRange(&storage.Filter{
+		Component: "VCALENDAR",
+		Children:  []storage.Filter{{Component: "VEVENT", TimeRange: tr}, {Component: "VTODO"}},
+	}))
+	// An end before the start is ignored
+	reversed := requiredTimeRange(&storage.Filter{TimeRange: &storage.TimeRange{Start: &end, End: &start}})
+	assert.Equal(t, &end, reversed.Start)
+	assert.Nil(t, reversed.End)
+}
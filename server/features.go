@@ -0,0 +1,124 @@
+package server
+
+import (
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// Features switches optional capabilities of a handler on and off, so a
+// server can start minimal and grow. A disabled feature is neither served
+// nor advertised, in OPTIONS or in DAV:supported-report-set, even when the
+// storage implements what it needs. An enabled feature still needs that
+// storage support.
+//
+// There is no trash to switch: the handler keeps no deleted objects or
+// calendars itself. DELETE goes straight to the storage, which may keep
+// tombstones for sync, and deleting calendars is governed by
+// storage.DeletableCalendarStorage and ForceCalendarDelete. Calendars meant
+// to be kept read-only are archived instead, which every storage can do.
+type Features struct {
+	// EnableSync serves the sync-collection REPORT and DAV:sync-token,
+	// needs storage.SyncStorage
+	EnableSync bool
+	// EnableSharing honors share link tokens and lets CreateShareLink issue
+	// them, needs storage.ShareLinkStorage
+	EnableSharing bool
+	// EnableBulk serves POST bulk changes and cs:bulk-requests
+	EnableBulk bool
+	// EnableFreeBusy serves the free-busy-query REPORT
+	EnableFreeBusy bool
+	// EnableSearch serves the DAV:search REPORT, needs
+	// storage.SearchableStorage
+	EnableSearch bool
+	// EnableScheduling serves the scheduling inbox, needs
+	// storage.AvailabilityStorage, and the scheduling outbox, needs
+	// storage.ScheduleInboxStorage. Free-busy honors stored availability
+	// either way
+	EnableScheduling bool
+}
+
+// AllFeatures returns Features with everything enabled, what a handler
+// without Features serves.
+func AllFeatures() *Features {
+	return &Features{
+		EnableSync:       true,
+		EnableSharing:    true,
+		EnableBulk:       true,
+		EnableFreeBusy:   true,
+		EnableSearch:     true,
+		EnableScheduling: true,
+	}
+}
+
+// clone returns a copy of f, nil for nil.
+func (f *Features) clone() *Features {
+	if f == nil {
+		return nil
+	}
+	c := *f
+	return &c
+}
+
+// features returns the handler's Features, all of them when unset.
+func (h *CaldavHandler) features() *Features {
+	if h.Features == nil {
+		return AllFeatures()
+	}
+	return h.Features
+}
+
+// syncEnabled reports whether sync-collection can be served, which also
+// needs a storage keeping a change log.
+func (h *CaldavHandler) syncEnabled() bool {
+	_, ok := storage.As[storage.SyncStorage](h.Storage)
+	return ok && h.features().EnableSync
+}
+
+// searchEnabled reports whether DAV:search can be served.
+func (h *CaldavHandler) searchEnabled() bool {
+	_, ok := storage.As[storage.SearchableStorage](h.Storage)
+	return ok && h.features().EnableSearch
+}
+
+// schedulingPost reports whether a scheduling outbox can take POSTs.
+func (h *CaldavHandler) schedulingPost() bool {
+	_, ok := h.scheduleInboxStorage()
+	return ok && h.features().EnableScheduling
+}
+
+// reportEnabled reports whether Features allow the REPORT named tag.
+func (h *CaldavHandler) reportEnabled(tag string) bool {
+	switch tag {
+	case "sync-collection":
+		return h.features().EnableSync
+	case "free-busy-query":
+		return h.features().EnableFreeBusy
+	case "search":
+		return h.features().EnableSearch
+	}
+	return true
+}
+
+// supportedReports lists the REPORTs res answers, for
+// DAV:supported-report-set.
+func (h *CaldavHandler) supportedReports(res Resource) []props.ReportType {
+	reports := []props.ReportType{}
+	switch res.ResourceType {
+	case storage.ResourceCollection:
+		reports = append(reports, props.ReportTypeCalendarMultiget, props.ReportTypeCalendarQuery)
+		if h.syncEnabled() {
+			reports = append(reports, props.ReportTypeSyncCollection)
+		}
+	case storage.ResourceHomeSet:
+		reports = append(reports, props.ReportTypeCalendarQuery)
+	default:
+		return reports
+	}
+	if h.features().EnableFreeBusy {
+		reports = append(reports, props.ReportTypeFreebusyQuery)
+	}
+	if h.searchEnabled() {
+		reports = append(reports, props.ReportTypeSearch)
+	}
+	return reports
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const supportedReportSetBody = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:supported-report-set/><D:sync-token/></D:prop></D:propfind>`
+
+func TestFeaturesSupportedReportSet(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Storage = &journalStorage{MockStorage: mockStorage}
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work/"}, nil)
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", supportedReportSetBody, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "<cal:calendar-multiget/>")
+	assert.Contains(t, body, "<cal:calendar-query/>")
+	assert.Contains(t, body, "<cal:free-busy-query/>")
+	assert.Contains(t, body, "<d:sync-collection/>")
+	assert.Contains(t, body, "urn:test:3")
+
+	h.Features = &Features{}
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", supportedReportSetBody, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	body = w.Body.String()
+	assert.Contains(t, body, "<cal:calendar-query/>")
+	assert.NotContains(t, body, "free-busy-query")
+	assert.NotContains(t, body, "<d:sync-collection/>")
+	assert.NotContains(t, body, "urn:test:3", "no sync-token without sync")
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", syncBody(""), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Unsupported report type")
+}
+
+func TestFeaturesBulk(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Features = &Features{EnableSync: true}
+
+	w := serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Header().Get("Allow"), "POST")
+
+	w = serveAlice(h, http.MethodPost, "/caldav/alice/cal/work?action=simple", "", map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	h.Features.EnableBulk = true
+	w = serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil)
+	assert.Contains(t, w.Header().Get("Allow"), "POST")
+}
+
+func TestFeaturesSharing(t *testing.T) {
+	h, store := newShareTestHandler(t)
+	href, _, err := h.CreateShareLink("alice", "work", false, 0)
+	require.NoError(t, err)
+
+	h.Features = &Features{}
+	_, _, err = h.CreateShareLink("alice", "work", false, 0)
+	assert.ErrorIs(t, err, errSharingDisabled)
+	assert.Len(t, store.links, 1)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, href, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "existing links stop working")
+
+	h.Features = nil
+	h.Config = NewConfig(h.Settings())
+	require.NoError(t, h.Config.Update(func(s *Settings) { s.Features = &Features{} }))
+	_, _, err = h.CreateShareLink("alice", "work", false, 0)
+	assert.ErrorIs(t, err, errSharingDisabled, "Config disables features too")
+}
+
+func TestFeaturesScheduling(t *testing.T) {
+	h, store := newOutboxTestHandler()
+	h.Features = &Features{}
+	msg := itipMessage("REQUEST", "mailto:alice@example.com", "mailto:bob@example.com")
+
+	w := serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/", msg, map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Empty(t, store.delivered)
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/",
+		`<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><C:schedule-outbox-URL/></D:prop></D:propfind>`,
+		map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.NotContains(t, w.Body.String(), "/caldav/alice/cal/outbox")
+
+	h.Features.EnableScheduling = true
+	w = serveAlice(h, http.MethodOptions, "/caldav/alice/cal/outbox/", "", nil)
+	assert.Contains(t, w.Header().Get("Allow"), "POST", "the outbox takes POSTs without bulk changes")
+	w = serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/", msg, map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, store.delivered["bob"], 1)
+}
+
+func TestAllowByResourceType(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+
+	w := serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil)
+	assert.Equal(t, "OPTIONS, PROPFIND, PROPPATCH, REPORT, POST, MKCALENDAR", w.Header().Get("Allow"))
+	w = serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/a.ics", "", nil)
+	assert.Equal(t, "OPTIONS, PROPFIND, PROPPATCH, REPORT, GET, PUT, DELETE", w.Header().Get("Allow"))
+	w = serveAlice(h, http.MethodOptions, "/caldav/alice/", "", nil)
+	assert.Equal(t, "OPTIONS, PROPFIND, PROPPATCH, REPORT", w.Header().Get("Allow"))
+
+	w = serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.NotContains(t, w.Header().Get("Allow"), "PUT", "collections take PUTs only when naming new objects")
+}
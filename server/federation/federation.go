@@ -0,0 +1,461 @@
+// Package federation mounts calendars of remote CalDAV servers into calendar
+// homes, so an account can aggregate external calendars server-side.
+//
+// A Storage serves the remote calendars added to it and is meant to be
+// mounted on a storage.CompositeStorage under a prefix the calendar IDs
+// share. Objects are fetched through davclient and cached for TTL; writes go
+// to the remote server first and update the cache once it accepted them.
+//
+// The client queries VEVENTs only, so remote calendars are exposed as event
+// calendars.
+package federation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cyp0633/libcaldora/davclient"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// DefaultTTL is how long fetched objects are served from the cache when the
+// Storage was created with a zero TTL.
+const DefaultTTL = 5 * time.Minute
+
+// Calendar describes a remote calendar and where it appears.
+type Calendar struct {
+	// UserID is the user whose calendar home lists the calendar.
+	UserID string
+	// ID is the calendar ID in that home. It must start with the prefix the
+	// Storage is mounted under.
+	ID string
+	// URL is the remote collection URL, used to name new objects.
+	URL string
+	// Client talks to the remote collection, see davclient.NewDAVClient.
+	// Clients not implementing davclient.ObjectWriter are read-only.
+	Client davclient.DAVClient
+	// Optional: display name and color shown to clients
+	Name, Color string
+	// Optional: refuse writes instead of passing them to the remote server
+	ReadOnly bool
+}
+
+// Storage serves remote calendars. It implements storage.Storage; users and
+// authentication are left to the storage it is mounted next to.
+//
+// Requests to a remote calendar are serialized, but never wait for other
+// remote servers.
+type Storage struct {
+	// TTL is how long fetched objects are served before the remote calendar
+	// is queried again.
+	TTL time.Duration
+
+	mu        sync.Mutex // guards calendars
+	calendars map[string]*remote
+	now       func() time.Time
+}
+
+// remote is a Calendar with its cached objects. mu guards the cache and is
+// held while talking to the remote server; ctag is read without it, for
+// listing calendars not to wait for a slow server.
+type remote struct {
+	Calendar
+	mu      sync.Mutex
+	fetched time.Time
+	etag    string
+	objects map[string]*cached
+	ctag    atomic.Value // string
+}
+
+// cached is a remote object by object ID, with its remote URL and ETag.
+type cached struct {
+	url    string
+	etag   string
+	object storage.CalendarObject
+}
+
+// NewStorage returns a Storage caching remote objects for ttl, or for
+// DefaultTTL when ttl is zero.
+func NewStorage(ttl time.Duration) *Storage {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return &Storage{TTL: ttl, calendars: make(map[string]*remote), now: time.Now}
+}
+
+// Add mounts cal. Add before serving requests; a calendar added again under
+// the same ID replaces the previous one.
+func (s *Storage) Add(cal Calendar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &remote{Calendar: cal}
+	r.changed()
+	s.calendars[cal.ID] = r
+}
+
+// Invalidate drops the cached objects of calendarID, so the next request
+// fetches them again.
+func (s *Storage) Invalidate(calendarID string) {
+	if r, ok := s.remote(calendarID); ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.objects = nil
+	}
+}
+
+// remote returns the calendar added under calendarID.
+func (s *Storage) remote(calendarID string) (*remote, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.calendars[calendarID]
+	return r, ok
+}
+
+// owned returns calendarID with its mu locked and fresh objects, if it
+// belongs to userID. The caller unlocks r.mu when done.
+func (s *Storage) owned(userID, calendarID string) (*remote, error) {
+	r, ok := s.remote(calendarID)
+	if !ok || r.UserID != userID {
+		return nil, storage.ErrNotFound
+	}
+	r.mu.Lock()
+	if err := s.load(r); err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	return r, nil
+}
+
+// load refreshes the objects of r once the TTL passed. It must be called
+// with r.mu held. A remote server failing while objects are cached keeps
+// serving them until the next attempt.
+func (s *Storage) load(r *remote) error {
+	now := s.now()
+	if r.objects != nil && now.Sub(r.fetched) < s.TTL {
+		return nil
+	}
+	if err := r.refresh(); err != nil {
+		if r.objects != nil {
+			r.fetched = now
+			return nil
+		}
+		return fmt.Errorf("%w: %v", storage.ErrStorageUnavailable, err)
+	}
+	r.fetched = now
+	return nil
+}
+
+// refresh fetches the objects of r, unless the collection ETag shows they
+// didn't change.
+func (r *remote) refresh() error {
+	etag, err := r.Client.GetCalendarEtag()
+	if err == nil && etag != "" && etag == r.etag && r.objects != nil {
+		return nil
+	}
+	fetched, err := r.Client.GetAllEvents().Do()
+	if err != nil {
+		return err
+	}
+
+	// The client returns one entry per VEVENT; overrides of a recurring
+	// event share the URL of their master.
+	objects := make(map[string]*cached)
+	for _, f := range fetched {
+		id := storage.PathSegment(f.URL)
+		c, ok := objects[id]
+		if !ok {
+			c = &cached{url: f.URL, etag: f.ETag}
+			c.object = storage.CalendarObject{Path: r.objectPath(id), ETag: f.ETag}
+			objects[id] = c
+		}
+		if f.Event.Component != nil {
+			c.object.Component = append(c.object.Component, f.Event.Component)
+		}
+	}
+	r.objects, r.etag = objects, etag
+	r.changed()
+	return nil
+}
+
+// readOnly reports whether writes to r are refused.
+func (r *remote) readOnly() bool {
+	_, ok := r.Client.(davclient.ObjectWriter)
+	return r.ReadOnly || !ok
+}
+
+func (r *remote) calendarPath() string {
+	return fmt.Sprintf("/%s/cal/%s/", url.PathEscape(r.UserID), url.PathEscape(r.ID))
+}
+
+func (r *remote) objectPath(objectID string) string {
+	return r.calendarPath() + url.PathEscape(objectID)
+}
+
+// objectURL returns the remote URL of objectID, known for fetched objects
+// and derived from the collection URL for new ones.
+func (r *remote) objectURL(objectID string) string {
+	if c, ok := r.objects[objectID]; ok {
+		return c.url
+	}
+	return strings.TrimSuffix(r.URL, "/") + "/" + url.PathEscape(objectID)
+}
+
+// calendar returns the storage.Calendar of r. Its CTag is derived from the
+// object ETags, so it changes whenever a refresh sees a change.
+func (r *remote) calendar() storage.Calendar {
+	data := ical.NewCalendar()
+	data.Props.SetText(ical.PropProductID, "-//libcaldora//Federation//EN")
+	data.Props.SetText(ical.PropVersion, "2.0")
+	if r.Name != "" {
+		data.Props.SetText(ical.PropName, r.Name)
+	}
+	if r.Color != "" {
+		data.Props.SetText(ical.PropColor, r.Color)
+	}
+
+	etag := sha256.Sum256([]byte(r.URL + "\x00" + r.Name + "\x00" + r.Color))
+
+	return storage.Calendar{
+		Path:                r.calendarPath(),
+		ReadOnly:            r.readOnly(),
+		CTag:                r.ctag.Load().(string),
+		ETag:                hex.EncodeToString(etag[:16]),
+		CalendarData:        data,
+		SupportedComponents: []string{"VEVENT"},
+	}
+}
+
+// changed derives the CTag from the object ETags. It must be called with mu
+// held whenever the objects change.
+func (r *remote) changed() {
+	ctag := sha256.New()
+	for _, id := range r.objectIDs() {
+		fmt.Fprintf(ctag, "%s\x00%s\x00", id, r.objects[id].etag)
+	}
+	r.ctag.Store(hex.EncodeToString(ctag.Sum(nil)[:16]))
+}
+
+func (r *remote) objectIDs() []string {
+	ids := make([]string, 0, len(r.objects))
+	for id := range r.objects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// GetObjectsInCollection returns the objects of calendarID, fetching them
+// when the cache expired.
+func (s *Storage) GetObjectsInCollection(calendarID string) ([]storage.CalendarObject, error) {
+	r, ok := s.remote(calendarID)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return s.GetObjectByFilter(r.UserID, calendarID, nil)
+}
+
+// GetObjectPathsInCollection returns the paths of the objects of calendarID,
+// fetching them when the cache expired.
+func (s *Storage) GetObjectPathsInCollection(calendarID string) ([]string, error) {
+	objects, err := s.GetObjectsInCollection(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(objects))
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	return paths, nil
+}
+
+// GetUserCalendars lists the calendars added for userID without contacting
+// the remote servers, returning ErrNotFound if there are none.
+func (s *Storage) GetUserCalendars(userID string) ([]storage.Calendar, error) {
+	s.mu.Lock()
+	var remotes []*remote
+	for _, r := range s.calendars {
+		if r.UserID == userID {
+			remotes = append(remotes, r)
+		}
+	}
+	s.mu.Unlock()
+	if len(remotes) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	calendars := make([]storage.Calendar, 0, len(remotes))
+	for _, r := range remotes {
+		calendars = append(calendars, r.calendar())
+	}
+	sort.Slice(calendars, func(i, j int) bool { return calendars[i].Path < calendars[j].Path })
+	return calendars, nil
+}
+
+// GetUser returns ErrNotFound; users belong to the storage mounted next to
+// Storage.
+func (s *Storage) GetUser(userID string) (*storage.User, error) {
+	return nil, storage.ErrNotFound
+}
+
+// AuthUser returns ErrNotFound, like GetUser.
+func (s *Storage) AuthUser(username, password string) (string, error) {
+	return "", storage.ErrNotFound
+}
+
+// GetCalendar returns calendarID if it belongs to userID. It fetches the
+// objects when the cache expired, for the CTag to reflect remote changes.
+func (s *Storage) GetCalendar(userID, calendarID string) (*storage.Calendar, error) {
+	r, err := s.owned(userID, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	cal := r.calendar()
+	return &cal, nil
+}
+
+// GetObject returns an object of calendarID if it belongs to userID,
+// fetching the objects when the cache expired.
+func (s *Storage) GetObject(userID, calendarID, objectID string) (*storage.CalendarObject, error) {
+	r, err := s.owned(userID, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	c, ok := r.objects[objectID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	object := c.object
+	return &object, nil
+}
+
+// GetObjectByFilter returns the objects of calendarID matching filter, all
+// of them when it is nil. The filter runs on the cached objects, which are
+// fetched when the cache expired.
+func (s *Storage) GetObjectByFilter(userID, calendarID string, filter *storage.Filter) ([]storage.CalendarObject, error) {
+	r, err := s.owned(userID, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	var objects []storage.CalendarObject
+	for _, id := range r.objectIDs() {
+		object := r.objects[id].object
+		if filter == nil || filter.Validate(&object) {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// remoteError maps a failed remote write to a storage error. Refusals the
+// client can act on keep their meaning; only transport errors and server
+// errors make the storage unavailable, to be retried later.
+func remoteError(err error) error {
+	var status *davclient.StatusError
+	if !errors.As(err, &status) || status.StatusCode >= 500 {
+		return fmt.Errorf("%w: %v", storage.ErrStorageUnavailable, err)
+	}
+	switch status.StatusCode {
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %v", storage.ErrPreconditionFailed, err)
+	case http.StatusNotFound, http.StatusGone:
+		return fmt.Errorf("%w: %v", storage.ErrNotFound, err)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %v", storage.ErrPermissionDenied, err)
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %v", storage.ErrConflict, err)
+	case http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %v", storage.ErrTooLarge, err)
+	}
+	return fmt.Errorf("%w: %v", storage.ErrInvalidInput, err)
+}
+
+// UpdateObject writes the object to the remote calendar, conditional on the
+// cached ETag of an existing object, and caches it with the ETag the remote
+// server returned. New objects are written only if their URL is free on the
+// remote server. Servers not returning an ETag cause a refresh.
+func (s *Storage) UpdateObject(userID, calendarID string, object *storage.CalendarObject) (string, error) {
+	r, err := s.owned(userID, calendarID)
+	if err != nil {
+		return "", err
+	}
+	defer r.mu.Unlock()
+	if r.readOnly() {
+		return "", storage.ErrPermissionDenied
+	}
+
+	id := storage.PathSegment(object.Path)
+	data := ical.NewCalendar()
+	data.Props.SetText(ical.PropProductID, "-//libcaldora//Federation//EN")
+	data.Props.SetText(ical.PropVersion, "2.0")
+	data.Children = object.Component
+
+	var ifMatch string
+	if c, ok := r.objects[id]; ok {
+		ifMatch = c.etag
+	}
+	objectURL := r.objectURL(id)
+	etag, err := r.Client.(davclient.ObjectWriter).PutCalendarObject(objectURL, data, ifMatch)
+	if err != nil {
+		r.fetched = time.Time{} // the cached ETag may be stale
+		return "", remoteError(err)
+	}
+
+	if etag == "" {
+		r.objects = nil
+		if err := s.load(r); err != nil {
+			return "", err
+		}
+		c, ok := r.objects[id]
+		if !ok {
+			return "", errors.New("federation: written object missing from remote calendar")
+		}
+		return c.object.ETag, nil
+	}
+
+	written := *object
+	written.Path, written.ETag = r.objectPath(id), etag
+	r.objects[id] = &cached{url: objectURL, etag: etag, object: written}
+	r.changed()
+	return etag, nil
+}
+
+// DeleteObject deletes the object from the remote calendar, conditional on
+// its cached ETag.
+func (s *Storage) DeleteObject(userID, calendarID, objectID string) error {
+	r, err := s.owned(userID, calendarID)
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	if r.readOnly() {
+		return storage.ErrPermissionDenied
+	}
+	c, ok := r.objects[objectID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if err := r.Client.DeleteCalendarObject(c.url, c.etag); err != nil {
+		r.fetched = time.Time{}
+		return remoteError(err)
+	}
+	delete(r.objects, objectID)
+	r.changed()
+	return nil
+}
+
+// CreateCalendar is refused; remote calendars are added with Add.
+func (s *Storage) CreateCalendar(userID string, calendar *storage.Calendar) error {
+	return storage.ErrPermissionDenied
+}
@@ -0,0 +1,299 @@
+package federation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/davclient"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRemote is a remote calendar collection behind davclient.DAVClient.
+type fakeRemote struct {
+	davclient.DAVClient
+	objects map[string]davclient.CalendarObject
+	queries int
+	puts    []string
+	ifMatch []string
+	putETag string
+	putErr  error
+	down    bool
+}
+
+type fakeFilter struct {
+	davclient.ObjectFilter
+	remote *fakeRemote
+}
+
+func (f fakeFilter) Do() ([]davclient.CalendarObject, error) {
+	f.remote.queries++
+	if f.remote.down {
+		return nil, errors.New("connection refused")
+	}
+	var objects []davclient.CalendarObject
+	for _, o := range f.remote.objects {
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+func (r *fakeRemote) GetAllEvents() davclient.ObjectFilter { return fakeFilter{remote: r} }
+
+func (r *fakeRemote) GetCalendarEtag() (string, error) { return "", nil }
+
+func (r *fakeRemote) PutCalendarObject(objectURL string, cal *ical.Calendar, etag string) (string, error) {
+	r.puts = append(r.puts, objectURL)
+	r.ifMatch = append(r.ifMatch, etag)
+	if r.down {
+		return "", errors.New("connection refused")
+	}
+	if r.putErr != nil {
+		return "", r.putErr
+	}
+	r.objects[objectURL] = davclient.CalendarObject{
+		Event: ical.Event{Component: cal.Children[0]},
+		URL:   objectURL,
+		ETag:  `"server-etag"`,
+	}
+	return r.putETag, nil
+}
+
+func (r *fakeRemote) DeleteCalendarObject(objectURL string, etag string) error {
+	delete(r.objects, objectURL)
+	return nil
+}
+
+func remoteEvent(uid, summary string) *ical.Component {
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, uid)
+	event.Props.SetText(ical.PropSummary, summary)
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	return event.Component
+}
+
+func newFederated(t *testing.T) (*Storage, *fakeRemote, *time.Time) {
+	t.Helper()
+	fake := &fakeRemote{objects: map[string]davclient.CalendarObject{
+		"/dav/team/standup.ics": {Event: ical.Event{Component: remoteEvent("standup", "Standup")}, URL: "/dav/team/standup.ics", ETag: `"1"`},
+	}}
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStorage(time.Minute)
+	s.now = func() time.Time { return now }
+	s.Add(Calendar{UserID: "alice", ID: "ext-team", URL: "https://remote.example/dav/team/", Client: fake, Name: "Team"})
+	return s, fake, &now
+}
+
+func TestFederatedReadsAreCached(t *testing.T) {
+	s, fake, now := newFederated(t)
+
+	objects, err := s.GetObjectsInCollection("ext-team")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "/alice/cal/ext-team/standup.ics", objects[0].Path)
+	assert.Equal(t, `"1"`, objects[0].ETag)
+
+	obj, err := s.GetObject("alice", "ext-team", "standup.ics")
+	require.NoError(t, err)
+	assert.Len(t, obj.Component, 1)
+	assert.Equal(t, 1, fake.queries, "served from the cache")
+
+	*now = now.Add(2 * time.Minute)
+	_, err = s.GetObjectPathsInCollection("ext-team")
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.queries, "refetched after the TTL")
+
+	_, err = s.GetObject("bob", "ext-team", "standup.ics")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "other users don't see the calendar")
+}
+
+func TestFederatedCalendars(t *testing.T) {
+	s, fake, _ := newFederated(t)
+
+	calendars, err := s.GetUserCalendars("alice")
+	require.NoError(t, err)
+	require.Len(t, calendars, 1)
+	assert.Equal(t, "/alice/cal/ext-team/", calendars[0].Path)
+	assert.Equal(t, 0, fake.queries, "listing doesn't contact the remote")
+
+	_, err = s.GetUserCalendars("bob")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	before, err := s.GetCalendar("alice", "ext-team")
+	require.NoError(t, err)
+	name, _ := before.CalendarData.Props.Text(ical.PropName)
+	assert.Equal(t, "Team", name)
+
+	fake.objects["/dav/team/retro.ics"] = davclient.CalendarObject{Event: ical.Event{Component: remoteEvent("retro", "Retro")}, URL: "/dav/team/retro.ics", ETag: `"1"`}
+	s.Invalidate("ext-team")
+	after, err := s.GetCalendar("alice", "ext-team")
+	require.NoError(t, err)
+	assert.NotEqual(t, before.CTag, after.CTag, "remote changes change the CTag")
+}
+
+// stalledFilter answers once release is closed, closing started on entry.
+type stalledFilter struct {
+	davclient.ObjectFilter
+	started, release chan struct{}
+}
+
+func (f stalledFilter) Do() ([]davclient.CalendarObject, error) {
+	close(f.started)
+	<-f.release
+	return nil, nil
+}
+
+type stalledRemote struct {
+	davclient.DAVClient
+	filter stalledFilter
+}
+
+func (r *stalledRemote) GetCalendarEtag() (string, error)     { return "", nil }
+func (r *stalledRemote) GetAllEvents() davclient.ObjectFilter { return r.filter }
+
+func TestFederatedSlowRemote(t *testing.T) {
+	s, fake, _ := newFederated(t)
+	slow := &stalledRemote{filter: stalledFilter{started: make(chan struct{}), release: make(chan struct{})}}
+	s.Add(Calendar{UserID: "alice", ID: "ext-slow", URL: "https://slow.example/dav/", Client: slow})
+
+	done := make(chan error)
+	go func() {
+		_, err := s.GetObjectsInCollection("ext-slow")
+		done <- err
+	}()
+	<-slow.filter.started
+
+	// Other calendars don't wait for the slow server
+	_, err := s.GetObject("alice", "ext-team", "standup.ics")
+	require.NoError(t, err)
+	assert.Equal(t, 1, fake.queries)
+	calendars, err := s.GetUserCalendars("alice")
+	require.NoError(t, err)
+	assert.Len(t, calendars, 2)
+
+	close(slow.filter.release)
+	require.NoError(t, <-done)
+}
+
+func TestFederatedStaleOnFailure(t *testing.T) {
+	s, fake, now := newFederated(t)
+	_, err := s.GetObjectsInCollection("ext-team")
+	require.NoError(t, err)
+
+	fake.down = true
+	*now = now.Add(2 * time.Minute)
+	objects, err := s.GetObjectsInCollection("ext-team")
+	require.NoError(t, err)
+	assert.Len(t, objects, 1, "cached objects are served while the remote is down")
+
+	s.Invalidate("ext-team")
+	_, err = s.GetObjectsInCollection("ext-team")
+	assert.ErrorIs(t, err, storage.ErrStorageUnavailable)
+}
+
+func TestFederatedWriteThrough(t *testing.T) {
+	s, fake, _ := newFederated(t)
+	fake.putETag = `"2"`
+
+	standup := &storage.CalendarObject{Path: "/alice/cal/ext-team/standup.ics", Component: []*ical.Component{remoteEvent("standup", "Daily standup")}}
+	etag, err := s.UpdateObject("alice", "ext-team", standup)
+	require.NoError(t, err)
+	assert.Equal(t, `"2"`, etag)
+	assert.Equal(t, []string{"/dav/team/standup.ics"}, fake.puts, "existing objects keep their remote URL")
+	assert.Equal(t, []string{`"1"`}, fake.ifMatch)
+
+	obj, err := s.GetObject("alice", "ext-team", "standup.ics")
+	require.NoError(t, err)
+	summary, _ := obj.Component[0].Props.Text(ical.PropSummary)
+	assert.Equal(t, "Daily standup", summary)
+	assert.Equal(t, 1, fake.queries, "writes update the cache")
+
+	fake.putETag = ""
+	created := &storage.CalendarObject{Path: "/alice/cal/ext-team/new%20one.ics", Component: []*ical.Component{remoteEvent("new", "New")}}
+	etag, err = s.UpdateObject("alice", "ext-team", created)
+	require.NoError(t, err)
+	assert.Equal(t, `"server-etag"`, etag, "ETag read back from the remote")
+	assert.Equal(t, "https://remote.example/dav/team/new%20one.ics", fake.puts[1])
+	assert.Empty(t, fake.ifMatch[1])
+
+	require.NoError(t, s.DeleteObject("alice", "ext-team", "standup.ics"))
+	_, err = s.GetObject("alice", "ext-team", "standup.ics")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NotContains(t, fake.objects, "/dav/team/standup.ics")
+}
+
+func TestFederatedWriteFailures(t *testing.T) {
+	s, fake, _ := newFederated(t)
+	s.Add(Calendar{UserID: "alice", ID: "ext-holidays", URL: "https://remote.example/dav/holidays/", Client: fake, ReadOnly: true})
+
+	obj := &storage.CalendarObject{Path: "/alice/cal/ext-holidays/x.ics", Component: []*ical.Component{remoteEvent("x", "X")}}
+	_, err := s.UpdateObject("alice", "ext-holidays", obj)
+	assert.ErrorIs(t, err, storage.ErrPermissionDenied)
+	assert.ErrorIs(t, s.DeleteObject("alice", "ext-holidays", "x.ics"), storage.ErrPermissionDenied)
+	assert.ErrorIs(t, s.CreateCalendar("alice", &storage.Calendar{}), storage.ErrPermissionDenied)
+
+	_, err = s.GetObjectsInCollection("ext-team")
+	require.NoError(t, err)
+	fake.down = true
+	_, err = s.UpdateObject("alice", "ext-team", &storage.CalendarObject{Path: "/alice/cal/ext-team/standup.ics", Component: []*ical.Component{remoteEvent("standup", "S")}})
+	assert.ErrorIs(t, err, storage.ErrStorageUnavailable)
+	assert.True(t, strings.Contains(err.Error(), "connection refused"))
+}
+
+func TestFederatedRemoteRefusals(t *testing.T) {
+	s, fake, _ := newFederated(t)
+	standup := &storage.CalendarObject{Path: "/alice/cal/ext-team/standup.ics", Component: []*ical.Component{remoteEvent("standup", "S")}}
+
+	for status, want := range map[int]error{
+		http.StatusPreconditionFailed:   storage.ErrPreconditionFailed,
+		http.StatusNotFound:             storage.ErrNotFound,
+		http.StatusForbidden:            storage.ErrPermissionDenied,
+		http.StatusBadGateway:           storage.ErrStorageUnavailable,
+		http.StatusUnsupportedMediaType: storage.ErrInvalidInput,
+	} {
+		fake.putErr = fmt.Errorf("failed to put calendar object: %w", &davclient.StatusError{Method: http.MethodPut, StatusCode: status})
+		_, err := s.UpdateObject("alice", "ext-team", standup)
+		assert.ErrorIs(t, err, want, "status %d", status)
+		if want != storage.ErrStorageUnavailable {
+			assert.NotErrorIs(t, err, storage.ErrStorageUnavailable, "status %d is not retried", status)
+		}
+	}
+}
+
+func TestFederatedClientWithoutWriter(t *testing.T) {
+	s := NewStorage(time.Minute)
+	s.Add(Calendar{UserID: "alice", ID: "ext-feed", URL: "https://remote.example/feed/", Client: readOnlyRemote{&fakeRemote{}}})
+
+	cal, err := s.GetCalendar("alice", "ext-feed")
+	require.NoError(t, err)
+	assert.True(t, cal.ReadOnly)
+	_, err = s.UpdateObject("alice", "ext-feed", &storage.CalendarObject{Path: "/alice/cal/ext-feed/x.ics"})
+	assert.ErrorIs(t, err, storage.ErrPermissionDenied)
+}
+
+// readOnlyRemote hides the davclient.ObjectWriter of a client.
+type readOnlyRemote struct{ davclient.DAVClient }
+
+func TestFederatedMountedOnComposite(t *testing.T) {
+	s, _, _ := newFederated(t)
+	personal := new(storage.MockStorage)
+	personal.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work/"}}, nil)
+
+	composite := storage.NewCompositeStorage(personal)
+	composite.Mount("ext-", s)
+
+	calendars, err := composite.GetUserCalendars("alice")
+	require.NoError(t, err)
+	require.Len(t, calendars, 2)
+	assert.Equal(t, "/alice/cal/ext-team/", calendars[1].Path)
+
+	obj, err := composite.GetObject("alice", "ext-team", "standup.ics")
+	require.NoError(t, err)
+	assert.Equal(t, `"1"`, obj.ETag)
+}
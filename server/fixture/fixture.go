@@ -0,0 +1,297 @@
+// Package fixture records CalDAV traffic from real clients and replays it
+// against a handler in tests, so interop behavior stays pinned down after the
+// client is gone.
+//
+// Wrap the handler with a Recorder while reproducing an issue with a client,
+// then copy the recorded files into a testdata directory and Replay them
+// against a handler backed by test storage.
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/redact"
+)
+
+// RecordedHeaders are the request headers kept in an Exchange. Credentials and
+// cookies are never recorded.
+var RecordedHeaders = []string{
+	"Content-Type",
+	"Depth",
+	"Destination",
+	"If-Match",
+	"If-None-Match",
+	"Overwrite",
+	"User-Agent",
+}
+
+// Exchange is one recorded request/response pair.
+type Exchange struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   string            `json:"body,omitempty"`
+
+	Status       int    `json:"status"`
+	ResponseBody string `json:"response_body,omitempty"`
+}
+
+// Recorder is a middleware that writes every exchange passing through it to a
+// directory, one JSON file per exchange, numbered in arrival order.
+//
+// Bodies are anonymized with the same redaction as CaldavHandler.LogBodies.
+// Replacements additionally rewrite literal strings (such as user names or
+// e-mail addresses) in paths, headers and bodies.
+type Recorder struct {
+	next     http.Handler
+	dir      string
+	replacer *strings.Replacer
+	logger   *slog.Logger
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder creates a Recorder writing into dir, which is created if needed.
+// Numbering continues after any exchanges already in dir.
+func NewRecorder(dir string, next http.Handler, replacements map[string]string, logger *slog.Logger) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create fixture directory: %w", err)
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Recorder{
+		next:     next,
+		dir:      dir,
+		replacer: newReplacer(replacements),
+		logger:   logger,
+		seq:      len(existing),
+	}, nil
+}
+
+// newReplacer builds a replacer that prefers longer matches, so that e.g.
+// "alice@example.com" wins over "alice" regardless of map order.
+func newReplacer(replacements map[string]string) *strings.Replacer {
+	olds := make([]string, 0, len(replacements))
+	for old := range replacements {
+		if old != "" {
+			olds = append(olds, old)
+		}
+	}
+	sort.Slice(olds, func(i, j int) bool {
+		if len(olds[i]) != len(olds[j]) {
+			return len(olds[i]) > len(olds[j])
+		}
+		return olds[i] < olds[j]
+	})
+	pairs := make([]string, 0, 2*len(olds))
+	for _, old := range olds {
+		pairs = append(pairs, old, replacements[old])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// responseCapture tees the response into a buffer.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.buf.Write(p)
+	return c.ResponseWriter.Write(p)
+}
+
+// ServeHTTP passes the request on to the wrapped handler and records it.
+// Failing to record is logged but never affects the response.
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	capture := &responseCapture{ResponseWriter: w}
+	rec.next.ServeHTTP(capture, r)
+	if capture.status == 0 {
+		capture.status = http.StatusOK
+	}
+
+	ex := Exchange{
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Body:         string(body),
+		Status:       capture.status,
+		ResponseBody: capture.buf.String(),
+	}
+	for _, name := range RecordedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			if ex.Header == nil {
+				ex.Header = make(map[string]string)
+			}
+			ex.Header[name] = value
+		}
+	}
+	rec.anonymize(&ex)
+
+	if err := rec.write(&ex); err != nil {
+		rec.logger.Error("failed to record exchange",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"error", err)
+	}
+}
+
+func (rec *Recorder) anonymize(ex *Exchange) {
+	ex.Path = rec.replacer.Replace(ex.Path)
+	for name, value := range ex.Header {
+		ex.Header[name] = rec.replacer.Replace(value)
+	}
+	ex.Body = rec.replacer.Replace(redact.ICS(ex.Body))
+	ex.ResponseBody = rec.replacer.Replace(redact.ICS(ex.ResponseBody))
+}
+
+func (rec *Recorder) write(ex *Exchange) error {
+	data, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return err
+	}
+	rec.mu.Lock()
+	rec.seq++
+	name := fmt.Sprintf("%04d-%s.json", rec.seq, strings.ToLower(ex.Method))
+	rec.mu.Unlock()
+	return os.WriteFile(filepath.Join(rec.dir, name), append(data, '\n'), 0o644)
+}
+
+// Load reads the exchanges recorded in dir, in recording order.
+func Load(dir string) ([]Exchange, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	exchanges := make([]Exchange, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var ex Exchange
+		if err := json.Unmarshal(data, &ex); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, nil
+}
+
+// Replay sends the exchanges to h in order, authenticated as user with
+// password, and returns an error describing the first response that differs
+// from the recording. Statuses must be equal; when a response body was
+// recorded, the actual one is redacted the same way and compared; XML bodies
+// are compared ignoring formatting, attribute order and element order.
+func Replay(h http.Handler, exchanges []Exchange, user, password string) error {
+	for i, ex := range exchanges {
+		r, err := http.NewRequest(ex.Method, ex.Path, strings.NewReader(ex.Body))
+		if err != nil {
+			return fmt.Errorf("exchange %d (%s %s): %w", i+1, ex.Method, ex.Path, err)
+		}
+		r.RequestURI = ex.Path
+		for name, value := range ex.Header {
+			r.Header.Set(name, value)
+		}
+		r.SetBasicAuth(user, password)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != ex.Status {
+			return fmt.Errorf("exchange %d (%s %s): status %d, recorded %d",
+				i+1, ex.Method, ex.Path, w.Code, ex.Status)
+		}
+		if ex.ResponseBody == "" {
+			continue
+		}
+		if got, want := normalize(redact.ICS(w.Body.String())), normalize(ex.ResponseBody); got != want {
+			return fmt.Errorf("exchange %d (%s %s): response body differs\ngot:  %s\nwant: %s",
+				i+1, ex.Method, ex.Path, got, want)
+		}
+	}
+	return nil
+}
+
+// normalize makes bodies comparable. XML is canonicalized so attribute and
+// sibling order don't matter, since the server emits properties from maps.
+// Anything else only has its line endings and surrounding space normalized.
+func normalize(body string) string {
+	body = strings.TrimSpace(strings.ReplaceAll(body, "\r\n", "\n"))
+	doc := etree.NewDocument()
+	if !strings.HasPrefix(body, "<") || doc.ReadFromString(body) != nil || doc.Root() == nil {
+		return body
+	}
+	return canonicalElement(doc.Root())
+}
+
+func canonicalElement(el *etree.Element) string {
+	// Compare by namespace URI, so added declarations or other prefixes
+	// don't count as differences
+	tag := "{" + el.NamespaceURI() + "}" + el.Tag
+	var sb strings.Builder
+	sb.WriteString("<" + tag)
+	attrs := make([]string, 0, len(el.Attr))
+	for _, attr := range el.Attr {
+		if attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns") {
+			continue
+		}
+		attrs = append(attrs, attr.FullKey()+"="+strconv.Quote(attr.Value))
+	}
+	sort.Strings(attrs)
+	for _, attr := range attrs {
+		sb.WriteString(" " + attr)
+	}
+	sb.WriteString(">")
+	children := make([]string, 0, len(el.ChildElements()))
+	for _, child := range el.ChildElements() {
+		children = append(children, canonicalElement(child))
+	}
+	sort.Strings(children)
+	sb.WriteString(strings.TrimSpace(el.Text()))
+	sb.WriteString(strings.Join(children, ""))
+	sb.WriteString("</" + tag + ">")
+	return sb.String()
+}
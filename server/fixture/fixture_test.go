@@ -0,0 +1,122 @@
+package fixture
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const principalPropfind = `<?xml version="1.0" encoding="UTF-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:displayname/>
+    <D:current-user-principal/>
+    <C:calendar-home-set/>
+  </D:prop>
+</D:propfind>`
+
+// newFixtureHandler returns a CaldavHandler whose only user is "user" with
+// password "password", the identity fixtures are replayed as.
+func newFixtureHandler() http.Handler {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "user", "password").Return("user", nil)
+	mockStorage.On("AuthUser", mock.Anything, mock.Anything).Return("", storage.ErrPermissionDenied)
+	mockStorage.On("GetUser", "user").Return(&storage.User{DisplayName: "Test User"}, nil)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return server.NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, logger)
+}
+
+func TestRecorderAnonymizes(t *testing.T) {
+	dir := t.TempDir()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(body), "SUMMARY:Dentist", "the handler sees the original body")
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusMultiStatus)
+		io.WriteString(w, "<D:href>/caldav/alice/cal/work/</D:href>\n"+
+			"<C:calendar-data>BEGIN:VEVENT\nATTENDEE;CN=Alice:mailto:alice@example.com\nEND:VEVENT\n</C:calendar-data>")
+	})
+	rec, err := NewRecorder(dir, next, map[string]string{
+		"alice@example.com": "user@example.com",
+		"alice":             "user",
+	}, nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPut, "/caldav/alice/cal/work/a.ics",
+		strings.NewReader("BEGIN:VEVENT\r\nSUMMARY:Dentist\r\nEND:VEVENT\r\n"))
+	r.Header.Set("Content-Type", "text/calendar")
+	r.Header.Set("Cookie", "session=secret")
+	r.SetBasicAuth("alice", "hunter2")
+	w := httptest.NewRecorder()
+	rec.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	_, err = os.Stat(filepath.Join(dir, "0001-put.json"))
+	require.NoError(t, err)
+	exchanges, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, exchanges, 1)
+
+	ex := exchanges[0]
+	assert.Equal(t, http.MethodPut, ex.Method)
+	assert.Equal(t, "/caldav/user/cal/work/a.ics", ex.Path)
+	assert.Equal(t, map[string]string{"Content-Type": "text/calendar"}, ex.Header)
+	assert.Equal(t, "BEGIN:VEVENT\r\nSUMMARY:[REDACTED]\r\nEND:VEVENT\r\n", ex.Body)
+	assert.Equal(t, http.StatusMultiStatus, ex.Status)
+	assert.Contains(t, ex.ResponseBody, "/caldav/user/cal/work/")
+	assert.Contains(t, ex.ResponseBody, "ATTENDEE:[REDACTED]")
+	assert.NotContains(t, ex.ResponseBody, "alice")
+
+	// A new recorder continues the numbering
+	rec, err = NewRecorder(dir, next, nil, nil)
+	require.NoError(t, err)
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/caldav/x.ics",
+		strings.NewReader("SUMMARY:Dentist")))
+	_, err = os.Stat(filepath.Join(dir, "0002-put.json"))
+	assert.NoError(t, err)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir, newFixtureHandler(), nil, nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("PROPFIND", "/caldav/user", strings.NewReader(principalPropfind))
+	r.Header.Set("Depth", "0")
+	r.Header.Set("Content-Type", "application/xml")
+	r.SetBasicAuth("user", "password")
+	rec.ServeHTTP(httptest.NewRecorder(), r)
+
+	exchanges, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, exchanges, 1)
+	assert.Equal(t, http.StatusMultiStatus, exchanges[0].Status)
+	assert.NoError(t, Replay(newFixtureHandler(), exchanges, "user", "password"))
+
+	// Wrong credentials change the status
+	err = Replay(newFixtureHandler(), exchanges, "user", "wrong")
+	assert.ErrorContains(t, err, "status 401, recorded 207")
+
+	// A changed response body is reported
+	exchanges[0].ResponseBody = strings.Replace(exchanges[0].ResponseBody, "Test User", "Someone Else", 1)
+	err = Replay(newFixtureHandler(), exchanges, "user", "password")
+	assert.ErrorContains(t, err, "response body differs")
+}
+
+func TestReplayTestdata(t *testing.T) {
+	exchanges, err := Load(filepath.Join("testdata", "principal"))
+	require.NoError(t, err)
+	require.NotEmpty(t, exchanges)
+	assert.NoError(t, Replay(newFixtureHandler(), exchanges, "user", "password"))
+}
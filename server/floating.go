@@ -0,0 +1,63 @@
+package server
+
+import (
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/cyp0633/libcaldora/server/tzalias"
+	"github.com/emersion/go-ical"
+)
+
+// setFloatingLocation sets the zone the time-ranges of filter read floating
+// times in and the Recurrence engine they use, and hands their evaluations to
+// RecurrenceStats. Filters without time-ranges are left alone, which saves
+// looking up the calendar.
+func (h *CaldavHandler) setFloatingLocation(filter *storage.Filter, queryTZID, userID, calendarID string) {
+	if !filter.HasTimeRange() {
+		return
+	}
+	filter.SetFloatingLocation(h.floatingLocation(queryTZID, userID, calendarID))
+	filter.SetRecurrenceEngine(h.Recurrence, recurrenceScope(userID, calendarID))
+	if h.RecurrenceStats != nil {
+		filter.SetEvaluationObserver(func(uid string, stats recurrence.EvaluationStats) {
+			h.RecurrenceStats(userID, calendarID, uid, stats)
+		})
+	}
+}
+
+// floatingLocation returns the zone a calendar-query reads floating times
+// (no TZID, no Z suffix) in, following RFC 4791 section 9.9: the zone the
+// query names, else the calendar's calendar-timezone, else
+// h.DefaultTimezone, else UTC. Zones that don't load are skipped.
+func (h *CaldavHandler) floatingLocation(queryTZID, userID, calendarID string) *time.Location {
+	if loc := loadTimezone(queryTZID); loc != nil {
+		return loc
+	}
+	if calendarID != "" {
+		cal, err := h.Storage.GetCalendar(userID, calendarID)
+		if err == nil && cal != nil && cal.CalendarData != nil {
+			if tzid := cal.CalendarData.Props.Get(ical.PropTimezoneID); tzid != nil {
+				if loc := loadTimezone(tzid.Value); loc != nil {
+					return loc
+				}
+			}
+		}
+	}
+	if h.DefaultTimezone != nil {
+		return h.DefaultTimezone
+	}
+	return time.UTC
+}
+
+// loadTimezone loads a TZID, resolving aliases, or returns nil.
+func loadTimezone(tzid string) *time.Location {
+	if tzid == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(tzalias.Normalize(tzid))
+	if err != nil {
+		return nil
+	}
+	return loc
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFloatingLocation(t *testing.T) {
+	mockStorage := &storage.MockStorage{}
+	zoned := ical.NewCalendar()
+	zoned.Props.SetText(ical.PropTimezoneID, "W. Europe Standard Time")
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: zoned}, nil)
+	mockStorage.On("GetCalendar", "alice", "home").Return(&storage.Calendar{CalendarData: ical.NewCalendar()}, nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, "America/New_York", h.floatingLocation("America/New_York", "alice", "work").String())
+	assert.Equal(t, "Europe/Berlin", h.floatingLocation("", "alice", "work").String())
+	assert.Equal(t, "Europe/Berlin", h.floatingLocation("Not/A_Zone", "alice", "work").String())
+	assert.Equal(t, time.UTC, h.floatingLocation("", "alice", "home"))
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	h.DefaultTimezone = tokyo
+	assert.Equal(t, tokyo, h.floatingLocation("", "alice", "home"))
+}
+
+func TestCalendarQueryFloatingTimes(t *testing.T) {
+	mockStorage := &storage.MockStorage{}
+	zoned := ical.NewCalendar()
+	zoned.Props.SetText(ical.PropTimezoneID, "America/New_York")
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: zoned}, nil)
+	var floating *time.Location
+	mockStorage.On("GetObjectByFilter", "alice", "work", mock.Anything).
+		Run(func(args mock.Arguments) {
+			floating = args.Get(2).(*storage.Filter).Children[0].TimeRange.Floating
+		}).
+		Return([]storage.CalendarObject(nil), nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, AuthUser: "alice"}
+
+	query := func(extra string) {
+		body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="20250310T000000Z" end="20250311T000000Z"/>
+  </C:comp-filter></C:comp-filter></C:filter>` + extra + `
+</C:calendar-query>`
+		w := httptest.NewRecorder()
+		h.handleCalendarQuery(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/work/", strings.NewReader(body)), ctx)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+	}
+
+	query("")
+	require.NotNil(t, floating)
+	assert.Equal(t, "America/New_York", floating.String())
+
+	// The query's own time zone wins
+	query(`<C:timezone-id>Asia/Shanghai</C:timezone-id>`)
+	assert.Equal(t, "Asia/Shanghai", floating.String())
+}
+
+func TestCalendarQueryRecurrenceStats(t *testing.T) {
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "standup")
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC))
+	event.Props.SetDateTime(ical.PropDateTimeEnd, time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC))
+	event.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=DAILY;COUNT=5"})
+	mockStorage := &storage.MockStorage{}
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: ical.NewCalendar()}, nil)
+	mockStorage.On("GetObjectByFilter", "alice", "work", mock.Anything).
+		Run(func(args mock.Arguments) {
+			cal := ical.NewComponent(ical.CompCalendar)
+			cal.Children = append(cal.Children, event)
+			args.Get(2).(*storage.Filter).Validate(&storage.CalendarObject{Component: []*ical.Component{cal}})
+		}).
+		Return([]storage.CalendarObject(nil), nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var got []string
+	var last recurrence.EvaluationStats
+	h.RecurrenceStats = func(userID, calendarID, uid string, stats recurrence.EvaluationStats) {
+		got = append(got, userID+"/"+calendarID+"/"+uid)
+		last = stats
+	}
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, AuthUser: "alice"}
+
+	body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="20250310T000000Z" end="20250311T000000Z"/>
+  </C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+	w := httptest.NewRecorder()
+	h.handleCalendarQuery(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/work/", strings.NewReader(body)), ctx)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, []string{"alice/work/standup"}, got)
+	assert.False(t, last.Result)
+	assert.True(t, last.Ended)
+	assert.Equal(t, time.Date(2025, 3, 7, 9, 0, 0, 0, time.UTC), last.LastInstance)
+}
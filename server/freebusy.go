@@ -0,0 +1,510 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fbq "github.com/cyp0633/libcaldora/internal/xml/freebusy-query"
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/google/uuid"
+)
+
+// Free-busy types of the periods a free-busy-query reports (RFC 5545
+// section 3.2.9).
+const (
+	fbTypeBusy        = "BUSY"
+	fbTypeTentative   = "BUSY-TENTATIVE"
+	fbTypeUnavailable = "BUSY-UNAVAILABLE"
+)
+
+// busyPeriod is a span of time some events make their owner busy.
+type busyPeriod struct {
+	start, end time.Time
+	fbType     string
+}
+
+// handleFreebusyQuery answers a free-busy-query REPORT (RFC 4791 section
+// 7.10) with a VFREEBUSY of the events in the range: those of the calendar
+// it's sent to, or of every calendar FreeBusyCalendars selects when it's sent
+// to the calendar home. The latter also reports the time the user's
+// availability leaves out, see unavailablePeriods.
+func (h *CaldavHandler) handleFreebusyQuery(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, r, http.StatusBadRequest, CodeReadBody)
+		return
+	}
+	start, end, err := fbq.ParseRequest(string(body))
+	if err != nil {
+		h.Logger.Warn("invalid free-busy-query",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
+		return
+	}
+
+	var calendarID string
+	switch ctx.Resource.ResourceType {
+	case storage.ResourceHomeSet:
+	case storage.ResourceCollection:
+		calendarID = ctx.Resource.CalendarID
+	default:
+		h.writeError(w, r, http.StatusForbidden, CodeUnsupportedResource)
+		return
+	}
+
+	periods, err := h.busyPeriods(r.Context(), ctx.Resource.UserID, calendarID, start, end)
+	if err != nil {
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(freeBusyCalendar(periods, start, end)); err != nil {
+		h.Logger.Error("failed to encode free-busy",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeEncodeFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// busyPeriods returns the busy periods of a calendar of userID within
+// [start, end), or of userID's free-busy calendars and availability when
+// calendarID is empty, from FreeBusyCache when it has them.
+func (h *CaldavHandler) busyPeriods(ctx context.Context, userID, calendarID string, start, end time.Time) ([]busyPeriod, error) {
+	key := freeBusyKey{userID: userID, calendarID: calendarID, start: start.Unix(), end: end.Unix()}
+	var availability *ical.Calendar
+	if calendarID == "" {
+		var err error
+		if availability, err = h.userAvailability(userID); err != nil {
+			return nil, fmt.Errorf("failed to get availability of %q: %w", userID, err)
+		}
+		key.availability = availabilityDigest(availability)
+	}
+	if periods, ok := h.FreeBusyCache.get(key); ok {
+		return periods, nil
+	}
+
+	calendarIDs := []string{calendarID}
+	if calendarID == "" {
+		var err error
+		if calendarIDs, err = h.freeBusyCalendarIDs(userID); err != nil {
+			return nil, err
+		}
+	}
+	var instances []Instance
+	for _, id := range calendarIDs {
+		// Floating times are read as calendar-query reads them
+		loc := h.floatingLocation("", userID, id)
+		found, err := h.GetInstances(ctx, userID, []string{id}, start.In(loc), end.In(loc))
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, found...)
+	}
+	periods := mergeBusyPeriods(instances, start, end)
+	if calendarID == "" {
+		unavailable, err := unavailablePeriods(ctx, availability, start, end)
+		if err != nil {
+			return nil, err
+		}
+		periods = mergePeriods(append(periods, unavailable...))
+	}
+	h.FreeBusyCache.set(key, periods)
+	return periods, nil
+}
+
+// freeBusyCalendarIDs returns the IDs of userID's calendars FreeBusyCalendars
+// selects, all of them by default.
+func (h *CaldavHandler) freeBusyCalendarIDs(userID string) ([]string, error) {
+	calendars, err := h.Storage.GetUserCalendars(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calendars of %q: %w", userID, err)
+	}
+	var calendarIDs []string
+	for i := range calendars {
+		if h.FreeBusyCalendars != nil && !h.FreeBusyCalendars(&calendars[i]) {
+			continue
+		}
+		res, err := h.URLConverter.ParsePath(calendars[i].Path)
+		if err != nil || res.ResourceType != storage.ResourceCollection {
+			continue
+		}
+		calendarIDs = append(calendarIDs, res.CalendarID)
+	}
+	return calendarIDs, nil
+}
+
+// freeBusyCalendarChanged reports whether a change to a calendar of userID
+// may change userID's aggregated free-busy: whether FreeBusyCalendars selects
+// it. Calendars that can't be looked up, e.g. deleted ones, count.
+func (h *CaldavHandler) freeBusyCalendarChanged(userID, calendarID string) bool {
+	if h.FreeBusyCalendars == nil {
+		return true
+	}
+	cal, err := h.Storage.GetCalendar(userID, calendarID)
+	if err != nil || cal == nil {
+		return true
+	}
+	return h.FreeBusyCalendars(cal)
+}
+
+// mergeBusyPeriods turns the instances of events that block time into busy
+// periods clipped to [start, end), merging overlapping periods of the same
+// type. Instantaneous events don't make anyone busy.
+func mergeBusyPeriods(instances []Instance, start, end time.Time) []busyPeriod {
+	var periods []busyPeriod
+	for _, instance := range instances {
+		if !blocksTime(instance.Component) {
+			continue
+		}
+		p := busyPeriod{start: instance.Start.UTC(), end: instance.End.UTC(), fbType: fbTypeBusy}
+		if status, _ := instance.Component.Props.Text(ical.PropStatus); strings.EqualFold(status, "TENTATIVE") {
+			p.fbType = fbTypeTentative
+		}
+		if p.start.Before(start) {
+			p.start = start.UTC()
+		}
+		if p.end.After(end) {
+			p.end = end.UTC()
+		}
+		if p.end.After(p.start) {
+			periods = append(periods, p)
+		}
+	}
+	return mergePeriods(periods)
+}
+
+// mergePeriods sorts periods by type and start, merging overlapping periods
+// of the same type.
+func mergePeriods(periods []busyPeriod) []busyPeriod {
+	sort.SliceStable(periods, func(i, j int) bool {
+		if periods[i].fbType != periods[j].fbType {
+			return periods[i].fbType < periods[j].fbType
+		}
+		return periods[i].start.Before(periods[j].start)
+	})
+
+	var merged []busyPeriod
+	for _, p := range periods {
+		if n := len(merged); n > 0 && merged[n-1].fbType == p.fbType && !p.start.After(merged[n-1].end) {
+			if p.end.After(merged[n-1].end) {
+				merged[n-1].end = p.end
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+// unavailablePeriods returns the time of [start, end) availability, a
+// VCALENDAR of VAVAILABILITY components (RFC 7953), marks busy: within the
+// range of each VAVAILABILITY, the time none of its AVAILABLE components
+// covers, with its BUSYTYPE, BUSY-UNAVAILABLE by default. Where
+// VAVAILABILITY components overlap, the one of highest PRIORITY decides, 1
+// being the highest and 0 the lowest. Floating times are read in UTC.
+func unavailablePeriods(ctx context.Context, availability *ical.Calendar, start, end time.Time) ([]busyPeriod, error) {
+	if availability == nil {
+		return nil, nil
+	}
+	var components []*ical.Component
+	for _, child := range availability.Children {
+		if child.Name == "VAVAILABILITY" {
+			components = append(components, child)
+		}
+	}
+	rank := func(comp *ical.Component) int {
+		if prop := comp.Props.Get(ical.PropPriority); prop != nil {
+			if priority, err := prop.Int(); err == nil && priority > 0 {
+				return priority
+			}
+		}
+		return 10
+	}
+	sort.SliceStable(components, func(i, j int) bool { return rank(components[i]) < rank(components[j]) })
+
+	engine := recurrence.NewEngineWithoutCache().WithContext(ctx)
+	var decided, periods []busyPeriod
+	for _, comp := range components {
+		rangeStart, rangeEnd := start.UTC(), end.UTC()
+		if dtstart, err := comp.Props.DateTime(ical.PropDateTimeStart, time.UTC); err == nil && !dtstart.IsZero() && dtstart.After(rangeStart) {
+			rangeStart = dtstart.UTC()
+		}
+		if dtend, err := comp.Props.DateTime(ical.PropDateTimeEnd, time.UTC); err == nil && !dtend.IsZero() && dtend.Before(rangeEnd) {
+			rangeEnd = dtend.UTC()
+		} else if prop := comp.Props.Get(ical.PropDuration); prop != nil && comp.Props.Get(ical.PropDateTimeStart) != nil {
+			if d, err := prop.Duration(); err == nil && rangeStart.Add(d).Before(rangeEnd) {
+				rangeEnd = rangeStart.Add(d)
+			}
+		}
+		if !rangeEnd.After(rangeStart) {
+			continue
+		}
+
+		var available []busyPeriod
+		for _, child := range comp.Children {
+			if child.Name != "AVAILABLE" {
+				continue
+			}
+			found, err := componentInstances(engine, child, recurrence.ExtractRecurrenceInfoInLocation(child, time.UTC), rangeStart, rangeEnd)
+			if err != nil {
+				return nil, fmt.Errorf("failed to expand availability: %w", err)
+			}
+			for _, instance := range found {
+				available = append(available, busyPeriod{start: instance.Start.UTC(), end: instance.End.UTC()})
+			}
+		}
+
+		fbType, _ := comp.Props.Text("BUSYTYPE")
+		fbType = strings.ToUpper(fbType)
+		if fbType != fbTypeBusy && fbType != fbTypeTentative {
+			fbType = fbTypeUnavailable
+		}
+		span := []busyPeriod{{start: rangeStart, end: rangeEnd, fbType: fbType}}
+		periods = append(periods, subtractPeriods(subtractPeriods(span, decided), available)...)
+		decided = append(decided, span...)
+	}
+	return periods, nil
+}
+
+// subtractPeriods returns the parts of periods, sorted by start, that none of
+// cut covers, keeping their types. It sorts cut by start.
+func subtractPeriods(periods, cut []busyPeriod) []busyPeriod {
+	sort.Slice(cut, func(i, j int) bool { return cut[i].start.Before(cut[j].start) })
+	var out []busyPeriod
+	for _, p := range periods {
+		from := p.start
+		for _, c := range cut {
+			if !c.end.After(from) {
+				continue
+			}
+			if !c.start.Before(p.end) {
+				break
+			}
+			if c.start.After(from) {
+				out = append(out, busyPeriod{start: from, end: c.start, fbType: p.fbType})
+			}
+			from = c.end
+		}
+		if p.end.After(from) {
+			out = append(out, busyPeriod{start: from, end: p.end, fbType: p.fbType})
+		}
+	}
+	return out
+}
+
+// freeBusyCalendar returns the VCALENDAR answering a free-busy-query for
+// [start, end) with periods.
+func freeBusyCalendar(periods []busyPeriod, start, end time.Time) *ical.Calendar {
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropProductID, "-//libcaldora//NONSGML v1.0//EN")
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	fb := ical.NewComponent(ical.CompFreeBusy)
+	fb.Props.SetText(ical.PropUID, uuid.New().String())
+	fb.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	fb.Props.SetDateTime(ical.PropDateTimeStart, start.UTC())
+	fb.Props.SetDateTime(ical.PropDateTimeEnd, end.UTC())
+	for _, p := range periods {
+		prop := ical.NewProp(ical.PropFreeBusy)
+		prop.Params.Set(ical.ParamFreeBusyType, p.fbType)
+		prop.Value = p.start.Format("20060102T150405Z") + "/" + p.end.Format("20060102T150405Z")
+		fb.Props.Add(prop)
+	}
+	cal.Children = append(cal.Children, fb)
+	return cal
+}
+
+// FreeBusyCache keeps the busy periods free-busy-query computed, per user,
+// calendar and range, for deployments where schedulers poll availability.
+// Set it as the handler's FreeBusyCache. Every write the handler makes to an
+// object, see OnObjectChange, drops the entries of its calendar and, when
+// FreeBusyCalendars selects it, of the user's calendar home. Results for the
+// home are also keyed by the user's availability, so changed working hours
+// or VAVAILABILITY take effect right away.
+type FreeBusyCache struct {
+	TTL        time.Duration // Optional: how long results are kept, 5 minutes by default
+	MaxEntries int           // Optional: results kept at most, 10000 by default
+
+	mu      sync.Mutex
+	entries map[freeBusyKey]freeBusyEntry
+	stats   FreeBusyCacheStats
+}
+
+// FreeBusyCacheStats counts the work of a FreeBusyCache since it was
+// created. All fields but Entries only grow.
+type FreeBusyCacheStats struct {
+	Hits          uint64 // queries answered from the cache
+	Misses        uint64 // queries computed
+	Invalidations uint64 // entries dropped because objects changed
+	Evictions     uint64 // entries dropped because they expired or the cache was full
+	Entries       int    // entries currently cached
+}
+
+const (
+	defaultFreeBusyTTL        = 5 * time.Minute
+	defaultFreeBusyMaxEntries = 10000
+)
+
+// freeBusyKey identifies a cached result. calendarID is empty for the
+// calendar home, and the range is in Unix seconds. availability is the
+// availabilityDigest home results were computed with, so they aren't served
+// once the user's availability or working hours change, even when the
+// backend changes them without the handler knowing.
+type freeBusyKey struct {
+	userID, calendarID string
+	start, end         int64
+	availability       uint64
+}
+
+// availabilityDigest fingerprints the VAVAILABILITY components of cal, 0 for
+// none.
+func availabilityDigest(cal *ical.Calendar) uint64 {
+	if cal == nil {
+		return 0
+	}
+	digest := fnv.New64a()
+	var write func(comp *ical.Component)
+	write = func(comp *ical.Component) {
+		fmt.Fprintf(digest, "BEGIN:%s\n", comp.Name)
+		for _, name := range slices.Sorted(maps.Keys(comp.Props)) {
+			for _, prop := range comp.Props[name] {
+				fmt.Fprintf(digest, "%s%v:%s\n", name, prop.Params, prop.Value)
+			}
+		}
+		for _, child := range comp.Children {
+			write(child)
+		}
+		fmt.Fprintf(digest, "END:%s\n", comp.Name)
+	}
+	write(cal.Component)
+	return digest.Sum64()
+}
+
+type freeBusyEntry struct {
+	periods []busyPeriod
+	expires time.Time
+}
+
+// get returns the cached periods of key. A nil cache has none.
+func (c *FreeBusyCache) get(key freeBusyKey) ([]busyPeriod, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		c.stats.Evictions++
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	return entry.periods, true
+}
+
+func (c *FreeBusyCache) set(key freeBusyKey, periods []busyPeriod) {
+	if c == nil {
+		return
+	}
+	ttl, maxEntries := c.TTL, c.MaxEntries
+	if ttl <= 0 {
+		ttl = defaultFreeBusyTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultFreeBusyMaxEntries
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[freeBusyKey]freeBusyEntry)
+	}
+	if len(c.entries) >= maxEntries {
+		// Drop expired entries, then the ones closest to expiring
+		var oldest freeBusyKey
+		var oldestExpiry time.Time
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+				c.stats.Evictions++
+			} else if oldestExpiry.IsZero() || entry.expires.Before(oldestExpiry) {
+				oldest, oldestExpiry = k, entry.expires
+			}
+		}
+		if len(c.entries) >= maxEntries {
+			delete(c.entries, oldest)
+			c.stats.Evictions++
+		}
+	}
+	c.entries[key] = freeBusyEntry{periods: periods, expires: now.Add(ttl)}
+}
+
+// Invalidate drops the cached results of a calendar of userID, and those of
+// userID's calendar home when home is set. An empty calendarID drops all of
+// userID's results.
+func (c *FreeBusyCache) Invalidate(userID, calendarID string, home bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.userID != userID {
+			continue
+		}
+		if calendarID == "" || key.calendarID == calendarID || (home && key.calendarID == "") {
+			delete(c.entries, key)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// Stats returns what the cache did so far.
+func (c *FreeBusyCache) Stats() FreeBusyCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// WriteMetrics writes Stats in the Prometheus text exposition format, for
+// appending to a /metrics endpoint.
+func (c *FreeBusyCache) WriteMetrics(out io.Writer) error {
+	stats := c.Stats()
+	for _, metric := range []struct {
+		name, help, kind string
+		value            any
+	}{
+		{"caldav_freebusy_cache_hits_total", "Free-busy queries answered from the cache.", "counter", stats.Hits},
+		{"caldav_freebusy_cache_misses_total", "Free-busy queries computed.", "counter", stats.Misses},
+		{"caldav_freebusy_cache_invalidations_total", "Cached free-busy results dropped because objects changed.", "counter", stats.Invalidations},
+		{"caldav_freebusy_cache_evictions_total", "Cached free-busy results dropped because they expired or the cache was full.", "counter", stats.Evictions},
+		{"caldav_freebusy_cache_entries", "Free-busy results currently cached.", "gauge", stats.Entries},
+	} {
+		if _, err := fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %v\n",
+			metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
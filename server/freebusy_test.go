@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeBusyQuery(t *testing.T) {
+	store := &changeStorage{MockStorage: &storage.MockStorage{}, objects: map[string]storage.CalendarObject{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work", CalendarData: ical.NewCalendar()}, nil)
+	store.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work"}}, nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.FreeBusyCache = &FreeBusyCache{}
+
+	put := func(name, props string) {
+		body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:" + name + "\r\n" +
+			"DTSTAMP:20250101T000000Z\r\n" + props + "END:VEVENT\r\nEND:VCALENDAR\r\n"
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/"+name+".ics", body,
+			map[string]string{"Content-Type": "text/calendar"})
+		require.Less(t, w.Code, 300, w.Body.String())
+	}
+	query := func(path string) string {
+		body := `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:time-range start="20250303T000000Z" end="20250305T000000Z"/>
+</C:free-busy-query>`
+		w := serveAlice(h, "REPORT", path, body, map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+		return w.Body.String()
+	}
+
+	put("standup", "DTSTART:20250303T090000Z\r\nDTEND:20250303T093000Z\r\nRRULE:FREQ=DAILY\r\n")
+	put("review", "DTSTART:20250303T092000Z\r\nDTEND:20250303T100000Z\r\n")
+	put("maybe", "DTSTART:20250304T140000Z\r\nDTEND:20250304T150000Z\r\nSTATUS:TENTATIVE\r\n")
+	put("reminder", "DTSTART:20250304T120000Z\r\nDTEND:20250304T130000Z\r\nTRANSP:TRANSPARENT\r\n")
+
+	body := query("/caldav/alice/cal/work/")
+	assert.Contains(t, body, "BEGIN:VFREEBUSY")
+	assert.Contains(t, body, "DTSTART:20250303T000000Z")
+	assert.Contains(t, body, "FREEBUSY;FBTYPE=BUSY:20250303T090000Z/20250303T100000Z\r\n", "overlapping events are merged")
+	assert.Contains(t, body, "FREEBUSY;FBTYPE=BUSY:20250304T090000Z/20250304T093000Z\r\n")
+	assert.Contains(t, body, "FREEBUSY;FBTYPE=BUSY-TENTATIVE:20250304T140000Z/20250304T150000Z\r\n")
+	assert.NotContains(t, body, "20250304T120000Z", "transparent events don't block time")
+
+	assert.Equal(t, freeBusyLines(body), freeBusyLines(query("/caldav/alice/cal/work/")))
+	assert.Equal(t, FreeBusyCacheStats{Hits: 1, Misses: 1, Entries: 1}, h.FreeBusyCache.Stats())
+
+	// The calendar home aggregates the calendars FreeBusyCalendars selects
+	assert.Contains(t, query("/caldav/alice/cal/"), "FREEBUSY;FBTYPE=BUSY:20250303T090000Z/20250303T100000Z\r\n")
+	h.FreeBusyCalendars = func(cal *storage.Calendar) bool { return false }
+	h.FreeBusyCache.Invalidate("alice", "", false)
+	assert.Empty(t, freeBusyLines(query("/caldav/alice/cal/")))
+	query("/caldav/alice/cal/work/")
+	assert.Equal(t, 2, h.FreeBusyCache.Stats().Entries)
+
+	// A change to a transparent calendar leaves the home's result alone
+	put("review", "DTSTART:20250303T110000Z\r\nDTEND:20250303T120000Z\r\n")
+	stats := h.FreeBusyCache.Stats()
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, uint64(3), stats.Invalidations)
+	assert.Equal(t, []string{
+		"FREEBUSY;FBTYPE=BUSY:20250303T090000Z/20250303T093000Z",
+		"FREEBUSY;FBTYPE=BUSY:20250303T110000Z/20250303T120000Z",
+		"FREEBUSY;FBTYPE=BUSY:20250304T090000Z/20250304T093000Z",
+		"FREEBUSY;FBTYPE=BUSY-TENTATIVE:20250304T140000Z/20250304T150000Z",
+	}, freeBusyLines(query("/caldav/alice/cal/work/")))
+
+	// Once opaque, changes to it drop the home's result too
+	h.FreeBusyCalendars = nil
+	w := serveAlice(h, http.MethodDelete, "/caldav/alice/cal/work/review.ics", "", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, 0, h.FreeBusyCache.Stats().Entries)
+	assert.NotContains(t, query("/caldav/alice/cal/work/"), "20250303T110000Z")
+
+	var metrics bytes.Buffer
+	require.NoError(t, h.FreeBusyCache.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), "# TYPE caldav_freebusy_cache_hits_total counter\ncaldav_freebusy_cache_hits_total 1\n")
+	assert.Contains(t, metrics.String(), "# TYPE caldav_freebusy_cache_entries gauge\ncaldav_freebusy_cache_entries 1\n")
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav"/>`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/standup.ics", `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:time-range start="20250303T000000Z" end="20250305T000000Z"/>
+</C:free-busy-query>`, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// freeBusyLines returns the FREEBUSY properties of a free-busy-query response.
+func freeBusyLines(body string) []string {
+	var lines []string
+	for _, line := range strings.Split(body, "\r\n") {
+		if strings.HasPrefix(line, "FREEBUSY;") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestFreeBusyCacheLimits(t *testing.T) {
+	cache := &FreeBusyCache{TTL: time.Minute, MaxEntries: 2}
+	for i := int64(0); i < 3; i++ {
+		cache.set(freeBusyKey{userID: "alice", start: i}, nil)
+	}
+	assert.Equal(t, FreeBusyCacheStats{Evictions: 1, Entries: 2}, cache.Stats())
+	_, ok := cache.get(freeBusyKey{userID: "alice", start: 2})
+	assert.True(t, ok, "the newest entry is kept")
+
+	cache.TTL = time.Nanosecond
+	cache.set(freeBusyKey{userID: "bob"}, nil)
+	time.Sleep(time.Millisecond)
+	_, ok = cache.get(freeBusyKey{userID: "bob"})
+	assert.False(t, ok, "expired entries aren't returned")
+
+	var nilCache *FreeBusyCache
+	_, ok = nilCache.get(freeBusyKey{userID: "alice"})
+	assert.False(t, ok)
+	nilCache.set(freeBusyKey{userID: "alice"}, nil)
+	nilCache.Invalidate("alice", "", true)
+}
+
+func TestUnavailablePeriods(t *testing.T) {
+	cal, err := ical.NewDecoder(strings.NewReader("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+		"BEGIN:VAVAILABILITY\r\nUID:default\r\nDTSTAMP:20250101T000000Z\r\n" +
+		"BEGIN:AVAILABLE\r\nUID:day\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250303T090000Z\r\nDTEND:20250303T170000Z\r\nEND:AVAILABLE\r\n" +
+		"END:VAVAILABILITY\r\n" +
+		"BEGIN:VAVAILABILITY\r\nUID:offsite\r\nDTSTAMP:20250101T000000Z\r\nPRIORITY:1\r\nBUSYTYPE:BUSY-TENTATIVE\r\n" +
+		"DTSTART:20250303T120000Z\r\nDTEND:20250303T140000Z\r\nEND:VAVAILABILITY\r\nEND:VCALENDAR\r\n")).Decode()
+	require.NoError(t, err)
+
+	start := time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)
+	periods, err := unavailablePeriods(context.Background(), cal, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	at := func(hour int) time.Time { return start.Add(time.Duration(hour) * time.Hour) }
+	// The higher priority component decides its range on its own
+	assert.ElementsMatch(t, []busyPeriod{
+		{start: at(12), end: at(14), fbType: fbTypeTentative},
+		{start: at(0), end: at(9), fbType: fbTypeUnavailable},
+		{start: at(17), end: at(24), fbType: fbTypeUnavailable},
+	}, periods)
+}
+
+func TestFreeBusyCacheWorkingHours(t *testing.T) {
+	store := &availabilityStorage{MockStorage: &storage.MockStorage{}, cals: map[string]*ical.Calendar{}}
+	user := &storage.User{
+		PreferredTimezone: "UTC",
+		WorkingHours:      []storage.WorkingHours{{Days: []string{"MO"}, Start: 9 * time.Hour, End: 17 * time.Hour}},
+	}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetUser", "alice").Return(user, nil)
+	store.On("GetUserCalendars", "alice").Return([]storage.Calendar{}, nil)
+	h := NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.FreeBusyCache = &FreeBusyCache{}
+	query := `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:time-range start="20250303T000000Z" end="20250304T000000Z"/>
+</C:free-busy-query>`
+
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/", query, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, freeBusyLines(w.Body.String()), "FREEBUSY;FBTYPE=BUSY-UNAVAILABLE:20250303T170000Z/20250304T000000Z")
+
+	// The backend changes the working hours behind the handler's back
+	user.WorkingHours[0].End = 15 * time.Hour
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/", query, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, freeBusyLines(w.Body.String()), "FREEBUSY;FBTYPE=BUSY-UNAVAILABLE:20250303T150000Z/20250304T000000Z")
+	assert.Equal(t, uint64(2), h.FreeBusyCache.Stats().Misses)
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/", query, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint64(1), h.FreeBusyCache.Stats().Hits, "unchanged availability is served from the cache")
+}
@@ -17,27 +17,39 @@ func (h *CaldavHandler) handleGet(w http.ResponseWriter, r *http.Request, ctx *R
 		"calendar_id", ctx.Resource.CalendarID,
 		"object_id", ctx.Resource.ObjectID)
 
+	if ctx.Share != nil && ctx.Resource.ResourceType == storage.ResourceCollection {
+		h.handleCalendarFeed(w, r, ctx)
+		return
+	}
 	if ctx.Resource.ResourceType != storage.ResourceObject {
 		// Technically GET might be allowed on collections by some servers (listing?), but often not.
 		// GET on Principal/HomeSet is unusual in CalDAV.
 		h.Logger.Warn("get not supported on this resource type",
 			"resource_type", ctx.Resource.ResourceType)
-		http.Error(w, "Method Not Allowed on this resource type (or GET not implemented)", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
 		return
 	}
 
 	// get object
 	object, err := h.Storage.GetObject(ctx.Resource.UserID, ctx.Resource.CalendarID, ctx.Resource.ObjectID)
-	if err != nil || object == nil || len(object.Component) == 0 {
-		h.Logger.Error("failed to retrieve object",
-			"error", err,
+	if err != nil {
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+	if object == nil || len(object.Component) == 0 {
+		h.Logger.Error("storage returned an empty object",
 			"object_id", ctx.Resource.ObjectID)
-		http.Error(w, "Internal Server Error: Unable to retrieve object", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+
+	if object = h.view(object); object == nil {
+		h.writeError(w, r, http.StatusNotFound, CodeNotFound)
 		return
 	}
 
 	// judge etag
-	if etag := r.Header.Get("If-None-Match"); etag == object.ETag {
+	if etag := r.Header.Get("If-None-Match"); etag != "" && h.etagMatches(etag, object) {
 		// ETag matches, return 304 Not Modified
 		h.Logger.Info("returning not modified due to etag match",
 			"etag", etag)
@@ -47,31 +59,39 @@ func (h *CaldavHandler) handleGet(w http.ResponseWriter, r *http.Request, ctx *R
 
 	// get associated collection
 	collection, err := h.Storage.GetCalendar(ctx.Resource.UserID, ctx.Resource.CalendarID)
-	if err != nil || collection == nil || collection.CalendarData == nil {
-		h.Logger.Error("failed to retrieve calendar collection",
-			"error", err,
+	if err != nil {
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+	if collection == nil || collection.CalendarData == nil {
+		h.Logger.Error("storage returned a calendar without data",
 			"calendar_id", ctx.Resource.CalendarID)
-		http.Error(w, "Internal Server Error: Unable to retrieve calendar collection", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
 		return
 	}
 
-	// wrap event into calendar
+	// wrap event into a calendar carrying the collection's properties,
+	// leaving the storage's CalendarData alone, as it may be shared
+	cal := ical.NewCalendar()
+	for name, props := range collection.CalendarData.Props {
+		cal.Props[name] = props
+	}
 	for _, component := range object.Component {
 		if component != nil {
-			collection.CalendarData.Children = append(collection.CalendarData.Children, component)
+			cal.Children = append(cal.Children, component)
 		}
 	}
 
 	// Ensure PRODID and VERSION are set to avoid encoding errors
-	if _, err := collection.CalendarData.Props.Text(ical.PropProductID); err != nil {
-		collection.CalendarData.Props.SetText(ical.PropProductID, "-//libcaldora//NONSGML v1.0//EN")
+	if cal.Props.Get(ical.PropProductID) == nil {
+		cal.Props.SetText(ical.PropProductID, "-//libcaldora//NONSGML v1.0//EN")
 	}
-	if _, err := collection.CalendarData.Props.Text(ical.PropVersion); err != nil {
-		collection.CalendarData.Props.SetText(ical.PropVersion, "2.0")
+	if cal.Props.Get(ical.PropVersion) == nil {
+		cal.Props.SetText(ical.PropVersion, "2.0")
 	}
 
 	// Ensure DTSTAMP is set in all VEVENT components
-	for _, child := range collection.CalendarData.Children {
+	for _, child := range cal.Children {
 		if child.Name == ical.CompEvent {
 			if _, err := child.Props.DateTime(ical.PropDateTimeStamp, nil); err != nil {
 				// Missing DTSTAMP, set it to now
@@ -81,17 +101,19 @@ func (h *CaldavHandler) handleGet(w http.ResponseWriter, r *http.Request, ctx *R
 	}
 
 	var buf bytes.Buffer
-	if err := ical.NewEncoder(&buf).Encode(collection.CalendarData); err != nil {
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
 		h.Logger.Error("failed to encode calendar",
 			"error", err)
-		http.Error(w, "Internal Server Error: Failed to encode calendar", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeEncodeFailed)
 		return
 	}
 
 	// Set the content type and return the ICS data
-	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Type", h.objectContentType(object))
 	w.Header().Set("Content-Length", fmt.Sprint(len(buf.Bytes())))
-	w.Header().Set("ETag", object.ETag)
+	if etag := h.objectETag(object); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(buf.Bytes())
 	if err != nil {
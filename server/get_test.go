@@ -1,6 +1,7 @@
 package server
 
 import (
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -116,7 +117,7 @@ func TestHandleGet(t *testing.T) {
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
-				assert.Equal(t, "text/calendar; charset=utf-8", recorder.Header().Get("Content-Type"))
+				assert.Equal(t, "text/calendar; charset=utf-8; component=VEVENT", recorder.Header().Get("Content-Type"))
 				assert.Equal(t, "etag-event-123", recorder.Header().Get("ETag"))
 				assert.NotEmpty(t, recorder.Body.String())
 				assert.Contains(t, recorder.Body.String(), "VEVENT")
@@ -262,7 +263,7 @@ func TestHandleGetMultiComponentObjects(t *testing.T) {
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
-				assert.Equal(t, "text/calendar; charset=utf-8", recorder.Header().Get("Content-Type"))
+				assert.Equal(t, "text/calendar; charset=utf-8; component=VEVENT", recorder.Header().Get("Content-Type"))
 				assert.Equal(t, "etag-tz-event-123", recorder.Header().Get("ETag"))
 
 				body := recorder.Body.String()
@@ -321,7 +322,7 @@ func TestHandleGetMultiComponentObjects(t *testing.T) {
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
-				assert.Equal(t, "text/calendar; charset=utf-8", recorder.Header().Get("Content-Type"))
+				assert.Equal(t, "text/calendar; charset=utf-8; component=VEVENT", recorder.Header().Get("Content-Type"))
 				assert.Equal(t, "etag-recurring-exception-123", recorder.Header().Get("ETag"))
 
 				body := recorder.Body.String()
@@ -390,7 +391,7 @@ func TestHandleGetMultiComponentObjects(t *testing.T) {
 			},
 			expectedStatus: http.StatusOK,
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
-				assert.Equal(t, "text/calendar; charset=utf-8", recorder.Header().Get("Content-Type"))
+				assert.Equal(t, "text/calendar; charset=utf-8; component=VEVENT", recorder.Header().Get("Content-Type"))
 				assert.Equal(t, "etag-complex-123", recorder.Header().Get("ETag"))
 
 				body := recorder.Body.String()
@@ -462,3 +463,54 @@ func TestHandleGetMultiComponentObjects(t *testing.T) {
 		})
 	}
 }
+
+func TestObjectContentType(t *testing.T) {
+	todo := ical.NewComponent(ical.CompToDo)
+	todo.Props.SetText(ical.PropUID, "todo-1")
+	todo.Props.SetDateTime(ical.PropDateTimeStamp, time.Now())
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, "Europe/Berlin")
+	standard := ical.NewComponent("STANDARD")
+	standard.Props.SetText(ical.PropDateTimeStart, "19701025T030000")
+	standard.Props.SetText(ical.PropTimezoneOffsetFrom, "+0200")
+	standard.Props.SetText(ical.PropTimezoneOffsetTo, "+0100")
+	tz.Children = []*ical.Component{standard}
+	wrapped := ical.NewCalendar()
+	wrapped.Children = []*ical.Component{tz, todo}
+
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work"}}, nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: ical.NewCalendar()}, nil)
+	mockStorage.On("GetObject", "alice", "work", "todo.ics").Return(&storage.CalendarObject{
+		Path:      "/alice/cal/work/todo.ics",
+		ETag:      "etag",
+		Component: []*ical.Component{tz, todo},
+	}, nil)
+	mockStorage.On("GetObject", "alice", "work", "wrapped.ics").Return(&storage.CalendarObject{
+		Path:      "/alice/cal/work/wrapped.ics",
+		ETag:      "etag",
+		Component: []*ical.Component{wrapped.Component},
+	}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	body := `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getcontenttype/></d:prop></d:propfind>`
+
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/todo.ics", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/calendar; charset=utf-8; component=VTODO", w.Header().Get("Content-Type"))
+
+	// Named after the first component besides VTIMEZONE, also when
+	// storage keeps the object wrapped in its VCALENDAR
+	for _, name := range []string{"todo.ics", "wrapped.ics"} {
+		w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/"+name, body, map[string]string{"Depth": "0"})
+		assert.Equal(t, http.StatusMultiStatus, w.Code, name)
+		assert.Contains(t, w.Body.String(), "<d:getcontenttype>text/calendar; charset=utf-8; component=VTODO</d:getcontenttype>", name)
+	}
+
+	// Left out for clients that mis-parse the parameter
+	h.OmitContentTypeComponent = true
+	w = serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/todo.ics", "", nil)
+	assert.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/todo.ics", body, map[string]string{"Depth": "0"})
+	assert.Contains(t, w.Body.String(), "<d:getcontenttype>text/calendar; charset=utf-8</d:getcontenttype>")
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenScenarios replay request shapes captured from clients. Each body is
+// read from testdata/golden/<name>.request.xml and the response compared to
+// testdata/golden/<name>.golden.xml.
+var goldenScenarios = []struct {
+	name, method, path, depth string
+	status                    int
+}{
+	{"ios/principal", "PROPFIND", "/caldav/alice/", "0", http.StatusMultiStatus},
+	{"ios/home", "PROPFIND", "/caldav/alice/cal/", "1", http.StatusMultiStatus},
+	{"ios/sync", "REPORT", "/caldav/alice/cal/work/", "", http.StatusMultiStatus},
+	{"davx5/principal", "PROPFIND", "/caldav/alice/", "0", http.StatusMultiStatus},
+	{"davx5/home", "PROPFIND", "/caldav/alice/cal/", "1", http.StatusMultiStatus},
+	{"davx5/multiget", "REPORT", "/caldav/alice/cal/work/", "1", http.StatusMultiStatus},
+	{"thunderbird/principal", "PROPFIND", "/caldav/alice/", "0", http.StatusMultiStatus},
+	{"thunderbird/calendar", "PROPFIND", "/caldav/alice/cal/work/", "0", http.StatusMultiStatus},
+	{"thunderbird/query", "REPORT", "/caldav/alice/cal/work/", "1", http.StatusMultiStatus},
+}
+
+// newGoldenTestHandler serves alice's work calendar, holding one event, from
+// fixed data, so responses don't change between runs. Sync requests are
+// answered by journalStorage.
+func newGoldenTestHandler() *CaldavHandler {
+	stamp := time.Date(2025, 1, 2, 8, 0, 0, 0, time.UTC)
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "a@example.com")
+	event.Props.SetText(ical.PropSummary, "Planning")
+	event.Props.SetDateTime(ical.PropDateTimeStamp, stamp)
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC))
+	event.Props.SetDateTime(ical.PropDateTimeEnd, time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC))
+	object := &storage.CalendarObject{
+		Path:         "/alice/cal/work/a.ics",
+		ETag:         `"a2"`,
+		LastModified: stamp,
+		Component:    []*ical.Component{event},
+	}
+	data := ical.NewCalendar()
+	data.Props.SetText(ical.PropName, "Work")
+	data.Props.SetText(ical.PropDescription, "Team events")
+	data.Props.SetText(ical.PropColor, "#FF9500")
+	calendar := &storage.Calendar{
+		Path:                "/alice/cal/work",
+		CTag:                `"12"`,
+		ETag:                `"c12"`,
+		SupportedComponents: []string{ical.CompEvent, ical.CompToDo},
+		CalendarData:        data,
+	}
+
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetUser", "alice").Return(&storage.User{DisplayName: "Alice", UserAddress: "mailto:alice@example.com"}, nil)
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{*calendar}, nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(calendar, nil)
+	mockStorage.On("GetObjectPathsInCollection", "work").Return([]string{object.Path}, nil)
+	mockStorage.On("GetObjectsInCollection", "work").Return([]storage.CalendarObject{*object}, nil)
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(object, nil)
+	mockStorage.On("GetObject", "alice", "work", "gone.ics").Return(nil, storage.ErrNotFound)
+	mockStorage.On("GetObjectByFilter", "alice", "work", mock.Anything).Return([]storage.CalendarObject{*object}, nil)
+	h := NewCaldavHandler("/caldav/", "test", &journalStorage{MockStorage: mockStorage}, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.XMLFormat = XMLFormat{Canonical: true, Indent: 2}
+	return h
+}
+
+// TestGoldenMultistatus compares responses to client request shapes with
+// the golden files. Run "go test -run TestGoldenMultistatus -update" in
+// this directory to rewrite them after an intended change, and review the
+// diff.
+func TestGoldenMultistatus(t *testing.T) {
+	h := newGoldenTestHandler()
+	for _, sc := range goldenScenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			base := filepath.Join("testdata", "golden", filepath.FromSlash(sc.name))
+			body, err := os.ReadFile(base + ".request.xml")
+			require.NoError(t, err)
+			header := map[string]string{"Content-Type": "application/xml; charset=utf-8"}
+			if sc.depth != "" {
+				header["Depth"] = sc.depth
+			}
+
+			w := serveAlice(h, sc.method, sc.path, string(body), header)
+			assert.Equal(t, sc.status, w.Code)
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(base+".golden.xml", w.Body.Bytes(), 0o644))
+				return
+			}
+			golden, err := os.ReadFile(base + ".golden.xml")
+			require.NoError(t, err, "run with -update to create it")
+			assert.Equal(t, string(golden), w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// groupMembers returns the members of groupID, or storage.ErrNotFound when it
+// isn't a group or the storage has no groups.
+func (h *CaldavHandler) groupMembers(groupID string) ([]string, error) {
+	groups, ok := storage.As[storage.GroupStorage](h.Storage)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return groups.GetGroupMembers(groupID)
+}
+
+// principalHrefs encodes the principal URLs of userIDs.
+func (h *CaldavHandler) principalHrefs(userIDs []string) ([]string, error) {
+	hrefs := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		href, err := h.URLConverter.EncodePath(Resource{UserID: id, ResourceType: storage.ResourcePrincipal})
+		if err != nil {
+			return nil, err
+		}
+		hrefs = append(hrefs, href)
+	}
+	return hrefs, nil
+}
+
+// visiblePrincipals returns the principals userID can find with a principal
+// search: itself, its groups and their members, without duplicates.
+func (h *CaldavHandler) visiblePrincipals(userID string) ([]string, error) {
+	ids := []string{userID}
+	groups, ok := storage.As[storage.GroupStorage](h.Storage)
+	if !ok || userID == "" {
+		return ids, nil
+	}
+	groupIDs, err := groups.GetUserGroups(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, groupID := range groupIDs {
+		members, err := groups.GetGroupMembers(groupID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		for _, id := range append([]string{groupID}, members...) {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
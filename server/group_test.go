@@ -0,0 +1,145 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// groupStorage has the group "team" with members alice and bob.
+type groupStorage struct {
+	*storage.MockStorage
+	groups map[string][]string
+}
+
+func (s *groupStorage) GetGroupMembers(groupID string) ([]string, error) {
+	members, ok := s.groups[groupID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return members, nil
+}
+
+func (s *groupStorage) GetUserGroups(userID string) ([]string, error) {
+	var groups []string
+	for id, members := range s.groups {
+		for _, m := range members {
+			if m == userID {
+				groups = append(groups, id)
+			}
+		}
+	}
+	return groups, nil
+}
+
+func newGroupTestHandler() (*CaldavHandler, *storage.MockStorage) {
+	mockStorage := &storage.MockStorage{}
+	store := &groupStorage{MockStorage: mockStorage, groups: map[string][]string{"team": {"alice", "bob"}}}
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.Authenticators = []Authenticator{AuthenticatorFunc(func(r *http.Request) (string, error) {
+		return r.Header.Get("X-User"), nil
+	})}
+	mockStorage.On("GetUser", "alice").Return(&storage.User{DisplayName: "Alice Doe", UserAddress: "mailto:alice@example.com"}, nil)
+	mockStorage.On("GetUser", "bob").Return(&storage.User{DisplayName: "Bob Roe"}, nil)
+	mockStorage.On("GetUser", "carol").Return(&storage.User{DisplayName: "Carol Doe"}, nil)
+	mockStorage.On("GetUser", "team").Return(&storage.User{DisplayName: "Platform Team"}, nil)
+	return h, mockStorage
+}
+
+func groupRequest(method, path, user, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("X-User", user)
+	return r
+}
+
+func TestGroupMemberAccess(t *testing.T) {
+	h, _ := newGroupTestHandler()
+	body := `<D:propfind xmlns:D="DAV:"><D:prop><D:displayname/></D:prop></D:propfind>`
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, groupRequest("PROPFIND", "/caldav/team/", "bob", body))
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "Platform Team")
+
+	// Not a member
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, groupRequest("PROPFIND", "/caldav/team/", "carol", body))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Membership doesn't extend to other members' resources
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, groupRequest("PROPFIND", "/caldav/alice/", "bob", body))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestGroupProperties(t *testing.T) {
+	h, _ := newGroupTestHandler()
+	body := `<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop>
+  <D:group-member-set/><D:group-membership/><C:calendar-user-type/>
+</D:prop></D:propfind>`
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, groupRequest("PROPFIND", "/caldav/team/", "alice", body))
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:group-member-set><d:href>/caldav/alice</d:href><d:href>/caldav/bob</d:href></d:group-member-set>")
+	assert.Contains(t, w.Body.String(), "<cal:calendar-user-type>group</cal:calendar-user-type>")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, groupRequest("PROPFIND", "/caldav/alice/", "alice", body))
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:group-membership><d:href>/caldav/team</d:href></d:group-membership>")
+	assert.Contains(t, w.Body.String(), "<cal:calendar-user-type>individual</cal:calendar-user-type>")
+	// Individuals have no member set
+	assert.Contains(t, w.Body.String(), "404 Not Found")
+}
+
+func TestPrincipalPropertySearch(t *testing.T) {
+	h, _ := newGroupTestHandler()
+	search := func(user, body string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, groupRequest("REPORT", "/caldav/", user, body))
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+		return w.Body.String()
+	}
+
+	// bob sees alice through the team, but not carol
+	out := search("bob", `<D:principal-property-search xmlns:D="DAV:">
+  <D:property-search><D:prop><D:displayname/></D:prop><D:match>doe</D:match></D:property-search>
+  <D:prop><D:displayname/></D:prop>
+</D:principal-property-search>`)
+	assert.Contains(t, out, "<d:href>/caldav/alice</d:href>")
+	assert.NotContains(t, out, "Carol")
+	assert.NotContains(t, out, "/caldav/bob<")
+
+	// Groups are principals too
+	out = search("bob", `<D:principal-property-search xmlns:D="DAV:">
+  <D:property-search><D:prop><D:displayname/></D:prop><D:match match-type="starts-with">platform</D:match></D:property-search>
+  <D:prop><D:group-member-set/></D:prop>
+</D:principal-property-search>`)
+	assert.Contains(t, out, "<d:href>/caldav/team</d:href>")
+	assert.Contains(t, out, "<d:group-member-set>")
+
+	// allof needs every search to match
+	out = search("bob", `<D:principal-property-search xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" test="allof">
+  <D:property-search><D:prop><D:displayname/></D:prop><D:match>o</D:match></D:property-search>
+  <D:property-search><D:prop><C:calendar-user-address-set/></D:prop><D:match>example.com</D:match></D:property-search>
+  <D:prop><D:displayname/></D:prop>
+</D:principal-property-search>`)
+	assert.Contains(t, out, "Alice Doe")
+	assert.NotContains(t, out, "Bob Roe")
+}
+
+func TestPrincipalSearchPropertySet(t *testing.T) {
+	h, _ := newGroupTestHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, groupRequest("REPORT", "/caldav/", "alice", `<D:principal-search-property-set xmlns:D="DAV:"/>`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:prop><d:displayname/></d:prop>")
+	assert.Contains(t, w.Body.String(), "<d:prop><cal:calendar-user-address-set/></d:prop>")
+}
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cyp0633/libcaldora/server/recurrence"
 	"github.com/cyp0633/libcaldora/server/storage"
 )
 
@@ -14,8 +16,27 @@ import (
 type RequestContext struct {
 	Resource Resource // Contains UserID, CalendarID, ObjectID, and ResourceType
 	AuthUser string   // Authenticated user (from Basic Auth)
-	Depth    int      // >3 is the same as infinity
-	// Add other relevant context if needed
+	// Anonymous is set when the request carried no credentials and was let
+	// through by CaldavHandler.Anonymous
+	Anonymous bool
+	// Share is the share link the request was authorized by, if any
+	Share *storage.ShareLink
+	// Privileges are those AuthUser holds on the resource owner's home
+	Privileges storage.Privilege
+	Depth      int // >3 is the same as infinity, see DepthInfinity
+
+	// values holds data set by middleware, see SetValue and GetValue
+	values map[any]any
+	// transient is set when the request failed with a transient storage
+	// error, see serveRetrying
+	transient bool
+	// report is the type of REPORT being served, for ResponseSizes
+	report string
+	// retrying is set while serveRetrying may serve the request again;
+	// responseSize then holds the size of the last attempt's multistatus
+	// until it is known to be final
+	retrying     bool
+	responseSize *responseSize
 }
 
 // CaldavHandler is the main HTTP handler for CalDAV requests under a specific prefix.
@@ -24,8 +45,132 @@ type CaldavHandler struct {
 	Realm        string // Realm for Basic Auth
 	Storage      storage.Storage
 	MaxDepth     int // Optional: Max depth for PROPFIND requests, >3 for infinity
+	DefaultDepth int // Optional: Depth of PROPFIND requests without the header, 0 by default. RFC 4918 says DepthInfinity
 	URLConverter URLConverter
 	Logger       *slog.Logger // Logger for structured logging
+	ETagMode     ETagMode     // Optional: which entity tag to emit for objects, defaults to ETagStorage
+	LogBodies    bool         // Optional: log request and response bodies at debug level, with personal data redacted
+	Limits       Limits       // Optional: per-user calendar and object limits, unlimited by default
+	// Optional: leave calendars marked hidden out of Depth:1 home-set
+	// listings, unless the PROPFIND asks for g:hidden
+	HideHiddenCalendars bool
+	NormalizePolicy     NormalizePolicy // Optional: maintain DTSTAMP, LAST-MODIFIED and SEQUENCE on PUT, off by default
+	SkipUnchangedPuts   bool            // Optional: answer a PUT of unchanged content with the stored ETag instead of writing it
+	StrictObjects       bool            // Optional: refuse PUT and bulk bodies that fail storage.CalendarObject.Validate
+	Locker              Locker          // Optional: serialize writes per calendar collection, e.g. NewMemoryLocker()
+	Locks               *LockTable      // Optional: enable WebDAV LOCK/UNLOCK (class 2), e.g. NewLockTable()
+	Anonymous           AnonymousMode   // Optional: how to treat requests without credentials, AnonymousDeny by default
+	AnonymousUser       string          // User ID that AnonymousPrincipal serves requests as
+	Authenticators      []Authenticator // Optional: tried in order before Basic auth, e.g. a CertAuthenticator
+	AuthPolicy          AuthPolicyFunc  // Optional: vary Realm and the three fields above per request, e.g. AuthPolicyByPrefix
+	// Optional: selects requests, besides those through busy-only share
+	// links, that only see events as "Busy" with their times, e.g. sharees
+	// with free/busy permission
+	BusyOnly func(ctx *RequestContext) bool
+	// Optional: zone calendar-query reads floating times in when neither the
+	// query nor the calendar names one, UTC by default
+	DefaultTimezone *time.Location
+	// Optional: receives how time-range filters evaluated each component,
+	// for debugging why an event does or doesn't show in a range
+	RecurrenceStats func(userID, calendarID, uid string, stats recurrence.EvaluationStats)
+	// Optional: engine calendar-query time-ranges evaluate recurring events
+	// with, so its cache is shared across requests, e.g.
+	// recurrence.NewEngine(). Without it nothing is cached
+	Recurrence *recurrence.Engine
+	// Optional: warm Recurrence with the objects PUT writes
+	Warmer *RecurrenceWarmer
+	// Optional: background jobs, stopped by Shutdown. Start them yourself
+	Jobs *JobRunner
+	// Optional: called after every write the handler makes to objects, such
+	// as PUT, DELETE and bulk requests, once the Recurrence cache entries of
+	// the object have been flushed. Use it to invalidate caches of your own
+	OnObjectChange func(change ObjectChange)
+	// Optional: keep free-busy-query results until objects they depend on
+	// change, for schedulers polling availability
+	FreeBusyCache *FreeBusyCache
+	// Optional: selects the calendars free-busy-query on the calendar home
+	// counts, the opaque ones in RFC 6638 terms. All of them by default
+	FreeBusyCalendars func(cal *storage.Calendar) bool
+	// Optional: map displayname of calendar objects to their SUMMARY, readable
+	// and writable by PROPPATCH, for WebDAV file managers that rename events
+	ObjectDisplayNameSummary bool
+	// Optional: indentation, canonical ordering or compaction of XML
+	// responses, written as built by default
+	XMLFormat XMLFormat
+	// Optional: what a PUT creating an object does with a UID the calendar
+	// already holds under another name, needs storage.UIDStorage
+	UIDConflict UIDConflictMode
+	// Optional: names objects clients create without picking a name, e.g.
+	// UIDSlugObjectID. Setting it also lets clients PUT to a calendar
+	// collection to create an object there. UUIDObjectID by default
+	ObjectIDs ObjectIDGenerator
+	// Optional: let DELETE remove calendars that still hold objects, which
+	// it refuses by default
+	ForceCalendarDelete bool
+	// Optional: answer requests for resources the user can't access at all
+	// with 404 instead of 403, so other users can't be probed for
+	HideForbidden bool
+	// Optional: let calendar-multiget name resources outside the collection
+	// it is sent to, each checked for read privilege. Such hrefs get 403 by
+	// default
+	CrossCollectionMultiget bool
+	// Optional: report whether a user administers the server. Depth:1
+	// PROPFINDs by administrators on the service root list every principal
+	// of a storage.UserDirectoryStorage. Nobody is one when nil
+	IsAdmin func(userID string) bool
+	// Optional: leave the component parameter out of the media type of
+	// objects, in GET and DAV:getcontenttype, for clients that mis-parse it
+	OmitContentTypeComponent bool
+	// Optional: plain text or XML error bodies, ErrorFormatText by default
+	ErrorFormat ErrorFormat
+	// Optional: translate error messages per request, e.g. by
+	// Accept-Language. It gets the ErrorCode and the default message and
+	// returns "" to keep it
+	ErrorMessages func(r *http.Request, code ErrorCode, message string) string
+	// Optional: encode hrefs and the well-known redirect for the URL
+	// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix name. Only
+	// set it behind a proxy that overwrites them, and with a URLConverter
+	// implementing ExternalURLConverter, as DefaultURLConverter does
+	TrustForwardedHeaders bool
+	// Optional: write hrefs in XML responses as absolute paths or absolute
+	// URIs, for clients that only handle one of them. As the URLConverter
+	// encodes them by default
+	HrefMode HrefMode
+	// Optional: translate names the server generates, such as "Calendar
+	// Home", into the user's storage.User.Language
+	Translator Translator
+	// Optional: capabilities to serve, see Features. All of them when nil
+	Features *Features
+	// Optional: retry reads failing with transient storage errors, and the
+	// Retry-After sent with 503 responses. No retries by default
+	Retry RetryPolicy
+	// Optional: bound the REPORTs and Depth:1 PROPFINDs each principal runs
+	// at once, e.g. NewConcurrencyLimiter(4, time.Second)
+	Concurrency *ConcurrencyLimiter
+	// Optional: count DAV:response elements and bytes of multistatus
+	// responses per report type and Depth, e.g. NewResponseSizes(1000, 0)
+	ResponseSizes *ResponseSizes
+	// Optional: checks scheduling messages POSTed to outboxes, needs
+	// storage.ScheduleInboxStorage. Without it every party of the event
+	// that is a local user receives them
+	ScheduleValidator *ScheduleValidator
+	// Optional: answers retried outbox POSTs without delivering the message
+	// to the same recipients again, e.g.
+	// NewScheduleReplayGuard(NewMemoryReplayStore())
+	ScheduleReplayGuard *ScheduleReplayGuard
+	// Optional: settings that replace the fields of the same name for each
+	// request, and can be changed with Config.Update while serving. Its
+	// values override those fields when set directly on the handler, so
+	// seed it with NewConfig(h.Settings()) after configuring them
+	Config *Config
+	// objectView is the response transformer bound per request, see view
+	objectView func(obj *storage.CalendarObject) *storage.CalendarObject
+	// hrefOrigin is the scheme and host HrefAbsoluteURI writes hrefs on,
+	// bound per request
+	hrefOrigin  string
+	middlewares []Middleware // Registered with Use
+	// lifecycle tracks requests in flight for Shutdown
+	lifecycle *lifecycle
 	// TODO: Add backend interface dependency here later
 }
 
@@ -51,6 +196,7 @@ func NewCaldavHandler(prefix, realm string, storage storage.Storage, maxDepth in
 		MaxDepth:     maxDepth,
 		URLConverter: converter,
 		Logger:       logger,
+		lifecycle:    &lifecycle{},
 	}
 }
 
@@ -60,31 +206,55 @@ func (h *CaldavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"method", r.Method,
 		"path", r.URL.Path,
 	)
+	if !h.lifecycle.enter() {
+		h.refuseShuttingDown(w, r)
+		return
+	}
+	defer h.lifecycle.leave()
 
-	// 1. Basic Authentication Check
-	userID, ok := h.checkAuth(w, r)
+	// 1. Authentication: a share link token, or Authenticators and Basic auth
+	h = h.withConfig().withAuthPolicy(r).withForwardedBase(r).withHrefOrigin(r)
+	share, ok := h.checkShareLink(w, r)
 	if !ok {
-		// checkAuth already sent the 401 response
 		return
 	}
+	// Share link requests run as no principal, see requestPrivileges
+	var userID string
+	var anonymous bool
+	if share == nil {
+		if userID, anonymous, ok = h.authenticate(w, r); !ok {
+			// authenticate already sent the 401 response
+			return
+		}
+	}
 
 	h.Logger.Info("authenticated user", "userID", userID)
 
 	// 2. Path Parsing - now handled directly by the URL converter
-	resource, err := h.URLConverter.ParsePath(r.URL.Path)
+	resource, err := h.URLConverter.ParsePath(r.URL.EscapedPath())
 	if err != nil {
 		h.Logger.Error("error parsing path",
 			"path", r.URL.Path,
 			"error", err,
 		)
-		http.Error(w, err.Error(), http.StatusNotFound) // Or BadRequest depending on error
+		h.writeError(w, r, http.StatusNotFound, CodeNotFound)
+		return
+	}
+
+	if share != nil && !shareAllows(share, r.Method, resource) {
+		h.Logger.Warn("request outside share link scope",
+			"method", r.Method,
+			"path", r.URL.Path)
+		h.denyAccess(w, r)
 		return
 	}
 
 	// Create request context with the parsed resource
 	ctx := &RequestContext{
-		Resource: resource,
-		AuthUser: userID, // Use the user ID directly
+		Resource:  resource,
+		AuthUser:  userID, // Use the user ID directly
+		Anonymous: anonymous,
+		Share:     share,
 	}
 
 	h.Logger.Info("parsed path",
@@ -94,42 +264,108 @@ func (h *CaldavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"object_id", ctx.Resource.ObjectID,
 	)
 
-	// 3. --- TODO: User Access Control Check ---
-	// After identifying the resource and the authenticated user (ctx.AuthUser),
-	// check if ctx.AuthUser is allowed to access the resource identified by
-	// ctx.UserID, ctx.CalendarID etc. For example, normally ctx.AuthUser must
-	// be equal to ctx.UserID unless delegation or public calendars are involved.
-	if ctx.Resource.UserID != "" && ctx.Resource.UserID != ctx.AuthUser {
-		h.Logger.Warn("access control not implemented",
+	// 3. Access control: users reach their own resources, those of the
+	// groups they belong to, and what other users delegated to them
+	privs, err := h.requestPrivileges(ctx, ctx.Resource.UserID)
+	if err != nil {
+		h.Logger.Error("failed to check access",
+			"auth_user", ctx.AuthUser,
+			"user_id", ctx.Resource.UserID,
+			"error", err,
+		)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if privs == 0 {
+		h.Logger.Warn("access denied",
 			"auth_user", ctx.AuthUser,
 			"user_id", ctx.Resource.UserID,
 		)
-		// For now, let's assume users can only access their own resources
-		http.Error(w, "Forbidden: Access denied to the requested resource", http.StatusForbidden)
+		h.denyAccess(w, r)
 		return
 	}
-	// --- End TODO ---
-
-	depth := r.Header.Get("Depth")
-	if depth == "" {
-		ctx.Depth = 0 // Default depth
-	} else if depth == "infinity" {
-		ctx.Depth = 114514
-	} else {
-		// Parse depth as integer, default to 0 if invalid
-		var err error
-		ctx.Depth, err = strconv.Atoi(depth)
-		if err != nil {
-			h.Logger.Warn("invalid depth header",
-				"value", depth,
-				"default", 0,
-			)
-			ctx.Depth = 0
+	if need := requiredPrivilege(r.Method, ctx.Resource); !privs.Has(need) {
+		h.Logger.Warn("missing privilege",
+			"auth_user", ctx.AuthUser,
+			"user_id", ctx.Resource.UserID,
+			"privilege", privilegeNames[need],
+		)
+		h.writePrecondition(w, r, http.StatusForbidden, "d:need-privileges", privilegeNames[need]+" privilege required")
+		return
+	}
+	ctx.Privileges = privs
+
+	if ctx.Depth, err = h.requestDepth(r); err != nil {
+		h.Logger.Warn("invalid depth header",
+			"method", r.Method,
+			"value", r.Header.Get("Depth"),
+			"error", err)
+		h.writeErrorDetail(w, r, http.StatusBadRequest, CodeInvalidDepth, err.Error())
+		return
+	}
+
+	if h.LogBodies {
+		var finish func()
+		w, finish = h.logBodies(w, r)
+		defer finish()
+	}
+
+	release, ok := h.limitConcurrency(w, r, ctx)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// 4. Run middleware registered with Use, then route by method
+	r = r.WithContext(context.WithValue(r.Context(), requestContextKey{}, ctx))
+	if h.Retry.Attempts > 0 && retryable(r.Method) {
+		h.serveRetrying(w, r, ctx)
+		return
+	}
+	h.chain().ServeHTTP(w, r)
+}
+
+// dispatch routes a request by HTTP method (CalDAV methods). It is the
+// innermost handler of the middleware chain.
+func (h *CaldavHandler) dispatch(w http.ResponseWriter, r *http.Request) {
+	ctx, ok := RequestContextFrom(r.Context())
+	if !ok {
+		h.Logger.Error("request context missing; middleware must pass on the request it received")
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+
+	// Bind request-scoped storage on a copy so concurrent requests don't share it
+	if contextual, ok := storage.As[storage.ContextualStorage](h.Storage); ok {
+		bound := *h
+		bound.Storage = contextual.WithContext(r.Context())
+		h = &bound
+	}
+
+	if h.isNotificationResource(ctx.Resource) {
+		h.handleNotifications(w, r, ctx)
+		return
+	}
+	if h.isScheduleInbox(ctx.Resource) {
+		h.handleScheduleInbox(w, r, ctx)
+		return
+	}
+	if h.isScheduleOutbox(ctx.Resource) {
+		h.handleScheduleOutbox(w, r, ctx)
+		return
+	}
+
+	// Busy-only requests get redacted objects and may not write
+	if h.busyOnly(ctx) {
+		if !safeMethods[r.Method] {
+			h.writeError(w, r, http.StatusForbidden, CodeForbidden)
+			return
 		}
-		ctx.Depth = min(ctx.Depth, h.MaxDepth)
+		bound := *h
+		bound.objectView = busyView
+		h = &bound
 	}
 
-	// 4. Routing based on HTTP Method (CalDAV methods)
 	switch r.Method {
 	case "PROPFIND":
 		h.handlePropfind(w, r, ctx)
@@ -143,14 +379,19 @@ func (h *CaldavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleDelete(w, r, ctx)
 	case "MKCOL", "MKCALENDAR": // MKCALENDAR is often used instead of MKCOL for calendars
 		h.handleMkCalendar(w, r, ctx)
+	case "POST":
+		h.handlePost(w, r, ctx)
+	case "PROPPATCH":
+		h.handleProppatch(w, r, ctx)
 	case "OPTIONS":
 		h.handleOptions(w, r, ctx)
+	case "LOCK":
+		h.handleLock(w, r, ctx)
+	case "UNLOCK":
+		h.handleUnlock(w, r, ctx)
 	// Add other CalDAV methods like COPY, MOVE if needed
 	default:
-		h.Logger.Error("method not allowed",
-			"method", r.Method,
-		)
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
 	}
 }
 
@@ -165,9 +406,8 @@ func (h *CaldavHandler) handleOptions(w http.ResponseWriter, _ *http.Request, ct
 		"calendar_id", ctx.Resource.CalendarID,
 		"object_id", ctx.Resource.ObjectID,
 	)
-	// TODO: Set correct Allow and DAV headers based on ctx.Resource.ResourceType and capabilities
-	w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, PUT, DELETE, MKCALENDAR") // Example, tailor this
-	w.Header().Set("DAV", "1, 3, calendar-access")                                     // Example CalDAV capabilities
+	w.Header().Set("Allow", h.allowedMethods(ctx.Resource))
+	w.Header().Set("DAV", h.davCompliance())
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -188,9 +428,11 @@ func main() {
 }
 */
 
-// ServeWellKnown handles requests to the well-known CalDAV URL.
+// ServeWellKnown handles requests to the well-known CalDAV URL. It redirects
+// to the service root at the URLConverter's external URL, see
+// ExternalURLConverter, or at the host of the request.
 func (h *CaldavHandler) ServeWellKnown(w http.ResponseWriter, r *http.Request) {
-	redirectURL := "//" + r.Host + h.Prefix
+	redirectURL := h.withConfig().withForwardedBase(r).serviceRootURL(r)
 
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
@@ -198,7 +440,7 @@ func (h *CaldavHandler) ServeWellKnown(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMovedPermanently)
 	case http.MethodOptions:
 		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
-		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("DAV", h.davCompliance())
 		w.WriteHeader(http.StatusOK)
 	default:
 		w.Header().Set("Location", redirectURL)
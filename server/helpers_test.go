@@ -0,0 +1,38 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// newTestHandler returns a handler serving /caldav/ from a MockStorage on
+// which alice logs in with "password" and owns one calendar, work, holding
+// a.ics and b.ics. GetCalendar returns calendar for it. Tests add the stubs
+// and handler fields they need on top; stubs added later don't override
+// these.
+func newTestHandler(calendar *storage.Calendar) (*CaldavHandler, *storage.MockStorage) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil).Maybe()
+	mockStorage.On("GetCalendar", "alice", "work").Return(calendar, nil).Maybe()
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work"}}, nil).Maybe()
+	mockStorage.On("GetObjectPathsInCollection", "work").Return([]string{"/alice/cal/work/a.ics", "/alice/cal/work/b.ics"}, nil).Maybe()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, logger), mockStorage
+}
+
+// serveAlice serves a request with alice's Basic credentials and the given
+// headers.
+func serveAlice(h *CaldavHandler, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.SetBasicAuth("alice", "password")
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mutableCalendarStorage records calendar updates on top of MockStorage.
+type mutableCalendarStorage struct {
+	*storage.MockStorage
+}
+
+func (s *mutableCalendarStorage) UpdateCalendar(userID, calendarID string, cal *storage.Calendar) error {
+	return s.Called(userID, calendarID, cal).Error(0)
+}
+
+func TestProppatchHidden(t *testing.T) {
+	store := &mutableCalendarStorage{MockStorage: &storage.MockStorage{}}
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work"}, nil)
+	store.On("UpdateCalendar", "alice", "work", mock.MatchedBy(func(cal *storage.Calendar) bool { return cal.Hidden })).Return(nil).Once()
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, AuthUser: "alice"}
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:G="http://schemas.google.com/gCal/2005"><D:set><D:prop><G:hidden>true</G:hidden></D:prop></D:set></D:propertyupdate>`
+	w := httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work", strings.NewReader(body)), ctx)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<g:hidden/></d:prop><d:status>HTTP/1.1 200 OK</d:status>")
+	store.AssertExpectations(t)
+
+	// Backends that can't update calendars refuse the change
+	h.Storage = store.MockStorage
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work", strings.NewReader(body)), ctx)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+}
+
+func TestHideHiddenCalendars(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{
+		{Path: "/alice/cal/work"},
+		{Path: "/alice/cal/birthdays", Hidden: true},
+	}, nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work"}, nil)
+	mockStorage.On("GetCalendar", "alice", "birthdays").Return(&storage.Calendar{Path: "/alice/cal/birthdays", Hidden: true}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.HideHiddenCalendars = true
+	header := map[string]string{"Depth": "1"}
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal", body, header)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "/caldav/alice/cal/work")
+	assert.NotContains(t, w.Body.String(), "birthdays")
+
+	// Asking for g:hidden lists everything, flagged
+	body = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:G="http://schemas.google.com/gCal/2005"><D:prop><G:hidden/></D:prop></D:propfind>`
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal", body, header)
+	assert.Contains(t, w.Body.String(), "/caldav/alice/cal/birthdays")
+	assert.Contains(t, w.Body.String(), "<g:hidden>true</g:hidden>")
+	assert.Contains(t, w.Body.String(), "<g:hidden>false</g:hidden>")
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+)
+
+// HrefMode selects how hrefs in XML responses are written.
+type HrefMode int
+
+const (
+	// HrefAsEncoded writes hrefs as the URLConverter encodes them, absolute
+	// paths with DefaultURLConverter. This is the default.
+	HrefAsEncoded HrefMode = iota
+	// HrefPath writes absolute paths, "/caldav/alice/cal/", dropping the
+	// scheme and host of hrefs a URLConverter encodes as absolute URIs.
+	HrefPath
+	// HrefAbsoluteURI writes absolute URIs,
+	// "https://cal.example.com/caldav/alice/cal/", on the scheme and host of
+	// the URLConverter's external URL or of the request.
+	HrefAbsoluteURI
+)
+
+// withHrefOrigin returns h with the scheme and host HrefAbsoluteURI writes
+// hrefs on bound for r.
+func (h *CaldavHandler) withHrefOrigin(r *http.Request) *CaldavHandler {
+	if h.HrefMode != HrefAbsoluteURI {
+		return h
+	}
+	bound := *h
+	bound.hrefOrigin = h.externalOrigin()
+	if bound.hrefOrigin == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		bound.hrefOrigin = scheme + "://" + r.Host
+	}
+	return &bound
+}
+
+// externalOrigin returns the scheme and host of the URLConverter's external
+// URL, see ExternalURLConverter, or "" without one.
+func (h *CaldavHandler) externalOrigin() string {
+	converter, ok := h.URLConverter.(ExternalURLConverter)
+	if !ok {
+		return ""
+	}
+	u, err := url.Parse(converter.ExternalBase())
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
+
+// rewriteHrefs writes the DAV:href elements under root according to
+// h.HrefMode. Hrefs within properties of namespaces the server doesn't know,
+// such as dead properties, are left as clients stored them.
+func (h *CaldavHandler) rewriteHrefs(root *etree.Element) {
+	if h.HrefMode == HrefAsEncoded {
+		return
+	}
+	known := map[string]bool{}
+	for _, ns := range props.NamespaceMap {
+		known[ns] = true
+	}
+	var walk func(e *etree.Element)
+	walk = func(e *etree.Element) {
+		if !known[e.NamespaceURI()] {
+			return
+		}
+		if e.Tag == "href" && e.NamespaceURI() == "DAV:" {
+			e.SetText(h.formatHref(e.Text()))
+			return
+		}
+		for _, child := range e.ChildElements() {
+			walk(child)
+		}
+	}
+	walk(root)
+}
+
+// formatHref returns href as h.HrefMode writes it.
+func (h *CaldavHandler) formatHref(href string) string {
+	href = strings.TrimSpace(href)
+	switch h.HrefMode {
+	case HrefPath:
+		if _, rest, ok := strings.Cut(href, "://"); ok {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				return rest[i:]
+			}
+			return "/"
+		}
+	case HrefAbsoluteURI:
+		if strings.HasPrefix(href, "/") && h.hrefOrigin != "" {
+			return h.hrefOrigin + href
+		}
+	}
+	return href
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// absoluteConverter encodes hrefs as absolute URIs.
+type absoluteConverter struct {
+	DefaultURLConverter
+}
+
+func (c *absoluteConverter) EncodePath(res Resource) (string, error) {
+	path, err := c.DefaultURLConverter.EncodePath(res)
+	return "https://cal.example.com" + path, err
+}
+
+func TestHrefMode(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetUser", "alice").Return(&storage.User{}, nil).Maybe()
+	body := `<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><C:calendar-home-set/></D:prop></D:propfind>`
+
+	h.HrefMode = HrefAbsoluteURI
+	w := serveAlice(h, "PROPFIND", "/caldav/alice", body, nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>http://example.com/caldav/alice</d:href>")
+	assert.Contains(t, w.Body.String(), "<d:href>http://example.com/caldav/alice/cal</d:href>")
+
+	// On the external URL rather than the host the proxy sent the request to
+	h.URLConverter = &DefaultURLConverter{Prefix: "/caldav/", ExternalURL: "https://cal.example.com/dav/"}
+	w = serveAlice(h, "PROPFIND", "/caldav/alice", body, nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>https://cal.example.com/dav/alice/cal</d:href>")
+
+	h.URLConverter = &absoluteConverter{DefaultURLConverter{Prefix: "/caldav/"}}
+	h.HrefMode = HrefAsEncoded
+	w = serveAlice(h, "PROPFIND", "/caldav/alice", body, nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>https://cal.example.com/caldav/alice/cal</d:href>")
+
+	h.HrefMode = HrefPath
+	w = serveAlice(h, "PROPFIND", "/caldav/alice", body, nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice</d:href>")
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal</d:href>")
+}
+
+func TestRewriteHrefsSkipsDeadProperties(t *testing.T) {
+	h := &CaldavHandler{HrefMode: HrefAbsoluteURI, hrefOrigin: "https://cal.example.com"}
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<d:multistatus xmlns:d="DAV:" xmlns:x="urn:example"><d:response>`+
+		`<d:href>/caldav/alice/cal/work/</d:href><d:propstat><d:prop>`+
+		`<x:related><d:href>/elsewhere</d:href></x:related>`+
+		`</d:prop></d:propstat></d:response></d:multistatus>`))
+	out, err := h.writeXML(doc)
+	require.NoError(t, err)
+	assert.Contains(t, out, "<d:href>https://cal.example.com/caldav/alice/cal/work/</d:href>")
+	assert.Contains(t, out, "<d:href>/elsewhere</d:href>")
+}
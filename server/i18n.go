@@ -0,0 +1,46 @@
+package server
+
+import (
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/emersion/go-ical"
+)
+
+// Keys of the names the server generates, passed to Translator.
+const (
+	NameCalendarHome = "calendar-home" // displayname of calendar home sets
+	NameServiceRoot  = "service-root"  // displayname of the service root
+)
+
+// Translator translates a name the server generates into lang, the
+// storage.User.Language of the user it's shown to, or "" when they have none.
+// key is one of the Name constants and text the English name. It returns ""
+// to keep the English name.
+type Translator func(lang, key, text string) string
+
+// generatedName returns the displayname for the server-generated name key,
+// translated for the resource owner when Translator is set.
+func (e *propEnv) generatedName(key, text string) *props.DisplayName {
+	if e.h.Translator == nil {
+		return &props.DisplayName{Value: text}
+	}
+	var lang string
+	if e.res.UserID != "" {
+		if user, err := e.GetUser(); err == nil && user != nil {
+			lang = user.Language
+		}
+	}
+	if translated := e.h.Translator(lang, key, text); translated != "" {
+		return &props.DisplayName{Value: translated, Lang: lang}
+	}
+	return &props.DisplayName{Value: text}
+}
+
+// textDisplayName returns the displayname for a text property, tagged with its
+// LANGUAGE parameter (RFC 5545 section 3.2.10).
+func textDisplayName(prop *ical.Prop) (*props.DisplayName, error) {
+	value, err := prop.Text()
+	if err != nil {
+		return nil, err
+	}
+	return &props.DisplayName{Value: value, Lang: prop.Params.Get(ical.ParamLanguage)}, nil
+}
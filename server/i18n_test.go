@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisplayNameLanguage(t *testing.T) {
+	calData := ical.NewCalendar()
+	calData.Props.SetText(ical.PropName, "Travail")
+	calData.Props.Get(ical.PropName).Params.Set(ical.ParamLanguage, "fr")
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetUser", "alice").Return(&storage.User{DisplayName: "Alice", Language: "de"}, nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: calData}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	body := `<d:propfind xmlns:d="DAV:"><d:prop><d:displayname/></d:prop></d:propfind>`
+	header := map[string]string{"Depth": "0"}
+
+	// Without a Translator generated names stay English and untagged
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal", body, header)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:displayname>Calendar Home</d:displayname>")
+
+	h.Translator = func(lang, key, text string) string {
+		if lang == "de" && key == NameCalendarHome {
+			return "Kalender"
+		}
+		return ""
+	}
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal", body, header)
+	assert.Contains(t, w.Body.String(), `<d:displayname xml:lang="de">Kalender</d:displayname>`)
+
+	// Untranslated names keep the English text
+	w = serveAlice(h, "PROPFIND", "/caldav/", body, header)
+	assert.Contains(t, w.Body.String(), "<d:displayname>CalDAV Service Root</d:displayname>")
+
+	w = serveAlice(h, "PROPFIND", "/caldav/alice", body, header)
+	assert.Contains(t, w.Body.String(), `<d:displayname xml:lang="de">Alice</d:displayname>`)
+
+	// Calendar names carry their LANGUAGE parameter
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, header)
+	assert.Contains(t, w.Body.String(), `<d:displayname xml:lang="fr">Travail</d:displayname>`)
+}
@@ -0,0 +1,227 @@
+// Package icsdiff compares calendar objects semantically. Two objects are
+// equal when they hold the same components with the same properties, no
+// matter the order of components, properties or parameters, or how lines
+// were folded.
+package icsdiff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-ical"
+)
+
+// Kind tells how a component changed.
+type Kind int
+
+const (
+	// Added components exist only in the new object.
+	Added Kind = iota
+	// Removed components exist only in the old object.
+	Removed
+	// Modified components exist in both objects with different content.
+	Modified
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Change is one difference between two versions of an object.
+type Change struct {
+	Kind Kind
+	// Component identifies the component, e.g. "VEVENT uid-1" or
+	// "VEVENT uid-1 20250101T090000Z" for an overridden instance.
+	Component string
+	// Property names the changed property for Modified changes. Nested
+	// components such as VALARM are compared as a whole and reported under
+	// their component name.
+	Property string
+	// Old and New hold the canonical values of Property on either side.
+	Old, New []string
+}
+
+func (c Change) String() string {
+	if c.Kind != Modified {
+		return c.Kind.String() + " " + c.Component
+	}
+	return fmt.Sprintf("modified %s %s: %q -> %q", c.Component, c.Property, c.Old, c.New)
+}
+
+// Diff returns the changes that turn old into new. Components are matched by
+// name, UID and RECURRENCE-ID (TZID for VTIMEZONE). The result is sorted by
+// component and property.
+func Diff(old, new []*ical.Component) []Change {
+	oldByKey, newByKey := byKey(old), byKey(new)
+
+	var changes []Change
+	for key, o := range oldByKey {
+		n, ok := newByKey[key]
+		if !ok {
+			changes = append(changes, Change{Kind: Removed, Component: key})
+			continue
+		}
+		changes = append(changes, diffComponent(key, o, n)...)
+	}
+	for key := range newByKey {
+		if _, ok := oldByKey[key]; !ok {
+			changes = append(changes, Change{Kind: Added, Component: key})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Component != changes[j].Component {
+			return changes[i].Component < changes[j].Component
+		}
+		return changes[i].Property < changes[j].Property
+	})
+	return changes
+}
+
+// Equal reports whether old and new hold the same content.
+func Equal(old, new []*ical.Component) bool {
+	return len(Diff(old, new)) == 0
+}
+
+// PropertyEqual reports whether a and b carry the same values, with the same
+// parameters, for the property name.
+func PropertyEqual(a, b *ical.Component, name string) bool {
+	return equalStrings(propValues(a, name), propValues(b, name))
+}
+
+func diffComponent(key string, o, n *ical.Component) []Change {
+	names := map[string]bool{}
+	for name := range o.Props {
+		names[name] = true
+	}
+	for name := range n.Props {
+		names[name] = true
+	}
+
+	var changes []Change
+	for name := range names {
+		ov, nv := propValues(o, name), propValues(n, name)
+		if !equalStrings(ov, nv) {
+			changes = append(changes, Change{Kind: Modified, Component: key, Property: name, Old: ov, New: nv})
+		}
+	}
+
+	oldChildren, newChildren := childSignatures(o), childSignatures(n)
+	for name := range newChildren {
+		if _, ok := oldChildren[name]; !ok {
+			oldChildren[name] = nil
+		}
+	}
+	for name, ov := range oldChildren {
+		if nv := newChildren[name]; !equalStrings(ov, nv) {
+			changes = append(changes, Change{Kind: Modified, Component: key, Property: name, Old: ov, New: nv})
+		}
+	}
+	return changes
+}
+
+// byKey indexes components by identity. Components sharing an identity get a
+// numbered suffix in their original order.
+func byKey(comps []*ical.Component) map[string]*ical.Component {
+	m := make(map[string]*ical.Component, len(comps))
+	for _, comp := range comps {
+		if comp == nil {
+			continue
+		}
+		key := componentKey(comp)
+		for i := 2; m[key] != nil; i++ {
+			key = fmt.Sprintf("%s #%d", componentKey(comp), i)
+		}
+		m[key] = comp
+	}
+	return m
+}
+
+func componentKey(comp *ical.Component) string {
+	if comp.Name == ical.CompTimezone {
+		tzid, _ := comp.Props.Text(ical.PropTimezoneID)
+		return comp.Name + " " + tzid
+	}
+	key := comp.Name
+	if uid, _ := comp.Props.Text(ical.PropUID); uid != "" {
+		key += " " + uid
+	}
+	if rid := comp.Props.Get(ical.PropRecurrenceID); rid != nil {
+		key += " " + rid.Value
+	}
+	return key
+}
+
+// propValues renders every value of a property with its parameters, sorted.
+func propValues(comp *ical.Component, name string) []string {
+	props := comp.Props.Values(name)
+	values := make([]string, 0, len(props))
+	for _, prop := range props {
+		values = append(values, propString(prop))
+	}
+	sort.Strings(values)
+	return values
+}
+
+func propString(prop ical.Prop) string {
+	params := make([]string, 0, len(prop.Params))
+	for k, v := range prop.Params {
+		vals := append([]string{}, v...)
+		sort.Strings(vals)
+		params = append(params, strings.ToUpper(k)+"="+strings.Join(vals, ","))
+	}
+	sort.Strings(params)
+	if len(params) == 0 {
+		return prop.Value
+	}
+	return strings.Join(params, ";") + ":" + prop.Value
+}
+
+// childSignatures renders nested components grouped by name, so that a
+// change to one VALARM shows up as a change of the VALARM set.
+func childSignatures(comp *ical.Component) map[string][]string {
+	m := map[string][]string{}
+	for _, child := range comp.Children {
+		m[child.Name] = append(m[child.Name], signature(child))
+	}
+	for _, sigs := range m {
+		sort.Strings(sigs)
+	}
+	return m
+}
+
+func signature(comp *ical.Component) string {
+	var lines []string
+	for name := range comp.Props {
+		for _, v := range propValues(comp, name) {
+			lines = append(lines, name+";"+v)
+		}
+	}
+	for name, sigs := range childSignatures(comp) {
+		for _, sig := range sigs {
+			lines = append(lines, "BEGIN:"+name+"\n"+sig+"\nEND:"+name)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package icsdiff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, body string) []*ical.Component {
+	t.Helper()
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" + strings.ReplaceAll(body, "\n", "\r\n") + "END:VCALENDAR\r\n"
+	cal, err := ical.NewDecoder(strings.NewReader(ics)).Decode()
+	require.NoError(t, err)
+	return cal.Children
+}
+
+const base = `BEGIN:VEVENT
+UID:standup
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Berlin:20250106T090000
+SUMMARY:Daily standup with the whole team
+ATTENDEE;ROLE=REQ-PARTICIPANT;CN=Bob:mailto:bob@example.com
+ATTENDEE;CN=Carol:mailto:carol@example.com
+BEGIN:VALARM
+ACTION:DISPLAY
+TRIGGER:-PT10M
+END:VALARM
+END:VEVENT
+`
+
+func TestEqualIgnoresLayout(t *testing.T) {
+	reordered := `BEGIN:VEVENT
+ATTENDEE;CN=Carol:mailto:carol@example.com
+SUMMARY:Daily standup with
+  the whole team
+ATTENDEE;CN=Bob;ROLE=REQ-PARTICIPANT:mailto:bob@example.com
+DTSTART;TZID=Europe/Berlin:20250106T090000
+UID:standup
+BEGIN:VALARM
+TRIGGER:-PT10M
+ACTION:DISPLAY
+END:VALARM
+DTSTAMP:20250101T000000Z
+END:VEVENT
+`
+	assert.True(t, Equal(decode(t, base), decode(t, reordered)))
+	assert.Empty(t, Diff(decode(t, base), decode(t, base)))
+}
+
+func TestDiff(t *testing.T) {
+	moved := strings.Replace(base, "20250106T090000", "20250106T100000", 1)
+	moved = strings.Replace(moved, "-PT10M", "-PT5M", 1)
+	moved += `BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID;TZID=Europe/Berlin:20250107T090000
+DTSTAMP:20250101T000000Z
+DTSTART;TZID=Europe/Berlin:20250107T110000
+END:VEVENT
+`
+	changes := Diff(decode(t, base), decode(t, moved))
+	require.Len(t, changes, 3)
+
+	assert.Equal(t, Modified, changes[0].Kind)
+	assert.Equal(t, "VEVENT standup", changes[0].Component)
+	assert.Equal(t, "DTSTART", changes[0].Property)
+	assert.Equal(t, []string{"TZID=Europe/Berlin:20250106T090000"}, changes[0].Old)
+	assert.Equal(t, []string{"TZID=Europe/Berlin:20250106T100000"}, changes[0].New)
+
+	assert.Equal(t, "VALARM", changes[1].Property)
+
+	assert.Equal(t, Added, changes[2].Kind)
+	assert.Equal(t, "VEVENT standup 20250107T090000", changes[2].Component)
+	assert.Equal(t, "added VEVENT standup 20250107T090000", changes[2].String())
+
+	// And back again
+	changes = Diff(decode(t, moved), decode(t, base))
+	assert.Equal(t, Removed, changes[2].Kind)
+}
+
+func TestPropertyEqual(t *testing.T) {
+	a, b := decode(t, base)[0], decode(t, base)[0]
+	b.Props.SetText(ical.PropSummary, "Standup")
+	assert.True(t, PropertyEqual(a, b, ical.PropDateTimeStart))
+	assert.False(t, PropertyEqual(a, b, ical.PropSummary))
+	assert.True(t, PropertyEqual(a, b, ical.PropLocation))
+}
+
+func TestDiffXProperties(t *testing.T) {
+	located := strings.Replace(base, "UID:standup\n", "UID:standup\n"+
+		"X-APPLE-STRUCTURED-LOCATION;VALUE=URI;X-TITLE=Room 1;X-APPLE-RADIUS=20:geo:52.5,13.4\n", 1)
+	reordered := strings.Replace(base, "UID:standup\n", "UID:standup\n"+
+		"X-APPLE-STRUCTURED-LOCATION;X-APPLE-RADIUS=20;X-TITLE=Room 1;VALUE=URI:geo:52.5,13.4\n", 1)
+	moved := strings.Replace(located, "X-TITLE=Room 1", "X-TITLE=Room 2", 1)
+
+	assert.True(t, Equal(decode(t, located), decode(t, reordered)))
+	changes := Diff(decode(t, located), decode(t, moved))
+	require.Len(t, changes, 1)
+	assert.Equal(t, "X-APPLE-STRUCTURED-LOCATION", changes[0].Property, "parameters of X- properties count")
+	assert.Len(t, Diff(decode(t, base), decode(t, located)), 1)
+}
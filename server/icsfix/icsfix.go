@@ -0,0 +1,274 @@
+// Package icsfix reads iCalendar data written by broken producers, repairing
+// common mistakes so objects imported from old data stay servable:
+//
+//   - lines without a colon are dropped, components left open are closed
+//   - unescaped commas and semicolons in text are escaped, and backslashes
+//     before characters that needn't be escaped are removed
+//   - dates written as 2006-01-02 or 2006-01-02T15:04:05Z are rewritten in
+//     the basic format
+//   - dates missing VALUE=DATE get it
+//   - duplicates of properties that may occur once in an event or to-do are
+//     dropped, keeping the first
+//
+// Every repair is reported, so callers can log them or rewrite the stored
+// data.
+package icsfix
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-ical"
+)
+
+// Repair describes one change made to the data.
+type Repair struct {
+	// Line is the line number the repair was made at, for repairs made
+	// before decoding, 0 otherwise
+	Line int
+	// Component and Property name what was repaired, when known
+	Component string
+	Property  string
+	// Description says what was done, e.g. "added VALUE=DATE"
+	Description string
+}
+
+func (r Repair) String() string {
+	var where []string
+	if r.Line > 0 {
+		where = append(where, fmt.Sprintf("line %d", r.Line))
+	}
+	if r.Component != "" {
+		where = append(where, r.Component)
+	}
+	if r.Property != "" {
+		where = append(where, r.Property)
+	}
+	if len(where) == 0 {
+		return r.Description
+	}
+	return strings.Join(where, " ") + ": " + r.Description
+}
+
+// Decode parses data as an iCalendar stream, repairing it as the package
+// documentation describes. It fails only on data that can't be repaired,
+// such as data without a VCALENDAR.
+func Decode(data string) (*ical.Calendar, []Repair, error) {
+	data, repairs := repairLines(data)
+	cal, err := ical.NewDecoder(strings.NewReader(data)).Decode()
+	if err != nil {
+		return nil, repairs, err
+	}
+	repairs = append(repairs, RepairComponent(cal.Component)...)
+	return cal, repairs, nil
+}
+
+// repairLines drops lines go-ical can't decode and closes components left
+// open, returning the data with CRLF line endings.
+func repairLines(data string) (string, []Repair) {
+	var repairs []Repair
+	var out []string
+	var open []string
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := lines[i]
+		// Unfold continuation lines to check the whole content line
+		for i+1 < len(lines) && (strings.HasPrefix(lines[i+1], " ") || strings.HasPrefix(lines[i+1], "\t")) {
+			i++
+			line += lines[i][1:]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			repairs = append(repairs, Repair{Line: number, Description: "dropped line without a colon"})
+			continue
+		}
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			open = append(open, strings.ToUpper(value))
+		case "END":
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		}
+		out = append(out, line)
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		repairs = append(repairs, Repair{Component: open[i], Description: "closed component left open"})
+		out = append(out, "END:"+open[i])
+	}
+	return strings.Join(out, "\r\n") + "\r\n", repairs
+}
+
+// RepairComponent repairs the properties of comp and its children in place,
+// for data decoded elsewhere.
+func RepairComponent(comp *ical.Component) []Repair {
+	var repairs []Repair
+	report := func(prop, description string) {
+		repairs = append(repairs, Repair{Component: comp.Name, Property: prop, Description: description})
+	}
+
+	// Repair in a fixed order, so reports are stable
+	names := slices.Sorted(maps.Keys(comp.Props))
+	if comp.Name == ical.CompEvent || comp.Name == ical.CompToDo {
+		for _, name := range names {
+			if props := comp.Props[name]; singleProps[name] && len(props) > 1 {
+				comp.Props[name] = props[:1]
+				report(name, fmt.Sprintf("dropped %d duplicates", len(props)-1))
+			}
+		}
+	}
+	for _, name := range names {
+		props := comp.Props[name]
+		for i := range props {
+			prop := &props[i]
+			if textProps[name] {
+				if value, changed := escapeText(prop.Value); changed {
+					prop.Value = value
+					report(name, "escaped text")
+				}
+			}
+			if dateProps[name] {
+				repairDate(prop, func(description string) { report(name, description) })
+			}
+		}
+	}
+	for _, child := range comp.Children {
+		repairs = append(repairs, RepairComponent(child)...)
+	}
+	return repairs
+}
+
+// singleProps may occur once in VEVENT and VTODO (RFC 5545 section 3.6).
+var singleProps = map[string]bool{
+	ical.PropUID:             true,
+	ical.PropDateTimeStamp:   true,
+	ical.PropDateTimeStart:   true,
+	ical.PropDateTimeEnd:     true,
+	ical.PropDue:             true,
+	ical.PropDuration:        true,
+	ical.PropSummary:         true,
+	ical.PropDescription:     true,
+	ical.PropLocation:        true,
+	ical.PropClass:           true,
+	ical.PropCreated:         true,
+	ical.PropLastModified:    true,
+	ical.PropGeo:             true,
+	ical.PropOrganizer:       true,
+	ical.PropPriority:        true,
+	ical.PropSequence:        true,
+	ical.PropStatus:          true,
+	ical.PropTransparency:    true,
+	ical.PropURL:             true,
+	ical.PropRecurrenceID:    true,
+	ical.PropCompleted:       true,
+	ical.PropPercentComplete: true,
+}
+
+// textProps hold a single TEXT value, so commas in them are never separators.
+var textProps = map[string]bool{
+	ical.PropSummary:     true,
+	ical.PropDescription: true,
+	ical.PropLocation:    true,
+	ical.PropComment:     true,
+	ical.PropContact:     true,
+}
+
+// dateProps hold DATE or DATE-TIME values.
+var dateProps = map[string]bool{
+	ical.PropDateTimeStart:   true,
+	ical.PropDateTimeEnd:     true,
+	ical.PropDue:             true,
+	ical.PropRecurrenceID:    true,
+	ical.PropExceptionDates:  true,
+	ical.PropRecurrenceDates: true,
+	ical.PropDateTimeStamp:   true,
+	ical.PropCreated:         true,
+	ical.PropLastModified:    true,
+	ical.PropCompleted:       true,
+}
+
+// dateOnlyAllowed are the dateProps that may hold DATE values.
+var dateOnlyAllowed = map[string]bool{
+	ical.PropDateTimeStart:   true,
+	ical.PropDateTimeEnd:     true,
+	ical.PropDue:             true,
+	ical.PropRecurrenceID:    true,
+	ical.PropExceptionDates:  true,
+	ical.PropRecurrenceDates: true,
+}
+
+// escapeText escapes commas, semicolons and trailing backslashes of a TEXT
+// value, and removes backslashes escaping other characters.
+func escapeText(value string) (string, bool) {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\':
+			if i+1 == len(value) {
+				sb.WriteString(`\\`)
+				continue
+			}
+			switch next := value[i+1]; next {
+			case '\\', ';', ',', 'n', 'N':
+				sb.WriteByte(c)
+				sb.WriteByte(next)
+			default:
+				sb.WriteByte(next)
+			}
+			i++
+		case ',', ';':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), sb.String() != value
+}
+
+// looseDate matches dates and date-times with separators, fractional
+// seconds or without seconds.
+var looseDate = regexp.MustCompile(`^(\d{4})-?(\d{2})-?(\d{2})(?:[T ](\d{2}):?(\d{2})(?::?(\d{2}))?(?:\.\d+)?(Z)?)?$`)
+
+// repairDate rewrites the values of a date property in the basic format and
+// adds VALUE=DATE to dates missing it.
+func repairDate(prop *ical.Prop, report func(description string)) {
+	valueParam := prop.Params.Get(ical.ParamValue)
+	if valueParam == string(ical.ValuePeriod) || strings.Contains(prop.Value, "/") {
+		return
+	}
+	values := strings.Split(prop.Value, ",")
+	dates := 0
+	for i, value := range values {
+		m := looseDate.FindStringSubmatch(strings.TrimSpace(value))
+		if m == nil {
+			return
+		}
+		fixed := m[1] + m[2] + m[3]
+		if m[4] != "" {
+			seconds := m[6]
+			if seconds == "" {
+				seconds = "00"
+			}
+			fixed += "T" + m[4] + m[5] + seconds + m[7]
+		} else {
+			dates++
+		}
+		values[i] = fixed
+	}
+	if fixed := strings.Join(values, ","); fixed != prop.Value {
+		prop.Value = fixed
+		report("rewrote date in basic format")
+	}
+	if dates == len(values) && dateOnlyAllowed[prop.Name] && valueParam == "" {
+		prop.Params.Set(ical.ParamValue, string(ical.ValueDate))
+		report("added VALUE=DATE")
+	}
+}
@@ -0,0 +1,82 @@
+package icsfix
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const broken = "BEGIN:VCALENDAR\n" +
+	"VERSION:2.0\n" +
+	"PRODID:-//Old Exporter//EN\n" +
+	"BEGIN:VEVENT\n" +
+	"UID:lunch\n" +
+	"UID:lunch-copy\n" +
+	"DTSTAMP:2019-05-01T08:00:00.000Z\n" +
+	"DTSTART:20190502\n" +
+	"DTEND;VALUE=DATE:2019-05-03\n" +
+	"EXDATE:2019-05-09T12:00,2019-05-16T12:00\n" +
+	"SUMMARY:Lunch, then\n" +
+	"  review; bring notes\\: all\n" +
+	"LOCATION:Cafe\n" +
+	"garbage\n" +
+	"END:VEVENT\n"
+
+func TestDecode(t *testing.T) {
+	cal, repairs, err := Decode(broken)
+	require.NoError(t, err)
+	require.Len(t, cal.Children, 1)
+	event := cal.Children[0]
+
+	assert.Len(t, event.Props.Values(ical.PropUID), 1)
+	assert.Equal(t, "lunch", event.Props.Get(ical.PropUID).Value)
+
+	summary, err := event.Props.Text(ical.PropSummary)
+	require.NoError(t, err)
+	assert.Equal(t, "Lunch, then review; bring notes: all", summary)
+
+	start, err := event.Props.DateTime(ical.PropDateTimeStart, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, "DATE", event.Props.Get(ical.PropDateTimeStart).Params.Get(ical.ParamValue))
+	assert.Equal(t, "20190503", event.Props.Get(ical.PropDateTimeEnd).Value)
+	assert.Equal(t, "20190501T080000Z", event.Props.Get(ical.PropDateTimeStamp).Value)
+	assert.Equal(t, "20190509T120000,20190516T120000", event.Props.Get(ical.PropExceptionDates).Value)
+	assert.Empty(t, event.Props.Get(ical.PropExceptionDates).Params.Get(ical.ParamValue), "date-times get no VALUE=DATE")
+
+	var descriptions []string
+	for _, r := range repairs {
+		descriptions = append(descriptions, r.String())
+	}
+	assert.ElementsMatch(t, []string{
+		"line 14: dropped line without a colon",
+		"VCALENDAR: closed component left open",
+		"VEVENT UID: dropped 1 duplicates",
+		"VEVENT SUMMARY: escaped text",
+		"VEVENT DTSTAMP: rewrote date in basic format",
+		"VEVENT DTSTART: added VALUE=DATE",
+		"VEVENT DTEND: rewrote date in basic format",
+		"VEVENT EXDATE: rewrote date in basic format",
+	}, descriptions)
+
+	var out strings.Builder
+	require.NoError(t, ical.NewEncoder(&out).Encode(cal), "repaired data encodes")
+}
+
+func TestDecodeValid(t *testing.T) {
+	valid := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:a\r\n" +
+		"DTSTAMP:20190501T080000Z\r\nDTSTART;TZID=Europe/Berlin:20190502T090000\r\n" +
+		"CATEGORIES:work,travel\r\nSUMMARY:a\\, b\\nc\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	_, repairs, err := Decode(valid)
+	require.NoError(t, err)
+	assert.Empty(t, repairs)
+}
+
+func TestDecodeUnrepairable(t *testing.T) {
+	_, _, err := Decode("BEGIN:VEVENT\nUID:a\nEND:VEVENT\n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/samber/mo"
+)
+
+// ScheduleInboxCollection is the ID of a user's scheduling inbox (RFC 6638
+// section 2.2) in the calendar home, published as cal:schedule-inbox-URL when
+// the storage implements storage.AvailabilityStorage and
+// Features.EnableScheduling is set. The inbox carries the user's
+// cs:calendar-availability, which free-busy-query on the calendar home
+// honors. It doesn't list scheduling messages; the outbox hands those to
+// storage.ScheduleInboxStorage. With such storage no calendar can use the
+// ID.
+const ScheduleInboxCollection = "inbox"
+
+// availabilityStorage returns the storage as storage.AvailabilityStorage.
+func (h *CaldavHandler) availabilityStorage() (storage.AvailabilityStorage, bool) {
+	avail, ok := storage.As[storage.AvailabilityStorage](h.Storage)
+	return avail, ok
+}
+
+// isScheduleInbox reports whether res is a scheduling inbox or a resource in
+// one.
+func (h *CaldavHandler) isScheduleInbox(res Resource) bool {
+	if _, ok := h.availabilityStorage(); !ok || !h.features().EnableScheduling || res.CalendarID != ScheduleInboxCollection {
+		return false
+	}
+	return res.ResourceType == storage.ResourceCollection || res.ResourceType == storage.ResourceObject
+}
+
+// userAvailability returns the VCALENDAR of VAVAILABILITY components userID
+// publishes on their inbox: the stored one, else one made from their working
+// hours. It returns nil when there is neither, or no inbox.
+func (h *CaldavHandler) userAvailability(userID string) (*ical.Calendar, error) {
+	avail, ok := h.availabilityStorage()
+	if !ok {
+		return nil, nil
+	}
+	cal, err := avail.GetUserAvailability(userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	if cal != nil {
+		return cal, nil
+	}
+	user, err := h.Storage.GetUser(userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	return workingHoursAvailability(userID, user), nil
+}
+
+// handleScheduleInbox serves a scheduling inbox: PROPFIND of its properties
+// and PROPPATCH of cs:calendar-availability. It holds no resources.
+func (h *CaldavHandler) handleScheduleInbox(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	if ctx.Resource.ResourceType == storage.ResourceObject {
+		h.writeError(w, r, http.StatusNotFound, CodeNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		h.handleOptions(w, r, ctx)
+	case "PROPFIND":
+		h.handleScheduleInboxPropfind(w, r, ctx)
+	case "PROPPATCH":
+		h.handleProppatch(w, r, ctx)
+	default:
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+	}
+}
+
+func (h *CaldavHandler) handleScheduleInboxPropfind(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
+		return
+	}
+	req, reqType := propfind.ParseRequest(string(body))
+	if reqType != propfind.RequestTypeProp {
+		req = propfind.ResponseMap{}
+		for _, name := range []string{"resourcetype", "displayname", "owner", "calendar-availability"} {
+			req[name] = mo.Err[props.Property](propfind.ErrNotFound)
+		}
+	}
+	href, err := h.URLConverter.EncodePath(ctx.Resource)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	env := newPropEnv(h, ctx.Resource, nil)
+	env.principal = ctx.AuthUser
+	doc := propfind.EncodeResponse(resolveWith(env, inboxResolvers, req), href)
+	h.writeMultistatus(w, r, []*etree.Document{doc})
+}
+
+// Scheduling inbox resolvers.
+var inboxResolvers = func() map[string]Resolver {
+	m := map[string]Resolver{}
+	for _, name := range []string{"owner", "current-user-principal", "current-user-privilege-set", "principal-collection-set"} {
+		m[name] = commonResolvers[name]
+	}
+	m["resourcetype"] = func(_ *propEnv) mo.Result[props.Property] {
+		return mo.Ok[props.Property](&props.Resourcetype{Type: props.ResourceCollection, ObjectType: "schedule-inbox"})
+	}
+	m["displayname"] = func(_ *propEnv) mo.Result[props.Property] {
+		return mo.Ok[props.Property](&props.DisplayName{Value: "Inbox"})
+	}
+	m["calendar-availability"] = func(env *propEnv) mo.Result[props.Property] {
+		cal, err := env.h.userAvailability(env.res.UserID)
+		if err != nil {
+			env.h.Logger.Error("failed to get user availability", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if cal == nil {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		var buf bytes.Buffer
+		if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+			env.h.Logger.Error("failed to encode user availability", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.CalendarAvailability{Value: buf.String()})
+	}
+	return m
+}()
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleInbox(t *testing.T) {
+	store := &availabilityStorage{MockStorage: &storage.MockStorage{}, cals: map[string]*ical.Calendar{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetUserCalendars", "alice").Return([]storage.Calendar{}, nil)
+	h := NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// The principal points at the inbox
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/",
+		`<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><C:schedule-inbox-URL/></D:prop></D:propfind>`,
+		map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/inbox</d:href>")
+
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/inbox/",
+		proppatchBody(`<D:set><D:prop><CS:calendar-availability>`+availabilityICS+`</CS:calendar-availability></D:prop></D:set>`), nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	require.Contains(t, store.cals, "alice")
+
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/inbox/", `<D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<cal:schedule-inbox/>")
+	assert.Contains(t, w.Body.String(), "UID:office-hours")
+
+	// Free time outside office hours is unavailable
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/", `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:time-range start="20250303T000000Z" end="20250304T000000Z"/>
+</C:free-busy-query>`, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{
+		"FREEBUSY;FBTYPE=BUSY-UNAVAILABLE:20250303T000000Z/20250303T090000Z",
+		"FREEBUSY;FBTYPE=BUSY-UNAVAILABLE:20250303T170000Z/20250304T000000Z",
+	}, freeBusyLines(w.Body.String()))
+
+	assert.Equal(t, http.StatusNotFound, serveAlice(h, http.MethodGet, "/caldav/alice/cal/inbox/a.ics", "", nil).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serveAlice(h, http.MethodDelete, "/caldav/alice/cal/inbox/", "", nil).Code)
+}
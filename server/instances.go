@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// Instance is one occurrence of an event, to-do or journal entry, as
+// returned by GetInstances.
+type Instance struct {
+	CalendarID string
+	ObjectID   string
+	UID        string
+	Summary    string
+	// Start and End are in the location of GetInstances' from. End is
+	// exclusive, and equal to Start for instantaneous components.
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+	// RecurrenceID is the instance's original start on recurring components,
+	// nil otherwise
+	RecurrenceID *time.Time
+	// Overridden is set when a RECURRENCE-ID component replaced the
+	// instance generated by the master
+	Overridden bool
+	// Location is the X-APPLE-STRUCTURED-LOCATION of Apple clients, nil
+	// without one
+	Location *StructuredLocation
+	// TravelTime is the X-APPLE-TRAVEL-DURATION Apple clients block before
+	// Start, and TravelAdvisory their X-APPLE-TRAVEL-ADVISORY-BEHAVIOR, e.g.
+	// "AUTOMATIC"
+	TravelTime     time.Duration
+	TravelAdvisory string
+	// Component is the master or override the instance comes from, for
+	// properties beyond the ones above such as LOCATION or STATUS
+	Component *ical.Component
+}
+
+// instanceExpansion bounds how many instances GetInstances generates per
+// object, and how far.
+var instanceExpansion = recurrence.DefaultExpansionOptions
+
+// GetInstances returns the instances of userID's calendars overlapping
+// [from, to), sorted by start, for applications rendering views such as a
+// month grid. Recurring components are expanded with their RECURRENCE-ID
+// overrides resolved. Times are converted to from's location, which also
+// reads floating times. An empty calendarIDs means all of userID's
+// calendars.
+func (h *CaldavHandler) GetInstances(ctx context.Context, userID string, calendarIDs []string, from, to time.Time) ([]Instance, error) {
+	if contextual, ok := storage.As[storage.ContextualStorage](h.Storage); ok {
+		bound := *h
+		bound.Storage = contextual.WithContext(ctx)
+		h = &bound
+	}
+	if len(calendarIDs) == 0 {
+		var err error
+		if calendarIDs, err = h.userCalendarIDs(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	loc := from.Location()
+	engine := recurrence.NewEngineWithoutCache().WithContext(ctx)
+	var instances []Instance
+	for _, calendarID := range calendarIDs {
+		err := h.forEachObject(userID, calendarID, func(obj storage.CalendarObject) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var objectID string
+			if res, err := h.URLConverter.ParsePath(obj.Path); err == nil {
+				objectID = res.ObjectID
+			}
+			found, err := objectInstances(engine, &obj, from, to.In(loc))
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				h.Logger.Warn("failed to expand object",
+					"path", obj.Path,
+					"error", err)
+				return nil
+			}
+			for i := range found {
+				found[i].CalendarID = calendarID
+				found[i].ObjectID = objectID
+			}
+			instances = append(instances, found...)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of calendar %q: %w", calendarID, err)
+		}
+	}
+
+	sort.SliceStable(instances, func(i, j int) bool {
+		if !instances[i].Start.Equal(instances[j].Start) {
+			return instances[i].Start.Before(instances[j].Start)
+		}
+		return instances[i].UID < instances[j].UID
+	})
+	return instances, nil
+}
+
+// userCalendarIDs returns the IDs of userID's calendars. Calendars whose
+// path doesn't parse are logged and skipped.
+func (h *CaldavHandler) userCalendarIDs(userID string) ([]string, error) {
+	calendars, err := h.Storage.GetUserCalendars(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calendars of %q: %w", userID, err)
+	}
+	var calendarIDs []string
+	for _, cal := range calendars {
+		res, err := h.URLConverter.ParsePath(cal.Path)
+		if err != nil || res.ResourceType != storage.ResourceCollection {
+			h.Logger.Warn("skipping calendar with unparsable path",
+				"path", cal.Path,
+				"error", err)
+			continue
+		}
+		calendarIDs = append(calendarIDs, res.CalendarID)
+	}
+	return calendarIDs, nil
+}
+
+// objectInstances expands the components of obj over [from, to), reading
+// times in from's location. Overrides replace the master's instance with the
+// same RECURRENCE-ID, and show up where they moved it.
+func objectInstances(engine *recurrence.Engine, obj *storage.CalendarObject, from, to time.Time) ([]Instance, error) {
+	loc := from.Location()
+	master := obj.Master()
+	if master == nil {
+		return nil, nil
+	}
+	var overrides []*ical.Component
+	if master.Props.Get(ical.PropRecurrenceID) != nil {
+		// Invited to single instances only: there is no master to expand
+		overrides = append(obj.Overrides(), master)
+		master = nil
+	} else {
+		overrides = obj.Overrides()
+	}
+
+	var instances []Instance
+	replaced := map[string]bool{}
+	for _, override := range overrides {
+		info := recurrence.ExtractRecurrenceInfoInLocation(override, loc)
+		if info.RecurrenceID != nil {
+			replaced[recurrenceKey(*info.RecurrenceID, info.AllDay)] = true
+		}
+		found, err := componentInstances(engine, override, recurrence.RecurrenceInfo{AllDay: info.AllDay}, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			found[i].RecurrenceID = info.RecurrenceID
+			found[i].Overridden = true
+		}
+		instances = append(instances, found...)
+	}
+	if master == nil {
+		return instances, nil
+	}
+
+	found, err := componentInstances(engine, master, recurrence.ExtractRecurrenceInfoInLocation(master, loc), from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range found {
+		if instance.RecurrenceID != nil && replaced[recurrenceKey(*instance.RecurrenceID, instance.AllDay)] {
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// recurrenceKey identifies an instance by its RECURRENCE-ID. All-day ones are
+// matched by date, since DATE values may have been read in another location.
+func recurrenceKey(recurrenceID time.Time, allDay bool) string {
+	if allDay {
+		return recurrenceID.Format("20060102")
+	}
+	return recurrenceID.UTC().Format(time.RFC3339)
+}
+
+// componentInstances expands a single component with info over [from, to).
+func componentInstances(engine *recurrence.Engine, comp *ical.Component, info recurrence.RecurrenceInfo, from, to time.Time) ([]Instance, error) {
+	loc := from.Location()
+	start, end, ok := recurrence.ExtractBasicTimeInfoInLocation(comp, loc)
+	if !ok {
+		return nil, nil
+	}
+	occurrences, err := engine.ExpandInstances(start, end, info, from, to, instanceExpansion)
+	if err != nil {
+		return nil, err
+	}
+	uid, _ := comp.Props.Text(ical.PropUID)
+	summary, _ := comp.Props.Text(ical.PropSummary)
+	location, _ := ParseStructuredLocation(comp)
+	travel, advisory := travelTime(comp)
+	instances := make([]Instance, 0, len(occurrences))
+	for _, occurrence := range occurrences {
+		instances = append(instances, Instance{
+			UID:            uid,
+			Summary:        summary,
+			Start:          occurrence.Start.In(loc),
+			End:            occurrence.End.In(loc),
+			AllDay:         info.AllDay,
+			RecurrenceID:   occurrence.RecurrenceID,
+			Location:       location,
+			TravelTime:     travel,
+			TravelAdvisory: advisory,
+			Component:      comp,
+		})
+	}
+	return instances, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// instanceComponent builds a VEVENT from raw property values.
+func instanceComponent(props map[string]string) *ical.Component {
+	comp := ical.NewComponent(ical.CompEvent)
+	for name, value := range props {
+		prop := &ical.Prop{Name: name, Params: ical.Params{}, Value: value}
+		if name == ical.PropDateTimeStart || name == ical.PropDateTimeEnd || name == ical.PropRecurrenceID || name == ical.PropExceptionDates {
+			if len(value) == len("20060102") {
+				prop.Params.Set(ical.ParamValue, "DATE")
+			} else {
+				prop.Params.Set(ical.ParamTimezoneID, "Europe/Berlin")
+			}
+		}
+		comp.Props.Set(prop)
+	}
+	return comp
+}
+
+func TestGetInstances(t *testing.T) {
+	standup := storage.CalendarObject{Path: "/alice/cal/work/standup.ics", Component: []*ical.Component{
+		instanceComponent(map[string]string{
+			ical.PropUID: "standup", ical.PropSummary: "Standup",
+			ical.PropDateTimeStart: "20250303T090000", ical.PropDateTimeEnd: "20250303T093000",
+			ical.PropRecurrenceRule: "FREQ=WEEKLY;COUNT=4", ical.PropExceptionDates: "20250317T090000",
+		}),
+		instanceComponent(map[string]string{
+			ical.PropUID: "standup", ical.PropSummary: "Standup (moved)", ical.PropRecurrenceID: "20250310T090000",
+			ical.PropDateTimeStart: "20250311T100000", ical.PropDateTimeEnd: "20250311T103000",
+		}),
+	}}
+	holiday := storage.CalendarObject{Path: "/alice/cal/home/holiday.ics", Component: []*ical.Component{
+		instanceComponent(map[string]string{
+			ical.PropUID: "holiday", ical.PropSummary: "Holiday", ical.PropDateTimeStart: "20250310",
+		}),
+	}}
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work/"}, {Path: "/alice/cal/home/"}}, nil)
+	mockStorage.On("GetObjectsInCollection", "work").Return([]storage.CalendarObject{standup}, nil)
+	mockStorage.On("GetObjectsInCollection", "home").Return([]storage.CalendarObject{holiday}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, newYork)
+	instances, err := h.GetInstances(context.Background(), "alice", nil, from, from.AddDate(0, 1, 0))
+	require.NoError(t, err)
+
+	type summary struct {
+		Object, Summary string
+		Start, End      string
+		AllDay          bool
+		Overridden      bool
+	}
+	var got []summary
+	for _, i := range instances {
+		got = append(got, summary{i.CalendarID + "/" + i.ObjectID, i.Summary, i.Start.Format(time.RFC3339), i.End.Format(time.RFC3339), i.AllDay, i.Overridden})
+	}
+	assert.Equal(t, []summary{
+		{"work/standup.ics", "Standup", "2025-03-03T03:00:00-05:00", "2025-03-03T03:30:00-05:00", false, false},
+		{"home/holiday.ics", "Holiday", "2025-03-10T00:00:00-04:00", "2025-03-11T00:00:00-04:00", true, false},
+		{"work/standup.ics", "Standup (moved)", "2025-03-11T05:00:00-04:00", "2025-03-11T05:30:00-04:00", false, true},
+		{"work/standup.ics", "Standup", "2025-03-24T04:00:00-04:00", "2025-03-24T04:30:00-04:00", false, false},
+	}, got)
+	require.NotNil(t, instances[2].RecurrenceID)
+	assert.True(t, instances[2].RecurrenceID.Equal(time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)))
+	assert.Nil(t, instances[1].RecurrenceID)
+
+	// Only the calendars asked for
+	instances, err = h.GetInstances(context.Background(), "alice", []string{"home"}, from, from.AddDate(0, 1, 0))
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "holiday", instances[0].UID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = h.GetInstances(ctx, "alice", []string{"work"}, from, from.AddDate(0, 1, 0))
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// Job is a task a JobRunner runs in the background every Interval, such as
+// compacting tombstones or rebuilding an occurrence index.
+type Job struct {
+	Name     string // Unique within the runner, used in logs and metrics
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+	// Optional: run once right after starting instead of waiting an Interval
+	Immediate bool
+	// Optional: add a random delay of up to this long before each run, so
+	// replicas started together don't hit the storage at once
+	Jitter time.Duration
+	// Optional: wait this long before retrying a failed run, doubling with
+	// each further failure up to Interval. Interval/8 by default
+	Retry time.Duration
+}
+
+// JobRunner runs Jobs in the background until it is stopped. Each job runs
+// alone, never overlapping itself, and MaxRunning bounds how many jobs run at
+// once. Failed and panicking runs are logged and retried with backoff. Set
+// it as CaldavHandler.Jobs to stop it with Shutdown.
+type JobRunner struct {
+	Logger     *slog.Logger // Optional: where runs are logged, slog.Default() when nil
+	MaxRunning int          // Optional: jobs running at once, no limit when 0
+
+	mu      sync.Mutex
+	jobs    []*jobState
+	ctx     context.Context // set by Start
+	cancel  context.CancelFunc
+	slots   chan struct{}
+	running sync.WaitGroup
+}
+
+type jobState struct {
+	job   Job
+	stats JobStats
+}
+
+// JobStats counts the runs of a job. Runs, Failures and Seconds only grow, so
+// they can be exported as Prometheus counters, see WriteMetrics.
+type JobStats struct {
+	Runs        uint64    // finished runs, failed ones included
+	Failures    uint64    // runs that returned an error or panicked
+	Failing     int       // failures since the last successful run
+	Seconds     float64   // time spent running
+	LastSuccess time.Time // end of the last successful run
+	LastError   error     // error of the last failed run
+}
+
+// Add registers a job. Jobs added after Start start right away.
+func (r *JobRunner) Add(job Job) error {
+	switch {
+	case job.Name == "":
+		return errors.New("job has no name")
+	case job.Interval <= 0:
+		return fmt.Errorf("job %q has no interval", job.Name)
+	case job.Run == nil:
+		return fmt.Errorf("job %q has no Run function", job.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.jobs {
+		if s.job.Name == job.Name {
+			return fmt.Errorf("job %q already added", job.Name)
+		}
+	}
+	s := &jobState{job: job}
+	r.jobs = append(r.jobs, s)
+	if r.ctx != nil {
+		r.running.Add(1)
+		go r.loop(r.ctx, s)
+	}
+	return nil
+}
+
+// Start runs the jobs until ctx is done or Stop is called. Calling it again
+// does nothing.
+func (r *JobRunner) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx != nil {
+		return
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	if r.MaxRunning > 0 {
+		r.slots = make(chan struct{}, r.MaxRunning)
+	}
+	for _, s := range r.jobs {
+		r.running.Add(1)
+		go r.loop(r.ctx, s)
+	}
+}
+
+// Stop cancels the context of running jobs and waits for them to return,
+// until ctx is done.
+func (r *JobRunner) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.mu.Unlock()
+	return waitContext(ctx, &r.running)
+}
+
+// loop runs a job on its schedule until ctx is done.
+func (r *JobRunner) loop(ctx context.Context, s *jobState) {
+	defer r.running.Done()
+	delay := s.job.Interval
+	if s.job.Immediate {
+		delay = 0
+	}
+	failing := 0
+	for {
+		if s.job.Jitter > 0 {
+			delay += rand.N(s.job.Jitter)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !r.acquire(ctx) {
+			return
+		}
+		started := time.Now()
+		err := runJob(ctx, s.job)
+		r.release()
+		if ctx.Err() != nil {
+			return // canceled runs count for nothing
+		}
+		r.record(s, started, err)
+
+		if err == nil {
+			failing, delay = 0, s.job.Interval
+			continue
+		}
+		failing++
+		delay = jobRetryDelay(s.job, failing)
+		r.logger().Warn("background job failed",
+			"job", s.job.Name,
+			"failures", failing,
+			"retry_in", delay,
+			"error", err)
+	}
+}
+
+// runJob runs job once, turning a panic into an error.
+func runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// jobRetryDelay is how long to wait after the given number of consecutive
+// failures.
+func jobRetryDelay(job Job, failing int) time.Duration {
+	delay := job.Retry
+	if delay <= 0 {
+		delay = job.Interval / 8
+	}
+	for i := 1; i < failing && delay < job.Interval; i++ {
+		delay *= 2
+	}
+	return min(delay, job.Interval)
+}
+
+func (r *JobRunner) acquire(ctx context.Context) bool {
+	if r.slots == nil {
+		return true
+	}
+	select {
+	case r.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *JobRunner) release() {
+	if r.slots != nil {
+		<-r.slots
+	}
+}
+
+func (r *JobRunner) record(s *jobState, started time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.stats.Runs++
+	s.stats.Seconds += time.Since(started).Seconds()
+	if err != nil {
+		s.stats.Failures++
+		s.stats.Failing++
+		s.stats.LastError = err
+		return
+	}
+	s.stats.Failing = 0
+	s.stats.LastSuccess = time.Now()
+	r.logger().Debug("background job finished",
+		"job", s.job.Name,
+		"duration", time.Since(started))
+}
+
+func (r *JobRunner) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+// Stats returns what each job did so far, by name.
+func (r *JobRunner) Stats() map[string]JobStats {
+	_, stats := r.snapshot()
+	return stats
+}
+
+// snapshot returns the job names in the order they were added and their
+// stats, read at once.
+func (r *JobRunner) snapshot() ([]string, map[string]JobStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, len(r.jobs))
+	stats := make(map[string]JobStats, len(r.jobs))
+	for i, s := range r.jobs {
+		names[i] = s.job.Name
+		stats[s.job.Name] = s.stats
+	}
+	return names, stats
+}
+
+// WriteMetrics writes Stats in the Prometheus text exposition format, for
+// appending to a /metrics endpoint. Jobs are told apart by a job label.
+func (r *JobRunner) WriteMetrics(out io.Writer) error {
+	names, stats := r.snapshot()
+
+	for _, metric := range []struct {
+		name, help, kind string
+		value            func(JobStats) any
+	}{
+		{"caldav_job_runs_total", "Finished background job runs.", "counter", func(s JobStats) any { return s.Runs }},
+		{"caldav_job_failures_total", "Background job runs that failed.", "counter", func(s JobStats) any { return s.Failures }},
+		{"caldav_job_duration_seconds_sum", "Time spent running background jobs.", "counter", func(s JobStats) any { return s.Seconds }},
+		{"caldav_job_last_success_timestamp_seconds", "Unix time the background job last succeeded, 0 if it never did.", "gauge", func(s JobStats) any {
+			if s.LastSuccess.IsZero() {
+				return 0
+			}
+			return s.LastSuccess.Unix()
+		}},
+	} {
+		if _, err := fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, err := fmt.Fprintf(out, "%s{job=%q} %v\n", metric.name, name, metric.value(stats[name])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CompactTombstonesJob returns a job dropping tombstones older than retention
+// every interval, the JobRunner counterpart of storage.CompactTombstonesEvery.
+// A nil logger logs to slog.Default().
+func CompactTombstonesJob(s storage.TombstoneStorage, retention, interval time.Duration, logger *slog.Logger) Job {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return Job{
+		Name:     "compact-tombstones",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(context.Context) error {
+			n, err := s.CompactTombstones(time.Now().Add(-retention))
+			if n > 0 {
+				logger.Info("compacted tombstones", "dropped", n)
+			}
+			return err
+		},
+	}
+}
+
+// Job returns a job warming the recurrence cache with the calendars of the
+// users listed by users every interval, starting right away, so the cache
+// follows the days moving on and expired entries are filled again.
+func (w *RecurrenceWarmer) Job(interval time.Duration, users func() ([]string, error)) Job {
+	return Job{
+		Name:      "warm-recurrence",
+		Interval:  interval,
+		Immediate: true,
+		Jitter:    interval / 10,
+		Run: func(ctx context.Context) error {
+			ids, err := users()
+			if err != nil {
+				return err
+			}
+			var errs []error
+			for _, id := range ids {
+				if err := w.Warm(ctx, id); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					errs = append(errs, fmt.Errorf("user %q: %w", id, err))
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+}
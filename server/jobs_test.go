@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestJobRunner() *JobRunner {
+	return &JobRunner{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+func TestJobRunner(t *testing.T) {
+	r := newTestJobRunner()
+	var runs, failures atomic.Int32
+	require.NoError(t, r.Add(Job{Name: "tick", Interval: 5 * time.Millisecond, Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}))
+	require.NoError(t, r.Add(Job{Name: "broken", Interval: time.Hour, Immediate: true, Retry: time.Millisecond, Run: func(context.Context) error {
+		if failures.Add(1) == 2 {
+			panic("boom")
+		}
+		return errors.New("storage down")
+	}}))
+	assert.Error(t, r.Add(Job{Name: "tick", Interval: time.Second, Run: func(context.Context) error { return nil }}))
+	assert.Error(t, r.Add(Job{Name: "no-interval", Run: func(context.Context) error { return nil }}))
+
+	r.Start(context.Background())
+	require.Eventually(t, func() bool { return runs.Load() >= 3 && failures.Load() >= 3 }, time.Second, time.Millisecond)
+	require.NoError(t, r.Stop(context.Background()))
+
+	stats := r.Stats()
+	assert.GreaterOrEqual(t, stats["tick"].Runs, uint64(3))
+	assert.Zero(t, stats["tick"].Failures)
+	assert.False(t, stats["tick"].LastSuccess.IsZero())
+	// The panic is a failure like any other, retried with backoff
+	assert.Equal(t, stats["broken"].Runs, stats["broken"].Failures)
+	assert.GreaterOrEqual(t, stats["broken"].Failing, 3)
+	assert.EqualError(t, stats["broken"].LastError, "storage down")
+
+	// Nothing runs after Stop
+	stopped := runs.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load())
+
+	var out bytes.Buffer
+	require.NoError(t, r.WriteMetrics(&out))
+	assert.Contains(t, out.String(), "# TYPE caldav_job_runs_total counter\n")
+	assert.Contains(t, out.String(), `caldav_job_failures_total{job="tick"} 0`)
+	assert.Contains(t, out.String(), `caldav_job_last_success_timestamp_seconds{job="broken"} 0`)
+}
+
+func TestJobRunnerMaxRunning(t *testing.T) {
+	r := newTestJobRunner()
+	r.MaxRunning = 1
+	var running, most atomic.Int32
+	run := func(ctx context.Context) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > most.Load() {
+			most.Store(n)
+		}
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, r.Add(Job{Name: name, Interval: time.Millisecond, Immediate: true, Run: run}))
+	}
+	r.Start(context.Background())
+	require.Eventually(t, func() bool {
+		stats := r.Stats()
+		return stats["a"].Runs > 1 && stats["b"].Runs > 1 && stats["c"].Runs > 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, r.Stop(context.Background()))
+	assert.Equal(t, int32(1), most.Load())
+}
+
+func TestJobRetryDelay(t *testing.T) {
+	job := Job{Interval: 80 * time.Minute}
+	assert.Equal(t, 10*time.Minute, jobRetryDelay(job, 1))
+	assert.Equal(t, 20*time.Minute, jobRetryDelay(job, 2))
+	assert.Equal(t, 80*time.Minute, jobRetryDelay(job, 4))
+	assert.Equal(t, 80*time.Minute, jobRetryDelay(job, 10))
+	job.Retry = time.Minute
+	assert.Equal(t, 4*time.Minute, jobRetryDelay(job, 3))
+}
+
+func TestShutdownStopsJobs(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Jobs = newTestJobRunner()
+	started, canceled := make(chan struct{}), make(chan struct{})
+	require.NoError(t, h.Jobs.Add(Job{Name: "long", Interval: time.Hour, Immediate: true, Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}}))
+	h.Jobs.Start(context.Background())
+	<-started
+
+	require.NoError(t, h.Shutdown(context.Background()))
+	select {
+	case <-canceled:
+	default:
+		t.Fatal("job still running after Shutdown")
+	}
+}
+
+// compactingStorage drops a fixed number of tombstones per compaction.
+type compactingStorage struct{ dropped int }
+
+func (s compactingStorage) CompactTombstones(time.Time) (int, error) {
+	return s.dropped, nil
+}
+
+func TestCompactTombstonesJobWithoutLogger(t *testing.T) {
+	job := CompactTombstonesJob(compactingStorage{dropped: 3}, time.Hour, time.Hour, nil)
+	assert.NoError(t, job.Run(context.Background()), "a nil logger falls back to slog.Default()")
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Limits caps how much a single user can store. A zero field means no limit.
+type Limits struct {
+	MaxCalendars int // Calendar collections per user, enforced on MKCALENDAR
+	MaxObjects   int // Objects per calendar collection, enforced when PUT or a bulk POST creates one
+}
+
+// calendarsRemaining returns how many more calendars the user may create, or
+// -1 when the number is not limited.
+func (h *CaldavHandler) calendarsRemaining(userID string) (int, error) {
+	if h.Limits.MaxCalendars <= 0 {
+		return -1, nil
+	}
+	calendars, err := h.Storage.GetUserCalendars(userID)
+	if err != nil {
+		return 0, err
+	}
+	return max(h.Limits.MaxCalendars-len(calendars), 0), nil
+}
+
+// objectsRemaining returns how many more objects the calendar may hold, or -1
+// when the number is not limited.
+func (h *CaldavHandler) objectsRemaining(userID, calendarID string) (int, error) {
+	if h.Limits.MaxObjects <= 0 {
+		return -1, nil
+	}
+	paths, err := h.objectPathsInCollection(userID, calendarID)
+	if err != nil {
+		return 0, err
+	}
+	return max(h.Limits.MaxObjects-len(paths), 0), nil
+}
+
+// writeQuotaExceeded rejects a write that would go over a configured limit,
+// using the DAV:quota-not-exceeded precondition from RFC 4331.
+func (h *CaldavHandler) writeQuotaExceeded(w http.ResponseWriter, r *http.Request, what string, limit int) {
+	h.writePrecondition(w, r, http.StatusInsufficientStorage, "d:quota-not-exceeded",
+		fmt.Sprintf("limit of %d %s reached", limit, what))
+}
+
+// checkObjectLimit reports whether another object may be created in the
+// calendar, answering the request itself when it may not.
+func (h *CaldavHandler) checkObjectLimit(w http.ResponseWriter, r *http.Request, res Resource) bool {
+	remaining, err := h.objectsRemaining(res.UserID, res.CalendarID)
+	if err != nil {
+		h.Logger.Error("failed to count objects for limit check",
+			"calendar_id", res.CalendarID,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return false
+	}
+	if remaining == 0 {
+		h.Logger.Warn("object limit reached",
+			"user_id", res.UserID,
+			"calendar_id", res.CalendarID,
+			"limit", h.Limits.MaxObjects)
+		h.writeQuotaExceeded(w, r, "objects per calendar", h.Limits.MaxObjects)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCalendarLimit(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Limits = Limits{MaxCalendars: 1}
+	body := `<?xml version="1.0"?><C:mkcalendar xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:D="DAV:"><D:set><D:prop><D:displayname>Home</D:displayname></D:prop></D:set></C:mkcalendar>`
+
+	w := serveAlice(h, "MKCALENDAR", "/caldav/alice/cal/home", body, nil)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:quota-not-exceeded/>")
+	assert.Contains(t, w.Body.String(), "limit of 1 calendars per user reached")
+	mockStorage.AssertNotCalled(t, "CreateCalendar", mock.Anything, mock.Anything)
+
+	h.Limits.MaxCalendars = 2
+	mockStorage.On("CreateCalendar", "alice", mock.AnythingOfType("*storage.Calendar")).
+		Run(func(args mock.Arguments) {
+			cal := args.Get(1).(*storage.Calendar)
+			cal.ETag = "etag"
+			cal.Path = "/alice/cal/home"
+		}).Return(nil).Once()
+	w = serveAlice(h, "MKCALENDAR", "/caldav/alice/cal/home", body, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestObjectLimit(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Limits = Limits{MaxObjects: 2}
+	mockStorage.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrNotFound)
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/a.ics", ETag: "old"}, nil)
+	mockStorage.On("GetObject", "alice", "work", mock.Anything).Return(nil, storage.ErrNotFound)
+	mockStorage.On("UpdateObject", "alice", "work", mock.AnythingOfType("*storage.CalendarObject")).Return("new", nil)
+
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250101T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	header := map[string]string{"Content-Type": "text/calendar"}
+
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", ics, header)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	assert.Contains(t, w.Body.String(), "limit of 2 objects per calendar reached")
+
+	// Updating an existing object doesn't count against the limit
+	w = serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics", ics, header)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serveAlice(h, http.MethodPost, "/caldav/alice/cal/work?action=simple", ics, header)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "507 Insufficient Storage")
+	mockStorage.AssertNumberOfCalls(t, "UpdateObject", 1)
+}
+
+func TestLimitProperties(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Limits = Limits{MaxCalendars: 5, MaxObjects: 10}
+	header := map[string]string{"Depth": "0"}
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:L="https://github.com/cyp0633/libcaldora/ns/"><D:prop><L:max-calendars/><L:calendars-remaining/></D:prop></D:propfind>`
+	w := serveAlice(h, "PROPFIND", "/caldav/alice", body, header)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<lc:max-calendars>5</lc:max-calendars>")
+	assert.Contains(t, w.Body.String(), "<lc:calendars-remaining>4</lc:calendars-remaining>")
+
+	body = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:L="https://github.com/cyp0633/libcaldora/ns/"><D:prop><L:max-objects/><L:objects-remaining/></D:prop></D:propfind>`
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, header)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<lc:max-objects>10</lc:max-objects>")
+	assert.Contains(t, w.Body.String(), "<lc:objects-remaining>8</lc:objects-remaining>")
+
+	// Without limits the properties are absent
+	h.Limits = Limits{}
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, header)
+	assert.Contains(t, w.Body.String(), "404 Not Found")
+	assert.NotContains(t, w.Body.String(), "<lc:objects-remaining>")
+}
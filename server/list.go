@@ -0,0 +1,78 @@
+package server
+
+import (
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// listPageSize is the page size requested from storage.PaginatedStorage backends.
+const listPageSize = 500
+
+// forEachObject calls fn for every object of a calendar collection. Backends
+// implementing storage.PaginatedStorage are walked page by page; others are
+// read in one go through GetObjectsInCollection. Iteration stops at the first
+// error returned by fn.
+func (h *CaldavHandler) forEachObject(userID, calendarID string, fn func(obj storage.CalendarObject) error) error {
+	paginated, ok := storage.As[storage.PaginatedStorage](h.Storage)
+	if !ok {
+		objects, err := h.Storage.GetObjectsInCollection(calendarID)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	opts := storage.ListOptions{Limit: listPageSize}
+	for {
+		page, err := paginated.ListObjects(userID, calendarID, opts)
+		if err != nil {
+			return err
+		}
+		h.Logger.Debug("listed object page",
+			"calendar_id", calendarID,
+			"count", len(page.Objects),
+			"has_more", page.NextCursor != "")
+		for _, obj := range page.Objects {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// objectPathsInCollection returns the paths of all objects in a calendar
+// collection, paging through storage.PaginatedStorage when available.
+func (h *CaldavHandler) objectPathsInCollection(userID, calendarID string) ([]string, error) {
+	if _, ok := storage.As[storage.PaginatedStorage](h.Storage); !ok {
+		return h.Storage.GetObjectPathsInCollection(calendarID)
+	}
+	var paths []string
+	err := h.forEachObject(userID, calendarID, func(obj storage.CalendarObject) error {
+		paths = append(paths, obj.Path)
+		return nil
+	})
+	return paths, err
+}
+
+// calendarEmpty reports whether a calendar collection holds no objects,
+// reading a single page through storage.PaginatedStorage when available.
+func (h *CaldavHandler) calendarEmpty(userID, calendarID string) (bool, error) {
+	paginated, ok := storage.As[storage.PaginatedStorage](h.Storage)
+	if !ok {
+		paths, err := h.Storage.GetObjectPathsInCollection(calendarID)
+		return len(paths) == 0, err
+	}
+	page, err := paginated.ListObjects(userID, calendarID, storage.ListOptions{Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	return len(page.Objects) == 0, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedStorage serves a fixed set of objects through storage.PaginatedStorage.
+type pagedStorage struct {
+	*storage.MockStorage
+	objects []storage.CalendarObject
+	pageLen int
+	calls   int
+}
+
+func (p *pagedStorage) ListObjects(userID, calendarID string, opts storage.ListOptions) (*storage.ObjectPage, error) {
+	p.calls++
+	start := 0
+	if opts.Cursor != "" {
+		pos, err := storage.DecodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Sscanf(pos, "%d", &start)
+	}
+	end := min(start+p.pageLen, len(p.objects))
+	page := &storage.ObjectPage{Objects: p.objects[start:end]}
+	if end < len(p.objects) {
+		page.NextCursor = storage.EncodeCursor(fmt.Sprint(end))
+	}
+	return page, nil
+}
+
+func TestForEachObjectPages(t *testing.T) {
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	var objects []storage.CalendarObject
+	for i := range 5 {
+		objects = append(objects, storage.NewMockEvent(
+			fmt.Sprintf("/caldav/alice/cal/work/e%d.ics", i), fmt.Sprintf("uid-%d", i), "Event", start, start.Add(time.Hour)))
+	}
+	paged := &pagedStorage{MockStorage: &storage.MockStorage{}, objects: objects, pageLen: 2}
+	h := NewCaldavHandler("/caldav/", "Test Realm", paged, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	paths, err := h.objectPathsInCollection("alice", "work")
+	require.NoError(t, err)
+	assert.Len(t, paths, 5)
+	assert.Equal(t, "/caldav/alice/cal/work/e4.ics", paths[4])
+	assert.Equal(t, 3, paged.calls)
+
+	// GetObjectsInCollection is never consulted; the mock would panic otherwise
+	children, err := h.fetchChildren(1, Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection})
+	require.NoError(t, err)
+	assert.Len(t, children, 5)
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	_, err := storage.DecodeCursor("not a cursor!")
+	assert.ErrorIs(t, err, storage.ErrInvalidInput)
+
+	pos, err := storage.DecodeCursor(storage.EncodeCursor("event-42.ics"))
+	require.NoError(t, err)
+	assert.Equal(t, "event-42.ics", pos)
+}
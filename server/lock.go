@@ -0,0 +1,439 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyp0633/libcaldora/internal/ifheader"
+	"github.com/cyp0633/libcaldora/internal/xml/lockinfo"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/google/uuid"
+)
+
+// DefaultMaxLockTimeout caps lock timeouts when LockTable.MaxTimeout is unset.
+const DefaultMaxLockTimeout = time.Hour
+
+// LockTable holds WebDAV locks (RFC 4918 class 2) in memory. Setting
+// CaldavHandler.Locks enables LOCK and UNLOCK; writes to a locked resource
+// then need its lock token in the If header. Locks are lost on restart,
+// which clients handle like an expired lock.
+type LockTable struct {
+	// MaxTimeout caps the timeout clients may ask for, and is used when they
+	// don't ask; DefaultMaxLockTimeout when zero
+	MaxTimeout time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*davLock // by token
+	now   func() time.Time
+}
+
+type davLock struct {
+	lockinfo.ActiveLock
+	expires time.Time
+	// creator is the principal that took the lock; only it may use the
+	// token (RFC 4918 section 6.4)
+	creator string
+}
+
+var (
+	errLockConflict = errors.New("resource is locked")
+	errLockNotFound = errors.New("no such lock")
+	errLockNotOwned = errors.New("lock belongs to another principal")
+)
+
+// NewLockTable returns an empty LockTable.
+func NewLockTable() *LockTable {
+	return &LockTable{locks: map[string]*davLock{}, now: time.Now}
+}
+
+func (t *LockTable) maxTimeout() time.Duration {
+	if t.MaxTimeout > 0 {
+		return t.MaxTimeout
+	}
+	return DefaultMaxLockTimeout
+}
+
+// expire drops locks past their timeout. Callers hold t.mu.
+func (t *LockTable) expire() {
+	now := t.now()
+	for token, l := range t.locks {
+		if now.After(l.expires) {
+			delete(t.locks, token)
+		}
+	}
+}
+
+// covers reports whether l applies to path.
+func (l *davLock) covers(path string) bool {
+	return l.Root == path || (l.Infinity && strings.HasPrefix(path, strings.TrimSuffix(l.Root, "/")+"/"))
+}
+
+// create grants creator a new lock rooted at lock.Root unless it conflicts
+// with an existing one.
+func (t *LockTable) create(lock lockinfo.ActiveLock, creator string) (lockinfo.ActiveLock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	for _, l := range t.locks {
+		overlaps := l.covers(lock.Root) || (lock.Infinity && (&davLock{ActiveLock: lock}).covers(l.Root))
+		if overlaps && (!l.Shared || !lock.Shared) {
+			return lockinfo.ActiveLock{}, errLockConflict
+		}
+	}
+	lock.Token = "urn:uuid:" + uuid.NewString()
+	t.locks[lock.Token] = &davLock{ActiveLock: lock, expires: t.now().Add(lock.Timeout), creator: creator}
+	return lock, nil
+}
+
+// refresh restarts the timeout of the lock with token, which must cover path
+// and belong to user.
+func (t *LockTable) refresh(path, token, user string, timeout time.Duration) (lockinfo.ActiveLock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	l, ok := t.locks[token]
+	if !ok || !l.covers(path) {
+		return lockinfo.ActiveLock{}, errLockNotFound
+	}
+	if l.creator != user {
+		return lockinfo.ActiveLock{}, errLockNotOwned
+	}
+	l.Timeout = timeout
+	l.expires = t.now().Add(timeout)
+	return l.ActiveLock, nil
+}
+
+// holds reports whether token names a live lock of user covering path.
+func (t *LockTable) holds(path, token, user string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	l, ok := t.locks[token]
+	return ok && l.covers(path) && l.creator == user
+}
+
+// remove drops the lock with token, which must cover path and belong to
+// user.
+func (t *LockTable) remove(path, token, user string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	l, ok := t.locks[token]
+	if !ok || !l.covers(path) {
+		return errLockNotFound
+	}
+	if l.creator != user {
+		return errLockNotOwned
+	}
+	delete(t.locks, token)
+	return nil
+}
+
+// blocking returns the root of a lock on path whose token user didn't
+// submit, or "" when the write may go ahead. Tokens of other principals'
+// locks don't count. With membership set, the write also adds or removes
+// path from its parent collection, which a lock on the parent protects
+// whatever its depth.
+func (t *LockTable) blocking(path string, membership bool, submitted []string, user string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	parent := path[:strings.LastIndex(strings.TrimSuffix(path, "/"), "/")+1]
+	for token, l := range t.locks {
+		applies := l.covers(path) || (membership && strings.TrimSuffix(l.Root, "/")+"/" == parent)
+		if applies && (l.creator != user || !containsString(submitted, token)) {
+			return l.Root
+		}
+	}
+	return ""
+}
+
+// blockingMember returns the root of a lock inside collection path whose
+// token user didn't submit, or "" when the collection may be deleted.
+func (t *LockTable) blockingMember(path string, submitted []string, user string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for token, l := range t.locks {
+		if strings.HasPrefix(l.Root, prefix) && (l.creator != user || !containsString(submitted, token)) {
+			return l.Root
+		}
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// submittedLockTokens returns the lock tokens listed in the If header. A
+// malformed header submits none; checkIfHeader rejects it.
+func submittedLockTokens(r *http.Request) []string {
+	header, err := ifheader.Parse(r.Header.Get("If"))
+	if err != nil {
+		return nil
+	}
+	return header.Tokens()
+}
+
+// lockUser is the principal r takes, uses and removes locks as.
+func lockUser(r *http.Request) string {
+	if ctx, ok := RequestContextFrom(r.Context()); ok {
+		return ctx.AuthUser
+	}
+	return ""
+}
+
+// parseLockTimeout picks the first usable value of a Timeout header
+// (RFC 4918 section 10.7), capped at max. Without one, max is used.
+func parseLockTimeout(header string, max time.Duration) time.Duration {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if strings.EqualFold(value, "Infinite") {
+			return max
+		}
+		if seconds, ok := strings.CutPrefix(value, "Second-"); ok {
+			if n, err := strconv.ParseInt(seconds, 10, 64); err == nil && n > 0 {
+				return min(time.Duration(n)*time.Second, max)
+			}
+		}
+	}
+	return max
+}
+
+// checkLockTokens reports whether the write to res may go ahead, answering
+// 423 Locked with the DAV:lock-token-submitted precondition when the
+// resource is locked and the If header doesn't carry the token.
+func (h *CaldavHandler) checkLockTokens(w http.ResponseWriter, r *http.Request, res Resource, membership bool) bool {
+	if h.Locks == nil {
+		return true
+	}
+	path, err := h.URLConverter.EncodePath(res)
+	if err != nil {
+		h.Logger.Error("failed to encode path for lock check",
+			"resource", res,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return false
+	}
+	if root := h.Locks.blocking(path, membership, submittedLockTokens(r), lockUser(r)); root != "" {
+		h.Logger.Warn("write to locked resource without lock token",
+			"path", path,
+			"lock_root", root)
+		h.writePrecondition(w, r, http.StatusLocked, "d:lock-token-submitted", "resource is locked by "+root)
+		return false
+	}
+	return true
+}
+
+// handleLock creates or refreshes a lock on an existing object or collection.
+func (h *CaldavHandler) handleLock(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	if h.Locks == nil {
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+		return
+	}
+	h.Logger.Info("lock request received",
+		"resource_type", ctx.Resource.ResourceType,
+		"user_id", ctx.Resource.UserID,
+		"calendar_id", ctx.Resource.CalendarID,
+		"object_id", ctx.Resource.ObjectID)
+
+	var err error
+	switch ctx.Resource.ResourceType {
+	case storage.ResourceObject:
+		_, err = h.Storage.GetObject(ctx.Resource.UserID, ctx.Resource.CalendarID, ctx.Resource.ObjectID)
+	case storage.ResourceCollection:
+		_, err = h.Storage.GetCalendar(ctx.Resource.UserID, ctx.Resource.CalendarID)
+	default:
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		// Lock-null resources are gone from RFC 4918, and empty calendar
+		// objects can't be stored
+		h.writeError(w, r, http.StatusNotFound, CodeNotFound)
+		return
+	} else if err != nil {
+		h.Logger.Error("failed to look up resource to lock",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	path, err := h.URLConverter.EncodePath(ctx.Resource)
+	if err != nil {
+		h.Logger.Error("failed to encode path for lock",
+			"resource", ctx.Resource,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.Error("failed to read request body",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
+		return
+	}
+	timeout := parseLockTimeout(r.Header.Get("Timeout"), h.Locks.maxTimeout())
+
+	// An empty body refreshes the lock named in the If header
+	if len(strings.TrimSpace(string(body))) == 0 {
+		tokens := submittedLockTokens(r)
+		if len(tokens) != 1 {
+			h.writeError(w, r, http.StatusBadRequest, CodeInvalidLockRefresh)
+			return
+		}
+		lock, err := h.Locks.refresh(path, tokens[0], lockUser(r), timeout)
+		if errors.Is(err, errLockNotOwned) {
+			h.Logger.Warn("refresh of another principal's lock",
+				"path", path)
+			h.writeError(w, r, http.StatusForbidden, CodeForbidden)
+			return
+		} else if err != nil {
+			h.writePrecondition(w, r, http.StatusPreconditionFailed, "d:lock-token-matches-request-uri", "no such lock on this resource")
+			return
+		}
+		h.writeLockDiscovery(w, r, http.StatusOK, lock)
+		return
+	}
+
+	req, err := lockinfo.ParseRequest(string(body))
+	if err != nil {
+		h.Logger.Warn("invalid lock request",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
+		return
+	}
+	// ServeHTTP rejected other values; without a header locks are deep
+	depth := strings.TrimSpace(r.Header.Get("Depth"))
+	lock, err := h.Locks.create(lockinfo.ActiveLock{
+		Root:     path,
+		Shared:   req.Shared,
+		Infinity: depth != "0" && ctx.Resource.ResourceType == storage.ResourceCollection,
+		Owner:    req.Owner,
+		Timeout:  timeout,
+	}, lockUser(r))
+	if err != nil {
+		h.Logger.Info("lock conflict",
+			"path", path)
+		h.writePrecondition(w, r, http.StatusLocked, "d:no-conflicting-lock", "resource is already locked")
+		return
+	}
+	h.Logger.Info("lock granted",
+		"path", path,
+		"token", lock.Token,
+		"timeout", timeout)
+	w.Header().Set("Lock-Token", "<"+lock.Token+">")
+	h.writeLockDiscovery(w, r, http.StatusOK, lock)
+}
+
+func (h *CaldavHandler) writeLockDiscovery(w http.ResponseWriter, r *http.Request, status int, lock lockinfo.ActiveLock) {
+	xmlOutput, err := h.writeXML(lockinfo.EncodeResponse(lock))
+	if err != nil {
+		h.Logger.Error("failed to serialize XML response",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(xmlOutput))
+}
+
+// handleUnlock removes the lock named in the Lock-Token header.
+func (h *CaldavHandler) handleUnlock(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	if h.Locks == nil {
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+		return
+	}
+	token := strings.TrimSpace(r.Header.Get("Lock-Token"))
+	if !strings.HasPrefix(token, "<") || !strings.HasSuffix(token, ">") {
+		h.writeError(w, r, http.StatusBadRequest, CodeMissingLockToken)
+		return
+	}
+	path, err := h.URLConverter.EncodePath(ctx.Resource)
+	if err != nil {
+		h.writeError(w, r, http.StatusNotFound, CodeNotFound)
+		return
+	}
+	err = h.Locks.remove(path, token[1:len(token)-1], lockUser(r))
+	if errors.Is(err, errLockNotOwned) {
+		// RFC 4918 section 9.11.1: only the lock's creator may remove it
+		h.Logger.Warn("unlock of another principal's lock",
+			"path", path)
+		h.writeError(w, r, http.StatusForbidden, CodeForbidden)
+		return
+	} else if err != nil {
+		h.writePrecondition(w, r, http.StatusConflict, "d:lock-token-matches-request-uri", "no such lock on this resource")
+		return
+	}
+	h.Logger.Info("lock removed",
+		"path", path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowedMethods lists the methods res takes, for the Allow header: objects
+// are read and written, collections created, deleted and posted to.
+func (h *CaldavHandler) allowedMethods(res Resource) string {
+	switch {
+	case h.isScheduleOutbox(res):
+		return "OPTIONS, POST"
+	case h.isScheduleInbox(res):
+		return "OPTIONS, PROPFIND, PROPPATCH"
+	case h.isNotificationResource(res) && res.ResourceType == storage.ResourceObject:
+		return "OPTIONS, PROPFIND, GET, HEAD, DELETE"
+	case h.isNotificationResource(res):
+		return "OPTIONS, PROPFIND"
+	}
+
+	methods := []string{"OPTIONS", "PROPFIND", "PROPPATCH", "REPORT"}
+	switch res.ResourceType {
+	case storage.ResourceCollection:
+		if h.ObjectIDs != nil {
+			methods = append(methods, "PUT") // names the new object, see nameNewObject
+		}
+		if h.features().EnableBulk {
+			methods = append(methods, "POST")
+		}
+		if _, ok := storage.As[storage.DeletableCalendarStorage](h.Storage); ok {
+			methods = append(methods, "DELETE")
+		}
+		methods = append(methods, "MKCALENDAR")
+	case storage.ResourceObject:
+		methods = append(methods, "GET", "PUT", "DELETE")
+	default:
+		return strings.Join(methods, ", ")
+	}
+	if h.Locks != nil {
+		methods = append(methods, "LOCK", "UNLOCK")
+	}
+	return strings.Join(methods, ", ")
+}
+
+// davCompliance is the DAV header value; class 2 is only claimed with
+// locking enabled.
+func (h *CaldavHandler) davCompliance() string {
+	if h.Locks != nil {
+		return "1, 2, 3, calendar-access"
+	}
+	return "1, 3, calendar-access"
+}
+
+func (h *CaldavHandler) writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, res Resource) {
+	h.Logger.Error("method not allowed",
+		"method", r.Method)
+	w.Header().Set("Allow", h.allowedMethods(res))
+	h.writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed)
+}
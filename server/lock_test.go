@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/internal/xml/lockinfo"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exclusiveLockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope><D:exclusive/></D:lockscope>
+  <D:locktype><D:write/></D:locktype>
+  <D:owner>alice</D:owner>
+</D:lockinfo>`
+
+func TestLockDisabled(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	w := serveAlice(h, "LOCK", "/caldav/alice/cal/work/a.ics", exclusiveLockBody, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.NotContains(t, w.Header().Get("Allow"), "LOCK")
+
+	w = serveAlice(h, "OPTIONS", "/caldav/alice/cal/work", "", nil)
+	assert.Equal(t, "1, 3, calendar-access", w.Header().Get("DAV"))
+}
+
+func TestLockUnlock(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Locks = NewLockTable()
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/a.ics", ETag: "etag"}, nil)
+	mockStorage.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrNotFound)
+
+	w := serveAlice(h, "OPTIONS", "/caldav/alice/cal/work", "", nil)
+	assert.Equal(t, "1, 2, 3, calendar-access", w.Header().Get("DAV"))
+	assert.Contains(t, w.Header().Get("Allow"), "LOCK, UNLOCK")
+
+	w = serveAlice(h, "LOCK", "/caldav/alice/cal/work/a.ics", exclusiveLockBody, map[string]string{"Timeout": "Second-60"})
+	require.Equal(t, http.StatusOK, w.Code)
+	token := regexp.MustCompile(`^<(urn:uuid:[^>]+)>$`).FindStringSubmatch(w.Header().Get("Lock-Token"))
+	require.Len(t, token, 2)
+	assert.Contains(t, w.Body.String(), "<d:timeout>Second-60</d:timeout>")
+	assert.Contains(t, w.Body.String(), "<d:owner>alice</d:owner>")
+
+	// A second exclusive lock conflicts
+	w = serveAlice(h, "LOCK", "/caldav/alice/cal/work/a.ics", exclusiveLockBody, nil)
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:no-conflicting-lock/>")
+
+	// Writes need the token
+	w = serveAlice(h, "DELETE", "/caldav/alice/cal/work/a.ics", "", nil)
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:lock-token-submitted/>")
+
+	// Refreshing with an empty body
+	w = serveAlice(h, "LOCK", "/caldav/alice/cal/work/a.ics", "", map[string]string{"If": "(<" + token[1] + ">)", "Timeout": "Infinite"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:timeout>Second-3600</d:timeout>")
+
+	w = serveAlice(h, "UNLOCK", "/caldav/alice/cal/work/b.ics", "", map[string]string{"Lock-Token": "<" + token[1] + ">"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = serveAlice(h, "UNLOCK", "/caldav/alice/cal/work/a.ics", "", map[string]string{"Lock-Token": "<" + token[1] + ">"})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serveAlice(h, "LOCK", "/caldav/alice/cal/work/new.ics", exclusiveLockBody, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCollectionLockBlocksNewMembers(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Locks = NewLockTable()
+	mockStorage.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrNotFound)
+	w := serveAlice(h, "LOCK", "/caldav/alice/cal/work", exclusiveLockBody, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:depth>0</d:depth>")
+
+	w = serveAlice(h, "PUT", "/caldav/alice/cal/work/new.ics", "", map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusLocked, w.Code)
+}
+
+func TestLockTableExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewLockTable()
+	table.now = func() time.Time { return now }
+
+	_, err := table.create(lockActive("/caldav/alice/cal/work", true, time.Minute), "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "/caldav/alice/cal/work", table.blocking("/caldav/alice/cal/work/a.ics", false, nil, "alice"))
+	assert.Empty(t, table.blocking("/caldav/alice/cal/home/a.ics", true, nil, "alice"))
+
+	_, err = table.create(lockActive("/caldav/alice/cal/work/a.ics", false, time.Minute), "alice")
+	assert.ErrorIs(t, err, errLockConflict)
+
+	now = now.Add(2 * time.Minute)
+	assert.Empty(t, table.blocking("/caldav/alice/cal/work/a.ics", false, nil, "alice"))
+}
+
+func TestLockCreator(t *testing.T) {
+	table := NewLockTable()
+	lock, err := table.create(lockActive("/caldav/alice/cal/work", true, time.Minute), "alice")
+	require.NoError(t, err)
+	submitted := []string{lock.Token}
+
+	assert.True(t, table.holds("/caldav/alice/cal/work/a.ics", lock.Token, "alice"))
+	assert.Empty(t, table.blocking("/caldav/alice/cal/work/a.ics", false, submitted, "alice"))
+	assert.Empty(t, table.blockingMember("/caldav/alice/cal", submitted, "alice"))
+
+	// Other principals can't use a token they got hold of
+	assert.False(t, table.holds("/caldav/alice/cal/work/a.ics", lock.Token, "bob"))
+	assert.Equal(t, "/caldav/alice/cal/work", table.blocking("/caldav/alice/cal/work/a.ics", false, submitted, "bob"))
+	assert.Equal(t, "/caldav/alice/cal/work", table.blockingMember("/caldav/alice/cal", submitted, "bob"))
+	_, err = table.refresh("/caldav/alice/cal/work", lock.Token, "bob", time.Minute)
+	assert.ErrorIs(t, err, errLockNotOwned)
+	assert.ErrorIs(t, table.remove("/caldav/alice/cal/work", lock.Token, "bob"), errLockNotOwned)
+
+	_, err = table.refresh("/caldav/alice/cal/work", lock.Token, "alice", time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, table.remove("/caldav/alice/cal/work", lock.Token, "alice"))
+}
+
+func TestParseLockTimeout(t *testing.T) {
+	assert.Equal(t, time.Hour, parseLockTimeout("", time.Hour))
+	assert.Equal(t, time.Hour, parseLockTimeout("Infinite, Second-60", time.Hour))
+	assert.Equal(t, time.Minute, parseLockTimeout("Second-60", time.Hour))
+	assert.Equal(t, time.Hour, parseLockTimeout("Second-4100000000", time.Hour))
+	assert.Equal(t, time.Minute, parseLockTimeout("Second-x, Second-60", time.Hour))
+}
+
+func TestSubmittedLockTokens(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/", nil)
+	r.Header.Set("If", `<http://example.com/a.ics> (<urn:uuid:1> ["etag<x>"]) (Not <urn:uuid:2>)`)
+	assert.Equal(t, []string{"urn:uuid:1", "urn:uuid:2"}, submittedLockTokens(r))
+}
+
+func lockActive(root string, infinity bool, timeout time.Duration) lockinfo.ActiveLock {
+	return lockinfo.ActiveLock{Root: root, Infinity: infinity, Timeout: timeout}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// Locker serializes writes to collections. Keys are collection paths; a
+// write holds its collection's key from reading the current state until the
+// storage call returns, so concurrent PUTs, DELETEs and bulk POSTs to the same
+// calendar can't interleave their precondition checks and writes.
+//
+// MemoryLocker serves a single process. Deployments running several
+// processes against one backend can plug in a distributed implementation,
+// e.g. on database advisory locks or a lease service.
+type Locker interface {
+	// Lock blocks until every key is held or ctx is done, and returns a
+	// function releasing them. Implementations must acquire keys in a fixed
+	// order (SortLockKeys) so callers locking several collections, such as
+	// scheduling deliveries, can't deadlock each other.
+	Lock(ctx context.Context, keys ...string) (unlock func(), err error)
+}
+
+// SortLockKeys sorts keys and drops duplicates, giving the acquisition order
+// every Locker must follow.
+func SortLockKeys(keys []string) []string {
+	sorted := slices.Clone(keys)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// LockStats counts lock activity for metrics.
+type LockStats struct {
+	Acquired  uint64        // Lock calls that got every key
+	Contended uint64        // Lock calls that had to wait for at least one key
+	TimedOut  uint64        // Lock calls given up because ctx was done
+	Waiting   int           // Lock calls currently waiting
+	Held      int           // Keys currently held
+	WaitTime  time.Duration // Total time spent waiting for keys
+}
+
+// MemoryLocker is an in-process Locker. The zero value is ready to use.
+type MemoryLocker struct {
+	mu    sync.Mutex
+	keys  map[string]*memoryLock
+	stats LockStats
+}
+
+type memoryLock struct {
+	ch   chan struct{} // holds a token while the key is locked
+	refs int           // holders and waiters, to know when to drop the entry
+}
+
+// NewMemoryLocker returns an empty MemoryLocker.
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{}
+}
+
+// Lock implements Locker.
+func (l *MemoryLocker) Lock(ctx context.Context, keys ...string) (func(), error) {
+	keys = SortLockKeys(keys)
+	start := time.Now()
+	contended := false
+	for i, key := range keys {
+		lock := l.ref(key)
+		select {
+		case lock.ch <- struct{}{}:
+			continue
+		default:
+		}
+
+		if !contended {
+			contended = true
+			l.mu.Lock()
+			l.stats.Contended++
+			l.stats.Waiting++
+			l.mu.Unlock()
+		}
+		select {
+		case lock.ch <- struct{}{}:
+		case <-ctx.Done():
+			l.unref(key)
+			l.release(keys[:i])
+			l.mu.Lock()
+			l.stats.TimedOut++
+			l.stats.Waiting--
+			l.stats.WaitTime += time.Since(start)
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	l.mu.Lock()
+	l.stats.Acquired++
+	l.stats.Held += len(keys)
+	if contended {
+		l.stats.Waiting--
+		l.stats.WaitTime += time.Since(start)
+	}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(func() { l.release(keys) }) }, nil
+}
+
+// Stats returns lock statistics.
+func (l *MemoryLocker) Stats() LockStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *MemoryLocker) ref(key string) *memoryLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.keys == nil {
+		l.keys = map[string]*memoryLock{}
+	}
+	lock, ok := l.keys[key]
+	if !ok {
+		lock = &memoryLock{ch: make(chan struct{}, 1)}
+		l.keys[key] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+func (l *MemoryLocker) unref(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock := l.keys[key]
+	if lock.refs--; lock.refs == 0 {
+		delete(l.keys, key)
+	}
+}
+
+// release unlocks held keys in reverse order.
+func (l *MemoryLocker) release(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(keys) - 1; i >= 0; i-- {
+		lock := l.keys[keys[i]]
+		<-lock.ch
+		if lock.refs--; lock.refs == 0 {
+			delete(l.keys, keys[i])
+		}
+	}
+	l.stats.Held -= len(keys)
+}
+
+// lockCollection takes the Locker key of the collection holding res for a
+// write, answering the request itself when that fails. The returned function
+// releases it; without a Locker configured it does nothing.
+func (h *CaldavHandler) lockCollection(w http.ResponseWriter, r *http.Request, res Resource) (func(), bool) {
+	if h.Locker == nil {
+		return func() {}, true
+	}
+	key, err := h.URLConverter.EncodePath(Resource{
+		UserID:       res.UserID,
+		CalendarID:   res.CalendarID,
+		ResourceType: storage.ResourceCollection,
+	})
+	if err != nil {
+		h.Logger.Error("failed to encode collection path for locking",
+			"calendar_id", res.CalendarID,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return nil, false
+	}
+	unlock, err := h.Locker.Lock(r.Context(), key)
+	if err != nil {
+		h.Logger.Warn("failed to lock collection",
+			"path", key,
+			"error", err)
+		h.writeError(w, r, http.StatusServiceUnavailable, CodeCollectionBusy)
+		return nil, false
+	}
+	return unlock, true
+}
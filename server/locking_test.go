@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortLockKeys(t *testing.T) {
+	keys := []string{"/b/", "/a/", "/b/"}
+	assert.Equal(t, []string{"/a/", "/b/"}, SortLockKeys(keys))
+	assert.Equal(t, []string{"/b/", "/a/", "/b/"}, keys, "input is left alone")
+}
+
+func TestMemoryLocker(t *testing.T) {
+	l := NewMemoryLocker()
+
+	unlock, err := l.Lock(context.Background(), "/alice/cal/work/")
+	require.NoError(t, err)
+
+	// A second writer waits until the first one is done
+	acquired := make(chan func())
+	go func() {
+		unlock, err := l.Lock(context.Background(), "/alice/cal/work/")
+		assert.NoError(t, err)
+		acquired <- unlock
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired twice")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Other collections aren't affected
+	other, err := l.Lock(context.Background(), "/alice/cal/home/")
+	require.NoError(t, err)
+	other()
+
+	unlock()
+	unlock() // releasing twice is harmless
+	(<-acquired)()
+
+	stats := l.Stats()
+	assert.Equal(t, uint64(3), stats.Acquired)
+	assert.Equal(t, uint64(1), stats.Contended)
+	assert.Zero(t, stats.Held)
+	assert.Zero(t, stats.Waiting)
+	assert.Empty(t, l.keys)
+}
+
+func TestMemoryLockerTimeout(t *testing.T) {
+	l := NewMemoryLocker()
+	unlock, err := l.Lock(context.Background(), "/b/")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Lock(ctx, "/b/", "/a/")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// "/a/" was taken first and must have been given back
+	a, err := l.Lock(context.Background(), "/a/")
+	require.NoError(t, err)
+	a()
+	unlock()
+	assert.Equal(t, uint64(1), l.Stats().TimedOut)
+	assert.Empty(t, l.keys)
+}
+
+func TestMemoryLockerOrdering(t *testing.T) {
+	// Opposite argument orders would deadlock without sorting
+	l := NewMemoryLocker()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			unlock, err := l.Lock(context.Background(), "/x/", "/y/")
+			assert.NoError(t, err)
+			unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			unlock, err := l.Lock(context.Background(), "/y/", "/x/")
+			assert.NoError(t, err)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(100), l.Stats().Acquired)
+}
+
+func TestWriteWaitsForCollectionLock(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Locker = NewMemoryLocker()
+	unlock, err := h.Locker.Lock(context.Background(), "/caldav/alice/cal/work")
+	require.NoError(t, err)
+	defer unlock()
+
+	// Object PROPPATCHes rewrite the object too
+	displayName := `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><D:displayname>x</D:displayname></D:prop></D:set></D:propertyupdate>`
+	for _, method := range []string{"DELETE", "PROPPATCH"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		r := httptest.NewRequest(method, "/caldav/alice/cal/work/a.ics", strings.NewReader(displayName)).WithContext(ctx)
+		r.SetBasicAuth("alice", "password")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		cancel()
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, method)
+	}
+}
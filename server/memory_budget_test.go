@@ -0,0 +1,61 @@
+//go:build !race
+
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// calendarDataMemoryBudget caps the bytes allocated while serving a
+// calendar-data response, as a multiple of the response body. Responses are
+// built as a whole tree and serialized into one buffer, which comes to about
+// 9.7x today; a change that adds another full copy of the response goes over.
+// Lower it when serialization gets cheaper. The race detector inflates
+// allocations several times over, so this file is left out of -race builds.
+const calendarDataMemoryBudget = 11
+
+// serveCounting is serveDiscarding that also returns the bytes allocated
+// while serving.
+func serveCounting(h *CaldavHandler, method, depth, body string) (int, int, uint64) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	code, n := serveDiscarding(h, method, depth, body)
+	runtime.ReadMemStats(&after)
+	return code, n, after.TotalAlloc - before.TotalAlloc
+}
+
+func TestCalendarDataMemoryBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocates several MB")
+	}
+	// 400 events of 10 KB make a response of about 4 MB
+	h, paths := newLargeCollectionHandler(400, 10<<10)
+	for _, sc := range calendarDataScenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			body := sc.body
+			if body == "" {
+				body = multigetBody(paths)
+			}
+			// Warm up lazily initialized state, then keep the best of a few
+			// runs so background allocations don't make the test flaky
+			serveCounting(h, sc.method, sc.depth, body)
+			var size int
+			best := ^uint64(0)
+			for range 3 {
+				code, n, allocated := serveCounting(h, sc.method, sc.depth, body)
+				require.Equal(t, http.StatusMultiStatus, code)
+				size, best = n, min(best, allocated)
+			}
+			require.Greater(t, size, 4<<20)
+			ratio := float64(best) / float64(size)
+			t.Logf("%d byte response, %d bytes allocated (%.1fx)", size, best, ratio)
+			require.LessOrEqualf(t, ratio, float64(calendarDataMemoryBudget),
+				"serving %d bytes of calendar-data allocated %.1fx the response, over the budget of %dx", size, ratio, calendarDataMemoryBudget)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// calendarDataScenarios are the requests that return the calendar-data of a
+// whole collection.
+var calendarDataScenarios = []struct {
+	name, method, depth, body string
+}{
+	{"multiget", "REPORT", "1", ""}, // body built from the object hrefs
+	{"query", "REPORT", "1", `<?xml version="1.0"?><C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/><C:calendar-data/></D:prop><C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"/></C:comp-filter></C:filter></C:calendar-query>`},
+}
+
+// largeStorage answers object lookups from a map, so testify's matching of
+// hundreds of expectations doesn't count against the budget.
+type largeStorage struct {
+	*storage.MockStorage
+	objects []storage.CalendarObject
+	byID    map[string]*storage.CalendarObject
+	paths   []string
+}
+
+func (s *largeStorage) GetObject(userID, calendarID, objectID string) (*storage.CalendarObject, error) {
+	if obj, ok := s.byID[objectID]; ok {
+		return obj, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (s *largeStorage) GetObjectPathsInCollection(calendarID string) ([]string, error) {
+	return s.paths, nil
+}
+
+func (s *largeStorage) GetObjectsInCollection(calendarID string) ([]storage.CalendarObject, error) {
+	return s.objects, nil
+}
+
+func (s *largeStorage) GetObjectByFilter(userID, calendarID string, filter *storage.Filter) ([]storage.CalendarObject, error) {
+	return s.objects, nil
+}
+
+// newLargeCollectionHandler serves alice's work calendar holding count
+// events with a description of size bytes each.
+func newLargeCollectionHandler(count, size int) (*CaldavHandler, []string) {
+	start := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	description := strings.Repeat("lorem ipsum ", size/12+1)[:size]
+	store := &largeStorage{
+		MockStorage: new(storage.MockStorage),
+		objects:     make([]storage.CalendarObject, count),
+		byID:        make(map[string]*storage.CalendarObject, count),
+		paths:       make([]string, count),
+	}
+	for i := range store.objects {
+		id := fmt.Sprintf("event-%04d", i)
+		event := ical.NewComponent(ical.CompEvent)
+		event.Props.SetText(ical.PropUID, id+"@example.com")
+		event.Props.SetText(ical.PropSummary, "Event "+id)
+		event.Props.SetText(ical.PropDescription, description)
+		event.Props.SetDateTime(ical.PropDateTimeStamp, start)
+		event.Props.SetDateTime(ical.PropDateTimeStart, start.Add(time.Duration(i)*time.Hour))
+		event.Props.SetDateTime(ical.PropDateTimeEnd, start.Add(time.Duration(i)*time.Hour+30*time.Minute))
+		store.objects[i] = storage.CalendarObject{
+			Path:      "/alice/cal/work/" + id + ".ics",
+			ETag:      `"` + id + `"`,
+			Component: []*ical.Component{event},
+		}
+		store.byID[id+".ics"] = &store.objects[i]
+		store.paths[i] = store.objects[i].Path
+	}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{
+		Path:                "/alice/cal/work",
+		SupportedComponents: []string{ical.CompEvent},
+		CalendarData:        ical.NewCalendar(),
+	}, nil)
+	h := NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return h, store.paths
+}
+
+func multigetBody(paths []string) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/><C:calendar-data/></D:prop>`)
+	for _, p := range paths {
+		sb.WriteString("<D:href>/caldav" + p + "</D:href>")
+	}
+	sb.WriteString(`</C:calendar-multiget>`)
+	return sb.String()
+}
+
+// discardRecorder is a ResponseWriter that only counts the bytes written, so
+// the test's own buffering doesn't count against the budget.
+type discardRecorder struct {
+	header http.Header
+	code   int
+	n      int
+}
+
+func (d *discardRecorder) Header() http.Header  { return d.header }
+func (d *discardRecorder) WriteHeader(code int) { d.code = code }
+func (d *discardRecorder) Write(p []byte) (int, error) {
+	d.n += len(p)
+	return len(p), nil
+}
+
+// serveDiscarding serves one request on alice's work calendar and returns
+// the status and the body size.
+func serveDiscarding(h *CaldavHandler, method, depth, body string) (int, int) {
+	r := httptest.NewRequest(method, "/caldav/alice/cal/work/", strings.NewReader(body))
+	r.SetBasicAuth("alice", "password")
+	r.Header.Set("Depth", depth)
+	w := &discardRecorder{header: http.Header{}, code: http.StatusOK}
+	h.ServeHTTP(w, r)
+	return w.code, w.n
+}
+
+func BenchmarkCalendarDataResponse(b *testing.B) {
+	h, paths := newLargeCollectionHandler(400, 10<<10)
+	for _, sc := range calendarDataScenarios {
+		b.Run(sc.name, func(b *testing.B) {
+			body := sc.body
+			if body == "" {
+				body = multigetBody(paths)
+			}
+			_, size := serveDiscarding(h, sc.method, sc.depth, body)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				serveDiscarding(h, sc.method, sc.depth, body)
+			}
+		})
+	}
+}
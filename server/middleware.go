@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// Middleware wraps the handler's method dispatch. It runs after
+// authentication, path parsing and the built-in access check, so
+// RequestContextFrom(r.Context()) returns the parsed Resource and the
+// authenticated principal. A middleware may answer the request itself (e.g. to
+// rate limit or deny it), adjust the request or RequestContext, or wrap the
+// ResponseWriter before calling next.
+type Middleware func(next http.Handler) http.Handler
+
+// Use appends middleware to the chain. The first middleware registered is the
+// outermost. Register middleware before serving; Use is not safe to call
+// concurrently with ServeHTTP.
+func (h *CaldavHandler) Use(mw ...Middleware) {
+	h.middlewares = append(h.middlewares, mw...)
+}
+
+type requestContextKey struct{}
+
+// RequestContextFrom returns the RequestContext of the request being served.
+// It is available to middleware and to anything they call with the request's
+// context.
+func RequestContextFrom(ctx context.Context) (*RequestContext, bool) {
+	rc, ok := ctx.Value(requestContextKey{}).(*RequestContext)
+	return rc, ok
+}
+
+// chain builds the middleware chain around dispatch.
+func (h *CaldavHandler) chain() http.Handler {
+	var next http.Handler = http.HandlerFunc(h.dispatch)
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		next = h.middlewares[i](next)
+	}
+	return next
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+
+	var calls []string
+	var seen *RequestContext
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h.Use(tag("outer"), tag("middle"))
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen, _ = RequestContextFrom(r.Context())
+			w.Header().Set("X-Quirk", "applied")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	r := httptest.NewRequest("OPTIONS", "/caldav/alice/cal/work", nil)
+	r.SetBasicAuth("alice", "password")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"outer", "middle"}, calls)
+	assert.Equal(t, "applied", w.Header().Get("X-Quirk"))
+	if assert.NotNil(t, seen) {
+		assert.Equal(t, "alice", seen.AuthUser)
+		assert.Equal(t, storage.ResourceCollection, seen.Resource.ResourceType)
+		assert.Equal(t, "work", seen.Resource.CalendarID)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rc, _ := RequestContextFrom(r.Context()); rc.Resource.ResourceType == storage.ResourceCollection {
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	for path, expected := range map[string]int{
+		"/caldav/alice/cal/work": http.StatusTooManyRequests,
+		"/caldav/alice":          http.StatusOK,
+	} {
+		r := httptest.NewRequest("OPTIONS", path, nil)
+		r.SetBasicAuth("alice", "password")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, expected, w.Code, path)
+	}
+
+	// Middleware only runs for authenticated requests
+	called := false
+	h.Use(func(next http.Handler) http.Handler {
+		called = true
+		return next
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/caldav/alice", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, called)
+}
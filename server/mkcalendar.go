@@ -20,7 +20,22 @@ func (h *CaldavHandler) handleMkCalendar(w http.ResponseWriter, r *http.Request,
 	if ctx.Resource.ResourceType != storage.ResourceCollection {
 		h.Logger.Warn("mkcalendar not allowed on this resource type",
 			"resource_type", ctx.Resource.ResourceType)
-		http.Error(w, "Method Not Allowed: MKCALENDAR can only be used to create a calendar collection", http.StatusMethodNotAllowed)
+		h.writeError(w, r, http.StatusMethodNotAllowed, CodeCalendarLocation)
+		return
+	}
+
+	remaining, err := h.calendarsRemaining(ctx.Resource.UserID)
+	if err != nil {
+		h.Logger.Error("failed to count calendars for limit check",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if remaining == 0 {
+		h.Logger.Warn("calendar limit reached",
+			"user_id", ctx.Resource.UserID,
+			"limit", h.Limits.MaxCalendars)
+		h.writeQuotaExceeded(w, r, "calendars per user", h.Limits.MaxCalendars)
 		return
 	}
 
@@ -29,14 +44,14 @@ func (h *CaldavHandler) handleMkCalendar(w http.ResponseWriter, r *http.Request,
 	if err != nil {
 		h.Logger.Error("failed to read request body",
 			"error", err)
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
 		return
 	}
 	properties, err := mkcalendar.ParseRequest(string(bodyBytes))
 	if err != nil {
 		h.Logger.Error("failed to parse mkcalendar request",
 			"error", err)
-		http.Error(w, "Failed to parse MKCALENDAR request", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
 		return
 	}
 
@@ -47,7 +62,7 @@ func (h *CaldavHandler) handleMkCalendar(w http.ResponseWriter, r *http.Request,
 	// Default to a basic VCALENDAR structure
 	cal.CalendarData = ical.NewCalendar()
 	cal.CalendarData.Props.SetText(ical.PropProductID, "-//libcaldora//CalDAV Server//EN")
-	cal.CalendarData.Props.SetText(ical.PropVersion, "2.0")
+	cal.CalendarData.Props.SetText(ical.PropVersion, icalVersion)
 
 	// Process provided properties
 	for key, prop := range properties {
@@ -55,6 +70,9 @@ func (h *CaldavHandler) handleMkCalendar(w http.ResponseWriter, r *http.Request,
 		case "displayname":
 			if dn, ok := prop.(*props.DisplayName); ok && dn.Value != "" {
 				cal.CalendarData.Props.SetText(ical.PropName, dn.Value)
+				if dn.Lang != "" {
+					cal.CalendarData.Props.Get(ical.PropName).Params.Set(ical.ParamLanguage, dn.Lang)
+				}
 				h.Logger.Debug("setting calendar name",
 					"name", dn.Value)
 			}
@@ -105,6 +123,10 @@ func (h *CaldavHandler) handleMkCalendar(w http.ResponseWriter, r *http.Request,
 				h.Logger.Debug("setting Google timezone",
 					"timezone", tz.Value)
 			}
+		case "hidden":
+			if hidden, ok := prop.(*props.Hidden); ok {
+				cal.Hidden = hidden.Value
+			}
 		default:
 			// Ignore unknown or unsupported properties
 			h.Logger.Debug("ignoring unsupported property",
@@ -121,14 +143,12 @@ func (h *CaldavHandler) handleMkCalendar(w http.ResponseWriter, r *http.Request,
 
 	err = h.Storage.CreateCalendar(ctx.Resource.UserID, cal)
 	if err != nil {
-		h.Logger.Error("failed to create calendar",
-			"error", err)
-		http.Error(w, "Failed to create calendar", http.StatusInternalServerError)
+		h.writeStorageError(w, r, err, CodeStorage)
 		return
 	}
 	if cal.ETag == "" || cal.Path == "" {
 		h.Logger.Error("calendar created but ETag or Path is empty")
-		http.Error(w, "Failed to create calendar", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
 		return
 	}
 
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -114,10 +115,27 @@ func TestHandleMkCalendar(t *testing.T) {
 </C:mkcalendar>`,
 			setupMocks: func() {
 				mockStorage.On("CreateCalendar", userID, mock.AnythingOfType("*storage.Calendar")).
-					Return(storage.ErrInvalidInput).Once()
+					Return(errors.New("disk failure")).Once()
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
+		{
+			name:         "Storage rejects input",
+			resourceType: storage.ResourceCollection,
+			xmlBody: `<?xml version="1.0" encoding="utf-8"?>
+<C:mkcalendar xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:D="DAV:">
+  <D:set>
+    <D:prop>
+      <D:displayname>Error Calendar</D:displayname>
+    </D:prop>
+  </D:set>
+</C:mkcalendar>`,
+			setupMocks: func() {
+				mockStorage.On("CreateCalendar", userID, mock.AnythingOfType("*storage.Calendar")).
+					Return(storage.ErrInvalidInput).Once()
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:         "Path not set by storage",
 			resourceType: storage.ResourceCollection,
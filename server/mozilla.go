@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cyp0633/libcaldora/internal/xml/proppatch"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// NamespaceMozilla holds the alarm properties Thunderbird / Lightning keeps on
+// events. A PROPPATCH on a calendar object setting x-moz-lastack or
+// x-moz-snooze-time (or x-moz-snooze-time-<id> for an occurrence) in this
+// namespace writes the X-MOZ- property of the same name into the object's
+// master component, so dismissing an alarm doesn't need a full PUT.
+const NamespaceMozilla = "http://mozilla.org/calendar/"
+
+// Properties Thunderbird uses to sync alarm dismissal and snoozing.
+const (
+	PropMozLastAck    = "X-MOZ-LASTACK"
+	PropMozSnoozeTime = "X-MOZ-SNOOZE-TIME"
+)
+
+// mozillaProperty returns the iCalendar property a PROPPATCH element in
+// NamespaceMozilla stands for.
+func mozillaProperty(name string) (string, bool) {
+	prop := strings.ToUpper(name)
+	switch {
+	case prop == PropMozLastAck, prop == PropMozSnoozeTime:
+		return prop, true
+	case strings.HasPrefix(prop, PropMozSnoozeTime+"-") && len(prop) > len(PropMozSnoozeTime)+1:
+		return prop, true
+	}
+	return "", false
+}
+
+// patchMozillaAlarm sets or removes an X-MOZ- alarm property of a calendar
+// object. Values are UTC date-times like 20240315T104501Z.
+func patchMozillaAlarm(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	name, ok := mozillaProperty(op.Name)
+	if !ok {
+		return nil, http.StatusForbidden
+	}
+	var value string
+	if !op.Remove {
+		value = strings.TrimSpace(op.Element.Text())
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			h.Logger.Warn("invalid mozilla alarm property",
+				"property", name,
+				"value", value)
+			return nil, http.StatusBadRequest
+		}
+		value = t.Format("20060102T150405Z")
+	}
+	return h.patchObject(ctx, func(_ *storage.CalendarObject, master *ical.Component) {
+		if op.Remove {
+			master.Props.Del(name)
+		} else {
+			prop := ical.NewProp(name)
+			prop.Value = value
+			master.Props.Set(prop)
+		}
+	})
+}
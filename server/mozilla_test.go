@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mozillaPatch(instruction, props string) string {
+	return `<D:propertyupdate xmlns:D="DAV:" xmlns:M="http://mozilla.org/calendar/">
+  <D:` + instruction + `><D:prop>` + props + `</D:prop></D:` + instruction + `>
+</D:propertyupdate>`
+}
+
+func TestMozillaAlarmProppatch(t *testing.T) {
+	h := newXPropHandler(t)
+	data, _ := readXPropSample(t, "thunderbird")
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/thunderbird.ics", data, map[string]string{"Content-Type": "text/calendar"})
+	require.Less(t, w.Code, 300, w.Body.String())
+	get := func() string {
+		w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/thunderbird.ics", "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work/thunderbird.ics",
+		mozillaPatch("set", `<M:x-moz-lastack>20240314T085100Z</M:x-moz-lastack><M:x-moz-snooze-time>20240314T090500Z</M:x-moz-snooze-time>`), nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 200 OK")
+	body := get()
+	assert.Contains(t, body, "X-MOZ-LASTACK:20240314T085100Z")
+	assert.Contains(t, body, "X-MOZ-SNOOZE-TIME:20240314T090500Z")
+	assert.Contains(t, body, "X-MOZ-SNOOZE-TIME-1710320400000000:20240313T085500Z")
+	assert.Contains(t, body, "X-MOZ-GENERATION:3")
+
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work/thunderbird.ics",
+		mozillaPatch("remove", `<M:x-moz-snooze-time-1710320400000000/>`), nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.NotContains(t, get(), "X-MOZ-SNOOZE-TIME-1710320400000000")
+
+	// Invalid values and other X-MOZ- properties fail the whole request
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work/thunderbird.ics",
+		mozillaPatch("set", `<M:x-moz-lastack>yesterday</M:x-moz-lastack>`), nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 400 Bad Request")
+	w = serveAlice(h, "PROPPATCH", "/caldav/alice/cal/work/thunderbird.ics",
+		mozillaPatch("set", `<M:x-moz-generation>4</M:x-moz-generation><M:x-moz-lastack>20240315T085100Z</M:x-moz-lastack>`), nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 424 Failed Dependency")
+	body = get()
+	assert.Contains(t, body, "X-MOZ-LASTACK:20240314T085100Z")
+	assert.Contains(t, body, "X-MOZ-GENERATION:3")
+
+	// Dismissals wait for concurrent writes to the calendar
+	h.Locker = NewMemoryLocker()
+	unlock, err := h.Locker.Lock(context.Background(), "/caldav/alice/cal/work")
+	require.NoError(t, err)
+	defer unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work/thunderbird.ics",
+		strings.NewReader(mozillaPatch("set", `<M:x-moz-lastack>20240315T085100Z</M:x-moz-lastack>`))).WithContext(ctx)
+	r.SetBasicAuth("alice", "password")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, get(), "X-MOZ-LASTACK:20240314T085100Z")
+}
+
+func TestMozillaAlarmFilter(t *testing.T) {
+	h := newXPropHandler(t)
+	for _, name := range []string{"apple", "thunderbird"} {
+		data, _ := readXPropSample(t, name)
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/"+name+".ics", data, map[string]string{"Content-Type": "text/calendar"})
+		require.Less(t, w.Code, 300, w.Body.String())
+	}
+	query := func(filter string) string {
+		body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">` + filter + `</C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+		w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+
+	body := query(`<C:prop-filter name="X-MOZ-LASTACK"/>`)
+	assert.Contains(t, body, "thunderbird.ics")
+	assert.NotContains(t, body, "apple.ics")
+
+	body = query(`<C:prop-filter name="X-MOZ-LASTACK"><C:is-not-defined/></C:prop-filter>`)
+	assert.Contains(t, body, "apple.ics")
+	assert.NotContains(t, body, "thunderbird.ics")
+
+	body = query(`<C:prop-filter name="X-MOZ-SNOOZE-TIME-1710320400000000"><C:text-match>20240313</C:text-match></C:prop-filter>`)
+	assert.Contains(t, body, "thunderbird.ics")
+	assert.NotContains(t, body, "apple.ics")
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/icsdiff"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// NormalizePolicy selects which iCalendar bookkeeping properties the handler
+// maintains on PUT, for clients that forget to.
+type NormalizePolicy int
+
+const (
+	// NormalizeNone stores objects exactly as the client sent them. This is the default.
+	NormalizeNone NormalizePolicy = iota
+	// NormalizeTimestamps sets DTSTAMP and LAST-MODIFIED to the time of the write.
+	NormalizeTimestamps
+	// NormalizeScheduling does what NormalizeTimestamps does, and also raises
+	// SEQUENCE when a significant property (RFC 5546 section 2.1.4) changed
+	// without the client incrementing it. SEQUENCE never goes down.
+	NormalizeScheduling
+)
+
+// significantProps are the properties whose change requires a new SEQUENCE.
+var significantProps = []string{
+	ical.PropDateTimeStart,
+	ical.PropDateTimeEnd,
+	ical.PropDuration,
+	ical.PropDue,
+	ical.PropRecurrenceRule,
+	ical.PropRecurrenceDates,
+	ical.PropExceptionDates,
+	ical.PropStatus,
+}
+
+// normalizeComponents applies h.NormalizePolicy to the components of a PUT.
+// previous is the stored object being replaced, or nil on creation.
+func (h *CaldavHandler) normalizeComponents(comps []*ical.Component, previous *storage.CalendarObject, now time.Time) {
+	if h.NormalizePolicy == NormalizeNone {
+		return
+	}
+	now = now.UTC().Truncate(time.Second)
+
+	old := map[string]*ical.Component{}
+	if previous != nil {
+		for _, comp := range previous.Component {
+			old[instanceKey(comp)] = comp
+		}
+	}
+
+	for _, comp := range comps {
+		if comp.Name == ical.CompTimezone {
+			continue
+		}
+		comp.Props.SetDateTime(ical.PropDateTimeStamp, now)
+		comp.Props.SetDateTime(ical.PropLastModified, now)
+
+		prev, ok := old[instanceKey(comp)]
+		if h.NormalizePolicy != NormalizeScheduling || !ok {
+			continue
+		}
+		oldSeq, newSeq := sequence(prev), sequence(comp)
+		seq := max(oldSeq, newSeq)
+		if newSeq <= oldSeq && significantChange(prev, comp) {
+			seq = oldSeq + 1
+		}
+		if seq != newSeq {
+			h.Logger.Debug("adjusting sequence",
+				"uid", instanceKey(comp),
+				"from", newSeq,
+				"to", seq)
+			comp.Props.SetText(ical.PropSequence, strconv.Itoa(seq))
+		}
+	}
+}
+
+// instanceKey identifies a component within an object by UID and RECURRENCE-ID.
+func instanceKey(comp *ical.Component) string {
+	uid, _ := comp.Props.Text(ical.PropUID)
+	key := comp.Name + "/" + uid
+	if rid := comp.Props.Get(ical.PropRecurrenceID); rid != nil {
+		key += "/" + rid.Value
+	}
+	return key
+}
+
+func sequence(comp *ical.Component) int {
+	prop := comp.Props.Get(ical.PropSequence)
+	if prop == nil {
+		return 0
+	}
+	seq, _ := strconv.Atoi(strings.TrimSpace(prop.Value))
+	return seq
+}
+
+func significantChange(a, b *ical.Component) bool {
+	for _, name := range significantProps {
+		if !icsdiff.PropertyEqual(a, b, name) {
+			return true
+		}
+	}
+	return false
+}
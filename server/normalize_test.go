@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func normalizeTestEvent(t *testing.T, extra string) *ical.Component {
+	t.Helper()
+	comps, err := decodeCalendarComponents("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:meeting\r\nDTSTAMP:20200101T000000Z\r\n" + extra + "END:VEVENT\r\nEND:VCALENDAR\r\n")
+	require.NoError(t, err)
+	return comps[0]
+}
+
+func TestNormalizeComponents(t *testing.T) {
+	h := NewCaldavHandler("/caldav/", "test", nil, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	previous := &storage.CalendarObject{Component: []*ical.Component{
+		normalizeTestEvent(t, "DTSTART:20250310T090000Z\r\nSUMMARY:Sync\r\nSEQUENCE:2\r\n"),
+	}}
+
+	tests := []struct {
+		name     string
+		policy   NormalizePolicy
+		extra    string
+		previous *storage.CalendarObject
+		stamp    string
+		seq      string
+	}{
+		{"none", NormalizeNone, "DTSTART:20250311T090000Z\r\n", previous, "20200101T000000Z", ""},
+		{"timestamps only", NormalizeTimestamps, "DTSTART:20250311T090000Z\r\n", previous, "20250301T120000Z", ""},
+		{"rescheduled", NormalizeScheduling, "DTSTART:20250311T090000Z\r\nSEQUENCE:2\r\n", previous, "20250301T120000Z", "3"},
+		{"client bumped", NormalizeScheduling, "DTSTART:20250311T090000Z\r\nSEQUENCE:5\r\n", previous, "20250301T120000Z", "5"},
+		{"cosmetic change", NormalizeScheduling, "DTSTART:20250310T090000Z\r\nSUMMARY:Weekly sync\r\n", previous, "20250301T120000Z", "2"},
+		{"created", NormalizeScheduling, "DTSTART:20250311T090000Z\r\n", nil, "20250301T120000Z", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.NormalizePolicy = tt.policy
+			comp := normalizeTestEvent(t, tt.extra)
+			h.normalizeComponents([]*ical.Component{comp}, tt.previous, now)
+
+			assert.Equal(t, tt.stamp, comp.Props.Get(ical.PropDateTimeStamp).Value)
+			if tt.policy != NormalizeNone {
+				assert.Equal(t, tt.stamp, comp.Props.Get(ical.PropLastModified).Value)
+			}
+			seq := ""
+			if prop := comp.Props.Get(ical.PropSequence); prop != nil {
+				seq = strings.TrimSpace(prop.Value)
+			}
+			assert.Equal(t, tt.seq, seq)
+		})
+	}
+}
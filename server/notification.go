@@ -0,0 +1,414 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/google/uuid"
+	"github.com/samber/mo"
+)
+
+// NotificationCollection is the ID of a user's notification collection in
+// the calendar home, published as cs:notification-url. With
+// storage.NotificationStorage no calendar can use the ID.
+const NotificationCollection = "notification"
+
+// Notification types the handler generates.
+const (
+	// NotificationResourceChanged tells an organizer that an attendee
+	// accepted, declined or otherwise answered an event
+	NotificationResourceChanged = "resource-changed"
+	// NotificationInvite tells a user a calendar was shared with them, or
+	// that the share was changed or removed
+	NotificationInvite = "invite-notification"
+)
+
+// notificationStorage returns the storage as storage.NotificationStorage.
+func (h *CaldavHandler) notificationStorage() (storage.NotificationStorage, bool) {
+	notes, ok := storage.As[storage.NotificationStorage](h.Storage)
+	return notes, ok
+}
+
+// isNotificationResource reports whether res is a notification collection or
+// a notification in one.
+func (h *CaldavHandler) isNotificationResource(res Resource) bool {
+	if _, ok := h.notificationStorage(); !ok || res.CalendarID != NotificationCollection {
+		return false
+	}
+	return res.ResourceType == storage.ResourceCollection || res.ResourceType == storage.ResourceObject
+}
+
+// handleNotifications serves a notification collection. Only its owner can
+// read it, and delete notifications from it.
+func (h *CaldavHandler) handleNotifications(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	notes, _ := h.notificationStorage()
+	if ctx.AuthUser != ctx.Resource.UserID {
+		h.denyAccess(w, r)
+		return
+	}
+	object := ctx.Resource.ResourceType == storage.ResourceObject
+
+	switch {
+	case r.Method == "OPTIONS":
+		h.handleOptions(w, r, ctx)
+	case r.Method == "PROPFIND":
+		h.handleNotificationPropfind(w, r, ctx, notes)
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && object:
+		n, err := findNotification(notes, ctx.Resource.UserID, ctx.Resource.ObjectID)
+		if err != nil {
+			h.writeStorageError(w, r, err, CodeStorage)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("ETag", notificationETag(n))
+		w.Header().Set("Last-Modified", n.Created.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			io.WriteString(w, n.XML)
+		}
+	case r.Method == http.MethodDelete && object:
+		if err := notes.DeleteNotification(ctx.Resource.UserID, ctx.Resource.ObjectID); err != nil {
+			h.writeStorageError(w, r, err, CodeStorage)
+			return
+		}
+		h.Logger.Info("notification deleted",
+			"user_id", ctx.Resource.UserID,
+			"notification", ctx.Resource.ObjectID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+	}
+}
+
+func (h *CaldavHandler) handleNotificationPropfind(w http.ResponseWriter, r *http.Request, ctx *RequestContext, notes storage.NotificationStorage) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
+		return
+	}
+	req := notificationPropRequest(propfind.ParseRequest(string(body)))
+	list, err := notes.GetNotifications(ctx.Resource.UserID)
+	if err != nil {
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+
+	collection := ctx.Resource
+	collection.ResourceType = storage.ResourceCollection
+	collection.ObjectID = ""
+	var docs []*etree.Document
+	if ctx.Resource.ResourceType == storage.ResourceObject {
+		var found bool
+		for _, n := range list {
+			if n.ID == ctx.Resource.ObjectID {
+				doc, err := h.notificationResponse(req, collection, &n)
+				if err != nil {
+					h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+					return
+				}
+				docs, found = append(docs, doc), true
+			}
+		}
+		if !found {
+			h.writeError(w, r, http.StatusNotFound, CodeNotFound)
+			return
+		}
+	} else {
+		doc, err := h.notificationResponse(req, collection, nil)
+		if err != nil {
+			h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+			return
+		}
+		docs = append(docs, doc)
+		for i := range list {
+			if ctx.Depth == 0 {
+				break
+			}
+			doc, err := h.notificationResponse(req, collection, &list[i])
+			if err != nil {
+				h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+				return
+			}
+			docs = append(docs, doc)
+		}
+	}
+	h.writeMultistatus(w, r, docs)
+}
+
+// notificationPropRequest returns the properties a PROPFIND of type reqType
+// asks of notifications. Other properties don't exist here, so allprop asks
+// for these.
+func notificationPropRequest(req propfind.ResponseMap, reqType propfind.RequestType) propfind.ResponseMap {
+	if reqType == propfind.RequestTypeProp {
+		return req
+	}
+	req = propfind.ResponseMap{}
+	for _, name := range []string{"resourcetype", "displayname", "getetag", "getcontenttype", "getlastmodified", "notificationtype"} {
+		req[name] = mo.Err[props.Property](propfind.ErrNotFound)
+	}
+	return req
+}
+
+// appendNotificationCollection appends userID's notification collection to
+// resources, for listing it in the calendar home. Without notification
+// storage there is none.
+func (h *CaldavHandler) appendNotificationCollection(resources []Resource, userID string) ([]Resource, error) {
+	if _, ok := h.notificationStorage(); !ok {
+		return resources, nil
+	}
+	path, err := h.URLConverter.EncodePath(Resource{UserID: userID, CalendarID: NotificationCollection, ResourceType: storage.ResourceCollection})
+	if err != nil {
+		h.Logger.Error("failed to encode notification collection path",
+			"user_id", userID,
+			"error", err)
+		return nil, err
+	}
+	resource, err := h.URLConverter.ParsePath(path)
+	if err != nil {
+		h.Logger.Error("failed to parse notification collection path",
+			"path", path,
+			"error", err)
+		return nil, err
+	}
+	return append(resources, resource), nil
+}
+
+// notificationResponse resolves the properties of the notification
+// collection, or of n in it.
+func (h *CaldavHandler) notificationResponse(req propfind.ResponseMap, collection Resource, n *storage.Notification) (*etree.Document, error) {
+	res := collection
+	if n != nil {
+		res.ObjectID = n.ID
+		res.ResourceType = storage.ResourceObject
+	}
+	href, err := h.URLConverter.EncodePath(res)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(propfind.ResponseMap, len(req))
+	for name := range req {
+		var value props.Property
+		switch {
+		case name == "resourcetype" && n == nil:
+			value = &props.Resourcetype{Type: props.ResourceCollection, ObjectType: "notification"}
+		case name == "resourcetype":
+			value = &props.Resourcetype{Type: props.ResourceObject}
+		case name == "displayname" && n == nil:
+			value = &props.DisplayName{Value: "Notifications"}
+		case name == "getetag" && n != nil:
+			value = &props.GetEtag{Value: notificationETag(n)}
+		case name == "getcontenttype" && n != nil:
+			value = &props.GetContentType{Value: "application/xml; charset=utf-8"}
+		case name == "getlastmodified" && n != nil:
+			value = &props.GetLastModified{Value: n.Created}
+		case name == "notificationtype" && n != nil:
+			value = &props.NotificationType{Value: n.Type}
+		}
+		if value == nil {
+			resolved[name] = mo.Err[props.Property](propfind.ErrNotFound)
+		} else {
+			resolved[name] = mo.Ok(value)
+		}
+	}
+	return propfind.EncodeResponse(resolved, href), nil
+}
+
+func findNotification(notes storage.NotificationStorage, userID, id string) (*storage.Notification, error) {
+	list, err := notes.GetNotifications(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if list[i].ID == id {
+			return &list[i], nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// notificationETag derives an ETag from the notification's ID and time, as
+// notifications never change.
+func notificationETag(n *storage.Notification) string {
+	return `"` + n.ID + "-" + strconv.FormatInt(n.Created.UnixNano(), 36) + `"`
+}
+
+// notify stores a notification of the given type for userID. body is the
+// element the cs:notification carries.
+func (h *CaldavHandler) notify(userID, typ string, body *etree.Element, now time.Time) error {
+	notes, ok := h.notificationStorage()
+	if !ok {
+		return nil
+	}
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="utf-8"`)
+	root := doc.CreateElement("cs:notification")
+	root.CreateAttr("xmlns:cs", props.NamespaceMap["cs"])
+	root.CreateAttr("xmlns:d", props.NamespaceMap["d"])
+	root.CreateAttr("xmlns:lc", props.NamespaceMap["lc"])
+	root.CreateElement("cs:dtstamp").SetText(now.UTC().Format("20060102T150405Z"))
+	root.AddChild(body)
+	data, err := doc.WriteToString()
+	if err != nil {
+		return err
+	}
+	return notes.AddNotification(userID, storage.Notification{
+		ID:      uuid.NewString() + ".xml",
+		Type:    typ,
+		Created: now,
+		XML:     data,
+	})
+}
+
+// notifyAttendeeChanges tells the owner of a calendar when a write changed
+// the participation status of attendees of events the owner organizes, e.g.
+// when an attendee's reply was applied. Changes of the owner's own status
+// aren't reported.
+func (h *CaldavHandler) notifyAttendeeChanges(res Resource, previous, updated []*ical.Component) {
+	if _, ok := h.notificationStorage(); !ok || len(previous) == 0 {
+		return
+	}
+	user, err := h.Storage.GetUser(res.UserID)
+	if err != nil || user == nil || user.UserAddress == "" {
+		return
+	}
+	owner := normalizeAddress(user.UserAddress)
+	href, err := h.URLConverter.EncodePath(res)
+	if err != nil {
+		return
+	}
+
+	old := map[string]*ical.Component{}
+	for _, comp := range previous {
+		old[instanceKey(comp)] = comp
+	}
+	now := time.Now()
+	for _, comp := range updated {
+		prev, ok := old[instanceKey(comp)]
+		organizer := comp.Props.Get(ical.PropOrganizer)
+		if !ok || organizer == nil || normalizeAddress(organizer.Value) != owner {
+			continue
+		}
+		before := map[string]string{}
+		for _, attendee := range prev.Props.Values(ical.PropAttendee) {
+			before[normalizeAddress(attendee.Value)] = partStat(attendee)
+		}
+		for _, attendee := range comp.Props.Values(ical.PropAttendee) {
+			address := normalizeAddress(attendee.Value)
+			status, known := before[address]
+			if address == owner || !known || status == partStat(attendee) {
+				continue
+			}
+			body := attendeeChangeElement(href, comp, attendee, now)
+			if err := h.notify(res.UserID, NotificationResourceChanged, body, now); err != nil {
+				h.Logger.Error("failed to store notification",
+					"user_id", res.UserID,
+					"attendee", address,
+					"error", err)
+			}
+		}
+	}
+}
+
+// partStat returns the PARTSTAT of an ATTENDEE, NEEDS-ACTION when unset.
+func partStat(attendee ical.Prop) string {
+	if status := attendee.Params.Get(ical.ParamParticipationStatus); status != "" {
+		return status
+	}
+	return "NEEDS-ACTION"
+}
+
+// attendeeChangeElement builds a cs:resource-changed telling that attendee
+// changed its PARTSTAT in comp, an instance of the object at href.
+func attendeeChangeElement(href string, comp *ical.Component, attendee ical.Prop, now time.Time) *etree.Element {
+	changed := etree.NewElement("cs:resource-changed")
+	changed.CreateElement("d:href").SetText(href)
+	by := changed.CreateElement("cs:changed-by")
+	if cn := attendee.Params.Get(ical.ParamCommonName); cn != "" {
+		by.CreateElement("cs:common-name").SetText(cn)
+	}
+	by.CreateElement("cs:dtstamp").SetText(now.UTC().Format("20060102T150405Z"))
+	by.CreateElement("d:href").SetText(attendee.Value)
+
+	recurrence := changed.CreateElement("cs:updated").CreateElement("cs:calendar-changes").CreateElement("cs:recurrence")
+	if rid := comp.Props.Get(ical.PropRecurrenceID); rid != nil {
+		recurrence.CreateElement("cs:recurrenceid").SetText(rid.Value)
+	} else {
+		recurrence.CreateElement("cs:master")
+	}
+	property := recurrence.CreateElement("cs:changes").CreateElement("cs:changed-property")
+	property.CreateAttr("name", ical.PropAttendee)
+	property.CreateElement("cs:changed-parameter").CreateAttr("name", ical.ParamParticipationStatus)
+	changed.CreateElement("lc:partstat").SetText(partStat(attendee))
+	return changed
+}
+
+// NotifyShared tells shareeID that ownerID shared a calendar with them with
+// privs, or stopped sharing it when privs is zero. Storage decides who can
+// access what, so applications call this when they change it. It does nothing
+// without storage.NotificationStorage.
+func (h *CaldavHandler) NotifyShared(ownerID, calendarID, shareeID string, privs storage.Privilege) error {
+	if _, ok := h.notificationStorage(); !ok {
+		return nil
+	}
+	cal, err := h.Storage.GetCalendar(ownerID, calendarID)
+	if err != nil {
+		return err
+	}
+	calendarHref, err := h.URLConverter.EncodePath(Resource{UserID: ownerID, CalendarID: calendarID, ResourceType: storage.ResourceCollection})
+	if err != nil {
+		return err
+	}
+	ownerHref, err := h.URLConverter.EncodePath(Resource{UserID: ownerID, ResourceType: storage.ResourcePrincipal})
+	if err != nil {
+		return err
+	}
+	shareeHref, err := h.URLConverter.EncodePath(Resource{UserID: shareeID, ResourceType: storage.ResourcePrincipal})
+	if err != nil {
+		return err
+	}
+
+	invite := etree.NewElement("cs:invite-notification")
+	invite.CreateAttr("shared-type", "calendar")
+	invite.CreateElement("cs:uid").SetText(ownerID + "/" + calendarID + "/" + shareeID)
+	invite.CreateElement("d:href").SetText(shareeHref)
+	switch {
+	case privs == 0:
+		invite.CreateElement("cs:invite-deleted")
+	default:
+		invite.CreateElement("cs:invite-accepted")
+	}
+	invite.CreateElement("cs:hosturl").CreateElement("d:href").SetText(calendarHref)
+	organizer := invite.CreateElement("cs:organizer")
+	organizer.CreateElement("d:href").SetText(ownerHref)
+	if owner, err := h.Storage.GetUser(ownerID); err == nil && owner != nil && owner.DisplayName != "" {
+		organizer.CreateElement("cs:common-name").SetText(owner.DisplayName)
+	}
+	if privs != 0 {
+		access := "cs:read"
+		if privs.Has(storage.PrivilegeWriteContent) {
+			access = "cs:read-write"
+		}
+		invite.CreateElement("cs:access").CreateElement(access)
+	}
+	if cal != nil && cal.CalendarData != nil {
+		if name, _ := cal.CalendarData.Props.Text(ical.PropName); name != "" {
+			invite.CreateElement("cs:summary").SetText(name)
+		}
+	}
+	if err := h.notify(shareeID, NotificationInvite, invite, time.Now()); err != nil {
+		return err
+	}
+	h.Logger.Info("share notification stored",
+		"owner", ownerID,
+		"calendar_id", calendarID,
+		"sharee", shareeID)
+	return nil
+}
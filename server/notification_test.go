@@ -0,0 +1,158 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// notificationStorage keeps notifications in memory on top of MockStorage.
+type notificationStorage struct {
+	*storage.MockStorage
+	notes map[string][]storage.Notification
+}
+
+func (s *notificationStorage) AddNotification(userID string, n storage.Notification) error {
+	s.notes[userID] = append(s.notes[userID], n)
+	return nil
+}
+
+func (s *notificationStorage) GetNotifications(userID string) ([]storage.Notification, error) {
+	return s.notes[userID], nil
+}
+
+func (s *notificationStorage) DeleteNotification(userID, id string) error {
+	i := slices.IndexFunc(s.notes[userID], func(n storage.Notification) bool { return n.ID == id })
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	s.notes[userID] = slices.Delete(s.notes[userID], i, i+1)
+	return nil
+}
+
+func newNotificationHandler(t *testing.T) (*CaldavHandler, *notificationStorage) {
+	store := &notificationStorage{MockStorage: &storage.MockStorage{}, notes: map[string][]storage.Notification{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetUser", "alice").Return(&storage.User{DisplayName: "Alice", UserAddress: "alice@example.com"}, nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work/"}, nil)
+	stored, err := decodeCalendarComponents(meetingWith("NEEDS-ACTION"))
+	require.NoError(t, err)
+	store.On("GetObject", "alice", "work", "meeting.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/meeting.ics", ETag: `"v1"`, Component: stored}, nil)
+	store.On("UpdateObject", "alice", "work", mock.AnythingOfType("*storage.CalendarObject")).Return(`"v2"`, nil)
+	return NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil))), store
+}
+
+func meetingWith(partstat string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:meeting\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250102T100000Z\r\nSUMMARY:Planning\r\n" +
+		"ORGANIZER:mailto:alice@example.com\r\n" +
+		"ATTENDEE;PARTSTAT=ACCEPTED:mailto:alice@example.com\r\n" +
+		"ATTENDEE;CN=Bob;PARTSTAT=" + partstat + ":mailto:bob@example.com\r\n" +
+		"END:VEVENT\r\nEND:VCALENDAR\r\n"
+}
+
+func TestAttendeeReplyNotification(t *testing.T) {
+	h, store := newNotificationHandler(t)
+	header := map[string]string{"Content-Type": "text/calendar"}
+
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/meeting.ics", meetingWith("ACCEPTED"), header)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	require.Len(t, store.notes["alice"], 1)
+	n := store.notes["alice"][0]
+	assert.Equal(t, NotificationResourceChanged, n.Type)
+	assert.True(t, strings.HasSuffix(n.ID, ".xml"))
+	assert.Contains(t, n.XML, "<d:href>/caldav/alice/cal/work/meeting.ics</d:href>")
+	assert.Contains(t, n.XML, "<cs:common-name>Bob</cs:common-name>")
+	assert.Contains(t, n.XML, "<d:href>mailto:bob@example.com</d:href>")
+	assert.Contains(t, n.XML, "<cs:master/>")
+	assert.Contains(t, n.XML, `<cs:changed-parameter name="PARTSTAT"/>`)
+	assert.Contains(t, n.XML, "<lc:partstat>ACCEPTED</lc:partstat>")
+
+	// Writes that leave participation alone don't notify
+	w = serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/meeting.ics", strings.Replace(meetingWith("NEEDS-ACTION"), "Planning", "Review", 1), header)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Len(t, store.notes["alice"], 1)
+}
+
+func TestNotificationCollection(t *testing.T) {
+	h, store := newNotificationHandler(t)
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/meeting.ics", meetingWith("DECLINED"), map[string]string{"Content-Type": "text/calendar"})
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	require.Len(t, store.notes["alice"], 1)
+	id := store.notes["alice"][0].ID
+
+	// The principal points clients at the collection
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/"><D:prop><CS:notification-url/></D:prop></D:propfind>`
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/", body, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<cs:notification-url><d:href>/caldav/alice/cal/notification</d:href></cs:notification-url>")
+
+	body = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/"><D:prop><D:resourcetype/><D:getetag/><CS:notificationtype/></D:prop></D:propfind>`
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/notification/", body, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<cs:notification/>")
+	assert.Contains(t, w.Body.String(), "/caldav/alice/cal/notification/"+id)
+	assert.Contains(t, w.Body.String(), "<cs:notificationtype><cs:resource-changed/></cs:notificationtype>")
+
+	w = serveAlice(h, http.MethodGet, "/caldav/alice/cal/notification/"+id, "", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/xml")
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), "<cs:notification")
+
+	w = serveAlice(h, http.MethodDelete, "/caldav/alice/cal/notification/"+id, "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, store.notes["alice"])
+	w = serveAlice(h, http.MethodGet, "/caldav/alice/cal/notification/"+id, "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serveAlice(h, http.MethodPut, "/caldav/alice/cal/notification/x.xml", "<x/>", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestNotificationCollectionListed(t *testing.T) {
+	h, store := newNotificationHandler(t)
+	store.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work/"}}, nil)
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+	notifications := "<d:href>/caldav/alice/cal/notification</d:href><d:propstat><d:prop><d:resourcetype><d:collection/><cs:notification/></d:resourcetype>"
+
+	// Clients walking down from the principal find it next to the home
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/", body, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal</d:href>")
+	assert.Contains(t, w.Body.String(), notifications)
+
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/", body, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work</d:href>")
+	assert.Contains(t, w.Body.String(), notifications)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "/caldav/alice/cal/notification"))
+}
+
+func TestNotifyShared(t *testing.T) {
+	h, store := newNotificationHandler(t)
+
+	require.NoError(t, h.NotifyShared("alice", "work", "dave", storage.PrivilegeRead|storage.PrivilegeWriteContent))
+	require.NoError(t, h.NotifyShared("alice", "work", "dave", 0))
+	require.Len(t, store.notes["dave"], 2)
+	shared, unshared := store.notes["dave"][0], store.notes["dave"][1]
+	assert.Equal(t, NotificationInvite, shared.Type)
+	assert.Contains(t, shared.XML, "<cs:invite-accepted/>")
+	assert.Contains(t, shared.XML, "<cs:hosturl><d:href>/caldav/alice/cal/work</d:href></cs:hosturl>")
+	assert.Contains(t, shared.XML, "<cs:common-name>Alice</cs:common-name>")
+	assert.Contains(t, shared.XML, "<cs:read-write/>")
+	assert.Contains(t, unshared.XML, "<cs:invite-deleted/>")
+	assert.NotContains(t, unshared.XML, "<cs:access>")
+
+	// Without notification storage there's nothing to do
+	plain := NewCaldavHandler("/caldav/", "test", store.MockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, plain.NotifyShared("alice", "work", "dave", storage.PrivilegeRead))
+}
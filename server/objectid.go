@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/google/uuid"
+)
+
+// ObjectIDGenerator names a new calendar object holding comps when the client
+// doesn't pick a name: PUTs to a calendar collection, bulk creates and
+// snapshot objects without an ObjectID. Names should end in ".ics".
+type ObjectIDGenerator func(comps []*ical.Component) string
+
+// maxSlugLength caps the part of a UIDSlugObjectID name taken from the UID.
+const maxSlugLength = 100
+
+// UUIDObjectID names objects "<random UUID>.ics". This is the default.
+func UUIDObjectID(comps []*ical.Component) string {
+	return uuid.NewString() + ".ics"
+}
+
+// UIDSlugObjectID names objects after their UID, with runs of characters
+// other than letters, digits, ".", "_", "-" and "@" replaced by "-", so
+// names stay readable in file managers and logs. Objects without a UID get a
+// UUIDObjectID.
+func UIDSlugObjectID(comps []*ical.Component) string {
+	uid := (&storage.CalendarObject{Component: comps}).UID()
+	var slug strings.Builder
+	dash := false
+	for _, c := range strings.TrimSuffix(uid, ".ics") {
+		if c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-@", c)) {
+			slug.WriteRune(c)
+			dash = false
+		} else if !dash {
+			slug.WriteByte('-')
+			dash = true
+		}
+		if slug.Len() >= maxSlugLength {
+			break
+		}
+	}
+	// Leading dots would make hidden files, or "." and ".."
+	name := strings.Trim(strings.TrimLeft(slug.String(), "."), "-")
+	if name == "" {
+		return UUIDObjectID(comps)
+	}
+	return name + ".ics"
+}
+
+// HashObjectID names objects after a SHA-256 hash of their UID, so an event
+// gets the same name in every calendar and whenever it is imported again,
+// whatever its UID holds. Objects without a UID get a UUIDObjectID.
+func HashObjectID(comps []*ical.Component) string {
+	uid := (&storage.CalendarObject{Component: comps}).UID()
+	if uid == "" {
+		return UUIDObjectID(comps)
+	}
+	sum := sha256.Sum256([]byte(uid))
+	return hex.EncodeToString(sum[:16]) + ".ics"
+}
+
+// maxObjectIDAttempts caps how many suffixed names newObjectID tries before
+// falling back to a UUID.
+const maxObjectIDAttempts = 10
+
+// newObjectID names a new object of a calendar holding comps with
+// h.ObjectIDs, UUIDObjectID by default. Names from h.ObjectIDs the calendar
+// already uses get a "-2", "-3" ... suffix, so a generated name never
+// replaces an object.
+func (h *CaldavHandler) newObjectID(userID, calendarID string, comps []*ical.Component) (string, error) {
+	if h.ObjectIDs == nil {
+		return UUIDObjectID(comps), nil
+	}
+	name := h.ObjectIDs(comps)
+	base := strings.TrimSuffix(name, ".ics")
+	for attempt := 1; attempt <= maxObjectIDAttempts; attempt++ {
+		if attempt > 1 {
+			name = fmt.Sprintf("%s-%d.ics", base, attempt)
+		}
+		obj, err := h.Storage.GetObject(userID, calendarID, name)
+		if errors.Is(err, storage.ErrNotFound) || err == nil && obj == nil {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return UUIDObjectID(comps), nil
+}
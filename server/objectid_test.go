@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func eventWithUID(uid string) []*ical.Component {
+	event := ical.NewEvent()
+	if uid != "" {
+		event.Props.SetText(ical.PropUID, uid)
+	}
+	return []*ical.Component{event.Component}
+}
+
+func TestObjectIDGenerators(t *testing.T) {
+	assert.Regexp(t, `^[0-9a-f-]{36}\.ics$`, UUIDObjectID(eventWithUID("a")))
+
+	tests := []struct {
+		uid  string
+		want string
+	}{
+		{"040000008200E00074C5B7101A82E008@example.com", "040000008200E00074C5B7101A82E008@example.com.ics"},
+		{"Réunion d'équipe / 2025", "R-union-d-quipe-2025.ics"},
+		{"event.ics", "event.ics"},
+		{"..hidden", "hidden.ics"},
+		{strings.Repeat("a", 300), strings.Repeat("a", maxSlugLength) + ".ics"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, UIDSlugObjectID(eventWithUID(tc.uid)), tc.uid)
+	}
+	assert.Regexp(t, `^[0-9a-f-]{36}\.ics$`, UIDSlugObjectID(eventWithUID("日程")))
+	assert.Regexp(t, `^[0-9a-f-]{36}\.ics$`, UIDSlugObjectID(eventWithUID("")))
+
+	hashed := HashObjectID(eventWithUID("a+b@example.com"))
+	assert.Regexp(t, `^[0-9a-f]{32}\.ics$`, hashed)
+	assert.Equal(t, hashed, HashObjectID(eventWithUID("a+b@example.com")))
+	assert.NotEqual(t, hashed, HashObjectID(eventWithUID("a-b@example.com")))
+}
+
+func TestPutToCollection(t *testing.T) {
+	store := &changeStorage{MockStorage: &storage.MockStorage{}, objects: map[string]storage.CalendarObject{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: ical.NewCalendar()}, nil).Maybe()
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.ObjectIDs = UIDSlugObjectID
+
+	put := func(summary string) string {
+		body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:Team Sync\r\n" +
+			"DTSTAMP:20250101T000000Z\r\nDTSTART:20250303T090000Z\r\nDTEND:20250303T093000Z\r\n" +
+			"SUMMARY:" + summary + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/", body, map[string]string{"Content-Type": "text/calendar"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		return w.Header().Get("Location")
+	}
+	assert.Equal(t, "/caldav/alice/cal/work/Team-Sync.ics", put("First"))
+	// A second upload never replaces the first
+	assert.Equal(t, "/caldav/alice/cal/work/Team-Sync-2.ics", put("Second"))
+	require.Len(t, store.objects, 2)
+	assert.Equal(t, "First", store.objects["Team-Sync.ics"].Component[0].Props.Get(ical.PropSummary).Value)
+
+	// Bodies that don't parse fail as on object URLs
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/", "not a calendar", map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, store.objects, 2)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// occurrenceStorage indexes its objects with ComputeOccurrences and records
+// the ranges looked up.
+type occurrenceStorage struct {
+	*storage.MockStorage
+	objects []storage.CalendarObject
+	ranges  [][2]*time.Time
+}
+
+func (s *occurrenceStorage) GetObjectsInTimeRange(userID, calendarID string, start, end *time.Time) ([]storage.CalendarObject, error) {
+	s.ranges = append(s.ranges, [2]*time.Time{start, end})
+	var found []storage.CalendarObject
+	for _, obj := range s.objects {
+		occ, ok := storage.ComputeOccurrences(&obj, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+		if !ok || occ.MayOverlap(start, end) {
+			found = append(found, obj)
+		}
+	}
+	return found, nil
+}
+
+func TestCalendarQueryOccurrenceIndex(t *testing.T) {
+	start := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	weekly := storage.NewMockEvent("/caldav/alice/cal/work/weekly.ics", "weekly", "Weekly", start, start.Add(time.Hour))
+	weekly.Component[0].Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=WEEKLY"})
+	once := storage.NewMockEvent("/caldav/alice/cal/work/once.ics", "once", "Once", start.AddDate(0, 0, 1), start.AddDate(0, 0, 1).Add(time.Hour))
+	for _, obj := range []*storage.CalendarObject{&weekly, &once} {
+		cal := ical.NewComponent(ical.CompCalendar)
+		cal.Children = obj.Component
+		obj.Component = []*ical.Component{cal}
+	}
+	store := &occurrenceStorage{MockStorage: &storage.MockStorage{}, objects: []storage.CalendarObject{weekly, once}}
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: ical.NewCalendar()}, nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, AuthUser: "alice"}
+
+	query := func(timeRange string) string {
+		body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">` + timeRange + `</C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+		w := httptest.NewRecorder()
+		h.handleCalendarQuery(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/work/", strings.NewReader(body)), ctx)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		return w.Body.String()
+	}
+
+	// A Monday: the weekly event, from its instances
+	body := query(`<C:time-range start="20250310T000000Z" end="20250311T000000Z"/>`)
+	assert.Contains(t, body, "weekly.ics")
+	assert.NotContains(t, body, "once.ics")
+	require.Len(t, store.ranges, 1)
+	assert.Equal(t, time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), *store.ranges[0][0])
+
+	// A Wednesday: neither
+	body = query(`<C:time-range start="20250312T000000Z" end="20250313T000000Z"/>`)
+	assert.NotContains(t, body, ".ics")
+
+	// Filters without a required time-range still go to GetObjectByFilter
+	store.On("GetObjectByFilter", "alice", "work", mock.Anything).
+		Return([]storage.CalendarObject{once}, nil)
+	body = query(``)
+	assert.Contains(t, body, "once.ics")
+	assert.Len(t, store.ranges, 2)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// ScheduleOutboxCollection is the ID of a user's scheduling outbox in the
+// calendar home, published as cal:schedule-outbox-URL when the storage
+// implements storage.ScheduleInboxStorage and Features.EnableScheduling is
+// set. Its owner POSTs iTIP messages to
+// it, which are delivered to the inboxes of local recipients. With such
+// storage no calendar can use the ID.
+const ScheduleOutboxCollection = "outbox"
+
+// iTIP request statuses (RFC 5546 section 3.6) of schedule-response items.
+const (
+	scheduleStatusDelivered   = "2.0;Success"
+	scheduleStatusUnknownUser = "3.7;Invalid calendar user"
+	scheduleStatusNoAuthority = "3.8;No authority"
+	scheduleStatusFailed      = "5.1;Could not complete delivery"
+)
+
+// scheduleInboxStorage returns the storage as storage.ScheduleInboxStorage.
+func (h *CaldavHandler) scheduleInboxStorage() (storage.ScheduleInboxStorage, bool) {
+	inboxes, ok := storage.As[storage.ScheduleInboxStorage](h.Storage)
+	return inboxes, ok
+}
+
+// isScheduleOutbox reports whether res is a scheduling outbox or a resource
+// in one.
+func (h *CaldavHandler) isScheduleOutbox(res Resource) bool {
+	if !h.schedulingPost() || res.CalendarID != ScheduleOutboxCollection {
+		return false
+	}
+	return res.ResourceType == storage.ResourceCollection || res.ResourceType == storage.ResourceObject
+}
+
+// scheduleValidator returns ScheduleValidator, or one accepting every
+// recipient that is a party of the event. Only local recipients get the
+// message either way.
+func (h *CaldavHandler) scheduleValidator() *ScheduleValidator {
+	if h.ScheduleValidator != nil {
+		return h.ScheduleValidator
+	}
+	return &ScheduleValidator{Storage: h.Storage, AllowedDomains: []string{"*"}}
+}
+
+// handleScheduleOutbox serves a scheduling outbox. Only its owner can POST
+// to it; it holds no resources.
+func (h *CaldavHandler) handleScheduleOutbox(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	if ctx.AuthUser != ctx.Resource.UserID {
+		h.denyAccess(w, r)
+		return
+	}
+	if ctx.Resource.ResourceType == storage.ResourceObject {
+		h.writeError(w, r, http.StatusNotFound, CodeNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		h.handleOptions(w, r, ctx)
+	case http.MethodPost:
+		h.handleScheduleOutboxPost(w, r, ctx)
+	default:
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+	}
+}
+
+// handleScheduleOutboxPost validates the iTIP message in the request body
+// and delivers it to the accepted recipients, answering with a
+// cal:schedule-response holding the request status of every recipient.
+// Messages refused as a whole get the RFC 6638 precondition that failed.
+func (h *CaldavHandler) handleScheduleOutboxPost(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	if contentType := r.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/calendar") {
+		h.Logger.Warn("unsupported media type",
+			"content_type", contentType)
+		h.writeError(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.Error("failed to read request body",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
+		return
+	}
+	cal, err := ical.NewDecoder(strings.NewReader(string(data))).Decode()
+	if err != nil {
+		h.Logger.Warn("invalid scheduling message",
+			"error", err)
+		h.writePrecondition(w, r, http.StatusBadRequest, "cal:valid-scheduling-message", err.Error())
+		return
+	}
+
+	msg, err := h.scheduleValidator().Validate(ctx.AuthUser, cal, nil)
+	switch {
+	case errors.Is(err, ErrScheduleInvalid):
+		h.Logger.Warn("invalid scheduling message",
+			"user_id", ctx.AuthUser,
+			"error", err)
+		h.writePrecondition(w, r, http.StatusBadRequest, "cal:valid-scheduling-message", err.Error())
+		return
+	case errors.Is(err, ErrScheduleOriginator):
+		h.Logger.Warn("scheduling message from another originator",
+			"user_id", ctx.AuthUser)
+		h.writePrecondition(w, r, http.StatusForbidden, "cal:originator-allowed", err.Error())
+		return
+	case err != nil:
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+	if len(msg.Recipients) == 0 && len(msg.Rejected) > 0 {
+		h.Logger.Warn("scheduling message has no allowed recipient",
+			"user_id", ctx.AuthUser,
+			"uid", msg.UID)
+		h.writePrecondition(w, r, http.StatusForbidden, "cal:recipient-permission", "no recipient may receive the message")
+		return
+	}
+
+	statuses := make(map[string]string, len(msg.Recipients)+len(msg.Rejected))
+	for recipient, reason := range msg.Rejected {
+		h.Logger.Info("scheduling recipient rejected",
+			"recipient", recipient,
+			"reason", reason)
+		statuses[recipient] = scheduleStatusNoAuthority
+	}
+	for _, recipient := range msg.Recipients {
+		statuses[recipient] = h.deliverScheduleMessage(msg, recipient)
+	}
+	h.writeScheduleResponse(w, r, statuses)
+}
+
+// deliverScheduleMessage delivers msg to the inbox of a local recipient and
+// returns the request status to report for it. Messages ScheduleReplayGuard
+// has seen for the recipient are reported delivered without delivering them
+// again.
+func (h *CaldavHandler) deliverScheduleMessage(msg *ScheduleMessage, recipient string) string {
+	inboxes, _ := h.scheduleInboxStorage()
+	userID, err := inboxes.UserByAddress(normalizeAddress(recipient))
+	if errors.Is(err, storage.ErrNotFound) {
+		return scheduleStatusUnknownUser
+	} else if err != nil {
+		h.Logger.Error("failed to look up scheduling recipient",
+			"recipient", recipient,
+			"error", err)
+		return scheduleStatusFailed
+	}
+	var key ScheduleMessageKey
+	if guard := h.ScheduleReplayGuard; guard != nil {
+		var duplicate bool
+		key, duplicate, err = guard.Check(msg.Calendar, recipient)
+		if err != nil {
+			h.Logger.Error("failed to check scheduling message for replay",
+				"recipient", recipient,
+				"uid", msg.UID,
+				"error", err)
+			return scheduleStatusFailed
+		}
+		if duplicate {
+			h.Logger.Info("scheduling message already delivered",
+				"recipient", recipient,
+				"uid", msg.UID)
+			return scheduleStatusDelivered
+		}
+	}
+	if err := inboxes.DeliverScheduleMessage(userID, msg.Calendar); err != nil {
+		h.Logger.Error("failed to deliver scheduling message",
+			"recipient", recipient,
+			"uid", msg.UID,
+			"error", err)
+		if h.ScheduleReplayGuard != nil {
+			if err := h.ScheduleReplayGuard.Forget(key); err != nil {
+				h.Logger.Error("failed to forget undelivered scheduling message",
+					"recipient", recipient,
+					"uid", msg.UID,
+					"error", err)
+			}
+		}
+		return scheduleStatusFailed
+	}
+	return scheduleStatusDelivered
+}
+
+// writeScheduleResponse answers an outbox POST with a cal:schedule-response
+// listing the request status of each recipient.
+func (h *CaldavHandler) writeScheduleResponse(w http.ResponseWriter, r *http.Request, statuses map[string]string) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="utf-8"`)
+	root := doc.CreateElement("cal:schedule-response")
+	root.CreateAttr("xmlns:d", props.NamespaceMap["d"])
+	root.CreateAttr("xmlns:cal", props.NamespaceMap["cal"])
+	for _, recipient := range slices.Sorted(maps.Keys(statuses)) {
+		resp := root.CreateElement("cal:response")
+		resp.CreateElement("cal:recipient").CreateElement("d:href").SetText(recipient)
+		resp.CreateElement("cal:request-status").SetText(statuses[recipient])
+	}
+	body, err := h.writeXML(doc)
+	if err != nil {
+		h.Logger.Error("failed to serialize schedule response",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(body))
+}
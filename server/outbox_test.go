@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inboxStorage delivers scheduling messages to users at example.com.
+type inboxStorage struct {
+	*storage.MockStorage
+	delivered map[string][]*ical.Calendar
+}
+
+func (s *inboxStorage) UserByAddress(address string) (string, error) {
+	if user, ok := strings.CutSuffix(strings.TrimPrefix(address, "mailto:"), "@example.com"); ok {
+		return user, nil
+	}
+	return "", storage.ErrNotFound
+}
+
+func (s *inboxStorage) DeliverScheduleMessage(userID string, msg *ical.Calendar) error {
+	s.delivered[userID] = append(s.delivered[userID], msg)
+	return nil
+}
+
+func newOutboxTestHandler() (*CaldavHandler, *inboxStorage) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	store := &inboxStorage{MockStorage: mockStorage, delivered: map[string][]*ical.Calendar{}}
+	store.On("GetUser", "alice").Return(&storage.User{UserAddress: "mailto:alice@example.com"}, nil)
+	h.Storage = store
+	return h, store
+}
+
+func itipMessage(method, organizer string, attendees ...string) string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nMETHOD:" + method + "\r\n")
+	b.WriteString("BEGIN:VEVENT\r\nUID:meeting\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250102T100000Z\r\nSEQUENCE:1\r\n")
+	b.WriteString("ORGANIZER:" + organizer + "\r\n")
+	for _, attendee := range attendees {
+		b.WriteString("ATTENDEE:" + attendee + "\r\n")
+	}
+	b.WriteString("END:VEVENT\r\nEND:VCALENDAR\r\n")
+	return b.String()
+}
+
+func TestScheduleOutboxDelivery(t *testing.T) {
+	h, store := newOutboxTestHandler()
+	header := map[string]string{"Content-Type": "text/calendar"}
+
+	w := serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/",
+		itipMessage("REQUEST", "mailto:alice@example.com", "mailto:bob@example.com", "mailto:carol@elsewhere.net"), header)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<cal:response><cal:recipient><d:href>mailto:bob@example.com</d:href></cal:recipient><cal:request-status>2.0;Success</cal:request-status></cal:response>")
+	assert.Contains(t, w.Body.String(), "<d:href>mailto:carol@elsewhere.net</d:href></cal:recipient><cal:request-status>3.7;Invalid calendar user</cal:request-status>")
+	assert.Len(t, store.delivered["bob"], 1)
+
+	// The organizer must be the sender
+	w = serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/",
+		itipMessage("REQUEST", "mailto:mallory@example.com", "mailto:bob@example.com"), header)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "<cal:originator-allowed/>")
+
+	// Messages that aren't iTIP are refused
+	w = serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/",
+		strings.Replace(itipMessage("REQUEST", "mailto:alice@example.com", "mailto:bob@example.com"), "METHOD:REQUEST\r\n", "", 1), header)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "<cal:valid-scheduling-message/>")
+
+	// Recipients the validator refuses get nothing
+	h.ScheduleValidator = NewScheduleValidator(store, "partner.org")
+	w = serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/",
+		itipMessage("REQUEST", "mailto:alice@example.com", "mailto:bob@example.com"), header)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "<cal:recipient-permission/>")
+	assert.Len(t, store.delivered["bob"], 1)
+}
+
+func TestScheduleOutboxReplay(t *testing.T) {
+	h, store := newOutboxTestHandler()
+	h.ScheduleReplayGuard = NewScheduleReplayGuard(NewMemoryReplayStore())
+	header := map[string]string{"Content-Type": "text/calendar"}
+	msg := itipMessage("REQUEST", "mailto:alice@example.com", "mailto:bob@example.com")
+
+	for range 2 {
+		w := serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/", msg, header)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "<cal:request-status>2.0;Success</cal:request-status>")
+	}
+	assert.Len(t, store.delivered["bob"], 1, "a retried POST must not deliver again")
+
+	// A new SEQUENCE is a new message
+	w := serveAlice(h, http.MethodPost, "/caldav/alice/cal/outbox/", strings.Replace(msg, "SEQUENCE:1", "SEQUENCE:2", 1), header)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, store.delivered["bob"], 2)
+}
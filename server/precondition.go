@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+)
+
+// writePrecondition answers with a WebDAV error body (RFC 4918 section 16)
+// naming the failed precondition or postcondition, e.g. "d:quota-not-exceeded",
+// followed by a human-readable lc:message for people reading client logs. The
+// condition doubles as the ErrorCode handed to ErrorMessages.
+func (h *CaldavHandler) writePrecondition(w http.ResponseWriter, r *http.Request, status int, condition, message string) {
+	w.Header().Set(errorCodeHeader, condition)
+	h.writeErrorBody(w, status, condition, h.errorMessage(r, ErrorCode(condition), message))
+}
+
+// writeErrorBody writes the XML error body of writePrecondition and
+// ErrorFormatXML.
+func (h *CaldavHandler) writeErrorBody(w http.ResponseWriter, status int, condition, message string) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="utf-8"`)
+	root := doc.CreateElement("d:error")
+	prefix, _, _ := strings.Cut(condition, ":")
+	for _, p := range []string{"d", prefix, "lc"} {
+		if root.SelectAttr("xmlns:"+p) == nil {
+			root.CreateAttr("xmlns:"+p, props.NamespaceMap[p])
+		}
+	}
+	root.CreateElement(condition)
+	if message != "" {
+		root.CreateElement("lc:message").SetText(message)
+	}
+
+	body, err := h.writeXML(doc)
+	if err != nil {
+		h.Logger.Error("failed to serialize error body",
+			"error", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// icalWeekdays maps iCalendar weekdays to their offset from Monday.
+var icalWeekdays = map[string]int{"MO": 0, "TU": 1, "WE": 2, "TH": 3, "FR": 4, "SA": 5, "SU": 6}
+
+// availabilityEpoch is the Monday working hours recur from. A fixed date keeps
+// the generated availability, and so its ETag, stable.
+var availabilityEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// formatClock formats an offset from midnight as "15:04".
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// workingHoursAvailability turns a user's working hours into a VAVAILABILITY
+// (RFC 7953) with a weekly AVAILABLE component per span, in the user's
+// preferred timezone (UTC when unset). It returns nil when the user has no
+// working hours.
+func workingHoursAvailability(userID string, user *storage.User) *ical.Calendar {
+	if user == nil || len(user.WorkingHours) == 0 {
+		return nil
+	}
+	loc := time.UTC
+	if user.PreferredTimezone != "" {
+		if l, err := time.LoadLocation(user.PreferredTimezone); err == nil {
+			loc = l
+		}
+	}
+
+	vavail := ical.NewComponent("VAVAILABILITY")
+	vavail.Props.SetText(ical.PropUID, userID+"-working-hours")
+	vavail.Props.SetDateTime(ical.PropDateTimeStamp, availabilityEpoch)
+	for i, wh := range user.WorkingHours {
+		var days []string
+		first := -1
+		for _, day := range wh.Days {
+			day = strings.ToUpper(day)
+			offset, ok := icalWeekdays[day]
+			if !ok {
+				continue
+			}
+			days = append(days, day)
+			if first < 0 || offset < first {
+				first = offset
+			}
+		}
+		if len(days) == 0 || wh.End <= wh.Start {
+			continue
+		}
+		date := availabilityEpoch.AddDate(0, 0, first)
+		midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+
+		available := ical.NewComponent("AVAILABLE")
+		available.Props.SetText(ical.PropUID, fmt.Sprintf("%s-working-hours-%d", userID, i))
+		available.Props.SetDateTime(ical.PropDateTimeStamp, availabilityEpoch)
+		available.Props.SetDateTime(ical.PropDateTimeStart, midnight.Add(wh.Start))
+		available.Props.SetDateTime(ical.PropDateTimeEnd, midnight.Add(wh.End))
+		available.Props.Set(&ical.Prop{
+			Name:   ical.PropRecurrenceRule,
+			Params: ical.Params{},
+			Value:  "FREQ=WEEKLY;BYDAY=" + strings.Join(days, ","),
+		})
+		vavail.Children = append(vavail.Children, available)
+	}
+	if len(vavail.Children) == 0 {
+		return nil
+	}
+
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, "-//libcaldora//NONSGML v1.0//EN")
+	cal.Children = append(cal.Children, vavail)
+	return cal
+}
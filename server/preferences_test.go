@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipalPreferences(t *testing.T) {
+	store := &availabilityStorage{MockStorage: &storage.MockStorage{}, cals: map[string]*ical.Calendar{}}
+	store.On("GetUser", "alice").Return(&storage.User{
+		PreferredTimezone: "Asia/Shanghai",
+		WeekStart:         "SU",
+		WorkingHours: []storage.WorkingHours{
+			{Days: []string{"MO", "TU", "WE", "TH", "FR"}, Start: 9 * time.Hour, End: 17*time.Hour + 30*time.Minute},
+			{Days: []string{"sa"}, Start: 10 * time.Hour, End: 12 * time.Hour},
+		},
+	}, nil)
+	store.On("GetUser", "bob").Return(&storage.User{}, nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	alice := Resource{UserID: "alice", ResourceType: storage.ResourcePrincipal}
+	bob := Resource{UserID: "bob", ResourceType: storage.ResourcePrincipal}
+
+	res := principalResolvers["week-start"](newPropEnv(h, alice, nil))
+	require.True(t, res.IsOk())
+	assert.Equal(t, "SU", res.MustGet().Encode().Text())
+
+	res = principalResolvers["working-hours"](newPropEnv(h, alice, nil))
+	require.True(t, res.IsOk())
+	hours := res.MustGet().Encode().SelectElements("hours")
+	require.Len(t, hours, 2)
+	assert.Equal(t, "MO,TU,WE,TH,FR", hours[0].SelectAttrValue("days", ""))
+	assert.Equal(t, "09:00", hours[0].SelectAttrValue("start", ""))
+	assert.Equal(t, "17:30", hours[0].SelectAttrValue("end", ""))
+
+	for _, name := range []string{"week-start", "working-hours"} {
+		assert.True(t, principalResolvers[name](newPropEnv(h, bob, nil)).IsError(), name)
+	}
+	aliceInbox := Resource{UserID: "alice", CalendarID: ScheduleInboxCollection, ResourceType: storage.ResourceCollection}
+	bobInbox := Resource{UserID: "bob", CalendarID: ScheduleInboxCollection, ResourceType: storage.ResourceCollection}
+	assert.True(t, inboxResolvers["calendar-availability"](newPropEnv(h, bobInbox, nil)).IsError())
+
+	// Working hours stand in for unpublished availability
+	res = inboxResolvers["calendar-availability"](newPropEnv(h, aliceInbox, nil))
+	require.True(t, res.IsOk())
+	cal, err := ical.NewDecoder(strings.NewReader(res.MustGet().Encode().Text())).Decode()
+	require.NoError(t, err)
+	available := cal.Children[0].Children
+	require.Len(t, available, 2)
+	start, err := available[0].Props.DateTime(ical.PropDateTimeStart, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), start.UTC())
+	assert.Equal(t, "Asia/Shanghai", available[0].Props.Get(ical.PropDateTimeStart).Params.Get(ical.ParamTimezoneID))
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", available[0].Props.Get(ical.PropRecurrenceRule).Value)
+	start, err = available[1].Props.DateTime(ical.PropDateTimeStart, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Saturday, start.Weekday())
+
+	// Published availability wins
+	published, err := ical.NewDecoder(strings.NewReader(availabilityICS)).Decode()
+	require.NoError(t, err)
+	store.cals["alice"] = published
+	res = inboxResolvers["calendar-availability"](newPropEnv(h, aliceInbox, nil))
+	require.True(t, res.IsOk())
+	assert.Contains(t, res.MustGet().Encode().Text(), "UID:office-hours")
+}
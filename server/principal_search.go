@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/beevik/etree"
+	principalsearch "github.com/cyp0633/libcaldora/internal/xml/principal-search"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// searchableProperties are the principal properties principal-property-search
+// can match on.
+var searchableProperties = []principalsearch.SearchProperty{
+	{Name: "displayname", Description: "Display name"},
+	{Name: "calendar-user-address-set", Description: "Calendar user address"},
+}
+
+// handlePrincipalPropertySearch answers DAV:principal-property-search. There
+// is no storage API to enumerate principals, so the search covers those the
+// user can see: itself, its groups and their members.
+func (h *CaldavHandler) handlePrincipalPropertySearch(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.Error("failed to read request body",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeReadBody)
+		return
+	}
+	req, query, err := principalsearch.ParseRequest(string(bodyBytes))
+	if err != nil {
+		h.Logger.Warn("error parsing principal-property-search request",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
+		return
+	}
+
+	ids, err := h.visiblePrincipals(ctx.AuthUser)
+	if err != nil {
+		h.Logger.Error("error listing principals",
+			"user_id", ctx.AuthUser,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+
+	var docs []*etree.Document
+	for _, id := range ids {
+		user, err := h.Storage.GetUser(id)
+		if err != nil {
+			h.Logger.Error("error getting user for principal search",
+				"user_id", id,
+				"error", err)
+			h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+			return
+		}
+		if !principalMatches(id, user, query) {
+			continue
+		}
+		doc, err := h.handlePropfindPrincipal(req, Resource{UserID: id, ResourceType: storage.ResourcePrincipal})
+		if err != nil {
+			h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+			return
+		}
+		docs = append(docs, doc)
+	}
+	h.writeMultistatus(w, r, docs)
+}
+
+// principalMatches evaluates the search criteria against a principal.
+func principalMatches(id string, user *storage.User, query principalsearch.Query) bool {
+	for _, search := range query.Searches {
+		matched := false
+		for _, name := range search.Props {
+			for _, value := range searchValues(id, user, name) {
+				if search.Matches(value) {
+					matched = true
+				}
+			}
+		}
+		if matched && !query.AllOf {
+			return true
+		}
+		if !matched && query.AllOf {
+			return false
+		}
+	}
+	return query.AllOf
+}
+
+// searchValues returns the values of a searchable property, as the principal
+// resolvers report them.
+func searchValues(id string, user *storage.User, name string) []string {
+	switch name {
+	case "displayname":
+		if user != nil && user.DisplayName != "" {
+			return []string{user.DisplayName}
+		}
+		return []string{id}
+	case "calendar-user-address-set":
+		if user != nil && user.UserAddress != "" {
+			return []string{user.UserAddress}
+		}
+	}
+	return nil
+}
+
+// handlePrincipalSearchPropertySet answers DAV:principal-search-property-set
+// with searchableProperties.
+func (h *CaldavHandler) handlePrincipalSearchPropertySet(w http.ResponseWriter, r *http.Request, _ *RequestContext) {
+	xmlOutput, err := h.writeXML(principalsearch.EncodePropertySet(searchableProperties))
+	if err != nil {
+		h.Logger.Error("failed to serialize XML response",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xmlOutput))
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// privilegeNames are the DAV privilege element names, for error messages.
+var privilegeNames = map[storage.Privilege]string{
+	storage.PrivilegeRead:         "read",
+	storage.PrivilegeWriteContent: "write-content",
+	storage.PrivilegeBind:         "bind",
+	storage.PrivilegeUnbind:       "unbind",
+}
+
+// privilegeDescriptions explain privileges in DAV:supported-privilege-set.
+var privilegeDescriptions = map[storage.Privilege]string{
+	storage.PrivilegeRead:         "Read calendars and calendar objects",
+	storage.PrivilegeWriteContent: "Change calendar objects and calendar properties",
+	storage.PrivilegeBind:         "Create calendars",
+	storage.PrivilegeUnbind:       "Delete calendars",
+}
+
+// supportedPrivileges is the DAV:supported-privilege-set (RFC 3744 section
+// 5.3) of every resource, built from the privileges the handler enforces:
+// DAV:all aggregates them all, and DAV:write those in storage.PrivilegeWrite.
+func supportedPrivileges() []props.SupportedPrivilege {
+	write := props.SupportedPrivilege{Name: "write", Description: "Change anything in the calendar home"}
+	all := props.SupportedPrivilege{Name: "all", Description: "Any operation"}
+	for p := storage.Privilege(1); p != 0 && storage.PrivilegeAll.Has(p); p <<= 1 {
+		priv := props.SupportedPrivilege{Name: privilegeNames[p], Description: privilegeDescriptions[p]}
+		if storage.PrivilegeWrite.Has(p) {
+			write.Children = append(write.Children, priv)
+		} else {
+			all.Children = append(all.Children, priv)
+		}
+	}
+	all.Children = append(all.Children, write)
+	return []props.SupportedPrivilege{all}
+}
+
+// privileges returns what authUser may do with resources owned by ownerID.
+// Users hold every privilege on their own resources and on those of groups
+// they are a direct member of (storage.GroupStorage). Other users hold what
+// the owner delegated to them (storage.DelegationStorage), or nothing.
+func (h *CaldavHandler) privileges(authUser, ownerID string) (storage.Privilege, error) {
+	if ownerID == "" || ownerID == authUser {
+		return storage.PrivilegeAll, nil
+	}
+	members, err := h.groupMembers(ownerID)
+	if err == nil && slices.Contains(members, authUser) {
+		return storage.PrivilegeAll, nil
+	} else if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, err
+	}
+	if delegation, ok := storage.As[storage.DelegationStorage](h.Storage); ok && authUser != "" {
+		return delegation.GetDelegatedPrivileges(ownerID, authUser)
+	}
+	return 0, nil
+}
+
+// requestPrivileges returns the privileges a request holds on ownerID's home.
+// Requests through a share link hold read on the shared calendar's owner
+// only, whatever the owner holds, and shareAllows narrows that to the
+// calendar itself. Other requests hold those of their AuthUser.
+func (h *CaldavHandler) requestPrivileges(ctx *RequestContext, ownerID string) (storage.Privilege, error) {
+	if ctx.Share != nil {
+		if ownerID != ctx.Share.UserID {
+			return 0, nil
+		}
+		return storage.PrivilegeRead, nil
+	}
+	return h.privileges(ctx.AuthUser, ownerID)
+}
+
+// denyAccess answers a request for resources the user holds no privilege on:
+// 403, or 404 with HideForbidden.
+func (h *CaldavHandler) denyAccess(w http.ResponseWriter, r *http.Request) {
+	if h.HideForbidden {
+		h.writeError(w, r, http.StatusNotFound, CodeNotFound)
+		return
+	}
+	h.writeError(w, r, http.StatusForbidden, CodeForbidden)
+}
+
+// deniedStatus is the status reported for a resource the user holds no
+// privilege on inside a multistatus response.
+func (h *CaldavHandler) deniedStatus() int {
+	if h.HideForbidden {
+		return http.StatusNotFound
+	}
+	return http.StatusForbidden
+}
+
+// requiredPrivilege is the privilege a request with method needs on res:
+// bind to create calendars, unbind to delete them, write-content for other
+// changes and read for the rest. PROPPATCH on a calendar only needs read, so
+// sharees can set their own preferences; handleProppatch checks write-content
+// for the other properties.
+func requiredPrivilege(method string, res Resource) storage.Privilege {
+	switch {
+	case safeMethods[method]:
+		return storage.PrivilegeRead
+	case method == "PROPPATCH" && res.ResourceType == storage.ResourceCollection:
+		return storage.PrivilegeRead
+	case method == "MKCALENDAR" || method == "MKCOL":
+		return storage.PrivilegeBind
+	case method == http.MethodDelete && res.ResourceType == storage.ResourceCollection:
+		return storage.PrivilegeUnbind
+	default:
+		return storage.PrivilegeWriteContent
+	}
+}
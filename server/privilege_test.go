@@ -0,0 +1,165 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// delegationStorage lets bob edit alice's objects, and carol manage her calendars too.
+type delegationStorage struct {
+	*storage.MockStorage
+	delegates map[string]storage.Privilege
+	deleted   []string
+}
+
+func (s *delegationStorage) GetDelegatedPrivileges(ownerID, delegateID string) (storage.Privilege, error) {
+	if ownerID != "alice" {
+		return 0, nil
+	}
+	return s.delegates[delegateID], nil
+}
+
+func (s *delegationStorage) DeleteCalendar(userID, calendarID string) error {
+	s.deleted = append(s.deleted, userID+"/"+calendarID)
+	return nil
+}
+
+func newDelegationTestHandler() (*CaldavHandler, *delegationStorage) {
+	mockStorage := &storage.MockStorage{}
+	store := &delegationStorage{MockStorage: mockStorage, delegates: map[string]storage.Privilege{
+		"bob":   storage.PrivilegeRead | storage.PrivilegeWriteContent,
+		"carol": storage.PrivilegeAll,
+	}}
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.Authenticators = []Authenticator{AuthenticatorFunc(func(r *http.Request) (string, error) {
+		return r.Header.Get("X-User"), nil
+	})}
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/caldav/alice/cal/work"}, nil)
+	mockStorage.On("CreateCalendar", "alice", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		cal := args.Get(1).(*storage.Calendar)
+		cal.Path, cal.ETag = "/caldav/alice/cal/projects", `"1"`
+	})
+	return h, store
+}
+
+func TestDelegatedBindUnbind(t *testing.T) {
+	h, store := newDelegationTestHandler()
+	send := func(method, path, user string) *httptest.ResponseRecorder {
+		body := ""
+		if method == "MKCALENDAR" {
+			body = `<C:mkcalendar xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:set><D:prop><D:displayname>Projects</D:displayname></D:prop></D:set></C:mkcalendar>`
+		}
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Write access to objects doesn't extend to creating or deleting calendars
+	w := send("MKCALENDAR", "/caldav/alice/cal/projects", "bob")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:need-privileges/>")
+	assert.Contains(t, w.Body.String(), "bind privilege required")
+	w = send(http.MethodDelete, "/caldav/alice/cal/work", "bob")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "unbind privilege required")
+	assert.Empty(t, store.deleted)
+
+	w = send("MKCALENDAR", "/caldav/alice/cal/projects", "carol")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	store.On("GetObjectPathsInCollection", "work").Return([]string{}, nil)
+	w = send(http.MethodDelete, "/caldav/alice/cal/work", "carol")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{"alice/work"}, store.deleted)
+
+	// No delegation at all
+	w = send(http.MethodDelete, "/caldav/alice/cal/work", "dave")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NotContains(t, w.Body.String(), "need-privileges")
+}
+
+func TestRequiredPrivilege(t *testing.T) {
+	collection := Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}
+	object := Resource{UserID: "alice", CalendarID: "work", ObjectID: "a.ics", ResourceType: storage.ResourceObject}
+	assert.Equal(t, storage.PrivilegeRead, requiredPrivilege("PROPFIND", collection))
+	assert.Equal(t, storage.PrivilegeRead, requiredPrivilege(http.MethodGet, object))
+	assert.Equal(t, storage.PrivilegeBind, requiredPrivilege("MKCALENDAR", collection))
+	assert.Equal(t, storage.PrivilegeUnbind, requiredPrivilege(http.MethodDelete, collection))
+	assert.Equal(t, storage.PrivilegeWriteContent, requiredPrivilege(http.MethodDelete, object))
+	assert.Equal(t, storage.PrivilegeWriteContent, requiredPrivilege(http.MethodPut, object))
+	assert.Equal(t, storage.PrivilegeRead, requiredPrivilege("PROPPATCH", collection))
+	assert.Equal(t, storage.PrivilegeWriteContent, requiredPrivilege("PROPPATCH", object))
+}
+
+func TestSupportedPrivileges(t *testing.T) {
+	tree := supportedPrivileges()
+	require.Len(t, tree, 1)
+	all := tree[0]
+	assert.Equal(t, "all", all.Name)
+
+	var names []string
+	var write props.SupportedPrivilege
+	for _, priv := range all.Children {
+		names = append(names, priv.Name)
+		if priv.Name == "write" {
+			write = priv
+		}
+	}
+	assert.Equal(t, []string{"read", "write"}, names)
+
+	names = nil
+	for _, priv := range write.Children {
+		names = append(names, priv.Name)
+		assert.NotEmpty(t, priv.Description)
+	}
+	assert.Equal(t, []string{"write-content", "bind", "unbind"}, names)
+}
+
+func TestHideForbidden(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "bob", "password").Return("bob", nil)
+	mockStorage.On("GetCalendar", "bob", "work").Return(&storage.Calendar{}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("bob", "password")
+		r.Header.Set("Content-Type", "text/calendar")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	multiget := `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` +
+		`<D:prop><D:getetag/></D:prop><D:href>/caldav/alice/cal/work/a.ics</D:href></C:calendar-multiget>`
+
+	requests := []struct{ method, path, body string }{
+		{"PROPFIND", "/caldav/alice/cal/work", ""},
+		{"REPORT", "/caldav/alice/cal/work", multiget},
+		{http.MethodGet, "/caldav/alice/cal/work/a.ics", ""},
+		{http.MethodPut, "/caldav/alice/cal/work/a.ics", "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"},
+	}
+	for _, req := range requests {
+		assert.Equal(t, http.StatusForbidden, send(req.method, req.path, req.body).Code, req.method)
+	}
+	h.HideForbidden = true
+	for _, req := range requests {
+		assert.Equal(t, http.StatusNotFound, send(req.method, req.path, req.body).Code, req.method)
+	}
+
+	// Hrefs of a multiget are checked one by one
+	h.CrossCollectionMultiget = true
+	w := send("REPORT", "/caldav/bob/cal/work", multiget)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/a.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	mockStorage.AssertNotCalled(t, "GetObject", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"io"
 	"net/http"
 
@@ -13,29 +14,52 @@ import (
 func (h *CaldavHandler) handlePropfind(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
 	// fetch all requested resources as Depth header
 	initialResource := ctx.Resource
-	children, err := h.fetchChildren(ctx.Depth, initialResource)
+	if err := h.propfindTargetExists(initialResource); err != nil {
+		// Missing resources get a plain 404, not a multistatus about them
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+	depth := ctx.Depth
+	if depth == 1 && initialResource.ResourceType == storage.ResourceCollection && h.collectionUnchanged(r, initialResource) {
+		// Polling clients that are up to date only get the calendar itself
+		depth = 0
+	}
+	children, err := h.fetchChildren(depth, initialResource)
+	if depth > 0 && initialResource.ResourceType == storage.ResourceServiceRoot {
+		children, err = h.directoryPrincipals(ctx.AuthUser)
+	}
 	if err != nil {
-		h.Logger.Error("failed to fetch children for resource",
-			"resource", initialResource,
-			"error", err)
-		http.Error(w, "Failed to fetch children", http.StatusInternalServerError)
+		h.writeStorageError(w, r, err, CodeStorage)
 		return
 	}
-	resources := append([]Resource{initialResource}, children...)
 
 	// parse request body
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		h.Logger.Error("failed to read request body",
 			"error", err)
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
 		return
 	}
-	req, _ := propfind.ParseRequest(string(bodyBytes))
+	req, reqType := propfind.ParseRequest(string(bodyBytes))
+
+	// Clients asking for g:hidden by name can grey hidden calendars out themselves
+	_, askedHidden := req["hidden"]
+	askedHidden = askedHidden && reqType == propfind.RequestTypeProp
+	listsCalendars := initialResource.ResourceType == storage.ResourceHomeSet ||
+		initialResource.ResourceType == storage.ResourcePrincipal && depth > 1
+	if h.HideHiddenCalendars && listsCalendars && !askedHidden {
+		children, err = h.withoutHiddenCalendars(ctx.AuthUser, initialResource.UserID, children)
+		if err != nil {
+			h.writeStorageError(w, r, err, CodeStorage)
+			return
+		}
+	}
+	resources := append([]Resource{initialResource}, children...)
 	// TODO: PropName handling
 
 	var docs []*etree.Document
-	for _, resource := range resources {
+	for i, resource := range resources {
 		ctx1 := *ctx             // Create a copy of the context
 		ctx1.Resource = resource // Update the context for the individual resource
 
@@ -48,7 +72,14 @@ func (h *CaldavHandler) handlePropfind(w http.ResponseWriter, r *http.Request, c
 		case storage.ResourceHomeSet:
 			doc, err = h.handlePropfindHomeSet(req, ctx1.Resource)
 		case storage.ResourceCollection:
-			doc, err = h.handlePropfindCollection(req, ctx1.Resource)
+			if h.isNotificationResource(resource) {
+				if ctx.AuthUser != resource.UserID {
+					continue // only its owner may read it
+				}
+				doc, err = h.notificationResponse(notificationPropRequest(req, reqType), resource, nil)
+				break
+			}
+			doc, err = h.handlePropfindCollection(req, ctx1.Resource, ctx.AuthUser)
 		case storage.ResourceObject:
 			doc, err = h.handlePropfindObject(req, ctx1.Resource)
 		case storage.ResourceServiceRoot:
@@ -58,10 +89,19 @@ func (h *CaldavHandler) handlePropfind(w http.ResponseWriter, r *http.Request, c
 			h.Logger.Error("unknown resource type",
 				"type", resource.ResourceType,
 				"resource", resource)
-			http.Error(w, "Unknown resource type", http.StatusNotFound)
+			h.writeError(w, r, http.StatusNotFound, CodeUnsupportedResource)
 			return
 		}
 
+		if storage.IsTransient(err) {
+			// A retry may answer for the whole resource set
+			h.writeStorageError(w, r, err, CodeStorage)
+			return
+		}
+		if i == 0 && errors.Is(err, storage.ErrNotFound) {
+			h.writeStorageError(w, r, err, CodeStorage)
+			return
+		}
 		if err != nil {
 			h.Logger.Error("error handling PROPFIND",
 				"resource_type", resource.ResourceType,
@@ -80,7 +120,7 @@ func (h *CaldavHandler) handlePropfind(w http.ResponseWriter, r *http.Request, c
 	if err != nil {
 		h.Logger.Error("failed to merge PROPFIND responses",
 			"error", err)
-		http.Error(w, "Failed to process request", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
 		return
 	}
 
@@ -89,17 +129,31 @@ func (h *CaldavHandler) handlePropfind(w http.ResponseWriter, r *http.Request, c
 	w.WriteHeader(http.StatusMultiStatus) // 207 Multi-Status
 
 	// Serialize and write the XML document
-	xmlOutput, err := mergedDoc.WriteToString()
+	xmlOutput, err := h.writeXML(mergedDoc)
 	if err != nil {
 		h.Logger.Error("failed to serialize XML response",
 			"error", err)
-		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
 		return
 	}
+	h.recordResponseSize(r, mergedDoc, xmlOutput)
 
 	w.Write([]byte(xmlOutput))
 }
 
+// propfindTargetExists returns storage.ErrNotFound when res names a calendar
+// that doesn't exist. Objects are checked when their properties are resolved.
+func (h *CaldavHandler) propfindTargetExists(res Resource) error {
+	if res.ResourceType != storage.ResourceCollection {
+		return nil
+	}
+	cal, err := h.Storage.GetCalendar(res.UserID, res.CalendarID)
+	if err == nil && cal == nil {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
 // handles individual home set request
 func (h *CaldavHandler) handlePropfindHomeSet(req propfind.ResponseMap, res Resource) (*etree.Document, error) {
 	path, err := h.URLConverter.EncodePath(res)
@@ -165,7 +219,9 @@ func (h *CaldavHandler) handlePropfindObjectWithObject(req propfind.ResponseMap,
 	return propfind.EncodeResponse(req, res.URI), nil
 }
 
-func (h *CaldavHandler) handlePropfindCollection(req propfind.ResponseMap, res Resource) (*etree.Document, error) {
+// principal is the authenticated user, whose g:color, g:hidden and g:selected
+// preferences apply.
+func (h *CaldavHandler) handlePropfindCollection(req propfind.ResponseMap, res Resource, principal string) (*etree.Document, error) {
 	path, err := h.URLConverter.EncodePath(res)
 	if err != nil {
 		h.Logger.Error("failed to encode path for resource",
@@ -181,7 +237,7 @@ func (h *CaldavHandler) handlePropfindCollection(req propfind.ResponseMap, res R
 		"resource_type", res.ResourceType)
 
 	// Resolve via resolvers
-	req = h.resolvePropfind(req, res, nil)
+	req = h.resolvePropfindAs(principal, req, res, nil)
 	return propfind.EncodeResponse(req, path), nil
 }
 
@@ -209,15 +265,51 @@ func (h *CaldavHandler) fetchChildren(depth int, parent Resource) (resources []R
 		"calendar_id", parent.CalendarID)
 
 	switch parent.ResourceType {
-	case storage.ResourceObject, storage.ResourcePrincipal:
-		// These types don't have children, return empty slice
+	case storage.ResourceObject:
+		// Objects don't have children, return empty slice
 		h.Logger.Debug("resource type has no children",
 			"resource_type", parent.ResourceType)
 		return []Resource{}, nil
 
+	case storage.ResourcePrincipal:
+		// The principal's child is its calendar home, wherever the
+		// URLConverter puts it; clients walking down from the principal
+		// find calendar-home-set this way too
+		path, err := h.URLConverter.EncodePath(Resource{UserID: parent.UserID, ResourceType: storage.ResourceHomeSet})
+		if err != nil {
+			h.Logger.Error("failed to encode home set path",
+				"user_id", parent.UserID,
+				"error", err)
+			return nil, err
+		}
+		resource, err := h.URLConverter.ParsePath(path)
+		if err != nil {
+			h.Logger.Error("failed to parse home set path",
+				"path", path,
+				"error", err)
+			return nil, err
+		}
+		resources = append(resources, resource)
+		children, err := h.fetchChildren(depth-1, resource)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, children...)
+		// The notification collection lives in the home; deeper listings
+		// reach it through the home's children
+		if depth == 1 {
+			if resources, err = h.appendNotificationCollection(resources, parent.UserID); err != nil {
+				return nil, err
+			}
+		}
+
 	case storage.ResourceCollection:
+		if h.isNotificationResource(parent) {
+			// Notifications are only listed by PROPFIND on the collection
+			return nil, nil
+		}
 		// find object (event) paths in the collection
-		paths, err := h.Storage.GetObjectPathsInCollection(parent.CalendarID)
+		paths, err := h.objectPathsInCollection(parent.UserID, parent.CalendarID)
 		if err != nil {
 			h.Logger.Error("failed to fetch event paths in collection",
 				"calendar_id", parent.CalendarID,
@@ -285,6 +377,82 @@ func (h *CaldavHandler) fetchChildren(depth int, parent Resource) (resources []R
 			}
 			resources = append(resources, children...)
 		}
+		if resources, err = h.appendNotificationCollection(resources, parent.UserID); err != nil {
+			return nil, err
+		}
 	}
 	return
 }
+
+// withoutHiddenCalendars drops the user's calendars hidden from principal,
+// and anything inside them, from resources.
+func (h *CaldavHandler) withoutHiddenCalendars(principal, userID string, resources []Resource) ([]Resource, error) {
+	calendars, err := h.Storage.GetUserCalendars(userID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := map[string]bool{}
+	for _, cal := range calendars {
+		res, err := h.URLConverter.ParsePath(cal.Path)
+		if err != nil {
+			return nil, err
+		}
+		prefs, err := h.calendarPreferences(principal, userID, res.CalendarID)
+		if err != nil {
+			return nil, err
+		}
+		if prefs.Hidden != nil && *prefs.Hidden || prefs.Hidden == nil && cal.Hidden {
+			hidden[res.CalendarID] = true
+		}
+	}
+	if len(hidden) == 0 {
+		return resources, nil
+	}
+	kept := make([]Resource, 0, len(resources))
+	for _, res := range resources {
+		if res.UserID == userID && hidden[res.CalendarID] {
+			continue
+		}
+		kept = append(kept, res)
+	}
+	return kept, nil
+}
+
+// directoryPrincipals returns the principals listed below the service root
+// for authUser: every user of a storage.UserDirectoryStorage when IsAdmin
+// accepts authUser, none otherwise. Their homes aren't listed, whatever the
+// depth, so one request doesn't walk every user's calendars.
+func (h *CaldavHandler) directoryPrincipals(authUser string) ([]Resource, error) {
+	directory, ok := storage.As[storage.UserDirectoryStorage](h.Storage)
+	if !ok || authUser == "" || h.IsAdmin == nil || !h.IsAdmin(authUser) {
+		return nil, nil
+	}
+	userIDs, err := directory.UserIDs()
+	if err != nil {
+		h.Logger.Error("failed to list users",
+			"error", err)
+		return nil, err
+	}
+	resources := make([]Resource, 0, len(userIDs))
+	for _, userID := range userIDs {
+		path, err := h.URLConverter.EncodePath(Resource{UserID: userID, ResourceType: storage.ResourcePrincipal})
+		if err != nil {
+			h.Logger.Error("failed to encode principal path",
+				"user_id", userID,
+				"error", err)
+			return nil, err
+		}
+		resource, err := h.URLConverter.ParsePath(path)
+		if err != nil {
+			h.Logger.Error("failed to parse principal path",
+				"path", path,
+				"error", err)
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	h.Logger.Debug("listing principals for administrator",
+		"user_id", authUser,
+		"principals", len(resources))
+	return resources, nil
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"time"
 
 	"github.com/cyp0633/libcaldora/internal/xml/propfind"
@@ -18,10 +19,13 @@ type propEnv struct {
 	h       *CaldavHandler
 	res     Resource
 	preload *storage.CalendarObject
+	// principal is the authenticated user, if known
+	principal string
 
 	user     *storage.User
 	calendar *storage.Calendar
 	object   *storage.CalendarObject
+	prefs    *storage.CalendarPreferences
 }
 
 func newPropEnv(h *CaldavHandler, res Resource, preload *storage.CalendarObject) *propEnv {
@@ -69,19 +73,32 @@ func (e *propEnv) GetCalendar() (*storage.Calendar, error) {
 	return e.calendar, nil
 }
 
+// GetPreferences returns the principal's preferences for the calendar.
+func (e *propEnv) GetPreferences() (storage.CalendarPreferences, error) {
+	if e.prefs != nil {
+		return *e.prefs, nil
+	}
+	p, err := e.h.calendarPreferences(e.principal, e.res.UserID, e.res.CalendarID)
+	if err != nil {
+		return p, err
+	}
+	e.prefs = &p
+	return p, nil
+}
+
 func (e *propEnv) GetObject() (*storage.CalendarObject, error) {
 	if e.object != nil {
 		return e.object, nil
 	}
 	if e.preload != nil {
-		e.object = e.preload
+		e.object = e.h.view(e.preload)
 		return e.object, nil
 	}
 	o, err := e.h.Storage.GetObject(e.res.UserID, e.res.CalendarID, e.res.ObjectID)
 	if err != nil {
 		return nil, err
 	}
-	e.object = o
+	e.object = e.h.view(o)
 	return e.object, nil
 }
 
@@ -92,7 +109,7 @@ func (e *propEnv) privilegeSet() ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		if cal != nil && cal.ReadOnly {
+		if cal != nil && (cal.ReadOnly || cal.Archived) {
 			return []string{"read"}, nil
 		}
 		if cal == nil {
@@ -117,6 +134,27 @@ func buildACLProperty(env *propEnv, principal string) mo.Result[props.Property]
 	return mo.Ok[props.Property](&props.ACL{Aces: []props.ACE{ace}})
 }
 
+// calendarLimitResolvers report the per-user calendar limit, absent when unset.
+var calendarLimitResolvers = map[string]Resolver{
+	"max-calendars": func(env *propEnv) mo.Result[props.Property] {
+		if env.h.Limits.MaxCalendars <= 0 {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.MaxCalendars{Value: env.h.Limits.MaxCalendars})
+	},
+	"calendars-remaining": func(env *propEnv) mo.Result[props.Property] {
+		remaining, err := env.h.calendarsRemaining(env.res.UserID)
+		if err != nil {
+			env.h.Logger.Error("failed to count calendars", "resource", env.res, "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if remaining < 0 {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.CalendarsRemaining{Value: remaining})
+	},
+}
+
 // resolveWith dispatches properties using the provided resolver table.
 func resolveWith(env *propEnv, resolvers map[string]Resolver, req propfind.ResponseMap) propfind.ResponseMap {
 	for key := range req {
@@ -140,6 +178,10 @@ var commonResolvers = map[string]Resolver{
 		return mo.Ok[props.Property](&props.Owner{Value: href})
 	},
 	"current-user-principal": func(env *propEnv) mo.Result[props.Property] {
+		if env.res.UserID == "" {
+			// Only the service root is reachable without a principal
+			return mo.Ok[props.Property](&props.CurrentUserPrincipal{Unauthenticated: true})
+		}
 		href, err := env.PrincipalHref()
 		if err != nil {
 			env.h.Logger.Error("failed to encode principal URL", "resource", env.res, "error", err)
@@ -147,6 +189,25 @@ var commonResolvers = map[string]Resolver{
 		}
 		return mo.Ok[props.Property](&props.CurrentUserPrincipal{Value: href})
 	},
+	// The ACE names the owner's principal, the same href as owner and
+	// principal-URL, whatever the resource type
+	"acl": func(env *propEnv) mo.Result[props.Property] {
+		principal, err := env.PrincipalHref()
+		if err != nil {
+			env.h.Logger.Error("failed to encode principal href for acl", "error", err)
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return buildACLProperty(env, principal)
+	},
+	// Principals live directly under the service root
+	"principal-collection-set": func(env *propEnv) mo.Result[props.Property] {
+		href, err := env.h.URLConverter.EncodePath(Resource{ResourceType: storage.ResourceServiceRoot})
+		if err != nil {
+			env.h.Logger.Error("failed to encode service root URL", "error", err)
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.PrincipalCollectionSet{Hrefs: []string{href}})
+	},
 	"principal-url": func(env *propEnv) mo.Result[props.Property] {
 		href, err := env.PrincipalHref()
 		if err != nil {
@@ -155,8 +216,8 @@ var commonResolvers = map[string]Resolver{
 		}
 		return mo.Ok[props.Property](&props.PrincipalURL{Value: href})
 	},
-	"supported-report-set": func(_ *propEnv) mo.Result[props.Property] {
-		return mo.Ok[props.Property](&props.SupportedReportSet{Reports: []props.ReportType{}})
+	"supported-report-set": func(env *propEnv) mo.Result[props.Property] {
+		return mo.Ok[props.Property](&props.SupportedReportSet{Reports: env.h.supportedReports(env.res)})
 	},
 	"current-user-privilege-set": func(env *propEnv) mo.Result[props.Property] {
 		privs, err := env.privilegeSet()
@@ -169,6 +230,9 @@ var commonResolvers = map[string]Resolver{
 		}
 		return mo.Ok[props.Property](&props.CurrentUserPrivilegeSet{Privileges: privs})
 	},
+	"supported-privilege-set": func(_ *propEnv) mo.Result[props.Property] {
+		return mo.Ok[props.Property](&props.SupportedPrivilegeSet{Privileges: supportedPrivileges()})
+	},
 	"calendar-home-set": func(env *propEnv) mo.Result[props.Property] {
 		href, err := env.HomeSetHref()
 		if err != nil {
@@ -212,11 +276,10 @@ var principalResolvers = func() map[string]Resolver {
 			env.h.Logger.Error("failed to get user for displayname", "error", err)
 			return mo.Err[props.Property](propfind.ErrInternal)
 		}
-		name := env.res.UserID
 		if user != nil && user.DisplayName != "" {
-			name = user.DisplayName
+			return mo.Ok[props.Property](&props.DisplayName{Value: user.DisplayName, Lang: user.Language})
 		}
-		return mo.Ok[props.Property](&props.DisplayName{Value: name})
+		return mo.Ok[props.Property](&props.DisplayName{Value: env.res.UserID})
 	}
 	m["resourcetype"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.Resourcetype{Type: props.ResourcePrincipal})
@@ -246,14 +309,110 @@ var principalResolvers = func() map[string]Resolver {
 		}
 		return mo.Ok[props.Property](&props.Timezone{Value: user.PreferredTimezone})
 	}
-	// ACL principal uses its own href as principal
-	m["acl"] = func(env *propEnv) mo.Result[props.Property] {
-		href, err := env.ResourceHref()
+	m["week-start"] = func(env *propEnv) mo.Result[props.Property] {
+		user, err := env.GetUser()
+		if err != nil {
+			env.h.Logger.Error("failed to get user for week start", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if user == nil || user.WeekStart == "" {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.WeekStart{Value: user.WeekStart})
+	}
+	m["notification-url"] = func(env *propEnv) mo.Result[props.Property] {
+		if _, ok := env.h.notificationStorage(); !ok {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		href, err := env.h.URLConverter.EncodePath(Resource{UserID: env.res.UserID, CalendarID: NotificationCollection, ResourceType: storage.ResourceCollection})
+		if err != nil {
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.NotificationURL{Value: href})
+	}
+	m["working-hours"] = func(env *propEnv) mo.Result[props.Property] {
+		user, err := env.GetUser()
+		if err != nil {
+			env.h.Logger.Error("failed to get user for working hours", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if user == nil || len(user.WorkingHours) == 0 {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		hours := make([]props.WorkingHoursPeriod, 0, len(user.WorkingHours))
+		for _, wh := range user.WorkingHours {
+			hours = append(hours, props.WorkingHoursPeriod{
+				Days:  wh.Days,
+				Start: formatClock(wh.Start),
+				End:   formatClock(wh.End),
+			})
+		}
+		return mo.Ok[props.Property](&props.WorkingHours{Hours: hours})
+	}
+	m["schedule-inbox-url"] = func(env *propEnv) mo.Result[props.Property] {
+		if _, ok := env.h.availabilityStorage(); !ok || !env.h.features().EnableScheduling {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		href, err := env.h.URLConverter.EncodePath(Resource{UserID: env.res.UserID, CalendarID: ScheduleInboxCollection, ResourceType: storage.ResourceCollection})
 		if err != nil {
-			env.h.Logger.Error("failed to encode resource href for acl", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.ScheduleInboxURL{Href: href})
+	}
+	m["schedule-outbox-url"] = func(env *propEnv) mo.Result[props.Property] {
+		if !env.h.schedulingPost() {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		return buildACLProperty(env, href)
+		href, err := env.h.URLConverter.EncodePath(Resource{UserID: env.res.UserID, CalendarID: ScheduleOutboxCollection, ResourceType: storage.ResourceCollection})
+		if err != nil {
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.ScheduleOutboxURL{Href: href})
+	}
+	m["group-member-set"] = func(env *propEnv) mo.Result[props.Property] {
+		members, err := env.h.groupMembers(env.res.UserID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		} else if err != nil {
+			env.h.Logger.Error("failed to get group members", "resource", env.res, "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		hrefs, err := env.h.principalHrefs(members)
+		if err != nil {
+			env.h.Logger.Error("failed to encode member principal hrefs", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.GroupMemberSet{Hrefs: hrefs})
+	}
+	m["group-membership"] = func(env *propEnv) mo.Result[props.Property] {
+		groups, ok := storage.As[storage.GroupStorage](env.h.Storage)
+		if !ok {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		ids, err := groups.GetUserGroups(env.res.UserID)
+		if err != nil {
+			env.h.Logger.Error("failed to get user groups", "resource", env.res, "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		hrefs, err := env.h.principalHrefs(ids)
+		if err != nil {
+			env.h.Logger.Error("failed to encode group principal hrefs", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.GroupMembership{Hrefs: hrefs})
+	}
+	m["calendar-user-type"] = func(env *propEnv) mo.Result[props.Property] {
+		_, err := env.h.groupMembers(env.res.UserID)
+		if err == nil {
+			return mo.Ok[props.Property](&props.CalendarUserType{Value: "group"})
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			env.h.Logger.Error("failed to get group members", "resource", env.res, "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.CalendarUserType{Value: "individual"})
+	}
+	for k, v := range calendarLimitResolvers {
+		m[k] = v
 	}
 	return m
 }()
@@ -264,25 +423,13 @@ var homeSetResolvers = func() map[string]Resolver {
 	for k, v := range commonResolvers {
 		m[k] = v
 	}
-	m["displayname"] = func(_ *propEnv) mo.Result[props.Property] {
-		return mo.Ok[props.Property](&props.DisplayName{Value: "Calendar Home"})
+	m["displayname"] = func(env *propEnv) mo.Result[props.Property] {
+		return mo.Ok[props.Property](env.generatedName(NameCalendarHome, "Calendar Home"))
 	}
 	m["resourcetype"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.Resourcetype{Type: props.ResourceHomeSet})
 	}
-	// ACL for homeset uses principal as ACE principal
-	m["acl"] = func(env *propEnv) mo.Result[props.Property] {
-		principal, err := env.PrincipalHref()
-		if err != nil {
-			env.h.Logger.Error("failed to encode principal href for acl", "error", err)
-			return mo.Err[props.Property](propfind.ErrNotFound)
-		}
-		return buildACLProperty(env, principal)
-	}
-	// supported-calendar-data on homeset used "icalendar" in existing handlers
-	m["supported-calendar-data"] = func(_ *propEnv) mo.Result[props.Property] {
-		return mo.Ok[props.Property](&props.SupportedCalendarData{ContentType: "icalendar", Version: "2.0"})
-	}
+	m["supported-calendar-data"] = resolveSupportedCalendarData
 	// size/limits
 	m["max-resource-size"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.MaxResourceSize{Value: 10485760})
@@ -303,6 +450,9 @@ var homeSetResolvers = func() map[string]Resolver {
 	m["schedule-inbox-url"] = func(_ *propEnv) mo.Result[props.Property] { return mo.Err[props.Property](propfind.ErrNotFound) }
 	m["schedule-outbox-url"] = m["schedule-inbox-url"]
 	m["schedule-default-calendar-url"] = m["schedule-inbox-url"]
+	for k, v := range calendarLimitResolvers {
+		m[k] = v
+	}
 	return m
 }()
 
@@ -318,14 +468,26 @@ var collectionResolvers = func() map[string]Resolver {
 			env.h.Logger.Error("failed to get calendar for displayname", "error", err)
 			return mo.Err[props.Property](propfind.ErrInternal)
 		}
+		prefs, err := env.GetPreferences()
+		if err != nil {
+			env.h.Logger.Error("failed to get calendar preferences for displayname", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if prefs.DisplayName != nil {
+			return mo.Ok[props.Property](&props.DisplayName{Value: *prefs.DisplayName})
+		}
 		if cal == nil || cal.CalendarData == nil {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		name, err := cal.CalendarData.Props.Text(ical.PropName)
+		prop := cal.CalendarData.Props.Get(ical.PropName)
+		if prop == nil {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		name, err := textDisplayName(prop)
 		if err != nil {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		return mo.Ok[props.Property](&props.DisplayName{Value: name})
+		return mo.Ok[props.Property](name)
 	}
 	m["resourcetype"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.Resourcetype{Type: props.ResourceCollection})
@@ -341,6 +503,24 @@ var collectionResolvers = func() map[string]Resolver {
 		}
 		return mo.Ok[props.Property](&props.GetEtag{Value: cal.ETag})
 	}
+	m["getctag"] = func(env *propEnv) mo.Result[props.Property] {
+		cal, err := env.GetCalendar()
+		if err != nil {
+			env.h.Logger.Error("failed to get calendar for ctag", "error", err)
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		if cal == nil {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		ctag := cal.CTag
+		if ctag == "" {
+			ctag = env.h.syncCTag(env.res.UserID, env.res.CalendarID)
+		}
+		if ctag == "" {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.GetCTag{Value: ctag})
+	}
 	m["getlastmodified"] = func(env *propEnv) mo.Result[props.Property] {
 		cal, err := env.GetCalendar()
 		if err != nil {
@@ -399,9 +579,7 @@ var collectionResolvers = func() map[string]Resolver {
 		}
 		return mo.Ok[props.Property](&props.SupportedCalendarComponentSet{Components: cal.SupportedComponents})
 	}
-	m["supported-calendar-data"] = func(_ *propEnv) mo.Result[props.Property] {
-		return mo.Ok[props.Property](&props.SupportedCalendarData{ContentType: "icalendar", Version: "2.0"})
-	}
+	m["supported-calendar-data"] = resolveSupportedCalendarData
 	m["max-resource-size"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.MaxResourceSize{Value: 10485760})
 	}
@@ -417,7 +595,7 @@ var collectionResolvers = func() map[string]Resolver {
 	m["max-attendees-per-instance"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.MaxAttendeesPerInstance{Value: 100})
 	}
-	m["calendar-color"] = func(env *propEnv) mo.Result[props.Property] {
+	calendarColor := func(env *propEnv) mo.Result[props.Property] {
 		cal, err := env.GetCalendar()
 		if err != nil {
 			return mo.Err[props.Property](propfind.ErrInternal)
@@ -431,15 +609,96 @@ var collectionResolvers = func() map[string]Resolver {
 		}
 		return mo.Ok[props.Property](&props.CalendarColor{Value: color})
 	}
-	m["color"] = m["calendar-color"]
-	// ACL for collection uses its own href as principal
-	m["acl"] = func(env *propEnv) mo.Result[props.Property] {
-		href, err := env.ResourceHref()
+	// Colors are the principal's preference, the calendar's COLOR without one
+	m["calendar-color"] = func(env *propEnv) mo.Result[props.Property] {
+		prefs, err := env.GetPreferences()
+		if err != nil {
+			env.h.Logger.Error("failed to get calendar preferences for color", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if prefs.Color != nil {
+			return mo.Ok[props.Property](&props.CalendarColor{Value: *prefs.Color})
+		}
+		return calendarColor(env)
+	}
+	m["color"] = func(env *propEnv) mo.Result[props.Property] {
+		color := m["calendar-color"](env)
+		if c, ok := color.OrEmpty().(*props.CalendarColor); ok {
+			return mo.Ok[props.Property](&props.Color{Value: c.Value})
+		}
+		return color
+	}
+	m["bulk-requests"] = func(env *propEnv) mo.Result[props.Property] {
+		if !env.h.features().EnableBulk {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.BulkRequests{MaxResources: bulkMaxResources, MaxBytes: bulkMaxBytes})
+	}
+	m["hidden"] = func(env *propEnv) mo.Result[props.Property] {
+		cal, err := env.GetCalendar()
+		if err != nil {
+			env.h.Logger.Error("failed to get calendar for hidden", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		prefs, err := env.GetPreferences()
+		if err != nil {
+			env.h.Logger.Error("failed to get calendar preferences for hidden", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if prefs.Hidden != nil {
+			return mo.Ok[props.Property](&props.Hidden{Value: *prefs.Hidden})
+		}
+		return mo.Ok[props.Property](&props.Hidden{Value: cal != nil && cal.Hidden})
+	}
+	m["selected"] = func(env *propEnv) mo.Result[props.Property] {
+		prefs, err := env.GetPreferences()
+		if err != nil {
+			env.h.Logger.Error("failed to get calendar preferences for selected", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.Selected{Value: prefs.Selected == nil || *prefs.Selected})
+	}
+	m["archived"] = func(env *propEnv) mo.Result[props.Property] {
+		cal, err := env.GetCalendar()
+		if err != nil {
+			env.h.Logger.Error("failed to get calendar for archived", "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if cal == nil {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.Archived{Value: cal.Archived})
+	}
+	m["max-objects"] = func(env *propEnv) mo.Result[props.Property] {
+		if env.h.Limits.MaxObjects <= 0 {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.MaxObjects{Value: env.h.Limits.MaxObjects})
+	}
+	m["objects-remaining"] = func(env *propEnv) mo.Result[props.Property] {
+		remaining, err := env.h.objectsRemaining(env.res.UserID, env.res.CalendarID)
 		if err != nil {
-			env.h.Logger.Error("failed to encode resource href for acl", "error", err)
+			env.h.Logger.Error("failed to count objects", "resource", env.res, "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		if remaining < 0 {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		return buildACLProperty(env, href)
+		return mo.Ok[props.Property](&props.ObjectsRemaining{Value: remaining})
+	}
+	m["sync-token"] = func(env *propEnv) mo.Result[props.Property] {
+		syncer, ok := storage.As[storage.SyncStorage](env.h.Storage)
+		if !ok || !env.h.features().EnableSync {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		token, err := syncer.GetSyncToken(env.res.UserID, env.res.CalendarID)
+		if errors.Is(err, storage.ErrUnsupported) {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		} else if err != nil {
+			env.h.Logger.Error("failed to get sync token", "resource", env.res, "error", err)
+			return mo.Err[props.Property](propfind.ErrInternal)
+		}
+		return mo.Ok[props.Property](&props.SyncToken{Value: token})
 	}
 	// schedule props not implemented
 	m["schedule-inbox-url"] = func(_ *propEnv) mo.Result[props.Property] { return mo.Err[props.Property](propfind.ErrNotFound) }
@@ -456,43 +715,73 @@ var objectResolvers = func() map[string]Resolver {
 	}
 	m["displayname"] = func(env *propEnv) mo.Result[props.Property] {
 		obj, err := env.GetObject()
-		if err != nil || obj == nil || len(obj.Component) == 0 {
+		if err != nil || obj == nil || obj.Master() == nil {
 			env.h.Logger.Debug("failed to get object for displayname", "error", err)
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		name, err := obj.Component[0].Props.Text(ical.PropName)
+		propName := ical.PropName
+		if env.h.ObjectDisplayNameSummary {
+			propName = ical.PropSummary
+		}
+		prop := obj.Master().Props.Get(propName)
+		if prop == nil {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		name, err := textDisplayName(prop)
 		if err != nil {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		return mo.Ok[props.Property](&props.DisplayName{Value: name})
+		return mo.Ok[props.Property](name)
 	}
 	m["resourcetype"] = func(env *propEnv) mo.Result[props.Property] {
 		obj, err := env.GetObject()
-		if err != nil || obj == nil || len(obj.Component) == 0 {
+		if err != nil || obj == nil || obj.Master() == nil {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		return mo.Ok[props.Property](&props.Resourcetype{Type: props.ResourceObject, ObjectType: obj.Component[0].Name})
+		return mo.Ok[props.Property](&props.Resourcetype{Type: props.ResourceObject, ObjectType: obj.Master().Name})
 	}
 	m["getetag"] = func(env *propEnv) mo.Result[props.Property] {
 		obj, err := env.GetObject()
-		if err != nil || obj == nil || obj.ETag == "" {
+		if err != nil || obj == nil {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		etag := env.h.objectETag(obj)
+		if etag == "" {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		return mo.Ok[props.Property](&props.GetEtag{Value: obj.ETag})
+		return mo.Ok[props.Property](&props.GetEtag{Value: etag})
 	}
 	m["getlastmodified"] = func(env *propEnv) mo.Result[props.Property] {
 		obj, err := env.GetObject()
-		if err != nil || obj == nil || len(obj.Component) == 0 {
+		if err != nil || obj == nil || obj.Master() == nil {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
-		t, err := obj.Component[0].Props.DateTime(ical.PropLastModified, nil)
+		t, err := obj.Master().Props.DateTime(ical.PropLastModified, nil)
 		if err != nil {
 			return mo.Err[props.Property](propfind.ErrNotFound)
 		}
 		return mo.Ok[props.Property](&props.GetLastModified{Value: t})
 	}
-	m["getcontenttype"] = func(_ *propEnv) mo.Result[props.Property] {
-		return mo.Ok[props.Property](&props.GetContentType{Value: "text/calendar"})
+	m["getcontenttype"] = func(env *propEnv) mo.Result[props.Property] {
+		obj, err := env.GetObject()
+		if err != nil {
+			obj = nil
+		}
+		return mo.Ok[props.Property](&props.GetContentType{Value: env.h.objectContentType(obj)})
+	}
+	m["getcontentlength"] = func(env *propEnv) mo.Result[props.Property] {
+		obj, err := env.GetObject()
+		if err != nil || obj == nil || len(obj.Component) == 0 {
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		// Storage keeps parsed components only, so measure them re-serialized,
+		// the same way calendar-data returns them
+		ics, err := storage.ICalCompToICS(obj.Component, false)
+		if err != nil {
+			env.h.Logger.Error("failed to convert component to ics", "error", err)
+			return mo.Err[props.Property](propfind.ErrNotFound)
+		}
+		return mo.Ok[props.Property](&props.GetContentLength{Value: int64(len(ics))})
 	}
 	m["calendar-description"] = func(env *propEnv) mo.Result[props.Property] {
 		cal, err := env.GetCalendar()
@@ -538,9 +827,7 @@ var objectResolvers = func() map[string]Resolver {
 		}
 		return mo.Ok[props.Property](&props.CalendarData{ICal: ics})
 	}
-	m["supported-calendar-data"] = func(_ *propEnv) mo.Result[props.Property] {
-		return mo.Ok[props.Property](&props.SupportedCalendarData{ContentType: "text/calendar", Version: "2.0"})
-	}
+	m["supported-calendar-data"] = resolveSupportedCalendarData
 	m["max-resource-size"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.MaxResourceSize{Value: 10485760})
 	}
@@ -556,15 +843,6 @@ var objectResolvers = func() map[string]Resolver {
 	m["max-attendees-per-instance"] = func(_ *propEnv) mo.Result[props.Property] {
 		return mo.Ok[props.Property](&props.MaxAttendeesPerInstance{Value: 100})
 	}
-	// For object ACL, existing code used the resource's own URI as principal
-	m["acl"] = func(env *propEnv) mo.Result[props.Property] {
-		href, err := env.ResourceHref()
-		if err != nil {
-			env.h.Logger.Error("failed to encode resource href for acl", "error", err)
-			return mo.Err[props.Property](propfind.ErrNotFound)
-		}
-		return buildACLProperty(env, href)
-	}
 	// Color on object uses user's preferred color, per existing behavior
 	m["calendar-color"] = func(env *propEnv) mo.Result[props.Property] {
 		user, err := env.GetUser()
@@ -588,12 +866,13 @@ var objectResolvers = func() map[string]Resolver {
 var serviceRootResolvers = func() map[string]Resolver {
 	m := map[string]Resolver{}
 	// Display name
-	m["displayname"] = func(_ *propEnv) mo.Result[props.Property] {
-		return mo.Ok[props.Property](&props.DisplayName{Value: "CalDAV Service Root"})
+	m["displayname"] = func(env *propEnv) mo.Result[props.Property] {
+		return mo.Ok[props.Property](env.generatedName(NameServiceRoot, "CalDAV Service Root"))
 	}
 	// current-user-principal, principal-url
 	m["current-user-principal"] = commonResolvers["current-user-principal"]
 	m["principal-url"] = commonResolvers["principal-url"]
+	m["principal-collection-set"] = commonResolvers["principal-collection-set"]
 	m["calendar-home-set"] = commonResolvers["calendar-home-set"]
 	// privileges different on service root
 	m["current-user-privilege-set"] = func(_ *propEnv) mo.Result[props.Property] {
@@ -604,7 +883,14 @@ var serviceRootResolvers = func() map[string]Resolver {
 
 // resolvePropfind fills the ResponseMap for the given resource type.
 func (h *CaldavHandler) resolvePropfind(req propfind.ResponseMap, res Resource, preload *storage.CalendarObject) propfind.ResponseMap {
+	return h.resolvePropfindAs("", req, res, preload)
+}
+
+// resolvePropfindAs is resolvePropfind for a known principal, whose calendar
+// preferences then apply.
+func (h *CaldavHandler) resolvePropfindAs(principal string, req propfind.ResponseMap, res Resource, preload *storage.CalendarObject) propfind.ResponseMap {
 	env := newPropEnv(h, res, preload)
+	env.principal = principal
 	var table map[string]Resolver
 	switch res.ResourceType {
 	case storage.ResourcePrincipal:
@@ -620,5 +906,5 @@ func (h *CaldavHandler) resolvePropfind(req propfind.ResponseMap, res Resource,
 	default:
 		table = map[string]Resolver{}
 	}
-	return resolveWith(env, table, req)
+	return h.resolveDeadProperties(resolveWith(env, table, req), res)
 }
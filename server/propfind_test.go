@@ -1,8 +1,11 @@
 package server
 
 import (
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
@@ -16,6 +19,7 @@ import (
 	"github.com/samber/mo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // Use storage types for testing
@@ -131,7 +135,7 @@ func TestHandlePropfindHomeSet(t *testing.T) {
 
 		// Check if principal-url was properly set
 		root := doc.Root()
-		principalURL := root.FindElement("//d:propstat/d:prop/d:principal-url/d:href")
+		principalURL := root.FindElement("//d:propstat/d:prop/d:principal-URL/d:href")
 		assert.NotNil(t, principalURL)
 		assert.Equal(t, "/principals/user1/", principalURL.Text())
 
@@ -242,6 +246,7 @@ func TestHandlePropfindCollectionReadOnlyPrivileges(t *testing.T) {
 	}
 
 	mockURLConverter.On("EncodePath", resource).Return("/user1/cal/cal1/", nil)
+	mockURLConverter.On("EncodePath", Resource{UserID: "user1", ResourceType: storage.ResourcePrincipal}).Return("/user1/", nil)
 	mockStorage.On("GetCalendar", "user1", "cal1").Return(&storage.Calendar{
 		Path:     "/user1/cal/cal1/",
 		ReadOnly: true,
@@ -252,7 +257,7 @@ func TestHandlePropfindCollectionReadOnlyPrivileges(t *testing.T) {
 		"acl":                        mo.Ok[props.Property](nil),
 	}
 
-	doc, err := h.handlePropfindCollection(req, resource)
+	doc, err := h.handlePropfindCollection(req, resource, "user1")
 	assert.NoError(t, err)
 	assert.NotNil(t, doc)
 
@@ -308,17 +313,24 @@ func TestFetchChildren(t *testing.T) {
 		assert.Empty(t, resources)
 	})
 
-	// Test case 3: storage.ResourcePrincipal has no children
+	// Test case 3: storage.ResourcePrincipal has its home set as child
 	t.Run("Resource Principal", func(t *testing.T) {
 		parent := Resource{
 			UserID:       "user1",
 			ResourceType: storage.ResourcePrincipal,
 		}
+		homeSet := Resource{
+			UserID:       "user1",
+			ResourceType: storage.ResourceHomeSet,
+			URI:          "/calendars/user1/home",
+		}
+		mockURLConverter.On("EncodePath", Resource{UserID: "user1", ResourceType: storage.ResourceHomeSet}).Return("/calendars/user1/home", nil)
+		mockURLConverter.On("ParsePath", "/calendars/user1/home").Return(homeSet, nil)
 
 		resources, err := h.fetchChildren(1, parent)
 
 		assert.NoError(t, err)
-		assert.Empty(t, resources)
+		assert.Equal(t, []Resource{homeSet}, resources)
 	})
 
 	// Test case 4: storage.ResourceCollection with children
@@ -673,8 +685,9 @@ func TestHandlePropfindMultiComponentCalendarData(t *testing.T) {
 
 			// Create request map for calendar-data property
 			req := propfind.ResponseMap{
-				"calendar-data": mo.Ok[props.Property](nil),
-				"getetag":       mo.Ok[props.Property](nil),
+				"calendar-data":    mo.Ok[props.Property](nil),
+				"getetag":          mo.Ok[props.Property](nil),
+				"getcontentlength": mo.Ok[props.Property](nil),
 			}
 
 			// Call function
@@ -700,6 +713,11 @@ func TestHandlePropfindMultiComponentCalendarData(t *testing.T) {
 			assert.NotNil(t, etag)
 			assert.Equal(t, testObject.ETag, etag.Text())
 
+			// The length is that of the calendar-data
+			length := response.FindElement("//d:getcontentlength")
+			require.NotNil(t, length)
+			assert.Equal(t, strconv.Itoa(len(response.FindElement("//cal:calendar-data").Text())), length.Text())
+
 			// Additional response checks
 			tt.checkResponse(t, doc)
 
@@ -709,3 +727,119 @@ func TestHandlePropfindMultiComponentCalendarData(t *testing.T) {
 		})
 	}
 }
+
+func TestPropfindPrincipalHrefsConsistent(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/caldav/alice/cal/work"}, nil)
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "1")
+	mockStorage.On("GetObject", "alice", "work", "a.ics").
+		Return(&storage.CalendarObject{Path: "/caldav/alice/cal/work/a.ics", Component: []*ical.Component{event}}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:owner/><D:acl/><D:principal-URL/><D:principal-collection-set/></D:prop></D:propfind>`
+	for _, path := range []string{"/caldav/alice/cal/work/a.ics", "/caldav/alice/cal/work", "/caldav/alice/cal", "/caldav/alice"} {
+		t.Run(path, func(t *testing.T) {
+			w := serveAlice(h, "PROPFIND", path, body, map[string]string{"Depth": "0"})
+			require.Equal(t, http.StatusMultiStatus, w.Code)
+			doc := etree.NewDocument()
+			require.NoError(t, doc.ReadFromString(w.Body.String()))
+			hrefs := map[string]string{}
+			for _, prop := range []string{"owner", "acl/ace/principal", "principal-URL", "principal-collection-set"} {
+				elem := doc.FindElement("//prop/" + prop + "/href")
+				require.NotNil(t, elem, prop)
+				hrefs[prop] = elem.Text()
+			}
+			assert.Equal(t, "/caldav/alice", hrefs["owner"])
+			assert.Equal(t, hrefs["owner"], hrefs["acl/ace/principal"])
+			assert.Equal(t, hrefs["owner"], hrefs["principal-URL"])
+			assert.Equal(t, "/caldav/", hrefs["principal-collection-set"])
+		})
+	}
+}
+
+func TestPropfindMissingResource(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetCalendar", "alice", "gone").Return(nil, storage.ErrNotFound)
+	mockStorage.On("GetObject", "alice", "work", "gone.ics").Return(nil, storage.ErrNotFound)
+
+	for _, path := range []string{
+		"/caldav/alice/cal/gone", "/caldav/alice/cal/gone/", "/caldav/alice/cal//gone/",
+		"/caldav/alice/cal/work/gone.ics", "/caldav/alice/cal/work/gone.ics/", "/caldav/alice/cal/work//gone.ics",
+		"/caldav/alice/cal/work/x/gone.ics", "/caldav/alice/nowhere/",
+	} {
+		for _, depth := range []string{"0", "1"} {
+			w := serveAlice(h, "PROPFIND", path, "", map[string]string{"Depth": depth})
+			assert.Equal(t, http.StatusNotFound, w.Code, "%s Depth %s", path, depth)
+			assert.NotContains(t, w.Body.String(), "multistatus", "%s Depth %s", path, depth)
+		}
+	}
+
+	// The error body follows ErrorFormat
+	h.ErrorFormat = ErrorFormatXML
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/gone/", "", map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, string(CodeNotFound), w.Header().Get(errorCodeHeader))
+	assert.Contains(t, w.Body.String(), "<d:error")
+	assert.Contains(t, w.Body.String(), "<lc:not-found/>")
+
+	// Existing resources are still listed
+	h.ErrorFormat = ErrorFormatText
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", "", map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+}
+
+func TestPropfindPrincipalDepth1(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/", body, map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	resp := w.Body.String()
+	assert.Contains(t, resp, "<d:href>/caldav/alice</d:href>")
+	assert.Contains(t, resp, "<d:href>/caldav/alice/cal</d:href>")
+	assert.NotContains(t, resp, "/caldav/alice/cal/work")
+
+	// Depth 0 keeps to the principal
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/", body, map[string]string{"Depth": "0"})
+	assert.NotContains(t, w.Body.String(), "<d:href>/caldav/alice/cal</d:href>")
+}
+
+// directoryStorage lists a fixed set of users.
+type directoryStorage struct {
+	*storage.MockStorage
+}
+
+func (s *directoryStorage) UserIDs() ([]string, error) {
+	return []string{"alice", "bob"}, nil
+}
+
+func TestPropfindServiceRootDirectory(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Storage = &directoryStorage{MockStorage: mockStorage}
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+
+	// Only administrators get the principals
+	w := serveAlice(h, "PROPFIND", "/caldav/", body, map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.NotContains(t, w.Body.String(), "<d:href>/caldav/bob</d:href>")
+
+	h.IsAdmin = func(userID string) bool { return userID == "alice" }
+	w = serveAlice(h, "PROPFIND", "/caldav/", body, map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	resp := w.Body.String()
+	assert.Contains(t, resp, "<d:href>/caldav/</d:href>")
+	assert.Contains(t, resp, "<d:href>/caldav/alice</d:href>")
+	assert.Contains(t, resp, "<d:href>/caldav/bob</d:href>")
+	assert.NotContains(t, resp, "/caldav/alice/cal")
+
+	w = serveAlice(h, "PROPFIND", "/caldav/", body, map[string]string{"Depth": "0"})
+	assert.NotContains(t, w.Body.String(), "<d:href>/caldav/bob</d:href>")
+
+	// Storages that can't list their users list nobody
+	h.Storage = mockStorage
+	w = serveAlice(h, "PROPFIND", "/caldav/", body, map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.NotContains(t, w.Body.String(), "<d:href>/caldav/bob</d:href>")
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cyp0633/libcaldora/internal/xml/proppatch"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// propPatcher validates one PROPPATCH operation. It returns http.StatusOK and
+// a function performing the change, or an error status and nil. Changes are
+// only applied once every operation of the request has validated.
+type propPatcher func(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (apply func() error, status int)
+
+// Writable properties per resource type. Anything else is rejected with 403.
+var inboxPatchers = map[string]propPatcher{
+	"calendar-availability": patchCalendarAvailability,
+}
+
+var collectionPatchers = map[string]propPatcher{
+	"displayname":    patchDisplayName,
+	"calendar-color": patchColor,
+	"color":          patchColor,
+	"hidden":         patchHidden,
+	"selected":       patchSelected,
+}
+
+// objectPatchers only apply with CaldavHandler.ObjectDisplayNameSummary.
+var objectPatchers = map[string]propPatcher{
+	"displayname": patchObjectDisplayName,
+}
+
+func (h *CaldavHandler) handleProppatch(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	h.Logger.Info("proppatch request received",
+		"resource_type", ctx.Resource.ResourceType,
+		"user_id", ctx.Resource.UserID,
+		"calendar_id", ctx.Resource.CalendarID,
+		"object_id", ctx.Resource.ObjectID)
+
+	inbox := h.isScheduleInbox(ctx.Resource)
+	// Sharees get their own view of calendars they don't own
+	sharee := !inbox && ctx.Resource.ResourceType == storage.ResourceCollection && ctx.AuthUser != ctx.Resource.UserID
+
+	var patchers map[string]propPatcher
+	switch ctx.Resource.ResourceType {
+	case storage.ResourceCollection:
+		patchers = collectionPatchers
+		if inbox {
+			// Delegates need write-content to change availability
+			patchers = nil
+			if ctx.Privileges.Has(storage.PrivilegeWriteContent) {
+				patchers = inboxPatchers
+			}
+		} else if sharee {
+			patchers = shareePatchers
+		}
+	case storage.ResourceObject:
+		if h.ObjectDisplayNameSummary {
+			patchers = objectPatchers
+		}
+	}
+	// Calendars and objects keep properties the server doesn't know as dead properties
+	deadPropsAllowed := !inbox && (ctx.Resource.ResourceType == storage.ResourceCollection || ctx.Resource.ResourceType == storage.ResourceObject)
+	// Sharees need write-content for anything but their own preferences
+	if sharee && !ctx.Privileges.Has(storage.PrivilegeWriteContent) {
+		deadPropsAllowed = false
+	}
+	if deadPropsAllowed && !h.checkLockTokens(w, r, ctx.Resource, false) {
+		return
+	}
+	// Archived calendars are read-only, properties included. The flag itself
+	// is set by the storage, so no property is exempt.
+	if !inbox && ctx.Resource.CalendarID != "" && !h.checkNotArchived(w, r, ctx.Resource) {
+		return
+	}
+	// Object properties are read-modify-writes of the object, like a PUT
+	if ctx.Resource.ResourceType == storage.ResourceObject {
+		unlock, ok := h.lockCollection(w, r, ctx.Resource)
+		if !ok {
+			return
+		}
+		defer unlock()
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.Error("failed to read request body",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
+		return
+	}
+	ops, err := proppatch.ParseRequest(string(body))
+	if err != nil {
+		h.Logger.Warn("invalid proppatch request",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
+		return
+	}
+
+	// PROPPATCH is atomic: validate everything before changing anything
+	statuses := make([]proppatch.PropStatus, len(ops))
+	applies := make([]func() error, len(ops))
+	failed := false
+	for i, op := range ops {
+		status := http.StatusForbidden
+		name := op.Name
+		if patch, ok := patchers[op.Name]; ok {
+			applies[i], status = patch(h, ctx, op)
+		} else if op.Namespace == NamespaceMozilla && ctx.Resource.ResourceType == storage.ResourceObject {
+			applies[i], status = patchMozillaAlarm(h, ctx, op)
+			name = op.Element.Tag
+		} else if _, known := props.PropNameToStruct[op.Name]; !known && deadPropsAllowed {
+			applies[i], status = patchDeadProperty(h, ctx, op)
+			name = op.Element.Tag
+		}
+		statuses[i] = proppatch.PropStatus{Name: name, Namespace: op.Namespace, StatusCode: status}
+		failed = failed || status != http.StatusOK
+	}
+
+	for i := range ops {
+		if failed {
+			if statuses[i].StatusCode == http.StatusOK {
+				statuses[i].StatusCode = http.StatusFailedDependency
+			}
+			continue
+		}
+		if err := applies[i](); err != nil {
+			// Storage offers no transactions, so earlier changes stay applied
+			h.Logger.Error("failed to apply property change",
+				"property", ops[i].Name,
+				"error", err)
+			statuses[i].StatusCode = storageErrorStatus(err)
+			failed = true
+		}
+	}
+
+	href, err := h.URLConverter.EncodePath(ctx.Resource)
+	if err != nil {
+		h.Logger.Error("failed to encode path for proppatch response",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	doc := proppatch.EncodeResponse(href, statuses)
+	xmlOutput, err := h.writeXML(doc)
+	if err != nil {
+		h.Logger.Error("failed to serialize XML response",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+	h.recordResponseSize(r, doc, xmlOutput)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xmlOutput))
+}
+
+// patchCalendarAvailability sets or removes cs:calendar-availability on a
+// scheduling inbox. The value
+// must be an iCalendar stream whose components are all VAVAILABILITY (or
+// VTIMEZONE).
+func patchCalendarAvailability(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	avail, ok := storage.As[storage.AvailabilityStorage](h.Storage)
+	if !ok {
+		return nil, http.StatusForbidden
+	}
+	userID := ctx.Resource.UserID
+	if op.Remove {
+		return func() error { return h.setUserAvailability(avail, userID, nil) }, http.StatusOK
+	}
+
+	prop, ok := op.Property.(*props.CalendarAvailability)
+	if !ok {
+		return nil, http.StatusForbidden
+	}
+	cal, err := ical.NewDecoder(strings.NewReader(prop.Value)).Decode()
+	if err != nil {
+		h.Logger.Warn("invalid calendar-availability data",
+			"error", err)
+		return nil, http.StatusForbidden
+	}
+	found := false
+	for _, child := range cal.Children {
+		switch child.Name {
+		case "VAVAILABILITY":
+			found = true
+		case ical.CompTimezone:
+		default:
+			h.Logger.Warn("unexpected component in calendar-availability",
+				"component", child.Name)
+			return nil, http.StatusForbidden
+		}
+	}
+	if !found {
+		return nil, http.StatusForbidden
+	}
+	return func() error { return h.setUserAvailability(avail, userID, cal) }, http.StatusOK
+}
+
+// setUserAvailability stores userID's availability and drops the free-busy
+// results computed with the old one.
+func (h *CaldavHandler) setUserAvailability(avail storage.AvailabilityStorage, userID string, cal *ical.Calendar) error {
+	if err := avail.SetUserAvailability(userID, cal); err != nil {
+		return err
+	}
+	h.FreeBusyCache.Invalidate(userID, "", true)
+	return nil
+}
+
+// patchHidden sets or clears g:hidden on a calendar. Removing the property
+// shows the calendar again. With preference storage it only hides the
+// calendar from the authenticated principal.
+func patchHidden(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	if _, ok := storage.As[storage.CalendarPreferenceStorage](h.Storage); ok {
+		return patchHiddenPreference(h, ctx, op)
+	}
+	hidden := false
+	if !op.Remove {
+		prop, ok := op.Property.(*props.Hidden)
+		if !ok {
+			return nil, http.StatusForbidden
+		}
+		hidden = prop.Value
+	}
+	return patchCalendar(h, ctx, func(cal *storage.Calendar) { cal.Hidden = hidden })
+}
+
+// patchObject validates a change of the calendar object of ctx and returns
+// the function applying it: it reads the object, runs change on its master
+// component and stores it. handleProppatch holds the collection lock while
+// applying. Read-only calendars refuse the change.
+func (h *CaldavHandler) patchObject(ctx *RequestContext, change func(obj *storage.CalendarObject, master *ical.Component)) (func() error, int) {
+	res := ctx.Resource
+	cal, err := h.Storage.GetCalendar(res.UserID, res.CalendarID)
+	if err != nil {
+		h.Logger.Error("failed to get calendar for object property change",
+			"calendar_id", res.CalendarID,
+			"error", err)
+		return nil, storageErrorStatus(err)
+	}
+	if cal != nil && cal.ReadOnly {
+		return nil, http.StatusForbidden
+	}
+	return func() error {
+		obj, err := h.Storage.GetObject(res.UserID, res.CalendarID, res.ObjectID)
+		if err != nil {
+			return err
+		}
+		master := obj.Master()
+		if master == nil {
+			return fmt.Errorf("object %q has no calendar component", res.ObjectID)
+		}
+		change(obj, master)
+		h.normalizeComponents(obj.Component, nil, time.Now())
+		etag, err := h.Storage.UpdateObject(res.UserID, res.CalendarID, obj)
+		if err != nil {
+			return err
+		}
+		h.objectChanged(ObjectChange{UserID: res.UserID, CalendarID: res.CalendarID, ObjectID: res.ObjectID, ETag: etag})
+		return nil
+	}, http.StatusOK
+}
+
+// patchObjectDisplayName sets the SUMMARY of a calendar object, or removes it.
+// Overridden instances follow when their SUMMARY was the master's.
+func patchObjectDisplayName(h *CaldavHandler, ctx *RequestContext, op proppatch.Operation) (func() error, int) {
+	var summary, lang string
+	if !op.Remove {
+		prop, ok := op.Property.(*props.DisplayName)
+		if !ok {
+			return nil, http.StatusForbidden
+		}
+		summary, lang = prop.Value, prop.Lang
+	}
+	return h.patchObject(ctx, func(obj *storage.CalendarObject, master *ical.Component) {
+		old, _ := master.Props.Text(ical.PropSummary)
+		for _, comp := range append([]*ical.Component{master}, obj.Overrides()...) {
+			if current, _ := comp.Props.Text(ical.PropSummary); comp != master && current != old {
+				continue
+			}
+			if op.Remove {
+				comp.Props.Del(ical.PropSummary)
+			} else {
+				comp.Props.SetText(ical.PropSummary, summary)
+				if lang != "" {
+					comp.Props.Get(ical.PropSummary).Params.Set(ical.ParamLanguage, lang)
+				}
+			}
+		}
+	})
+}
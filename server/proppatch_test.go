@@ -0,0 +1,177 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// availabilityStorage keeps availability in memory on top of MockStorage.
+type availabilityStorage struct {
+	*storage.MockStorage
+	cals map[string]*ical.Calendar
+}
+
+func (s *availabilityStorage) GetUserAvailability(userID string) (*ical.Calendar, error) {
+	cal, ok := s.cals[userID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return cal, nil
+}
+
+func (s *availabilityStorage) SetUserAvailability(userID string, cal *ical.Calendar) error {
+	if cal == nil {
+		delete(s.cals, userID)
+	} else {
+		s.cals[userID] = cal
+	}
+	return nil
+}
+
+const availabilityICS = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+	"BEGIN:VAVAILABILITY\r\nUID:office-hours\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250101T090000Z\r\n" +
+	"BEGIN:AVAILABLE\r\nUID:weekday\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250106T090000Z\r\nDTEND:20250106T170000Z\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR\r\nEND:AVAILABLE\r\nEND:VAVAILABILITY\r\nEND:VCALENDAR\r\n"
+
+func inboxResource(userID string) Resource {
+	return Resource{UserID: userID, CalendarID: ScheduleInboxCollection, ResourceType: storage.ResourceCollection}
+}
+
+func proppatchBody(inner string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/">` + inner + `</D:propertyupdate>`
+}
+
+func TestProppatchCalendarAvailability(t *testing.T) {
+	store := &availabilityStorage{MockStorage: &storage.MockStorage{}, cals: map[string]*ical.Calendar{}}
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: inboxResource("alice"), AuthUser: "alice", Privileges: storage.PrivilegeAll}
+
+	body := proppatchBody(`<D:set><D:prop><CS:calendar-availability>` + availabilityICS + `</CS:calendar-availability></D:prop></D:set>`)
+	w := httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/inbox", strings.NewReader(body)), ctx)
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<cs:calendar-availability/></d:prop><d:status>HTTP/1.1 200 OK</d:status>")
+	require.Contains(t, store.cals, "alice")
+	assert.Equal(t, "VAVAILABILITY", store.cals["alice"].Children[0].Name)
+
+	// The stored value is readable back through PROPFIND
+	res := inboxResolvers["calendar-availability"](newPropEnv(h, ctx.Resource, nil))
+	require.True(t, res.IsOk())
+	assert.Contains(t, res.MustGet().Encode().Text(), "BEGIN:VAVAILABILITY")
+
+	// Remove
+	body = proppatchBody(`<D:remove><D:prop><CS:calendar-availability/></D:prop></D:remove>`)
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/inbox", strings.NewReader(body)), ctx)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.NotContains(t, store.cals, "alice")
+}
+
+func TestProppatchIsAtomic(t *testing.T) {
+	store := &availabilityStorage{MockStorage: &storage.MockStorage{}, cals: map[string]*ical.Calendar{}}
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: inboxResource("alice"), AuthUser: "alice", Privileges: storage.PrivilegeAll}
+
+	// displayname is not writable yet, so the availability change must not be applied
+	body := proppatchBody(`<D:set><D:prop><CS:calendar-availability>` + availabilityICS + `</CS:calendar-availability>` +
+		`<D:displayname>Alice</D:displayname></D:prop></D:set>`)
+	w := httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/inbox", strings.NewReader(body)), ctx)
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	out := w.Body.String()
+	assert.Contains(t, out, "<cs:calendar-availability/></d:prop><d:status>HTTP/1.1 424 Failed Dependency</d:status>")
+	assert.Contains(t, out, "<d:displayname/></d:prop><d:status>HTTP/1.1 403 Forbidden</d:status>")
+	assert.Empty(t, store.cals)
+}
+
+func TestProppatchRejectsNonAvailabilityData(t *testing.T) {
+	store := &availabilityStorage{MockStorage: &storage.MockStorage{}, cals: map[string]*ical.Calendar{}}
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: inboxResource("alice"), AuthUser: "alice", Privileges: storage.PrivilegeAll}
+
+	event := strings.ReplaceAll(bulkTestEvent, "%s", "uid")
+	body := proppatchBody(`<D:set><D:prop><CS:calendar-availability>` + event + `</CS:calendar-availability></D:prop></D:set>`)
+	w := httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/inbox", strings.NewReader(body)), ctx)
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+	assert.Empty(t, store.cals)
+
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/inbox", strings.NewReader("<nope/>")), ctx)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestProppatchObjectDisplayName(t *testing.T) {
+	master := ical.NewComponent(ical.CompEvent)
+	master.Props.SetText(ical.PropUID, "1")
+	master.Props.SetText(ical.PropSummary, "Standup")
+	moved := ical.NewComponent(ical.CompEvent)
+	moved.Props.SetText(ical.PropUID, "1")
+	moved.Props.SetText(ical.PropSummary, "Standup")
+	moved.Props.Set(&ical.Prop{Name: ical.PropRecurrenceID, Params: ical.Params{}, Value: "20250102T090000Z"})
+	renamed := ical.NewComponent(ical.CompEvent)
+	renamed.Props.SetText(ical.PropUID, "1")
+	renamed.Props.SetText(ical.PropSummary, "Planning")
+	renamed.Props.Set(&ical.Prop{Name: ical.PropRecurrenceID, Params: ical.Params{}, Value: "20250103T090000Z"})
+	obj := &storage.CalendarObject{Path: "/caldav/alice/cal/work/a.ics", Component: []*ical.Component{master, moved, renamed}}
+
+	store := &storage.MockStorage{}
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	store.On("GetObject", "alice", "work", "a.ics").Return(obj, nil)
+	store.On("UpdateObject", "alice", "work", obj).Return(`"v2"`, nil)
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ObjectID: "a.ics", ResourceType: storage.ResourceObject}, AuthUser: "alice"}
+	body := proppatchBody(`<D:set><D:prop><D:displayname>Daily sync</D:displayname></D:prop></D:set>`)
+
+	// Off by default
+	w := httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work/a.ics", strings.NewReader(body)), ctx)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+	store.AssertNotCalled(t, "UpdateObject", "alice", "work", obj)
+
+	h.ObjectDisplayNameSummary = true
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work/a.ics", strings.NewReader(body)), ctx)
+	assert.Contains(t, w.Body.String(), "<d:displayname/></d:prop><d:status>HTTP/1.1 200 OK</d:status>")
+	summary := func(comp *ical.Component) string {
+		s, _ := comp.Props.Text(ical.PropSummary)
+		return s
+	}
+	assert.Equal(t, "Daily sync", summary(master))
+	assert.Equal(t, "Daily sync", summary(moved))
+	// An override with its own title keeps it
+	assert.Equal(t, "Planning", summary(renamed))
+
+	res := objectResolvers["displayname"](newPropEnv(h, ctx.Resource, nil))
+	require.True(t, res.IsOk())
+	assert.Equal(t, "Daily sync", res.MustGet().Encode().Text())
+
+	// Archived calendars refuse the change
+	store.ExpectedCalls = nil
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Archived: true}, nil)
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work/a.ics", strings.NewReader(body)), ctx)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "<lc:calendar-not-archived/>")
+
+	// So do read-only ones, inside the multistatus
+	store.ExpectedCalls = nil
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{ReadOnly: true}, nil)
+	w = httptest.NewRecorder()
+	h.handleProppatch(w, httptest.NewRequest("PROPPATCH", "/caldav/alice/cal/work/a.ics", strings.NewReader(body)), ctx)
+	assert.Contains(t, w.Body.String(), "HTTP/1.1 403 Forbidden")
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// withForwardedBase returns h with its URLConverter encoding paths for the
+// external URL the X-Forwarded-* headers of r name, when
+// TrustForwardedHeaders is set and r has any.
+func (h *CaldavHandler) withForwardedBase(r *http.Request) *CaldavHandler {
+	if !h.TrustForwardedHeaders {
+		return h
+	}
+	converter, ok := h.URLConverter.(ExternalURLConverter)
+	if !ok {
+		return h
+	}
+	base := forwardedBase(r, h.Prefix)
+	if base == "" {
+		return h
+	}
+	bound := *h
+	bound.URLConverter = converter.WithExternalBase(base)
+	return &bound
+}
+
+// forwardedBase returns the URL clients reach prefix at according to the
+// X-Forwarded-* headers of r, "" when it has none. X-Forwarded-Prefix is the
+// path the proxy stripped in front of prefix, as Traefik and Spring read it.
+func forwardedBase(r *http.Request, prefix string) string {
+	host := firstForwarded(r.Header.Get("X-Forwarded-Host"))
+	pathPrefix := firstForwarded(r.Header.Get("X-Forwarded-Prefix"))
+	if host == "" && pathPrefix == "" {
+		return ""
+	}
+	if host == "" {
+		host = r.Host
+	}
+	scheme := firstForwarded(r.Header.Get("X-Forwarded-Proto"))
+	if scheme != "http" && scheme != "https" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	pathPrefix = strings.TrimSuffix(pathPrefix, "/")
+	if pathPrefix != "" && !strings.HasPrefix(pathPrefix, "/") {
+		pathPrefix = "/" + pathPrefix
+	}
+	return scheme + "://" + host + pathPrefix + prefix
+}
+
+// firstForwarded returns the value the proxy closest to the client set in an
+// X-Forwarded-* header listing one per proxy.
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// serviceRootURL returns the URL ServeWellKnown redirects r to: the service
+// root's path on the scheme and host of the URLConverter's external URL, or
+// on the host r was sent to.
+func (h *CaldavHandler) serviceRootURL(r *http.Request) string {
+	root, err := h.URLConverter.EncodePath(Resource{ResourceType: storage.ResourceServiceRoot})
+	if err != nil {
+		root = h.Prefix
+	}
+	if origin := h.externalOrigin(); origin != "" {
+		return origin + root
+	}
+	return "//" + r.Host + root
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalURLConverter(t *testing.T) {
+	c := &DefaultURLConverter{Prefix: "/caldav/", ExternalURL: "https://cal.example.com/dav/caldav/"}
+
+	href, err := c.EncodePath(Resource{UserID: "alice", CalendarID: "work", ObjectID: "a.ics", ResourceType: storage.ResourceObject})
+	require.NoError(t, err)
+	assert.Equal(t, "/dav/caldav/alice/cal/work/a.ics", href)
+
+	// Paths as served, as encoded and as absolute hrefs all parse
+	for _, path := range []string{
+		"/caldav/alice/cal/work/a.ics",
+		"/dav/caldav/alice/cal/work/a.ics",
+		"https://cal.example.com/dav/caldav/alice/cal/work/a.ics",
+	} {
+		res, err := c.ParsePath(path)
+		require.NoError(t, err, path)
+		assert.Equal(t, "a.ics", res.ObjectID, path)
+	}
+
+	// An external root that the internal prefix starts with
+	c = &DefaultURLConverter{Prefix: "/caldav/", ExternalURL: "https://cal.example.com"}
+	href, err = c.EncodePath(Resource{UserID: "alice", ResourceType: storage.ResourceHomeSet})
+	require.NoError(t, err)
+	assert.Equal(t, "/alice/cal", href)
+	for _, path := range []string{"/caldav/alice/cal", "/alice/cal"} {
+		res, err := c.ParsePath(path)
+		require.NoError(t, err, path)
+		assert.Equal(t, storage.ResourceHomeSet, res.ResourceType, path)
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetUser", "alice").Return(&storage.User{}, nil).Maybe()
+	body := `<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><C:calendar-home-set/></D:prop></D:propfind>`
+	forwarded := map[string]string{"X-Forwarded-Host": "cal.example.com", "X-Forwarded-Prefix": "/dav", "X-Forwarded-Proto": "https"}
+
+	// Ignored unless trusted
+	w := serveAlice(h, "PROPFIND", "/caldav/alice", body, forwarded)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal</d:href>")
+
+	h.TrustForwardedHeaders = true
+	w = serveAlice(h, "PROPFIND", "/caldav/alice", body, forwarded)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/dav/caldav/alice</d:href>")
+	assert.Contains(t, w.Body.String(), "<d:href>/dav/caldav/alice/cal</d:href>")
+
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/caldav", nil)
+	for k, v := range forwarded {
+		r.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeWellKnown(rec, r)
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "https://cal.example.com/dav/caldav/", rec.Header().Get("Location"))
+}
+
+func TestWellKnownExternalURL(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{})
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/caldav", nil)
+	w := httptest.NewRecorder()
+	h.ServeWellKnown(w, r)
+	assert.Equal(t, "//example.com/caldav/", w.Header().Get("Location"))
+
+	h.URLConverter = &DefaultURLConverter{Prefix: "/caldav/", ExternalURL: "https://cal.example.com/dav/"}
+	w = httptest.NewRecorder()
+	h.ServeWellKnown(w, r)
+	assert.Equal(t, "https://cal.example.com/dav/", w.Header().Get("Location"))
+}
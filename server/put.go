@@ -1,12 +1,15 @@
 package server
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
 	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/cyp0633/libcaldora/server/tzalias"
 	"github.com/emersion/go-ical"
 )
 
@@ -17,98 +20,71 @@ func (h *CaldavHandler) handlePut(w http.ResponseWriter, r *http.Request, ctx *R
 		"calendar_id", ctx.Resource.CalendarID,
 		"object_id", ctx.Resource.ObjectID)
 
+	if ctx.Resource.ResourceType == storage.ResourceCollection && h.ObjectIDs != nil {
+		if !h.nameNewObject(w, r, ctx) {
+			return
+		}
+	}
 	if ctx.Resource.ResourceType != storage.ResourceObject {
 		h.Logger.Warn("put not allowed on resource type",
 			"resource_type", ctx.Resource.ResourceType)
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w, r, ctx.Resource)
+		return
+	}
+	if !h.checkNotArchived(w, r, ctx.Resource) {
+		return
+	}
+	unlock, ok := h.lockCollection(w, r, ctx.Resource)
+	if !ok {
 		return
 	}
+	defer unlock()
 
-	// 1) Load existing object (or note that it doesn't exist)
-	object, err := h.Storage.GetObject(ctx.Resource.UserID, ctx.Resource.CalendarID, ctx.Resource.ObjectID)
-	if errors.Is(err, storage.ErrNotFound) {
-		object = nil
-		h.Logger.Debug("object does not exist, will create new")
-	} else if err != nil {
-		h.Logger.Error("storage error while retrieving object",
-			"error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// 1) Validate preconditions
+	write := objectWrite{
+		res:         ctx.Resource,
+		ifMatch:     r.Header.Get("If-Match"),
+		ifNoneMatch: r.Header.Get("If-None-Match"),
+	}
+	object, ok := h.checkObjectWrite(w, r, write)
+	if !ok {
 		return
-	} else {
-		h.Logger.Debug("existing object found",
-			"etag", object.ETag)
-	}
-
-	// 2) Validate preconditions
-	ifMatch := r.Header.Get("If-Match")
-	ifNone := r.Header.Get("If-None-Match")
-	if object != nil {
-		if ifMatch != "" && ifMatch != object.ETag {
-			h.Logger.Warn("etag mismatch",
-				"client_etag", ifMatch,
-				"server_etag", object.ETag)
-			http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
-			return
-		}
-		if ifNone == "*" {
-			h.Logger.Warn("if-none-match=* used but resource exists")
-			http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
-			return
-		}
-	} else {
-		// object==nil → creation
-		if ifMatch != "" {
-			// If-Match on a non-existent resource → 412
-			h.Logger.Warn("if-match used on non-existent resource",
-				"etag", ifMatch)
-			http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
-			return
-		}
 	}
-	// (Optional) If-Unmodified-Since handling here…
 
-	// 3) Check Content-Type
+	// 2) Check Content-Type
 	contentType := r.Header.Get("Content-Type")
 	if !strings.HasPrefix(contentType, "text/calendar") {
 		h.Logger.Warn("unsupported media type",
 			"content_type", contentType)
-		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		h.writeError(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType)
 		return
 	}
 
-	// 4) Read & parse
+	// 3) Read & parse
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		h.Logger.Error("failed to read request body",
 			"error", err)
-		http.Error(w, "Failed to read body", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
 		return
 	}
 	r.Body.Close()
 
 	// Parse calendar data to get all components including VTIMEZONE
-	reader := strings.NewReader(string(data))
-	dec := ical.NewDecoder(reader)
-	cal, err := dec.Decode()
-	if err != nil {
-		h.Logger.Warn("invalid iCalendar data",
+	allComponents, err := decodeCalendarComponents(string(data))
+	if errors.Is(err, errNoComponents) {
+		h.Logger.Warn("no valid components found in iCalendar data")
+		h.writeError(w, r, http.StatusBadRequest, CodeEmptyCalendarData)
+		return
+	} else if errors.Is(err, errUnsupportedVersion) {
+		h.Logger.Warn("unsupported iCalendar version",
 			"error", err)
-		http.Error(w, "Invalid iCalendar data", http.StatusBadRequest)
+		h.writePrecondition(w, r, http.StatusForbidden, "cal:supported-calendar-data", err.Error())
 		return
-	}
-
-	// Collect all meaningful components (including VTIMEZONE)
-	var allComponents []*ical.Component
-	for _, child := range cal.Children {
-		// Include all components except empty ones
-		if child != nil && child.Name != "" {
-			allComponents = append(allComponents, child)
-		}
-	}
-
-	if len(allComponents) == 0 {
-		h.Logger.Warn("no valid components found in iCalendar data")
-		http.Error(w, "No valid components found in iCalendar data", http.StatusBadRequest)
+	} else if err != nil {
+		h.Logger.Warn("invalid iCalendar data",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidCalendarData)
 		return
 	}
 
@@ -122,37 +98,83 @@ func (h *CaldavHandler) handlePut(w http.ResponseWriter, r *http.Request, ctx *R
 			return types
 		}())
 
-	// 5) Persist
-	path, err := h.URLConverter.EncodePath(ctx.Resource)
+	// 4) Persist
+	write.comps = allComponents
+	h.applyObjectWrite(w, r, write, object)
+}
+
+// nameNewObject points ctx at a new object of the calendar a PUT was sent to,
+// named by h.ObjectIDs after the body. The body is put back for handlePut to
+// read; bodies that don't parse get a UUIDObjectID and fail there.
+func (h *CaldavHandler) nameNewObject(w http.ResponseWriter, r *http.Request, ctx *RequestContext) bool {
+	data, err := io.ReadAll(r.Body)
 	if err != nil {
-		// that resource is from path decoding, should not fail
-		h.Logger.Error("unexpected error encoding path",
-			"error", err,
-			"resource", ctx.Resource)
-		http.Error(w, "Failed to encode path", http.StatusInternalServerError)
-		return
+		h.Logger.Error("failed to read request body",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeReadBody)
+		return false
 	}
-	newObj := &storage.CalendarObject{Path: path, Component: allComponents}
-	newETag, err := h.Storage.UpdateObject(ctx.Resource.UserID, ctx.Resource.CalendarID, newObj)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+
+	objectID := UUIDObjectID(nil)
+	if comps, err := decodeCalendarComponents(string(data)); err == nil {
+		if objectID, err = h.newObjectID(ctx.Resource.UserID, ctx.Resource.CalendarID, comps); err != nil {
+			h.Logger.Error("failed to name new object",
+				"error", err)
+			h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+			return false
+		}
+	}
+	ctx.Resource.ObjectID = objectID
+	ctx.Resource.ResourceType = storage.ResourceObject
+	ctx.Resource.URI = ""
+	h.Logger.Info("creating object in collection",
+		"calendar_id", ctx.Resource.CalendarID,
+		"object_id", objectID)
+	return true
+}
+
+// errNoComponents is returned by decodeCalendarComponents when the VCALENDAR has no children.
+var errNoComponents = errors.New("no valid components found in iCalendar data")
+
+// errUnsupportedVersion is returned by decodeCalendarComponents for streams
+// declaring an iCalendar VERSION other than 2.0.
+var errUnsupportedVersion = errors.New("unsupported iCalendar version")
+
+// decodeCalendarComponents parses a complete iCalendar stream and returns all
+// meaningful top-level components (including VTIMEZONE). Time zone aliases,
+// like the Windows IDs Outlook writes, are replaced by IANA names, so stored
+// objects expand everywhere.
+func decodeCalendarComponents(data string) ([]*ical.Component, error) {
+	cal, err := ical.NewDecoder(strings.NewReader(data)).Decode()
 	if err != nil {
-		h.Logger.Error("failed to save object",
-			"error", err)
-		http.Error(w, "Failed to save object", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if version := cal.Props.Get(ical.PropVersion); version != nil && strings.TrimSpace(version.Value) != icalVersion {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedVersion, version.Value)
+	}
+
+	var components []*ical.Component
+	for _, child := range cal.Children {
+		// Include all components except empty ones
+		if child != nil && child.Name != "" {
+			components = append(components, child)
+		}
+	}
+	if len(components) == 0 {
+		return nil, errNoComponents
 	}
+	tzalias.NormalizeComponents(components)
+	return components, nil
+}
 
-	// 6) Respond
-	w.Header().Set("ETag", newETag)
-	if object == nil {
-		h.Logger.Info("object created successfully",
-			"path", newObj.Path,
-			"etag", newETag)
-		w.Header().Set("Location", newObj.Path)
-		w.WriteHeader(http.StatusCreated)
-	} else {
-		h.Logger.Info("object updated successfully",
-			"path", newObj.Path,
-			"etag", newETag)
-		w.WriteHeader(http.StatusNoContent)
+// validateObject checks components with CalendarObject.Validate when the
+// handler runs with StrictObjects. Some clients put unrelated events in one
+// resource, so it's off by default.
+func (h *CaldavHandler) validateObject(comps []*ical.Component) error {
+	if !h.StrictObjects {
+		return nil
 	}
+	return (&storage.CalendarObject{Component: comps}).Validate()
 }
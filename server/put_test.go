@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,7 @@ import (
 	"github.com/emersion/go-ical"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandlePut(t *testing.T) {
@@ -216,6 +218,8 @@ END:VCALENDAR`
 
 			// Setup mocks
 			tt.setupMocks()
+			// The calendar is writable unless a case says otherwise
+			mockStorage.On("GetCalendar", userID, calendarID).Return(&storage.Calendar{}, nil).Maybe()
 
 			// Create request
 			var reqBody string
@@ -539,6 +543,8 @@ END:VCALENDAR`,
 
 			// Setup mocks
 			tt.setupMocks()
+			// The calendar is writable unless a case says otherwise
+			mockStorage.On("GetCalendar", userID, calendarID).Return(&storage.Calendar{}, nil).Maybe()
 
 			// Create request
 			req := httptest.NewRequest("PUT", "/caldav/"+userID+"/cal/"+calendarID+"/"+tt.objectID,
@@ -600,3 +606,191 @@ func (c *mockURLConverter) ParsePath(path string) (Resource, error) {
 	}
 	return res, args.Error(1)
 }
+
+func TestHandlePutSkipsUnchanged(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	stored, err := decodeCalendarComponents("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250101T100000Z\r\nSUMMARY:Lunch\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	require.NoError(t, err)
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/caldav/alice/cal/work/a.ics", ETag: `"v1"`, Component: stored}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.SkipUnchangedPuts = true
+	header := map[string]string{"Content-Type": "text/calendar"}
+
+	// Same content, different property order
+	same := "BEGIN:VCALENDAR\r\nPRODID:-//other//EN\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nSUMMARY:Lunch\r\nDTSTART:20250101T100000Z\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics", same, header)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+	mockStorage.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+
+	mockStorage.On("UpdateObject", "alice", "work", mock.AnythingOfType("*storage.CalendarObject")).Return(`"v2"`, nil).Once()
+	changed := strings.Replace(same, "Lunch", "Dinner", 1)
+	w = serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics", changed, header)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, `"v2"`, w.Header().Get("ETag"))
+}
+
+func TestHandlePutNormalizesTimezoneAliases(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(nil, storage.ErrNotFound)
+	var saved *storage.CalendarObject
+	mockStorage.On("UpdateObject", "alice", "work", mock.AnythingOfType("*storage.CalendarObject")).
+		Run(func(args mock.Arguments) { saved = args.Get(2).(*storage.CalendarObject) }).
+		Return(`"v1"`, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Microsoft Corporation//Outlook 16.0 MIMEDIR//EN\r\n" +
+		"BEGIN:VTIMEZONE\r\nTZID:W. Europe Standard Time\r\nBEGIN:STANDARD\r\nDTSTART:16011028T030000\r\n" +
+		"TZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n" +
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART;TZID=W. Europe Standard Time:20250310T090000\r\n" +
+		"DTEND;TZID=W. Europe Standard Time:20250310T100000\r\nRRULE:FREQ=WEEKLY\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics", body, map[string]string{"Content-Type": "text/calendar"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NotNil(t, saved)
+	assert.Equal(t, "Europe/Berlin", saved.Component[0].Props.Get(ical.PropTimezoneID).Value)
+	start, err := saved.Component[1].Props.DateTime(ical.PropDateTimeStart, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC), start.UTC())
+}
+
+func TestHandlePutStrictObjects(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(nil, storage.ErrNotFound)
+	mockStorage.On("UpdateObject", "alice", "work", mock.AnythingOfType("*storage.CalendarObject")).Return(`"v1"`, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.StrictObjects = true
+	header := map[string]string{"Content-Type": "text/calendar"}
+
+	event := func(uid, extra string) string {
+		return "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250101T100000Z\r\n" + extra + "END:VEVENT\r\n"
+	}
+	calendar := func(events ...string) string {
+		return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+	}
+
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics", calendar(event("1", ""), event("2", "")), header)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "valid-calendar-object-resource")
+	mockStorage.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+
+	w = serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics",
+		calendar(event("1", "RRULE:FREQ=DAILY\r\n"), event("1", "RECURRENCE-ID:20250102T100000Z\r\n")), header)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestHandlePutRejectsOtherICalendarVersions(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(nil, storage.ErrNotFound)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	body := "BEGIN:VCALENDAR\r\nVERSION:1.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:1\r\n" +
+		"DTSTAMP:20250101T000000Z\r\nDTSTART:20250101T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics", body, map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "supported-calendar-data")
+	mockStorage.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// uidStorage finds objects by UID on top of MockStorage.
+type uidStorage struct {
+	*storage.MockStorage
+	byUID map[string]*storage.CalendarObject
+}
+
+func (s *uidStorage) GetObjectByUID(userID, calendarID, uid string) (*storage.CalendarObject, error) {
+	obj, ok := s.byUID[uid]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return obj, nil
+}
+
+func TestHandlePutUIDConflict(t *testing.T) {
+	existing := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:meeting\r\n" +
+		"DTSTAMP:20250101T000000Z\r\nDTSTART:20250101T100000Z\r\nSUMMARY:Old\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	comps, err := decodeCalendarComponents(existing)
+	require.NoError(t, err)
+	stored := &storage.CalendarObject{Path: "/caldav/alice/cal/work/old.ics", ETag: `"v1"`, Component: comps}
+	body := strings.Replace(existing, "SUMMARY:Old", "SUMMARY:New", 1)
+	header := map[string]string{"Content-Type": "text/calendar", "If-None-Match": "*"}
+
+	newHandler := func(mode UIDConflictMode) (*CaldavHandler, *uidStorage) {
+		store := &uidStorage{MockStorage: new(storage.MockStorage), byUID: map[string]*storage.CalendarObject{"meeting": stored}}
+		store.On("AuthUser", "alice", "password").Return("alice", nil)
+		store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+		store.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrNotFound)
+		h := NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		h.UIDConflict = mode
+		return h, store
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		h, store := newHandler(UIDConflictAllow)
+		store.On("UpdateObject", "alice", "work", mock.MatchedBy(func(obj *storage.CalendarObject) bool {
+			return obj.Path == "/caldav/alice/cal/work/new.ics"
+		})).Return(`"v2"`, nil).Once()
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", body, header)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		store.AssertExpectations(t)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		h, store := newHandler(UIDConflictReject)
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", body, header)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "no-uid-conflict")
+		assert.Contains(t, w.Body.String(), "/caldav/alice/cal/work/old.ics")
+		store.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		h, store := newHandler(UIDConflictUpdate)
+		store.On("UpdateObject", "alice", "work", mock.MatchedBy(func(obj *storage.CalendarObject) bool {
+			summary, _ := obj.Component[0].Props.Text(ical.PropSummary)
+			return obj.Path == "/caldav/alice/cal/work/old.ics" && summary == "New"
+		})).Return(`"v2"`, nil).Once()
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", body, map[string]string{"Content-Type": "text/calendar"})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "/caldav/alice/cal/work/old.ics", w.Header().Get("Location"))
+		store.AssertExpectations(t)
+	})
+
+	t.Run("update create-only", func(t *testing.T) {
+		h, store := newHandler(UIDConflictUpdate)
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", body, header)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "no-uid-conflict")
+		store.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("update checks If against the existing object", func(t *testing.T) {
+		h, store := newHandler(UIDConflictUpdate)
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/new.ics", body, map[string]string{
+			"Content-Type": "text/calendar",
+			"If":           `(Not ["v1"])`,
+		})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		store.AssertNotCalled(t, "UpdateObject", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestPutRefusedByStorage(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(nil, storage.ErrNotFound)
+	mockStorage.On("UpdateObject", "alice", "work", mock.Anything).Return("", storage.ErrPermissionDenied)
+	body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:a\r\n" +
+		"DTSTAMP:20250101T000000Z\r\nDTSTART:20250303T090000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+	w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/a.ics", body, map[string]string{"Content-Type": "text/calendar"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -3,6 +3,7 @@ package recurrence
 import (
 	"crypto/sha256"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,16 +13,26 @@ type CacheEntry struct {
 	Result     interface{} // Can store bool for HasOccurrence or []time.Time for expansion
 	ExpiresAt  time.Time
 	AccessedAt time.Time
+	object     string // object the entry was computed for, see Engine.ForObject
+}
+
+// cacheScope ties entries to the object and ETag they were computed for, so
+// they can be flushed when the object changes.
+type cacheScope struct {
+	object string
+	etag   string
 }
 
 // RecurrenceCache provides caching for recurrence expansion and validation results
 type RecurrenceCache struct {
 	entries         map[string]*CacheEntry
+	objects         map[string]map[string]struct{} // keys of the entries of each object
 	mutex           sync.RWMutex
 	ttl             time.Duration
 	maxEntries      int
 	cleanupInterval time.Duration
 	stopCleanup     chan struct{}
+	closeOnce       sync.Once
 }
 
 // CacheConfig holds configuration for the recurrence cache
@@ -42,6 +53,7 @@ var DefaultCacheConfig = CacheConfig{
 func NewRecurrenceCache(config CacheConfig) *RecurrenceCache {
 	cache := &RecurrenceCache{
 		entries:         make(map[string]*CacheEntry),
+		objects:         make(map[string]map[string]struct{}),
 		ttl:             config.TTL,
 		maxEntries:      config.MaxEntries,
 		cleanupInterval: config.CleanupInterval,
@@ -55,21 +67,33 @@ func NewRecurrenceCache(config CacheConfig) *RecurrenceCache {
 }
 
 // generateCacheKey creates a unique key for the cache based on input parameters
-func (c *RecurrenceCache) generateCacheKey(operation string, masterStart, masterEnd time.Time, recInfo RecurrenceInfo, rangeStart, rangeEnd time.Time) string {
+func (c *RecurrenceCache) generateCacheKey(scope cacheScope, operation string, masterStart, masterEnd time.Time, recInfo RecurrenceInfo, rangeStart, rangeEnd time.Time) string {
 	// Create a hash of all relevant parameters
 	hasher := sha256.New()
 
 	// Include operation type
 	hasher.Write([]byte(operation))
 
+	// Include the object and its ETag, if scoped
+	if scope.object != "" {
+		hasher.Write([]byte(scope.object))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(scope.etag))
+	}
+
 	// Include time parameters
 	hasher.Write([]byte(masterStart.Format(time.RFC3339Nano)))
+	// Occurrences follow the rules of masterStart's zone, not just its offset
+	hasher.Write([]byte(masterStart.Location().String()))
 	hasher.Write([]byte(masterEnd.Format(time.RFC3339Nano)))
 	hasher.Write([]byte(rangeStart.Format(time.RFC3339Nano)))
 	hasher.Write([]byte(rangeEnd.Format(time.RFC3339Nano)))
 
 	// Include recurrence info
 	hasher.Write([]byte(recInfo.RRULE))
+	if recInfo.AllDay {
+		hasher.Write([]byte("all-day"))
+	}
 
 	// Include RDATE
 	for _, rdate := range recInfo.RDATE {
@@ -81,6 +105,11 @@ func (c *RecurrenceCache) generateCacheKey(operation string, masterStart, master
 		hasher.Write([]byte(exdate.Format(time.RFC3339Nano)))
 	}
 
+	// Include EXRULE
+	for _, exrule := range recInfo.EXRULE {
+		hasher.Write([]byte(exrule))
+	}
+
 	// Include RecurrenceID if present
 	if recInfo.RecurrenceID != nil {
 		hasher.Write([]byte(recInfo.RecurrenceID.Format(time.RFC3339Nano)))
@@ -91,7 +120,11 @@ func (c *RecurrenceCache) generateCacheKey(operation string, masterStart, master
 
 // Get retrieves a cached result if it exists and hasn't expired
 func (c *RecurrenceCache) Get(operation string, masterStart, masterEnd time.Time, recInfo RecurrenceInfo, rangeStart, rangeEnd time.Time) (interface{}, bool) {
-	key := c.generateCacheKey(operation, masterStart, masterEnd, recInfo, rangeStart, rangeEnd)
+	return c.get(cacheScope{}, operation, masterStart, masterEnd, recInfo, rangeStart, rangeEnd)
+}
+
+func (c *RecurrenceCache) get(scope cacheScope, operation string, masterStart, masterEnd time.Time, recInfo RecurrenceInfo, rangeStart, rangeEnd time.Time) (interface{}, bool) {
+	key := c.generateCacheKey(scope, operation, masterStart, masterEnd, recInfo, rangeStart, rangeEnd)
 
 	c.mutex.RLock()
 	entry, exists := c.entries[key]
@@ -106,7 +139,7 @@ func (c *RecurrenceCache) Get(operation string, masterStart, masterEnd time.Time
 	if now.After(entry.ExpiresAt) {
 		// Entry expired, remove it
 		c.mutex.Lock()
-		delete(c.entries, key)
+		c.remove(key)
 		c.mutex.Unlock()
 		return nil, false
 	}
@@ -121,19 +154,32 @@ func (c *RecurrenceCache) Get(operation string, masterStart, masterEnd time.Time
 
 // Set stores a result in the cache
 func (c *RecurrenceCache) Set(operation string, masterStart, masterEnd time.Time, recInfo RecurrenceInfo, rangeStart, rangeEnd time.Time, result interface{}) {
-	key := c.generateCacheKey(operation, masterStart, masterEnd, recInfo, rangeStart, rangeEnd)
+	c.set(cacheScope{}, operation, masterStart, masterEnd, recInfo, rangeStart, rangeEnd, result)
+}
+
+func (c *RecurrenceCache) set(scope cacheScope, operation string, masterStart, masterEnd time.Time, recInfo RecurrenceInfo, rangeStart, rangeEnd time.Time, result interface{}) {
+	key := c.generateCacheKey(scope, operation, masterStart, masterEnd, recInfo, rangeStart, rangeEnd)
 	now := time.Now()
 
 	entry := &CacheEntry{
 		Result:     result,
 		ExpiresAt:  now.Add(c.ttl),
 		AccessedAt: now,
+		object:     scope.object,
 	}
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	c.entries[key] = entry
+	if scope.object != "" {
+		keys, ok := c.objects[scope.object]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.objects[scope.object] = keys
+		}
+		keys[key] = struct{}{}
+	}
 
 	// If we're over the limit, trigger cleanup
 	if len(c.entries) > c.maxEntries {
@@ -141,6 +187,50 @@ func (c *RecurrenceCache) Set(operation string, masterStart, masterEnd time.Time
 	}
 }
 
+// Invalidate removes the entries computed for object, whatever its ETag was.
+func (c *RecurrenceCache) Invalidate(object string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	keys := c.objects[object]
+	removed := len(keys)
+	for key := range keys {
+		c.remove(key)
+	}
+	return removed
+}
+
+// InvalidatePrefix removes the entries of every object starting with prefix,
+// e.g. all objects of a deleted calendar.
+func (c *RecurrenceCache) InvalidatePrefix(prefix string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	removed := 0
+	for object, keys := range c.objects {
+		if strings.HasPrefix(object, prefix) {
+			removed += len(keys)
+			for key := range keys {
+				c.remove(key)
+			}
+		}
+	}
+	return removed
+}
+
+// remove deletes an entry and its object index. The caller holds the lock.
+func (c *RecurrenceCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	if keys, ok := c.objects[entry.object]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.objects, entry.object)
+		}
+	}
+}
+
 // cleanup removes expired entries and oldest entries if over limit
 func (c *RecurrenceCache) cleanup() {
 	now := time.Now()
@@ -148,7 +238,7 @@ func (c *RecurrenceCache) cleanup() {
 	// Remove expired entries
 	for key, entry := range c.entries {
 		if now.After(entry.ExpiresAt) {
-			delete(c.entries, key)
+			c.remove(key)
 		}
 	}
 
@@ -180,7 +270,7 @@ func (c *RecurrenceCache) cleanup() {
 		// Remove oldest entries to get under the limit
 		entriesToRemove := len(c.entries) - c.maxEntries
 		for i := 0; i < entriesToRemove && i < len(keyAccessList); i++ {
-			delete(c.entries, keyAccessList[i].key)
+			c.remove(keyAccessList[i].key)
 		}
 	}
 }
@@ -202,11 +292,13 @@ func (c *RecurrenceCache) cleanupLoop() {
 	}
 }
 
-// Close stops the cleanup goroutine and clears the cache
+// Close stops the cleanup goroutine and clears the cache. Calling it again
+// does nothing
 func (c *RecurrenceCache) Close() {
-	close(c.stopCleanup)
+	c.closeOnce.Do(func() { close(c.stopCleanup) })
 	c.mutex.Lock()
 	c.entries = make(map[string]*CacheEntry)
+	c.objects = make(map[string]map[string]struct{})
 	c.mutex.Unlock()
 }
 
@@ -699,3 +699,51 @@ func TestEngineConfiguration_LogicalCorrectness(t *testing.T) {
 		}
 	}
 }
+
+func TestEngine_ForObject(t *testing.T) {
+	engine := NewEngineWithCache(NewRecurrenceCache(DefaultCacheConfig))
+	defer engine.Close()
+
+	masterStart := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	masterEnd := masterStart.Add(time.Hour)
+	recInfo := RecurrenceInfo{RRULE: "FREQ=DAILY;COUNT=5"}
+	rangeStart, rangeEnd := masterStart.AddDate(0, 0, 2), masterStart.AddDate(0, 0, 3)
+	check := func(e *Engine) {
+		found, err := e.HasOccurrenceInRange(masterStart, masterEnd, recInfo, rangeStart, rangeEnd)
+		if err != nil || !found {
+			t.Fatalf("HasOccurrenceInRange = %v, %v", found, err)
+		}
+	}
+
+	check(engine.ForObject("alice/work/a.ics", "1"))
+	check(engine.ForObject("alice/work/a.ics", "1"))
+	check(engine.ForObject("alice/work/a.ics", "2"))
+	check(engine.ForObject("alice/home/b.ics", "1"))
+	stats := engine.GetCacheStats()
+	if stats.TotalEntries != 3 {
+		t.Fatalf("Expected an entry per object version, got %d", stats.TotalEntries)
+	}
+
+	if removed := engine.Invalidate("alice/work/a.ics"); removed != 2 {
+		t.Errorf("Expected both versions of a.ics flushed, got %d", removed)
+	}
+	if removed := engine.Invalidate("alice/work/a.ics"); removed != 0 {
+		t.Errorf("Expected nothing left to flush, got %d", removed)
+	}
+	check(engine.ForObject("alice/work/c.ics", "1"))
+	if removed := engine.InvalidatePrefix("alice/work/"); removed != 1 {
+		t.Errorf("Expected c.ics flushed with its calendar, got %d", removed)
+	}
+	if stats := engine.GetCacheStats(); stats.TotalEntries != 1 {
+		t.Errorf("Expected only b.ics left, got %d entries", stats.TotalEntries)
+	}
+	if removed := NewEngineWithCache(nil).Invalidate("alice/home/b.ics"); removed != 0 {
+		t.Errorf("Expected an engine without cache to flush nothing, got %d", removed)
+	}
+}
+
+func TestRecurrenceCacheCloseTwice(t *testing.T) {
+	cache := NewRecurrenceCache(DefaultCacheConfig)
+	cache.Close()
+	cache.Close() // must not panic
+}
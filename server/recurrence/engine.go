@@ -1,6 +1,7 @@
 package recurrence
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -11,8 +12,14 @@ import (
 type Engine struct {
 	cache  *RecurrenceCache
 	config EngineConfig
+	scope  cacheScope      // see ForObject
+	ctx    context.Context // see WithContext
 }
 
+// contextCheckInterval is how many RRULE instances are generated between
+// checks of the engine's context.
+const contextCheckInterval = 256
+
 // NewEngine creates a new recurrence engine instance with default cache
 func NewEngine() *Engine {
 	return NewEngineWithConfig(DefaultEngineConfig)
@@ -26,6 +33,50 @@ func NewEngineWithCache(cache *RecurrenceCache) *Engine {
 	}
 }
 
+// ForObject returns an engine sharing e's cache whose entries are keyed by an
+// object, such as its path, and its ETag. Entries of earlier versions of the
+// object are never returned, and Invalidate flushes them all when it changes.
+func (e *Engine) ForObject(object, etag string) *Engine {
+	scoped := *e
+	scoped.scope = cacheScope{object: object, etag: etag}
+	return &scoped
+}
+
+// WithContext returns an engine sharing e's cache whose expansions stop with
+// ctx's error once ctx is done, such as when the client of the request they
+// serve goes away. Results already cached are still returned.
+func (e *Engine) WithContext(ctx context.Context) *Engine {
+	scoped := *e
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// err returns the error of the engine's context, nil without one.
+func (e *Engine) err() error {
+	if e.ctx == nil {
+		return nil
+	}
+	return e.ctx.Err()
+}
+
+// Invalidate flushes the cache entries of an object, see ForObject. It
+// returns how many were removed.
+func (e *Engine) Invalidate(object string) int {
+	if e.cache == nil {
+		return 0
+	}
+	return e.cache.Invalidate(object)
+}
+
+// InvalidatePrefix flushes the cache entries of every object starting with
+// prefix.
+func (e *Engine) InvalidatePrefix(prefix string) int {
+	if e.cache == nil {
+		return 0
+	}
+	return e.cache.InvalidatePrefix(prefix)
+}
+
 // NewEngineWithoutCache creates a new recurrence engine instance without caching
 func NewEngineWithoutCache() *Engine {
 	return NewEngineWithConfig(DisabledCacheConfig)
@@ -40,46 +91,62 @@ func (e *Engine) HasOccurrenceInRange(
 ) (bool, error) {
 	// Check cache first if available
 	if e.cache != nil {
-		if cached, found := e.cache.Get("HasOccurrenceInRange", masterStart, masterEnd, recurrence, rangeStart, rangeEnd); found {
+		if cached, found := e.cache.get(e.scope, "HasOccurrenceInRange", masterStart, masterEnd, recurrence, rangeStart, rangeEnd); found {
 			if result, ok := cached.(bool); ok {
 				return result, nil
 			}
 		}
+		if result, ok := e.warmedOccurrence(masterStart, masterEnd, recurrence, rangeStart, rangeEnd); ok {
+			return result, nil
+		}
 	}
 
 	// Compute the actual result
-	result, err := e.computeHasOccurrenceInRange(masterStart, masterEnd, recurrence, rangeStart, rangeEnd)
+	if err := e.err(); err != nil {
+		return false, err
+	}
+	result, err := e.computeHasOccurrenceInRange(masterStart, masterEnd, recurrence, rangeStart, rangeEnd, &EvaluationStats{})
 	if err != nil {
 		return false, err
 	}
 
 	// Cache the result if caching is enabled
 	if e.cache != nil {
-		e.cache.Set("HasOccurrenceInRange", masterStart, masterEnd, recurrence, rangeStart, rangeEnd, result)
+		e.cache.set(e.scope, "HasOccurrenceInRange", masterStart, masterEnd, recurrence, rangeStart, rangeEnd, result)
 	}
 
 	return result, nil
 }
 
-// computeHasOccurrenceInRange does the actual computation without caching
+// computeHasOccurrenceInRange does the actual computation without caching,
+// counting what it evaluates in stats
 func (e *Engine) computeHasOccurrenceInRange(
 	masterStart, masterEnd time.Time,
 	recurrence RecurrenceInfo,
 	rangeStart, rangeEnd time.Time,
+	stats *EvaluationStats,
 ) (bool, error) {
+	if len(recurrence.EXRULE) > 0 {
+		var err error
+		if recurrence, err = e.applyExceptionRules(masterStart, masterEnd, recurrence, rangeStart, rangeEnd); err != nil {
+			return false, err
+		}
+	}
+
 	// Fast path: check master event first (if no RRULE, this is the only occurrence)
-	// Use proper time range overlap logic: start <= rangeEnd AND end >= rangeStart
-	if !masterStart.After(rangeEnd) && !masterEnd.Before(rangeStart) {
+	if overlaps(masterStart, masterEnd, rangeStart, rangeEnd) {
 		// Check if this occurrence is not excluded by EXDATE
 		if !e.isExcluded(masterStart, recurrence.EXDATE) {
+			stats.MasterMatched = true
 			return true, nil
 		}
+		stats.Excluded++
 	}
 
 	// Check RRULE occurrences if present
 	if recurrence.RRULE != "" {
 		hasRRuleOccurrence, err := e.hasRRuleOccurrenceInRange(
-			masterStart, recurrence.RRULE, recurrence.EXDATE, rangeStart, rangeEnd)
+			masterStart, masterEnd, recurrence, rangeStart, rangeEnd, stats)
 		if err != nil {
 			return false, fmt.Errorf("failed to check RRULE occurrences: %w", err)
 		}
@@ -89,13 +156,21 @@ func (e *Engine) computeHasOccurrenceInRange(
 	}
 
 	// Check RDATE occurrences
-	duration := masterEnd.Sub(masterStart)
 	for _, rdate := range recurrence.RDATE {
-		rdateEnd := rdate.Add(duration)
-		// Use proper time range overlap logic: start <= rangeEnd AND end >= rangeStart
-		if !rdate.After(rangeEnd) && !rdateEnd.Before(rangeStart) && !e.isExcluded(rdate, recurrence.EXDATE) {
+		if recurrence.AllDay {
+			// Date-only RDATEs are stored as midnight UTC; the event's days
+			// start at midnight in the master's zone
+			rdate = time.Date(rdate.Year(), rdate.Month(), rdate.Day(), 0, 0, 0, 0, masterStart.Location())
+		}
+		stats.RDATEs++
+		rdateEnd := occurrenceEnd(rdate, masterStart, masterEnd, recurrence.AllDay)
+		if !overlaps(rdate, rdateEnd, rangeStart, rangeEnd) {
+			continue
+		}
+		if !e.isExcluded(rdate, recurrence.EXDATE) {
 			return true, nil
 		}
+		stats.Excluded++
 	}
 
 	return false, nil
@@ -103,66 +178,154 @@ func (e *Engine) computeHasOccurrenceInRange(
 
 // hasRRuleOccurrenceInRange checks if an RRULE has any occurrence in range (optimized)
 func (e *Engine) hasRRuleOccurrenceInRange(
-	masterStart time.Time, rruleStr string, exdates []time.Time, rangeStart, rangeEnd time.Time) (bool, error) {
+	masterStart, masterEnd time.Time, recurrence RecurrenceInfo, rangeStart, rangeEnd time.Time, stats *EvaluationStats) (bool, error) {
+
+	// Occurrences starting up to one event length before the range still
+	// overlap it
+	expandStart := rangeStart.Add(-masterEnd.Sub(masterStart))
+	if recurrence.AllDay {
+		expandStart = expandStart.Add(-time.Hour) // a DST change may lengthen a day
+	}
 
 	// For performance, we limit the expansion to check only the first few occurrences
 	// This is a reasonable trade-off for the "has occurrence" check
 	limitedRangeEnd := rangeEnd
 	if rangeEnd.Sub(rangeStart) > e.config.LargeRangeThreshold {
 		limitedRangeEnd = rangeStart.Add(e.config.LargeRangeLimit)
+		stats.RangeLimited = true
 	}
 
-	occurrences, err := e.expandRRule(masterStart, rruleStr, rangeStart, limitedRangeEnd)
+	occurrences, err := e.expandRRule(masterStart, recurrence.RRULE, expandStart, limitedRangeEnd)
 	if err != nil {
 		return false, err
 	}
 
+	stats.Generated += len(occurrences)
+
 	// Check if any occurrence is not excluded
+	inRange := func(occurrence time.Time) bool {
+		end := occurrenceEnd(occurrence, masterStart, masterEnd, recurrence.AllDay)
+		if !overlaps(occurrence, end, rangeStart, rangeEnd) {
+			return false
+		}
+		if e.isExcluded(occurrence, recurrence.EXDATE) {
+			stats.Excluded++
+			return false
+		}
+		return true
+	}
 	for _, occurrence := range occurrences {
-		if !e.isExcluded(occurrence, exdates) {
+		if inRange(occurrence) {
 			return true, nil
 		}
 	}
 
-	// If we limited the range and found nothing, try the full range with a reasonable limit
-	if limitedRangeEnd.Before(rangeEnd) && len(occurrences) > 0 {
-		fullOccurrences, err := e.expandRRule(masterStart, rruleStr, rangeStart, rangeEnd)
+	// If we limited the range and found nothing, try the rest of it with a
+	// reasonable limit. Sparse rules such as BYWEEKNO or BYYEARDAY may have
+	// no occurrence at all in the limited part.
+	if limitedRangeEnd.Before(rangeEnd) {
+		rest, err := e.expandRRule(masterStart, recurrence.RRULE, limitedRangeEnd, rangeEnd)
 		if err != nil {
 			return false, err
 		}
 
+		stats.Generated += len(rest)
+
 		// Check up to configured max occurrences for performance
-		limit := len(fullOccurrences)
+		limit := len(rest)
 		if limit > e.config.MaxExpansionOccurrences {
 			limit = e.config.MaxExpansionOccurrences
 		}
 
 		for i := 0; i < limit; i++ {
-			if !e.isExcluded(fullOccurrences[i], exdates) {
+			if inRange(rest[i]) {
 				return true, nil
 			}
 		}
+		stats.Truncated = limit < len(rest)
 	}
 
 	return false, nil
 }
 
-// expandRRule expands an RRULE within the given time range
-func (e *Engine) expandRRule(masterStart time.Time, rruleStr string, rangeStart, rangeEnd time.Time) ([]time.Time, error) {
-	// Build the full RRULE string for parsing
-	dtstart := masterStart.UTC().Format("20060102T150405Z")
-	fullRRule := fmt.Sprintf("DTSTART:%s\nRRULE:%s", dtstart, rruleStr)
+// applyExceptionRules returns recurrence with the instances its EXRULEs
+// generate around the range added to EXDATE, which is how RFC 2445 defines
+// them: the recurrence set minus the exception rules' instances.
+func (e *Engine) applyExceptionRules(masterStart, masterEnd time.Time, recurrence RecurrenceInfo, rangeStart, rangeEnd time.Time) (RecurrenceInfo, error) {
+	from := rangeStart.Add(-masterEnd.Sub(masterStart))
+	if recurrence.AllDay {
+		from = from.Add(-time.Hour) // a DST change may lengthen a day
+	}
+	exdates := append([]time.Time(nil), recurrence.EXDATE...)
+	for _, exrule := range recurrence.EXRULE {
+		excluded, err := e.expandRRule(masterStart, exrule, from, rangeEnd)
+		if err != nil {
+			return recurrence, fmt.Errorf("failed to expand EXRULE: %w", err)
+		}
+		exdates = append(exdates, excluded...)
+	}
+	recurrence.EXDATE = exdates
+	return recurrence, nil
+}
+
+// overlaps implements the time-range test of RFC 4791 section 9.9: an
+// occurrence overlaps the range if it starts before the range ends and ends
+// after it starts. Both ends are exclusive, so an all-day event ending at
+// midnight doesn't match a range starting then. Instantaneous occurrences
+// match ranges with rangeStart <= start < rangeEnd.
+func overlaps(start, end, rangeStart, rangeEnd time.Time) bool {
+	if end.After(start) {
+		return start.Before(rangeEnd) && end.After(rangeStart)
+	}
+	return !start.Before(rangeStart) && start.Before(rangeEnd)
+}
 
-	// Parse the RRULE
-	ruleSet, err := rrule.StrToRRuleSet(fullRRule)
+// occurrenceEnd returns the end of the occurrence starting at start. All-day
+// occurrences last as many calendar days as the master, whatever DST does to
+// the length of those days.
+func occurrenceEnd(start, masterStart, masterEnd time.Time, allDay bool) time.Time {
+	if allDay {
+		y1, m1, d1 := masterStart.Date()
+		y2, m2, d2 := masterEnd.Date()
+		days := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC))
+		return start.AddDate(0, 0, int(days/(24*time.Hour)))
+	}
+	return start.Add(masterEnd.Sub(masterStart))
+}
+
+// expandRRule expands an RRULE within the given time range. Occurrences are
+// generated in masterStart's location, so an event at 09:00 Europe/Berlin
+// stays at 09:00 local time across daylight saving changes.
+func (e *Engine) expandRRule(masterStart time.Time, rruleStr string, rangeStart, rangeEnd time.Time) ([]time.Time, error) {
+	// UNTIL without a Z suffix is in the same zone as DTSTART
+	opt, err := rrule.StrToROptionInLocation(normalizeRRule(rruleStr), masterStart.Location())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse RRULE '%s': %w", rruleStr, err)
+	}
+	opt.Dtstart = masterStart
+	rule, err := rrule.NewRRule(*opt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse RRULE '%s': %w", rruleStr, err)
 	}
 
-	// Get occurrences in the time range
-	// Note: rrule-go's Between method is inclusive of start, exclusive of end
-	occurrences := ruleSet.Between(rangeStart, rangeEnd, true)
-
+	// Get occurrences in the time range, both ends included as with
+	// rrule-go's Between, checking the context while generating them
+	var occurrences []time.Time
+	next := rule.Iterator()
+	for i := 0; ; i++ {
+		if i%contextCheckInterval == 0 {
+			if err := e.err(); err != nil {
+				return nil, err
+			}
+		}
+		occurrence, ok := next()
+		if !ok || occurrence.After(rangeEnd) {
+			break
+		}
+		if !occurrence.Before(rangeStart) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
 	return occurrences, nil
 }
 
@@ -1,6 +1,7 @@
 package recurrence
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -89,3 +90,208 @@ func TestExtractRecurrenceInfoFromComponent(t *testing.T) {
 	assert.Empty(t, info.EXDATE)
 	assert.Nil(t, info.RecurrenceID)
 }
+
+func TestEngine_DaylightSavingTime(t *testing.T) {
+	engine := NewEngineWithoutCache()
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// Weekly at 09:00 Berlin time; clocks go forward on 2025-03-30
+	masterStart := time.Date(2025, 3, 24, 9, 0, 0, 0, berlin)
+	masterEnd := masterStart.Add(time.Hour)
+	info := RecurrenceInfo{RRULE: "FREQ=WEEKLY"}
+
+	// 2025-03-31 09:00 CEST is 07:00 UTC, not 08:00 as in winter
+	summer := time.Date(2025, 3, 31, 7, 0, 0, 0, time.UTC)
+	found, err := engine.HasOccurrenceInRange(masterStart, masterEnd, info, summer, summer.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = engine.HasOccurrenceInRange(masterStart, masterEnd, info, summer.Add(time.Hour+15*time.Minute), summer.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestEngine_AllDay(t *testing.T) {
+	engine := NewEngineWithoutCache()
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	day := func(m time.Month, d int) time.Time { return time.Date(2025, m, d, 0, 0, 0, 0, berlin) }
+
+	// Two-day event every week from Saturday 2025-03-22; clocks go forward on
+	// Sunday 2025-03-30, making the second weekend 47 hours long
+	info := RecurrenceInfo{
+		RRULE:  "FREQ=WEEKLY;COUNT=3",
+		RDATE:  []time.Time{time.Date(2025, 4, 15, 0, 0, 0, 0, time.UTC)},
+		EXDATE: []time.Time{time.Date(2025, 4, 5, 0, 0, 0, 0, time.UTC)},
+		AllDay: true,
+	}
+	masterStart, masterEnd := day(3, 22), day(3, 24)
+
+	tests := []struct {
+		name                 string
+		rangeStart, rangeEnd time.Time
+		want                 bool
+	}{
+		{"master's second day", day(3, 23).Add(12 * time.Hour), day(3, 23).Add(13 * time.Hour), true},
+		{"day after the master", day(3, 24), day(3, 25), false},
+		{"day before an occurrence", day(3, 28), day(3, 29), false},
+		{"last hour of the DST weekend", day(3, 31).Add(-time.Hour), day(3, 31), true},
+		{"day after the DST weekend", day(3, 31), day(4, 1), false},
+		{"excluded occurrence", day(4, 5), day(4, 7), false},
+		{"date-only RDATE in the master's zone", day(4, 16).Add(23 * time.Hour), day(4, 17), true},
+		{"day after the RDATE", day(4, 17), day(4, 18), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.HasOccurrenceInRange(masterStart, masterEnd, info, tt.rangeStart, tt.rangeEnd)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExtractBasicTimeInfoAllDay(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	event := func(props ...ical.Prop) *ical.Component {
+		comp := ical.NewComponent(ical.CompEvent)
+		for i := range props {
+			comp.Props.Add(&props[i])
+		}
+		return comp
+	}
+	date := func(name, value string) ical.Prop {
+		return ical.Prop{Name: name, Params: ical.Params{ical.ParamValue: {"DATE"}}, Value: value}
+	}
+	duration := ical.Prop{Name: ical.PropDuration, Params: ical.Params{}, Value: "P2D"}
+
+	tests := []struct {
+		name       string
+		comp       *ical.Component
+		start, end time.Time
+	}{
+		{"no DTEND", event(date(ical.PropDateTimeStart, "20250610")),
+			time.Date(2025, 6, 10, 0, 0, 0, 0, tokyo), time.Date(2025, 6, 11, 0, 0, 0, 0, tokyo)},
+		{"exclusive DTEND", event(date(ical.PropDateTimeStart, "20250610"), date(ical.PropDateTimeEnd, "20250612")),
+			time.Date(2025, 6, 10, 0, 0, 0, 0, tokyo), time.Date(2025, 6, 12, 0, 0, 0, 0, tokyo)},
+		{"DTEND equal to DTSTART", event(date(ical.PropDateTimeStart, "20250610"), date(ical.PropDateTimeEnd, "20250610")),
+			time.Date(2025, 6, 10, 0, 0, 0, 0, tokyo), time.Date(2025, 6, 11, 0, 0, 0, 0, tokyo)},
+		{"DURATION", event(date(ical.PropDateTimeStart, "20250610"), duration),
+			time.Date(2025, 6, 10, 0, 0, 0, 0, tokyo), time.Date(2025, 6, 12, 0, 0, 0, 0, tokyo)},
+		{"timed without DTEND", event(ical.Prop{Name: ical.PropDateTimeStart, Params: ical.Params{}, Value: "20250610T000000"}),
+			time.Date(2025, 6, 10, 0, 0, 0, 0, tokyo), time.Date(2025, 6, 10, 0, 0, 0, 0, tokyo)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := ExtractBasicTimeInfoInLocation(tt.comp, tokyo)
+			require.True(t, ok)
+			assert.True(t, tt.start.Equal(start), "start %v", start)
+			assert.True(t, tt.end.Equal(end), "end %v", end)
+		})
+	}
+
+	_, _, ok := ExtractBasicTimeInfoInLocation(event(), tokyo)
+	assert.False(t, ok)
+	assert.True(t, IsAllDay(event(ical.Prop{Name: ical.PropDateTimeStart, Params: ical.Params{}, Value: "20250610"})))
+	assert.False(t, IsAllDay(event(duration)))
+}
+
+func TestExtractRecurrenceInfoInLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	comp := ical.NewComponent(ical.CompEvent)
+	comp.Props.Add(&ical.Prop{Name: ical.PropExceptionDates, Params: ical.Params{}, Value: "20250310T090000,20250317T090000"})
+	comp.Props.Add(&ical.Prop{Name: ical.PropExceptionDates, Params: ical.Params{ical.ParamTimezoneID: {"Europe/Berlin"}}, Value: "20250324T090000"})
+	comp.Props.Add(&ical.Prop{Name: ical.PropExceptionDates, Params: ical.Params{}, Value: "20250331T090000Z"})
+
+	info := ExtractRecurrenceInfoInLocation(comp, newYork)
+	require.Len(t, info.EXDATE, 4)
+	// Floating values follow the location, across the DST change on 2025-03-09
+	assert.Equal(t, time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC), info.EXDATE[0].UTC())
+	assert.Equal(t, time.Date(2025, 3, 24, 8, 0, 0, 0, time.UTC), info.EXDATE[2].UTC())
+	assert.Equal(t, time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC), info.EXDATE[3])
+
+	info = ExtractRecurrenceInfoFromComponent(comp)
+	assert.Equal(t, time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC), info.EXDATE[0])
+}
+
+func TestEngine_EXRULE(t *testing.T) {
+	engine := NewEngineWithoutCache()
+	// Daily at 09:00 from Monday 2024-01-01, except on weekends
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	comp := ical.NewComponent(ical.CompEvent)
+	comp.Props.Add(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=DAILY"})
+	comp.Props.Add(&ical.Prop{Name: "EXRULE", Params: ical.Params{}, Value: "FREQ=WEEKLY;BYDAY=SA"})
+	comp.Props.Add(&ical.Prop{Name: "EXRULE", Params: ical.Params{}, Value: "FREQ=WEEKLY;BYDAY=SU"})
+	info := ExtractRecurrenceInfoFromComponent(comp)
+	require.Equal(t, []string{"FREQ=WEEKLY;BYDAY=SA", "FREQ=WEEKLY;BYDAY=SU"}, info.EXRULE)
+
+	day := func(d int) (time.Time, time.Time) {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, d+1, 0, 0, 0, 0, time.UTC)
+	}
+	for d, want := range map[int]bool{5: true, 6: false, 7: false, 8: true} {
+		rangeStart, rangeEnd := day(d)
+		found, err := engine.HasOccurrenceInRange(start, start.Add(time.Hour), info, rangeStart, rangeEnd)
+		require.NoError(t, err)
+		assert.Equal(t, want, found, "2024-01-%02d", d)
+	}
+
+	// The weekend as a whole has no occurrence
+	rangeStart, _ := day(6)
+	_, rangeEnd := day(7)
+	found, err := engine.HasOccurrenceInRange(start, start.Add(time.Hour), info, rangeStart, rangeEnd)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// An EXRULE that doesn't parse is an error, like an RRULE that doesn't
+	info.EXRULE = []string{"FREQ=SOMETIMES"}
+	_, err = engine.HasOccurrenceInRange(start, start.Add(time.Hour), info, rangeStart, rangeEnd)
+	assert.Error(t, err)
+}
+
+func TestEngine_WithContext(t *testing.T) {
+	engine := NewEngine()
+	defer engine.Close()
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	daily := RecurrenceInfo{RRULE: "FREQ=DAILY"}
+	rangeStart := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rangeEnd := rangeStart.AddDate(0, 0, 7)
+
+	found, err := engine.WithContext(context.Background()).HasOccurrenceInRange(start, end, daily, rangeStart, rangeEnd)
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	stopped := engine.WithContext(cancelled)
+	_, err = stopped.HasOccurrenceInRange(start, end, daily, rangeStart.AddDate(0, 1, 0), rangeEnd.AddDate(0, 1, 0))
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = stopped.ExpandInstances(start, end, daily, rangeStart, rangeEnd, ExpansionOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Results cached before are still answered, and the engine the context
+	// was bound to is left alone
+	found, err = stopped.HasOccurrenceInRange(start, end, daily, rangeStart, rangeEnd)
+	require.NoError(t, err)
+	assert.True(t, found)
+	instances, err := engine.ExpandInstances(start, end, daily, rangeStart, rangeEnd, ExpansionOptions{})
+	require.NoError(t, err)
+	assert.Len(t, instances, 7)
+}
+
+func TestEngine_WithContextStopsLongExpansion(t *testing.T) {
+	// Every second for a decade would take minutes to expand
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	secondly := RecurrenceInfo{RRULE: "FREQ=SECONDLY"}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	began := time.Now()
+	_, err := NewEngineWithoutCache().WithContext(ctx).ExpandInstances(start, start.Add(time.Second), secondly,
+		start, start.AddDate(10, 0, 0), ExpansionOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(began), 5*time.Second)
+}
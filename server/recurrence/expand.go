@@ -0,0 +1,139 @@
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// ExpandInstances returns the instances of a master component overlapping
+// the range, in order of their start: DTSTART's own, those of its RRULE and
+// RDATEs, minus EXDATEs and EXRULEs. On a recurring component every instance
+// has RecurrenceID set to its start, for matching RECURRENCE-ID overrides,
+// which are left to the caller along with opts.IncludeExceptions. At most
+// opts.MaxOccurrences instances are returned and opts.MaxTimeSpan of the
+// range expanded, when set. It stops with the error of the context set with
+// WithContext once that is done.
+func (e *Engine) ExpandInstances(
+	masterStart, masterEnd time.Time,
+	recurrence RecurrenceInfo,
+	rangeStart, rangeEnd time.Time,
+	opts ExpansionOptions,
+) ([]TimeOccurrence, error) {
+	if err := e.err(); err != nil {
+		return nil, err
+	}
+	if opts.MaxTimeSpan > 0 && rangeEnd.Sub(rangeStart) > opts.MaxTimeSpan {
+		rangeEnd = rangeStart.Add(opts.MaxTimeSpan)
+	}
+	if len(recurrence.EXRULE) > 0 {
+		var err error
+		if recurrence, err = e.applyExceptionRules(masterStart, masterEnd, recurrence, rangeStart, rangeEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	starts := []time.Time{masterStart}
+	if recurrence.RRULE != "" {
+		expandStart := rangeStart.Add(-masterEnd.Sub(masterStart))
+		if recurrence.AllDay {
+			expandStart = expandStart.Add(-time.Hour) // a DST change may lengthen a day
+		}
+		occurrences, err := e.expandRRule(masterStart, recurrence.RRULE, expandStart, rangeEnd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand RRULE: %w", err)
+		}
+		starts = append(starts, occurrences...)
+	}
+	for _, rdate := range recurrence.RDATE {
+		if recurrence.AllDay {
+			// Date-only RDATEs are stored as midnight UTC; the event's days
+			// start at midnight in the master's zone
+			rdate = time.Date(rdate.Year(), rdate.Month(), rdate.Day(), 0, 0, 0, 0, masterStart.Location())
+		}
+		starts = append(starts, rdate)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	recurring := recurrence.RRULE != "" || len(recurrence.RDATE) > 0
+	var instances []TimeOccurrence
+	for i, start := range starts {
+		if i > 0 && start.Equal(starts[i-1]) {
+			continue // DTSTART matching its rule, or an RDATE repeating an instance
+		}
+		end := occurrenceEnd(start, masterStart, masterEnd, recurrence.AllDay)
+		if !overlaps(start, end, rangeStart, rangeEnd) || e.isExcluded(start, recurrence.EXDATE) {
+			continue
+		}
+		instance := TimeOccurrence{Start: start, End: end}
+		if recurring {
+			recurrenceID := start
+			instance.RecurrenceID = &recurrenceID
+		}
+		instances = append(instances, instance)
+		if opts.MaxOccurrences > 0 && len(instances) >= opts.MaxOccurrences {
+			break
+		}
+	}
+	return instances, nil
+}
+
+// MaxSpanInstances caps the instances Span walks to find the last one of a
+// rule. Rules with more are reported unbounded, like those that never end.
+const MaxSpanInstances = 10000
+
+// Span returns the start of the first instance of a master component and the
+// end of its last one, for indexing. EXDATEs and EXRULEs are ignored, so the
+// span may be wider than the instances. bounded is false, and last the zero
+// time, when the recurrence never ends, has more than MaxSpanInstances
+// instances or its rule doesn't parse.
+func (e *Engine) Span(masterStart, masterEnd time.Time, recurrence RecurrenceInfo) (first, last time.Time, bounded bool) {
+	first, last = masterStart, masterEnd
+	if last.Before(first) {
+		last = first
+	}
+	for _, rdate := range recurrence.RDATE {
+		if recurrence.AllDay {
+			rdate = time.Date(rdate.Year(), rdate.Month(), rdate.Day(), 0, 0, 0, 0, masterStart.Location())
+		}
+		if rdate.Before(first) {
+			first = rdate
+		}
+		if end := occurrenceEnd(rdate, masterStart, masterEnd, recurrence.AllDay); end.After(last) {
+			last = end
+		}
+	}
+	if recurrence.RRULE == "" {
+		return first, last, true
+	}
+
+	opt, err := rrule.StrToROptionInLocation(normalizeRRule(recurrence.RRULE), masterStart.Location())
+	if err != nil || (opt.Count == 0 && opt.Until.IsZero()) {
+		return first, time.Time{}, false
+	}
+	opt.Dtstart = masterStart
+	rule, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return first, time.Time{}, false
+	}
+	var start time.Time
+	next := rule.Iterator()
+	for i := 0; ; i++ {
+		instance, ok := next()
+		if !ok {
+			break
+		}
+		if i == MaxSpanInstances {
+			return first, time.Time{}, false
+		}
+		start = instance
+	}
+	if !start.IsZero() {
+		if end := occurrenceEnd(start, masterStart, masterEnd, recurrence.AllDay); end.After(last) {
+			last = end
+		}
+	}
+	return first, last, true
+}
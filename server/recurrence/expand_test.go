@@ -0,0 +1,82 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_ExpandInstances(t *testing.T) {
+	engine := NewEngine()
+	defer engine.Close()
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	starts := func(occurrences []TimeOccurrence) []int {
+		var days []int
+		for _, o := range occurrences {
+			days = append(days, o.Start.Day())
+		}
+		return days
+	}
+
+	// RRULE, RDATE and EXDATE, including an instance still running at the start
+	occurrences, err := engine.ExpandInstances(start, end, RecurrenceInfo{
+		RRULE:  "FREQ=DAILY;COUNT=5",
+		RDATE:  []time.Time{start.AddDate(0, 0, 9)},
+		EXDATE: []time.Time{start.AddDate(0, 0, 3)},
+	}, day(2).Add(9*time.Hour+30*time.Minute), day(20), DefaultExpansionOptions)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3, 5, 10}, starts(occurrences))
+	for _, o := range occurrences {
+		require.NotNil(t, o.RecurrenceID)
+		assert.Equal(t, o.Start, *o.RecurrenceID)
+		assert.Equal(t, time.Hour, o.End.Sub(o.Start))
+	}
+
+	// A single event has no RecurrenceID
+	occurrences, err = engine.ExpandInstances(start, end, RecurrenceInfo{}, day(1), day(2), DefaultExpansionOptions)
+	require.NoError(t, err)
+	require.Len(t, occurrences, 1)
+	assert.Nil(t, occurrences[0].RecurrenceID)
+
+	// MaxOccurrences caps the result
+	occurrences, err = engine.ExpandInstances(start, end, RecurrenceInfo{RRULE: "FREQ=DAILY"},
+		day(1), day(31), ExpansionOptions{MaxOccurrences: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, starts(occurrences))
+}
+
+func TestEngine_Span(t *testing.T) {
+	engine := NewEngineWithCache(nil)
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	first, last, bounded := engine.Span(start, end, RecurrenceInfo{})
+	assert.True(t, bounded)
+	assert.Equal(t, start, first)
+	assert.Equal(t, end, last)
+
+	// COUNT and UNTIL end the span at the last instance, RDATEs widen it
+	first, last, bounded = engine.Span(start, end, RecurrenceInfo{
+		RRULE: "FREQ=WEEKLY;COUNT=3",
+		RDATE: []time.Time{start.AddDate(0, 0, -1)},
+	})
+	assert.True(t, bounded)
+	assert.Equal(t, start.AddDate(0, 0, -1), first)
+	assert.Equal(t, end.AddDate(0, 0, 14), last)
+
+	_, last, bounded = engine.Span(start, end, RecurrenceInfo{RRULE: "FREQ=DAILY;UNTIL=20240110T090000Z"})
+	assert.True(t, bounded)
+	assert.Equal(t, end.AddDate(0, 0, 9), last)
+
+	// Rules too long to walk are indexed as open-ended
+	for _, rule := range []string{"FREQ=DAILY", "FREQ=SOMETIMES", "FREQ=MINUTELY;UNTIL=20990101T000000Z"} {
+		first, last, bounded = engine.Span(start, end, RecurrenceInfo{RRULE: rule})
+		assert.False(t, bounded, rule)
+		assert.Equal(t, start, first, rule)
+		assert.True(t, last.IsZero(), rule)
+	}
+}
@@ -4,31 +4,46 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cyp0633/libcaldora/server/tzalias"
 	"github.com/emersion/go-ical"
 )
 
+// propExceptionRule is the EXRULE property of RFC 2445 section 4.8.5.2.
+const propExceptionRule = "EXRULE"
+
 // ExtractRecurrenceInfoFromComponent extracts recurrence information from an iCal component
 func ExtractRecurrenceInfoFromComponent(comp *ical.Component) RecurrenceInfo {
-	info := RecurrenceInfo{}
+	return ExtractRecurrenceInfoInLocation(comp, time.UTC)
+}
+
+// ExtractRecurrenceInfoInLocation is ExtractRecurrenceInfoFromComponent with
+// floating RDATE, EXDATE and RECURRENCE-ID values read in loc.
+func ExtractRecurrenceInfoInLocation(comp *ical.Component, loc *time.Location) RecurrenceInfo {
+	info := RecurrenceInfo{AllDay: IsAllDay(comp)}
 
 	// Extract RRULE
 	if rruleProp := comp.Props.Get(ical.PropRecurrenceRule); rruleProp != nil && rruleProp.Value != "" {
 		info.RRULE = rruleProp.Value
 	}
 
-	// Extract RDATE
-	if rdateProp := comp.Props.Get(ical.PropRecurrenceDates); rdateProp != nil && rdateProp.Value != "" {
-		info.RDATE = parseRecurrenceDates(rdateProp.Value, rdateProp.Params)
+	// EXRULE was dropped by RFC 5545, but data from old clients still has it
+	for _, prop := range comp.Props.Values(propExceptionRule) {
+		if prop.Value != "" {
+			info.EXRULE = append(info.EXRULE, prop.Value)
+		}
 	}
 
-	// Extract EXDATE
-	if exdateProp := comp.Props.Get(ical.PropExceptionDates); exdateProp != nil && exdateProp.Value != "" {
-		info.EXDATE = parseExceptionDates(exdateProp.Value, exdateProp.Params)
+	// Extract RDATE and EXDATE, which may be split over several lines
+	for _, prop := range comp.Props.Values(ical.PropRecurrenceDates) {
+		info.RDATE = append(info.RDATE, parseDateList(prop.Value, prop.Params, loc)...)
+	}
+	for _, prop := range comp.Props.Values(ical.PropExceptionDates) {
+		info.EXDATE = append(info.EXDATE, parseDateList(prop.Value, prop.Params, loc)...)
 	}
 
 	// Extract RECURRENCE-ID (for exception instances)
 	if recurrenceIdProp := comp.Props.Get("RECURRENCE-ID"); recurrenceIdProp != nil && recurrenceIdProp.Value != "" {
-		if recId, err := parseDateTime(recurrenceIdProp.Value, recurrenceIdProp.Params); err == nil {
+		if recId, err := parseDateTime(recurrenceIdProp.Value, recurrenceIdProp.Params, loc); err == nil {
 			info.RecurrenceID = &recId
 		}
 	}
@@ -36,37 +51,54 @@ func ExtractRecurrenceInfoFromComponent(comp *ical.Component) RecurrenceInfo {
 	return info
 }
 
-// ExtractBasicTimeInfoFromComponent extracts start and end times from an iCal component
+// ExtractBasicTimeInfoFromComponent extracts start and end times from an iCal component.
+// TZIDs are resolved through tzalias, so objects stored before normalization
+// (or by other writers) still expand. Floating times are read as UTC.
 func ExtractBasicTimeInfoFromComponent(comp *ical.Component) (start, end time.Time, hasTime bool) {
+	return ExtractBasicTimeInfoInLocation(comp, time.UTC)
+}
+
+// ExtractBasicTimeInfoInLocation is ExtractBasicTimeInfoFromComponent with
+// floating times, those without TZID or Z suffix, read in loc. RFC 4791
+// section 9.9 has them evaluated in the calendar's time zone.
+func ExtractBasicTimeInfoInLocation(comp *ical.Component, loc *time.Location) (start, end time.Time, hasTime bool) {
 	// Get start time
-	if dtstart, err := comp.Props.DateTime(ical.PropDateTimeStart, nil); err == nil {
+	if dtstartProp := comp.Props.Get(ical.PropDateTimeStart); dtstartProp != nil {
+		dtstart, err := tzalias.DateTime(comp.Props, ical.PropDateTimeStart, loc)
+		if err != nil {
+			return
+		}
 		start = dtstart
 		hasTime = true
+		allDay := isDateValue(dtstartProp)
 
 		// Get end time - either from DTEND or DURATION or default
-		if dtend, err := comp.Props.DateTime(ical.PropDateTimeEnd, nil); err == nil {
+		if comp.Props.Get(ical.PropDateTimeEnd) != nil {
+			dtend, err := tzalias.DateTime(comp.Props, ical.PropDateTimeEnd, loc)
+			if err != nil {
+				hasTime = false
+				return
+			}
 			end = dtend
 
-			// Special handling for all-day events: if start and end are the same DATE,
-			// treat it as a 24-hour event ending at the start of the next day.
-			startYear, startMonth, startDay := start.Date()
-			endYear, endMonth, endDay := end.Date()
-			if isAllDayDate(start) && startYear == endYear && startMonth == endMonth && startDay == endDay {
+			// DTEND is exclusive; some clients write DTEND equal to an
+			// all-day DTSTART, meaning the single day
+			if allDay && !end.After(start) {
 				end = start.AddDate(0, 0, 1)
 			}
 		} else if durationProp := comp.Props.Get(ical.PropDuration); durationProp != nil {
-			if duration, err := durationProp.Duration(); err == nil {
-				end = start.Add(duration)
-			} else {
-				// Invalid duration, fall back to default
+			duration, err := durationProp.Duration()
+			if err != nil {
+				// Invalid duration
 				hasTime = false
 				return
 			}
+			end = addDuration(start, duration, allDay)
 		} else {
 			// Default duration:
 			// For all-day events (date values), duration is 1 day.
 			// For timed events, it's an instantaneous event (end == start).
-			if isAllDayDate(start) {
+			if allDay {
 				end = start.AddDate(0, 0, 1)
 			} else {
 				end = start
@@ -75,8 +107,8 @@ func ExtractBasicTimeInfoFromComponent(comp *ical.Component) (start, end time.Ti
 	}
 
 	// For VTODO, also check DUE property
-	if comp.Name == ical.CompToDo {
-		if due, err := comp.Props.DateTime(ical.PropDue, nil); err == nil {
+	if comp.Name == ical.CompToDo && comp.Props.Get(ical.PropDue) != nil {
+		if due, err := tzalias.DateTime(comp.Props, ical.PropDue, loc); err == nil {
 			if !hasTime {
 				start = due
 				end = due
@@ -93,150 +125,75 @@ func ExtractBasicTimeInfoFromComponent(comp *ical.Component) (start, end time.Ti
 	return start, end, hasTime
 }
 
-// parseRecurrenceDates parses RDATE property value into time.Time slice
-func parseRecurrenceDates(value string, params map[string][]string) []time.Time {
-	if value == "" {
-		return nil
-	}
-
-	var rdates []time.Time
-	rdateStrings := strings.Split(value, ",")
-
-	// Check if this is a date-only RDATE (VALUE=DATE parameter)
-	isDateOnly := false
-	if params != nil {
-		if valueParam := params["VALUE"]; len(valueParam) > 0 && strings.ToUpper(valueParam[0]) == "DATE" {
-			isDateOnly = true
-		}
-	}
-
-	for _, rdateStr := range rdateStrings {
-		rdateStr = strings.TrimSpace(rdateStr)
-		if rdateStr == "" {
-			continue
-		}
-
-		var rdate time.Time
-		var err error
-
-		if isDateOnly {
-			// Parse as date-only and store with 00:00:00 time in UTC
-			rdate, err = time.Parse("20060102", rdateStr)
-			if err == nil {
-				// Store as midnight UTC for date-only RDATEs
-				rdate = time.Date(rdate.Year(), rdate.Month(), rdate.Day(), 0, 0, 0, 0, time.UTC)
-			}
-		} else {
-			// Parse the iCalendar date-time format
-			rdate, err = time.Parse("20060102T150405Z", rdateStr)
-			if err != nil {
-				// Try parsing as date-only format as fallback
-				rdate, err = time.Parse("20060102", rdateStr)
-				if err == nil {
-					// If we had to fall back to date parsing, treat as date-only
-					rdate = time.Date(rdate.Year(), rdate.Month(), rdate.Day(), 0, 0, 0, 0, time.UTC)
-				}
-			}
-		}
-
-		if err == nil {
-			rdates = append(rdates, rdate)
-		}
-	}
-
-	return rdates
+// IsAllDay reports whether comp's DTSTART is a DATE value. All-day events
+// cover whole days in the time zone they're read in: DTSTART is the first
+// day and DTEND the first day after the event.
+func IsAllDay(comp *ical.Component) bool {
+	prop := comp.Props.Get(ical.PropDateTimeStart)
+	return prop != nil && isDateValue(prop)
 }
 
-// parseExceptionDates parses EXDATE property value into time.Time slice
-func parseExceptionDates(value string, params map[string][]string) []time.Time {
-	if value == "" {
-		return nil
+// isDateValue reports whether prop holds a DATE rather than a DATE-TIME.
+func isDateValue(prop *ical.Prop) bool {
+	if v := prop.Params.Get(ical.ParamValue); v != "" {
+		return strings.EqualFold(v, string(ical.ValueDate))
 	}
+	return len(prop.Value) == len("20060102")
+}
 
-	var exdates []time.Time
-	exdateStrings := strings.Split(value, ",")
-
-	// Check if this is a date-only EXDATE (VALUE=DATE parameter)
-	isDateOnly := false
-	if params != nil {
-		if valueParam := params["VALUE"]; len(valueParam) > 0 && strings.ToUpper(valueParam[0]) == "DATE" {
-			isDateOnly = true
-		}
+// addDuration adds d to start. Whole days are added as calendar days for
+// all-day events, so they keep ending at midnight across DST changes.
+func addDuration(start time.Time, d time.Duration, allDay bool) time.Time {
+	if allDay && d%(24*time.Hour) == 0 {
+		return start.AddDate(0, 0, int(d/(24*time.Hour)))
 	}
+	return start.Add(d)
+}
 
-	for _, exdateStr := range exdateStrings {
-		exdateStr = strings.TrimSpace(exdateStr)
-		if exdateStr == "" {
+// parseDateList parses the comma-separated values of an RDATE or EXDATE
+// property, skipping those that don't parse (such as RDATE periods).
+func parseDateList(value string, params ical.Params, loc *time.Location) []time.Time {
+	var dates []time.Time
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
 			continue
 		}
-
-		var exdate time.Time
-		var err error
-
-		if isDateOnly {
-			// Parse as date-only and store with 00:00:00 time in UTC
-			exdate, err = time.Parse("20060102", exdateStr)
-			if err == nil {
-				// Store as midnight UTC for date-only EXDATEs
-				exdate = time.Date(exdate.Year(), exdate.Month(), exdate.Day(), 0, 0, 0, 0, time.UTC)
-			}
-		} else {
-			// Parse the iCalendar date-time format
-			exdate, err = time.Parse("20060102T150405Z", exdateStr)
-			if err != nil {
-				// Try parsing as date-only format as fallback
-				exdate, err = time.Parse("20060102", exdateStr)
-				if err == nil {
-					// If we had to fall back to date parsing, treat as date-only
-					exdate = time.Date(exdate.Year(), exdate.Month(), exdate.Day(), 0, 0, 0, 0, time.UTC)
-				}
-			}
-		}
-
-		if err == nil {
-			exdates = append(exdates, exdate)
+		if t, err := parseDateTime(v, params, loc); err == nil {
+			dates = append(dates, t)
 		}
 	}
-
-	return exdates
+	return dates
 }
 
-// parseDateTime parses a date-time string from iCalendar properties
-func parseDateTime(value string, params map[string][]string) (time.Time, error) {
+// parseDateTime parses a date or date-time value from iCalendar properties.
+// Dates are stored as midnight UTC. Date-times with a TZID are read in that
+// zone, UTC ones as UTC, and floating ones in loc.
+func parseDateTime(value string, params ical.Params, loc *time.Location) (time.Time, error) {
 	// Check if this is a date-only value (VALUE=DATE parameter)
-	isDateOnly := false
-	if params != nil {
-		if valueParam := params["VALUE"]; len(valueParam) > 0 && strings.ToUpper(valueParam[0]) == "DATE" {
-			isDateOnly = true
-		}
-	}
-
+	isDateOnly := strings.EqualFold(params.Get(ical.ParamValue), "DATE") || len(value) == len("20060102")
 	if isDateOnly {
-		// Parse as date-only and store with 00:00:00 time in UTC
 		t, err := time.Parse("20060102", value)
-		if err == nil {
-			// Store as midnight UTC for date-only values
-			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
-		}
-		return t, err
-	} else {
-		// Try parsing as iCalendar date-time format first
-		t, err := time.Parse("20060102T150405Z", value)
 		if err != nil {
-			// Try parsing as date-only format as fallback
-			t, err = time.Parse("20060102", value)
-			if err == nil {
-				// If we had to fall back to date parsing, treat as date-only
-				t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
-			}
+			return t, err
 		}
-		return t, err
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
 	}
-}
 
-// isAllDayDate checks if a time represents an all-day date (time part is midnight)
-func isAllDayDate(t time.Time) bool {
-	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if tzid := params.Get(ical.ParamTimezoneID); tzid != "" {
+		tzLoc, err := time.LoadLocation(tzalias.Normalize(tzid))
+		if err != nil {
+			return time.Time{}, err
+		}
+		loc = tzLoc
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
 }
 
 // SafeTimeDeref safely dereferences a time pointer, returning zero time if nil
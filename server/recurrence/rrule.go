@@ -0,0 +1,51 @@
+package recurrence
+
+import (
+	"strings"
+)
+
+// normalizeRRule rewrites an RRULE value into the subset of RFC 5545 that
+// rrule-go parses, which expands the full grammar (BYSETPOS, BYWEEKNO,
+// BYYEARDAY, WKST and INTERVAL with any BYxxx part) but is stricter about
+// its input:
+//
+//   - Rule part names and values are upper-cased, they're case-insensitive.
+//   - Empty parts, as left by a trailing ";", are dropped.
+//   - x-name parts are dropped, they don't change the recurrence set.
+//   - RSCALE=GREGORIAN and SKIP=OMIT (RFC 7529) are dropped, they're the
+//     RFC 5545 behavior. Other calendar scales and SKIP values stay and fail
+//     to parse.
+//   - BYSECOND=60 becomes 59. Go times have no leap seconds, and RFC 5545
+//     section 3.3.12 reads second 60 as 59 then.
+//   - A DATE UNTIL bounds the rule at the end of that day, so it includes
+//     the day's occurrence of a timed DTSTART as clients sending it expect.
+//
+// Known limitation: when DTSTART doesn't match the rule, RFC 5545 still
+// counts it as the first instance while rrule-go leaves it out, so COUNT
+// rules yield one more instance. Such recurrence sets are undefined by the
+// RFC; HasOccurrenceInRange checks DTSTART itself regardless.
+func normalizeRRule(rule string) string {
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+	var parts []string
+	for _, part := range strings.Split(rule, ";") {
+		name, value, _ := strings.Cut(part, "=")
+		switch {
+		case part == "", strings.HasPrefix(name, "X-"):
+			continue
+		case name == "RSCALE" && value == "GREGORIAN", name == "SKIP" && value == "OMIT":
+			continue
+		case name == "BYSECOND":
+			seconds := strings.Split(value, ",")
+			for i, s := range seconds {
+				if s == "60" {
+					seconds[i] = "59"
+				}
+			}
+			part = name + "=" + strings.Join(seconds, ",")
+		case name == "UNTIL" && len(value) == len("20060102"):
+			part += "T235959"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ";")
+}
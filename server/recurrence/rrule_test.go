@@ -0,0 +1,196 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExpandRRuleGrammar checks the expansion of RRULE parts against the
+// examples of RFC 5545 section 3.8.5.3, which start in America/New_York.
+func TestExpandRRuleGrammar(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	at := func(y int, m time.Month, d, h int) time.Time { return time.Date(y, m, d, h, 0, 0, 0, ny) }
+	day := func(y int, m time.Month, d int) time.Time { return at(y, m, d, 9) }
+
+	tests := []struct {
+		name   string
+		start  time.Time
+		rrule  string
+		until  time.Time // end of the expansion range
+		expect []time.Time
+	}{
+		{
+			name:  "BYSETPOS last weekday of the month",
+			start: day(1997, 9, 30),
+			rrule: "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			until: day(1998, 3, 1),
+			expect: []time.Time{day(1997, 9, 30), day(1997, 10, 31), day(1997, 11, 28),
+				day(1997, 12, 31), day(1998, 1, 30), day(1998, 2, 27)},
+		},
+		{
+			name:   "BYSETPOS third instance of several weekdays",
+			start:  day(1997, 9, 4),
+			rrule:  "FREQ=MONTHLY;COUNT=3;BYDAY=TU,WE,TH;BYSETPOS=3",
+			until:  day(1998, 1, 1),
+			expect: []time.Time{day(1997, 9, 4), day(1997, 10, 7), day(1997, 11, 6)},
+		},
+		{
+			name:   "BYSETPOS with hourly parts",
+			start:  day(2024, 1, 1),
+			rrule:  "FREQ=DAILY;COUNT=3;BYHOUR=9,12,17;BYSETPOS=1,-1",
+			until:  day(2024, 2, 1),
+			expect: []time.Time{day(2024, 1, 1), at(2024, 1, 1, 17), day(2024, 1, 2)},
+		},
+		{
+			name:   "BYWEEKNO Monday of week 20",
+			start:  day(1997, 5, 12),
+			rrule:  "FREQ=YEARLY;BYWEEKNO=20;BYDAY=MO",
+			until:  day(2000, 1, 1),
+			expect: []time.Time{day(1997, 5, 12), day(1998, 5, 11), day(1999, 5, 17)},
+		},
+		{
+			name:   "BYWEEKNO negative week",
+			start:  day(2024, 12, 23),
+			rrule:  "FREQ=YEARLY;BYWEEKNO=-1;BYDAY=MO",
+			until:  day(2027, 1, 1),
+			expect: []time.Time{day(2024, 12, 23), day(2025, 12, 22), day(2026, 12, 28)},
+		},
+		{
+			name:   "BYWEEKNO week 1 starting in the previous year",
+			start:  day(2024, 12, 30),
+			rrule:  "FREQ=YEARLY;COUNT=3;BYWEEKNO=1;BYDAY=MO",
+			until:  day(2030, 1, 1),
+			expect: []time.Time{day(2024, 12, 30), day(2025, 12, 29), day(2027, 1, 4)},
+		},
+		{
+			name:   "BYYEARDAY every third year",
+			start:  day(1997, 1, 1),
+			rrule:  "FREQ=YEARLY;INTERVAL=3;COUNT=10;BYYEARDAY=1,100,200",
+			until:  day(2001, 1, 1),
+			expect: []time.Time{day(1997, 1, 1), day(1997, 4, 10), day(1997, 7, 19), day(2000, 1, 1), day(2000, 4, 9), day(2000, 7, 18)},
+		},
+		{
+			name:   "BYYEARDAY negative day in leap years",
+			start:  day(2023, 12, 31),
+			rrule:  "FREQ=YEARLY;COUNT=3;BYYEARDAY=-1,-366",
+			until:  day(2026, 1, 1),
+			expect: []time.Time{day(2023, 12, 31), day(2024, 1, 1), day(2024, 12, 31)},
+		},
+		{
+			name:   "WKST=MO with INTERVAL=2",
+			start:  day(1997, 8, 5),
+			rrule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			until:  day(1998, 1, 1),
+			expect: []time.Time{day(1997, 8, 5), day(1997, 8, 10), day(1997, 8, 19), day(1997, 8, 24)},
+		},
+		{
+			name:   "WKST=SU with INTERVAL=2",
+			start:  day(1997, 8, 5),
+			rrule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			until:  day(1998, 1, 1),
+			expect: []time.Time{day(1997, 8, 5), day(1997, 8, 17), day(1997, 8, 19), day(1997, 8, 31)},
+		},
+		{
+			name:   "INTERVAL=2 monthly with BYDAY offsets",
+			start:  day(1997, 9, 7),
+			rrule:  "FREQ=MONTHLY;INTERVAL=2;COUNT=6;BYDAY=1SU,-1SU",
+			until:  day(1999, 1, 1),
+			expect: []time.Time{day(1997, 9, 7), day(1997, 9, 28), day(1997, 11, 2), day(1997, 11, 30), day(1998, 1, 4), day(1998, 1, 25)},
+		},
+		{
+			name:   "INTERVAL=18 monthly with BYMONTHDAY",
+			start:  day(1997, 9, 10),
+			rrule:  "FREQ=MONTHLY;INTERVAL=18;COUNT=4;BYMONTHDAY=10,11",
+			until:  day(2000, 1, 1),
+			expect: []time.Time{day(1997, 9, 10), day(1997, 9, 11), day(1999, 3, 10), day(1999, 3, 11)},
+		},
+		{
+			name:   "INTERVAL=2 yearly BYMONTH with BYDAY",
+			start:  day(1997, 1, 1),
+			rrule:  "FREQ=YEARLY;INTERVAL=2;COUNT=4;BYMONTH=1;BYDAY=SU",
+			until:  day(2000, 1, 1),
+			expect: []time.Time{day(1997, 1, 5), day(1997, 1, 12), day(1997, 1, 19), day(1997, 1, 26)},
+		},
+		{
+			name:   "Friday the 13th",
+			start:  day(1997, 9, 2),
+			rrule:  "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			until:  day(1999, 1, 1),
+			expect: []time.Time{day(1998, 2, 13), day(1998, 3, 13), day(1998, 11, 13)},
+		},
+		{
+			name:   "Invalid dates are skipped",
+			start:  day(2007, 1, 15),
+			rrule:  "FREQ=MONTHLY;BYMONTHDAY=15,30;COUNT=5",
+			until:  day(2008, 1, 1),
+			expect: []time.Time{day(2007, 1, 15), day(2007, 1, 30), day(2007, 2, 15), day(2007, 3, 15), day(2007, 3, 30)},
+		},
+		{
+			name:   "UNTIL in local time",
+			start:  day(1997, 9, 2),
+			rrule:  "FREQ=WEEKLY;INTERVAL=2;UNTIL=19971007T000000;WKST=SU;BYDAY=TU,TH",
+			until:  day(1998, 1, 1),
+			expect: []time.Time{day(1997, 9, 2), day(1997, 9, 4), day(1997, 9, 16), day(1997, 9, 18), day(1997, 9, 30), day(1997, 10, 2)},
+		},
+		{
+			name:   "Lower case and trailing separator",
+			start:  day(2024, 1, 1),
+			rrule:  "freq=weekly;interval=2;count=2;",
+			until:  day(2025, 1, 1),
+			expect: []time.Time{day(2024, 1, 1), day(2024, 1, 15)},
+		},
+		{
+			name:   "RSCALE=GREGORIAN, SKIP=OMIT and x-name parts",
+			start:  day(2024, 1, 31),
+			rrule:  "RSCALE=GREGORIAN;FREQ=MONTHLY;COUNT=3;SKIP=OMIT;X-APPLE-FOO=1",
+			until:  day(2025, 1, 1),
+			expect: []time.Time{day(2024, 1, 31), day(2024, 3, 31), day(2024, 5, 31)},
+		},
+		{
+			name:   "BYSECOND=60 is read as 59",
+			start:  at(2024, 1, 1, 0),
+			rrule:  "FREQ=MINUTELY;COUNT=2;BYSECOND=60",
+			until:  day(2024, 1, 2),
+			expect: []time.Time{at(2024, 1, 1, 0).Add(59 * time.Second), at(2024, 1, 1, 0).Add(time.Minute + 59*time.Second)},
+		},
+		{
+			name:   "DATE UNTIL includes that day",
+			start:  day(2024, 1, 1),
+			rrule:  "FREQ=DAILY;UNTIL=20240103",
+			until:  day(2025, 1, 1),
+			expect: []time.Time{day(2024, 1, 1), day(2024, 1, 2), day(2024, 1, 3)},
+		},
+	}
+
+	engine := NewEngineWithoutCache()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.expandRRule(tt.start, tt.rrule, tt.start, tt.until)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func TestEngine_SparseRuleInLargeRange(t *testing.T) {
+	// Monday of week 20 doesn't fall into the first 90 days of the range,
+	// which the default configuration expands first
+	start := time.Date(1997, 5, 12, 9, 0, 0, 0, time.UTC)
+	info := RecurrenceInfo{RRULE: "FREQ=YEARLY;BYWEEKNO=20;BYDAY=MO"}
+	engine := NewEngineWithConfig(DefaultEngineConfig)
+	defer engine.Close()
+
+	found, err := engine.HasOccurrenceInRange(start, start.Add(time.Hour), info,
+		time.Date(2000, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2001, 6, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = engine.HasOccurrenceInRange(start, start.Add(time.Hour), info,
+		time.Date(2000, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2001, 5, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,73 @@
+package recurrence
+
+import (
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// EvaluationStats describes how HasOccurrenceInRangeWithStats came to its
+// result, for debugging why an event does or doesn't show in a range.
+type EvaluationStats struct {
+	RRULE      string // The RRULE as expanded, after normalization
+	RangeStart time.Time
+	RangeEnd   time.Time
+	Result     bool
+	Err        error
+
+	MasterMatched bool // DTSTART's own instance overlaps the range
+	Generated     int  // RRULE instances generated around the range
+	RDATEs        int  // RDATEs checked
+	Excluded      int  // Instances overlapping the range but removed by EXDATE
+	// RangeLimited is set when the range was longer than LargeRangeThreshold,
+	// so only its first LargeRangeLimit was expanded before the rest
+	RangeLimited bool
+	// Truncated is set when the rest of a limited range had more than
+	// MaxExpansionOccurrences instances and later ones weren't checked
+	Truncated bool
+
+	// Bounded is set when the RRULE has COUNT or UNTIL
+	Bounded bool
+	// LastInstance is the last instance of a bounded RRULE starting before
+	// RangeEnd, zero when there is none. Together with Ended it tells
+	// whether COUNT or UNTIL stopped the event before the range.
+	LastInstance time.Time
+	// Ended is set when a bounded RRULE has no instance from RangeEnd on
+	Ended bool
+}
+
+// HasOccurrenceInRangeWithStats is HasOccurrenceInRange, also describing
+// the evaluation. It bypasses the cache so the stats are complete, and finds
+// where a bounded RRULE ends, which costs an expansion up to RangeEnd.
+func (e *Engine) HasOccurrenceInRangeWithStats(
+	masterStart, masterEnd time.Time,
+	recurrence RecurrenceInfo,
+	rangeStart, rangeEnd time.Time,
+) (bool, EvaluationStats, error) {
+	stats := EvaluationStats{RangeStart: rangeStart, RangeEnd: rangeEnd}
+	if recurrence.RRULE != "" {
+		stats.RRULE = normalizeRRule(recurrence.RRULE)
+	}
+	result, err := e.computeHasOccurrenceInRange(masterStart, masterEnd, recurrence, rangeStart, rangeEnd, &stats)
+	stats.Result, stats.Err = result, err
+	if err == nil && stats.RRULE != "" {
+		e.ruleBoundary(masterStart, &stats)
+	}
+	return result, stats, err
+}
+
+// ruleBoundary fills in Bounded, LastInstance and Ended.
+func (e *Engine) ruleBoundary(masterStart time.Time, stats *EvaluationStats) {
+	opt, err := rrule.StrToROptionInLocation(stats.RRULE, masterStart.Location())
+	if err != nil || (opt.Count == 0 && opt.Until.IsZero()) {
+		return
+	}
+	opt.Dtstart = masterStart
+	rule, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return
+	}
+	stats.Bounded = true
+	stats.LastInstance = rule.Before(stats.RangeEnd, false)
+	stats.Ended = rule.After(stats.RangeEnd, true).IsZero()
+}
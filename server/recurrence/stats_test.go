@@ -0,0 +1,54 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasOccurrenceInRangeWithStats(t *testing.T) {
+	engine := NewEngine()
+	defer engine.Close()
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	// COUNT ends the event before the range
+	found, stats, err := engine.HasOccurrenceInRangeWithStats(start, end,
+		RecurrenceInfo{RRULE: "freq=daily;count=3"}, day(10), day(11))
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, "FREQ=DAILY;COUNT=3", stats.RRULE)
+	assert.True(t, stats.Bounded)
+	assert.True(t, stats.Ended)
+	assert.Equal(t, time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC), stats.LastInstance)
+	assert.Equal(t, 0, stats.Generated)
+
+	// The only instance in range is excluded
+	found, stats, err = engine.HasOccurrenceInRangeWithStats(start, end,
+		RecurrenceInfo{RRULE: "FREQ=DAILY", EXDATE: []time.Time{start.AddDate(0, 0, 4)}}, day(5), day(6))
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 1, stats.Generated)
+	assert.Equal(t, 1, stats.Excluded)
+	assert.False(t, stats.Bounded)
+
+	// The master's own instance matches without expansion
+	found, stats, err = engine.HasOccurrenceInRangeWithStats(start, end,
+		RecurrenceInfo{RRULE: "FREQ=DAILY;UNTIL=20240301"}, day(1), day(2))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, stats.MasterMatched)
+	assert.True(t, stats.Bounded)
+	assert.False(t, stats.Ended)
+
+	// Large ranges are expanded in two steps
+	found, stats, err = engine.HasOccurrenceInRangeWithStats(start, end,
+		RecurrenceInfo{RRULE: "FREQ=YEARLY;BYWEEKNO=20;BYDAY=MO"}, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, stats.RangeLimited)
+	assert.False(t, stats.Truncated)
+}
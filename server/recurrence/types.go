@@ -9,7 +9,9 @@ type RecurrenceInfo struct {
 	RRULE        string      // The RRULE string (without "RRULE:" prefix)
 	RDATE        []time.Time // Additional recurrence dates
 	EXDATE       []time.Time // Exception dates (excluded occurrences)
+	EXRULE       []string    // Exception rules, deprecated by RFC 5545 but found in old data
 	RecurrenceID *time.Time  // For exception instances - which occurrence this overrides
+	AllDay       bool        // DTSTART is a DATE; occurrences span whole days
 }
 
 // TimeOccurrence represents a single occurrence of an event in time
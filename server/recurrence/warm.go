@@ -0,0 +1,73 @@
+package recurrence
+
+import (
+	"time"
+)
+
+// MaxWarmInstances caps the instances Warm keeps for one component. Rules
+// with more in the window aren't cached and are expanded per query.
+const MaxWarmInstances = 10000
+
+// warmedWindow is the cache entry Warm stores: every instance overlapping
+// [start, end), after exclusions.
+type warmedWindow struct {
+	start, end time.Time
+	instances  []TimeOccurrence
+}
+
+// warmOperation keys warmed windows in the cache. Their entries don't depend
+// on the range asked for, so they're stored with zero range times.
+const warmOperation = "Warm"
+
+// Warm expands the instances of a component over [windowStart, windowEnd)
+// into the cache, so HasOccurrenceInRange answers any range within the window
+// without expanding the rule again, whatever range a client asks for. It
+// reports whether the window was cached: it isn't without a cache or when the
+// window holds more than MaxWarmInstances instances.
+func (e *Engine) Warm(
+	masterStart, masterEnd time.Time,
+	recurrence RecurrenceInfo,
+	windowStart, windowEnd time.Time,
+) (bool, error) {
+	if e.cache == nil {
+		return false, nil
+	}
+	instances, err := e.ExpandInstances(masterStart, masterEnd, recurrence, windowStart, windowEnd,
+		ExpansionOptions{MaxOccurrences: MaxWarmInstances + 1})
+	if err != nil {
+		return false, err
+	}
+	if len(instances) > MaxWarmInstances {
+		return false, nil
+	}
+	e.cache.set(e.scope, warmOperation, masterStart, masterEnd, recurrence, time.Time{}, time.Time{},
+		&warmedWindow{start: windowStart, end: windowEnd, instances: instances})
+	return true, nil
+}
+
+// warmedOccurrence answers HasOccurrenceInRange from a window cached by Warm.
+// ok is false when there is none covering the range.
+func (e *Engine) warmedOccurrence(
+	masterStart, masterEnd time.Time,
+	recurrence RecurrenceInfo,
+	rangeStart, rangeEnd time.Time,
+) (found, ok bool) {
+	cached, exists := e.cache.get(e.scope, warmOperation, masterStart, masterEnd, recurrence, time.Time{}, time.Time{})
+	if !exists {
+		return false, false
+	}
+	window, isWindow := cached.(*warmedWindow)
+	if !isWindow || rangeStart.Before(window.start) || rangeEnd.After(window.end) {
+		return false, false
+	}
+	// An instance overlapping the range overlaps the window, so it's listed
+	for _, instance := range window.instances {
+		if !instance.Start.Before(rangeEnd) {
+			break
+		}
+		if overlaps(instance.Start, instance.End, rangeStart, rangeEnd) {
+			return true, true
+		}
+	}
+	return false, true
+}
@@ -0,0 +1,54 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Warm(t *testing.T) {
+	engine := NewEngine()
+	defer engine.Close()
+	uncached := NewEngineWithoutCache()
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	info := RecurrenceInfo{RRULE: "FREQ=WEEKLY;BYDAY=MO,WE", EXDATE: []time.Time{start.AddDate(0, 0, 7)}}
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	warmed, err := engine.Warm(start, end, info, day(1), day(31))
+	require.NoError(t, err)
+	assert.True(t, warmed)
+	assert.Equal(t, 1, engine.GetCacheStats().TotalEntries)
+
+	// Ranges within the window are answered from it, as expansion would
+	for _, r := range [][2]time.Time{
+		{day(2), day(3)}, // Tuesday
+		{day(3), day(4)}, // Wednesday
+		{day(8), day(9)}, // excluded Monday
+		{day(1).Add(9*time.Hour + 30*time.Minute), day(2)}, // inside the first instance
+		{day(29), day(31)},
+	} {
+		want, err := uncached.HasOccurrenceInRange(start, end, info, r[0], r[1])
+		require.NoError(t, err)
+		got, err := engine.HasOccurrenceInRange(start, end, info, r[0], r[1])
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "range %v", r)
+	}
+	assert.Equal(t, 1, engine.GetCacheStats().TotalEntries)
+
+	// Ranges outside it are expanded and cached as before
+	_, err = engine.HasOccurrenceInRange(start, end, info, day(30), day(31).AddDate(0, 0, 2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, engine.GetCacheStats().TotalEntries)
+
+	// Windows with too many instances aren't cached
+	warmed, err = engine.Warm(start, start.Add(time.Second), RecurrenceInfo{RRULE: "FREQ=SECONDLY"}, day(1), day(1).Add(13*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, warmed)
+
+	warmed, err = uncached.Warm(start, end, info, day(1), day(31))
+	require.NoError(t, err)
+	assert.False(t, warmed)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// busyOnly reports whether a request may only see when events happen, not
+// what they are: requests through busy-only share links, and those
+// h.BusyOnly selects.
+func (h *CaldavHandler) busyOnly(ctx *RequestContext) bool {
+	if ctx.Share != nil && ctx.Share.BusyOnly {
+		return true
+	}
+	return h.BusyOnly != nil && h.BusyOnly(ctx)
+}
+
+// view passes an object through the request's response transformer before
+// it's written to a GET, PROPFIND or REPORT response. A nil result means the
+// request may not see the object at all.
+func (h *CaldavHandler) view(obj *storage.CalendarObject) *storage.CalendarObject {
+	if h.objectView == nil || obj == nil {
+		return obj
+	}
+	return h.objectView(obj)
+}
+
+// busyView is the response transformer for busy-only requests. Events are
+// replaced by synthesized ones titled "Busy" with their times only, and
+// objects that don't block time are hidden.
+func busyView(obj *storage.CalendarObject) *storage.CalendarObject {
+	components, busy := busyComponents(obj.Component)
+	if !busy {
+		return nil
+	}
+	view := *obj
+	view.Component = components
+	return &view
+}
+
+// busyComponents redacts components with busyComponent, keeping time zones
+// and descending into VCALENDAR wrappers. It reports whether any event is left.
+func busyComponents(components []*ical.Component) ([]*ical.Component, bool) {
+	var out []*ical.Component
+	busy := false
+	for _, comp := range components {
+		if comp == nil {
+			continue
+		}
+		switch comp.Name {
+		case ical.CompTimezone:
+			out = append(out, comp)
+		case ical.CompCalendar:
+			children, ok := busyComponents(comp.Children)
+			if ok {
+				wrapper := &ical.Component{Name: comp.Name, Props: comp.Props, Children: children}
+				out = append(out, wrapper)
+				busy = true
+			}
+		default:
+			if b := busyComponent(comp); b != nil {
+				out = append(out, b)
+				busy = true
+			}
+		}
+	}
+	return out, busy
+}
+
+// busyProps are the event properties a busy-only view keeps.
+var busyProps = []string{
+	ical.PropUID, ical.PropDateTimeStamp, ical.PropDateTimeStart, ical.PropDateTimeEnd, ical.PropDuration,
+	ical.PropRecurrenceID, ical.PropRecurrenceRule, ical.PropRecurrenceDates, ical.PropExceptionDates,
+}
+
+// blocksTime reports whether comp is an event that makes its attendees busy:
+// neither transparent nor cancelled.
+func blocksTime(comp *ical.Component) bool {
+	if comp.Name != ical.CompEvent {
+		return false
+	}
+	if p := comp.Props.Get(ical.PropTransparency); p != nil && strings.EqualFold(p.Value, "TRANSPARENT") {
+		return false
+	}
+	if p := comp.Props.Get(ical.PropStatus); p != nil && strings.EqualFold(p.Value, "CANCELLED") {
+		return false
+	}
+	return true
+}
+
+// busyComponent returns a copy of an event with only its times, titled
+// "Busy", or nil for components that don't block time: other component
+// types, transparent events and cancelled ones.
+func busyComponent(comp *ical.Component) *ical.Component {
+	if !blocksTime(comp) {
+		return nil
+	}
+	busy := ical.NewComponent(ical.CompEvent)
+	for _, name := range busyProps {
+		if props, ok := comp.Props[name]; ok {
+			busy.Props[name] = props
+		}
+	}
+	busy.Props.SetText(ical.PropSummary, "Busy")
+	return busy
+}
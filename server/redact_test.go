@@ -0,0 +1,110 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusyView(t *testing.T) {
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	obj := storage.NewMockEvent("/caldav/alice/cal/work/a.ics", "a", "Salary review", start, start.Add(time.Hour))
+	event := obj.Component[0]
+	event.Props.SetText(ical.PropLocation, "Room 1")
+	event.Props.SetText(ical.PropRecurrenceRule, "FREQ=WEEKLY")
+	event.Children = append(event.Children, ical.NewComponent(ical.CompAlarm))
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, "Europe/Berlin")
+	obj.Component = append(obj.Component, tz)
+
+	view := busyView(&obj)
+	require.NotNil(t, view)
+	require.Len(t, view.Component, 2)
+	busy := view.Component[0]
+	assert.Equal(t, "Busy", busy.Props.Get(ical.PropSummary).Value)
+	assert.Equal(t, "a", busy.Props.Get(ical.PropUID).Value)
+	assert.Equal(t, "FREQ=WEEKLY", busy.Props.Get(ical.PropRecurrenceRule).Value)
+	assert.NotNil(t, busy.Props.Get(ical.PropDateTimeStart))
+	assert.Nil(t, busy.Props.Get(ical.PropLocation))
+	assert.Empty(t, busy.Children)
+	assert.Same(t, tz, view.Component[1])
+	// The stored object is left alone
+	assert.Equal(t, "Salary review", event.Props.Get(ical.PropSummary).Value)
+
+	// Wrapped objects keep their wrapper
+	wrapper := ical.NewCalendar()
+	wrapper.Children = []*ical.Component{event}
+	view = busyView(&storage.CalendarObject{Component: []*ical.Component{wrapper.Component}})
+	require.NotNil(t, view)
+	assert.Equal(t, "Busy", view.Component[0].Children[0].Props.Get(ical.PropSummary).Value)
+
+	// Objects that don't block time are hidden
+	todo := ical.NewComponent(ical.CompToDo)
+	assert.Nil(t, busyView(&storage.CalendarObject{Component: []*ical.Component{todo}}))
+	event.Props.SetText(ical.PropStatus, "CANCELLED")
+	assert.Nil(t, busyView(&obj))
+}
+
+func TestBusyOnlyRequests(t *testing.T) {
+	mockStorage := &storage.MockStorage{}
+	h := NewCaldavHandler("/caldav/", "Test Realm", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.Authenticators = []Authenticator{AuthenticatorFunc(func(r *http.Request) (string, error) { return "alice", nil })}
+	h.BusyOnly = func(ctx *RequestContext) bool { return ctx.Resource.CalendarID == "work" }
+
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	obj := storage.NewMockEvent("/caldav/alice/cal/work/a.ics", "a", "Salary review", start, start.Add(time.Hour))
+	obj.Component[0].Props.SetDateTime(ical.PropDateTimeStamp, start)
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&obj, nil)
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropProductID, "-//test//EN")
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/caldav/alice/cal/work", CalendarData: cal}, nil)
+	mockStorage.On("GetObjectsInCollection", "work").Return([]storage.CalendarObject{obj}, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/caldav/alice/cal/work/a.ics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "SUMMARY:Busy")
+	assert.NotContains(t, w.Body.String(), "Salary")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/caldav/alice/cal/work/a.ics", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/work", strings.NewReader(`<D:search xmlns:D="DAV:"><D:text>salary</D:text></D:search>`)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	query := func(filter string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/work", strings.NewReader(`<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><C:calendar-data/></D:prop>
+  <C:filter>`+filter+`</C:filter>
+</C:calendar-query>`)))
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+		return w.Body.String()
+	}
+	out := query(`<C:comp-filter name="VEVENT"/>`)
+	assert.Contains(t, out, "SUMMARY:Busy")
+	assert.NotContains(t, out, "Salary")
+	// Filters are matched against the redacted objects, so they can't probe details
+	out = query(`<C:comp-filter name="VEVENT"><C:prop-filter name="SUMMARY"><C:text-match>Salary</C:text-match></C:prop-filter></C:comp-filter>`)
+	assert.NotContains(t, out, "a.ics")
+	// Nor can negated ones: the answer is the same whatever the hidden summary
+	for _, text := range []string{"Salary", "Lunch"} {
+		out = query(`<C:comp-filter name="VEVENT"><C:prop-filter name="SUMMARY"><C:text-match negate-condition="yes">` + text + `</C:text-match></C:prop-filter></C:comp-filter>`)
+		assert.Contains(t, out, "a.ics", text)
+	}
+	// The storage doesn't get to filter on hidden fields either
+	mockStorage.AssertNotCalled(t, "GetObjectByFilter", "alice", "work", mock.Anything)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// DefaultReplayWindow is how long a ScheduleReplayGuard remembers deliveries
+// when its Window is unset.
+const DefaultReplayWindow = 24 * time.Hour
+
+var errNoScheduleUID = errors.New("scheduling message has no UID")
+
+// ScheduleMessageKey identifies an iTIP message (RFC 5546) delivered to one
+// recipient. Besides UID, SEQUENCE and recipient it holds the method and
+// DTSTAMP, so an attendee changing a reply without a new SEQUENCE isn't taken
+// for a retry; retries resend the same message and repeat both.
+type ScheduleMessageKey struct {
+	UID          string
+	RecurrenceID string // RECURRENCE-IDs of the instances the message covers, empty for the whole series
+	Sequence     int
+	Method       string
+	Stamp        string // DTSTAMP as sent
+	Recipient    string // calendar user address, lower-cased
+}
+
+// ReplayStore remembers delivered scheduling messages for a ScheduleReplayGuard.
+// MemoryReplayStore serves a single process; deployments running several
+// processes can back it with their database.
+type ReplayStore interface {
+	// Seen records key until expires and reports whether it was already
+	// recorded. It must test and record atomically, so concurrent retries of
+	// one message see a single first delivery.
+	Seen(key ScheduleMessageKey, expires time.Time) (bool, error)
+	// Forget drops key, after its delivery failed and may be retried.
+	Forget(key ScheduleMessageKey) error
+}
+
+// ScheduleReplayGuard ignores scheduling messages delivered twice, which
+// flaky mobile connections cause by retrying POSTs whose response got lost.
+// The scheduling outbox checks every recipient with
+// CaldavHandler.ScheduleReplayGuard before writing to its inbox and answers
+// duplicates with the same success as the first delivery, without
+// delivering again. Other scheduling endpoints can call it the same way.
+type ScheduleReplayGuard struct {
+	Store ReplayStore
+	// Window is how long deliveries are remembered, DefaultReplayWindow when zero
+	Window time.Duration
+	now    func() time.Time
+}
+
+// NewScheduleReplayGuard returns a guard remembering deliveries in store.
+func NewScheduleReplayGuard(store ReplayStore) *ScheduleReplayGuard {
+	return &ScheduleReplayGuard{Store: store, now: time.Now}
+}
+
+// Check records the delivery of msg to recipient and reports whether it was
+// delivered before. When the delivery then fails, pass the key to Forget so a
+// retry goes through.
+func (g *ScheduleReplayGuard) Check(msg *ical.Calendar, recipient string) (ScheduleMessageKey, bool, error) {
+	key, err := scheduleMessageKey(msg, recipient)
+	if err != nil {
+		return key, false, err
+	}
+	window := g.Window
+	if window <= 0 {
+		window = DefaultReplayWindow
+	}
+	now := time.Now
+	if g.now != nil {
+		now = g.now
+	}
+	duplicate, err := g.Store.Seen(key, now().Add(window))
+	return key, duplicate, err
+}
+
+// Forget drops a recorded delivery.
+func (g *ScheduleReplayGuard) Forget(key ScheduleMessageKey) error {
+	return g.Store.Forget(key)
+}
+
+// scheduleMessageKey builds the key of msg for recipient. The UID comes from
+// the first scheduling component; SEQUENCE and DTSTAMP are the highest of all
+// components, so a message carrying a series and its overrides has one key.
+func scheduleMessageKey(msg *ical.Calendar, recipient string) (ScheduleMessageKey, error) {
+	key := ScheduleMessageKey{Recipient: strings.ToLower(recipient)}
+	if p := msg.Props.Get(ical.PropMethod); p != nil {
+		key.Method = strings.ToUpper(p.Value)
+	}
+	var recurrenceIDs []string
+	master := false
+	for _, comp := range msg.Children {
+		if comp.Name == ical.CompTimezone {
+			continue
+		}
+		if p := comp.Props.Get(ical.PropUID); p != nil && key.UID == "" {
+			key.UID = p.Value
+		}
+		if p := comp.Props.Get(ical.PropSequence); p != nil {
+			if seq, err := strconv.Atoi(strings.TrimSpace(p.Value)); err == nil && seq > key.Sequence {
+				key.Sequence = seq
+			}
+		}
+		if p := comp.Props.Get(ical.PropDateTimeStamp); p != nil && p.Value > key.Stamp {
+			key.Stamp = p.Value
+		}
+		if p := comp.Props.Get(ical.PropRecurrenceID); p != nil {
+			recurrenceIDs = append(recurrenceIDs, p.Value)
+		} else {
+			master = true
+		}
+	}
+	if key.UID == "" {
+		return key, errNoScheduleUID
+	}
+	if !master {
+		slices.Sort(recurrenceIDs)
+		key.RecurrenceID = strings.Join(recurrenceIDs, ",")
+	}
+	return key, nil
+}
+
+// MemoryReplayStore is an in-process ReplayStore.
+type MemoryReplayStore struct {
+	mu        sync.Mutex
+	seen      map[ScheduleMessageKey]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryReplayStore returns an empty MemoryReplayStore.
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{seen: map[ScheduleMessageKey]time.Time{}, now: time.Now}
+}
+
+// Seen implements ReplayStore.
+func (s *MemoryReplayStore) Seen(key ScheduleMessageKey, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, exp := range s.seen {
+			if !now.Before(exp) {
+				delete(s.seen, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if exp, ok := s.seen[key]; ok && now.Before(exp) {
+		return true, nil
+	}
+	s.seen[key] = expires
+	return false, nil
+}
+
+// Forget implements ReplayStore.
+func (s *MemoryReplayStore) Forget(key ScheduleMessageKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.seen, key)
+	return nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newITIPMessage(method, uid string, sequence int, stamp string) *ical.Calendar {
+	msg := ical.NewCalendar()
+	msg.Props.SetText(ical.PropMethod, method)
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, uid)
+	event.Props.SetText(ical.PropSequence, strconv.Itoa(sequence))
+	event.Props.SetText(ical.PropDateTimeStamp, stamp)
+	msg.Children = append(msg.Children, event)
+	return msg
+}
+
+func TestScheduleReplayGuard(t *testing.T) {
+	now := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	store := NewMemoryReplayStore()
+	store.now = func() time.Time { return now }
+	guard := NewScheduleReplayGuard(store)
+	guard.now = store.now
+
+	msg := newITIPMessage("REQUEST", "meeting", 1, "20250101T090000Z")
+	_, dup, err := guard.Check(msg, "mailto:Bob@example.com")
+	require.NoError(t, err)
+	assert.False(t, dup)
+
+	// The same POST retried
+	_, dup, err = guard.Check(newITIPMessage("REQUEST", "meeting", 1, "20250101T090000Z"), "mailto:bob@example.com")
+	require.NoError(t, err)
+	assert.True(t, dup)
+
+	// Other recipients, a new SEQUENCE, or a changed reply are new messages
+	for name, tc := range map[string]struct {
+		msg       *ical.Calendar
+		recipient string
+	}{
+		"recipient": {msg, "mailto:carol@example.com"},
+		"sequence":  {newITIPMessage("REQUEST", "meeting", 2, "20250101T090000Z"), "mailto:bob@example.com"},
+		"reply":     {newITIPMessage("REPLY", "meeting", 1, "20250101T100000Z"), "mailto:bob@example.com"},
+	} {
+		_, dup, err := guard.Check(tc.msg, tc.recipient)
+		require.NoError(t, err, name)
+		assert.False(t, dup, name)
+	}
+
+	// Failed deliveries can be retried
+	key, _, err := guard.Check(newITIPMessage("CANCEL", "meeting", 3, "20250101T110000Z"), "mailto:bob@example.com")
+	require.NoError(t, err)
+	require.NoError(t, guard.Forget(key))
+	_, dup, err = guard.Check(newITIPMessage("CANCEL", "meeting", 3, "20250101T110000Z"), "mailto:bob@example.com")
+	require.NoError(t, err)
+	assert.False(t, dup)
+
+	// Deliveries are forgotten after the window
+	now = now.Add(DefaultReplayWindow + time.Minute)
+	_, dup, err = guard.Check(msg, "mailto:bob@example.com")
+	require.NoError(t, err)
+	assert.False(t, dup)
+}
+
+func TestScheduleMessageKey(t *testing.T) {
+	msg := newITIPMessage("request", "series", 4, "20250101T090000Z")
+	override := ical.NewComponent(ical.CompEvent)
+	override.Props.SetText(ical.PropUID, "series")
+	override.Props.SetText(ical.PropRecurrenceID, "20250108T090000Z")
+	override.Props.SetText(ical.PropSequence, "5")
+	msg.Children = append(msg.Children, override)
+
+	key, err := scheduleMessageKey(msg, "mailto:Bob@Example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ScheduleMessageKey{UID: "series", Sequence: 5, Method: "REQUEST", Stamp: "20250101T090000Z", Recipient: "mailto:bob@example.com"}, key)
+
+	// Messages about single instances name them
+	msg.Children = msg.Children[1:]
+	key, err = scheduleMessageKey(msg, "mailto:bob@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "20250108T090000Z", key.RecurrenceID)
+
+	_, err = scheduleMessageKey(ical.NewCalendar(), "mailto:bob@example.com")
+	assert.Error(t, err)
+}
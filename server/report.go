@@ -1,8 +1,10 @@
 package server
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/beevik/etree"
@@ -24,7 +26,7 @@ func (h *CaldavHandler) handleReport(w http.ResponseWriter, r *http.Request, ctx
 	if err != nil {
 		h.Logger.Error("failed to read report request body",
 			"error", err)
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeReadBody)
 		return
 	}
 	defer r.Body.Close()
@@ -34,7 +36,7 @@ func (h *CaldavHandler) handleReport(w http.ResponseWriter, r *http.Request, ctx
 	if err := doc.ReadFromBytes(body); err != nil {
 		h.Logger.Error("failed to parse report XML",
 			"error", err)
-		http.Error(w, "Error parsing XML request body", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
 		return
 	}
 
@@ -42,7 +44,7 @@ func (h *CaldavHandler) handleReport(w http.ResponseWriter, r *http.Request, ctx
 	root := doc.Root()
 	if root == nil {
 		h.Logger.Error("invalid XML: no root element")
-		http.Error(w, "Invalid XML: no root element", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
 		return
 	}
 
@@ -55,42 +57,66 @@ func (h *CaldavHandler) handleReport(w http.ResponseWriter, r *http.Request, ctx
 	h.Logger.Debug("report type identified",
 		"tag", tagName)
 
+	// Calendar data only comes as iCalendar 2.0
+	if contentType, version := cq.ParseCalendarDataType(string(body)); !supportsCalendarData(contentType, version) {
+		h.Logger.Warn("unsupported calendar data requested",
+			"content_type", contentType,
+			"version", version)
+		h.writePrecondition(w, r, http.StatusForbidden, "cal:supported-calendar-data",
+			"calendar data is only available as "+calendarDataType+" version "+icalVersion)
+		return
+	}
+
 	// Clone the request for handlers to re-read the body
 	reqClone := r.Clone(r.Context())
 	reqClone.Body = io.NopCloser(strings.NewReader(string(body)))
 
+	if !h.reportEnabled(tagName) {
+		h.Logger.Warn("report disabled by features",
+			"tag", tagName)
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedReport)
+		return
+	}
+
 	// Route to appropriate handler based on report type
+	ctx.report = tagName
 	switch tagName {
 	case "calendar-multiget":
 		h.handleCalendarMultiget(w, reqClone, ctx)
 	case "calendar-query":
 		h.handleCalendarQuery(w, reqClone, ctx)
-	case "freebusy-query":
+	case "free-busy-query":
 		h.handleFreebusyQuery(w, reqClone, ctx)
 	case "schedule-query":
 		h.handleScheduleQuery(w, reqClone, ctx)
 	case "availability-query":
 		h.handleAvailabilityQuery(w, reqClone, ctx)
+	case "search":
+		h.handleSearch(w, reqClone, ctx)
+	case "sync-collection":
+		h.handleSyncCollection(w, reqClone, ctx)
+	case "principal-property-search":
+		h.handlePrincipalPropertySearch(w, reqClone, ctx)
+	case "principal-search-property-set":
+		h.handlePrincipalSearchPropertySet(w, reqClone, ctx)
 	default:
 		h.Logger.Warn("unsupported report type",
 			"tag", tagName)
-		http.Error(w, "Unsupported report type", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedReport)
 	}
 }
 
-func (h *CaldavHandler) handleCalendarMultiget(w http.ResponseWriter, r *http.Request, _ *RequestContext) {
+func (h *CaldavHandler) handleCalendarMultiget(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
 	// get resources and requested properties
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		h.Logger.Error("failed to read request body",
 			"error", err)
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeReadBody)
 		return
 	}
 
 	bodyStr := string(bodyBytes)
-	h.Logger.Info("calendar-multiget request body",
-		"body", bodyStr)
 
 	req, resourceLinks := cmg.ParseRequest(bodyStr)
 
@@ -104,11 +130,45 @@ func (h *CaldavHandler) handleCalendarMultiget(w http.ResponseWriter, r *http.Re
 			"link", resourceLink)
 		resource, err := h.URLConverter.ParsePath(resourceLink)
 		if err != nil {
-			h.Logger.Error("error parsing path",
-				"path", resourceLink,
+			h.Logger.Warn("multiget href not parsable",
+				"link", resourceLink,
 				"error", err)
-			http.Error(w, "Error retrieving resource", http.StatusInternalServerError)
+			docs = append(docs, propfind.EncodeStatusResponse(resourceLink, http.StatusNotFound))
+			continue
+		}
+		if !h.CrossCollectionMultiget && !inScope(ctx.Resource, resource) {
+			h.Logger.Warn("multiget href outside the request's collection",
+				"auth_user", ctx.AuthUser,
+				"link", resourceLink)
+			docs = append(docs, propfind.EncodeStatusResponse(resourceLink, http.StatusForbidden))
+			continue
+		}
+		if ctx.Share != nil && !shareAllows(ctx.Share, r.Method, resource) {
+			h.Logger.Warn("multiget href outside share link scope",
+				"link", resourceLink)
+			docs = append(docs, propfind.EncodeStatusResponse(resourceLink, h.deniedStatus()))
+			continue
+		}
+
+		// Hrefs may name any user's resources; check them like request URLs
+		privs, err := h.requestPrivileges(ctx, resource.UserID)
+		if storage.IsTransient(err) {
+			h.writeStorageError(w, r, err, CodeStorage)
 			return
+		} else if err != nil {
+			h.Logger.Error("failed to check access",
+				"auth_user", ctx.AuthUser,
+				"user_id", resource.UserID,
+				"error", err)
+			docs = append(docs, propfind.EncodeStatusResponse(resourceLink, storageErrorStatus(err)))
+			continue
+		}
+		if !privs.Has(storage.PrivilegeRead) {
+			h.Logger.Warn("multiget href not readable",
+				"auth_user", ctx.AuthUser,
+				"link", resourceLink)
+			docs = append(docs, propfind.EncodeStatusResponse(resourceLink, h.deniedStatus()))
+			continue
 		}
 
 		var doc *etree.Document
@@ -116,24 +176,29 @@ func (h *CaldavHandler) handleCalendarMultiget(w http.ResponseWriter, r *http.Re
 		case storage.ResourceObject:
 			doc, err = h.handlePropfindObject(req, resource)
 		case storage.ResourceCollection:
-			doc, err = h.handlePropfindCollection(req, resource)
+			doc, err = h.handlePropfindCollection(req, resource, ctx.AuthUser)
 		case storage.ResourceHomeSet:
 			doc, err = h.handlePropfindHomeSet(req, resource)
 		case storage.ResourcePrincipal:
 			doc, err = h.handlePropfindPrincipal(req, resource)
 		default:
-			h.Logger.Error("unsupported resource type",
-				"type", resource.ResourceType)
-			http.Error(w, "Unsupported resource type", http.StatusBadRequest)
-			return
+			err = storage.ErrNotFound
 		}
 
-		if err != nil {
-			h.Logger.Error("error handling propfind",
-				"type", resource.ResourceType,
-				"error", err)
-			http.Error(w, "Error retrieving resource", http.StatusInternalServerError)
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, propfind.ErrNotFound) {
+			docs = append(docs, propfind.EncodeStatusResponse(resourceLink, http.StatusNotFound))
+			continue
+		} else if storage.IsTransient(err) {
+			// Fail the whole report so it can be retried
+			h.writeStorageError(w, r, err, CodeStorage)
 			return
+		} else if err != nil {
+			// One broken href doesn't spoil the others
+			h.Logger.Error("failed to answer multiget href",
+				"link", resourceLink,
+				"error", err)
+			docs = append(docs, propfind.EncodeStatusResponse(resourceLink, storageErrorStatus(err)))
+			continue
 		}
 		docs = append(docs, doc)
 	}
@@ -142,7 +207,7 @@ func (h *CaldavHandler) handleCalendarMultiget(w http.ResponseWriter, r *http.Re
 	if err != nil {
 		h.Logger.Error("error merging responses",
 			"error", err)
-		http.Error(w, "Error merging responses", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
 		return
 	}
 
@@ -151,101 +216,128 @@ func (h *CaldavHandler) handleCalendarMultiget(w http.ResponseWriter, r *http.Re
 	w.WriteHeader(http.StatusMultiStatus) // 207 Multi-Status
 
 	// Serialize and write the XML document
-	xmlOutput, err := mergedDoc.WriteToString()
+	xmlOutput, err := h.writeXML(mergedDoc)
 	if err != nil {
 		h.Logger.Error("failed to serialize XML response",
 			"error", err)
-		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
 		return
 	}
+	h.recordResponseSize(r, mergedDoc, xmlOutput)
 
 	h.Logger.Info("sending calendar-multiget response",
 		"response_size", len(xmlOutput))
 	w.Write([]byte(xmlOutput))
 }
 
+// inScope reports whether res is target or lies below it, as the hrefs of a
+// calendar-multiget must (RFC 4791 section 7.9).
+func inScope(target, res Resource) bool {
+	switch target.ResourceType {
+	case storage.ResourceServiceRoot:
+		return true
+	case storage.ResourcePrincipal, storage.ResourceHomeSet:
+		return res.UserID == target.UserID && res.ResourceType >= target.ResourceType && res.ResourceType != storage.ResourceServiceRoot
+	case storage.ResourceCollection:
+		return res.UserID == target.UserID && res.CalendarID == target.CalendarID &&
+			(res.ResourceType == storage.ResourceCollection || res.ResourceType == storage.ResourceObject)
+	case storage.ResourceObject:
+		return res.ResourceType == storage.ResourceObject && res.UserID == target.UserID &&
+			res.CalendarID == target.CalendarID && res.ObjectID == target.ObjectID
+	}
+	return false
+}
+
 func (h *CaldavHandler) handleCalendarQuery(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		h.Logger.Error("failed to read request body",
 			"error", err)
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeReadBody)
 		return
 	}
 	bodyStr := string(bodyBytes)
-	h.Logger.Info("calendar-query request body",
-		"body", bodyStr)
 
 	req, filter, err := cq.ParseRequest(bodyStr)
 	if err != nil {
 		h.Logger.Error("error parsing request",
 			"error", err)
-		http.Error(w, "Error parsing request", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
 		return
 	}
+	queryTZID := cq.ParseTimezoneID(bodyStr)
+	// Stop expanding recurring events when the client goes away
+	filter.SetContext(r.Context())
 
 	docs := []*etree.Document{}
 	switch ctx.Resource.ResourceType {
 	case storage.ResourceObject:
 		object, err := h.Storage.GetObject(ctx.Resource.UserID, ctx.Resource.CalendarID, ctx.Resource.ObjectID)
 		if err != nil {
-			h.Logger.Error("error getting object",
-				"error", err)
-			http.Error(w, "Error retrieving object", http.StatusInternalServerError)
+			h.writeStorageError(w, r, err, CodeStorage)
 			return
 		}
-		if !filter.Validate(object) {
+		h.setFloatingLocation(filter, queryTZID, ctx.Resource.UserID, ctx.Resource.CalendarID)
+		if !h.viewMatches(filter, object) {
 			h.Logger.Warn("object does not match filter",
 				"object_id", ctx.Resource.ObjectID)
-			http.Error(w, "Object does not match filter", http.StatusNotFound)
+			h.writeError(w, r, http.StatusNotFound, CodeFilterMismatch)
 			return
 		}
-		doc, err := h.handlePropfindObjectWithObject(req, ctx.Resource, *object)
+		res := ctx.Resource
+		if res.URI == "" {
+			if res.URI, err = h.URLConverter.EncodePath(res); err != nil {
+				h.writeStorageError(w, r, err, CodeStorage)
+				return
+			}
+		}
+		doc, err := h.handlePropfindObjectWithObject(req, res, *object)
 		if err != nil {
-			h.Logger.Error("error handling propfind for object",
-				"error", err)
-			http.Error(w, "Error retrieving object", http.StatusInternalServerError)
+			h.writeStorageError(w, r, err, CodeStorage)
 			return
 		}
 		docs = append(docs, doc)
 	case storage.ResourceCollection:
-		objects, err := h.Storage.GetObjectByFilter(ctx.Resource.UserID, ctx.Resource.CalendarID, filter)
+		docs, err = h.queryCollection(req, filter, queryTZID, ctx.Resource.UserID, ctx.Resource.CalendarID)
 		if err != nil {
-			h.Logger.Error("error getting objects by filter",
-				"error", err)
-			http.Error(w, "Error retrieving objects", http.StatusInternalServerError)
+			h.writeStorageError(w, r, err, CodeStorage)
 			return
 		}
-		for _, object := range objects {
-			// Build an object resource to ensure object resolvers are used instead of collection ones
-			objRes := Resource{
-				UserID:       ctx.Resource.UserID,
-				CalendarID:   ctx.Resource.CalendarID,
-				ResourceType: storage.ResourceObject,
-			}
-			// If storage provided a path, use it for href; otherwise, try to encode
-			if object.Path != "" {
-				objRes.URI = object.Path
-			} else {
-				if path, err := h.URLConverter.EncodePath(objRes); err == nil {
-					objRes.URI = path
-				}
-			}
-
-			doc, err := h.handlePropfindObjectWithObject(req, objRes, object)
-			if err != nil {
-				h.Logger.Error("error handling propfind for object",
+	case storage.ResourceHomeSet:
+		// Search every calendar of the home; responses are grouped per calendar
+		calendars, err := h.Storage.GetUserCalendars(ctx.Resource.UserID)
+		if err != nil {
+			h.writeStorageError(w, r, err, CodeStorage)
+			return
+		}
+		sort.Slice(calendars, func(i, j int) bool { return calendars[i].Path < calendars[j].Path })
+		for _, cal := range calendars {
+			calRes, err := h.URLConverter.ParsePath(cal.Path)
+			if err != nil || calRes.ResourceType != storage.ResourceCollection {
+				h.Logger.Warn("skipping calendar with unparsable path",
+					"path", cal.Path,
 					"error", err)
-				http.Error(w, "Error retrieving object", http.StatusInternalServerError)
+				continue
+			}
+			calDocs, err := h.queryCollection(req, filter, queryTZID, calRes.UserID, calRes.CalendarID)
+			if errors.Is(err, storage.ErrPermissionDenied) {
+				// Calendars the user may not read are left out of the results
+				continue
+			} else if storage.IsTransient(err) {
+				h.writeStorageError(w, r, err, CodeStorage)
 				return
+			} else if err != nil {
+				// Answer for the failed calendar and go on with the others
+				docs = append(docs, propfind.EncodeStatusResponse(cal.Path, storageErrorStatus(err)))
+				continue
 			}
-			docs = append(docs, doc)
+			docs = append(docs, calDocs...)
 		}
 	default:
-		// bad request, only collection & object
+		// bad request, only home set, collection & object
 		h.Logger.Error("unsupported resource type for calendar-query",
 			"type", ctx.Resource.ResourceType)
-		http.Error(w, "Unsupported resource type for calendar-query", http.StatusBadRequest)
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedResource)
 		return
 	}
 
@@ -253,31 +345,122 @@ func (h *CaldavHandler) handleCalendarQuery(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		h.Logger.Error("error merging responses",
 			"error", err)
-		http.Error(w, "Error merging responses", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
 	w.WriteHeader(http.StatusMultiStatus) // 207 Multi-Status
 
-	xmlOutput, err := mergedDoc.WriteToString()
+	xmlOutput, err := h.writeXML(mergedDoc)
 	if err != nil {
 		h.Logger.Error("failed to serialize XML response",
 			"error", err)
-		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
 		return
 	}
+	h.recordResponseSize(r, mergedDoc, xmlOutput)
 
 	h.Logger.Info("sending calendar-query response",
 		"response_size", len(xmlOutput))
 	w.Write([]byte(xmlOutput))
 }
 
-func (h *CaldavHandler) handleFreebusyQuery(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
-}
-
 func (h *CaldavHandler) handleScheduleQuery(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
 }
 
 func (h *CaldavHandler) handleAvailabilityQuery(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
 }
+
+// viewMatches reports whether filter matches obj as the request sees it,
+// through the response transformer if any. Matching the stored object as well
+// would let negated filters probe what the view hides.
+func (h *CaldavHandler) viewMatches(filter *storage.Filter, obj *storage.CalendarObject) bool {
+	view := h.view(obj)
+	return view != nil && filter.Validate(view)
+}
+
+// objectsByFilter returns the objects of a calendar matching filter. Backends
+// implementing storage.OccurrenceIndex are asked for the objects in the
+// filter's time-range, which are then matched here; others get the whole
+// filter through GetObjectByFilter. Requests with a response transformer get
+// the whole calendar matched here, so the storage can't decide on hidden
+// fields either.
+func (h *CaldavHandler) objectsByFilter(userID, calendarID string, filter *storage.Filter) ([]storage.CalendarObject, error) {
+	if h.objectView != nil {
+		all, err := h.Storage.GetObjectsInCollection(calendarID)
+		if err != nil {
+			return nil, err
+		}
+		objects := all[:0]
+		for i := range all {
+			if h.viewMatches(filter, &all[i]) {
+				objects = append(objects, all[i])
+			}
+		}
+		return objects, nil
+	}
+	index, ok := storage.As[storage.OccurrenceIndex](h.Storage)
+	tr := filter.RequiredTimeRange()
+	if !ok || tr == nil {
+		return h.Storage.GetObjectByFilter(userID, calendarID, filter)
+	}
+	candidates, err := index.GetObjectsInTimeRange(userID, calendarID, tr.Start, tr.End)
+	if err != nil {
+		return nil, err
+	}
+	objects := candidates[:0]
+	for i := range candidates {
+		if filter.Validate(&candidates[i]) {
+			objects = append(objects, candidates[i])
+		}
+	}
+	h.Logger.Debug("matched indexed objects",
+		"calendar_id", calendarID,
+		"candidates", len(candidates),
+		"matches", len(objects))
+	return objects, nil
+}
+
+// queryCollection runs a calendar-query filter against one calendar and returns
+// a response document per matching object. tzid is the time zone the query
+// names for floating times, if any.
+func (h *CaldavHandler) queryCollection(req propfind.ResponseMap, filter *storage.Filter, tzid, userID, calendarID string) ([]*etree.Document, error) {
+	h.setFloatingLocation(filter, tzid, userID, calendarID)
+	objects, err := h.objectsByFilter(userID, calendarID, filter)
+	if err != nil {
+		h.Logger.Error("error getting objects by filter",
+			"user_id", userID,
+			"calendar_id", calendarID,
+			"error", err)
+		return nil, err
+	}
+
+	var docs []*etree.Document
+	for _, object := range objects {
+		// Build an object resource to ensure object resolvers are used instead of collection ones
+		objRes := Resource{
+			UserID:       userID,
+			CalendarID:   calendarID,
+			ResourceType: storage.ResourceObject,
+		}
+		// If storage provided a path, use it for href, encoded like the hrefs
+		// of other reports; otherwise, try to encode
+		if object.Path != "" {
+			objRes.URI = h.canonicalPath(object.Path)
+		} else {
+			if path, err := h.URLConverter.EncodePath(objRes); err == nil {
+				objRes.URI = path
+			}
+		}
+
+		doc, err := h.handlePropfindObjectWithObject(req, objRes, object)
+		if err != nil {
+			h.Logger.Error("error handling propfind for object",
+				"error", err)
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
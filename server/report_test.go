@@ -1,6 +1,8 @@
 package server
 
 import (
+	"errors"
+	"io"
 	"log"
 	"log/slog"
 	"net/http"
@@ -20,7 +22,7 @@ func TestHandleCalendarMultiget(t *testing.T) {
 	// Add log capturing
 	var logOutput strings.Builder
 	log.SetOutput(&logOutput)
-	defer log.SetOutput(nil)
+	defer log.SetOutput(os.Stdout)
 
 	// Setup
 	mockURLConverter := new(MockURLConverter)
@@ -43,6 +45,7 @@ func TestHandleCalendarMultiget(t *testing.T) {
 	}
 	ctx := &RequestContext{
 		Resource: ctxResource,
+		AuthUser: "user1",
 	}
 
 	t.Run("Multiget Object and Collection", func(t *testing.T) {
@@ -142,6 +145,9 @@ func TestHandleCalendarMultiget(t *testing.T) {
 		mockStorage = new(storage.MockStorage)
 		h.URLConverter = mockURLConverter
 		h.Storage = mockStorage
+		// The hrefs lie outside the calendar the report is sent to
+		h.CrossCollectionMultiget = true
+		defer func() { h.CrossCollectionMultiget = false }()
 
 		principalPath := "/principals/user1/"
 		homeSetPath := "/calendars/user1/"
@@ -174,7 +180,7 @@ func TestHandleCalendarMultiget(t *testing.T) {
 <C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
   <D:prop>
     <D:displayname/>
-    <D:principal-url/>
+    <D:principal-URL/>
   </D:prop>
   <D:href>` + principalPath + `</D:href>
   <D:href>` + homeSetPath + `</D:href>
@@ -207,7 +213,7 @@ func TestHandleCalendarMultiget(t *testing.T) {
 		// Check for requested properties
 		assert.Contains(t, respBody, "<d:displayname>User One</d:displayname>")                               // From principal
 		assert.Contains(t, respBody, "<d:displayname>Calendar Home</d:displayname>")                          // From home set
-		assert.Contains(t, respBody, "<d:principal-url><d:href>"+principalPath+"</d:href></d:principal-url>") // Both should return this
+		assert.Contains(t, respBody, "<d:principal-URL><d:href>"+principalPath+"</d:href></d:principal-URL>") // Both should return this
 
 		// Verify mocks
 		mockURLConverter.AssertExpectations(t)
@@ -245,14 +251,9 @@ func TestHandleCalendarMultiget(t *testing.T) {
 		// Check logs to debug the issue
 		t.Logf("Log output: %s", logOutput.String())
 
-		// If the test is failing, you can temporarily use this to pass
-		if rr.Code != http.StatusInternalServerError {
-			t.Logf("Expected status 500, got %d", rr.Code)
-			t.Logf("Response body: %s", rr.Body.String())
-		}
-
-		// Assertions
-		assert.Equal(t, http.StatusInternalServerError, rr.Code) // Expecting internal server error as ParsePath failed
+		// A href that doesn't parse only fails itself
+		assert.Equal(t, http.StatusMultiStatus, rr.Code)
+		assert.Contains(t, rr.Body.String(), "<d:href>"+invalidPath+"</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
 
 		// Verify mocks
 		mockURLConverter.AssertExpectations(t)
@@ -427,7 +428,7 @@ func TestHandleCalendarQuery(t *testing.T) {
 			},
 		},
 		{
-			name: "unsupported resource type",
+			name: "home set query searches every readable calendar",
 			ctxResource: Resource{
 				UserID:       "user1",
 				ResourceType: storage.ResourceHomeSet,
@@ -443,13 +444,64 @@ func TestHandleCalendarQuery(t *testing.T) {
       <C:comp-filter name="VEVENT"/>
     </C:comp-filter>
   </C:filter>
+</C:calendar-query>`,
+			setupMocks: func() {
+				mockStorage.On("GetUserCalendars", "user1").Return([]storage.Calendar{
+					{Path: "/calendars/user1/work/"},
+					{Path: "/calendars/user1/private/"},
+					{Path: "/calendars/user1/home/"},
+				}, nil).Once()
+				for _, id := range []string{"work", "private", "home"} {
+					mockURL.On("ParsePath", "/calendars/user1/"+id+"/").Return(Resource{
+						UserID: "user1", CalendarID: id, ResourceType: storage.ResourceCollection,
+					}, nil).Once()
+				}
+				event := func(id string) storage.CalendarObject {
+					return storage.CalendarObject{
+						Path:      "/calendars/user1/" + id + "/event.ics",
+						ETag:      id + "-etag",
+						Component: []*ical.Component{{Name: ical.CompEvent, Props: make(ical.Props)}},
+					}
+				}
+				for _, id := range []string{"work", "home"} {
+					res := Resource{UserID: "user1", CalendarID: id, ObjectID: "event.ics", ResourceType: storage.ResourceObject}
+					mockURL.On("ParsePath", "/calendars/user1/"+id+"/event.ics").Return(res, nil).Once()
+					mockURL.On("EncodePath", res).Return("/calendars/user1/"+id+"/event.ics", nil).Once()
+				}
+				mockStorage.On("GetObjectByFilter", "user1", "home", mock.Anything).Return([]storage.CalendarObject{event("home")}, nil).Once()
+				mockStorage.On("GetObjectByFilter", "user1", "private", mock.Anything).Return([]storage.CalendarObject(nil), storage.ErrPermissionDenied).Once()
+				mockStorage.On("GetObjectByFilter", "user1", "work", mock.Anything).Return([]storage.CalendarObject{event("work")}, nil).Once()
+			},
+			expectStatus: http.StatusMultiStatus,
+			expectResponseContains: []string{
+				// grouped per calendar, in path order
+				"<d:href>/calendars/user1/home/event.ics</d:href><d:propstat><d:prop><d:getetag>home-etag</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/calendars/user1/work/event.ics</d:href>",
+			},
+		},
+		{
+			name: "unsupported resource type",
+			ctxResource: Resource{
+				UserID:       "user1",
+				ResourceType: storage.ResourcePrincipal,
+				URI:          "/calendars/user1/",
+			},
+			requestBody: `<?xml version="1.0" encoding="UTF-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT"/>
+    </C:comp-filter>
+  </C:filter>
 </C:calendar-query>`,
 			setupMocks: func() {
 				// No mocks needed, should return error directly
 			},
 			expectStatus: http.StatusBadRequest,
 			expectResponseContains: []string{
-				"Unsupported resource type for calendar-query",
+				"Unsupported resource type for this request",
 			},
 		},
 	}
@@ -607,6 +659,7 @@ func TestHandleCalendarMultigetMultiComponent(t *testing.T) {
 				CalendarID:   calendarID,
 				ResourceType: storage.ResourceCollection,
 			},
+			AuthUser: userID,
 		}
 
 		// Call the handler
@@ -722,6 +775,7 @@ func TestHandleCalendarMultigetMultiComponent(t *testing.T) {
 				CalendarID:   calendarID,
 				ResourceType: storage.ResourceCollection,
 			},
+			AuthUser: userID,
 		}
 
 		// Call the handler
@@ -754,3 +808,160 @@ func TestHandleCalendarMultigetMultiComponent(t *testing.T) {
 		mockStorage.AssertExpectations(t)
 	})
 }
+
+func TestReportCalendarDataVersion(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{}, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, attrs := range []string{
+		`content-type="text/calendar" version="1.0"`,
+		`content-type="application/calendar+json" version="2.0"`,
+	} {
+		body := `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` +
+			`<D:prop><C:calendar-data ` + attrs + `/></D:prop><D:href>/caldav/alice/cal/work/a.ics</D:href></C:calendar-multiget>`
+		w := serveAlice(h, "REPORT", "/caldav/alice/cal/work", body, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, attrs)
+		assert.Contains(t, w.Body.String(), "supported-calendar-data", attrs)
+	}
+	mockStorage.AssertNotCalled(t, "GetObject", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMultigetHrefScope(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetObject", "alice", "work", "gone.ics").Return(nil, storage.ErrNotFound)
+	mockStorage.On("GetCalendar", "alice", "home").Return(&storage.Calendar{}, nil).Maybe()
+	body := `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop>` +
+		`<D:href>/caldav/alice/cal/work/gone.ics</D:href>` +
+		`<D:href>/caldav/alice/cal/home/a.ics</D:href>` +
+		`<D:href>/elsewhere/a.ics</D:href></C:calendar-multiget>`
+
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/gone.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/home/a.ics</d:href><d:status>HTTP/1.1 403 Forbidden</d:status>")
+	assert.Contains(t, w.Body.String(), "<d:href>/elsewhere/a.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	mockStorage.AssertNotCalled(t, "GetObject", "alice", "home", mock.Anything)
+
+	// Allowed, other calendars are read like any href
+	h.CrossCollectionMultiget = true
+	mockStorage.On("GetObject", "alice", "home", "a.ics").Return(nil, storage.ErrNotFound)
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1"})
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/home/a.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	mockStorage.AssertCalled(t, "GetObject", "alice", "home", "a.ics")
+}
+
+func TestInScope(t *testing.T) {
+	calendar := Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}
+	home := Resource{UserID: "alice", ResourceType: storage.ResourceHomeSet}
+	object := Resource{UserID: "alice", CalendarID: "work", ObjectID: "a.ics", ResourceType: storage.ResourceObject}
+	assert.True(t, inScope(calendar, object))
+	assert.True(t, inScope(calendar, calendar))
+	assert.True(t, inScope(home, object))
+	assert.True(t, inScope(object, object))
+	assert.True(t, inScope(Resource{ResourceType: storage.ResourceServiceRoot}, object))
+	assert.False(t, inScope(calendar, home))
+	assert.False(t, inScope(calendar, Resource{UserID: "alice", CalendarID: "home", ObjectID: "a.ics", ResourceType: storage.ResourceObject}))
+	assert.False(t, inScope(home, Resource{UserID: "bob", CalendarID: "work", ObjectID: "a.ics", ResourceType: storage.ResourceObject}))
+	assert.False(t, inScope(object, Resource{UserID: "alice", CalendarID: "work", ObjectID: "b.ics", ResourceType: storage.ResourceObject}))
+}
+
+func TestReportPartialFailure(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	mockStorage.On("GetCalendar", "alice", mock.Anything).Return(&storage.Calendar{}, nil).Maybe()
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	event := func(path string) *storage.CalendarObject {
+		return &storage.CalendarObject{
+			Path:      path,
+			ETag:      "etag",
+			Component: []*ical.Component{{Name: ical.CompEvent, Props: make(ical.Props)}},
+		}
+	}
+
+	t.Run("multiget", func(t *testing.T) {
+		mockStorage.On("GetObject", "alice", "work", "a.ics").Return(event("/caldav/alice/cal/work/a.ics"), nil)
+		mockStorage.On("GetObject", "alice", "work", "b.ics").Return(nil, errors.New("disk on fire"))
+		mockStorage.On("GetObject", "alice", "work", "c.ics").Return(nil, storage.ErrNotFound)
+		mockStorage.On("GetObject", "alice", "work", "d.ics").Return(nil, storage.ErrStorageUnavailable)
+		body := `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop>` +
+			`<D:href>/caldav/alice/cal/work/a.ics</D:href>` +
+			`<D:href>/caldav/alice/cal/work/b.ics</D:href>` +
+			`<D:href>/caldav/alice/cal/work/c.ics</D:href>` +
+			`<D:href>/caldav/alice/cal/work/d.ics</D:href></C:calendar-multiget>`
+
+		w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1"})
+		assert.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/a.ics</d:href><d:propstat><d:prop><d:getetag>etag</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status>")
+		assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/b.ics</d:href><d:status>HTTP/1.1 500 Internal Server Error</d:status>")
+		assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/c.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+		assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/d.ics</d:href><d:status>HTTP/1.1 503 Service Unavailable</d:status>")
+
+		// Transient errors fail the whole report, so it can be retried
+		mockStorage.On("GetObject", "alice", "work", "e.ics").Return(nil, storage.Transient(errors.New("deadlock")))
+		body = `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop>` +
+			`<D:href>/caldav/alice/cal/work/a.ics</D:href>` +
+			`<D:href>/caldav/alice/cal/work/e.ics</D:href></C:calendar-multiget>`
+		w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1"})
+		assert.NotEqual(t, http.StatusMultiStatus, w.Code)
+	})
+
+	t.Run("calendar-query on home set", func(t *testing.T) {
+		mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{
+			{Path: "/caldav/alice/cal/home/"},
+			{Path: "/caldav/alice/cal/work/"},
+		}, nil)
+		mockStorage.On("GetObjectByFilter", "alice", "home", mock.Anything).Return([]storage.CalendarObject(nil), errors.New("index corrupt"))
+		mockStorage.On("GetObjectByFilter", "alice", "work", mock.Anything).Return([]storage.CalendarObject{*event("/caldav/alice/cal/work/a.ics")}, nil)
+		body := `<?xml version="1.0"?><C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop>` +
+			`<C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"/></C:comp-filter></C:filter></C:calendar-query>`
+
+		w := serveAlice(h, "REPORT", "/caldav/alice/cal/", body, map[string]string{"Depth": "1"})
+		assert.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/home/</d:href><d:status>HTTP/1.1 500 Internal Server Error</d:status>")
+		assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/a.ics</d:href><d:propstat><d:prop><d:getetag>etag</d:getetag>")
+	})
+}
+
+func TestReportOnObject(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "a")
+	event.Props.SetText(ical.PropSummary, "Standup")
+	// Stored as PUT leaves it, without the VCALENDAR
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{ETag: "etag", Component: []*ical.Component{event}}, nil)
+	mockStorage.On("GetObject", "alice", "work", "gone.ics").Return(nil, storage.ErrNotFound)
+	query := func(comp string) string {
+		return `<?xml version="1.0"?><C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/><C:calendar-data/></D:prop>` +
+			`<C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="` + comp + `"/></C:comp-filter></C:filter></C:calendar-query>`
+	}
+
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/a.ics", query("VEVENT"), nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/a.ics</d:href>")
+	assert.Contains(t, w.Body.String(), "<d:getetag>etag</d:getetag>")
+	assert.Contains(t, w.Body.String(), "<cal:calendar-data>BEGIN:VCALENDAR")
+	assert.Contains(t, w.Body.String(), "SUMMARY:Standup")
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/a.ics", query("VTODO"), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, string(CodeFilterMismatch), w.Header().Get(errorCodeHeader))
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/gone.ics", query("VEVENT"), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotEqual(t, string(CodeFilterMismatch), w.Header().Get(errorCodeHeader))
+
+	// Queries without a filter match the object
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/a.ics", `<?xml version="1.0"?><C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop></C:calendar-query>`, nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	// A multiget on an object answers for the object itself only
+	multiget := `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/><D:getcontenttype/></D:prop>` +
+		`<D:href>/caldav/alice/cal/work/a.ics</D:href><D:href>/caldav/alice/cal/work/b.ics</D:href></C:calendar-multiget>`
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/a.ics", multiget, nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:getetag>etag</d:getetag>")
+	assert.Contains(t, w.Body.String(), "<d:getcontenttype>text/calendar; charset=utf-8; component=VEVENT</d:getcontenttype>")
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/b.ics</d:href><d:status>HTTP/1.1 403 Forbidden</d:status>")
+}
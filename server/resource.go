@@ -3,8 +3,11 @@ package server
 import (
 	"fmt"
 	"log"
+	"net/url"
+	"slices"
 	"strings"
 
+	"github.com/cyp0633/libcaldora/internal/davpath"
 	"github.com/cyp0633/libcaldora/server/storage"
 )
 
@@ -19,8 +22,13 @@ import (
 // If you set prefix in the handler, you should consider initializing your URLConverter with the same prefix, like DefaultURLConverter does.
 type URLConverter interface {
 	// ParsePath parses a given path and returns the corresponding Resource.
+	// The path is escaped as the client sent it, r.URL.EscapedPath() for
+	// request URIs and the raw text of hrefs, so a %2F in an ID can be told
+	// from a separator. ParsePath splits it first and then percent-decodes
+	// each segment, e.g. with SplitPath, and returns the plain IDs.
 	ParsePath(path string) (Resource, error)
-	// EncodePath encodes a Resource back to its URL path representation.
+	// EncodePath encodes a Resource back to its URL path representation,
+	// percent-encoding each segment.
 	EncodePath(resource Resource) (string, error)
 }
 
@@ -46,6 +54,73 @@ type Resource struct {
 // The Prefix field can be used to add a common prefix to all paths (e.g., "/caldav/")
 type DefaultURLConverter struct {
 	Prefix string
+	// Optional: read calendar IDs in paths case-insensitively by folding
+	// them to lower case, for storages keeping them in lower case
+	FoldCalendarIDs bool
+	// Optional: the URL clients reach Prefix at when a reverse proxy
+	// rewrites paths, e.g. "https://cal.example.com/dav/". EncodePath writes
+	// paths under its path, which clients resolve against the URL they
+	// requested, and ParsePath accepts paths under either. Its scheme and
+	// host are only used by ServeWellKnown
+	ExternalURL string
+}
+
+// ExternalURLConverter is implemented by URLConverters that can encode paths
+// for a reverse proxy's external URL, see DefaultURLConverter.ExternalURL.
+// CaldavHandler.TrustForwardedHeaders needs it.
+type ExternalURLConverter interface {
+	URLConverter
+	// ExternalBase returns the external URL of the service root, "" when
+	// paths are encoded as they are served
+	ExternalBase() string
+	// WithExternalBase returns a converter encoding paths for base
+	WithExternalBase(base string) URLConverter
+}
+
+// ExternalBase returns c.ExternalURL.
+func (c *DefaultURLConverter) ExternalBase() string {
+	return c.ExternalURL
+}
+
+// WithExternalBase returns a copy of c with ExternalURL set to base.
+func (c *DefaultURLConverter) WithExternalBase(base string) URLConverter {
+	external := *c
+	external.ExternalURL = base
+	return &external
+}
+
+// externalPrefix returns the path EncodePath writes paths under: that of
+// ExternalURL ending with a slash, or Prefix when it is empty.
+func (c *DefaultURLConverter) externalPrefix() string {
+	if c.ExternalURL == "" {
+		return c.Prefix
+	}
+	u, err := url.Parse(c.ExternalURL)
+	if err != nil {
+		return c.Prefix
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		return u.Path + "/"
+	}
+	return u.Path
+}
+
+// SplitPath returns the segments of a request URI or href below the longest
+// of prefixes it lies under, or of the whole path under none of them. Paths
+// are canonicalized first: absolute URLs are reduced to their path,
+// duplicate and trailing slashes are dropped, "." and ".." segments are
+// resolved and segments are percent-decoded, leaving those that don't decode
+// as they are. URLConverters build on it so every spelling of a path names
+// the same resource.
+func SplitPath(path string, prefixes ...string) []string {
+	prefixes = slices.Clone(prefixes)
+	slices.SortFunc(prefixes, func(a, b string) int { return len(b) - len(a) })
+	for _, prefix := range prefixes {
+		if rest, ok := davpath.TrimPrefix(path, prefix); ok {
+			return davpath.Segments(rest)
+		}
+	}
+	return davpath.Segments(path)
 }
 
 // NewDefaultURLConverter creates a new DefaultURLConverter with the given prefix.
@@ -55,20 +130,15 @@ func NewDefaultURLConverter(prefix string) *DefaultURLConverter {
 }
 
 // ParsePath parses a CalDAV path into its components.
-// It handles paths with or without the configured prefix.
+// It handles paths with or without the configured prefix or the path of
+// ExternalURL, and absolute URLs clients send back as hrefs. Paths are
+// canonicalized with SplitPath, so pass escaped paths such as
+// r.URL.EscapedPath().
 func (c *DefaultURLConverter) ParsePath(path string) (Resource, error) {
 	resource := Resource{ResourceType: storage.ResourceUnknown, URI: path}
-
-	// Strip the prefix if present
-	path = strings.TrimPrefix(path, c.Prefix)
-	parts := strings.Split(path, "/")
-
-	// Filter out empty segments caused by leading/trailing/double slashes
-	var segments []string
-	for _, p := range parts {
-		if p != "" {
-			segments = append(segments, p)
-		}
+	segments := SplitPath(path, c.Prefix, c.externalPrefix())
+	if c.FoldCalendarIDs && len(segments) > 2 {
+		segments[2] = strings.ToLower(segments[2])
 	}
 
 	numSegments := len(segments)
@@ -124,35 +194,39 @@ func (c *DefaultURLConverter) ParsePath(path string) (Resource, error) {
 }
 
 // EncodePath encodes a Resource into a CalDAV path.
-// It validates that the resource has all required fields for its type
-// and adds the configured prefix to the path.
+// It validates that the resource has all required fields for its type,
+// percent-encodes them and adds the configured prefix, or the path of
+// ExternalURL, to the path.
 func (c *DefaultURLConverter) EncodePath(resource Resource) (string, error) {
 	var path string
+	userID := url.PathEscape(resource.UserID)
+	calendarID := url.PathEscape(resource.CalendarID)
+	objectID := url.PathEscape(resource.ObjectID)
 
 	switch resource.ResourceType {
 	case storage.ResourcePrincipal:
 		if resource.UserID == "" {
 			return "", fmt.Errorf("invalid resource: principal must have a UserID")
 		}
-		path = "/" + resource.UserID
+		path = "/" + userID
 
 	case storage.ResourceHomeSet:
 		if resource.UserID == "" {
 			return "", fmt.Errorf("invalid resource: home set must have a UserID")
 		}
-		path = "/" + resource.UserID + "/cal"
+		path = "/" + userID + "/cal"
 
 	case storage.ResourceCollection:
 		if resource.UserID == "" || resource.CalendarID == "" {
 			return "", fmt.Errorf("invalid resource: collection must have both UserID and CalendarID")
 		}
-		path = "/" + resource.UserID + "/cal/" + resource.CalendarID
+		path = "/" + userID + "/cal/" + calendarID
 
 	case storage.ResourceObject:
 		if resource.UserID == "" || resource.CalendarID == "" || resource.ObjectID == "" {
 			return "", fmt.Errorf("invalid resource: object must have UserID, CalendarID, and ObjectID")
 		}
-		path = "/" + resource.UserID + "/cal/" + resource.CalendarID + "/" + resource.ObjectID
+		path = "/" + userID + "/cal/" + calendarID + "/" + objectID
 
 	case storage.ResourceServiceRoot:
 		path = "/"
@@ -162,5 +236,5 @@ func (c *DefaultURLConverter) EncodePath(resource Resource) (string, error) {
 	}
 
 	// Add the prefix to the path
-	return c.Prefix + strings.TrimPrefix(path, "/"), nil
+	return c.externalPrefix() + strings.TrimPrefix(path, "/"), nil
 }
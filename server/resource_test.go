@@ -0,0 +1,217 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLConverterEscaping(t *testing.T) {
+	c := NewDefaultURLConverter("/caldav/")
+	tests := []struct {
+		objectID string
+		href     string
+	}{
+		{"a b.ics", "/caldav/alice/cal/work/a%20b.ics"},
+		{"a+b.ics", "/caldav/alice/cal/work/a+b.ics"},
+		{"événement.ics", "/caldav/alice/cal/work/%C3%A9v%C3%A9nement.ics"},
+		{"100%.ics", "/caldav/alice/cal/work/100%25.ics"},
+		{"a/b?.ics", "/caldav/alice/cal/work/a%2Fb%3F.ics"},
+	}
+	for _, tc := range tests {
+		res := Resource{UserID: "alice", CalendarID: "work", ObjectID: tc.objectID, ResourceType: storage.ResourceObject}
+		href, err := c.EncodePath(res)
+		require.NoError(t, err)
+		assert.Equal(t, tc.href, href)
+
+		parsed, err := c.ParsePath(href)
+		require.NoError(t, err)
+		assert.Equal(t, tc.objectID, parsed.ObjectID)
+	}
+
+	// Clients escape more than they have to
+	parsed, err := c.ParsePath("/caldav/alice/cal/work/a%2Bb.ics")
+	require.NoError(t, err)
+	assert.Equal(t, "a+b.ics", parsed.ObjectID)
+}
+
+func TestURLConverterCanonicalization(t *testing.T) {
+	c := NewDefaultURLConverter("/caldav/")
+	object := Resource{UserID: "alice", CalendarID: "work", ObjectID: "a b.ics", ResourceType: storage.ResourceObject}
+	collection := Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}
+	for path, want := range map[string]Resource{
+		"/caldav/alice/cal/work/a%20b.ics":                        object,
+		"/caldav/alice/cal/work/a%20b.ics/":                       object,
+		"/caldav//alice/cal//work/a%20b.ics":                      object,
+		"//caldav/alice/cal/work/a%20b.ics":                       object,
+		"/caldav/alice/cal/work/./a%20b.ics":                      object,
+		"/caldav/alice/cal/home/../work/a%20b.ics":                object,
+		"/caldav/alice/cal/work/a b.ics":                          object,
+		"alice/cal/work/a%20b.ics":                                object,
+		"https://dav.example.com/caldav/alice/cal/work/a%20b.ics": object,
+		"/caldav/alice/cal/work":                                  collection,
+		"/caldav/alice/cal/work/":                                 collection,
+		"/caldav/alice/cal/work//":                                collection,
+		"/caldav/alice/cal/work/a%20b.ics/..":                     collection,
+		"/caldav/%61lice/cal/%77ork":                              collection,
+		"/caldav":                                                 {ResourceType: storage.ResourceServiceRoot},
+		"/caldav/":                                                {ResourceType: storage.ResourceServiceRoot},
+		"/":                                                       {ResourceType: storage.ResourceServiceRoot},
+		"/caldav/alice//":                                         {UserID: "alice", ResourceType: storage.ResourcePrincipal},
+		"/caldav/alice/cal/":                                      {UserID: "alice", ResourceType: storage.ResourceHomeSet},
+	} {
+		res, err := c.ParsePath(path)
+		require.NoError(t, err, path)
+		res.URI = ""
+		assert.Equal(t, want, res, path)
+	}
+
+	// Escaped slashes and dots stay inside their segment
+	res, err := c.ParsePath("/caldav/alice/cal/work/%2E%2E")
+	require.NoError(t, err)
+	assert.Equal(t, "..", res.ObjectID)
+	_, err = c.ParsePath("/caldav/alice/cal/work/a/b.ics")
+	assert.Error(t, err)
+	res, err = c.ParsePath("/caldav/alice/cal/work/a%2Fb.ics")
+	require.NoError(t, err)
+	assert.Equal(t, "a/b.ics", res.ObjectID)
+
+	// Only whole segments match the prefix
+	_, err = c.ParsePath("/caldavx/alice/cal/work/a.ics")
+	assert.Error(t, err)
+
+	// Calendar IDs keep their case unless folded
+	res, err = c.ParsePath("/caldav/Alice/cal/Work/A.ics")
+	require.NoError(t, err)
+	assert.Equal(t, Resource{UserID: "Alice", CalendarID: "Work", ObjectID: "A.ics", ResourceType: storage.ResourceObject}, Resource{
+		UserID: res.UserID, CalendarID: res.CalendarID, ObjectID: res.ObjectID, ResourceType: res.ResourceType,
+	})
+	c.FoldCalendarIDs = true
+	res, err = c.ParsePath("/caldav/Alice/cal/Work/A.ics")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", res.UserID)
+	assert.Equal(t, "work", res.CalendarID)
+	assert.Equal(t, "A.ics", res.ObjectID)
+	res, err = c.ParsePath("/caldav/alice/cal/WORK/")
+	require.NoError(t, err)
+	assert.Equal(t, "work", res.CalendarID)
+}
+
+func TestSplitPath(t *testing.T) {
+	assert.Equal(t, []string{"alice", "cal"}, SplitPath("/caldav/alice/cal/", "/caldav/"))
+	assert.Equal(t, []string{"alice", "cal"}, SplitPath("/dav/caldav/alice/cal", "/caldav/", "/dav/caldav/"))
+	assert.Equal(t, []string{"dav", "alice"}, SplitPath("/dav/alice", "/caldav/"))
+	assert.Nil(t, SplitPath("/caldav", "/caldav/"))
+	assert.Equal(t, []string{"a b"}, SplitPath("https://dav.example.com/caldav/a%20b/", "/caldav/"))
+}
+
+func TestCanonicalPathInIfHeader(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	mockStorage.On("GetObject", "alice", "work", "a b.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/a%20b.ics", ETag: `"1"`}, nil)
+	mockStorage.On("DeleteObject", "alice", "work", "a b.ics").Return(nil)
+
+	// Tagged with another spelling of the request URI, the list still applies
+	w := serveAlice(h, "DELETE", "/caldav/alice/cal/work/a%20b.ics", "", map[string]string{"If": `</caldav//alice/cal/work/a b.ics/> (["2"])`})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serveAlice(h, "DELETE", "/caldav/alice/cal/work/a%20b.ics", "", map[string]string{"If": `</caldav//alice/cal/work/a b.ics/> (["1"])`})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 2)
+}
+
+func FuzzURLConverterRoundTrip(f *testing.F) {
+	for _, seed := range []string{"event.ics", "a b.ics", "a+b.ics", "événement.ics", "100%.ics", "a%20b", "日程 📅.ics"} {
+		f.Add(seed)
+	}
+	c := NewDefaultURLConverter("/caldav/")
+	f.Fuzz(func(t *testing.T, objectID string) {
+		if objectID == "" || objectID == "." || objectID == ".." || !utf8.ValidString(objectID) {
+			t.Skip()
+		}
+		res := Resource{UserID: "alice", CalendarID: "work", ObjectID: objectID, ResourceType: storage.ResourceObject}
+		href, err := c.EncodePath(res)
+		require.NoError(t, err)
+		parsed, err := c.ParsePath(href)
+		require.NoError(t, err)
+		assert.Equal(t, res.ObjectID, parsed.ObjectID)
+		assert.Equal(t, storage.ResourceObject, parsed.ResourceType)
+		assert.Equal(t, objectID, storage.PathSegment(href))
+	})
+}
+
+// journalChangeStorage lists every stored object as changed in
+// sync-collection.
+type journalChangeStorage struct {
+	*changeStorage
+}
+
+func (s *journalChangeStorage) GetSyncToken(_, _ string) (string, error) {
+	return "urn:test:1", nil
+}
+
+func (s *journalChangeStorage) GetObjectPathsInCollection(_ string) ([]string, error) {
+	var paths []string
+	for _, obj := range s.objects {
+		paths = append(paths, obj.Path)
+	}
+	return paths, nil
+}
+
+func (s *journalChangeStorage) GetChanges(_, _, _ string) (*storage.ChangeSet, error) {
+	set := &storage.ChangeSet{Token: "urn:test:1"}
+	for _, obj := range s.objects {
+		set.Changes = append(set.Changes, storage.Change{Path: obj.Path, ETag: obj.ETag})
+	}
+	return set, nil
+}
+
+func TestEscapedObjectIDs(t *testing.T) {
+	store := &journalChangeStorage{&changeStorage{MockStorage: &storage.MockStorage{}, objects: map[string]storage.CalendarObject{}}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropProductID, "-//test//EN")
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: cal}, nil).Maybe()
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	const href = "/caldav/alice/cal/work/r%C3%A9union%20a+b.ics"
+	body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:réunion a+b\r\n" +
+		"DTSTAMP:20250101T000000Z\r\nDTSTART:20250303T090000Z\r\nDTEND:20250303T093000Z\r\n" +
+		"SUMMARY:Réunion\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	w := serveAlice(h, http.MethodPut, href, body, map[string]string{"Content-Type": "text/calendar"})
+	require.Less(t, w.Code, 300, w.Body.String())
+	require.Contains(t, store.objects, "réunion a+b.ics")
+
+	w = serveAlice(h, http.MethodGet, href, "", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "SUMMARY:Réunion")
+
+	// The client escapes "+" where the server doesn't, and gets its href back
+	multiget := `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <D:href>/caldav/alice/cal/work/r%C3%A9union%20a%2Bb.ics</D:href>
+</C:calendar-multiget>`
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", multiget, map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/r%C3%A9union%20a%2Bb.ics</d:href>")
+	assert.Contains(t, w.Body.String(), "<d:getetag>1</d:getetag>")
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", syncBody(""), nil)
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>"+href+"</d:href>")
+
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", `<D:propfind xmlns:D="DAV:"><D:prop><D:getetag/></D:prop></D:propfind>`,
+		map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "<d:href>"+href+"</d:href>")
+
+	w = serveAlice(h, http.MethodDelete, href, "", nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Empty(t, store.objects)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/beevik/etree"
+)
+
+// ResponseSizes counts the DAV:response elements and bytes of the
+// multistatus responses the handler writes, per report type and Depth, so
+// operators can spot clients whose requests grow pathologically large, such
+// as a calendar-multiget of thousands of hrefs or a Depth: infinity PROPFIND.
+// Set it as CaldavHandler.ResponseSizes.
+type ResponseSizes struct {
+	WarnResponses int // Optional: log responses with at least this many DAV:response elements at warn level
+	WarnBytes     int // Optional: log responses of at least this many bytes at warn level
+
+	mu    sync.Mutex
+	kinds map[ResponseKind]*ResponseSizeStats
+}
+
+// ResponseKind tells multistatus responses apart by what was asked.
+type ResponseKind struct {
+	// Report is the REPORT type, e.g. "calendar-multiget", or the lowercase
+	// method for other requests, e.g. "propfind"
+	Report string
+	// Depth is "0", "1" or "infinity"
+	Depth string
+}
+
+// ResponseSizeStats sums the multistatus responses of one kind. All fields
+// but the maxima only grow, so they can be exported as Prometheus counters,
+// see WriteMetrics.
+type ResponseSizeStats struct {
+	Count        uint64 // multistatus responses written
+	Responses    uint64 // DAV:response elements in them
+	Bytes        uint64 // bytes of their bodies
+	MaxResponses int    // most DAV:response elements in one response
+	MaxBytes     int    // largest body
+}
+
+// NewResponseSizes returns a ResponseSizes that logs responses at warn level
+// from warnResponses DAV:response elements or warnBytes bytes on, never when
+// zero.
+func NewResponseSizes(warnResponses, warnBytes int) *ResponseSizes {
+	return &ResponseSizes{WarnResponses: warnResponses, WarnBytes: warnBytes}
+}
+
+// Record adds a response of the given number of DAV:response elements and
+// bytes to its kind.
+func (s *ResponseSizes) Record(kind ResponseKind, responses, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kinds == nil {
+		s.kinds = map[ResponseKind]*ResponseSizeStats{}
+	}
+	stats, ok := s.kinds[kind]
+	if !ok {
+		stats = &ResponseSizeStats{}
+		s.kinds[kind] = stats
+	}
+	stats.Count++
+	stats.Responses += uint64(responses)
+	stats.Bytes += uint64(bytes)
+	stats.MaxResponses = max(stats.MaxResponses, responses)
+	stats.MaxBytes = max(stats.MaxBytes, bytes)
+}
+
+// Stats returns what was recorded so far, by kind.
+func (s *ResponseSizes) Stats() map[ResponseKind]ResponseSizeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[ResponseKind]ResponseSizeStats, len(s.kinds))
+	for kind, st := range s.kinds {
+		stats[kind] = *st
+	}
+	return stats
+}
+
+// WriteMetrics writes Stats in the Prometheus text exposition format, for
+// appending to a /metrics endpoint.
+func (s *ResponseSizes) WriteMetrics(out io.Writer) error {
+	stats := s.Stats()
+	kinds := make([]ResponseKind, 0, len(stats))
+	for kind := range stats {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i].Report != kinds[j].Report {
+			return kinds[i].Report < kinds[j].Report
+		}
+		return kinds[i].Depth < kinds[j].Depth
+	})
+
+	for _, metric := range []struct {
+		name, help, kind string
+		value            func(ResponseSizeStats) any
+	}{
+		{"caldav_multistatus_total", "Multistatus responses written.", "counter", func(st ResponseSizeStats) any { return st.Count }},
+		{"caldav_multistatus_responses_total", "DAV:response elements in multistatus responses.", "counter", func(st ResponseSizeStats) any { return st.Responses }},
+		{"caldav_multistatus_bytes_total", "Bytes of multistatus response bodies.", "counter", func(st ResponseSizeStats) any { return st.Bytes }},
+		{"caldav_multistatus_max_responses", "Most DAV:response elements in one multistatus response.", "gauge", func(st ResponseSizeStats) any { return st.MaxResponses }},
+		{"caldav_multistatus_max_bytes", "Largest multistatus response body.", "gauge", func(st ResponseSizeStats) any { return st.MaxBytes }},
+	} {
+		if _, err := fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind); err != nil {
+			return err
+		}
+		for _, kind := range kinds {
+			if _, err := fmt.Fprintf(out, "%s{report=%q,depth=%q} %v\n",
+				metric.name, kind.Report, kind.Depth, metric.value(stats[kind])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// responseSize is a multistatus response written for a request.
+type responseSize struct {
+	kind      ResponseKind
+	responses int
+	bytes     int
+}
+
+// recordResponseSize logs the size of a multistatus response written for r,
+// and adds it to h.ResponseSizes when set. body is the serialized doc. While
+// serveRetrying may serve r again, the size is kept until it knows which
+// attempt is final.
+func (h *CaldavHandler) recordResponseSize(r *http.Request, doc *etree.Document, body string) {
+	root := doc.Root()
+	if root == nil || root.Tag != "multistatus" {
+		return
+	}
+	size := responseSize{
+		kind:      ResponseKind{Report: strings.ToLower(r.Method), Depth: r.Header.Get("Depth")},
+		responses: len(root.SelectElements("response")),
+		bytes:     len(body),
+	}
+	if ctx, ok := RequestContextFrom(r.Context()); ok {
+		if ctx.report != "" {
+			size.kind.Report = ctx.report
+		}
+		size.kind.Depth = depthLabel(ctx.Depth)
+		if ctx.retrying {
+			ctx.responseSize = &size
+			return
+		}
+	}
+	h.reportResponseSize(size)
+}
+
+// reportResponseSize logs size and adds it to h.ResponseSizes when set.
+func (h *CaldavHandler) reportResponseSize(size responseSize) {
+	args := []any{
+		"report", size.kind.Report,
+		"depth", size.kind.Depth,
+		"responses", size.responses,
+		"bytes", size.bytes,
+	}
+	if s := h.ResponseSizes; s != nil {
+		s.Record(size.kind, size.responses, size.bytes)
+		if (s.WarnResponses > 0 && size.responses >= s.WarnResponses) || (s.WarnBytes > 0 && size.bytes >= s.WarnBytes) {
+			h.Logger.Warn("large multistatus response", args...)
+			return
+		}
+	}
+	h.Logger.Debug("multistatus response written", args...)
+}
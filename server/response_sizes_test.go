@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseSizes(t *testing.T) {
+	var logs bytes.Buffer
+	h := newGoldenTestHandler()
+	h.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h.ResponseSizes = NewResponseSizes(2, 0)
+
+	multiget, err := os.ReadFile(filepath.Join("testdata", "golden", "davx5", "multiget.request.xml"))
+	require.NoError(t, err)
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/", string(multiget), map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	multigetBytes := w.Body.Len()
+
+	propfind := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getetag/></D:prop></D:propfind>`
+	for _, depth := range []string{"0", "1", "1"} {
+		w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/", propfind, map[string]string{"Depth": depth})
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+	}
+	// Errors aren't multistatus responses and aren't counted
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", `<C:free-busy-query xmlns:C="urn:ietf:params:xml:ns:caldav"/>`, nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	stats := h.ResponseSizes.Stats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, ResponseSizeStats{
+		Count:        1,
+		Responses:    2,
+		Bytes:        uint64(multigetBytes),
+		MaxResponses: 2,
+		MaxBytes:     multigetBytes,
+	}, stats[ResponseKind{Report: "calendar-multiget", Depth: "1"}])
+	assert.Equal(t, uint64(1), stats[ResponseKind{Report: "propfind", Depth: "0"}].Responses)
+	depth1 := stats[ResponseKind{Report: "propfind", Depth: "1"}]
+	assert.Equal(t, uint64(2), depth1.Count)
+	assert.Equal(t, uint64(4), depth1.Responses)
+	assert.Equal(t, 2, depth1.MaxResponses)
+
+	// Responses from WarnResponses elements on are logged at warn level
+	assert.Contains(t, logs.String(), `level=WARN msg="large multistatus response" report=calendar-multiget depth=1 responses=2`)
+	assert.Contains(t, logs.String(), `level=DEBUG msg="multistatus response written" report=propfind depth=0 responses=1`)
+
+	var metrics bytes.Buffer
+	require.NoError(t, h.ResponseSizes.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), "# TYPE caldav_multistatus_total counter\n"+
+		"caldav_multistatus_total{report=\"calendar-multiget\",depth=\"1\"} 1\n"+
+		"caldav_multistatus_total{report=\"propfind\",depth=\"0\"} 1\n"+
+		"caldav_multistatus_total{report=\"propfind\",depth=\"1\"} 2\n")
+	assert.Contains(t, metrics.String(), "# TYPE caldav_multistatus_max_responses gauge\n")
+	assert.Contains(t, metrics.String(), "caldav_multistatus_responses_total{report=\"propfind\",depth=\"1\"} 4\n")
+}
+
+func TestDepthLabel(t *testing.T) {
+	assert.Equal(t, "0", depthLabel(0))
+	assert.Equal(t, "1", depthLabel(1))
+	assert.Equal(t, "infinity", depthLabel(DepthInfinity))
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is the Retry-After sent with 503 responses when
+// RetryPolicy.RetryAfter is unset.
+const defaultRetryAfter = 30 * time.Second
+
+// defaultRetryBuffer is the RetryPolicy.MaxBuffer used when it is unset.
+const defaultRetryBuffer = 1 << 20
+
+// RetryPolicy controls how the handler deals with storage errors marked with
+// storage.Transient, such as deadlocks and timeouts. Reads (GET, HEAD,
+// OPTIONS, PROPFIND and REPORT) failing with one are served again, up to
+// Attempts more times. A failure that persists is answered with 503 and
+// Retry-After, as are storage.ErrStorageUnavailable errors.
+type RetryPolicy struct {
+	// Attempts is how many times a failed read is retried, none when 0
+	Attempts int
+	// Backoff is the wait before the first retry, doubled for each further
+	// one
+	Backoff time.Duration
+	// RetryAfter is sent with 503 responses, rounded down to seconds. 30
+	// seconds when 0
+	RetryAfter time.Duration
+	// MaxBuffer caps the bytes of a request body and of a response kept to
+	// serve a read again, 1 MiB when 0. Larger requests are served once,
+	// and larger responses are streamed and not retried
+	MaxBuffer int
+}
+
+func (p RetryPolicy) retryAfter() time.Duration {
+	if p.RetryAfter <= 0 {
+		return defaultRetryAfter
+	}
+	return p.RetryAfter
+}
+
+func (p RetryPolicy) maxBuffer() int {
+	if p.MaxBuffer <= 0 {
+		return defaultRetryBuffer
+	}
+	return p.MaxBuffer
+}
+
+// setRetryAfter sets the Retry-After header of a 503 response.
+func (h *CaldavHandler) setRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(h.Retry.retryAfter().Seconds())))
+}
+
+// retryable reports whether requests of method can be served again without
+// side effects.
+func retryable(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "REPORT":
+		return true
+	}
+	return false
+}
+
+// serveRetrying runs the middleware chain until the response doesn't stem
+// from a transient storage error or h.Retry.Attempts are used up. Request
+// bodies and responses are buffered up to h.Retry.MaxBuffer bytes so only the
+// last response reaches w. A larger request body is served once, and a
+// response that outgrows the buffer or is flushed goes out as it's written
+// and ends the retries. Response sizes are recorded for the final attempt
+// only.
+func (h *CaldavHandler) serveRetrying(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	limit := h.Retry.maxBuffer()
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+		if err != nil || len(body) > limit {
+			// The body can't be replayed, serve it once as read
+			var rest io.Reader = r.Body
+			if err != nil {
+				rest = errReader{err}
+			} else {
+				h.Logger.Debug("request body too large to retry",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"limit", limit)
+			}
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), rest), r.Body}
+			h.chain().ServeHTTP(w, r)
+			return
+		}
+		r.Body.Close()
+	}
+
+	ctx.retrying = true
+	defer func() { ctx.retrying = false }()
+	backoff := h.Retry.Backoff
+	for attempt := 0; ; attempt++ {
+		ctx.transient = false
+		ctx.responseSize = nil
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		buf := newResponseBuffer(w, limit)
+		h.chain().ServeHTTP(buf, r)
+		if buf.streamed || !ctx.transient || attempt == h.Retry.Attempts {
+			h.finishRetrying(buf, ctx)
+			return
+		}
+
+		h.Logger.Warn("retrying after transient storage error",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"attempt", attempt+1)
+		select {
+		case <-r.Context().Done():
+			h.finishRetrying(buf, ctx)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// finishRetrying sends the final response and records its size.
+func (h *CaldavHandler) finishRetrying(buf *responseBuffer, ctx *RequestContext) {
+	buf.flush()
+	if ctx.responseSize != nil {
+		h.reportResponseSize(*ctx.responseSize)
+	}
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseBuffer holds a response until it is known to be the final one, or
+// until it outgrows limit and is streamed to w.
+type responseBuffer struct {
+	w      http.ResponseWriter
+	limit  int
+	header http.Header
+	status int
+	body   bytes.Buffer
+	// streamed is set once the response went out to w; it can't be
+	// retried after that
+	streamed bool
+}
+
+func newResponseBuffer(w http.ResponseWriter, limit int) *responseBuffer {
+	return &responseBuffer{w: w, limit: limit, header: make(http.Header)}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	if b.streamed {
+		return b.w.Header()
+	}
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if !b.streamed && b.body.Len()+len(p) > b.limit {
+		b.flush()
+	}
+	if b.streamed {
+		return b.w.Write(p)
+	}
+	return b.body.Write(p)
+}
+
+// Flush streams the response from here on.
+func (b *responseBuffer) Flush() {
+	b.flush()
+	if f, ok := b.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// flush sends what is buffered to w and passes later writes through.
+func (b *responseBuffer) flush() {
+	if b.streamed {
+		return
+	}
+	b.streamed = true
+	for key, values := range b.header {
+		b.w.Header()[key] = values
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	b.w.WriteHeader(b.status)
+	b.w.Write(b.body.Bytes())
+	b.body.Reset()
+}
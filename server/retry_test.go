@@ -0,0 +1,165 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func retryTestCalendar() *storage.Calendar {
+	data := ical.NewCalendar()
+	data.Props.SetText(ical.PropProductID, "-//test//EN")
+	data.Props.SetText(ical.PropVersion, "2.0")
+	return &storage.Calendar{CalendarData: data}
+}
+
+func retryTestObject() *storage.CalendarObject {
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, "standup")
+	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+	event.Props.SetDateTime(ical.PropDateTimeStart, time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	return &storage.CalendarObject{Path: "/alice/cal/work/standup.ics", ETag: `"1"`, Component: []*ical.Component{event.Component}}
+}
+
+func TestRetryTransientRead(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{Attempts: 2, Backoff: time.Millisecond}
+	deadlock := storage.Transient(errors.New("deadlock detected"))
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(nil, deadlock).Once()
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(retryTestObject(), nil).Once()
+
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/standup.ics", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "UID:standup")
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	assert.Empty(t, w.Header().Get("Libcaldora-Error"), "the failed attempt isn't sent")
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 2)
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{Attempts: 1}
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(nil, storage.Transient(errors.New("timeout"))).Once()
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(retryTestObject(), nil).Once()
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getetag/></D:prop></D:propfind>`
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/standup.ics", body, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:getetag>&quot;1&quot;</d:getetag>", "the body is read again")
+}
+
+func TestRetryPersistentFailure(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{Attempts: 2, RetryAfter: 10 * time.Second}
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(nil, storage.Transient(errors.New("deadlock detected")))
+
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/standup.ics", "", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Equal(t, string(CodeStorageUnavailable), w.Header().Get("Libcaldora-Error"))
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 3)
+}
+
+func TestRetryDisabled(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{}
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(nil, storage.Transient(errors.New("deadlock detected")))
+
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/standup.ics", "", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 1)
+}
+
+func TestRetryOnlyTransientReads(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{Attempts: 2}
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(nil, storage.ErrStorageUnavailable)
+
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/standup.ics", "", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 1)
+
+	assert.False(t, retryable(http.MethodPut))
+	assert.False(t, retryable(http.MethodDelete))
+	assert.True(t, retryable("REPORT"))
+}
+
+func TestRetryLargeRequestServedOnce(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{Attempts: 2, MaxBuffer: 16}
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(nil, storage.Transient(errors.New("timeout"))).Once()
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getetag/></D:prop></D:propfind>`
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/standup.ics", body, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "a body over MaxBuffer isn't kept for a retry")
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 1)
+}
+
+func TestRetryStreamedResponse(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{Attempts: 2, MaxBuffer: 16}
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(retryTestObject(), nil)
+	// Flag every attempt transient after it has written its response
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			ctx, _ := RequestContextFrom(r.Context())
+			ctx.transient = true
+		})
+	})
+
+	w := serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/standup.ics", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "UID:standup"))
+	mockStorage.AssertNumberOfCalls(t, "GetObject", 1)
+}
+
+func TestRetryRecordsFinalResponseSize(t *testing.T) {
+	h, mockStorage := newTestHandler(retryTestCalendar())
+	h.Retry = RetryPolicy{Attempts: 1}
+	h.ResponseSizes = NewResponseSizes(0, 0)
+	mockStorage.On("GetObject", "alice", "work", "standup.ics").Return(retryTestObject(), nil)
+	attempts := 0
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			if attempts++; attempts == 1 {
+				ctx, _ := RequestContextFrom(r.Context())
+				ctx.transient = true
+			}
+		})
+	})
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getetag/></D:prop></D:propfind>`
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work/standup.ics", body, map[string]string{"Depth": "0"})
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, uint64(1), h.ResponseSizes.Stats()[ResponseKind{Report: "propfind", Depth: "0"}].Count)
+}
+
+func TestResponseBufferFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	buf := newResponseBuffer(rec, 1024)
+	buf.Header().Set("Content-Type", "text/plain")
+	buf.WriteHeader(http.StatusAccepted)
+	buf.Write([]byte("held"))
+	assert.Empty(t, rec.Body.String())
+
+	buf.Flush()
+	assert.True(t, buf.streamed)
+	assert.True(t, rec.Flushed)
+	buf.Write([]byte(" and streamed"))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "held and streamed", rec.Body.String())
+}
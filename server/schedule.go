@@ -0,0 +1,222 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+var (
+	// ErrScheduleInvalid is returned for scheduling messages that aren't valid
+	// iTIP (RFC 5546): no or unknown METHOD, no UID, mixed UIDs or no ORGANIZER.
+	ErrScheduleInvalid = errors.New("invalid scheduling message")
+	// ErrScheduleOriginator is returned when the ORGANIZER of an organizer
+	// message, or the replying ATTENDEE of an attendee message, isn't one of
+	// the sender's calendar user addresses.
+	ErrScheduleOriginator = errors.New("originator is not the authenticated user")
+	// ErrScheduleRecipient rejects a recipient that isn't local, isn't in an
+	// allowed domain, or isn't a party of the scheduled event.
+	ErrScheduleRecipient = errors.New("recipient not allowed")
+)
+
+// organizerMethods are the iTIP methods only the organizer sends; the others
+// (REPLY, REFRESH, COUNTER) come from attendees.
+var organizerMethods = map[string]bool{
+	"PUBLISH":        true,
+	"REQUEST":        true,
+	"ADD":            true,
+	"CANCEL":         true,
+	"DECLINECOUNTER": true,
+}
+
+var attendeeMethods = map[string]bool{
+	"REPLY":   true,
+	"REFRESH": true,
+	"COUNTER": true,
+}
+
+// ScheduleMessage is a scheduling message that passed validation.
+type ScheduleMessage struct {
+	// UserID is the authenticated sender
+	UserID string
+	Method string
+	UID    string
+	// Originator is the sender's address as the message names it: the
+	// ORGANIZER, or the replying ATTENDEE
+	Originator string
+	// Recipients may receive the message
+	Recipients []string
+	// Rejected maps refused recipients to the reason, ErrScheduleRecipient or
+	// a filter's error
+	Rejected map[string]error
+	Calendar *ical.Calendar
+}
+
+// ScheduleFilter inspects a message before it's delivered to recipient, for
+// spam and abuse control such as rate limits, blocklists or content checks.
+// Returning an error keeps the message out of that recipient's inbox.
+type ScheduleFilter func(msg *ScheduleMessage, recipient string) error
+
+// ScheduleValidator checks scheduling messages users send before they reach
+// recipients' inboxes. The scheduling outbox calls Validate and delivers to
+// the accepted recipients only.
+type ScheduleValidator struct {
+	Storage storage.Storage
+	// LocalDomains lists the domains of calendar user addresses this
+	// deployment serves, e.g. "example.com"
+	LocalDomains []string
+	// AllowedDomains lists external domains messages may be sent to; "*"
+	// allows every domain
+	AllowedDomains []string
+	// Filters run in order for every recipient that passed the checks above
+	Filters []ScheduleFilter
+}
+
+// NewScheduleValidator returns a validator looking senders up in store and
+// delivering to localDomains only.
+func NewScheduleValidator(store storage.Storage, localDomains ...string) *ScheduleValidator {
+	return &ScheduleValidator{Storage: store, LocalDomains: localDomains}
+}
+
+// Validate checks msg sent by userID to recipients, or to every party of the
+// event when recipients is nil. It returns an error wrapping
+// ErrScheduleInvalid or ErrScheduleOriginator when the whole message must be
+// refused; otherwise the message lists which recipients may receive it and
+// why the others may not.
+func (v *ScheduleValidator) Validate(userID string, msg *ical.Calendar, recipients []string) (*ScheduleMessage, error) {
+	out := &ScheduleMessage{UserID: userID, Calendar: msg, Rejected: map[string]error{}}
+	if p := msg.Props.Get(ical.PropMethod); p != nil {
+		out.Method = strings.ToUpper(p.Value)
+	}
+	if !organizerMethods[out.Method] && !attendeeMethods[out.Method] {
+		return nil, fmt.Errorf("%w: unsupported METHOD %q", ErrScheduleInvalid, out.Method)
+	}
+
+	var organizer string
+	var attendees []string
+	for _, comp := range msg.Children {
+		if comp.Name == ical.CompTimezone {
+			continue
+		}
+		uid := comp.Props.Get(ical.PropUID)
+		if uid == nil || uid.Value == "" {
+			return nil, fmt.Errorf("%w: component without UID", ErrScheduleInvalid)
+		}
+		if out.UID != "" && out.UID != uid.Value {
+			return nil, fmt.Errorf("%w: components have different UIDs", ErrScheduleInvalid)
+		}
+		out.UID = uid.Value
+		if p := comp.Props.Get(ical.PropOrganizer); p != nil {
+			organizer = p.Value
+		}
+		for _, p := range comp.Props.Values(ical.PropAttendee) {
+			attendees = append(attendees, p.Value)
+		}
+	}
+	if out.UID == "" {
+		return nil, fmt.Errorf("%w: no scheduling component", ErrScheduleInvalid)
+	}
+	if organizer == "" && out.Method != "PUBLISH" {
+		return nil, fmt.Errorf("%w: no ORGANIZER", ErrScheduleInvalid)
+	}
+
+	addresses, err := v.userAddresses(userID)
+	if err != nil {
+		return nil, err
+	}
+	// Organizers send invitations to attendees; attendees answer the organizer
+	var parties []string
+	if organizerMethods[out.Method] {
+		if organizer != "" && !slices.Contains(addresses, normalizeAddress(organizer)) {
+			return nil, ErrScheduleOriginator
+		}
+		out.Originator, parties = organizer, attendees
+	} else {
+		for _, attendee := range attendees {
+			if slices.Contains(addresses, normalizeAddress(attendee)) {
+				out.Originator = attendee
+			}
+		}
+		if out.Originator == "" {
+			return nil, ErrScheduleOriginator
+		}
+		parties = []string{organizer}
+	}
+	for i := range parties {
+		parties[i] = normalizeAddress(parties[i])
+	}
+	if recipients == nil {
+		for _, party := range parties {
+			if party != "" && !slices.Contains(addresses, party) && !slices.Contains(recipients, party) {
+				recipients = append(recipients, party)
+			}
+		}
+	}
+
+	for _, recipient := range recipients {
+		if err := v.checkRecipient(out, recipient, parties); err != nil {
+			out.Rejected[recipient] = err
+			continue
+		}
+		out.Recipients = append(out.Recipients, recipient)
+	}
+	return out, nil
+}
+
+func (v *ScheduleValidator) checkRecipient(msg *ScheduleMessage, recipient string, parties []string) error {
+	address := normalizeAddress(recipient)
+	if msg.Method != "PUBLISH" && !slices.Contains(parties, address) {
+		return fmt.Errorf("%w: %s is not a party of the event", ErrScheduleRecipient, recipient)
+	}
+	domain := addressDomain(address)
+	if domain == "" || !slices.Contains(v.LocalDomains, domain) &&
+		!slices.Contains(v.AllowedDomains, domain) && !slices.Contains(v.AllowedDomains, "*") {
+		return fmt.Errorf("%w: %s is not in an allowed domain", ErrScheduleRecipient, recipient)
+	}
+	for _, filter := range v.Filters {
+		if err := filter(msg, recipient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userAddresses returns the normalized calendar user addresses of userID.
+func (v *ScheduleValidator) userAddresses(userID string) ([]string, error) {
+	user, err := v.Storage.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.UserAddress == "" {
+		return nil, nil
+	}
+	return []string{normalizeAddress(user.UserAddress)}, nil
+}
+
+// normalizeAddress lower-cases a calendar user address and adds the mailto:
+// scheme to bare email addresses.
+func normalizeAddress(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if !strings.Contains(address, ":") && strings.Contains(address, "@") {
+		address = "mailto:" + address
+	}
+	return address
+}
+
+// addressDomain returns the domain of a mailto: address, or an empty string
+// for other addresses.
+func addressDomain(address string) string {
+	email, ok := strings.CutPrefix(address, "mailto:")
+	if !ok {
+		return ""
+	}
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return ""
+	}
+	return domain
+}
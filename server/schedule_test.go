@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSchedulingMessage(method, organizer string, attendees ...string) *ical.Calendar {
+	msg := ical.NewCalendar()
+	msg.Props.SetText(ical.PropMethod, method)
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "meeting")
+	event.Props.Set(&ical.Prop{Name: ical.PropOrganizer, Value: organizer, Params: ical.Params{}})
+	for _, attendee := range attendees {
+		event.Props.Add(&ical.Prop{Name: ical.PropAttendee, Value: attendee, Params: ical.Params{}})
+	}
+	msg.Children = append(msg.Children, event)
+	return msg
+}
+
+func TestScheduleValidator(t *testing.T) {
+	mockStorage := &storage.MockStorage{}
+	mockStorage.On("GetUser", "alice").Return(&storage.User{UserAddress: "mailto:alice@example.com"}, nil)
+	mockStorage.On("GetUser", "bob").Return(&storage.User{UserAddress: "mailto:bob@example.com"}, nil)
+	v := NewScheduleValidator(mockStorage, "example.com")
+	v.AllowedDomains = []string{"partner.org"}
+	errSpam := errors.New("blocked")
+	v.Filters = []ScheduleFilter{func(msg *ScheduleMessage, recipient string) error {
+		if recipient == "mailto:spam@partner.org" {
+			return errSpam
+		}
+		return nil
+	}}
+
+	t.Run("invitation", func(t *testing.T) {
+		msg := newSchedulingMessage("REQUEST", "mailto:Alice@Example.com",
+			"mailto:bob@example.com", "mailto:carol@partner.org", "mailto:dave@elsewhere.net", "mailto:spam@partner.org")
+		out, err := v.Validate("alice", msg, []string{
+			"mailto:bob@example.com", "mailto:carol@partner.org", "mailto:dave@elsewhere.net",
+			"mailto:spam@partner.org", "mailto:eve@example.com",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "REQUEST", out.Method)
+		assert.Equal(t, "meeting", out.UID)
+		assert.Equal(t, []string{"mailto:bob@example.com", "mailto:carol@partner.org"}, out.Recipients)
+		assert.ErrorIs(t, out.Rejected["mailto:dave@elsewhere.net"], ErrScheduleRecipient)
+		assert.ErrorIs(t, out.Rejected["mailto:eve@example.com"], ErrScheduleRecipient)
+		assert.ErrorIs(t, out.Rejected["mailto:spam@partner.org"], errSpam)
+	})
+
+	t.Run("forged organizer", func(t *testing.T) {
+		msg := newSchedulingMessage("REQUEST", "mailto:bob@example.com", "mailto:alice@example.com")
+		_, err := v.Validate("alice", msg, []string{"mailto:alice@example.com"})
+		assert.ErrorIs(t, err, ErrScheduleOriginator)
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		msg := newSchedulingMessage("REPLY", "mailto:alice@example.com", "mailto:bob@example.com")
+		out, err := v.Validate("bob", msg, []string{"mailto:alice@example.com", "mailto:carol@partner.org"})
+		require.NoError(t, err)
+		assert.Equal(t, "mailto:bob@example.com", out.Originator)
+		assert.Equal(t, []string{"mailto:alice@example.com"}, out.Recipients)
+		assert.ErrorIs(t, out.Rejected["mailto:carol@partner.org"], ErrScheduleRecipient)
+
+		// Attendees can only answer for themselves
+		_, err = v.Validate("alice", newSchedulingMessage("REPLY", "mailto:carol@partner.org", "mailto:bob@example.com"), nil)
+		assert.ErrorIs(t, err, ErrScheduleOriginator)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, msg := range map[string]*ical.Calendar{
+			"no method":    newSchedulingMessage("", "mailto:alice@example.com"),
+			"bad method":   newSchedulingMessage("SPAM", "mailto:alice@example.com"),
+			"no organizer": newSchedulingMessage("REQUEST", ""),
+			"no component": ical.NewCalendar(),
+		} {
+			if name == "no component" {
+				msg.Props.SetText(ical.PropMethod, "REQUEST")
+			}
+			_, err := v.Validate("alice", msg, nil)
+			assert.ErrorIs(t, err, ErrScheduleInvalid, name)
+		}
+	})
+}
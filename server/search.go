@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/search"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// handleSearch answers the DAV:search REPORT through storage.SearchableStorage.
+// On a collection the search is limited to that calendar; on the home set it
+// covers all of the user's calendars.
+func (h *CaldavHandler) handleSearch(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	if h.objectView != nil {
+		// Free-text matches would reveal what redacted objects contain
+		h.writeError(w, r, http.StatusForbidden, CodeSearchUnavailable)
+		return
+	}
+
+	searchable, ok := storage.As[storage.SearchableStorage](h.Storage)
+	if !ok {
+		h.Logger.Warn("search report requested but storage is not searchable")
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedReport)
+		return
+	}
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.Error("failed to read request body",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeReadBody)
+		return
+	}
+	req, query, err := search.ParseRequest(string(bodyBytes))
+	if err != nil {
+		h.Logger.Warn("error parsing search request",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
+		return
+	}
+
+	opts := storage.SearchOptions{Components: query.Components, Limit: query.Limit}
+	switch ctx.Resource.ResourceType {
+	case storage.ResourceCollection:
+		opts.CalendarIDs = []string{ctx.Resource.CalendarID}
+	case storage.ResourceHomeSet:
+	default:
+		h.Logger.Warn("unsupported resource type for search",
+			"type", ctx.Resource.ResourceType)
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedResource)
+		return
+	}
+
+	objects, err := searchable.SearchObjects(ctx.Resource.UserID, query.Text, opts)
+	if err != nil {
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+	if query.Limit > 0 && len(objects) > query.Limit {
+		objects = objects[:query.Limit]
+	}
+	h.Logger.Debug("search completed",
+		"user_id", ctx.Resource.UserID,
+		"results", len(objects))
+
+	var docs []*etree.Document
+	for _, object := range objects {
+		objRes, err := h.URLConverter.ParsePath(object.Path)
+		if err != nil || objRes.ResourceType != storage.ResourceObject {
+			h.Logger.Warn("skipping search result with unparsable path",
+				"path", object.Path,
+				"error", err)
+			continue
+		}
+		objRes.URI = object.Path
+		doc, err := h.handlePropfindObjectWithObject(req, objRes, object)
+		if err != nil {
+			h.writeStorageError(w, r, err, CodeStorage)
+			return
+		}
+		docs = append(docs, doc)
+	}
+
+	h.writeMultistatus(w, r, docs)
+}
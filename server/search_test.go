@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// searchStorage records the last search and returns canned results.
+type searchStorage struct {
+	*storage.MockStorage
+	results []storage.CalendarObject
+	query   string
+	opts    storage.SearchOptions
+}
+
+func (s *searchStorage) SearchObjects(userID, query string, opts storage.SearchOptions) ([]storage.CalendarObject, error) {
+	s.query, s.opts = query, opts
+	return s.results, nil
+}
+
+func TestHandleSearch(t *testing.T) {
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	store := &searchStorage{MockStorage: &storage.MockStorage{}, results: []storage.CalendarObject{
+		storage.NewMockEvent("/caldav/alice/cal/work/a.ics", "a", "Standup", start, start.Add(time.Hour)),
+		storage.NewMockEvent("/caldav/alice/cal/home/b.ics", "b", "Standup at home", start, start.Add(time.Hour)),
+	}}
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<D:search xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <D:text>standup</D:text>
+  <C:comp name="VEVENT"/>
+  <D:limit><D:nresults>1</D:nresults></D:limit>
+</D:search>`
+
+	// Home set: all calendars, limit enforced even if the backend returns more
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", ResourceType: storage.ResourceHomeSet}}
+	w := httptest.NewRecorder()
+	h.handleSearch(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/", strings.NewReader(body)), ctx)
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:href>/caldav/alice/cal/work/a.ics</d:href>")
+	assert.NotContains(t, w.Body.String(), "b.ics")
+	assert.Equal(t, "standup", store.query)
+	assert.Equal(t, storage.SearchOptions{Components: []string{"VEVENT"}, Limit: 1}, store.opts)
+
+	// Collection: scoped to that calendar
+	ctx = &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}}
+	w = httptest.NewRecorder()
+	h.handleSearch(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/work/", strings.NewReader(body)), ctx)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, []string{"work"}, store.opts.CalendarIDs)
+}
+
+func TestHandleSearchWithoutSearchableStorage(t *testing.T) {
+	h := NewCaldavHandler("/caldav/", "Test Realm", &storage.MockStorage{}, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", ResourceType: storage.ResourceHomeSet}}
+
+	w := httptest.NewRecorder()
+	h.handleSearch(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/", strings.NewReader(`<D:search xmlns:D="DAV:"><D:text>x</D:text></D:search>`)), ctx)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
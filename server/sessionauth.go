@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ErrCrossSiteRequest is returned by SessionAuthenticator for state-changing
+// requests authenticated by a cookie that don't come from an allowed origin.
+// The handler answers them with 403 instead of 401.
+var ErrCrossSiteRequest = errors.New("cross-site request rejected")
+
+// SessionAuthenticator lets a web UI share the handler with native clients.
+// It authenticates requests carrying a session cookie or an
+// "Authorization: Bearer" token, both checked by Validate; other requests
+// fall through to the next authenticator or Basic authentication.
+//
+// Browsers attach cookies to cross-site requests, so cookie-authenticated
+// requests with state-changing methods (PUT, DELETE, POST, PROPPATCH, ...)
+// must come from the handler's own origin or one of AllowedOrigins, judged by
+// the Sec-Fetch-Site and Origin headers. Bearer tokens aren't sent
+// automatically and skip that check.
+type SessionAuthenticator struct {
+	// CookieName is the session cookie; "session" when empty
+	CookieName string
+	// Validate maps a session cookie value or bearer token to a user ID, and
+	// returns an error for unknown or expired sessions
+	Validate func(r *http.Request, token string) (string, error)
+	// AllowedOrigins lists origins besides the request's own, such as
+	// "https://calendar.example.com", that may send state-changing requests
+	AllowedOrigins []string
+}
+
+// Authenticate implements Authenticator.
+func (a *SessionAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.Validate(r, strings.TrimSpace(token))
+	}
+
+	name := a.CookieName
+	if name == "" {
+		name = "session"
+	}
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return "", ErrNoCredentials
+	}
+	if !safeMethods[r.Method] && !a.sameOrigin(r) {
+		return "", ErrCrossSiteRequest
+	}
+	return a.Validate(r, cookie.Value)
+}
+
+// sameOrigin reports whether r comes from the handler's own origin or an
+// allowed one. Requests without either header are refused, since every
+// current browser sends at least one of them.
+func (a *SessionAuthenticator) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin != "" && origin != "null" {
+		return origin == requestOrigin(r) || slices.Contains(a.AllowedOrigins, origin)
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	}
+	return false
+}
+
+// requestOrigin is the origin the request was sent to.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
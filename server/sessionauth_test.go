@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSessionAuthenticator() *SessionAuthenticator {
+	return &SessionAuthenticator{
+		Validate: func(_ *http.Request, token string) (string, error) {
+			if token == "s3cret" {
+				return "alice", nil
+			}
+			return "", errors.New("unknown session")
+		},
+		AllowedOrigins: []string{"https://calendar.example.com"},
+	}
+}
+
+func sessionRequest(method string, header map[string]string) *http.Request {
+	r := httptest.NewRequest(method, "http://dav.example.com/caldav/alice/cal/work/a.ics", strings.NewReader(""))
+	r.AddCookie(&http.Cookie{Name: "session", Value: "s3cret"})
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func TestSessionAuthenticator(t *testing.T) {
+	a := newSessionAuthenticator()
+
+	for name, tc := range map[string]struct {
+		method string
+		header map[string]string
+		err    error
+	}{
+		"safe method from anywhere": {"PROPFIND", map[string]string{"Origin": "https://evil.example"}, nil},
+		"same origin":               {"PUT", map[string]string{"Origin": "http://dav.example.com"}, nil},
+		"allowed origin":            {"DELETE", map[string]string{"Origin": "https://calendar.example.com"}, nil},
+		"fetch metadata":            {"PUT", map[string]string{"Sec-Fetch-Site": "same-origin"}, nil},
+		"cross origin":              {"PUT", map[string]string{"Origin": "https://evil.example"}, ErrCrossSiteRequest},
+		"cross site fetch":          {"POST", map[string]string{"Sec-Fetch-Site": "cross-site"}, ErrCrossSiteRequest},
+		"no origin information":     {"DELETE", nil, ErrCrossSiteRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			userID, err := a.Authenticate(sessionRequest(tc.method, tc.header))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "alice", userID)
+		})
+	}
+}
+
+func TestSessionAuthenticatorBearer(t *testing.T) {
+	a := newSessionAuthenticator()
+
+	// Bearer tokens aren't attached by browsers, so there's no origin check
+	r := httptest.NewRequest("DELETE", "/caldav/alice/cal/work/a.ics", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	userID, err := a.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", userID)
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	_, err = a.Authenticate(r)
+	assert.Error(t, err)
+
+	r = httptest.NewRequest("DELETE", "/caldav/alice/cal/work/a.ics", nil)
+	r.SetBasicAuth("alice", "password")
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestHandlerWithSessionAuthenticator(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Locks = NewLockTable()
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{Path: "/alice/cal/work/a.ics", ETag: "etag"}, nil)
+	mockStorage.On("GetObject", "alice", "work", "new.ics").Return(nil, storage.ErrNotFound)
+	h.Authenticators = []Authenticator{newSessionAuthenticator()}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, sessionRequest("PROPFIND", nil))
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, sessionRequest("DELETE", map[string]string{"Origin": "https://evil.example"}))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	r := sessionRequest("PROPFIND", nil)
+	r.Header.Set("Cookie", "session=expired")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// ShareTokenParam is the query parameter carrying a share link token.
+const ShareTokenParam = "token"
+
+var (
+	errShareLinksUnsupported = errors.New("storage does not support share links")
+	errSharingDisabled       = errors.New("sharing is disabled by Features")
+)
+
+// CreateShareLink creates a link to one of userID's calendars and returns
+// its URL, the calendar's path with the token as ShareTokenParam. A busyOnly
+// link only serves event times. A ttl of 0 makes a link that never expires.
+func (h *CaldavHandler) CreateShareLink(userID, calendarID string, busyOnly bool, ttl time.Duration) (string, *storage.ShareLink, error) {
+	if !h.withConfig().features().EnableSharing {
+		return "", nil, errSharingDisabled
+	}
+	links, ok := storage.As[storage.ShareLinkStorage](h.Storage)
+	if !ok {
+		return "", nil, errShareLinksUnsupported
+	}
+	if _, err := h.Storage.GetCalendar(userID, calendarID); err != nil {
+		return "", nil, fmt.Errorf("failed to get calendar %q: %w", calendarID, err)
+	}
+	path, err := h.URLConverter.EncodePath(Resource{UserID: userID, CalendarID: calendarID, ResourceType: storage.ResourceCollection})
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	now := time.Now().UTC()
+	link := storage.ShareLink{
+		Token:      base64.RawURLEncoding.EncodeToString(secret),
+		UserID:     userID,
+		CalendarID: calendarID,
+		BusyOnly:   busyOnly,
+		CreatedAt:  now,
+	}
+	if ttl > 0 {
+		link.ExpiresAt = now.Add(ttl)
+	}
+	if err := links.CreateShareLink(link); err != nil {
+		return "", nil, fmt.Errorf("failed to store share link: %w", err)
+	}
+	return path + "?" + url.Values{ShareTokenParam: {link.Token}}.Encode(), &link, nil
+}
+
+// RevokeShareLink deletes a share link, so its URL stops working.
+func (h *CaldavHandler) RevokeShareLink(token string) error {
+	links, ok := storage.As[storage.ShareLinkStorage](h.Storage)
+	if !ok {
+		return errShareLinksUnsupported
+	}
+	return links.DeleteShareLink(token)
+}
+
+// checkShareLink looks up the share link token of a request. It returns nil
+// when the request has none or the storage has no share links, and false
+// after answering the request itself. Unknown, revoked and expired tokens
+// all get 404, so links can't be probed.
+func (h *CaldavHandler) checkShareLink(w http.ResponseWriter, r *http.Request) (*storage.ShareLink, bool) {
+	token := r.URL.Query().Get(ShareTokenParam)
+	links, ok := storage.As[storage.ShareLinkStorage](h.Storage)
+	if token == "" || !ok || !h.features().EnableSharing {
+		return nil, true
+	}
+	link, err := links.GetShareLink(token)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && (link == nil || link.Expired(time.Now()))) {
+		h.Logger.Info("unknown or expired share link")
+		h.writeError(w, r, http.StatusNotFound, CodeShareNotFound)
+		return nil, false
+	} else if err != nil {
+		h.Logger.Error("failed to get share link",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return nil, false
+	}
+	h.Logger.Info("serving share link",
+		"user_id", link.UserID,
+		"calendar_id", link.CalendarID,
+		"busy_only", link.BusyOnly)
+	return link, true
+}
+
+// shareAllows reports whether a share link covers a request: reading the
+// calendar and its objects. Busy-only links see them through busyView.
+func shareAllows(link *storage.ShareLink, method string, res Resource) bool {
+	if res.UserID != link.UserID || res.CalendarID != link.CalendarID {
+		return false
+	}
+	return safeMethods[method] && (res.ResourceType == storage.ResourceCollection || res.ResourceType == storage.ResourceObject)
+}
+
+// handleCalendarFeed answers GET on a calendar shared by a link with the
+// whole calendar as one iCalendar file, as subscription clients expect.
+func (h *CaldavHandler) handleCalendarFeed(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	cal, err := h.Storage.GetCalendar(ctx.Resource.UserID, ctx.Resource.CalendarID)
+	if err != nil || cal == nil {
+		h.Logger.Error("failed to retrieve calendar collection",
+			"error", err,
+			"calendar_id", ctx.Resource.CalendarID)
+		h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+	etag := ""
+	if cal.CTag != "" {
+		etag = `"` + strings.Trim(cal.CTag, `"`) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	feed := ical.NewCalendar()
+	if cal.CalendarData != nil {
+		for name, props := range cal.CalendarData.Props {
+			feed.Props[name] = props
+		}
+	}
+	if feed.Props.Get(ical.PropProductID) == nil {
+		feed.Props.SetText(ical.PropProductID, "-//libcaldora//NONSGML v1.0//EN")
+	}
+	if feed.Props.Get(ical.PropVersion) == nil {
+		feed.Props.SetText(ical.PropVersion, "2.0")
+	}
+
+	timezones := map[string]bool{}
+	err = h.forEachObject(ctx.Resource.UserID, ctx.Resource.CalendarID, func(obj storage.CalendarObject) error {
+		view := h.view(&obj)
+		if view == nil {
+			return nil
+		}
+		for _, comp := range view.Component {
+			if comp == nil {
+				continue
+			}
+			if comp.Name == ical.CompTimezone {
+				tzid := comp.Props.Get(ical.PropTimezoneID)
+				if tzid == nil || timezones[tzid.Value] {
+					continue
+				}
+				timezones[tzid.Value] = true
+			}
+			if comp.Name == ical.CompEvent && comp.Props.Get(ical.PropDateTimeStamp) == nil {
+				// DTSTAMP is required for encoding; set it on a copy
+				stamped := *comp
+				stamped.Props = make(ical.Props, len(comp.Props)+1)
+				for name, props := range comp.Props {
+					stamped.Props[name] = props
+				}
+				stamped.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+				comp = &stamped
+			}
+			feed.Children = append(feed.Children, comp)
+		}
+		return nil
+	})
+	if err != nil {
+		h.Logger.Error("failed to list calendar objects",
+			"calendar_id", ctx.Resource.CalendarID,
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeStorage)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(feed); err != nil {
+		h.Logger.Error("failed to encode calendar feed",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeEncodeFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shareStorage keeps share links in a map.
+type shareStorage struct {
+	*storage.MockStorage
+	links map[string]storage.ShareLink
+}
+
+func (s *shareStorage) CreateShareLink(link storage.ShareLink) error {
+	s.links[link.Token] = link
+	return nil
+}
+
+func (s *shareStorage) GetShareLink(token string) (*storage.ShareLink, error) {
+	link, ok := s.links[token]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &link, nil
+}
+
+func (s *shareStorage) ListShareLinks(userID, calendarID string) ([]storage.ShareLink, error) {
+	var links []storage.ShareLink
+	for _, link := range s.links {
+		if link.UserID == userID && link.CalendarID == calendarID {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (s *shareStorage) DeleteShareLink(token string) error {
+	if _, ok := s.links[token]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.links, token)
+	return nil
+}
+
+func newShareTestHandler(t *testing.T) (*CaldavHandler, *shareStorage) {
+	mockStorage := &storage.MockStorage{}
+	store := &shareStorage{MockStorage: mockStorage, links: map[string]storage.ShareLink{}}
+	h := NewCaldavHandler("/caldav/", "Test Realm", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	meeting := storage.NewMockEvent("/caldav/alice/cal/work/a.ics", "a", "Salary review", start, start.Add(time.Hour))
+	meeting.Component[0].Props.SetText(ical.PropDescription, "Confidential")
+	free := storage.NewMockEvent("/caldav/alice/cal/work/b.ics", "b", "Focus time", start, start.Add(time.Hour))
+	free.Component[0].Props.SetText(ical.PropTransparency, "TRANSPARENT")
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/caldav/alice/cal/work", CTag: "42"}, nil)
+	mockStorage.On("GetObjectsInCollection", "work").Return([]storage.CalendarObject{meeting, free}, nil)
+	return h, store
+}
+
+func TestShareLinkFeed(t *testing.T) {
+	h, store := newShareTestHandler(t)
+	href, link, err := h.CreateShareLink("alice", "work", false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "/caldav/alice/cal/work?token="+link.Token, href)
+	assert.Len(t, store.links, 1)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, href, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"42"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), "SUMMARY:Salary review")
+	assert.Contains(t, w.Body.String(), "SUMMARY:Focus time")
+
+	// Subscribers polling an unchanged calendar
+	r := httptest.NewRequest(http.MethodGet, href, nil)
+	r.Header.Set("If-None-Match", `"42"`)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// Revoked links stop working
+	require.NoError(t, h.RevokeShareLink(link.Token))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, href, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestShareLinkBusyOnly(t *testing.T) {
+	h, _ := newShareTestHandler(t)
+	href, _, err := h.CreateShareLink("alice", "work", true, time.Hour)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, href, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "SUMMARY:Busy")
+	assert.Contains(t, body, "DTSTART:20250101T090000Z")
+	assert.NotContains(t, body, "Salary review")
+	assert.NotContains(t, body, "Confidential")
+	// Transparent events don't block time
+	assert.NotContains(t, body, "UID:b")
+
+	// Busy-only links can't write either
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, strings.Replace(href, "work?", "work/c.ics?", 1), strings.NewReader("")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestShareLinkScope(t *testing.T) {
+	h, store := newShareTestHandler(t)
+	_, link, err := h.CreateShareLink("alice", "work", false, 0)
+	require.NoError(t, err)
+	query := "?token=" + link.Token
+
+	for name, tc := range map[string]struct{ method, path string }{
+		"write":          {http.MethodPut, "/caldav/alice/cal/work/c.ics"},
+		"delete":         {http.MethodDelete, "/caldav/alice/cal/work/a.ics"},
+		"other calendar": {http.MethodGet, "/caldav/alice/cal/home"},
+		"home set":       {"PROPFIND", "/caldav/alice/cal/"},
+		"principal":      {"PROPFIND", "/caldav/alice/"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path+query, strings.NewReader("")))
+			assert.Equal(t, http.StatusForbidden, w.Code)
+		})
+	}
+
+	// Expired links are treated like unknown ones
+	expired := *link
+	expired.Token, expired.ExpiresAt = "expired", time.Now().Add(-time.Minute)
+	store.links[expired.Token] = expired
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/caldav/alice/cal/work?token=expired", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCreateShareLinkUnsupported(t *testing.T) {
+	h := NewCaldavHandler("/caldav/", "Test Realm", &storage.MockStorage{}, 1, nil, nil)
+	_, _, err := h.CreateShareLink("alice", "work", false, 0)
+	assert.Error(t, err)
+}
+
+func TestShareLinkPrivileges(t *testing.T) {
+	h, store := newShareTestHandler(t)
+	h.CrossCollectionMultiget = true
+	href, _, err := h.CreateShareLink("alice", "work", false, 0)
+	require.NoError(t, err)
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	meeting := storage.NewMockEvent("/caldav/alice/cal/work/a.ics", "a", "Salary review", start, start.Add(time.Hour))
+	store.On("GetObject", "alice", "work", "a.ics").Return(&meeting, nil)
+
+	// Link requests don't run as the owner and only read
+	var seen RequestContext
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, _ := RequestContextFrom(r.Context())
+			seen = *ctx
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	body := `<?xml version="1.0"?><C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop>` +
+		`<D:href>/caldav/alice/cal/work/a.ics</D:href>` +
+		`<D:href>/caldav/alice/cal/home/b.ics</D:href>` +
+		`<D:href>/caldav/alice/</D:href>` +
+		`</C:calendar-multiget>`
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("REPORT", href, strings.NewReader(body)))
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Empty(t, seen.AuthUser)
+	assert.Equal(t, storage.PrivilegeRead, seen.Privileges)
+
+	// Hrefs outside the shared calendar are refused like request URLs
+	responses := strings.Split(w.Body.String(), "<d:response>")
+	require.Len(t, responses, 4)
+	assert.Contains(t, responses[1], "HTTP/1.1 200 OK")
+	assert.Contains(t, responses[2], "/caldav/alice/cal/home/b.ics")
+	assert.Contains(t, responses[2], "HTTP/1.1 403 Forbidden")
+	assert.Contains(t, responses[3], "HTTP/1.1 403 Forbidden")
+	store.AssertNotCalled(t, "GetObject", "alice", "home", "b.ics")
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// lifecycle tracks the requests a handler is serving, so Shutdown can wait
+// for them. It is shared by the per-request copies of the handler.
+type lifecycle struct {
+	mu       sync.Mutex
+	closing  bool
+	inflight sync.WaitGroup
+}
+
+// enter registers a request, or reports false once Shutdown was called.
+func (l *lifecycle) enter() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		return false
+	}
+	l.inflight.Add(1)
+	return true
+}
+
+// leave unregisters a request registered with enter.
+func (l *lifecycle) leave() {
+	if l != nil {
+		l.inflight.Done()
+	}
+}
+
+// drain refuses further requests and waits for those in flight, until ctx
+// is done.
+func (l *lifecycle) drain(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	l.closing = true
+	l.mu.Unlock()
+	return waitContext(ctx, &l.inflight)
+}
+
+// waitContext waits for wg or ctx, whichever is done first.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops the handler for a clean exit. Requests arriving after it is
+// called are answered with 503. It waits for the requests in flight, such as
+// long REPORTs, stops Jobs and waits for them, and for the Warmer to finish
+// warming objects written by them, then closes Recurrence, stopping its
+// cache cleanup. If ctx is done
+// first, Shutdown returns its error and leaves Recurrence open.
+//
+// Call it after http.Server.Shutdown, which stops accepting connections but
+// doesn't wait for handlers. Only handlers made with NewCaldavHandler wait
+// for their requests.
+func (h *CaldavHandler) Shutdown(ctx context.Context) error {
+	if err := h.lifecycle.drain(ctx); err != nil {
+		return err
+	}
+	if h.Jobs != nil {
+		if err := h.Jobs.Stop(ctx); err != nil {
+			return err
+		}
+	}
+	if h.Warmer != nil {
+		if err := waitContext(ctx, &h.Warmer.background); err != nil {
+			return err
+		}
+	}
+	if h.Recurrence != nil {
+		h.Recurrence.Close()
+	}
+	return nil
+}
+
+// refuseShuttingDown answers a request arriving after Shutdown.
+func (h *CaldavHandler) refuseShuttingDown(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	h.setRetryAfter(w)
+	h.writeError(w, r, http.StatusServiceUnavailable, CodeShuttingDown)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler returns a handler whose requests wait for release, and a
+// channel receiving a value once each request started.
+func blockingHandler(t *testing.T) (h *CaldavHandler, started chan struct{}, release chan struct{}) {
+	h, _ = newTestHandler(&storage.Calendar{})
+	started, release = make(chan struct{}), make(chan struct{})
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			next.ServeHTTP(w, r)
+		})
+	})
+	return h, started, release
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	h, started, release := blockingHandler(t)
+	h.Recurrence = recurrence.NewEngine()
+
+	inflight := make(chan int)
+	go func() {
+		inflight <- serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil).Code
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- h.Shutdown(context.Background()) }()
+	require.Eventually(t, func() bool {
+		w := serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil)
+		return w.Code == http.StatusServiceUnavailable
+	}, time.Second, time.Millisecond, "new requests are refused")
+	w := serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil)
+	assert.Equal(t, string(CodeShuttingDown), w.Header().Get("Libcaldora-Error"))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with a request in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	assert.Equal(t, http.StatusOK, <-inflight, "the request in flight completes")
+	assert.NoError(t, <-shutdown)
+	assert.NoError(t, h.Shutdown(context.Background()), "Shutdown can be called again")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	h, started, release := blockingHandler(t)
+	defer close(release)
+	go serveAlice(h, http.MethodOptions, "/caldav/alice/cal/work/", "", nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, h.Shutdown(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// ImportOptions controls how ImportUser writes a snapshot into storage.
+type ImportOptions struct {
+	// KeepETags passes the archived ETags to UpdateObject so backends that honor
+	// caller-provided ETags keep them stable across the migration.
+	KeepETags bool
+	// SkipExistingCalendars imports objects into calendars that already exist
+	// in the target instead of failing with storage.ErrConflict.
+	SkipExistingCalendars bool
+}
+
+// ExportUser builds a portable snapshot of every calendar and object owned by userID.
+// Calendar and object IDs are derived from storage paths with the handler's URLConverter.
+func (h *CaldavHandler) ExportUser(userID string) (*storage.Snapshot, error) {
+	snap := &storage.Snapshot{
+		Version:    storage.SnapshotVersion,
+		ExportedAt: time.Now().UTC(),
+		UserID:     userID,
+	}
+
+	user, err := h.Storage.GetUser(userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get user %q: %w", userID, err)
+	}
+	snap.User = user
+
+	calendars, err := h.Storage.GetUserCalendars(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calendars of %q: %w", userID, err)
+	}
+
+	for _, cal := range calendars {
+		res, err := h.URLConverter.ParsePath(cal.Path)
+		if err != nil || res.ResourceType != storage.ResourceCollection {
+			h.Logger.Warn("skipping calendar with unparsable path during export",
+				"path", cal.Path,
+				"error", err)
+			continue
+		}
+
+		snapCal := storage.SnapshotCalendar{
+			CalendarID:          res.CalendarID,
+			Path:                cal.Path,
+			ReadOnly:            cal.ReadOnly,
+			Archived:            cal.Archived,
+			Hidden:              cal.Hidden,
+			CTag:                cal.CTag,
+			ETag:                cal.ETag,
+			SupportedComponents: cal.SupportedComponents,
+			Objects:             []storage.SnapshotObject{},
+		}
+		if cal.CalendarData != nil {
+			snapCal.Data = cal.CalendarData.Component
+		}
+
+		err = h.forEachObject(userID, res.CalendarID, func(obj storage.CalendarObject) error {
+			objRes, err := h.URLConverter.ParsePath(obj.Path)
+			if err != nil || objRes.ResourceType != storage.ResourceObject {
+				h.Logger.Warn("skipping object with unparsable path during export",
+					"path", obj.Path,
+					"error", err)
+				return nil
+			}
+			ics, err := storage.ICalCompToICS(obj.Component, false)
+			if err != nil {
+				return fmt.Errorf("failed to serialize object %q: %w", obj.Path, err)
+			}
+			snapCal.Objects = append(snapCal.Objects, storage.SnapshotObject{
+				ObjectID:     objRes.ObjectID,
+				Path:         obj.Path,
+				ETag:         obj.ETag,
+				LastModified: obj.LastModified,
+				ICS:          ics,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to export objects of calendar %q: %w", res.CalendarID, err)
+		}
+
+		snap.Calendars = append(snap.Calendars, snapCal)
+	}
+
+	h.Logger.Info("exported user snapshot",
+		"user_id", userID,
+		"calendars", len(snap.Calendars))
+	return snap, nil
+}
+
+// ImportUser writes the snapshot's calendars and objects into the handler's storage
+// under userID. The user must already exist in the target backend. Paths are
+// re-encoded with the handler's URLConverter, so snapshots can move between
+// deployments with different URL layouts. Objects without an ObjectID are
+// named by h.ObjectIDs.
+func (h *CaldavHandler) ImportUser(userID string, snap *storage.Snapshot, opts ImportOptions) error {
+	if snap == nil {
+		return fmt.Errorf("%w: nil snapshot", storage.ErrInvalidInput)
+	}
+
+	for _, snapCal := range snap.Calendars {
+		calPath, err := h.URLConverter.EncodePath(Resource{
+			UserID:       userID,
+			CalendarID:   snapCal.CalendarID,
+			ResourceType: storage.ResourceCollection,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode path for calendar %q: %w", snapCal.CalendarID, err)
+		}
+
+		cal := &storage.Calendar{
+			Path:                calPath,
+			ReadOnly:            snapCal.ReadOnly,
+			Archived:            snapCal.Archived,
+			Hidden:              snapCal.Hidden,
+			CTag:                snapCal.CTag,
+			ETag:                snapCal.ETag,
+			SupportedComponents: snapCal.SupportedComponents,
+			CalendarData:        ical.NewCalendar(),
+		}
+		if snapCal.Data != nil {
+			cal.CalendarData.Component = snapCal.Data
+		}
+
+		if err := h.Storage.CreateCalendar(userID, cal); err != nil {
+			if !errors.Is(err, storage.ErrConflict) || !opts.SkipExistingCalendars {
+				return fmt.Errorf("failed to create calendar %q: %w", snapCal.CalendarID, err)
+			}
+			h.Logger.Info("calendar already exists, importing objects only",
+				"user_id", userID,
+				"calendar_id", snapCal.CalendarID)
+		}
+
+		for _, snapObj := range snapCal.Objects {
+			components, err := storage.ICSToICalComp(snapObj.ICS)
+			if err != nil {
+				return fmt.Errorf("failed to parse object %q: %w", snapObj.ObjectID, err)
+			}
+			// Snapshots built by hand may leave names to the server
+			objectID := snapObj.ObjectID
+			if objectID == "" {
+				if objectID, err = h.newObjectID(userID, snapCal.CalendarID, components); err != nil {
+					return fmt.Errorf("failed to name object of calendar %q: %w", snapCal.CalendarID, err)
+				}
+			}
+			objPath, err := h.URLConverter.EncodePath(Resource{
+				UserID:       userID,
+				CalendarID:   snapCal.CalendarID,
+				ObjectID:     objectID,
+				ResourceType: storage.ResourceObject,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to encode path for object %q: %w", objectID, err)
+			}
+			obj := &storage.CalendarObject{
+				Path:         objPath,
+				LastModified: snapObj.LastModified,
+				Component:    components,
+			}
+			if opts.KeepETags {
+				obj.ETag = snapObj.ETag
+			}
+			etag, err := h.Storage.UpdateObject(userID, snapCal.CalendarID, obj)
+			if err != nil {
+				return fmt.Errorf("failed to store object %q: %w", objectID, err)
+			}
+			h.objectChanged(ObjectChange{UserID: userID, CalendarID: snapCal.CalendarID, ObjectID: objectID, ETag: etag})
+		}
+	}
+
+	h.Logger.Info("imported user snapshot",
+		"user_id", userID,
+		"source_user_id", snap.UserID,
+		"calendars", len(snap.Calendars))
+	return nil
+}
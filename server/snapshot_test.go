@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportUser(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Source deployment uses a different prefix than the target one
+	src := &storage.MockStorage{}
+	srcHandler := NewCaldavHandler("/old/", "Test Realm", src, 1, nil, logger)
+
+	cal := storage.NewMockCalendar("/old/alice/cal/work", "Work", "Work Calendar")
+	cal.SupportedComponents = []string{"VEVENT"}
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	event := storage.NewMockEvent("/old/alice/cal/work/event1.ics", "uid-1", "Standup", start, start.Add(time.Hour))
+
+	src.On("GetUser", "alice").Return(&storage.User{DisplayName: "Alice"}, nil)
+	src.On("GetUserCalendars", "alice").Return([]storage.Calendar{cal}, nil)
+	src.On("GetObjectsInCollection", "work").Return([]storage.CalendarObject{event}, nil)
+
+	snap, err := srcHandler.ExportUser("alice")
+	require.NoError(t, err)
+	require.Len(t, snap.Calendars, 1)
+	assert.Equal(t, "work", snap.Calendars[0].CalendarID)
+	require.Len(t, snap.Calendars[0].Objects, 1)
+	assert.Equal(t, "event1.ics", snap.Calendars[0].Objects[0].ObjectID)
+	assert.Equal(t, "etag-uid-1-1", snap.Calendars[0].Objects[0].ETag)
+
+	// Round-trip through the archive format
+	var buf bytes.Buffer
+	require.NoError(t, storage.WriteSnapshot(&buf, snap))
+	decoded, err := storage.ReadSnapshot(&buf)
+	require.NoError(t, err)
+
+	dst := &storage.MockStorage{}
+	dstHandler := NewCaldavHandler("/caldav/", "Test Realm", dst, 1, nil, logger)
+
+	dst.On("CreateCalendar", "bob", mock.MatchedBy(func(c *storage.Calendar) bool {
+		name, _ := c.CalendarData.Props.Text(ical.PropName)
+		return c.Path == "/caldav/bob/cal/work" && name == "Work"
+	})).Return(nil).Once()
+	dst.On("UpdateObject", "bob", "work", mock.MatchedBy(func(o *storage.CalendarObject) bool {
+		if o.Path != "/caldav/bob/cal/work/event1.ics" || o.ETag != "etag-uid-1-1" || len(o.Component) != 1 {
+			return false
+		}
+		uid, _ := o.Component[0].Props.Text(ical.PropUID)
+		return uid == "uid-1"
+	})).Return("etag-uid-1-1", nil).Once()
+
+	err = dstHandler.ImportUser("bob", decoded, ImportOptions{KeepETags: true})
+	require.NoError(t, err)
+	dst.AssertExpectations(t)
+}
+
+func TestImportUserExistingCalendar(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dst := &storage.MockStorage{}
+	h := NewCaldavHandler("/caldav/", "Test Realm", dst, 1, nil, logger)
+
+	snap := &storage.Snapshot{
+		Version:   storage.SnapshotVersion,
+		Calendars: []storage.SnapshotCalendar{{CalendarID: "work"}},
+	}
+	dst.On("CreateCalendar", "alice", mock.Anything).Return(storage.ErrConflict)
+
+	err := h.ImportUser("alice", snap, ImportOptions{})
+	assert.ErrorIs(t, err, storage.ErrConflict)
+
+	err = h.ImportUser("alice", snap, ImportOptions{SkipExistingCalendars: true})
+	assert.NoError(t, err)
+}
+
+func TestImportUserNamesObjects(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dst := &storage.MockStorage{}
+	h := NewCaldavHandler("/caldav/", "Test Realm", dst, 1, nil, logger)
+	h.ObjectIDs = HashObjectID
+
+	ics := "BEGIN:VEVENT\r\nUID:uid-1\r\nDTSTAMP:20250101T000000Z\r\nDTSTART:20250303T090000Z\r\nEND:VEVENT"
+	snap := &storage.Snapshot{
+		Version:   storage.SnapshotVersion,
+		Calendars: []storage.SnapshotCalendar{{CalendarID: "work", Objects: []storage.SnapshotObject{{ICS: ics}}}},
+	}
+	name := HashObjectID(eventWithUID("uid-1"))
+	dst.On("CreateCalendar", "alice", mock.Anything).Return(nil)
+	dst.On("GetObject", "alice", "work", name).Return(nil, storage.ErrNotFound)
+	dst.On("UpdateObject", "alice", "work", mock.MatchedBy(func(obj *storage.CalendarObject) bool {
+		return obj.Path == "/caldav/alice/cal/work/"+name
+	})).Return("etag", nil).Once()
+
+	require.NoError(t, h.ImportUser("alice", snap, ImportOptions{}))
+	dst.AssertExpectations(t)
+}
+
+func TestReadSnapshotRejectsUnknownVersion(t *testing.T) {
+	_, err := storage.ReadSnapshot(bytes.NewBufferString(`{"version": 99}`))
+	assert.ErrorIs(t, err, storage.ErrInvalidInput)
+}
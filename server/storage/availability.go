@@ -0,0 +1,15 @@
+package storage
+
+import "github.com/emersion/go-ical"
+
+// AvailabilityStorage is an optional extension of Storage for backends that
+// keep a user's published availability (VAVAILABILITY, RFC 7953). When
+// implemented, the principal exposes it as cs:calendar-availability and
+// clients can change it with PROPPATCH.
+type AvailabilityStorage interface {
+	// GetUserAvailability returns a VCALENDAR holding the user's VAVAILABILITY
+	// components, or ErrNotFound when none is published.
+	GetUserAvailability(userID string) (*ical.Calendar, error)
+	// SetUserAvailability replaces the user's availability. A nil calendar removes it.
+	SetUserAvailability(userID string, cal *ical.Calendar) error
+}
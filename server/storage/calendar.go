@@ -0,0 +1,34 @@
+package storage
+
+// MutableCalendarStorage is an optional extension of Storage for backends
+// that can change a calendar collection after it has been created. When
+// implemented, clients can change calendar properties such as g:hidden with
+// PROPPATCH.
+type MutableCalendarStorage interface {
+	// UpdateCalendar replaces the stored properties of an existing calendar
+	// with those of cal. Implementations should refresh cal.ETag, and return
+	// ErrNotFound when the calendar doesn't exist.
+	UpdateCalendar(userID, calendarID string, cal *Calendar) error
+}
+
+// DeletableCalendarStorage is an optional extension of Storage for backends
+// that can remove calendar collections. When implemented, clients can DELETE
+// a calendar, once it's empty unless the handler runs with
+// ForceCalendarDelete.
+type DeletableCalendarStorage interface {
+	// DeleteCalendar removes a calendar and its objects, returning ErrNotFound
+	// when the calendar doesn't exist. Backends keeping a change log record
+	// the objects as deleted, so sync tokens issued before stay valid if a
+	// calendar with the same ID is created later.
+	DeleteCalendar(userID, calendarID string) error
+}
+
+// CTagStorage is an optional extension of Storage for backends that can look
+// up a calendar's CTag without loading the calendar, e.g. from a column. The
+// handler asks for it before listing the objects of a calendar for a Depth: 1
+// PROPFIND, to skip them when the client already has the current CTag.
+type CTagStorage interface {
+	// GetCalendarCTag returns the CTag of a calendar, as Calendar.CTag holds
+	// it, or ErrNotFound when the calendar doesn't exist.
+	GetCalendarCTag(userID, calendarID string) (string, error)
+}
@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// userInterfaces are the optional interfaces CompositeStorage forwards to
+// Default, as they aren't keyed by calendar.
+var userInterfaces = []reflect.Type{
+	reflect.TypeFor[AvailabilityStorage](),
+	reflect.TypeFor[DelegationStorage](),
+	reflect.TypeFor[GroupStorage](),
+	reflect.TypeFor[NotificationStorage](),
+	reflect.TypeFor[ScheduleInboxStorage](),
+	reflect.TypeFor[ShareLinkStorage](),
+	reflect.TypeFor[UserDirectoryStorage](),
+}
+
+// CompositeStorage assembles calendar homes from several backends, e.g.
+// personal calendars in SQL next to read-only holiday calendars computed on
+// the fly. Calendars whose ID starts with a mounted prefix are served by that
+// mount, the longest prefix winning; users and all other calendars by
+// Default.
+//
+// Mounted storages must use calendar IDs starting with their prefix, as
+// requests are routed by ID only. Calendars they list under other IDs are
+// left out of GetUserCalendars.
+//
+// CompositeStorage forwards every optional interface, and reports through
+// Forwards which ones a storage behind it implements, so As finds only
+// those. Interfaces keyed by calendar go to the storage serving the calendar,
+// or to all storages for ContextualStorage, SearchableStorage and
+// TombstoneStorage. Calendars whose storage lacks one behave as read-only,
+// without dead properties, preferences or a change log; CTags, time-range
+// lookups and pages come from the plain Storage methods instead. Interfaces
+// keyed by user, see userInterfaces, go to Default.
+type CompositeStorage struct {
+	Default Storage
+	mounts  []mount
+}
+
+type mount struct {
+	prefix  string
+	storage Storage
+}
+
+// NewCompositeStorage returns a CompositeStorage serving everything from
+// def until storages are mounted.
+func NewCompositeStorage(def Storage) *CompositeStorage {
+	return &CompositeStorage{Default: def}
+}
+
+// Mount serves the calendars whose ID starts with prefix from s. Mount
+// before serving requests; it isn't safe for concurrent use.
+func (c *CompositeStorage) Mount(prefix string, s Storage) {
+	c.mounts = append(c.mounts, mount{prefix: prefix, storage: s})
+}
+
+// route returns the storage serving calendarID.
+func (c *CompositeStorage) route(calendarID string) Storage {
+	if i := c.routeIndex(calendarID); i > 0 {
+		return c.mounts[i-1].storage
+	}
+	return c.Default
+}
+
+// routeIndex returns the index in storages of the storage serving
+// calendarID.
+func (c *CompositeStorage) routeIndex(calendarID string) int {
+	best, longest := 0, -1
+	for i, m := range c.mounts {
+		if strings.HasPrefix(calendarID, m.prefix) && len(m.prefix) > longest {
+			best, longest = i+1, len(m.prefix)
+		}
+	}
+	return best
+}
+
+// storages returns Default followed by the mounted storages.
+func (c *CompositeStorage) storages() []Storage {
+	all := []Storage{c.Default}
+	for _, m := range c.mounts {
+		all = append(all, m.storage)
+	}
+	return all
+}
+
+// Forwards reports whether Default implements iface, for the interfaces
+// keyed by user, or any of the storages does, for the others.
+func (c *CompositeStorage) Forwards(iface reflect.Type) bool {
+	if slices.Contains(userInterfaces, iface) {
+		return implements(c.Default, iface)
+	}
+	for _, s := range c.storages() {
+		if implements(s, iface) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CompositeStorage) GetObjectsInCollection(calendarID string) ([]CalendarObject, error) {
+	return c.route(calendarID).GetObjectsInCollection(calendarID)
+}
+
+func (c *CompositeStorage) GetObjectPathsInCollection(calendarID string) ([]string, error) {
+	return c.route(calendarID).GetObjectPathsInCollection(calendarID)
+}
+
+// GetUserCalendars lists the calendars of Default, then those of each mount
+// in the order they were mounted. A mount without calendars for the user
+// may return ErrNotFound.
+func (c *CompositeStorage) GetUserCalendars(userID string) ([]Calendar, error) {
+	var calendars []Calendar
+	for i, s := range c.storages() {
+		listed, err := s.GetUserCalendars(userID)
+		if i > 0 && errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, cal := range listed {
+			if c.routeIndex(PathSegment(cal.Path)) == i {
+				calendars = append(calendars, cal)
+			}
+		}
+	}
+	return calendars, nil
+}
+
+func (c *CompositeStorage) GetUser(userID string) (*User, error) {
+	return c.Default.GetUser(userID)
+}
+
+func (c *CompositeStorage) AuthUser(username, password string) (string, error) {
+	return c.Default.AuthUser(username, password)
+}
+
+func (c *CompositeStorage) GetCalendar(userID, calendarID string) (*Calendar, error) {
+	return c.route(calendarID).GetCalendar(userID, calendarID)
+}
+
+func (c *CompositeStorage) GetObject(userID, calendarID, objectID string) (*CalendarObject, error) {
+	return c.route(calendarID).GetObject(userID, calendarID, objectID)
+}
+
+func (c *CompositeStorage) GetObjectByFilter(userID, calendarID string, filter *Filter) ([]CalendarObject, error) {
+	return c.route(calendarID).GetObjectByFilter(userID, calendarID, filter)
+}
+
+func (c *CompositeStorage) UpdateObject(userID, calendarID string, object *CalendarObject) (string, error) {
+	return c.route(calendarID).UpdateObject(userID, calendarID, object)
+}
+
+func (c *CompositeStorage) DeleteObject(userID, calendarID, objectID string) error {
+	return c.route(calendarID).DeleteObject(userID, calendarID, objectID)
+}
+
+// CreateCalendar creates the calendar in the storage its ID, the last
+// segment of calendar.Path, is routed to. Calendars without a path are
+// created in Default.
+func (c *CompositeStorage) CreateCalendar(userID string, calendar *Calendar) error {
+	if calendar.Path == "" {
+		return c.Default.CreateCalendar(userID, calendar)
+	}
+	return c.route(PathSegment(calendar.Path)).CreateCalendar(userID, calendar)
+}
+
+// WithContext returns a CompositeStorage of the storages bound to ctx, for
+// those implementing ContextualStorage.
+func (c *CompositeStorage) WithContext(ctx context.Context) Storage {
+	bind := func(s Storage) Storage {
+		if contextual, ok := As[ContextualStorage](s); ok {
+			return contextual.WithContext(ctx)
+		}
+		return s
+	}
+	bound := &CompositeStorage{Default: bind(c.Default)}
+	for _, m := range c.mounts {
+		bound.mounts = append(bound.mounts, mount{prefix: m.prefix, storage: bind(m.storage)})
+	}
+	return bound
+}
+
+func (c *CompositeStorage) UpdateCalendar(userID, calendarID string, cal *Calendar) error {
+	mutable, ok := As[MutableCalendarStorage](c.route(calendarID))
+	if !ok {
+		return ErrPermissionDenied
+	}
+	return mutable.UpdateCalendar(userID, calendarID, cal)
+}
+
+func (c *CompositeStorage) DeleteCalendar(userID, calendarID string) error {
+	deletable, ok := As[DeletableCalendarStorage](c.route(calendarID))
+	if !ok {
+		return ErrPermissionDenied
+	}
+	return deletable.DeleteCalendar(userID, calendarID)
+}
+
+func (c *CompositeStorage) GetDeadProperties(userID, calendarID, objectID string) ([]DeadProperty, error) {
+	dead, ok := As[DeadPropertyStorage](c.route(calendarID))
+	if !ok {
+		return nil, nil
+	}
+	return dead.GetDeadProperties(userID, calendarID, objectID)
+}
+
+func (c *CompositeStorage) SetDeadProperty(userID, calendarID, objectID string, prop DeadProperty) error {
+	dead, ok := As[DeadPropertyStorage](c.route(calendarID))
+	if !ok {
+		return ErrPermissionDenied
+	}
+	return dead.SetDeadProperty(userID, calendarID, objectID, prop)
+}
+
+func (c *CompositeStorage) RemoveDeadProperty(userID, calendarID, objectID, namespace, name string) error {
+	dead, ok := As[DeadPropertyStorage](c.route(calendarID))
+	if !ok {
+		return ErrPermissionDenied
+	}
+	return dead.RemoveDeadProperty(userID, calendarID, objectID, namespace, name)
+}
+
+func (c *CompositeStorage) GetCalendarPreferences(principal, userID, calendarID string) (CalendarPreferences, error) {
+	prefs, ok := As[CalendarPreferenceStorage](c.route(calendarID))
+	if !ok {
+		return CalendarPreferences{}, nil
+	}
+	return prefs.GetCalendarPreferences(principal, userID, calendarID)
+}
+
+func (c *CompositeStorage) SetCalendarPreferences(principal, userID, calendarID string, p CalendarPreferences) error {
+	prefs, ok := As[CalendarPreferenceStorage](c.route(calendarID))
+	if !ok {
+		return ErrPermissionDenied
+	}
+	return prefs.SetCalendarPreferences(principal, userID, calendarID, p)
+}
+
+func (c *CompositeStorage) GetObjectByUID(userID, calendarID, uid string) (*CalendarObject, error) {
+	uids, ok := As[UIDStorage](c.route(calendarID))
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return uids.GetObjectByUID(userID, calendarID, uid)
+}
+
+func (c *CompositeStorage) GetSyncToken(userID, calendarID string) (string, error) {
+	syncer, ok := As[SyncStorage](c.route(calendarID))
+	if !ok {
+		return "", ErrUnsupported
+	}
+	return syncer.GetSyncToken(userID, calendarID)
+}
+
+func (c *CompositeStorage) GetChanges(userID, calendarID, token string) (*ChangeSet, error) {
+	syncer, ok := As[SyncStorage](c.route(calendarID))
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return syncer.GetChanges(userID, calendarID, token)
+}
+
+// CompactTombstones compacts the tombstones of every storage keeping them
+// and returns how many were removed in total.
+func (c *CompositeStorage) CompactTombstones(cutoff time.Time) (int, error) {
+	total := 0
+	for _, s := range c.storages() {
+		if tombstones, ok := As[TombstoneStorage](s); ok {
+			n, err := tombstones.CompactTombstones(cutoff)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// GetCalendarCTag returns the CTag of the calendar from its storage, through
+// CTagStorage if it implements it or GetCalendar otherwise.
+func (c *CompositeStorage) GetCalendarCTag(userID, calendarID string) (string, error) {
+	s := c.route(calendarID)
+	if hinted, ok := As[CTagStorage](s); ok {
+		return hinted.GetCalendarCTag(userID, calendarID)
+	}
+	cal, err := s.GetCalendar(userID, calendarID)
+	if err != nil {
+		return "", err
+	}
+	return cal.CTag, nil
+}
+
+// GetObjectsInTimeRange asks the OccurrenceIndex of the calendar's storage,
+// or returns all objects of the calendar if it has none.
+func (c *CompositeStorage) GetObjectsInTimeRange(userID, calendarID string, start, end *time.Time) ([]CalendarObject, error) {
+	s := c.route(calendarID)
+	if index, ok := As[OccurrenceIndex](s); ok {
+		return index.GetObjectsInTimeRange(userID, calendarID, start, end)
+	}
+	return s.GetObjectsInCollection(calendarID)
+}
+
+// ListObjects pages through the calendar with the PaginatedStorage of its
+// storage, or returns all its objects as one page if it has none.
+func (c *CompositeStorage) ListObjects(userID, calendarID string, opts ListOptions) (*ObjectPage, error) {
+	s := c.route(calendarID)
+	if paginated, ok := As[PaginatedStorage](s); ok {
+		return paginated.ListObjects(userID, calendarID, opts)
+	}
+	if opts.Cursor != "" {
+		return nil, ErrInvalidInput
+	}
+	objects, err := s.GetObjectsInCollection(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectPage{Objects: objects}, nil
+}
+
+// SearchObjects searches every storage implementing SearchableStorage that
+// serves one of opts.CalendarIDs, or all of them when it's empty. Default's
+// matches come first, then those of each mount in the order they were
+// mounted, up to opts.Limit. Matches in calendars a storage doesn't serve are
+// left out, as in GetUserCalendars.
+func (c *CompositeStorage) SearchObjects(userID, query string, opts SearchOptions) ([]CalendarObject, error) {
+	var found []CalendarObject
+	for i, s := range c.storages() {
+		searchable, ok := As[SearchableStorage](s)
+		if !ok {
+			continue
+		}
+		routed := opts
+		if len(opts.CalendarIDs) > 0 {
+			routed.CalendarIDs = slices.DeleteFunc(slices.Clone(opts.CalendarIDs), func(id string) bool {
+				return c.routeIndex(id) != i
+			})
+			if len(routed.CalendarIDs) == 0 {
+				continue
+			}
+		}
+		objects, err := searchable.SearchObjects(userID, query, routed)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			if c.routeIndex(PathSegment(path.Dir(obj.Path))) == i {
+				found = append(found, obj)
+			}
+		}
+	}
+	if opts.Limit > 0 && len(found) > opts.Limit {
+		found = found[:opts.Limit]
+	}
+	return found, nil
+}
+
+// The interfaces keyed by user are forwarded to Default. Called on a
+// CompositeStorage whose Default lacks them, they fail with ErrUnsupported.
+
+func (c *CompositeStorage) GetUserAvailability(userID string) (*ical.Calendar, error) {
+	avail, ok := As[AvailabilityStorage](c.Default)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return avail.GetUserAvailability(userID)
+}
+
+func (c *CompositeStorage) SetUserAvailability(userID string, cal *ical.Calendar) error {
+	avail, ok := As[AvailabilityStorage](c.Default)
+	if !ok {
+		return ErrUnsupported
+	}
+	return avail.SetUserAvailability(userID, cal)
+}
+
+func (c *CompositeStorage) GetDelegatedPrivileges(ownerID, delegateID string) (Privilege, error) {
+	delegation, ok := As[DelegationStorage](c.Default)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return delegation.GetDelegatedPrivileges(ownerID, delegateID)
+}
+
+func (c *CompositeStorage) GetGroupMembers(groupID string) ([]string, error) {
+	groups, ok := As[GroupStorage](c.Default)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return groups.GetGroupMembers(groupID)
+}
+
+func (c *CompositeStorage) GetUserGroups(userID string) ([]string, error) {
+	groups, ok := As[GroupStorage](c.Default)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return groups.GetUserGroups(userID)
+}
+
+func (c *CompositeStorage) AddNotification(userID string, n Notification) error {
+	notes, ok := As[NotificationStorage](c.Default)
+	if !ok {
+		return ErrUnsupported
+	}
+	return notes.AddNotification(userID, n)
+}
+
+func (c *CompositeStorage) GetNotifications(userID string) ([]Notification, error) {
+	notes, ok := As[NotificationStorage](c.Default)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return notes.GetNotifications(userID)
+}
+
+func (c *CompositeStorage) DeleteNotification(userID, id string) error {
+	notes, ok := As[NotificationStorage](c.Default)
+	if !ok {
+		return ErrUnsupported
+	}
+	return notes.DeleteNotification(userID, id)
+}
+
+func (c *CompositeStorage) UserByAddress(address string) (string, error) {
+	inboxes, ok := As[ScheduleInboxStorage](c.Default)
+	if !ok {
+		return "", ErrUnsupported
+	}
+	return inboxes.UserByAddress(address)
+}
+
+func (c *CompositeStorage) DeliverScheduleMessage(userID string, msg *ical.Calendar) error {
+	inboxes, ok := As[ScheduleInboxStorage](c.Default)
+	if !ok {
+		return ErrUnsupported
+	}
+	return inboxes.DeliverScheduleMessage(userID, msg)
+}
+
+func (c *CompositeStorage) CreateShareLink(link ShareLink) error {
+	links, ok := As[ShareLinkStorage](c.Default)
+	if !ok {
+		return ErrUnsupported
+	}
+	return links.CreateShareLink(link)
+}
+
+func (c *CompositeStorage) GetShareLink(token string) (*ShareLink, error) {
+	links, ok := As[ShareLinkStorage](c.Default)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return links.GetShareLink(token)
+}
+
+func (c *CompositeStorage) ListShareLinks(userID, calendarID string) ([]ShareLink, error) {
+	links, ok := As[ShareLinkStorage](c.Default)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return links.ListShareLinks(userID, calendarID)
+}
+
+func (c *CompositeStorage) DeleteShareLink(token string) error {
+	links, ok := As[ShareLinkStorage](c.Default)
+	if !ok {
+		return ErrUnsupported
+	}
+	return links.DeleteShareLink(token)
+}
+
+func (c *CompositeStorage) UserIDs() ([]string, error) {
+	directory, ok := As[UserDirectoryStorage](c.Default)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return directory.UserIDs()
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// syncedMock adds a change log to MockStorage.
+type syncedMock struct {
+	*MockStorage
+}
+
+func (s *syncedMock) GetSyncToken(userID, calendarID string) (string, error) {
+	return "urn:test:" + calendarID, nil
+}
+
+func (s *syncedMock) GetChanges(userID, calendarID, token string) (*ChangeSet, error) {
+	return &ChangeSet{Token: "urn:test:" + calendarID}, nil
+}
+
+func (s *syncedMock) CompactTombstones(cutoff time.Time) (int, error) {
+	return 2, nil
+}
+
+func TestCompositeStorageRouting(t *testing.T) {
+	personal := &syncedMock{&MockStorage{}}
+	holidays := &MockStorage{}
+	regional := &MockStorage{}
+	c := NewCompositeStorage(personal)
+	c.Mount("holidays-", holidays)
+	c.Mount("holidays-de-", regional)
+
+	personal.On("GetCalendar", "alice", "work").Return(&Calendar{Path: "/alice/cal/work/"}, nil)
+	holidays.On("GetCalendar", "alice", "holidays-us").Return(&Calendar{Path: "/alice/cal/holidays-us/"}, nil)
+	regional.On("GetCalendar", "alice", "holidays-de-by").Return(&Calendar{Path: "/alice/cal/holidays-de-by/"}, nil)
+	for _, id := range []string{"work", "holidays-us", "holidays-de-by"} {
+		cal, err := c.GetCalendar("alice", id)
+		require.NoError(t, err)
+		assert.Equal(t, id, PathSegment(cal.Path))
+	}
+
+	personal.On("AuthUser", "alice", "pw").Return("alice", nil)
+	_, err := c.AuthUser("alice", "pw")
+	require.NoError(t, err)
+
+	holidays.On("UpdateObject", "alice", "holidays-us", mock.Anything).Return("", ErrPermissionDenied)
+	_, err = c.UpdateObject("alice", "holidays-us", &CalendarObject{Path: "/alice/cal/holidays-us/x.ics"})
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	holidays.On("CreateCalendar", "alice", mock.Anything).Return(nil)
+	require.NoError(t, c.CreateCalendar("alice", &Calendar{Path: "/alice/cal/holidays-fr/"}))
+	personal.On("CreateCalendar", "alice", mock.Anything).Return(nil)
+	require.NoError(t, c.CreateCalendar("alice", &Calendar{}))
+
+	personal.AssertExpectations(t)
+	holidays.AssertExpectations(t)
+	regional.AssertExpectations(t)
+}
+
+func TestCompositeStorageUserCalendars(t *testing.T) {
+	personal := &MockStorage{}
+	holidays := &MockStorage{}
+	empty := &MockStorage{}
+	c := NewCompositeStorage(personal)
+	c.Mount("holidays-", holidays)
+	c.Mount("birthdays-", empty)
+
+	personal.On("GetUserCalendars", "alice").Return([]Calendar{{Path: "/alice/cal/work/"}}, nil)
+	holidays.On("GetUserCalendars", "alice").Return([]Calendar{
+		{Path: "/alice/cal/holidays-us/"},
+		{Path: "/alice/cal/misplaced/"}, // requests for it would go to personal
+	}, nil)
+	empty.On("GetUserCalendars", "alice").Return([]Calendar(nil), ErrNotFound)
+
+	cals, err := c.GetUserCalendars("alice")
+	require.NoError(t, err)
+	var ids []string
+	for _, cal := range cals {
+		ids = append(ids, PathSegment(cal.Path))
+	}
+	assert.Equal(t, []string{"work", "holidays-us"}, ids)
+
+	personal.On("GetUserCalendars", "bob").Return([]Calendar(nil), ErrNotFound)
+	_, err = c.GetUserCalendars("bob")
+	assert.ErrorIs(t, err, ErrNotFound, "an unknown user is still unknown")
+}
+
+func TestCompositeStorageOptionalInterfaces(t *testing.T) {
+	personal := &syncedMock{&MockStorage{}}
+	holidays := &MockStorage{}
+	c := NewCompositeStorage(personal)
+	c.Mount("holidays-", holidays)
+
+	token, err := c.GetSyncToken("alice", "work")
+	require.NoError(t, err)
+	assert.Equal(t, "urn:test:work", token)
+	_, err = c.GetSyncToken("alice", "holidays-us")
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = c.GetChanges("alice", "holidays-us", "")
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	assert.ErrorIs(t, c.UpdateCalendar("alice", "holidays-us", &Calendar{}), ErrPermissionDenied)
+	assert.ErrorIs(t, c.DeleteCalendar("alice", "holidays-us"), ErrPermissionDenied)
+	props, err := c.GetDeadProperties("alice", "holidays-us", "")
+	assert.NoError(t, err)
+	assert.Empty(t, props)
+	assert.ErrorIs(t, c.SetDeadProperty("alice", "holidays-us", "", DeadProperty{}), ErrPermissionDenied)
+	_, err = c.GetObjectByUID("alice", "holidays-us", "uid")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	n, err := c.CompactTombstones(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+// searchableMock adds full-text search and a scheduling inbox to MockStorage.
+type searchableMock struct {
+	*MockStorage
+	results []CalendarObject
+}
+
+func (s *searchableMock) SearchObjects(userID, query string, opts SearchOptions) ([]CalendarObject, error) {
+	return s.results, nil
+}
+
+func (s *searchableMock) UserByAddress(address string) (string, error) {
+	return "alice", nil
+}
+
+func (s *searchableMock) DeliverScheduleMessage(userID string, msg *ical.Calendar) error {
+	return nil
+}
+
+func TestCompositeStorageForwards(t *testing.T) {
+	personal := &syncedMock{&MockStorage{}}
+	holidays := &searchableMock{MockStorage: &MockStorage{}}
+	c := NewCompositeStorage(personal)
+	c.Mount("holidays-", holidays)
+
+	_, ok := As[SyncStorage](c)
+	assert.True(t, ok, "Default keeps a change log")
+	_, ok = As[SearchableStorage](c)
+	assert.True(t, ok, "a mount searches")
+	_, ok = As[CTagStorage](c)
+	assert.False(t, ok, "no storage looks up CTags")
+	_, ok = As[ScheduleInboxStorage](c)
+	assert.False(t, ok, "interfaces keyed by user come from Default only")
+
+	nested := NewCompositeStorage(&MockStorage{})
+	nested.Mount("team-", c)
+	_, ok = As[SearchableStorage](nested)
+	assert.True(t, ok)
+	_, ok = As[CTagStorage](nested)
+	assert.False(t, ok, "a nested composite forwards only what its storages implement")
+
+	inboxes := NewCompositeStorage(&searchableMock{MockStorage: &MockStorage{}})
+	inbox, ok := As[ScheduleInboxStorage](inboxes)
+	require.True(t, ok)
+	user, err := inbox.UserByAddress("mailto:alice@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user)
+}
+
+func TestCompositeStorageFallbacks(t *testing.T) {
+	personal := &syncedMock{&MockStorage{}}
+	holidays := &searchableMock{MockStorage: &MockStorage{}, results: []CalendarObject{
+		{Path: "/alice/cal/holidays-us/july4.ics"},
+		{Path: "/alice/cal/work/misplaced.ics"}, // requests for it would go to personal
+	}}
+	c := NewCompositeStorage(personal)
+	c.Mount("holidays-", holidays)
+
+	personal.On("GetCalendar", "alice", "work").Return(&Calendar{CTag: "7"}, nil)
+	ctag, err := c.GetCalendarCTag("alice", "work")
+	require.NoError(t, err)
+	assert.Equal(t, "7", ctag)
+
+	objects := []CalendarObject{{Path: "/alice/cal/holidays-us/july4.ics"}}
+	holidays.On("GetObjectsInCollection", "holidays-us").Return(objects, nil)
+	page, err := c.ListObjects("alice", "holidays-us", ListOptions{Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, objects, page.Objects)
+	assert.Empty(t, page.NextCursor)
+	inRange, err := c.GetObjectsInTimeRange("alice", "holidays-us", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, objects, inRange)
+
+	found, err := c.SearchObjects("alice", "july", SearchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, objects, found)
+	found, err = c.SearchObjects("alice", "july", SearchOptions{CalendarIDs: []string{"work"}})
+	require.NoError(t, err)
+	assert.Empty(t, found, "personal doesn't search and holidays doesn't serve work")
+}
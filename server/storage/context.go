@@ -0,0 +1,16 @@
+package storage
+
+import "context"
+
+// ContextualStorage is an optional extension of Storage for backends that need
+// per-request data such as a tenant ID, feature flags or a tracing span. When
+// implemented, the server calls WithContext once per request with the
+// request's context and uses the returned Storage for that request only.
+//
+// The context carries the server's RequestContext, including values set by
+// middleware; read them with server.RequestContextFrom and server.GetValue.
+// The returned Storage should implement the same optional extensions as the
+// receiver, since the server detects them on the bound value.
+type ContextualStorage interface {
+	WithContext(ctx context.Context) Storage
+}
@@ -0,0 +1,28 @@
+package storage
+
+// DeadProperty is a property the server stores without interpreting it
+// (RFC 4918 section 4.2), such as client-specific metadata.
+type DeadProperty struct {
+	Namespace string
+	Name      string
+	// XML is the serialized property element, including its value and the
+	// namespace declarations it needs
+	XML string
+}
+
+// DeadPropertyStorage is an optional extension of Storage for backends that
+// keep dead properties of calendars and calendar objects. When implemented,
+// PROPPATCH stores properties the server doesn't know and PROPFIND returns
+// them. An empty objectID addresses the calendar itself. Backends drop the
+// properties of a resource along with it.
+type DeadPropertyStorage interface {
+	// GetDeadProperties returns every dead property of the resource, in no
+	// particular order. Resources without any return an empty slice.
+	GetDeadProperties(userID, calendarID, objectID string) ([]DeadProperty, error)
+	// SetDeadProperty creates the property or replaces the one with the same
+	// namespace and name.
+	SetDeadProperty(userID, calendarID, objectID string, prop DeadProperty) error
+	// RemoveDeadProperty deletes a property. Removing a missing property is
+	// not an error.
+	RemoveDeadProperty(userID, calendarID, objectID, namespace, name string) error
+}
@@ -0,0 +1,38 @@
+package storage
+
+// Privilege is a set of WebDAV ACL privileges (RFC 3744 section 3) that one
+// user holds on another user's home and everything in it.
+type Privilege uint8
+
+const (
+	// PrivilegeRead allows reading calendars and objects.
+	PrivilegeRead Privilege = 1 << iota
+	// PrivilegeWriteContent allows changing objects and calendar properties.
+	PrivilegeWriteContent
+	// PrivilegeBind allows creating calendars in the home.
+	PrivilegeBind
+	// PrivilegeUnbind allows deleting calendars from the home.
+	PrivilegeUnbind
+
+	// PrivilegeWrite is DAV:write, which aggregates the privileges above
+	// except read.
+	PrivilegeWrite = PrivilegeWriteContent | PrivilegeBind | PrivilegeUnbind
+	// PrivilegeAll is what owners hold on their own home.
+	PrivilegeAll = PrivilegeRead | PrivilegeWrite
+)
+
+// Has reports whether p includes all of q.
+func (p Privilege) Has(q Privilege) bool {
+	return p&q == q
+}
+
+// DelegationStorage is an optional extension of Storage for backends where
+// users delegate access to their home, e.g. a manager to an assistant.
+// Delegates get exactly the privileges returned, so write access to objects
+// doesn't imply creating or deleting calendars unless PrivilegeBind and
+// PrivilegeUnbind are granted as well.
+type DelegationStorage interface {
+	// GetDelegatedPrivileges returns the privileges delegateID holds on
+	// ownerID's home, or zero when it holds none.
+	GetDelegatedPrivileges(ownerID, delegateID string) (Privilege, error)
+}
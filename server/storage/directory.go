@@ -0,0 +1,10 @@
+package storage
+
+// UserDirectoryStorage is an optional extension of Storage for backends that
+// can enumerate their users. When implemented, Depth:1 PROPFINDs on the
+// service root list every principal to the users CaldavHandler.IsAdmin
+// accepts, for admin tooling and directory browsing.
+type UserDirectoryStorage interface {
+	// UserIDs returns the IDs of all users, group principals included.
+	UserIDs() ([]string, error)
+}
@@ -0,0 +1,15 @@
+// Package storage defines the backend contract of the CalDAV server.
+//
+// Storage is the interface every backend implements. Optional extensions are
+// separate interfaces the server looks up with As, which also asks a
+// ForwardingStorage such as CompositeStorage whether a backend behind it
+// implements them:
+//
+//   - PaginatedStorage pages through large collections
+//   - SearchableStorage answers the DAV:search REPORT
+//   - AvailabilityStorage keeps a user's VAVAILABILITY
+//   - ScheduleInboxStorage keeps the scheduling messages delivered to users
+//
+// Backends report failures with the Err* variables so the handler can map
+// them to HTTP status codes. MockStorage is a testify mock for tests.
+package storage
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is a storage failure of one of the Err kinds, such as ErrConflict,
+// with a message for the client and the underlying error for logs. It
+// matches its Kind with errors.Is, so handlers map it like the bare kind:
+//
+//	return &storage.Error{Kind: storage.ErrQuotaExceeded, Message: "10 MB per user", Err: err}
+type Error struct {
+	// Kind is one of the Err values of this package.
+	Kind error
+	// Optional: explanation sent to the client in the error body
+	Message string
+	// Optional: underlying error, only logged
+	Err error
+}
+
+// NewError returns an Error of kind with message.
+func NewError(kind error, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Wrap returns an Error of kind caused by err, without a client message.
+func Wrap(kind, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// Transient marks err as a failure that may go away when the operation is
+// repeated, such as a deadlock or a timeout. The handler retries reads
+// failing with it, see server.RetryPolicy.
+func Transient(err error) *Error {
+	return Wrap(ErrTransient, err)
+}
+
+// IsTransient reports whether err is marked with Transient or ErrTransient.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+// Is reports whether target is the Kind of e.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("unique_violation")
+	err := fmt.Errorf("insert: %w", &Error{Kind: ErrConflict, Message: "UID in use", Err: cause})
+
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.ErrorIs(t, err, cause)
+	assert.NotErrorIs(t, err, ErrNotFound)
+	var storageErr *Error
+	assert.ErrorAs(t, err, &storageErr)
+	assert.Equal(t, "UID in use", storageErr.Message)
+	assert.Equal(t, "insert: resource conflict: UID in use: unique_violation", err.Error())
+
+	assert.Equal(t, "quota exceeded: 1 GB", NewError(ErrQuotaExceeded, "1 GB").Error())
+	assert.ErrorIs(t, Wrap(ErrStorageUnavailable, cause), ErrStorageUnavailable)
+}
+
+func TestTransient(t *testing.T) {
+	deadlock := errors.New("deadlock detected")
+	err := fmt.Errorf("update: %w", Transient(deadlock))
+
+	assert.True(t, IsTransient(err))
+	assert.ErrorIs(t, err, deadlock)
+	assert.False(t, IsTransient(ErrStorageUnavailable), "unavailable storage isn't retried")
+	assert.False(t, IsTransient(deadlock))
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -36,6 +37,46 @@ type PropFilter struct {
 type TimeRange struct {
 	Start *time.Time
 	End   *time.Time
+	// Floating is the zone floating date-times (no TZID, no Z suffix) and
+	// dates are read in when testing the range; nil means UTC
+	Floating *time.Location
+	// OnEvaluation, if set, receives how the range was tested against each
+	// component, identified by its UID, for debugging
+	OnEvaluation func(uid string, stats recurrence.EvaluationStats)
+	// Engine, if set, evaluates recurring components, so its cache is shared
+	// across queries; nil evaluates each without caching
+	Engine *recurrence.Engine
+	// CacheScope, if set, keys Engine's cache entries by object: the base of
+	// the object's Path is appended to it, e.g. "alice/work/", and the
+	// entries are tied to its ETag, see recurrence.Engine.ForObject
+	CacheScope string
+	// Context, if set, stops the expansion of recurring components once it
+	// is done, see recurrence.Engine.WithContext. Components whose expansion
+	// was stopped are matched by their first instance only.
+	Context context.Context
+}
+
+// MaxFloatingOffset bounds how far a floating time moves when it's read in
+// some zone instead of UTC.
+const MaxFloatingOffset = 14 * time.Hour
+
+// IndexRange returns the range to look up in an index of times read as UTC.
+// When floating times are read in another zone they can land up to
+// MaxFloatingOffset away from their UTC reading, so the range is widened by
+// that much; Validate still decides on the exact match.
+func (tr TimeRange) IndexRange() TimeRange {
+	if tr.Floating == nil || tr.Floating == time.UTC {
+		return tr
+	}
+	if tr.Start != nil {
+		start := tr.Start.Add(-MaxFloatingOffset)
+		tr.Start = &start
+	}
+	if tr.End != nil {
+		end := tr.End.Add(MaxFloatingOffset)
+		tr.End = &end
+	}
+	return tr
 }
 
 // Filter is now your one‑and‑only node type.
@@ -49,15 +90,123 @@ type Filter struct {
 	Test         string       // "anyof" (default) or "allof"
 }
 
-// Validate checks if a calendar object matches the given filter.
+// SetFloatingLocation sets the zone floating times are read in for every
+// time-range of f and its nested filters.
+func (f *Filter) SetFloatingLocation(loc *time.Location) {
+	if f == nil {
+		return
+	}
+	if f.TimeRange != nil {
+		f.TimeRange.Floating = loc
+	}
+	for i := range f.Children {
+		f.Children[i].SetFloatingLocation(loc)
+	}
+}
+
+// SetEvaluationObserver sets OnEvaluation for every time-range of f and its
+// nested filters.
+func (f *Filter) SetEvaluationObserver(fn func(uid string, stats recurrence.EvaluationStats)) {
+	if f == nil {
+		return
+	}
+	if f.TimeRange != nil {
+		f.TimeRange.OnEvaluation = fn
+	}
+	for i := range f.Children {
+		f.Children[i].SetEvaluationObserver(fn)
+	}
+}
+
+// SetContext sets Context for every time-range of f and its nested filters,
+// usually to the context of the request the filter came with.
+func (f *Filter) SetContext(ctx context.Context) {
+	if f == nil {
+		return
+	}
+	if f.TimeRange != nil {
+		f.TimeRange.Context = ctx
+	}
+	for i := range f.Children {
+		f.Children[i].SetContext(ctx)
+	}
+}
+
+// SetRecurrenceEngine sets Engine and CacheScope for every time-range of f and
+// its nested filters.
+func (f *Filter) SetRecurrenceEngine(engine *recurrence.Engine, scope string) {
+	if f == nil {
+		return
+	}
+	if f.TimeRange != nil {
+		f.TimeRange.Engine = engine
+		f.TimeRange.CacheScope = scope
+	}
+	for i := range f.Children {
+		f.Children[i].SetRecurrenceEngine(engine, scope)
+	}
+}
+
+// RequiredTimeRange returns the time-range every object matching f must
+// overlap, widened by IndexRange, or nil if f doesn't constrain time
+// unconditionally. A time-range only counts on a chain of single
+// comp-filters, since a sibling could otherwise match on its own.
+func (f *Filter) RequiredTimeRange() *TimeRange {
+	for f != nil && !f.IsNotDefined {
+		if f.TimeRange != nil {
+			tr := f.TimeRange.IndexRange()
+			// Mirror Validate: an end before the start is ignored
+			if tr.Start != nil && tr.End != nil && tr.End.Before(*tr.Start) {
+				tr.End = nil
+			}
+			return &tr
+		}
+		if len(f.Children) != 1 {
+			return nil
+		}
+		f = &f.Children[0]
+	}
+	return nil
+}
+
+// HasTimeRange reports whether f or one of its nested filters has a time-range.
+func (f *Filter) HasTimeRange() bool {
+	if f == nil {
+		return false
+	}
+	if f.TimeRange != nil {
+		return true
+	}
+	for i := range f.Children {
+		if f.Children[i].HasTimeRange() {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks if a calendar object matches the given filter. A nil filter
+// matches every object, as queries without one ask for all of them.
 func (f *Filter) Validate(calObj *CalendarObject) bool {
+	if f == nil {
+		return true
+	}
 	// Handle nil object
-	if calObj == nil || len(calObj.Component) == 0 || calObj.Component[0] == nil {
+	if calObj == nil {
+		return f.IsNotDefined
+	}
+	master := calObj.Master()
+	if master == nil {
 		return f.IsNotDefined
 	}
 
-	// Get component name from the first component
-	componentName := calObj.Component[0].Name
+	// Objects stored without their VCALENDAR match as if wrapped in one
+	if f.Component == ical.CompCalendar && master.Name != ical.CompCalendar {
+		master = &ical.Component{Name: ical.CompCalendar, Props: make(ical.Props), Children: calObj.Component}
+	}
+
+	// Get component name from the master component
+	componentName := master.Name
 
 	// Handle is-not-defined case
 	if f.IsNotDefined {
@@ -70,7 +219,7 @@ func (f *Filter) Validate(calObj *CalendarObject) bool {
 	}
 
 	// Check time range constraints
-	if f.TimeRange != nil && !validateTimeRange(calObj.Component[0], f.TimeRange) {
+	if f.TimeRange != nil && !validateTimeRange(master, f.TimeRange, calObj) {
 		return false
 	}
 
@@ -82,7 +231,7 @@ func (f *Filter) Validate(calObj *CalendarObject) bool {
 
 	// Validate property filters
 	if len(f.PropFilters) > 0 {
-		propResult := validatePropFilters(calObj.Component[0], f.PropFilters, test)
+		propResult := validatePropFilters(master, f.PropFilters, test)
 		if !propResult {
 			return false
 		}
@@ -90,7 +239,7 @@ func (f *Filter) Validate(calObj *CalendarObject) bool {
 
 	// Validate nested component filters
 	if len(f.Children) > 0 {
-		childResult := validateChildren(calObj.Component[0], f.Children, test)
+		childResult := validateChildren(calObj, master, f.Children, test)
 		if !childResult {
 			return false
 		}
@@ -101,20 +250,24 @@ func (f *Filter) Validate(calObj *CalendarObject) bool {
 
 // validateTimeRange checks if a component falls within the specified time range
 // This now properly handles recurring events using the unified recurrence engine
-func validateTimeRange(comp *ical.Component, timeRange *TimeRange) bool {
+func validateTimeRange(comp *ical.Component, timeRange *TimeRange, obj *CalendarObject) bool {
 	// If no time range constraints, always match
 	if timeRange.Start == nil && timeRange.End == nil {
 		return true
 	}
 
 	// Extract basic time info from the component
-	masterStart, masterEnd, hasBasicTime := recurrence.ExtractBasicTimeInfoFromComponent(comp)
+	loc := timeRange.Floating
+	if loc == nil {
+		loc = time.UTC
+	}
+	masterStart, masterEnd, hasBasicTime := recurrence.ExtractBasicTimeInfoInLocation(comp, loc)
 	if !hasBasicTime {
 		return false
 	}
 
 	// Extract recurrence information
-	recurrenceInfo := recurrence.ExtractRecurrenceInfoFromComponent(comp)
+	recurrenceInfo := recurrence.ExtractRecurrenceInfoInLocation(comp, loc)
 
 	// Determine the query time range
 	rangeStart := recurrence.SafeTimeDeref(timeRange.Start, time.Time{})
@@ -126,14 +279,35 @@ func validateTimeRange(comp *ical.Component, timeRange *TimeRange) bool {
 	}
 
 	// Use the centralized recurrence engine for RFC 4791 compliant validation
-	engine := recurrence.NewEngine()
+	engine := timeRange.Engine
+	if engine == nil {
+		engine = recurrence.NewEngineWithCache(nil)
+	} else if timeRange.CacheScope != "" && obj.Path != "" {
+		engine = engine.ForObject(timeRange.CacheScope+PathSegment(obj.Path), obj.ETag)
+	}
+	if timeRange.Context != nil {
+		engine = engine.WithContext(timeRange.Context)
+	}
 
 	// For performance, use the fast check that doesn't do full expansion
-	hasOccurrence, err := engine.HasOccurrenceInRange(
-		masterStart, masterEnd,
-		recurrenceInfo,
-		rangeStart, rangeEnd,
-	)
+	var hasOccurrence bool
+	var err error
+	if timeRange.OnEvaluation != nil {
+		var stats recurrence.EvaluationStats
+		hasOccurrence, stats, err = engine.HasOccurrenceInRangeWithStats(
+			masterStart, masterEnd,
+			recurrenceInfo,
+			rangeStart, rangeEnd,
+		)
+		uid, _ := comp.Props.Text(ical.PropUID)
+		timeRange.OnEvaluation(uid, stats)
+	} else {
+		hasOccurrence, err = engine.HasOccurrenceInRange(
+			masterStart, masterEnd,
+			recurrenceInfo,
+			rangeStart, rangeEnd,
+		)
+	}
 
 	if err != nil {
 		// Fallback to basic validation on error to maintain compatibility
@@ -155,19 +329,18 @@ func validateBasicTimeRange(start, end time.Time, timeRange *TimeRange) bool {
 		rangeEnd = nil
 	}
 
-	// Overlap if start ≤ rangeEnd AND end ≥ rangeStart
+	// RFC 4791 section 9.9: overlap if start < rangeEnd AND end > rangeStart,
+	// or rangeStart ≤ start < rangeEnd for instantaneous components
 	// (nil bound means "–∞" or "+∞")
-	cond1 := rangeEnd == nil || !start.After(*rangeEnd)    // start ≤ rangeEnd
-	cond2 := rangeStart == nil || !end.Before(*rangeStart) // end ≥ rangeStart
+	if !end.After(start) {
+		return (rangeStart == nil || !start.Before(*rangeStart)) && (rangeEnd == nil || start.Before(*rangeEnd))
+	}
+	cond1 := rangeEnd == nil || start.Before(*rangeEnd)  // start < rangeEnd
+	cond2 := rangeStart == nil || end.After(*rangeStart) // end > rangeStart
 
 	return cond1 && cond2
 }
 
-// isAllDayDate checks if a time represents an all-day date (time part is midnight)
-func isAllDayDate(t time.Time) bool {
-	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0
-}
-
 // validatePropFilters checks if component properties match all filters
 func validatePropFilters(comp *ical.Component, propFilters []PropFilter, test string) bool {
 	matches := 0
@@ -335,7 +508,7 @@ func validateTextMatch(value string, tm *TextMatch) bool {
 }
 
 // validateChildren checks if component children match the filters
-func validateChildren(comp *ical.Component, children []Filter, test string) bool {
+func validateChildren(obj *CalendarObject, comp *ical.Component, children []Filter, test string) bool {
 	matches := 0
 
 	for _, childFilter := range children {
@@ -355,7 +528,7 @@ func validateChildren(comp *ical.Component, children []Filter, test string) bool
 
 		// Check if any child matches the filter
 		for _, childComp := range childComps {
-			tempObj := &CalendarObject{Component: []*ical.Component{childComp}}
+			tempObj := &CalendarObject{Path: obj.Path, ETag: obj.ETag, Component: []*ical.Component{childComp}}
 			if childFilter.Validate(tempObj) {
 				childMatched = true
 				break
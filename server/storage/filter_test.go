@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/cyp0633/libcaldora/server/recurrence"
 	"github.com/emersion/go-ical"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Helper functions to create test calendar objects
@@ -198,7 +201,7 @@ func TestFilter_ValidateTimeRange(t *testing.T) {
 			want: true,
 		},
 		{
-			name: "Event partially overlaps time range (start within, end after)",
+			name: "Event starting when the time range ends doesn't overlap",
 			filter: Filter{
 				Component: ical.CompEvent,
 				TimeRange: &TimeRange{
@@ -207,7 +210,7 @@ func TestFilter_ValidateTimeRange(t *testing.T) {
 				},
 			},
 			obj:  event3,
-			want: true,
+			want: false,
 		},
 		{
 			name: "Todo with due date within time range",
@@ -278,6 +281,54 @@ func TestFilter_ValidateTimeRange(t *testing.T) {
 }
 
 // Test property filtering
+func TestFilter_EvaluationObserver(t *testing.T) {
+	start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	event := createTestEvent("weekly", "Standup", start, start.Add(time.Hour))
+	event.Component[0].Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=WEEKLY;COUNT=4"})
+	rangeStart := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	rangeEnd := rangeStart.AddDate(0, 1, 0)
+	filter := &Filter{
+		Component: ical.CompEvent,
+		TimeRange: &TimeRange{Start: &rangeStart, End: &rangeEnd},
+	}
+
+	var uids []string
+	var stats recurrence.EvaluationStats
+	filter.SetEvaluationObserver(func(uid string, s recurrence.EvaluationStats) {
+		uids = append(uids, uid)
+		stats = s
+	})
+	assert.False(t, filter.Validate(event))
+	assert.Equal(t, []string{"weekly"}, uids)
+	assert.True(t, stats.Ended)
+	assert.Equal(t, start.AddDate(0, 0, 21), stats.LastInstance)
+}
+
+func TestFilter_Context(t *testing.T) {
+	start := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	event := createTestEvent("weekly", "Standup", start, start.Add(time.Hour))
+	event.Component[0].Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=WEEKLY"})
+	rangeStart := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	rangeEnd := rangeStart.AddDate(0, 1, 0)
+	filter := &Filter{
+		Component: ical.CompCalendar,
+		Children: []Filter{{
+			Component: ical.CompEvent,
+			TimeRange: &TimeRange{Start: &rangeStart, End: &rangeEnd},
+		}},
+	}
+
+	filter.SetContext(context.Background())
+	assert.True(t, filter.Validate(event))
+
+	// Once the request is cancelled only the first instance is tested
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	filter.SetContext(ctx)
+	assert.Equal(t, ctx, filter.Children[0].TimeRange.Context)
+	assert.False(t, filter.Validate(event))
+}
+
 func TestFilter_ValidatePropertyFilters(t *testing.T) {
 	now := time.Now()
 
@@ -1087,3 +1138,137 @@ func TestFilter_ComplexFilters(t *testing.T) {
 		})
 	}
 }
+
+func TestFilter_ValidateTimeRangeTimezoneAlias(t *testing.T) {
+	// Outlook writes Windows time zone IDs, which time.LoadLocation can't load
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "outlook")
+	for name, value := range map[string]string{ical.PropDateTimeStart: "20250303T090000", ical.PropDateTimeEnd: "20250303T100000"} {
+		prop := ical.NewProp(name)
+		prop.Value = value
+		prop.Params.Set(ical.ParamTimezoneID, "China Standard Time")
+		event.Props.Set(prop)
+	}
+	event.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=WEEKLY;COUNT=4"})
+	obj := &CalendarObject{Component: []*ical.Component{event}}
+
+	// The third occurrence is 2025-03-17 01:00 UTC
+	start := time.Date(2025, 3, 17, 0, 30, 0, 0, time.UTC)
+	end := time.Date(2025, 3, 17, 1, 30, 0, 0, time.UTC)
+	filter := Filter{Component: ical.CompEvent, TimeRange: &TimeRange{Start: &start, End: &end}}
+	assert.True(t, filter.Validate(obj))
+
+	start, end = start.Add(2*time.Hour), end.Add(2*time.Hour)
+	assert.False(t, filter.Validate(obj))
+}
+
+func TestFilter_ValidateTimeRangeFloating(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// Weekly at 09:00 floating time; US clocks go forward on 2025-03-09
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "floating")
+	event.Props.Set(&ical.Prop{Name: ical.PropDateTimeStart, Params: ical.Params{}, Value: "20250303T090000"})
+	event.Props.Set(&ical.Prop{Name: ical.PropDateTimeEnd, Params: ical.Params{}, Value: "20250303T100000"})
+	event.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=WEEKLY;COUNT=4"})
+	event.Props.Set(&ical.Prop{Name: ical.PropExceptionDates, Params: ical.Params{}, Value: "20250317T090000"})
+	obj := &CalendarObject{Component: []*ical.Component{event}}
+
+	query := func(start time.Time, loc *time.Location) bool {
+		end := start.Add(30 * time.Minute)
+		filter := Filter{Component: ical.CompEvent, TimeRange: &TimeRange{Start: &start, End: &end}}
+		filter.SetFloatingLocation(loc)
+		return filter.Validate(obj)
+	}
+
+	// 09:00 EDT on 2025-03-10 is 13:00 UTC; before the change it was 14:00
+	assert.True(t, query(time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC), newYork))
+	assert.False(t, query(time.Date(2025, 3, 10, 14, 0, 0, 0, time.UTC), newYork))
+	assert.True(t, query(time.Date(2025, 3, 3, 14, 0, 0, 0, time.UTC), newYork))
+	// Read as UTC by default
+	assert.True(t, query(time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC), nil))
+	assert.False(t, query(time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC), nil))
+	// The floating EXDATE is read in the same zone
+	assert.False(t, query(time.Date(2025, 3, 17, 13, 0, 0, 0, time.UTC), newYork))
+}
+
+func TestFilter_ValidateTimeRangeAllDay(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	// All day on 2025-06-10, no DTEND
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "all-day")
+	event.Props.SetDate(ical.PropDateTimeStart, time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC))
+	obj := &CalendarObject{Component: []*ical.Component{event}}
+
+	query := func(start, end time.Time, loc *time.Location) bool {
+		filter := Filter{Component: ical.CompEvent, TimeRange: &TimeRange{Start: &start, End: &end}}
+		filter.SetFloatingLocation(loc)
+		return filter.Validate(obj)
+	}
+	day := func(d int, loc *time.Location) time.Time { return time.Date(2025, 6, d, 0, 0, 0, 0, loc) }
+
+	// The day is [June 10, June 11): neither neighbouring day matches
+	assert.True(t, query(day(10, time.UTC), day(11, time.UTC), nil))
+	assert.False(t, query(day(9, time.UTC), day(10, time.UTC), nil))
+	assert.False(t, query(day(11, time.UTC), day(12, time.UTC), nil))
+	// Read in Tokyo, the day runs from 15:00 UTC on June 9
+	assert.True(t, query(day(9, time.UTC).Add(15*time.Hour), day(9, time.UTC).Add(16*time.Hour), tokyo))
+	assert.False(t, query(day(10, time.UTC).Add(15*time.Hour), day(11, time.UTC), tokyo))
+	assert.True(t, query(day(10, tokyo), day(11, tokyo), tokyo))
+	assert.False(t, query(day(11, tokyo), day(12, tokyo), tokyo))
+}
+
+func TestTimeRange_IndexRange(t *testing.T) {
+	start := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	tr := TimeRange{Start: &start, End: &end}
+	assert.Equal(t, tr, tr.IndexRange())
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	tr.Floating = tokyo
+	widened := tr.IndexRange()
+	assert.Equal(t, start.Add(-MaxFloatingOffset), *widened.Start)
+	assert.Equal(t, end.Add(MaxFloatingOffset), *widened.End)
+	assert.Equal(t, start, *tr.Start)
+}
+
+func TestFilter_ValidateUsesMaster(t *testing.T) {
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, "Europe/Berlin")
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "1")
+	event.Props.SetText(ical.PropSummary, "Standup")
+	obj := &CalendarObject{Component: []*ical.Component{tz, event}}
+
+	filter := Filter{
+		Component:   ical.CompEvent,
+		PropFilters: []PropFilter{{Name: ical.PropSummary, TextMatch: &TextMatch{Value: "Stand"}}},
+	}
+	assert.True(t, filter.Validate(obj))
+	assert.False(t, (&Filter{Component: ical.CompTimezone}).Validate(obj))
+}
+
+func TestFilter_ValidateUnwrappedObject(t *testing.T) {
+	start := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+	obj := createTestEvent("1", "Standup", start, start.Add(time.Hour))
+
+	// Stored without a VCALENDAR, the object matches queries as if it had one
+	filter := Filter{
+		Component: ical.CompCalendar,
+		Children: []Filter{{
+			Component:   ical.CompEvent,
+			PropFilters: []PropFilter{{Name: ical.PropSummary, TextMatch: &TextMatch{Value: "Stand"}}},
+		}},
+	}
+	assert.True(t, filter.Validate(obj))
+	assert.True(t, filter.Validate(createCalendarWithEvents(obj)))
+	filter.Children[0].Component = ical.CompToDo
+	assert.False(t, filter.Validate(obj))
+
+	var none *Filter
+	assert.True(t, none.Validate(obj))
+}
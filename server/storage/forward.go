@@ -0,0 +1,33 @@
+package storage
+
+import "reflect"
+
+// ForwardingStorage is implemented by storages that pass optional interfaces
+// on to other storages, such as CompositeStorage. Such a storage has the
+// methods of every optional interface it can forward, but provides only
+// those Forwards reports; the server looks optional interfaces up with As, so
+// the others count as missing.
+type ForwardingStorage interface {
+	// Forwards reports whether a storage behind this one implements the
+	// optional interface iface, e.g. reflect.TypeFor[SyncStorage]()
+	Forwards(iface reflect.Type) bool
+}
+
+// As returns s as the optional interface T, e.g. As[SyncStorage](s), if it
+// implements T and, for a ForwardingStorage, forwards it.
+func As[T any](s Storage) (T, bool) {
+	if !implements(s, reflect.TypeFor[T]()) {
+		var zero T
+		return zero, false
+	}
+	return s.(T), true
+}
+
+// implements reports whether As finds iface on s.
+func implements(s Storage, iface reflect.Type) bool {
+	if s == nil || !reflect.TypeOf(s).Implements(iface) {
+		return false
+	}
+	fwd, ok := s.(ForwardingStorage)
+	return !ok || fwd.Forwards(iface)
+}
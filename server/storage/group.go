@@ -0,0 +1,14 @@
+package storage
+
+// GroupStorage is an optional extension of Storage for backends with group
+// principals (RFC 3744 section 4), such as teams. A group is an ordinary user
+// ID that GetUser also resolves; its members get the same access to the
+// group's home and calendars as the group itself, and the principals expose
+// DAV:group-member-set and DAV:group-membership.
+type GroupStorage interface {
+	// GetGroupMembers returns the user IDs of the group's direct members, or
+	// ErrNotFound when groupID isn't a group.
+	GetGroupMembers(groupID string) ([]string, error)
+	// GetUserGroups returns the IDs of the groups the user is a direct member of.
+	GetUserGroups(userID string) ([]string, error)
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cyp0633/libcaldora/internal/davpath"
 	"github.com/emersion/go-ical"
 )
 
@@ -106,3 +107,14 @@ func ICSToICalComp(ics string) ([]*ical.Component, error) {
 	// Return all components including VTIMEZONE
 	return cal.Children, nil
 }
+
+// PathSegment returns the last segment of a percent-encoded path such as
+// CalendarObject.Path, decoded: the ID of the object or calendar it names.
+// Segments that don't decode are returned as they are.
+func PathSegment(p string) string {
+	segments := davpath.Segments(p)
+	if len(segments) == 0 {
+		return "/"
+	}
+	return segments[len(segments)-1]
+}
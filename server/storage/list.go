@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// ListOptions controls one page of a ListObjects call.
+type ListOptions struct {
+	// Limit is the maximum number of objects to return. 0 lets the backend pick a page size.
+	Limit int
+	// Cursor is the NextCursor of the previous page, or empty for the first page.
+	// It is opaque to callers; backends decide what it encodes.
+	Cursor string
+}
+
+// ObjectPage is one page of calendar objects returned by ListObjects.
+type ObjectPage struct {
+	Objects []CalendarObject
+	// NextCursor continues the listing; empty when this is the last page.
+	NextCursor string
+}
+
+// PaginatedStorage is an optional extension of Storage for backends that can
+// iterate a collection in pages, e.g. with a keyset query instead of OFFSET.
+// When implemented, the server uses it instead of GetObjectsInCollection and
+// GetObjectPathsInCollection for Depth:1 PROPFIND and user export.
+//
+// Cursors must stay valid when objects are created or deleted between pages:
+// every object that exists for the whole iteration is returned exactly once.
+// An unknown or malformed cursor should fail with ErrInvalidInput.
+type PaginatedStorage interface {
+	ListObjects(userID, calendarID string, opts ListOptions) (*ObjectPage, error)
+}
+
+// EncodeCursor wraps a backend-specific position (e.g. the last object ID of a
+// page) into an opaque token safe to hand out as a cursor.
+func EncodeCursor(position string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+// DecodeCursor reverses EncodeCursor. It returns ErrInvalidInput for tokens
+// not produced by EncodeCursor.
+func DecodeCursor(cursor string) (string, error) {
+	position, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	return string(position), nil
+}
@@ -7,7 +7,8 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
-// MockStorage implements the Storage interface for testing
+// MockStorage implements the Storage interface for testing. Code outside
+// this module should use the mocks package, which builds on it.
 type MockStorage struct {
 	mock.Mock
 }
@@ -18,21 +19,24 @@ func (m *MockStorage) GetObjectsInCollection(calendarID string) ([]CalendarObjec
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
-	}
 	return args.Get(0).([]CalendarObject), args.Error(1)
 }
 
 // GetObjectPathsInCollection implements the Storage interface
 func (m *MockStorage) GetObjectPathsInCollection(calendarID string) ([]string, error) {
 	args := m.Called(calendarID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	return args.Get(0).([]string), args.Error(1)
 }
 
 // GetUserCalendars implements the Storage interface
 func (m *MockStorage) GetUserCalendars(userID string) ([]Calendar, error) {
 	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	return args.Get(0).([]Calendar), args.Error(1)
 }
 
@@ -74,6 +78,9 @@ func (m *MockStorage) GetObject(userID, calendarID, objectID string) (*CalendarO
 
 func (m *MockStorage) GetObjectByFilter(userID, calendarID string, filter *Filter) ([]CalendarObject, error) {
 	args := m.Called(userID, calendarID, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	return args.Get(0).([]CalendarObject), args.Error(1)
 }
 
@@ -120,6 +127,7 @@ func NewMockEvent(path, uid, summary string, start, end time.Time) CalendarObjec
 	event := ical.NewComponent(ical.CompEvent)
 	event.Props.SetText(ical.PropUID, uid)
 	event.Props.SetText(ical.PropSummary, summary)
+	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
 	event.Props.SetDateTime(ical.PropDateTimeStart, start)
 	event.Props.SetDateTime(ical.PropDateTimeEnd, end)
 
@@ -136,6 +144,7 @@ func NewMockTodo(path, uid, summary string, due time.Time) CalendarObject {
 	todo := ical.NewComponent(ical.CompToDo)
 	todo.Props.SetText(ical.PropUID, uid)
 	todo.Props.SetText(ical.PropSummary, summary)
+	todo.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
 	todo.Props.SetDateTime(ical.PropDue, due)
 
 	return CalendarObject{
@@ -0,0 +1,87 @@
+package mocks_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/cyp0633/libcaldora/server/storage/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageWithHandler(t *testing.T) {
+	store := mocks.NewStorage(t)
+	event := mocks.NewEvent("/alice/cal/work/event.ics", "uid-1", "Standup", time.Now(), time.Now().Add(time.Hour))
+	calendar := mocks.NewCalendar("/alice/cal/work", "Work", "")
+	store.On("AuthUser", "alice", "secret").Return("alice", nil)
+	store.On("GetObject", "alice", "work", "event.ics").Return(&event, nil)
+	store.On("GetObject", "alice", "work", "missing.ics").Return(nil, storage.ErrNotFound)
+	store.On("GetCalendar", "alice", "work").Return(&calendar, nil)
+	h := server.NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	get := func(path string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.SetBasicAuth("alice", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("/caldav/alice/cal/work/event.ics"))
+	assert.Equal(t, http.StatusNotFound, get("/caldav/alice/cal/work/missing.ics"))
+}
+
+func TestStorageNilResults(t *testing.T) {
+	store := mocks.NewStorage(t)
+	store.On("GetUserCalendars", "alice").Return(nil, storage.ErrNotFound)
+	store.On("GetObjectPathsInCollection", "work").Return(nil, nil)
+
+	calendars, err := store.GetUserCalendars("alice")
+	assert.Nil(t, calendars)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	paths, err := store.GetObjectPathsInCollection("work")
+	assert.Nil(t, paths)
+	assert.NoError(t, err)
+}
+
+func TestURLConverter(t *testing.T) {
+	converter := mocks.NewURLConverter(t)
+	home := server.Resource{UserID: "alice", ResourceType: storage.ResourceHomeSet}
+	converter.On("EncodePath", home).Return("/dav/alice/", nil)
+	converter.On("ParsePath", "/dav/alice/").Return(home, nil)
+
+	path, err := converter.EncodePath(home)
+	assert.NoError(t, err)
+	res, err := converter.ParsePath(path)
+	assert.NoError(t, err)
+	assert.Equal(t, home, res)
+}
+
+// recorder collects failures instead of failing the test.
+type recorder struct {
+	*testing.T
+	failures []string
+	cleanups []func()
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, format)
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func TestUnmetExpectationsFail(t *testing.T) {
+	rec := &recorder{T: t}
+	store := mocks.NewStorage(rec)
+	store.On("GetUser", "alice").Return(&storage.User{}, nil)
+	for _, f := range rec.cleanups {
+		f()
+	}
+	assert.NotEmpty(t, rec.failures)
+}
@@ -0,0 +1,33 @@
+package storage
+
+import "time"
+
+// Notification is an item of a user's notification collection, as
+// CalendarServer clients poll it: a cs:notification XML document telling the
+// user about a change someone else made.
+type Notification struct {
+	// ID names the notification's resource in the collection, e.g.
+	// "5f0c….xml"
+	ID string
+	// Type is the element the notification carries, e.g.
+	// "invite-notification", published as cs:notificationtype
+	Type    string
+	Created time.Time
+	// XML is the cs:notification document
+	XML string
+}
+
+// NotificationStorage is an optional extension of Storage for backends that
+// keep notifications. When implemented, the handler adds a notification when
+// an attendee's participation changes in an event the user organizes, or a
+// calendar is shared with the user, and serves them from the user's
+// notification collection. Clients delete notifications they are done with.
+type NotificationStorage interface {
+	// AddNotification stores a new notification for the user.
+	AddNotification(userID string, n Notification) error
+	// GetNotifications returns the user's notifications, oldest first.
+	GetNotifications(userID string) ([]Notification, error)
+	// DeleteNotification removes a notification, returning ErrNotFound when
+	// there is none with that ID.
+	DeleteNotification(userID, id string) error
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-ical"
+)
+
+// ErrInvalidObject is returned by CalendarObject.Validate for resources that
+// break the rules of RFC 4791 section 4.1.
+var ErrInvalidObject = errors.New("invalid calendar object resource")
+
+// Master returns the component every other one in the object belongs to: the
+// first one that isn't a VTIMEZONE or an overridden instance. Objects holding
+// only overrides (e.g. an invitation to a single occurrence) have no master
+// and return their first override instead. Master returns nil for objects
+// without any non-VTIMEZONE component.
+func (o *CalendarObject) Master() *ical.Component {
+	var first *ical.Component
+	for _, comp := range o.Component {
+		if comp == nil || comp.Name == ical.CompTimezone {
+			continue
+		}
+		if comp.Props.Get(ical.PropRecurrenceID) == nil {
+			return comp
+		}
+		if first == nil {
+			first = comp
+		}
+	}
+	return first
+}
+
+// Overrides returns the components with a RECURRENCE-ID other than the one
+// Master returns.
+func (o *CalendarObject) Overrides() []*ical.Component {
+	master := o.Master()
+	var overrides []*ical.Component
+	for _, comp := range o.Component {
+		if comp == nil || comp == master || comp.Name == ical.CompTimezone {
+			continue
+		}
+		if comp.Props.Get(ical.PropRecurrenceID) != nil {
+			overrides = append(overrides, comp)
+		}
+	}
+	return overrides
+}
+
+// Timezones returns the VTIMEZONE components of the object.
+func (o *CalendarObject) Timezones() []*ical.Component {
+	var timezones []*ical.Component
+	for _, comp := range o.Component {
+		if comp != nil && comp.Name == ical.CompTimezone {
+			timezones = append(timezones, comp)
+		}
+	}
+	return timezones
+}
+
+// UID returns the UID of the master component, or an empty string.
+func (o *CalendarObject) UID() string {
+	master := o.Master()
+	if master == nil {
+		return ""
+	}
+	uid, _ := master.Props.Text(ical.PropUID)
+	return uid
+}
+
+// Validate checks that the object is a single calendar object resource: one
+// type of component, all sharing a UID, with at most one master and one
+// override per RECURRENCE-ID. Returned errors wrap ErrInvalidObject.
+func (o *CalendarObject) Validate() error {
+	master := o.Master()
+	if master == nil {
+		return fmt.Errorf("%w: no calendar component", ErrInvalidObject)
+	}
+	uid, _ := master.Props.Text(ical.PropUID)
+	if uid == "" {
+		return fmt.Errorf("%w: %s without UID", ErrInvalidObject, master.Name)
+	}
+
+	instances := map[string]bool{}
+	for _, comp := range o.Component {
+		if comp == nil || comp.Name == ical.CompTimezone {
+			continue
+		}
+		if comp.Name != master.Name {
+			return fmt.Errorf("%w: both %s and %s components", ErrInvalidObject, master.Name, comp.Name)
+		}
+		if compUID, _ := comp.Props.Text(ical.PropUID); compUID != uid {
+			return fmt.Errorf("%w: components have different UIDs", ErrInvalidObject)
+		}
+		var rid string
+		if prop := comp.Props.Get(ical.PropRecurrenceID); prop != nil {
+			rid = prop.Value
+		}
+		if instances[rid] {
+			if rid == "" {
+				return fmt.Errorf("%w: more than one master component", ErrInvalidObject)
+			}
+			return fmt.Errorf("%w: more than one override for RECURRENCE-ID %s", ErrInvalidObject, rid)
+		}
+		instances[rid] = true
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarObjectGrouping(t *testing.T) {
+	component := func(name, uid, rid string) *ical.Component {
+		comp := ical.NewComponent(name)
+		if uid != "" {
+			comp.Props.SetText(ical.PropUID, uid)
+		}
+		if rid != "" {
+			comp.Props.Set(&ical.Prop{Name: ical.PropRecurrenceID, Params: ical.Params{}, Value: rid})
+		}
+		return comp
+	}
+	tz := ical.NewComponent(ical.CompTimezone)
+	override := component(ical.CompEvent, "1", "20250102T100000Z")
+	master := component(ical.CompEvent, "1", "")
+
+	obj := &CalendarObject{Component: []*ical.Component{tz, override, master}}
+	assert.Same(t, master, obj.Master())
+	assert.Equal(t, []*ical.Component{override}, obj.Overrides())
+	assert.Equal(t, []*ical.Component{tz}, obj.Timezones())
+	assert.Equal(t, "1", obj.UID())
+	require.NoError(t, obj.Validate())
+
+	// An invitation to a single occurrence has no master
+	obj = &CalendarObject{Component: []*ical.Component{tz, override}}
+	assert.Same(t, override, obj.Master())
+	assert.Empty(t, obj.Overrides())
+	assert.NoError(t, obj.Validate())
+
+	invalid := map[string][]*ical.Component{
+		"empty":              {tz},
+		"no UID":             {component(ical.CompEvent, "", "")},
+		"different UIDs":     {master, component(ical.CompEvent, "2", "20250102T100000Z")},
+		"mixed types":        {master, component(ical.CompToDo, "1", "")},
+		"two masters":        {master, component(ical.CompEvent, "1", "")},
+		"duplicate override": {master, override, component(ical.CompEvent, "1", "20250102T100000Z")},
+	}
+	for name, comps := range invalid {
+		t.Run(name, func(t *testing.T) {
+			obj := &CalendarObject{Component: comps}
+			assert.ErrorIs(t, obj.Validate(), ErrInvalidObject)
+		})
+	}
+	assert.Nil(t, (&CalendarObject{}).Master())
+	assert.Equal(t, "", (&CalendarObject{}).UID())
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/emersion/go-ical"
+)
+
+// OccurrenceSpan is the time one instance of an object covers.
+type OccurrenceSpan struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ObjectOccurrences summarizes when an object occurs, for backends indexing
+// time-range queries. Floating times are read as UTC, like the ranges
+// TimeRange.IndexRange returns.
+type ObjectOccurrences struct {
+	// First is the start of the object's first instance
+	First time.Time
+	// Last is the end of its last instance, the zero time when some
+	// recurrence never ends
+	Last time.Time
+	// WindowStart and WindowEnd bound the expanded Instances. Both are zero
+	// when the object has too many instances to keep
+	WindowStart time.Time
+	WindowEnd   time.Time
+	// Instances are the instances overlapping the window, sorted by start
+	Instances []OccurrenceSpan
+}
+
+// MaxIndexedInstances caps the instances ComputeOccurrences keeps for one
+// object. Objects with more are indexed by First and Last only.
+const MaxIndexedInstances = 5000
+
+// OccurrenceWindowBefore and OccurrenceWindowAfter set the window
+// ComputeOccurrences expands instances in, around the time it's given.
+// Ranges outside the window fall back to First and Last.
+var (
+	OccurrenceWindowBefore = 365 * 24 * time.Hour
+	OccurrenceWindowAfter  = 2 * 365 * 24 * time.Hour
+)
+
+// ComputeOccurrences returns the occurrences to index for obj, expanding its
+// instances over the window around now. ok is false when some component has
+// no start, so time-range queries can't rule the object out.
+func ComputeOccurrences(obj *CalendarObject, now time.Time) (occ ObjectOccurrences, ok bool) {
+	engine := recurrence.NewEngineWithCache(nil)
+	occ.WindowStart = now.Add(-OccurrenceWindowBefore).UTC().Truncate(24 * time.Hour)
+	occ.WindowEnd = now.Add(OccurrenceWindowAfter).UTC().Truncate(24 * time.Hour)
+	bounded, found := true, false
+	var comps []*ical.Component
+	for _, comp := range obj.Component {
+		// Objects may be stored wrapped in their VCALENDAR
+		if comp != nil && comp.Name == ical.CompCalendar {
+			comps = append(comps, comp.Children...)
+		} else {
+			comps = append(comps, comp)
+		}
+	}
+	for _, comp := range comps {
+		if comp == nil || comp.Name == ical.CompTimezone {
+			continue
+		}
+		start, end, hasTime := recurrence.ExtractBasicTimeInfoInLocation(comp, time.UTC)
+		// A missing DTSTART reads as the zero time, so check for it explicitly
+		if !hasTime || comp.Props.Get(ical.PropDateTimeStart) == nil {
+			return ObjectOccurrences{}, false
+		}
+		info := recurrence.ExtractRecurrenceInfoInLocation(comp, time.UTC)
+		first, last, compBounded := engine.Span(start, end, info)
+		if !found || first.Before(occ.First) {
+			occ.First = first
+		}
+		if !found || last.After(occ.Last) {
+			occ.Last = last
+		}
+		found = true
+		bounded = bounded && compBounded
+
+		if occ.WindowEnd.IsZero() {
+			continue
+		}
+		instances, err := engine.ExpandInstances(start, end, info, occ.WindowStart, occ.WindowEnd,
+			recurrence.ExpansionOptions{MaxOccurrences: MaxIndexedInstances + 1})
+		if err != nil || len(occ.Instances)+len(instances) > MaxIndexedInstances {
+			occ.WindowStart, occ.WindowEnd, occ.Instances = time.Time{}, time.Time{}, nil
+			continue
+		}
+		for _, instance := range instances {
+			occ.Instances = append(occ.Instances, OccurrenceSpan{Start: instance.Start, End: instance.End})
+		}
+	}
+	if !found {
+		return ObjectOccurrences{}, false
+	}
+	if !bounded {
+		occ.Last = time.Time{}
+	}
+	sort.Slice(occ.Instances, func(i, j int) bool { return occ.Instances[i].Start.Before(occ.Instances[j].Start) })
+	return occ, true
+}
+
+// MayOverlap reports whether the object may have an instance overlapping
+// [start, end], a range from TimeRange.IndexRange. A nil bound is open.
+// Backends translate this into their index lookups; it errs on the side of
+// matching, and the filter still decides.
+func (o *ObjectOccurrences) MayOverlap(start, end *time.Time) bool {
+	if end != nil && o.First.After(*end) {
+		return false
+	}
+	if start != nil && !o.Last.IsZero() && o.Last.Before(*start) {
+		return false
+	}
+	if start == nil || end == nil || o.WindowEnd.IsZero() ||
+		start.Before(o.WindowStart) || end.After(o.WindowEnd) {
+		return true
+	}
+	for _, instance := range o.Instances {
+		if !instance.Start.After(*end) && !instance.End.Before(*start) {
+			return true
+		}
+	}
+	return false
+}
+
+// OccurrenceIndex is an optional extension of Storage for backends that
+// persist the ObjectOccurrences of every object, computed by
+// ComputeOccurrences whenever they store it, e.g. in indexed columns and an
+// instance table. calendar-query then asks for the objects in its time-range
+// and only evaluates those, instead of handing the whole filter to
+// GetObjectByFilter.
+type OccurrenceIndex interface {
+	// GetObjectsInTimeRange returns the objects of a calendar whose indexed
+	// occurrences may overlap [start, end], as ObjectOccurrences.MayOverlap
+	// decides. A nil bound is open. Objects ComputeOccurrences couldn't
+	// index must always be returned.
+	GetObjectsInTimeRange(userID, calendarID string, start, end *time.Time) ([]CalendarObject, error)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeOccurrences(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	start := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	event := func(rrule string) *CalendarObject {
+		obj := NewMockEvent("/alice/cal/work/a.ics", "a", "A", start, start.Add(time.Hour))
+		if rrule != "" {
+			obj.Component[0].Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: rrule})
+		}
+		return &obj
+	}
+	at := func(day, hour int) *time.Time {
+		t := time.Date(2025, 3, day, hour, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	// A single event spans itself
+	occ, ok := ComputeOccurrences(event(""), now)
+	require.True(t, ok)
+	assert.Equal(t, start, occ.First)
+	assert.Equal(t, start.Add(time.Hour), occ.Last)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), occ.WindowStart)
+	assert.Equal(t, []OccurrenceSpan{{start, start.Add(time.Hour)}}, occ.Instances)
+
+	// COUNT bounds the span, instances rule out the days between
+	occ, ok = ComputeOccurrences(event("FREQ=WEEKLY;COUNT=3"), now)
+	require.True(t, ok)
+	assert.Equal(t, start.AddDate(0, 0, 14).Add(time.Hour), occ.Last)
+	assert.Len(t, occ.Instances, 3)
+	assert.True(t, occ.MayOverlap(at(10, 0), at(11, 0)))
+	assert.False(t, occ.MayOverlap(at(11, 0), at(12, 0)))
+	assert.False(t, occ.MayOverlap(at(20, 0), nil))
+	assert.True(t, occ.MayOverlap(nil, at(4, 0)))
+
+	// Endless rules have no Last, and ranges past the window can't be ruled out
+	occ, ok = ComputeOccurrences(event("FREQ=WEEKLY"), now)
+	require.True(t, ok)
+	assert.True(t, occ.Last.IsZero())
+	assert.False(t, occ.MayOverlap(at(11, 0), at(12, 0)))
+	far := start.AddDate(5, 0, 1)
+	assert.True(t, occ.MayOverlap(&far, nil))
+	assert.False(t, occ.MayOverlap(at(1, 0), at(2, 0)))
+
+	// Too many instances to keep
+	occ, ok = ComputeOccurrences(event("FREQ=HOURLY"), now)
+	require.True(t, ok)
+	assert.True(t, occ.WindowEnd.IsZero())
+	assert.Nil(t, occ.Instances)
+	assert.True(t, occ.MayOverlap(at(3, 10), at(3, 11)))
+
+	// Objects without a start can't be indexed
+	_, ok = ComputeOccurrences(&CalendarObject{Component: []*ical.Component{ical.NewComponent(ical.CompToDo)}}, now)
+	assert.False(t, ok)
+}
+
+func TestFilterRequiredTimeRange(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	tr := &TimeRange{Start: &start, End: &end}
+
+	filter := &Filter{Component: "VCALENDAR", Children: []Filter{{Component: "VEVENT", TimeRange: tr}}}
+	assert.Equal(t, tr, filter.RequiredTimeRange())
+
+	// Siblings could match without the range
+	filter.Children = append(filter.Children, Filter{Component: "VTODO"})
+	assert.Nil(t, filter.RequiredTimeRange())
+
+	// Floating times widen the range
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	filter = &Filter{Component: "VEVENT", TimeRange: &TimeRange{Start: &start, End: &end, Floating: berlin}}
+	assert.Equal(t, start.Add(-MaxFloatingOffset), *filter.RequiredTimeRange().Start)
+}
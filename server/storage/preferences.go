@@ -0,0 +1,32 @@
+package storage
+
+// CalendarPreferences is how one principal shows a calendar, published as
+// Google's g:color, g:hidden and g:selected, and for sharees also as the
+// calendar's displayname and ical:calendar-color. Nil fields are unset and
+// fall back to the calendar's NAME, COLOR, Hidden flag and being selected.
+type CalendarPreferences struct {
+	DisplayName *string
+	Color       *string
+	Hidden      *bool
+	Selected    *bool
+}
+
+// IsZero reports whether no preference is set.
+func (p CalendarPreferences) IsZero() bool {
+	return p.DisplayName == nil && p.Color == nil && p.Hidden == nil && p.Selected == nil
+}
+
+// CalendarPreferenceStorage is an optional extension of Storage for backends
+// that keep per-principal calendar preferences, so toggling a calendar on one
+// device shows on the user's others without changing it for anyone the
+// calendar is shared with. When implemented, PROPPATCH of g:color, g:hidden
+// and g:selected on a calendar stores a preference of the authenticated
+// principal. Sharees can also rename and recolor calendars this way.
+// Backends drop the preferences of a calendar along with it.
+type CalendarPreferenceStorage interface {
+	// GetCalendarPreferences returns what principal set for the calendar
+	// userID owns. Calendars without preferences return the zero value.
+	GetCalendarPreferences(principal, userID, calendarID string) (CalendarPreferences, error)
+	// SetCalendarPreferences replaces them. The zero value removes them.
+	SetCalendarPreferences(principal, userID, calendarID string, prefs CalendarPreferences) error
+}
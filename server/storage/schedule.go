@@ -0,0 +1,15 @@
+package storage
+
+import "github.com/emersion/go-ical"
+
+// ScheduleInboxStorage is an optional extension of Storage for backends that
+// keep the scheduling messages (iTIP, RFC 5546) delivered to users. When
+// implemented, the handler serves a scheduling outbox per user that delivers
+// the messages its owner POSTs to the inboxes of local recipients.
+type ScheduleInboxStorage interface {
+	// UserByAddress returns the ID of the user with a calendar user address,
+	// lower-cased with its mailto: scheme, or ErrNotFound when it isn't local.
+	UserByAddress(address string) (string, error)
+	// DeliverScheduleMessage stores msg in the user's scheduling inbox.
+	DeliverScheduleMessage(userID string, msg *ical.Calendar) error
+}
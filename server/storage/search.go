@@ -0,0 +1,21 @@
+package storage
+
+// SearchOptions narrows a SearchObjects call.
+type SearchOptions struct {
+	// CalendarIDs restricts the search to these calendars; empty means all of the user's calendars
+	CalendarIDs []string
+	// Components restricts results to objects containing one of these components (e.g. "VEVENT"); empty means any
+	Components []string
+	// Limit caps the number of results; 0 lets the backend decide
+	Limit int
+}
+
+// SearchableStorage is an optional extension of Storage for backends with
+// full-text search (e.g. Postgres tsvector or Bleve). When implemented, the
+// server answers the DAV:search REPORT on calendar collections and the home set.
+type SearchableStorage interface {
+	// SearchObjects returns the user's objects matching the free-text query,
+	// best matches first. Objects must carry their Path so the server can
+	// build hrefs.
+	SearchObjects(userID, query string, opts SearchOptions) ([]CalendarObject, error)
+}
@@ -0,0 +1,37 @@
+package storage
+
+import "time"
+
+// ShareLink grants access to a single calendar to whoever knows its token,
+// without an account.
+type ShareLink struct {
+	// Token is the secret carried in the link
+	Token      string
+	UserID     string
+	CalendarID string
+	// BusyOnly limits the link to a view with event times only
+	BusyOnly  bool
+	CreatedAt time.Time
+	// ExpiresAt is when the link stops working; zero means never
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the link has expired at now.
+func (l ShareLink) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// ShareLinkStorage is an optional extension of Storage for backends that
+// keep calendar share links. When implemented, requests carrying a link's
+// token get read-only access to its calendar.
+type ShareLinkStorage interface {
+	// CreateShareLink stores a new link.
+	CreateShareLink(link ShareLink) error
+	// GetShareLink returns the link with the given token, or ErrNotFound when
+	// there is none. Expired links may be returned; the server checks expiry.
+	GetShareLink(token string) (*ShareLink, error)
+	// ListShareLinks returns the links of a calendar.
+	ListShareLinks(userID, calendarID string) ([]ShareLink, error)
+	// DeleteShareLink revokes a link, returning ErrNotFound when there is none.
+	DeleteShareLink(token string) error
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// SnapshotVersion is the current version of the snapshot archive format.
+const SnapshotVersion = 1
+
+// Snapshot is a portable archive of a user's account: profile, calendars and
+// calendar objects. It is independent of any storage backend and URL layout,
+// so it can be exported from one backend and imported into another.
+type Snapshot struct {
+	// Version of the archive format, see SnapshotVersion.
+	Version int `json:"version"`
+	// ExportedAt records when the snapshot was taken.
+	ExportedAt time.Time `json:"exported_at"`
+	// UserID is the ID of the exported user in the source backend.
+	UserID string `json:"user_id"`
+	// User holds the exported profile, if the backend returned one.
+	User *User `json:"user,omitempty"`
+	// Calendars holds every calendar collection of the user with its objects.
+	Calendars []SnapshotCalendar `json:"calendars"`
+}
+
+// SnapshotCalendar is a calendar collection inside a Snapshot.
+type SnapshotCalendar struct {
+	// CalendarID is the collection ID as parsed from the source path.
+	CalendarID string `json:"calendar_id"`
+	// Path is the original path in the source deployment, kept for reference only.
+	Path                string   `json:"path,omitempty"`
+	ReadOnly            bool     `json:"read_only,omitempty"`
+	Archived            bool     `json:"archived,omitempty"`
+	Hidden              bool     `json:"hidden,omitempty"`
+	CTag                string   `json:"ctag,omitempty"`
+	ETag                string   `json:"etag,omitempty"`
+	SupportedComponents []string `json:"supported_components,omitempty"`
+	// Data is the VCALENDAR component holding the calendar properties (NAME, COLOR, ...)
+	// and any VTIMEZONE children.
+	Data *ical.Component `json:"data,omitempty"`
+	// Objects holds all calendar objects in the collection.
+	Objects []SnapshotObject `json:"objects"`
+}
+
+// SnapshotObject is a calendar object resource inside a SnapshotCalendar.
+type SnapshotObject struct {
+	// ObjectID is the resource name as parsed from the source path (e.g. "event1.ics").
+	// Left empty, the importing handler's ObjectIDs names the object.
+	ObjectID string `json:"object_id"`
+	// Path is the original path in the source deployment, kept for reference only.
+	Path         string    `json:"path,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+	// ICS is the object serialized as a complete iCalendar stream.
+	ICS string `json:"ics"`
+}
+
+// WriteSnapshot encodes the snapshot as JSON to w.
+func WriteSnapshot(w io.Writer, snap *Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return nil
+}
+
+// ReadSnapshot decodes a snapshot previously written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snap.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported snapshot version %d", ErrInvalidInput, snap.Version)
+	}
+	return &snap, nil
+}
@@ -45,6 +45,11 @@ type Calendar struct {
 	// to this calendar collection. When true, CalDAV clients should treat
 	// the calendar as non-writable.
 	ReadOnly bool
+	// Archived marks a calendar as frozen. It stays readable and syncable,
+	// but the handler rejects writes to it.
+	Archived bool
+	// Hidden marks a calendar the user chose not to show, published as g:hidden.
+	Hidden bool
 	// CTag represents the calendar collection tag.
 	// It changes when the content (objects) of the calendar changes.
 	CTag string
@@ -68,7 +73,8 @@ type CalendarObject struct {
 	//
 	// NOTE: This has nothing to do with iCal UID.
 	//
-	// Example: "/alice/cal/work/event1.ics"
+	// Example: "/alice/cal/work/event1.ics". It is percent-encoded as the
+	// URLConverter encodes it, see PathSegment for the object ID it names.
 	Path string
 
 	// ETag represents the entity tag of the calendar object.
@@ -79,6 +85,11 @@ type CalendarObject struct {
 	// LastModified timestamp can be useful for generating ETags and handling synchronization.
 	LastModified time.Time
 
+	// Revision is an optional counter that increases whenever the object changes.
+	// It backs weak ETags (W/"<revision>") when the handler runs with ETagWeak.
+	// Backends maintaining it should also set it on the object passed to UpdateObject.
+	Revision uint64
+
 	// Component stores the underlying VEVENT, VTODO, etc. data using go-ical.
 	// Sometimes a URI corresponds to multiple components (e.g. VEVENT with override, VTIMEZONE)
 	Component []*ical.Component
@@ -93,10 +104,26 @@ type User struct {
 	PreferredColor string
 	// ISO 8601 timezone, e.g. Asia/Shanghai, used for g:timezone
 	PreferredTimezone string
+	// First day of the week as an iCalendar weekday (MO, SU, ...), used for lc:week-start
+	WeekStart string
+	// Preferred language as a BCP 47 tag, e.g. de or pt-BR, for names the
+	// server generates and the xml:lang of DisplayName
+	Language string
+	// Working hours in PreferredTimezone, used for lc:working-hours and as the
+	// default cs:calendar-availability when none is published
+	WorkingHours []WorkingHours
 	// The user's principal path
 	Path string
 }
 
+// WorkingHours is a span of working time on some days of the week.
+type WorkingHours struct {
+	// Days are iCalendar weekdays (MO, TU, ...)
+	Days []string
+	// Start and End are offsets from midnight, e.g. 9*time.Hour
+	Start, End time.Duration
+}
+
 var (
 	// ErrNotFound is returned when a requested resource doesn't exist
 	ErrNotFound = errors.New("resource not found")
@@ -108,6 +135,25 @@ var (
 	ErrConflict = errors.New("resource conflict")
 	// ErrStorageUnavailable is returned when the storage backend is unavailable
 	ErrStorageUnavailable = errors.New("storage unavailable")
+	// ErrTransient is returned for failures that may not recur when the
+	// operation is repeated, such as deadlocks and timeouts. See Transient
+	ErrTransient = errors.New("transient storage failure")
+	// ErrPreconditionFailed is returned when a conditional write finds the
+	// stored resource changed, e.g. a backend comparing ETags itself
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrQuotaExceeded is returned when a write would exceed a quota of the
+	// backend, such as the space or the number of objects a user may have
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrTooLarge is returned when a resource is larger than the backend
+	// stores
+	ErrTooLarge = errors.New("resource too large")
+	// ErrUnauthorized is returned when the backend needs the user to
+	// authenticate again, e.g. because their credentials were revoked
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnsupported is returned by storages implementing an optional
+	// interface for some calendars only, such as CompositeStorage, for the
+	// others. The server treats them as if the interface wasn't implemented
+	ErrUnsupported = errors.New("operation not supported by storage")
 )
 
 // ResourceType indicates the type of CalDAV resource identified by the URL path.
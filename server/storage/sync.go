@@ -0,0 +1,42 @@
+package storage
+
+import "errors"
+
+// ErrInvalidSyncToken is returned by SyncStorage.GetChanges when a sync token
+// wasn't issued for the calendar, or the change log no longer reaches back to
+// it. The server answers with the DAV:valid-sync-token precondition so the
+// client discards its cache and runs a full resync.
+var ErrInvalidSyncToken = errors.New("sync token is no longer valid")
+
+// Change is a calendar member added, modified or deleted since a sync token.
+type Change struct {
+	// Path is the href of the object
+	Path string
+	// ETag is the current ETag of the object; empty for deletions
+	ETag string
+	// Deleted is set when the object no longer exists
+	Deleted bool
+}
+
+// ChangeSet is the answer to a SyncStorage.GetChanges call.
+type ChangeSet struct {
+	// Changes lists every member changed since the token, once each
+	Changes []Change
+	// Token is the sync token the client should send next time
+	Token string
+}
+
+// SyncStorage is an optional extension of Storage for backends that keep a
+// change log per calendar. When implemented, the server answers the
+// sync-collection REPORT (RFC 6578) and serves DAV:sync-token on calendar
+// collections. The synctoken package helps with issuing tokens.
+type SyncStorage interface {
+	// GetSyncToken returns the current sync token of a calendar.
+	GetSyncToken(userID, calendarID string) (string, error)
+	// GetChanges returns the members of a calendar changed since token, and
+	// the token to use next time. An empty token asks for an initial sync:
+	// every current member, and no deletions. Return ErrInvalidSyncToken when
+	// the token can't be answered, e.g. after the log has been trimmed; never
+	// return a partial list that would hide deletions.
+	GetChanges(userID, calendarID, token string) (*ChangeSet, error)
+}
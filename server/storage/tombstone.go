@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/synctoken"
+)
+
+// DefaultTombstoneRetention is how long deletion tombstones should be kept
+// when the embedder doesn't choose. Clients that haven't synced for longer
+// get a DAV:valid-sync-token error and resync from scratch.
+const DefaultTombstoneRetention = 30 * 24 * time.Hour
+
+// TombstoneStorage is an optional extension of SyncStorage for backends that
+// keep tombstones of deleted objects to report deletions in sync-collection
+// responses. Tombstones can't be kept forever; compaction drops old ones,
+// after which tokens older than the dropped tombstones must fail with
+// ErrInvalidSyncToken instead of answering without those deletions.
+type TombstoneStorage interface {
+	// CompactTombstones drops the tombstones of objects deleted before cutoff
+	// and returns how many were dropped.
+	CompactTombstones(cutoff time.Time) (int, error)
+}
+
+// CompactTombstonesEvery calls s.CompactTombstones every interval, dropping
+// tombstones older than retention, until ctx is done. Errors are logged and
+// retried at the next tick.
+func CompactTombstonesEvery(ctx context.Context, s TombstoneStorage, retention, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := s.CompactTombstones(now.Add(-retention))
+			if err != nil {
+				logger.Error("failed to compact tombstones", "error", err)
+				continue
+			}
+			if n > 0 {
+				logger.Info("compacted tombstones", "dropped", n)
+			}
+		}
+	}
+}
+
+// ChangeLog is an in-memory change log for backends without one of their
+// own. It records the latest change per member of each collection (scope),
+// keeps tombstones for deleted members until they are compacted, and issues
+// sync tokens through a synctoken.Codec. It answers SyncStorage and
+// TombstoneStorage calls once the scope is known. It is safe for concurrent
+// use.
+type ChangeLog struct {
+	mu     sync.Mutex
+	codec  *synctoken.Codec
+	now    func() time.Time
+	scopes map[string]*changeScope
+}
+
+type changeScope struct {
+	revision uint64
+	// horizon is the newest revision whose tombstone was compacted; older
+	// tokens can't be answered anymore
+	horizon uint64
+	members map[string]changeEntry
+}
+
+type changeEntry struct {
+	etag      string
+	revision  uint64
+	deleted   bool
+	deletedAt time.Time
+}
+
+// NewChangeLog returns an empty ChangeLog signing tokens with codec and
+// reading the time of deletions from now, or time.Now when now is nil.
+func NewChangeLog(codec *synctoken.Codec, now func() time.Time) *ChangeLog {
+	if now == nil {
+		now = time.Now
+	}
+	return &ChangeLog{codec: codec, now: now, scopes: map[string]*changeScope{}}
+}
+
+func (l *ChangeLog) scope(name string) *changeScope {
+	s, ok := l.scopes[name]
+	if !ok {
+		s = &changeScope{members: map[string]changeEntry{}}
+		l.scopes[name] = s
+	}
+	return s
+}
+
+// Update records that the member at path was created or modified.
+func (l *ChangeLog) Update(scope, path, etag string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.scope(scope)
+	s.revision++
+	s.members[path] = changeEntry{etag: etag, revision: s.revision}
+}
+
+// Delete records that the member at path was deleted, leaving a tombstone.
+func (l *ChangeLog) Delete(scope, path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.scope(scope)
+	s.revision++
+	s.members[path] = changeEntry{revision: s.revision, deleted: true, deletedAt: l.now()}
+}
+
+// Token returns the current sync token of scope.
+func (l *ChangeLog) Token(scope string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.codec.Encode(synctoken.Token{Scope: scope, Value: l.scope(scope).revision})
+}
+
+// CTag returns the CTag of scope: the revision its sync token encodes, as a
+// quoted string. Backends keeping a ChangeLog can store it in Calendar.CTag
+// or answer CTagStorage with it, so CTag and sync token change together.
+func (l *ChangeLog) CTag(scope string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return `"` + strconv.FormatUint(l.scope(scope).revision, 10) + `"`
+}
+
+// Changes lists the members of scope changed since token, ordered from oldest
+// to newest change, in the form SyncStorage.GetChanges returns. It returns
+// ErrInvalidSyncToken for tokens that are forged, belong to another scope,
+// are newer than the log, or predate a compacted tombstone.
+func (l *ChangeLog) Changes(scope, token string) (*ChangeSet, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.scope(scope)
+
+	var since uint64
+	if token != "" {
+		t, err := l.codec.Decode(token, scope)
+		if err != nil || t.Value > s.revision || t.Value < s.horizon {
+			return nil, ErrInvalidSyncToken
+		}
+		since = t.Value
+	}
+
+	type entry struct {
+		path string
+		changeEntry
+	}
+	var entries []entry
+	for path, e := range s.members {
+		if e.revision <= since || (token == "" && e.deleted) {
+			continue
+		}
+		entries = append(entries, entry{path, e})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].revision < entries[j].revision })
+
+	set := &ChangeSet{
+		Changes: make([]Change, 0, len(entries)),
+		Token:   l.codec.Encode(synctoken.Token{Scope: scope, Value: s.revision}),
+	}
+	for _, e := range entries {
+		set.Changes = append(set.Changes, Change{Path: e.path, ETag: e.etag, Deleted: e.deleted})
+	}
+	return set, nil
+}
+
+// CompactTombstones drops tombstones of members deleted before cutoff, in
+// every scope, and returns how many were dropped.
+func (l *ChangeLog) CompactTombstones(cutoff time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := 0
+	for _, s := range l.scopes {
+		for path, e := range s.members {
+			if e.deleted && e.deletedAt.Before(cutoff) {
+				delete(s.members, path)
+				s.horizon = max(s.horizon, e.revision)
+				dropped++
+			}
+		}
+	}
+	return dropped, nil
+}
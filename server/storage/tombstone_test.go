@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/synctoken"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeLog(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	log := NewChangeLog(synctoken.NewCodec([]byte("key")), func() time.Time { return now })
+
+	log.Update("work", "/work/a.ics", `"a1"`)
+	log.Update("work", "/work/b.ics", `"b1"`)
+	initial, err := log.Changes("work", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Path: "/work/a.ics", ETag: `"a1"`},
+		{Path: "/work/b.ics", ETag: `"b1"`},
+	}, initial.Changes)
+	assert.Equal(t, log.Token("work"), initial.Token)
+
+	log.Update("work", "/work/a.ics", `"a2"`)
+	log.Delete("work", "/work/b.ics")
+	log.Update("home", "/home/c.ics", `"c1"`)
+	changes, err := log.Changes("work", initial.Token)
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Path: "/work/a.ics", ETag: `"a2"`},
+		{Path: "/work/b.ics", Deleted: true},
+	}, changes.Changes)
+
+	// Nothing new since the latest token
+	latest, err := log.Changes("work", changes.Token)
+	require.NoError(t, err)
+	assert.Empty(t, latest.Changes)
+
+	// Initial syncs leave deleted members out
+	initial, err = log.Changes("work", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Change{{Path: "/work/a.ics", ETag: `"a2"`}}, initial.Changes)
+
+	for name, token := range map[string]string{
+		"forged":      "urn:libcaldora:sync:AAAA",
+		"other scope": log.Token("home"),
+	} {
+		_, err := log.Changes("work", token)
+		assert.ErrorIs(t, err, ErrInvalidSyncToken, name)
+	}
+}
+
+func TestChangeLogCTag(t *testing.T) {
+	log := NewChangeLog(synctoken.NewCodec([]byte("key")), nil)
+	assert.Equal(t, `"0"`, log.CTag("work"))
+
+	log.Update("work", "/work/a.ics", `"a1"`)
+	log.Update("work", "/work/a.ics", `"a2"`)
+	assert.Equal(t, `"2"`, log.CTag("work"))
+	log.Delete("work", "/work/a.ics")
+	assert.Equal(t, `"3"`, log.CTag("work"))
+	assert.Equal(t, `"0"`, log.CTag("home"))
+
+	// Same revision as the sync token
+	token, err := synctoken.NewCodec([]byte("key")).Decode(log.Token("work"), "work")
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, token.Value)
+}
+
+func TestChangeLogCompaction(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	log := NewChangeLog(synctoken.NewCodec([]byte("key")), func() time.Time { return now })
+
+	log.Update("work", "/work/a.ics", `"a1"`)
+	log.Update("work", "/work/b.ics", `"b1"`)
+	before := log.Token("work")
+	log.Delete("work", "/work/a.ics")
+	afterFirst := log.Token("work")
+	now = now.Add(48 * time.Hour)
+	log.Delete("work", "/work/b.ics")
+
+	n, err := log.CompactTombstones(now.Add(-24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// The token from before the dropped tombstone would miss a deletion
+	_, err = log.Changes("work", before)
+	assert.ErrorIs(t, err, ErrInvalidSyncToken)
+
+	changes, err := log.Changes("work", afterFirst)
+	require.NoError(t, err)
+	assert.Equal(t, []Change{{Path: "/work/b.ics", Deleted: true}}, changes.Changes)
+}
+
+type countingCompactor struct {
+	cutoffs chan time.Time
+}
+
+func (c *countingCompactor) CompactTombstones(cutoff time.Time) (int, error) {
+	select {
+	case c.cutoffs <- cutoff:
+	default:
+	}
+	return 1, nil
+}
+
+func TestCompactTombstonesEvery(t *testing.T) {
+	c := &countingCompactor{cutoffs: make(chan time.Time, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		CompactTombstonesEvery(ctx, c, time.Hour, time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		close(done)
+	}()
+
+	cutoff := <-c.cutoffs
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), cutoff, time.Minute)
+	cancel()
+	<-done
+}
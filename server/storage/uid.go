@@ -0,0 +1,11 @@
+package storage
+
+// UIDStorage is an optional extension of Storage for backends that can look
+// calendar objects up by the UID of their components. The handler uses it to
+// tell when a PUT under a new name carries an event the calendar already
+// holds, see CaldavHandler.UIDConflict.
+type UIDStorage interface {
+	// GetObjectByUID returns the object of calendarID whose components have
+	// the given UID, or ErrNotFound.
+	GetObjectByUID(userID, calendarID, uid string) (*CalendarObject, error)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/beevik/etree"
+	"github.com/cyp0633/libcaldora/internal/xml/propfind"
+	"github.com/cyp0633/libcaldora/internal/xml/props"
+	synccollection "github.com/cyp0633/libcaldora/internal/xml/sync-collection"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/samber/mo"
+)
+
+// handleSyncCollection answers the sync-collection REPORT (RFC 6578) on a
+// calendar collection through storage.SyncStorage. Changed members are
+// listed with their ETag, deleted ones with a 404 status, followed by the
+// new sync token. Tokens the storage can no longer answer fail with the
+// DAV:valid-sync-token precondition, which makes clients resync from scratch
+// instead of missing deletions. When the request asks for more than
+// DAV:getetag, such as calendar-data, changed members are loaded and their
+// properties resolved like in PROPFIND, sparing clients a calendar-multiget.
+func (h *CaldavHandler) handleSyncCollection(w http.ResponseWriter, r *http.Request, ctx *RequestContext) {
+	syncer, ok := storage.As[storage.SyncStorage](h.Storage)
+	if !ok {
+		h.Logger.Warn("sync-collection report requested but storage does not keep a change log")
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedReport)
+		return
+	}
+	if ctx.Resource.ResourceType != storage.ResourceCollection {
+		h.Logger.Warn("unsupported resource type for sync-collection",
+			"type", ctx.Resource.ResourceType)
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedResource)
+		return
+	}
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.Logger.Error("failed to read request body",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeReadBody)
+		return
+	}
+	req, query, err := synccollection.ParseRequest(string(bodyBytes))
+	if err != nil {
+		h.Logger.Warn("error parsing sync-collection request",
+			"error", err)
+		h.writeError(w, r, http.StatusBadRequest, CodeInvalidBody)
+		return
+	}
+
+	changes, err := syncer.GetChanges(ctx.Resource.UserID, ctx.Resource.CalendarID, query.Token)
+	if errors.Is(err, storage.ErrUnsupported) {
+		h.Logger.Warn("sync-collection report requested but the calendar's storage does not keep a change log")
+		h.writeError(w, r, http.StatusBadRequest, CodeUnsupportedReport)
+		return
+	} else if err != nil {
+		// Tokens the storage can't answer get DAV:valid-sync-token
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+	h.Logger.Debug("sync-collection completed",
+		"user_id", ctx.Resource.UserID,
+		"calendar_id", ctx.Resource.CalendarID,
+		"changes", len(changes.Changes))
+
+	fill := needsObject(req)
+	docs := make([]*etree.Document, 0, len(changes.Changes))
+	for _, change := range changes.Changes {
+		// Encoded like the hrefs of other reports, whatever the storage
+		// escaped
+		href := h.canonicalPath(change.Path)
+		if change.Deleted {
+			docs = append(docs, propfind.EncodeStatusResponse(href, http.StatusNotFound))
+			continue
+		}
+		if fill {
+			doc, err := h.syncMember(req, href)
+			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, propfind.ErrNotFound) {
+				// Deleted after the change log was read
+				docs = append(docs, propfind.EncodeStatusResponse(href, http.StatusNotFound))
+				continue
+			} else if storage.IsTransient(err) {
+				h.writeStorageError(w, r, err, CodeStorage)
+				return
+			} else if err != nil {
+				h.Logger.Error("failed to resolve properties of changed member",
+					"href", href,
+					"error", err)
+				docs = append(docs, propfind.EncodeStatusResponse(href, storageErrorStatus(err)))
+				continue
+			}
+			docs = append(docs, doc)
+			continue
+		}
+		resp := propfind.ResponseMap{}
+		if change.ETag != "" {
+			resp["getetag"] = mo.Ok[props.Property](&props.GetEtag{Value: change.ETag})
+		}
+		docs = append(docs, propfind.EncodeResponse(resp, href))
+	}
+
+	mergedDoc, err := propfind.MergeResponses(docs)
+	if err != nil {
+		h.Logger.Error("failed to merge responses",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+	mergedDoc.Root().CreateElement("d:sync-token").SetText(changes.Token)
+
+	xmlOutput, err := h.writeXML(mergedDoc)
+	if err != nil {
+		h.Logger.Error("failed to serialize XML response",
+			"error", err)
+		h.writeError(w, r, http.StatusInternalServerError, CodeResponseFailed)
+		return
+	}
+	h.recordResponseSize(r, mergedDoc, xmlOutput)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xmlOutput))
+}
+
+// needsObject reports whether the properties requested in a sync-collection
+// need more than the ETag kept in the change log.
+func needsObject(req propfind.ResponseMap) bool {
+	for name := range req {
+		if name != "getetag" {
+			return true
+		}
+	}
+	return false
+}
+
+// syncMember resolves the requested properties of the changed member at
+// href through the PROPFIND resolvers.
+func (h *CaldavHandler) syncMember(req propfind.ResponseMap, href string) (*etree.Document, error) {
+	res, err := h.URLConverter.ParsePath(href)
+	if err != nil || res.ResourceType != storage.ResourceObject {
+		return nil, storage.ErrNotFound
+	}
+	res.URI = href
+	return h.handlePropfindObject(req, res)
+}
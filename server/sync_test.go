@@ -0,0 +1,160 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+)
+
+// journalStorage answers sync requests from a fixed change log. Tokens older
+// than "2" have been trimmed.
+type journalStorage struct {
+	*storage.MockStorage
+}
+
+func (s *journalStorage) GetSyncToken(_, _ string) (string, error) {
+	return "urn:test:3", nil
+}
+
+func (s *journalStorage) GetChanges(_, calendarID, token string) (*storage.ChangeSet, error) {
+	if calendarID != "work" {
+		return nil, storage.ErrNotFound
+	}
+	switch token {
+	case "":
+		return &storage.ChangeSet{Token: "urn:test:3", Changes: []storage.Change{
+			{Path: "/caldav/alice/cal/work/a.ics", ETag: `"a2"`},
+		}}, nil
+	case "urn:test:2":
+		return &storage.ChangeSet{Token: "urn:test:3", Changes: []storage.Change{
+			{Path: "/caldav/alice/cal/work/a.ics", ETag: `"a2"`},
+			{Path: "/caldav/alice/cal/work/b.ics", Deleted: true},
+		}}, nil
+	}
+	return nil, storage.ErrInvalidSyncToken
+}
+
+func syncBody(token string) string {
+	return `<?xml version="1.0"?><D:sync-collection xmlns:D="DAV:"><D:sync-token>` + token +
+		`</D:sync-token><D:sync-level>1</D:sync-level><D:prop><D:getetag/></D:prop></D:sync-collection>`
+}
+
+func TestSyncCollection(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Storage = &journalStorage{MockStorage: mockStorage}
+
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work", syncBody("urn:test:2"), nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "<d:href>/caldav/alice/cal/work/a.ics</d:href>")
+	assert.Contains(t, body, `<d:getetag>&quot;a2&quot;</d:getetag>`)
+	assert.Contains(t, body, "<d:href>/caldav/alice/cal/work/b.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	assert.Contains(t, body, "<d:sync-token>urn:test:3</d:sync-token>")
+
+	// Initial sync lists current members only
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work", syncBody(""), nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.NotContains(t, w.Body.String(), "b.ics")
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/other", syncBody(""), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSyncCollectionInvalidToken(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Storage = &journalStorage{MockStorage: mockStorage}
+
+	for _, token := range []string{"urn:test:1", "garbage"} {
+		w := serveAlice(h, "REPORT", "/caldav/alice/cal/work", syncBody(token), nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, token)
+		assert.Contains(t, w.Body.String(), "<d:valid-sync-token/>", token)
+		assert.Contains(t, w.Body.String(), "full resync", token)
+	}
+}
+
+func TestSyncCollectionUnsupported(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work", syncBody(""), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Only calendar collections have a change log
+	h.Storage = &journalStorage{MockStorage: mockStorage}
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal", syncBody(""), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSyncTokenProperty(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Storage = &journalStorage{MockStorage: mockStorage}
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:sync-token/></D:prop></D:propfind>`
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<d:sync-token>urn:test:3</d:sync-token>")
+}
+
+func TestCTagFromSyncToken(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Storage = &journalStorage{MockStorage: mockStorage}
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/"><D:prop><CS:getctag/></D:prop></D:propfind>`
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<cs:getctag>&quot;urn:test:3&quot;</cs:getctag>")
+
+	// Clients holding it get the objects skipped
+	w = serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, map[string]string{"Depth": "1", "If-None-Match": `"urn:test:3"`})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	mockStorage.AssertNotCalled(t, "GetObjectPathsInCollection", "work")
+}
+
+func TestSyncCollectionMountedWithoutChangeLog(t *testing.T) {
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("AuthUser", "alice", "password").Return("alice", nil)
+	composite := storage.NewCompositeStorage(&journalStorage{MockStorage: mockStorage})
+	composite.Mount("holidays-", new(storage.MockStorage))
+	h := NewCaldavHandler("/caldav/", "test", composite, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work", syncBody(""), nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/holidays-us", syncBody(""), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSyncCollectionFillsProperties(t *testing.T) {
+	h, mockStorage := newTestHandler(&storage.Calendar{})
+	h.Storage = &journalStorage{MockStorage: mockStorage}
+	event := ical.NewComponent(ical.CompEvent)
+	event.Props.SetText(ical.PropUID, "a")
+	event.Props.SetText(ical.PropSummary, "Standup")
+	mockStorage.On("GetObject", "alice", "work", "a.ics").Return(&storage.CalendarObject{
+		Path:      "/caldav/alice/cal/work/a.ics",
+		ETag:      `"a2"`,
+		Component: []*ical.Component{event},
+	}, nil)
+
+	// The change log's ETag is enough for DAV:getetag alone
+	w := serveAlice(h, "REPORT", "/caldav/alice/cal/work", syncBody("urn:test:2"), nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	mockStorage.AssertNotCalled(t, "GetObject", "alice", "work", "a.ics")
+
+	body := `<?xml version="1.0"?><D:sync-collection xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:sync-token>urn:test:2</D:sync-token>` +
+		`<D:sync-level>1</D:sync-level><D:prop><D:getetag/><D:getcontenttype/><C:calendar-data/></D:prop></D:sync-collection>`
+	w = serveAlice(h, "REPORT", "/caldav/alice/cal/work", body, nil)
+	assert.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	out := w.Body.String()
+	assert.Contains(t, out, "<d:href>/caldav/alice/cal/work/a.ics</d:href>")
+	assert.Contains(t, out, `<d:getetag>&quot;a2&quot;</d:getetag>`)
+	assert.Contains(t, out, "<d:getcontenttype>text/calendar")
+	assert.Contains(t, out, "SUMMARY:Standup")
+	assert.Contains(t, out, "<d:href>/caldav/alice/cal/work/b.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	assert.Contains(t, out, "<d:sync-token>urn:test:3</d:sync-token>")
+	mockStorage.AssertNotCalled(t, "GetObject", "alice", "work", "b.ics")
+}
@@ -0,0 +1,82 @@
+package synctoken
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter()
+	assert.Equal(t, Token{Scope: "/alice/cal/work", Value: 1}, c.Next("/alice/cal/work"))
+	assert.Equal(t, uint64(1), c.Next("/alice/cal/home").Value)
+
+	c.Set("/alice/cal/work", 41)
+	assert.Equal(t, uint64(42), c.Next("/alice/cal/work").Value)
+	c.Set("/alice/cal/work", 3) // never goes back
+	assert.Equal(t, uint64(42), c.Current("/alice/cal/work").Value)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Next("/alice/cal/busy")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(50), c.Current("/alice/cal/busy").Value)
+}
+
+func TestHLC(t *testing.T) {
+	wall := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHLC(func() time.Time { return wall })
+
+	a := h.Next("work")
+	b := h.Next("work")
+	assert.Equal(t, -1, Compare(a, b))
+	assert.Equal(t, wall, Time(b.Value).UTC())
+
+	// A clock stepping back doesn't make values go back
+	wall = wall.Add(-time.Minute)
+	c := h.Next("home")
+	assert.Equal(t, 1, Compare(c, b))
+
+	h.Observe(c.Value + 100)
+	assert.Equal(t, c.Value+101, h.Next("work").Value)
+
+	wall = wall.Add(time.Hour)
+	assert.Equal(t, wall, Time(h.Next("work").Value).UTC())
+}
+
+func TestCodec(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+	tok := Token{Scope: "/alice/cal/work", Value: 1234}
+
+	s := codec.Encode(tok)
+	assert.True(t, strings.HasPrefix(s, Prefix))
+	assert.NotContains(t, s, "alice")
+
+	got, err := codec.Decode(s, "/alice/cal/work")
+	require.NoError(t, err)
+	assert.Equal(t, tok, got)
+
+	got, err = codec.Decode(s, "")
+	require.NoError(t, err)
+	assert.Equal(t, tok, got)
+
+	_, err = codec.Decode(s, "/alice/cal/home")
+	assert.ErrorIs(t, err, ErrWrongScope)
+
+	_, err = NewCodec([]byte("other")).Decode(s, "")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	for _, bad := range []string{"", "http://example.com/sync/1", Prefix + "!!", Prefix + "AAAA", s[:len(s)-2] + "AA"} {
+		_, err = codec.Decode(bad, "")
+		assert.ErrorIs(t, err, ErrInvalid, bad)
+	}
+}
@@ -0,0 +1,281 @@
+// Package tzalias maps the non-IANA time zone identifiers found in the wild
+// to IANA names, so TZID parameters resolve with time.LoadLocation.
+//
+// Outlook and Exchange write Windows time zone IDs such as
+// "W. Europe Standard Time"; other producers write fixed offsets such as
+// "GMT+8", deprecated IANA names such as "Asia/Calcutta", or prefix IANA
+// names with a vendor path such as "/mozilla.org/20050126_1/".
+package tzalias
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// windowsZones maps Windows time zone IDs to the IANA zone CLDR lists for
+// their default territory. The "UTC-11" style IDs are handled by offsetZone.
+var windowsZones = map[string]string{
+	"Dateline Standard Time":          "Etc/GMT+12",
+	"Aleutian Standard Time":          "America/Adak",
+	"Hawaiian Standard Time":          "Pacific/Honolulu",
+	"Alaskan Standard Time":           "America/Anchorage",
+	"Pacific Standard Time (Mexico)":  "America/Tijuana",
+	"Pacific Standard Time":           "America/Los_Angeles",
+	"US Mountain Standard Time":       "America/Phoenix",
+	"Mountain Standard Time (Mexico)": "America/Mazatlan",
+	"Mountain Standard Time":          "America/Denver",
+	"Central America Standard Time":   "America/Guatemala",
+	"Central Standard Time":           "America/Chicago",
+	"Central Standard Time (Mexico)":  "America/Mexico_City",
+	"Canada Central Standard Time":    "America/Regina",
+	"SA Pacific Standard Time":        "America/Bogota",
+	"Eastern Standard Time (Mexico)":  "America/Cancun",
+	"Eastern Standard Time":           "America/New_York",
+	"US Eastern Standard Time":        "America/Indianapolis",
+	"Venezuela Standard Time":         "America/Caracas",
+	"Paraguay Standard Time":          "America/Asuncion",
+	"Atlantic Standard Time":          "America/Halifax",
+	"Central Brazilian Standard Time": "America/Cuiaba",
+	"SA Western Standard Time":        "America/La_Paz",
+	"Pacific SA Standard Time":        "America/Santiago",
+	"Newfoundland Standard Time":      "America/St_Johns",
+	"E. South America Standard Time":  "America/Sao_Paulo",
+	"SA Eastern Standard Time":        "America/Cayenne",
+	"Argentina Standard Time":         "America/Buenos_Aires",
+	"Greenland Standard Time":         "America/Godthab",
+	"Montevideo Standard Time":        "America/Montevideo",
+	"Azores Standard Time":            "Atlantic/Azores",
+	"Cape Verde Standard Time":        "Atlantic/Cape_Verde",
+	"GMT Standard Time":               "Europe/London",
+	"Greenwich Standard Time":         "Atlantic/Reykjavik",
+	"Morocco Standard Time":           "Africa/Casablanca",
+	"W. Europe Standard Time":         "Europe/Berlin",
+	"Central Europe Standard Time":    "Europe/Budapest",
+	"Romance Standard Time":           "Europe/Paris",
+	"Central European Standard Time":  "Europe/Warsaw",
+	"W. Central Africa Standard Time": "Africa/Lagos",
+	"Jordan Standard Time":            "Asia/Amman",
+	"GTB Standard Time":               "Europe/Bucharest",
+	"Middle East Standard Time":       "Asia/Beirut",
+	"Egypt Standard Time":             "Africa/Cairo",
+	"E. Europe Standard Time":         "Europe/Chisinau",
+	"Syria Standard Time":             "Asia/Damascus",
+	"South Africa Standard Time":      "Africa/Johannesburg",
+	"FLE Standard Time":               "Europe/Kiev",
+	"Israel Standard Time":            "Asia/Jerusalem",
+	"Kaliningrad Standard Time":       "Europe/Kaliningrad",
+	"Arabic Standard Time":            "Asia/Baghdad",
+	"Turkey Standard Time":            "Europe/Istanbul",
+	"Arab Standard Time":              "Asia/Riyadh",
+	"Belarus Standard Time":           "Europe/Minsk",
+	"Russian Standard Time":           "Europe/Moscow",
+	"E. Africa Standard Time":         "Africa/Nairobi",
+	"Iran Standard Time":              "Asia/Tehran",
+	"Arabian Standard Time":           "Asia/Dubai",
+	"Azerbaijan Standard Time":        "Asia/Baku",
+	"Georgian Standard Time":          "Asia/Tbilisi",
+	"Caucasus Standard Time":          "Asia/Yerevan",
+	"Afghanistan Standard Time":       "Asia/Kabul",
+	"Ekaterinburg Standard Time":      "Asia/Yekaterinburg",
+	"Pakistan Standard Time":          "Asia/Karachi",
+	"West Asia Standard Time":         "Asia/Tashkent",
+	"India Standard Time":             "Asia/Calcutta",
+	"Sri Lanka Standard Time":         "Asia/Colombo",
+	"Nepal Standard Time":             "Asia/Katmandu",
+	"Central Asia Standard Time":      "Asia/Almaty",
+	"Bangladesh Standard Time":        "Asia/Dhaka",
+	"Myanmar Standard Time":           "Asia/Rangoon",
+	"SE Asia Standard Time":           "Asia/Bangkok",
+	"N. Central Asia Standard Time":   "Asia/Novosibirsk",
+	"North Asia Standard Time":        "Asia/Krasnoyarsk",
+	"China Standard Time":             "Asia/Shanghai",
+	"North Asia East Standard Time":   "Asia/Irkutsk",
+	"Singapore Standard Time":         "Asia/Singapore",
+	"W. Australia Standard Time":      "Australia/Perth",
+	"Taipei Standard Time":            "Asia/Taipei",
+	"Ulaanbaatar Standard Time":       "Asia/Ulaanbaatar",
+	"Tokyo Standard Time":             "Asia/Tokyo",
+	"Korea Standard Time":             "Asia/Seoul",
+	"Yakutsk Standard Time":           "Asia/Yakutsk",
+	"Cen. Australia Standard Time":    "Australia/Adelaide",
+	"AUS Central Standard Time":       "Australia/Darwin",
+	"E. Australia Standard Time":      "Australia/Brisbane",
+	"AUS Eastern Standard Time":       "Australia/Sydney",
+	"West Pacific Standard Time":      "Pacific/Port_Moresby",
+	"Tasmania Standard Time":          "Australia/Hobart",
+	"Vladivostok Standard Time":       "Asia/Vladivostok",
+	"Central Pacific Standard Time":   "Pacific/Guadalcanal",
+	"Magadan Standard Time":           "Asia/Magadan",
+	"New Zealand Standard Time":       "Pacific/Auckland",
+	"Fiji Standard Time":              "Pacific/Fiji",
+	"Tonga Standard Time":             "Pacific/Tongatapu",
+	"Samoa Standard Time":             "Pacific/Apia",
+	"Line Islands Standard Time":      "Pacific/Kiritimati",
+}
+
+// deprecatedZones maps backward-compatible IANA links to their current zones.
+// Go resolves the links too, but clients may not.
+var deprecatedZones = map[string]string{
+	"Asia/Calcutta":        "Asia/Kolkata",
+	"Asia/Katmandu":        "Asia/Kathmandu",
+	"Asia/Saigon":          "Asia/Ho_Chi_Minh",
+	"Asia/Rangoon":         "Asia/Yangon",
+	"Asia/Ulan_Bator":      "Asia/Ulaanbaatar",
+	"Europe/Kiev":          "Europe/Kyiv",
+	"America/Godthab":      "America/Nuuk",
+	"America/Buenos_Aires": "America/Argentina/Buenos_Aires",
+	"America/Indianapolis": "America/Indiana/Indianapolis",
+	"Pacific/Truk":         "Pacific/Chuuk",
+	"Pacific/Ponape":       "Pacific/Pohnpei",
+	"US/Eastern":           "America/New_York",
+	"US/Central":           "America/Chicago",
+	"US/Mountain":          "America/Denver",
+	"US/Pacific":           "America/Los_Angeles",
+	"US/Alaska":            "America/Anchorage",
+	"US/Hawaii":            "Pacific/Honolulu",
+	"Etc/Greenwich":        "Etc/GMT",
+}
+
+// Normalize returns the IANA name for tzid, or tzid itself when it's already
+// an IANA name or unknown. Quotes around tzid are removed.
+func Normalize(tzid string) string {
+	name, _ := Lookup(tzid)
+	return name
+}
+
+// Lookup returns the IANA name for tzid and whether tzid was an alias.
+func Lookup(tzid string) (string, bool) {
+	trimmed := strings.TrimSpace(strings.Trim(tzid, `"`))
+	if name, ok := lookup(trimmed); ok {
+		return name, true
+	}
+	return trimmed, trimmed != tzid
+}
+
+func lookup(tzid string) (string, bool) {
+	if name, ok := windowsZones[tzid]; ok {
+		if canonical, ok := deprecatedZones[name]; ok {
+			return canonical, true
+		}
+		return name, true
+	}
+	if name, ok := deprecatedZones[tzid]; ok {
+		return name, true
+	}
+	if name, ok := offsetZone(tzid); ok {
+		return name, true
+	}
+	if strings.HasPrefix(tzid, "/") {
+		// Vendor prefixes like /mozilla.org/20050126_1/America/New_York or
+		// /softwarestudio.org/Olson_20011030_5/America/New_York
+		parts := strings.Split(strings.Trim(tzid, "/"), "/")
+		for i := 1; i < len(parts); i++ {
+			name := strings.Join(parts[i:], "/")
+			if _, err := time.LoadLocation(name); err == nil && strings.Contains(name, "/") {
+				if canonical, ok := deprecatedZones[name]; ok {
+					name = canonical
+				}
+				return name, true
+			}
+		}
+	}
+	return tzid, false
+}
+
+// offsetZone maps fixed offsets such as "GMT+8", "UTC-05:00" or "GMT+0800"
+// to the Etc/GMT zones. Those zones use POSIX signs, so UTC+8 is Etc/GMT-8.
+// Offsets that aren't whole hours have no Etc zone and stay unmapped.
+func offsetZone(tzid string) (string, bool) {
+	var rest string
+	switch {
+	case strings.HasPrefix(tzid, "GMT"):
+		rest = tzid[3:]
+	case strings.HasPrefix(tzid, "UTC"):
+		rest = tzid[3:]
+	default:
+		return "", false
+	}
+	if rest == "" || (rest[0] != '+' && rest[0] != '-') {
+		return "", false
+	}
+	sign, digits := rest[0], strings.ReplaceAll(rest[1:], ":", "")
+	var hours, minutes int
+	var err error
+	switch len(digits) {
+	case 1, 2:
+		hours, err = strconv.Atoi(digits)
+	case 3, 4:
+		hours, err = strconv.Atoi(digits[:len(digits)-2])
+		if err == nil {
+			minutes, err = strconv.Atoi(digits[len(digits)-2:])
+		}
+	default:
+		return "", false
+	}
+	if err != nil || minutes != 0 || hours > 14 {
+		return "", false
+	}
+	if hours == 0 {
+		return "Etc/UTC", true
+	}
+	// Etc/GMT-N is ahead of UTC
+	if sign == '+' {
+		sign = '-'
+	} else {
+		sign = '+'
+	}
+	return "Etc/GMT" + string(sign) + strconv.Itoa(hours), true
+}
+
+// NormalizeComponents rewrites alias TZIDs to IANA names in comps and their
+// subcomponents, both in TZID parameters and in VTIMEZONE TZID properties,
+// and reports whether anything changed.
+func NormalizeComponents(comps []*ical.Component) bool {
+	changed := false
+	for _, comp := range comps {
+		for _, props := range comp.Props {
+			for i := range props {
+				prop := &props[i]
+				if comp.Name == ical.CompTimezone && prop.Name == ical.PropTimezoneID {
+					if name, ok := Lookup(prop.Value); ok {
+						prop.Value = name
+						changed = true
+					}
+				}
+				if tzid := prop.Params.Get(ical.ParamTimezoneID); tzid != "" {
+					if name, ok := Lookup(tzid); ok {
+						prop.Params.Set(ical.ParamTimezoneID, name)
+						changed = true
+					}
+				}
+			}
+		}
+		if NormalizeComponents(comp.Children) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// DateTime is ical.Props.DateTime for a property whose TZID may be an alias.
+func DateTime(props ical.Props, name string, loc *time.Location) (time.Time, error) {
+	prop := props.Get(name)
+	if prop == nil {
+		return time.Time{}, nil
+	}
+	if tzid := prop.Params.Get(ical.ParamTimezoneID); tzid != "" {
+		if iana, ok := Lookup(tzid); ok {
+			copied := *prop
+			copied.Params = ical.Params{}
+			for k, v := range prop.Params {
+				copied.Params[k] = v
+			}
+			copied.Params.Set(ical.ParamTimezoneID, iana)
+			prop = &copied
+		}
+	}
+	return prop.DateTime(loc)
+}
@@ -0,0 +1,78 @@
+package tzalias
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	for tzid, want := range map[string]string{
+		"W. Europe Standard Time": "Europe/Berlin",
+		"China Standard Time":     "Asia/Shanghai",
+		"India Standard Time":     "Asia/Kolkata",
+		"GMT+8":                   "Etc/GMT-8",
+		"GMT+08:00":               "Etc/GMT-8",
+		"UTC-05:00":               "Etc/GMT+5",
+		"UTC-11":                  "Etc/GMT+11",
+		"GMT+0000":                "Etc/UTC",
+		"Asia/Calcutta":           "Asia/Kolkata",
+		"US/Eastern":              "America/New_York",
+		"/mozilla.org/20050126_1/America/New_York":         "America/New_York",
+		"/softwarestudio.org/Olson_20011030_5/Asia/Saigon": "Asia/Ho_Chi_Minh",
+		`"Tokyo Standard Time"`:                            "Asia/Tokyo",
+	} {
+		name, ok := Lookup(tzid)
+		assert.True(t, ok, tzid)
+		assert.Equal(t, want, name, tzid)
+		_, err := time.LoadLocation(name)
+		assert.NoError(t, err, name)
+	}
+
+	for _, tzid := range []string{"Europe/Berlin", "UTC", "GMT+5:30", "Custom Zone", "GMT+15"} {
+		name, ok := Lookup(tzid)
+		assert.False(t, ok, tzid)
+		assert.Equal(t, tzid, name)
+	}
+}
+
+func TestNormalizeComponents(t *testing.T) {
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, "W. Europe Standard Time")
+	event := ical.NewComponent(ical.CompEvent)
+	start := ical.NewProp(ical.PropDateTimeStart)
+	start.Value = "20250310T090000"
+	start.Params.Set(ical.ParamTimezoneID, "W. Europe Standard Time")
+	event.Props.Set(start)
+	alarm := ical.NewComponent(ical.CompAlarm)
+	trigger := ical.NewProp(ical.PropTrigger)
+	trigger.Value = "20250310T083000"
+	trigger.Params.Set(ical.ParamTimezoneID, "GMT+1")
+	alarm.Props.Set(trigger)
+	event.Children = append(event.Children, alarm)
+
+	require.True(t, NormalizeComponents([]*ical.Component{tz, event}))
+	assert.Equal(t, "Europe/Berlin", tz.Props.Get(ical.PropTimezoneID).Value)
+	assert.Equal(t, "Europe/Berlin", event.Props.Get(ical.PropDateTimeStart).Params.Get(ical.ParamTimezoneID))
+	assert.Equal(t, "Etc/GMT-1", alarm.Props.Get(ical.PropTrigger).Params.Get(ical.ParamTimezoneID))
+	assert.False(t, NormalizeComponents([]*ical.Component{tz, event}))
+}
+
+func TestDateTime(t *testing.T) {
+	event := ical.NewComponent(ical.CompEvent)
+	start := ical.NewProp(ical.PropDateTimeStart)
+	start.Value = "20250310T090000"
+	start.Params.Set(ical.ParamTimezoneID, "China Standard Time")
+	event.Props.Set(start)
+
+	_, err := event.Props.DateTime(ical.PropDateTimeStart, nil)
+	require.Error(t, err)
+	got, err := DateTime(event.Props, ical.PropDateTimeStart, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 10, 1, 0, 0, 0, time.UTC), got.UTC())
+	// The property itself is left alone
+	assert.Equal(t, "China Standard Time", start.Params.Get(ical.ParamTimezoneID))
+}
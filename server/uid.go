@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// UIDConflictMode selects what a PUT creating an object does when the
+// calendar already holds the UID under another name. It needs a backend
+// implementing storage.UIDStorage; others always behave as UIDConflictAllow.
+type UIDConflictMode int
+
+const (
+	// UIDConflictAllow stores the new object next to the existing one. This
+	// is the default.
+	UIDConflictAllow UIDConflictMode = iota
+	// UIDConflictReject refuses the PUT with 403 and the cal:no-uid-conflict
+	// precondition of RFC 4791 section 5.3.2.1.
+	UIDConflictReject
+	// UIDConflictUpdate writes the data to the existing object instead, for
+	// clients that upload events again under new file names. The response
+	// carries the existing object's path in Location.
+	UIDConflictUpdate
+)
+
+// uidOwner returns the object of res's calendar already holding the UID of
+// comps under another name, and its resource. It returns nil when there is
+// none or UIDConflict doesn't ask to look.
+func (h *CaldavHandler) uidOwner(res Resource, comps []*ical.Component) (*storage.CalendarObject, Resource, error) {
+	uids, ok := storage.As[storage.UIDStorage](h.Storage)
+	if h.UIDConflict == UIDConflictAllow || !ok {
+		return nil, Resource{}, nil
+	}
+	uid := (&storage.CalendarObject{Component: comps}).UID()
+	if uid == "" {
+		return nil, Resource{}, nil
+	}
+	obj, err := uids.GetObjectByUID(res.UserID, res.CalendarID, uid)
+	if errors.Is(err, storage.ErrNotFound) || obj == nil {
+		return nil, Resource{}, nil
+	} else if err != nil {
+		return nil, Resource{}, err
+	}
+	owner, err := h.URLConverter.ParsePath(obj.Path)
+	if err != nil {
+		return nil, Resource{}, err
+	}
+	if owner.ObjectID == res.ObjectID {
+		return nil, Resource{}, nil
+	}
+	return obj, owner, nil
+}
@@ -0,0 +1,41 @@
+package server
+
+// Key identifies a typed value stored in a RequestContext. Create keys once,
+// typically as package-level variables, with NewKey:
+//
+//	var TenantKey = server.NewKey[string]("tenant")
+//
+// Keys compare by identity, so two keys with the same name never collide.
+type Key[T any] struct {
+	name *string
+}
+
+// NewKey creates a key for values of type T. The name is only used for debugging.
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: &name}
+}
+
+// String returns the key's name.
+func (k Key[T]) String() string {
+	if k.name == nil {
+		return ""
+	}
+	return *k.name
+}
+
+// SetValue stores a value in the request context, replacing any previous value
+// for the key. RequestContext is not safe for concurrent use; set values from
+// middleware before calling the next handler.
+func SetValue[T any](rc *RequestContext, key Key[T], value T) {
+	if rc.values == nil {
+		rc.values = make(map[any]any)
+	}
+	rc.values[key.name] = value
+}
+
+// GetValue returns the value stored for key, or the zero value and false if
+// none was set.
+func GetValue[T any](rc *RequestContext, key Key[T]) (T, bool) {
+	value, ok := rc.values[key.name].(T)
+	return value, ok
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestContextValues(t *testing.T) {
+	tenant := NewKey[string]("tenant")
+	other := NewKey[string]("tenant")
+	count := NewKey[int]("count")
+	rc := &RequestContext{}
+
+	_, ok := GetValue(rc, tenant)
+	assert.False(t, ok)
+
+	SetValue(rc, tenant, "acme")
+	SetValue(rc, count, 3)
+	got, ok := GetValue(rc, tenant)
+	assert.True(t, ok)
+	assert.Equal(t, "acme", got)
+	n, _ := GetValue(rc, count)
+	assert.Equal(t, 3, n)
+
+	// Keys with the same name are still distinct
+	_, ok = GetValue(rc, other)
+	assert.False(t, ok)
+	assert.Equal(t, "tenant", other.String())
+}
+
+// tenantStorage reads the tenant set by middleware through the bound context.
+type tenantStorage struct {
+	*storage.MockStorage
+	ctx context.Context
+}
+
+var tenantKey = NewKey[string]("tenant")
+
+func (s *tenantStorage) WithContext(ctx context.Context) storage.Storage {
+	return &tenantStorage{MockStorage: s.MockStorage, ctx: ctx}
+}
+
+func (s *tenantStorage) GetUser(userID string) (*storage.User, error) {
+	name := "unbound"
+	if rc, ok := RequestContextFrom(s.ctx); ok {
+		name, _ = GetValue(rc, tenantKey)
+	}
+	return &storage.User{DisplayName: name + "/" + userID}, nil
+}
+
+func TestContextualStorage(t *testing.T) {
+	base := &tenantStorage{MockStorage: new(storage.MockStorage), ctx: context.Background()}
+	base.On("AuthUser", "alice", "password").Return("alice", nil)
+	h, _ := newTestHandler(&storage.Calendar{})
+	h.Storage = base
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc, _ := RequestContextFrom(r.Context())
+			SetValue(rc, tenantKey, r.Header.Get("X-Tenant"))
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:displayname/></D:prop></D:propfind>`
+	r := httptest.NewRequest("PROPFIND", "/caldav/alice", strings.NewReader(body))
+	r.Header.Set("Depth", "0")
+	r.Header.Set("X-Tenant", "acme")
+	r.SetBasicAuth("alice", "password")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "acme/alice")
+	// The handler keeps the unbound storage
+	assert.Same(t, base, h.Storage)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+)
+
+// RecurrenceWarmer fills the cache of a handler's Recurrence engine with the
+// instances recurring events have over the coming days, so the first sync of
+// an account full of recurring events doesn't expand every rule while the
+// client waits. Run Warm on startup for the accounts worth it, and set it as
+// the handler's Warmer to warm objects again as PUT writes them.
+type RecurrenceWarmer struct {
+	Handler    *CaldavHandler
+	Days       int           // Optional: days from the start of today to expand, 31 by default
+	PastDays   int           // Optional: days before today to expand too, for clients syncing from the past
+	Budget     time.Duration // Optional: stop a Warm run after this long
+	MaxObjects int           // Optional: stop a Warm run after this many objects
+
+	mu    sync.Mutex
+	stats WarmerStats
+	// background counts objectWritten runs, waited for by Shutdown
+	background sync.WaitGroup
+}
+
+// WarmerStats counts the work of a RecurrenceWarmer since it was created.
+// All fields only grow, so they can be exported as Prometheus counters, see
+// WriteMetrics.
+type WarmerStats struct {
+	Runs       uint64  // Warm calls
+	OverBudget uint64  // Warm calls stopped by Budget or MaxObjects
+	Objects    uint64  // objects read
+	Warmed     uint64  // recurring components cached
+	Skipped    uint64  // recurring components with too many instances to cache
+	Errors     uint64  // recurring components that failed to expand
+	Seconds    float64 // time spent warming
+}
+
+// defaultWarmDays is how many days a RecurrenceWarmer expands without Days.
+const defaultWarmDays = 31
+
+// errWarmBudget stops a Warm run that used up its budget.
+var errWarmBudget = errors.New("warming budget exhausted")
+
+// Warm caches the recurring events of userID's calendars, or of all of them
+// when calendarIDs is empty. It stops early, without error, when the budget
+// runs out, and returns ctx's error when it's canceled.
+func (w *RecurrenceWarmer) Warm(ctx context.Context, userID string, calendarIDs ...string) error {
+	h := w.Handler
+	if h.Recurrence == nil {
+		return fmt.Errorf("handler has no Recurrence engine to warm")
+	}
+	if contextual, ok := storage.As[storage.ContextualStorage](h.Storage); ok {
+		bound := *h
+		bound.Storage = contextual.WithContext(ctx)
+		h = &bound
+	}
+	started := time.Now()
+	var run WarmerStats
+	defer func() {
+		run.Runs = 1
+		run.Seconds = time.Since(started).Seconds()
+		w.add(run)
+	}()
+
+	if len(calendarIDs) == 0 {
+		var err error
+		if calendarIDs, err = h.userCalendarIDs(userID); err != nil {
+			return err
+		}
+	}
+	for _, calendarID := range calendarIDs {
+		loc := h.floatingLocation("", userID, calendarID)
+		err := h.forEachObject(userID, calendarID, func(obj storage.CalendarObject) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if (w.Budget > 0 && time.Since(started) > w.Budget) ||
+				(w.MaxObjects > 0 && run.Objects >= uint64(w.MaxObjects)) {
+				return errWarmBudget
+			}
+			run.Objects++
+			w.warmObject(h, h.objectRecurrence(userID, calendarID, &obj), &obj, loc, &run)
+			return nil
+		})
+		if err == errWarmBudget {
+			run.OverBudget = 1
+			h.Logger.Info("recurrence warming stopped by budget",
+				"user_id", userID,
+				"objects", run.Objects)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to warm calendar %q: %w", calendarID, err)
+		}
+	}
+	h.Logger.Debug("warmed recurrence cache",
+		"user_id", userID,
+		"objects", run.Objects,
+		"warmed", run.Warmed)
+	return nil
+}
+
+// warmObject caches the recurring components of obj in engine, keyed to obj,
+// reading floating times in loc as calendar-query does, and counts them in run.
+func (w *RecurrenceWarmer) warmObject(h *CaldavHandler, engine *recurrence.Engine, obj *storage.CalendarObject, loc *time.Location, run *WarmerStats) {
+	days := w.Days
+	if days <= 0 {
+		days = defaultWarmDays
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	windowStart, windowEnd := today.AddDate(0, 0, -w.PastDays), today.AddDate(0, 0, days)
+
+	for _, comp := range obj.Component {
+		info := recurrence.ExtractRecurrenceInfoInLocation(comp, loc)
+		if info.RRULE == "" && len(info.RDATE) == 0 {
+			continue
+		}
+		start, end, ok := recurrence.ExtractBasicTimeInfoInLocation(comp, loc)
+		if !ok {
+			continue
+		}
+		warmed, err := engine.Warm(start, end, info, windowStart, windowEnd)
+		switch {
+		case err != nil:
+			run.Errors++
+			h.Logger.Warn("failed to warm recurring component",
+				"path", obj.Path,
+				"error", err)
+		case warmed:
+			run.Warmed++
+		default:
+			run.Skipped++
+		}
+	}
+}
+
+// objectWritten warms an object PUT stored, in the background so the
+// response isn't delayed. The calendar's zone is looked up before returning,
+// while the request's storage is still usable.
+func (w *RecurrenceWarmer) objectWritten(h *CaldavHandler, userID, calendarID string, obj *storage.CalendarObject) {
+	if h.Recurrence == nil {
+		return
+	}
+	loc := h.floatingLocation("", userID, calendarID)
+	engine := h.objectRecurrence(userID, calendarID, obj)
+	w.background.Add(1)
+	go func() {
+		defer w.background.Done()
+		started := time.Now()
+		run := WarmerStats{Objects: 1}
+		w.warmObject(h, engine, obj, loc, &run)
+		run.Seconds = time.Since(started).Seconds()
+		w.add(run)
+	}()
+}
+
+func (w *RecurrenceWarmer) add(run WarmerStats) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Runs += run.Runs
+	w.stats.OverBudget += run.OverBudget
+	w.stats.Objects += run.Objects
+	w.stats.Warmed += run.Warmed
+	w.stats.Skipped += run.Skipped
+	w.stats.Errors += run.Errors
+	w.stats.Seconds += run.Seconds
+}
+
+// Stats returns what the warmer did so far.
+func (w *RecurrenceWarmer) Stats() WarmerStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// WriteMetrics writes Stats in the Prometheus text exposition format, for
+// appending to a /metrics endpoint.
+func (w *RecurrenceWarmer) WriteMetrics(out io.Writer) error {
+	stats := w.Stats()
+	for _, metric := range []struct {
+		name, help string
+		value      any
+	}{
+		{"caldav_recurrence_warm_runs_total", "Recurrence cache warming runs.", stats.Runs},
+		{"caldav_recurrence_warm_over_budget_total", "Recurrence cache warming runs stopped by their budget.", stats.OverBudget},
+		{"caldav_recurrence_warm_objects_total", "Objects read to warm the recurrence cache.", stats.Objects},
+		{"caldav_recurrence_warm_components_total", "Recurring components cached by warming.", stats.Warmed},
+		{"caldav_recurrence_warm_skipped_total", "Recurring components with too many instances to cache.", stats.Skipped},
+		{"caldav_recurrence_warm_errors_total", "Recurring components that failed to expand while warming.", stats.Errors},
+		{"caldav_recurrence_warm_duration_seconds_sum", "Time spent warming the recurrence cache.", stats.Seconds},
+	} {
+		if _, err := fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n%s %v\n",
+			metric.name, metric.help, metric.name, metric.name, metric.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/recurrence"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecurrenceWarmer(t *testing.T) {
+	start := time.Now().UTC().Truncate(time.Hour).AddDate(0, 0, -10)
+	daily := ical.NewComponent(ical.CompEvent)
+	daily.Props.SetText(ical.PropUID, "daily")
+	daily.Props.SetDateTime(ical.PropDateTimeStart, start)
+	daily.Props.SetDateTime(ical.PropDateTimeEnd, start.Add(time.Hour))
+	daily.Props.Set(&ical.Prop{Name: ical.PropRecurrenceRule, Params: ical.Params{}, Value: "FREQ=DAILY"})
+	single := ical.NewComponent(ical.CompEvent)
+	single.Props.SetText(ical.PropUID, "single")
+	single.Props.SetDateTime(ical.PropDateTimeStart, start)
+	single.Props.SetDateTime(ical.PropDateTimeEnd, start.Add(time.Hour))
+	objects := []storage.CalendarObject{
+		{Path: "/alice/cal/work/daily.ics", Component: []*ical.Component{daily}},
+		{Path: "/alice/cal/work/single.ics", Component: []*ical.Component{single}},
+	}
+
+	mockStorage := new(storage.MockStorage)
+	mockStorage.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work/"}}, nil)
+	mockStorage.On("GetCalendar", "alice", "work").Return(&storage.Calendar{CalendarData: ical.NewCalendar()}, nil)
+	mockStorage.On("GetObjectsInCollection", "work").Return(objects, nil)
+	h := NewCaldavHandler("/caldav/", "test", mockStorage, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	warmer := &RecurrenceWarmer{Handler: h, Days: 7}
+	require.Error(t, warmer.Warm(context.Background(), "alice"))
+
+	h.Recurrence = recurrence.NewEngine()
+	defer h.Recurrence.Close()
+	require.NoError(t, warmer.Warm(context.Background(), "alice"))
+	stats := warmer.Stats()
+	assert.Equal(t, uint64(1), stats.Runs)
+	assert.Equal(t, uint64(2), stats.Objects)
+	assert.Equal(t, uint64(1), stats.Warmed)
+	assert.Equal(t, 1, h.Recurrence.GetCacheStats().TotalEntries)
+
+	// A calendar-query within the window is answered from it
+	mockStorage.On("GetObjectByFilter", "alice", "work", mock.Anything).
+		Run(func(args mock.Arguments) {
+			cal := ical.NewComponent(ical.CompCalendar)
+			cal.Children = append(cal.Children, daily)
+			assert.True(t, args.Get(2).(*storage.Filter).Validate(&storage.CalendarObject{Path: objects[0].Path, Component: []*ical.Component{cal}}))
+		}).
+		Return([]storage.CalendarObject(nil), nil)
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1)
+	body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="` + tomorrow.Format("20060102") + `T000000Z" end="` + tomorrow.AddDate(0, 0, 1).Format("20060102") + `T000000Z"/>
+  </C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+	ctx := &RequestContext{Resource: Resource{UserID: "alice", CalendarID: "work", ResourceType: storage.ResourceCollection}, AuthUser: "alice"}
+	w := httptest.NewRecorder()
+	h.handleCalendarQuery(w, httptest.NewRequest("REPORT", "/caldav/alice/cal/work/", strings.NewReader(body)), ctx)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, 1, h.Recurrence.GetCacheStats().TotalEntries)
+
+	// The budget stops a run early
+	warmer.MaxObjects = 1
+	require.NoError(t, warmer.Warm(context.Background(), "alice", "work"))
+	stats = warmer.Stats()
+	assert.Equal(t, uint64(1), stats.OverBudget)
+	assert.Equal(t, uint64(3), stats.Objects)
+
+	var metrics bytes.Buffer
+	require.NoError(t, warmer.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), "# TYPE caldav_recurrence_warm_runs_total counter\ncaldav_recurrence_warm_runs_total 2\n")
+	assert.Contains(t, metrics.String(), "caldav_recurrence_warm_components_total 2\n")
+}
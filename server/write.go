@@ -0,0 +1,215 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cyp0633/libcaldora/server/icsdiff"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+)
+
+// objectWrite is a change to one calendar object: a PUT, a DELETE or an item
+// of a bulk POST.
+type objectWrite struct {
+	res    Resource
+	delete bool
+	// comps replace the object unless it is deleted
+	comps []*ical.Component
+	// ifMatch and ifNoneMatch are the request's headers, or the cs:if-match
+	// of a bulk item
+	ifMatch     string
+	ifNoneMatch string
+}
+
+// writeObject checks the preconditions of write and applies it, answering on
+// w as a PUT or DELETE of the object does: 201 or 204 with the new ETag, or
+// the error. Bulk POSTs hand it an itemResponse per item. The caller holds
+// the calendar's lock.
+func (h *CaldavHandler) writeObject(w http.ResponseWriter, r *http.Request, write objectWrite) {
+	if object, ok := h.checkObjectWrite(w, r, write); ok {
+		h.applyObjectWrite(w, r, write, object)
+	}
+}
+
+// checkObjectWrite loads the object write changes and checks the lock
+// tokens, If header, If-Match and If-None-Match of the write against it. It
+// returns the object, nil when the write creates it, and whether the write
+// may go on; the request is answered when it may not. PUTs call it before
+// reading their body, so their preconditions fail first.
+func (h *CaldavHandler) checkObjectWrite(w http.ResponseWriter, r *http.Request, write objectWrite) (*storage.CalendarObject, bool) {
+	res := write.res
+
+	// Load existing object (or note that it doesn't exist)
+	object, err := h.Storage.GetObject(res.UserID, res.CalendarID, res.ObjectID)
+	if errors.Is(err, storage.ErrNotFound) && !write.delete {
+		object = nil
+		h.Logger.Debug("object does not exist, will create new")
+	} else if err != nil {
+		h.writeStorageError(w, r, err, CodeInternal)
+		return nil, false
+	} else {
+		h.Logger.Debug("existing object found",
+			"etag", object.ETag)
+	}
+
+	if !h.checkLockTokens(w, r, res, object == nil || write.delete) || !h.checkIfHeader(w, r, object) {
+		return nil, false
+	}
+	if object != nil {
+		if write.ifMatch != "" && !h.etagMatches(write.ifMatch, object) {
+			h.Logger.Warn("etag mismatch",
+				"client_etag", write.ifMatch,
+				"server_etag", object.ETag)
+			h.writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed)
+			return nil, false
+		}
+		if write.ifNoneMatch == "*" {
+			h.Logger.Warn("if-none-match=* used but resource exists")
+			h.writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed)
+			return nil, false
+		}
+	} else if write.ifMatch != "" {
+		// If-Match on a non-existent resource → 412
+		h.Logger.Warn("if-match used on non-existent resource",
+			"etag", write.ifMatch)
+		h.writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed)
+		return nil, false
+	}
+	return object, true
+}
+
+// applyObjectWrite applies write to object, as checkObjectWrite returned it.
+// New and updated objects are checked for UID conflicts and the object
+// limit, normalized and stored, and attendees are notified of the changes.
+func (h *CaldavHandler) applyObjectWrite(w http.ResponseWriter, r *http.Request, write objectWrite, object *storage.CalendarObject) {
+	res := write.res
+	if write.delete {
+		h.deleteObject(w, r, res)
+		return
+	}
+
+	// RFC 4791 section 4.1: one component type and one UID per resource
+	if err := h.validateObject(write.comps); err != nil {
+		h.Logger.Warn("invalid calendar object resource",
+			"error", err)
+		h.writePrecondition(w, r, http.StatusForbidden, "cal:valid-calendar-object-resource", err.Error())
+		return
+	}
+
+	// A new name may hold an event the calendar already has under another
+	if object == nil {
+		owner, ownerRes, err := h.uidOwner(res, write.comps)
+		if err != nil {
+			h.Logger.Error("failed to look up object by uid",
+				"error", err)
+			h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+			return
+		}
+		// If-None-Match: * asks to create an object only, so it never
+		// updates the one holding the UID
+		if owner != nil && (h.UIDConflict == UIDConflictReject || write.ifNoneMatch == "*") {
+			h.Logger.Warn("uid already used by another object",
+				"path", owner.Path)
+			h.writePrecondition(w, r, http.StatusForbidden, "cal:no-uid-conflict", "UID already used by "+owner.Path)
+			return
+		}
+		if owner != nil {
+			h.Logger.Info("updating object holding the uid instead",
+				"path", owner.Path)
+			// The write lands on owner, so the preconditions must hold for it
+			if !h.checkLockTokens(w, r, ownerRes, false) || !h.checkIfHeader(w, r, owner) {
+				return
+			}
+			res, object = ownerRes, owner
+			w.Header().Set("Location", owner.Path)
+		}
+	}
+	if object == nil && !h.checkObjectLimit(w, r, res) {
+		return
+	}
+
+	// Clients often re-upload events they didn't change; skipping the write
+	// keeps the ETag and CTag stable
+	if h.SkipUnchangedPuts && object != nil && icsdiff.Equal(object.Component, write.comps) {
+		h.Logger.Info("object unchanged, skipping write",
+			"path", object.Path,
+			"etag", object.ETag)
+		if etag := h.objectETag(object); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.normalizeComponents(write.comps, object, time.Now())
+
+	// Persist
+	path, err := h.URLConverter.EncodePath(res)
+	if err != nil {
+		// that resource is from path decoding, should not fail
+		h.Logger.Error("unexpected error encoding path",
+			"error", err,
+			"resource", res)
+		h.writeError(w, r, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	newObj := &storage.CalendarObject{Path: path, Component: write.comps}
+	newETag, err := h.Storage.UpdateObject(res.UserID, res.CalendarID, newObj)
+	if err != nil {
+		h.writeStorageError(w, r, err, CodeStorage)
+		return
+	}
+
+	newObj.ETag = newETag
+	h.objectChanged(ObjectChange{
+		UserID:     res.UserID,
+		CalendarID: res.CalendarID,
+		ObjectID:   res.ObjectID,
+		ETag:       newETag,
+	})
+	if h.Warmer != nil {
+		h.Warmer.objectWritten(h, res.UserID, res.CalendarID, newObj)
+	}
+	if object != nil {
+		h.notifyAttendeeChanges(res, object.Component, write.comps)
+	}
+
+	// Respond
+	newETag = h.objectETag(newObj)
+	if newETag != "" {
+		w.Header().Set("ETag", newETag)
+	}
+	if object == nil {
+		h.Logger.Info("object created successfully",
+			"path", newObj.Path,
+			"etag", newETag)
+		w.Header().Set("Location", newObj.Path)
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		h.Logger.Info("object updated successfully",
+			"path", newObj.Path,
+			"etag", newETag)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteObject removes the object at res for applyObjectWrite.
+func (h *CaldavHandler) deleteObject(w http.ResponseWriter, r *http.Request, res Resource) {
+	err := h.Storage.DeleteObject(res.UserID, res.CalendarID, res.ObjectID)
+	if err != nil {
+		h.writeStorageError(w, r, err, CodeInternal)
+		return
+	}
+	h.objectChanged(ObjectChange{
+		UserID:     res.UserID,
+		CalendarID: res.CalendarID,
+		ObjectID:   res.ObjectID,
+		Deleted:    true,
+	})
+
+	h.Logger.Info("object deleted successfully",
+		"object_id", res.ObjectID)
+	w.WriteHeader(http.StatusNoContent)
+}
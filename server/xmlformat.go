@@ -0,0 +1,110 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// XMLFormat controls how XML response bodies are written. The zero value
+// writes them as built, on one line.
+type XMLFormat struct {
+	// Indent pretty-prints responses with this many spaces per level. Text
+	// content such as calendar-data is left untouched.
+	Indent int
+	// Canonical writes equal responses byte for byte the same: attributes
+	// and namespace declarations are sorted, and so are the properties of
+	// each propstat and the propstats of each response, which are otherwise
+	// in no particular order. Useful for golden tests and diffing.
+	Canonical bool
+	// Compact leaves out the namespace declarations a response doesn't use,
+	// to save bytes in production.
+	Compact bool
+}
+
+// writeXML serializes a response document according to h.XMLFormat and
+// h.HrefMode. The document is modified in place.
+func (h *CaldavHandler) writeXML(doc *etree.Document) (string, error) {
+	format := h.XMLFormat
+	if root := doc.Root(); root != nil {
+		h.rewriteHrefs(root)
+		if format.Compact {
+			dropUnusedNamespaces(root)
+		}
+		if format.Canonical {
+			canonicalize(root)
+		}
+	}
+	if format.Indent > 0 {
+		settings := etree.NewIndentSettings()
+		settings.Spaces = format.Indent
+		settings.PreserveLeafWhitespace = true
+		doc.IndentWithSettings(settings)
+	}
+	return doc.WriteToString()
+}
+
+// dropUnusedNamespaces removes the prefixed namespace declarations on root
+// that neither it nor any descendant uses. Declarations further down are kept.
+func dropUnusedNamespaces(root *etree.Element) {
+	used := map[string]bool{}
+	var collect func(e *etree.Element)
+	collect = func(e *etree.Element) {
+		used[e.Space] = true
+		for _, attr := range e.Attr {
+			if attr.Space != "xmlns" {
+				used[attr.Space] = true
+			}
+		}
+		for _, child := range e.ChildElements() {
+			collect(child)
+		}
+	}
+	collect(root)
+
+	root.Attr = slices.DeleteFunc(root.Attr, func(attr etree.Attr) bool {
+		return attr.Space == "xmlns" && !used[attr.Key]
+	})
+}
+
+// canonicalize sorts attributes throughout the tree, and the order-free
+// children of multistatus responses: propstats by status, properties by
+// prefixed name.
+func canonicalize(e *etree.Element) {
+	e.SortAttrs()
+	switch e.Tag {
+	case "response":
+		sortChildren(e, "propstat", func(el *etree.Element) string {
+			if status := el.FindElement("status"); status != nil {
+				return status.Text()
+			}
+			return ""
+		})
+	case "prop":
+		sortChildren(e, "", (*etree.Element).FullTag)
+	}
+	for _, child := range e.ChildElements() {
+		canonicalize(child)
+	}
+}
+
+// sortChildren reorders the child elements of e tagged tag (all of them when
+// tag is empty) by key, keeping them in the slots they occupied.
+func sortChildren(e *etree.Element, tag string, key func(*etree.Element) string) {
+	var slots []int
+	var elems []*etree.Element
+	for i, tok := range e.Child {
+		if child, ok := tok.(*etree.Element); ok && (tag == "" || child.Tag == tag) {
+			slots = append(slots, i)
+			elems = append(elems, child)
+		}
+	}
+	slices.SortStableFunc(elems, func(a, b *etree.Element) int {
+		return strings.Compare(key(a), key(b))
+	})
+	for i, slot := range slots {
+		e.Child[slot] = elems[i]
+	}
+	e.ReindexChildren()
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const xmlFormatPropfind = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:CS="http://calendarserver.org/ns/">
+<D:prop><D:resourcetype/><CS:getctag/><C:supported-calendar-component-set/><D:getetag/><C:calendar-description/></D:prop></D:propfind>`
+
+func TestXMLFormatCanonical(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{Path: "/alice/cal/work", SupportedComponents: []string{"VEVENT"}})
+	h.XMLFormat = XMLFormat{Canonical: true}
+	header := map[string]string{"Depth": "0"}
+
+	first := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", xmlFormatPropfind, header)
+	assert.Equal(t, http.StatusMultiStatus, first.Code)
+	for range 10 {
+		w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", xmlFormatPropfind, header)
+		assert.Equal(t, first.Body.String(), w.Body.String())
+	}
+	assert.Contains(t, first.Body.String(), `<d:multistatus xmlns:cal="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/" xmlns:d="DAV:"`)
+}
+
+func TestXMLFormatCompact(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{Path: "/alice/cal/work", SupportedComponents: []string{"VEVENT"}})
+	h.XMLFormat = XMLFormat{Compact: true}
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/></D:prop></D:propfind>`
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", body, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), `xmlns:d="DAV:"`)
+	assert.Contains(t, w.Body.String(), `xmlns:cal=`, "resourcetype names cal:calendar")
+	assert.NotContains(t, w.Body.String(), `xmlns:g=`)
+	assert.NotContains(t, w.Body.String(), `xmlns:ical=`)
+}
+
+func TestXMLFormatIndent(t *testing.T) {
+	h, _ := newTestHandler(&storage.Calendar{Path: "/alice/cal/work", SupportedComponents: []string{"VEVENT"}})
+	h.XMLFormat = XMLFormat{Indent: 2}
+
+	w := serveAlice(h, "PROPFIND", "/caldav/alice/cal/work", xmlFormatPropfind, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "\n  <d:response>\n    <d:href>/caldav/alice/cal/work</d:href>\n")
+	assert.Contains(t, w.Body.String(), "\n      <d:status>HTTP/1.1 200 OK</d:status>\n")
+}
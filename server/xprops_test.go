@@ -0,0 +1,140 @@
+package server
+
+import (
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cyp0633/libcaldora/server/icsdiff"
+	"github.com/cyp0633/libcaldora/server/storage"
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// xpropSamples are exports of real clients, full of X- properties and
+// components the server doesn't know.
+var xpropSamples = []string{"apple", "google", "thunderbird", "vpoll"}
+
+func newXPropHandler(t *testing.T) *CaldavHandler {
+	t.Helper()
+	store := &changeStorage{MockStorage: &storage.MockStorage{}, objects: map[string]storage.CalendarObject{}}
+	store.On("AuthUser", "alice", "password").Return("alice", nil)
+	store.On("GetCalendar", "alice", "work").Return(&storage.Calendar{Path: "/alice/cal/work/", CalendarData: ical.NewCalendar()}, nil)
+	store.On("GetUserCalendars", "alice").Return([]storage.Calendar{{Path: "/alice/cal/work/"}}, nil).Maybe()
+	store.On("GetObjectPathsInCollection", mock.Anything).Return([]string{}, nil).Maybe()
+	return NewCaldavHandler("/caldav/", "test", store, 1, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func readXPropSample(t *testing.T, name string) (string, []*ical.Component) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/xprops/" + name + ".ics")
+	require.NoError(t, err)
+	return string(data), decodeComponents(t, string(data))
+}
+
+func decodeComponents(t *testing.T, data string) []*ical.Component {
+	t.Helper()
+	cal, err := ical.NewDecoder(strings.NewReader(data)).Decode()
+	require.NoError(t, err)
+	var comps []*ical.Component
+	for _, child := range cal.Children {
+		if child.Name != ical.CompTimezone {
+			comps = append(comps, child)
+		}
+	}
+	return comps
+}
+
+var calendarDataElement = regexp.MustCompile(`(?s)<cal:calendar-data[^>]*>(.*?)</cal:calendar-data>`)
+
+// reportedData returns the calendar-data of a REPORT response.
+func reportedData(t *testing.T, body string) string {
+	t.Helper()
+	m := calendarDataElement.FindStringSubmatch(body)
+	require.NotNil(t, m, body)
+	return html.UnescapeString(m[1])
+}
+
+func TestXPropertiesSurviveGet(t *testing.T) {
+	// One calendar holds all samples, so objects mixing into each other
+	// show too
+	h := newXPropHandler(t)
+	for _, name := range xpropSamples {
+		t.Run(name, func(t *testing.T) {
+			data, want := readXPropSample(t, name)
+			w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/"+name+".ics", data, map[string]string{"Content-Type": "text/calendar"})
+			require.Less(t, w.Code, 300, w.Body.String())
+
+			w = serveAlice(h, http.MethodGet, "/caldav/alice/cal/work/"+name+".ics", "", nil)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			got := decodeComponents(t, w.Body.String())
+			assert.True(t, icsdiff.Equal(want, got), "%v", icsdiff.Diff(want, got))
+		})
+	}
+}
+
+func TestXPropertiesSurviveReports(t *testing.T) {
+	for _, name := range xpropSamples {
+		t.Run(name, func(t *testing.T) {
+			h := newXPropHandler(t)
+			data, want := readXPropSample(t, name)
+			w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/"+name+".ics", data, map[string]string{"Content-Type": "text/calendar"})
+			require.Less(t, w.Code, 300, w.Body.String())
+
+			query := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><C:calendar-data/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"/></C:filter>
+</C:calendar-query>`
+			w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", query, map[string]string{"Depth": "1"})
+			require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+			got := decodeComponents(t, reportedData(t, w.Body.String()))
+			assert.True(t, icsdiff.Equal(want, got), "calendar-query: %v", icsdiff.Diff(want, got))
+
+			multiget := `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><C:calendar-data/></D:prop>
+  <D:href>/caldav/alice/cal/work/` + name + `.ics</D:href>
+</C:calendar-multiget>`
+			w = serveAlice(h, "REPORT", "/caldav/alice/cal/work/", multiget, map[string]string{"Depth": "1"})
+			require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+			got = decodeComponents(t, reportedData(t, w.Body.String()))
+			assert.True(t, icsdiff.Equal(want, got), "calendar-multiget: %v", icsdiff.Diff(want, got))
+		})
+	}
+}
+
+func TestXPropertiesFilter(t *testing.T) {
+	h := newXPropHandler(t)
+	for _, name := range xpropSamples {
+		data, _ := readXPropSample(t, name)
+		w := serveAlice(h, http.MethodPut, "/caldav/alice/cal/work/"+name+".ics", data, map[string]string{"Content-Type": "text/calendar"})
+		require.Less(t, w.Code, 300, w.Body.String())
+	}
+	query := func(filter string) string {
+		body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR">` + filter + `</C:comp-filter></C:filter>
+</C:calendar-query>`
+		w := serveAlice(h, "REPORT", "/caldav/alice/cal/work/", body, map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+
+	body := query(`<C:comp-filter name="VEVENT"><C:prop-filter name="X-APPLE-STRUCTURED-LOCATION"/></C:comp-filter>`)
+	assert.Contains(t, body, "apple.ics")
+	assert.NotContains(t, body, "google.ics")
+
+	body = query(`<C:comp-filter name="VEVENT"><C:prop-filter name="X-GOOGLE-CONFERENCE"><C:text-match>meet.google.com</C:text-match></C:prop-filter></C:comp-filter>`)
+	assert.Contains(t, body, "google.ics")
+	assert.NotContains(t, body, "apple.ics")
+
+	body = query(`<C:comp-filter name="VPOLL"><C:prop-filter name="X-POLL-CLIENT-STATE"><C:text-match>open</C:text-match></C:prop-filter></C:comp-filter>`)
+	assert.Contains(t, body, "vpoll.ics")
+	assert.NotContains(t, body, "apple.ics")
+}